@@ -0,0 +1,112 @@
+// Command loadgen drives a steady rate of persona-driven payments at the
+// PayFlow API, for watching dashboards, alerts and autoscaling under load.
+// Its shape is set from the environment:
+//
+//	LOADGEN_RPS           payments per second (default 5)
+//	LOADGEN_DURATION_SEC  how long to run (default 60)
+//	LOADGEN_PERSONAS      comma-separated personas to draw from; all by default
+//
+// Failed requests are counted rather than stopping the run, which fails
+// only if none got through. The API is reached as described in package
+// batchjob, and the run's metrics are pushed to PUSHGATEWAY_URL when it is
+// set; PUSHGATEWAY_INTERVAL_SEC shows a long run while it is going.
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/infrasage/payflow/internal/batchjob"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var requestDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "payflow_loadgen_request_duration_seconds",
+		Help:    "Latency of the payments loadgen posted, by outcome",
+		Buckets: prometheus.DefBuckets,
+	},
+	[]string{"result"},
+)
+
+func main() {
+	rps := batchjob.EnvFloat("LOADGEN_RPS", 5)
+	duration := batchjob.EnvInt("LOADGEN_DURATION_SEC", 60)
+	if rps <= 0 || duration < 1 {
+		log.Fatalf("loadgen: LOADGEN_RPS must be above 0 and LOADGEN_DURATION_SEC at least 1")
+	}
+	personas := os.Getenv("LOADGEN_PERSONAS")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	run := batchjob.Start("loadgen")
+	run.Registry.MustRegister(requestDuration)
+	log.Printf("loadgen: %.1f payments/s for %ds", rps, duration)
+	err := generate(ctx, batchjob.NewClient(), run, rps, time.Duration(duration)*time.Second, personas)
+	if pushErr := run.Finish(err); pushErr != nil {
+		log.Printf("loadgen: push to Pushgateway failed: %v", pushErr)
+	}
+	if err != nil {
+		log.Fatalf("loadgen: %v", err)
+	}
+}
+
+// generate posts payments at rps until duration is up or ctx is done,
+// drawing them from a pool of samples fetched up front.
+func generate(ctx context.Context, client *batchjob.Client, run *batchjob.Run, rps float64, duration time.Duration, personas string) error {
+	pool, err := client.SamplePayments(ctx, 100, personas)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / rps))
+	defer ticker.Stop()
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		ok, bad int
+	)
+	for i := 0; ; i++ {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			log.Printf("loadgen: %d payments went through, %d errored", ok, bad)
+			if ok == 0 && bad > 0 {
+				return errors.New("no payment went through")
+			}
+			return nil
+		case <-ticker.C:
+		}
+		wg.Add(1)
+		go func(p batchjob.Payment) {
+			defer wg.Done()
+			start := time.Now()
+			status, err := client.Pay(ctx, p)
+			result := status
+			if err != nil {
+				if ctx.Err() != nil {
+					// Cut off by the end of the run, not the API.
+					return
+				}
+				result = "error"
+			}
+			requestDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+			run.Items.WithLabelValues(result).Inc()
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				bad++
+			} else {
+				ok++
+			}
+		}(pool[i%len(pool)])
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxTimeoutHang is how long a timed-out request hangs before it gives up
+// on its own. Clients are expected to time out well before that.
+const maxTimeoutHang = 60 * time.Second
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mockbank_requests_total",
+		Help: "Bank API requests by route and injected fault (none, failure, timeout)",
+	}, []string{"route", "fault"})
+	transfersTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mockbank_transfers_total",
+		Help: "Transfers booked, by type and status (accepted, rejected)",
+	}, []string{"type", "status"})
+)
+
+// Faults is how badly the bank behaves. Every API request waits LatencyMs,
+// give or take up to JitterMs, and then fails with a 503 at FailureRate.
+// A transfer that gets through is rejected at DeclineRate, and at
+// TimeoutRate it is booked but its response never comes, so a client only
+// learns the outcome by retrying with the same Idempotency-Key.
+type Faults struct {
+	LatencyMs   int     `json:"latency_ms"`
+	JitterMs    int     `json:"jitter_ms"`
+	FailureRate float64 `json:"failure_rate"`
+	TimeoutRate float64 `json:"timeout_rate"`
+	DeclineRate float64 `json:"decline_rate"`
+}
+
+func (f Faults) validate() error {
+	if f.LatencyMs < 0 || f.JitterMs < 0 {
+		return errors.New("latency and jitter must not be negative")
+	}
+	for _, rate := range []float64{f.FailureRate, f.TimeoutRate, f.DeclineRate} {
+		if rate < 0 || rate > 1 {
+			return errors.New("rates must be between 0 and 1")
+		}
+	}
+	return nil
+}
+
+// Transfer is money moved between PayFlow and an account at the bank.
+// Reference is the sender's own identifier for it.
+type Transfer struct {
+	ID        string    `json:"id"`
+	Reference string    `json:"reference"`
+	Type      string    `json:"type"`
+	Amount    float64   `json:"amount"`
+	Currency  string    `json:"currency"`
+	Account   string    `json:"account"`
+	Status    string    `json:"status"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type transferRequest struct {
+	Reference string  `json:"reference" binding:"required"`
+	Type      string  `json:"type"`
+	Amount    float64 `json:"amount" binding:"required,gt=0"`
+	Currency  string  `json:"currency" binding:"required,len=3"`
+	Account   string  `json:"account" binding:"required"`
+}
+
+// Bank holds the transfers booked since startup.
+type Bank struct {
+	mu        sync.Mutex
+	faults    Faults
+	transfers map[string]*Transfer
+	// byKey maps an Idempotency-Key to the transfer it booked.
+	byKey map[string]*Transfer
+}
+
+func newBank(faults Faults) *Bank {
+	return &Bank{faults: faults, transfers: map[string]*Transfer{}, byKey: map[string]*Transfer{}}
+}
+
+func (b *Bank) currentFaults() Faults {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.faults
+}
+
+// faultMiddleware applies the latency and failure rate to API requests.
+func (b *Bank) faultMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		f := b.currentFaults()
+		delay := time.Duration(f.LatencyMs) * time.Millisecond
+		if f.JitterMs > 0 {
+			delay += time.Duration(rand.Intn(2*f.JitterMs+1)-f.JitterMs) * time.Millisecond
+		}
+		if delay > 0 {
+			time.Sleep(delay)
+		}
+		if rand.Float64() < f.FailureRate {
+			requestsTotal.WithLabelValues(c.FullPath(), "failure").Inc()
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "bank temporarily unavailable"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// hang holds a request until its client gives up, as a bank that stopped
+// answering would.
+func hang(c *gin.Context) {
+	select {
+	case <-c.Request.Context().Done():
+	case <-time.After(maxTimeoutHang):
+	}
+	c.AbortWithStatusJSON(http.StatusGatewayTimeout, gin.H{"error": "bank timed out"})
+}
+
+// createTransferHandler books a transfer. A repeated Idempotency-Key
+// returns the transfer it booked the first time, with a 200, or a 409 when
+// the request differs.
+func (b *Bank) createTransferHandler(c *gin.Context) {
+	var req transferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		requestsTotal.WithLabelValues(c.FullPath(), "none").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Type == "" {
+		req.Type = "transfer"
+	}
+	key := c.GetHeader("Idempotency-Key")
+	f := b.currentFaults()
+
+	b.mu.Lock()
+	if existing, ok := b.byKey[key]; key != "" && ok {
+		b.mu.Unlock()
+		requestsTotal.WithLabelValues(c.FullPath(), "none").Inc()
+		if existing.Reference != req.Reference || existing.Amount != req.Amount || existing.Currency != req.Currency || existing.Account != req.Account {
+			c.JSON(http.StatusConflict, gin.H{"error": "Idempotency-Key was used for a different transfer"})
+			return
+		}
+		c.JSON(http.StatusOK, existing)
+		return
+	}
+	t := &Transfer{
+		ID:        uuid.New().String(),
+		Reference: req.Reference,
+		Type:      req.Type,
+		Amount:    req.Amount,
+		Currency:  req.Currency,
+		Account:   req.Account,
+		Status:    "accepted",
+		CreatedAt: time.Now().UTC(),
+	}
+	if rand.Float64() < f.DeclineRate {
+		t.Status, t.Reason = "rejected", "declined by bank"
+	}
+	b.transfers[t.ID] = t
+	if key != "" {
+		b.byKey[key] = t
+	}
+	b.mu.Unlock()
+	transfersTotal.WithLabelValues(t.Type, t.Status).Inc()
+
+	if rand.Float64() < f.TimeoutRate {
+		requestsTotal.WithLabelValues(c.FullPath(), "timeout").Inc()
+		hang(c)
+		return
+	}
+	requestsTotal.WithLabelValues(c.FullPath(), "none").Inc()
+	c.JSON(http.StatusCreated, t)
+}
+
+func (b *Bank) getTransferHandler(c *gin.Context) {
+	requestsTotal.WithLabelValues(c.FullPath(), "none").Inc()
+	b.mu.Lock()
+	t, ok := b.transfers[c.Param("id")]
+	b.mu.Unlock()
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transfer not found"})
+		return
+	}
+	c.JSON(http.StatusOK, t)
+}
+
+func (b *Bank) getFaultsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, b.currentFaults())
+}
+
+// setFaultsHandler replaces the faults. Fields left out of the body keep
+// their current values.
+func (b *Bank) setFaultsHandler(c *gin.Context) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	f := b.faults
+	if err := c.ShouldBindJSON(&f); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := f.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	b.faults = f
+	c.JSON(http.StatusOK, f)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func send(ctx context.Context, h http.Handler, method, path, key string, body interface{}) *httptest.ResponseRecorder {
+	raw, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, path, bytes.NewReader(raw)).WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestTransferIdempotency(t *testing.T) {
+	r := newBank(Faults{}).newRouter()
+	ctx := context.Background()
+	transfer := map[string]interface{}{"reference": "settlement-2026-03-01", "type": "settlement", "amount": 125.5, "currency": "USD", "account": "SETTLE-1"}
+
+	w := send(ctx, r, http.MethodPost, "/v1/transfers", "key-1", transfer)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("first transfer: %d %s", w.Code, w.Body)
+	}
+	var booked Transfer
+	json.Unmarshal(w.Body.Bytes(), &booked)
+	if booked.ID == "" || booked.Status != "accepted" {
+		t.Fatalf("booked %+v", booked)
+	}
+
+	w = send(ctx, r, http.MethodPost, "/v1/transfers", "key-1", transfer)
+	var again Transfer
+	json.Unmarshal(w.Body.Bytes(), &again)
+	if w.Code != http.StatusOK || again.ID != booked.ID {
+		t.Errorf("repeated key: %d %s, want 200 with transfer %s", w.Code, w.Body, booked.ID)
+	}
+	transfer["amount"] = 200.0
+	if w := send(ctx, r, http.MethodPost, "/v1/transfers", "key-1", transfer); w.Code != http.StatusConflict {
+		t.Errorf("repeated key for another amount: %d %s, want 409", w.Code, w.Body)
+	}
+	if w := send(ctx, r, http.MethodPost, "/v1/transfers", "key-2", transfer); w.Code != http.StatusCreated {
+		t.Errorf("new key: %d %s", w.Code, w.Body)
+	}
+	if w := send(ctx, r, http.MethodGet, "/v1/transfers/"+booked.ID, "", nil); w.Code != http.StatusOK {
+		t.Errorf("get transfer: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	bank := newBank(Faults{})
+	r := bank.newRouter()
+	ctx := context.Background()
+	transfer := map[string]interface{}{"reference": "r-1", "amount": 10, "currency": "USD", "account": "ACC-1"}
+
+	if w := send(ctx, r, http.MethodPut, "/admin/faults", "", map[string]interface{}{"failure_rate": 1.5}); w.Code != http.StatusBadRequest {
+		t.Errorf("failure rate 1.5: %d %s", w.Code, w.Body)
+	}
+	if w := send(ctx, r, http.MethodPut, "/admin/faults", "", map[string]interface{}{"failure_rate": 1}); w.Code != http.StatusOK {
+		t.Fatalf("set faults: %d %s", w.Code, w.Body)
+	}
+	if w := send(ctx, r, http.MethodPost, "/v1/transfers", "key-1", transfer); w.Code != http.StatusServiceUnavailable || len(bank.transfers) != 0 {
+		t.Errorf("with failure rate 1: %d, %d booked; want a 503 and nothing booked", w.Code, len(bank.transfers))
+	}
+
+	send(ctx, r, http.MethodPut, "/admin/faults", "", map[string]interface{}{"failure_rate": 0, "decline_rate": 1})
+	w := send(ctx, r, http.MethodPost, "/v1/transfers", "key-1", transfer)
+	var booked Transfer
+	json.Unmarshal(w.Body.Bytes(), &booked)
+	if w.Code != http.StatusCreated || booked.Status != "rejected" || booked.Reason == "" {
+		t.Errorf("with decline rate 1: %d %s, want a rejected transfer", w.Code, w.Body)
+	}
+
+	// A timed-out transfer is booked all the same; retrying its key returns
+	// it.
+	send(ctx, r, http.MethodPut, "/admin/faults", "", map[string]interface{}{"decline_rate": 0, "timeout_rate": 1})
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if w := send(timeout, r, http.MethodPost, "/v1/transfers", "key-2", transfer); w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("with timeout rate 1: %d %s, want 504", w.Code, w.Body)
+	}
+	w = send(ctx, r, http.MethodPost, "/v1/transfers", "key-2", transfer)
+	json.Unmarshal(w.Body.Bytes(), &booked)
+	if w.Code != http.StatusOK || booked.Status != "accepted" {
+		t.Errorf("retry after a timeout: %d %s, want the transfer booked the first time", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,82 @@
+// Command mockbank simulates the external bank PayFlow settles with, so the
+// whole money-movement chain can run and be broken locally. Transfers are
+// kept in memory; latency and failures are set from the environment and
+// changed at runtime through /admin/faults.
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func main() {
+	faults := Faults{
+		LatencyMs:   envInt("MOCKBANK_LATENCY_MS", 50),
+		JitterMs:    envInt("MOCKBANK_JITTER_MS", 25),
+		FailureRate: envFloat("MOCKBANK_FAILURE_RATE", 0),
+		TimeoutRate: envFloat("MOCKBANK_TIMEOUT_RATE", 0),
+		DeclineRate: envFloat("MOCKBANK_DECLINE_RATE", 0),
+	}
+	if err := faults.validate(); err != nil {
+		log.Fatalf("mockbank: %v", err)
+	}
+	bank := newBank(faults)
+	prometheus.MustRegister(requestsTotal, transfersTotal)
+
+	gin.SetMode(gin.ReleaseMode)
+	addr := ":" + envString("PORT", "8090")
+	log.Printf("mockbank listening on %s (latency %dms ±%dms, failure rate %.2f, timeout rate %.2f, decline rate %.2f)",
+		addr, faults.LatencyMs, faults.JitterMs, faults.FailureRate, faults.TimeoutRate, faults.DeclineRate)
+	log.Fatal(bank.newRouter().Run(addr))
+}
+
+func (b *Bank) newRouter() *gin.Engine {
+	r := gin.New()
+	r.Use(gin.Recovery())
+	r.GET("/health", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"status": "healthy"}) })
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	r.GET("/admin/faults", b.getFaultsHandler)
+	r.PUT("/admin/faults", b.setFaultsHandler)
+
+	v1 := r.Group("/v1", b.faultMiddleware())
+	v1.POST("/transfers", b.createTransferHandler)
+	v1.GET("/transfers/:id", b.getTransferHandler)
+	return r
+}
+
+func envString(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func envInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Fatalf("mockbank: %s must be an integer, got %q", key, v)
+	}
+	return n
+}
+
+func envFloat(key string, def float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Fatalf("mockbank: %s must be a number, got %q", key, v)
+	}
+	return f
+}
@@ -0,0 +1,58 @@
+// Command seed posts persona-driven sample payments through the PayFlow API,
+// the way a demo would be filled from outside the server. How many and
+// which personas are set from the environment:
+//
+//	SEED_COUNT     payments to post (default 50)
+//	SEED_PERSONAS  comma-separated personas to draw from; all by default
+//
+// The API is reached as described in package batchjob, and the run's
+// metrics are pushed to PUSHGATEWAY_URL when it is set.
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/infrasage/payflow/internal/batchjob"
+)
+
+func main() {
+	count := batchjob.EnvInt("SEED_COUNT", 50)
+	if count < 1 {
+		log.Fatalf("seed: SEED_COUNT must be at least 1, got %d", count)
+	}
+	personas := os.Getenv("SEED_PERSONAS")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	run := batchjob.Start("seed")
+	err := seed(ctx, batchjob.NewClient(), run, count, personas)
+	if pushErr := run.Finish(err); pushErr != nil {
+		log.Printf("seed: push to Pushgateway failed: %v", pushErr)
+	}
+	if err != nil {
+		log.Fatalf("seed: %v", err)
+	}
+}
+
+// seed posts count sample payments, counting them in run.Items by the
+// status each came back with. It stops at the first request that errors.
+func seed(ctx context.Context, client *batchjob.Client, run *batchjob.Run, count int, personas string) error {
+	payments, err := client.SamplePayments(ctx, count, personas)
+	if err != nil {
+		return err
+	}
+	for _, p := range payments {
+		status, err := client.Pay(ctx, p)
+		if err != nil {
+			run.Items.WithLabelValues("error").Inc()
+			return err
+		}
+		run.Items.WithLabelValues(status).Inc()
+	}
+	log.Printf("seed: posted %d payments", len(payments))
+	return nil
+}
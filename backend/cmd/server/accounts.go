@@ -0,0 +1,439 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	apihttp "github.com/infrasage/payflow/internal/http"
+)
+
+// Account is a balance-holding account. Transactions touching an account
+// that isn't registered here are treated as external: they move money in or
+// out without any balance check. An account with a ParentID is a
+// sub-account, such as one store of a merchant; sub-accounts can't have
+// sub-accounts of their own. Accounts opened in a demo session, such as a
+// tenant's sample accounts, are only seen from that session. An account
+// with an Email is sent receipts when RECEIPT_EMAILS is on, until mail to it
+// bounces; EmailBouncedAt is cleared when the address is changed.
+type Account struct {
+	ID             string     `json:"id"`
+	Name           string     `json:"name"`
+	Balance        float64    `json:"balance"`
+	ParentID       string     `json:"parent_id,omitempty"`
+	Email          string     `json:"email,omitempty"`
+	EmailBouncedAt *time.Time `json:"email_bounced_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// AccountRollup totals an account together with its sub-accounts. Inflow
+// and outflow only count settled money crossing the family's boundary;
+// transfers between its members are counted separately as internal.
+type AccountRollup struct {
+	Account
+	SubAccounts       int     `json:"sub_accounts"`
+	TotalBalance      float64 `json:"total_balance"`
+	Transactions      int     `json:"transactions"`
+	Inflow            float64 `json:"inflow"`
+	Outflow           float64 `json:"outflow"`
+	InternalTransfers int     `json:"internal_transfers"`
+	InternalVolume    float64 `json:"internal_volume"`
+}
+
+// postBalances debits and credits the registered accounts on each side of
+// txn inside tx, and declines txn when the payer can't cover it. A transfer
+// between accounts of one parent is marked internal. Row locks are taken
+// payer first; callers already hold the ledger lock, or the session's lock
+// for demo session transactions, so concurrent transfers can't deadlock on
+// them. Only accounts of txn's session are posted to: live accounts for
+// live transactions, the session's own for a demo session's.
+func postBalances(ctx context.Context, tx *sql.Tx, txn *Transaction) error {
+	var balance float64
+	var payerRoot, payeeRoot string
+	err := tx.QueryRowContext(ctx, `SELECT balance, COALESCE(parent_id, id) FROM accounts WHERE id = $1 AND session_id IS NOT DISTINCT FROM $2 FOR UPDATE`, txn.FromAccount, sessionArg(txn.SessionID)).Scan(&balance, &payerRoot)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return fmt.Errorf("failed to read balance: %w", err)
+	case balance < txn.Amount:
+		txn.Status = "failed"
+		return nil
+	default:
+		if _, err := tx.ExecContext(ctx, `UPDATE accounts SET balance = balance - $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1`, txn.FromAccount, txn.Amount); err != nil {
+			return fmt.Errorf("failed to debit account: %w", err)
+		}
+	}
+	err = tx.QueryRowContext(ctx, `
+		UPDATE accounts SET balance = balance + $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND session_id IS NOT DISTINCT FROM $3
+		RETURNING COALESCE(parent_id, id)
+	`, txn.ToAccount, txn.Amount, sessionArg(txn.SessionID)).Scan(&payeeRoot)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to credit account: %w", err)
+	}
+	txn.Internal = payerRoot != "" && payerRoot == payeeRoot
+	return nil
+}
+
+// errReversalUncovered is returned by reverseBalances when the payee of a
+// posted transaction no longer holds enough to give the money back.
+var errReversalUncovered = errors.New("payee balance does not cover the reversal")
+
+// reverseBalances undoes the postings postBalances made for a settled txn
+// inside tx: the payee is debited and the payer credited. Callers hold the
+// same lock as for postBalances, so taking the payee's row lock first can't
+// deadlock against a concurrent posting.
+func reverseBalances(ctx context.Context, tx *sql.Tx, txn Transaction) error {
+	var balance float64
+	err := tx.QueryRowContext(ctx, `SELECT balance FROM accounts WHERE id = $1 AND session_id IS NOT DISTINCT FROM $2 FOR UPDATE`, txn.ToAccount, sessionArg(txn.SessionID)).Scan(&balance)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return fmt.Errorf("failed to read balance: %w", err)
+	case balance < txn.Amount:
+		return errReversalUncovered
+	default:
+		if _, err := tx.ExecContext(ctx, `UPDATE accounts SET balance = balance - $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1`, txn.ToAccount, txn.Amount); err != nil {
+			return fmt.Errorf("failed to debit account: %w", err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE accounts SET balance = balance + $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND session_id IS NOT DISTINCT FROM $3
+	`, txn.FromAccount, txn.Amount, sessionArg(txn.SessionID)); err != nil {
+		return fmt.Errorf("failed to credit account: %w", err)
+	}
+	return nil
+}
+
+func scanAccount(row interface{ Scan(...interface{}) error }) (Account, error) {
+	var a Account
+	var bounced sql.NullTime
+	err := row.Scan(&a.ID, &a.Name, &a.Balance, &a.ParentID, &a.Email, &bounced, &a.CreatedAt, &a.UpdatedAt)
+	if bounced.Valid {
+		a.EmailBouncedAt = &bounced.Time
+	}
+	return a, err
+}
+
+const accountColumns = `id, name, balance, COALESCE(parent_id, ''), COALESCE(email, ''), email_bounced_at, created_at, updated_at`
+
+func (app *App) listAccountsHandler(c *gin.Context) {
+	fields, err := apihttp.FieldsParam(c, Account{})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	rows, err := app.readPool().QueryContext(c.Request.Context(), `
+		SELECT `+accountColumns+` FROM accounts WHERE session_id IS NOT DISTINCT FROM $1 ORDER BY id
+	`, sessionArg(sessionID(c)))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	accounts := []Account{}
+	for rows.Next() {
+		a, err := scanAccount(rows)
+		if err != nil {
+			continue
+		}
+		accounts = append(accounts, a)
+	}
+	c.JSON(http.StatusOK, fields.Apply(accounts))
+}
+
+func (app *App) getAccountHandler(c *gin.Context) {
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	id, ok := app.resolveParam(c, "id")
+	if !ok {
+		return
+	}
+	a, err := scanAccount(app.readPool().QueryRowContext(c.Request.Context(), `
+		SELECT `+accountColumns+` FROM accounts WHERE id = $1 AND session_id IS NOT DISTINCT FROM $2
+	`, id, sessionArg(sessionID(c))))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, a)
+}
+
+// createAccountHandler opens an account. opening_balance is the only way to
+// set a balance directly; afterwards it only changes through transactions.
+// It creates money outside the ledger, so only admins may set it.
+func (app *App) createAccountHandler(c *gin.Context) {
+	var req struct {
+		ID             string  `json:"id"`
+		Name           string  `json:"name"`
+		OpeningBalance float64 `json:"opening_balance"`
+		ParentID       string  `json:"parent_id"`
+		Email          string  `json:"email"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.OpeningBalance != 0 {
+		if !app.isAdminRequest(c) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "opening_balance requires admin access"})
+			return
+		}
+		if !wholeCents(req.OpeningBalance) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "opening_balance must have at most 2 decimal places"})
+			return
+		}
+	}
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+
+	id, err := app.vault.Tokenize(c.Request.Context(), app.db, req.ID)
+	if err != nil {
+		app.logCtx(c.Request.Context(), "error", "Failed to tokenize account", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Vault error"})
+		return
+	}
+	var parent sql.NullString
+	if req.ParentID != "" {
+		var ok bool
+		if parent.String, ok = app.checkParentAccount(c, req.ParentID); !ok {
+			return
+		}
+		parent.Valid = true
+	}
+	a, err := scanAccount(app.db.QueryRowContext(c.Request.Context(), `
+		INSERT INTO accounts (id, name, balance, parent_id, session_id, email) VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''))
+		ON CONFLICT (id) DO NOTHING
+		RETURNING `+accountColumns,
+		id, req.Name, req.OpeningBalance, parent, sessionArg(sessionID(c)), req.Email))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusConflict, gin.H{"error": "Account already exists"})
+		return
+	}
+	if err != nil {
+		app.logCtx(c.Request.Context(), "error", "Failed to create account", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	app.eventCtx(c.Request.Context(), "info", EventAccountOpened, a.ID, "Account opened", map[string]interface{}{"opening_balance": a.Balance, "parent_id": a.ParentID})
+	c.JSON(http.StatusCreated, a)
+}
+
+// checkParentAccount resolves the parent a new sub-account is opened under
+// and checks it is a top-level account, answering the request itself when
+// it isn't one.
+func (app *App) checkParentAccount(c *gin.Context, parentID string) (string, bool) {
+	id, err := app.vault.Resolve(c.Request.Context(), parentID)
+	if err != nil {
+		app.logCtx(c.Request.Context(), "error", "Token lookup failed", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Vault error"})
+		return "", false
+	}
+	var grandparent sql.NullString
+	err = app.db.QueryRowContext(c.Request.Context(), `
+		SELECT parent_id FROM accounts WHERE id = $1 AND session_id IS NOT DISTINCT FROM $2
+	`, id, sessionArg(sessionID(c))).Scan(&grandparent)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Parent account not found"})
+		return "", false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return "", false
+	}
+	if grandparent.Valid {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Parent account is itself a sub-account"})
+		return "", false
+	}
+	return id, true
+}
+
+func (app *App) listSubAccountsHandler(c *gin.Context) {
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	id, ok := app.resolveParam(c, "id")
+	if !ok {
+		return
+	}
+	rows, err := app.readPool().QueryContext(c.Request.Context(), `
+		SELECT `+accountColumns+` FROM accounts WHERE parent_id = $1 AND session_id IS NOT DISTINCT FROM $2 ORDER BY id
+	`, id, sessionArg(sessionID(c)))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	accounts := []Account{}
+	for rows.Next() {
+		a, err := scanAccount(rows)
+		if err != nil {
+			continue
+		}
+		accounts = append(accounts, a)
+	}
+	c.JSON(http.StatusOK, accounts)
+}
+
+// accountRollupHandler rolls an account's sub-accounts up into it: their
+// combined balance and the family's settled money flows.
+func (app *App) accountRollupHandler(c *gin.Context) {
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	id, ok := app.resolveParam(c, "id")
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	session := sessionArg(sessionID(c))
+	a, err := scanAccount(app.readPool().QueryRowContext(ctx, `
+		SELECT `+accountColumns+` FROM accounts WHERE id = $1 AND session_id IS NOT DISTINCT FROM $2
+	`, id, session))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	r := AccountRollup{Account: a}
+	err = app.readPool().QueryRowContext(ctx, `
+		SELECT COUNT(*) - 1, SUM(balance) FROM accounts WHERE id = $1 OR parent_id = $1
+	`, id).Scan(&r.SubAccounts, &r.TotalBalance)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	// Only the account's own session's traffic counts: live accounts see
+	// live transactions, a tenant's accounts its session's.
+	err = app.readPool().QueryRowContext(ctx, `
+		WITH family AS (SELECT id FROM accounts WHERE id = $1 OR parent_id = $1),
+		flows AS (
+			SELECT amount, from_account IN (SELECT id FROM family) AS outgoing, to_account IN (SELECT id FROM family) AS incoming
+			FROM transactions
+			WHERE status IN `+settledStatuses+` AND session_id IS NOT DISTINCT FROM $2
+				AND (from_account IN (SELECT id FROM family) OR to_account IN (SELECT id FROM family))
+		)
+		SELECT
+			COUNT(*) FILTER (WHERE NOT (outgoing AND incoming)),
+			COALESCE(SUM(amount) FILTER (WHERE incoming AND NOT outgoing), 0),
+			COALESCE(SUM(amount) FILTER (WHERE outgoing AND NOT incoming), 0),
+			COUNT(*) FILTER (WHERE outgoing AND incoming),
+			COALESCE(SUM(amount) FILTER (WHERE outgoing AND incoming), 0)
+		FROM flows
+	`, id, session).Scan(&r.Transactions, &r.Inflow, &r.Outflow, &r.InternalTransfers, &r.InternalVolume)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, r)
+}
+
+// updateAccountHandler renames an account or changes its email address.
+// Fields left out are kept; an empty email removes the address. Setting an
+// address clears any bounce recorded against the old one.
+func (app *App) updateAccountHandler(c *gin.Context) {
+	var req struct {
+		Name  *string `json:"name"`
+		Email *string `json:"email"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	id, ok := app.resolveParam(c, "id")
+	if !ok {
+		return
+	}
+	var name, email sql.NullString
+	if req.Name != nil {
+		name = sql.NullString{String: *req.Name, Valid: true}
+	}
+	if req.Email != nil {
+		email = sql.NullString{String: *req.Email, Valid: true}
+	}
+	a, err := scanAccount(app.db.QueryRowContext(c.Request.Context(), `
+		UPDATE accounts SET name = COALESCE($2, name),
+			email = CASE WHEN $4::text IS NULL THEN email ELSE NULLIF($4, '') END,
+			email_bounced_at = CASE WHEN $4::text IS NULL THEN email_bounced_at END,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND session_id IS NOT DISTINCT FROM $3
+		RETURNING `+accountColumns, id, name, sessionArg(sessionID(c)), email))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, a)
+}
+
+// deleteAccountHandler closes an account. Only empty accounts can be closed,
+// so money never disappears with one, and a parent only once its
+// sub-accounts are closed.
+func (app *App) deleteAccountHandler(c *gin.Context) {
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	id, ok := app.resolveParam(c, "id")
+	if !ok {
+		return
+	}
+	var balance float64
+	var subAccounts int
+	err := app.db.QueryRowContext(c.Request.Context(), `
+		WITH target AS (
+			SELECT id, balance, (SELECT COUNT(*) FROM accounts WHERE parent_id = $1) AS sub_accounts
+			FROM accounts WHERE id = $1 AND session_id IS NOT DISTINCT FROM $2
+		),
+		removed AS (DELETE FROM accounts WHERE id IN (SELECT id FROM target WHERE balance = 0 AND sub_accounts = 0))
+		SELECT balance, sub_accounts FROM target
+	`, id, sessionArg(sessionID(c))).Scan(&balance, &subAccounts)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if balance != 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Account has a non-zero balance", "balance": balance})
+		return
+	}
+	if subAccounts > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Account has sub-accounts", "sub_accounts": subAccounts})
+		return
+	}
+	app.eventCtx(c.Request.Context(), "info", EventAccountClosed, id, "Account closed", nil)
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/infrasage/payflow/internal/dbtest"
+)
+
+// fakeAccount is a row of fakeLedger's accounts table.
+type fakeAccount struct {
+	Balance float64
+	Parent  string
+	Session string
+}
+
+// fakeLedger stands in for the accounts, transactions and transaction_audit
+// tables, answering the statements the money-moving code sends. Changes
+// made inside a database transaction are undone when it rolls back. Any
+// other statement fails the query, so tests notice when the code changes.
+type fakeLedger struct {
+	mu       sync.Mutex
+	accounts map[string]fakeAccount
+	txns     map[string]Transaction
+	audit    []string
+	saved    *fakeLedger
+}
+
+func newFakeLedger() *fakeLedger {
+	return &fakeLedger{accounts: map[string]fakeAccount{}, txns: map[string]Transaction{}}
+}
+
+// open returns a database backed by l.
+func (l *fakeLedger) open(t *testing.T) *sql.DB {
+	t.Helper()
+	return dbtest.New(l.run).WithTx(dbtest.Tx{Begin: l.begin, Commit: l.commit, Rollback: l.rollback}).Open(t)
+}
+
+func (l *fakeLedger) balance(id string) float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.accounts[id].Balance
+}
+
+func (l *fakeLedger) status(id string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.txns[id].Status
+}
+
+func (l *fakeLedger) begin() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	saved := &fakeLedger{accounts: map[string]fakeAccount{}, txns: map[string]Transaction{}, audit: append([]string(nil), l.audit...)}
+	for k, v := range l.accounts {
+		saved.accounts[k] = v
+	}
+	for k, v := range l.txns {
+		saved.txns[k] = v
+	}
+	l.saved = saved
+}
+
+func (l *fakeLedger) commit() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.saved = nil
+}
+
+func (l *fakeLedger) rollback() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if s := l.saved; s != nil {
+		l.accounts, l.txns, l.audit, l.saved = s.accounts, s.txns, s.audit, nil
+	}
+}
+
+// cents rounds like the DECIMAL(15,2) balance column.
+func cents(v float64) float64 { return math.Round(v*100) / 100 }
+
+func (l *fakeLedger) run(q dbtest.Query) (*dbtest.Rows, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	none := dbtest.None()
+	switch {
+	case q.HasPrefix("SELECT pg_advisory_xact_lock("),
+		q.HasPrefix("SELECT hash FROM transactions WHERE hash IS NOT NULL"),
+		q.HasPrefix("UPDATE transactions SET status_hash"):
+		return none, nil
+
+	case q.HasPrefix("SELECT balance, COALESCE(parent_id, id) FROM accounts WHERE id = $1"),
+		q.HasPrefix("SELECT balance FROM accounts WHERE id = $1"):
+		a, ok := l.accounts[q.String(0)]
+		if !ok || a.Session != q.String(1) {
+			return none, nil
+		}
+		root := a.Parent
+		if root == "" {
+			root = q.String(0)
+		}
+		if q.HasPrefix("SELECT balance FROM") {
+			return dbtest.NewRows("balance").Add(a.Balance), nil
+		}
+		return dbtest.NewRows("balance", "root").Add(a.Balance, root), nil
+	case q.HasPrefix("UPDATE accounts SET balance = balance - $2"):
+		id := q.String(0)
+		a := l.accounts[id]
+		a.Balance = cents(a.Balance - q.Args[1].(float64))
+		l.accounts[id] = a
+		return none, nil
+	case q.HasPrefix("UPDATE accounts SET balance = balance + $2"):
+		id := q.String(0)
+		a, ok := l.accounts[id]
+		if !ok || a.Session != q.String(2) {
+			return none, nil
+		}
+		a.Balance = cents(a.Balance + q.Args[1].(float64))
+		l.accounts[id] = a
+		root := a.Parent
+		if root == "" {
+			root = id
+		}
+		return dbtest.NewRows("root").Add(root), nil
+
+	case q.HasPrefix("SELECT EXISTS (SELECT 1 FROM transactions WHERE id = $1)"):
+		_, ok := l.txns[q.String(0)]
+		return dbtest.NewRows("exists").Add(ok), nil
+	case q.HasPrefix("INSERT INTO transactions"):
+		t := Transaction{ID: q.String(0), FromAccount: q.String(1), ToAccount: q.String(2), Amount: q.Args[3].(float64),
+			Description: q.String(4), Status: q.String(5), Hash: q.String(8), SessionID: q.String(10),
+			RefundOf: q.String(12), Internal: q.Args[13].(bool)}
+		if _, ok := l.txns[t.ID]; !ok {
+			l.txns[t.ID] = t
+		}
+		return none, nil
+	case q.HasPrefix("SELECT id, from_account, to_account, amount, status, COALESCE(refund_of, '') FROM transactions WHERE id = $1"),
+		q.HasPrefix("SELECT id, from_account, to_account, amount, status, COALESCE(session_id, '') FROM transactions WHERE id = $1"):
+		t, ok := l.txns[q.String(0)]
+		if !ok || t.SessionID != q.String(1) {
+			return none, nil
+		}
+		last := t.RefundOf
+		if q.Contains("COALESCE(session_id") {
+			last = t.SessionID
+		}
+		return dbtest.NewRows("id", "from", "to", "amount", "status", "last").Add(t.ID, t.FromAccount, t.ToAccount, t.Amount, t.Status, last), nil
+	case q.HasPrefix("SELECT id, from_account, to_account, amount FROM transactions WHERE id = ANY($1) AND session_id IS NOT DISTINCT FROM $2 AND status = 'success' AND refund_of IS NULL"):
+		ids, rows := q.IDs(0), dbtest.NewRows("id", "from", "to", "amount")
+		for _, t := range l.txns {
+			if ids[t.ID] && t.SessionID == q.String(1) && t.Status == "success" && t.RefundOf == "" &&
+				t.FromAccount == q.String(2) && t.ToAccount == q.String(3) && t.Amount == q.Args[4].(float64) {
+				rows.Add(t.ID, t.FromAccount, t.ToAccount, t.Amount)
+			}
+		}
+		return rows, nil
+	case q.HasPrefix("SELECT COALESCE(SUM(amount), 0) FROM transactions WHERE refund_of = $1 AND status = 'success'"):
+		var sum float64
+		for _, t := range l.txns {
+			if t.RefundOf == q.String(0) && t.Status == "success" {
+				sum += t.Amount
+			}
+		}
+		return dbtest.NewRows("sum").Add(sum), nil
+	case q.HasPrefix("UPDATE transactions SET status = $2 WHERE id = $1"):
+		t := l.txns[q.String(0)]
+		t.Status = q.String(1)
+		l.txns[t.ID] = t
+		return none, nil
+	case q.HasPrefix("UPDATE transactions SET status = 'voided' WHERE id = ANY($1)"):
+		for id := range q.IDs(0) {
+			t := l.txns[id]
+			t.Status = "voided"
+			l.txns[id] = t
+		}
+		return none, nil
+	case q.HasPrefix("SELECT id, hash, status FROM transactions WHERE id = ANY($1) AND hash IS NOT NULL"):
+		ids, rows := q.IDs(0), dbtest.NewRows("id", "hash", "status")
+		for _, t := range l.txns {
+			if ids[t.ID] && t.Hash != "" {
+				rows.Add(t.ID, t.Hash, t.Status)
+			}
+		}
+		return rows, nil
+	case q.HasPrefix("SELECT id, COALESCE(session_id, ''), created_at FROM transactions WHERE status = 'held' AND created_at < $1"):
+		var held []Transaction
+		for _, t := range l.txns {
+			if t.Status == "held" && t.CreatedAt.Before(q.Args[0].(time.Time)) {
+				held = append(held, t)
+			}
+		}
+		sort.Slice(held, func(i, j int) bool { return held[i].CreatedAt.Before(held[j].CreatedAt) })
+		rows := dbtest.NewRows("id", "session", "created_at")
+		for i, t := range held {
+			if int64(i) == q.Args[1].(int64) {
+				break
+			}
+			rows.Add(t.ID, t.SessionID, t.CreatedAt)
+		}
+		return rows, nil
+	case q.HasPrefix("INSERT INTO transaction_audit"):
+		l.audit = append(l.audit, q.String(0)+" "+q.String(1))
+		return none, nil
+	}
+	return nil, dbtest.Unexpected(q)
+}
+
+func TestPostBalances(t *testing.T) {
+	tests := []struct {
+		name       string
+		txn        Transaction
+		wantStatus string
+		internal   bool
+		balances   map[string]float64
+	}{
+		{"settles", Transaction{FromAccount: "ACC-1", ToAccount: "MER-1", Amount: 40},
+			"success", false, map[string]float64{"ACC-1": 60, "MER-1": 40}},
+		{"insufficient funds", Transaction{FromAccount: "ACC-1", ToAccount: "MER-1", Amount: 100.01},
+			"failed", false, map[string]float64{"ACC-1": 100, "MER-1": 0}},
+		{"external payer", Transaction{FromAccount: "EXT-1", ToAccount: "MER-1", Amount: 500},
+			"success", false, map[string]float64{"MER-1": 500}},
+		{"internal transfer between sub-accounts", Transaction{FromAccount: "STORE-1", ToAccount: "STORE-2", Amount: 5},
+			"success", true, map[string]float64{"STORE-1": 5, "STORE-2": 15}},
+		{"transfer to the parent", Transaction{FromAccount: "STORE-1", ToAccount: "MER-1", Amount: 10},
+			"success", true, map[string]float64{"STORE-1": 0, "MER-1": 10}},
+		{"other session's accounts untouched", Transaction{FromAccount: "ACC-1", ToAccount: "MER-1", Amount: 1000, SessionID: "s1"},
+			"success", false, map[string]float64{"ACC-1": 100, "MER-1": 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newFakeLedger()
+			l.accounts["ACC-1"] = fakeAccount{Balance: 100}
+			l.accounts["MER-1"] = fakeAccount{}
+			l.accounts["STORE-1"] = fakeAccount{Balance: 10, Parent: "MER-1"}
+			l.accounts["STORE-2"] = fakeAccount{Balance: 10, Parent: "MER-1"}
+			db := l.open(t)
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			txn := tt.txn
+			txn.Status = "success"
+			if err := postBalances(context.Background(), tx, &txn); err != nil {
+				t.Fatal(err)
+			}
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			if txn.Status != tt.wantStatus || txn.Internal != tt.internal {
+				t.Errorf("status %q internal %v, want %q %v", txn.Status, txn.Internal, tt.wantStatus, tt.internal)
+			}
+			for id, want := range tt.balances {
+				if got := l.balance(id); got != want {
+					t.Errorf("%s balance = %v, want %v", id, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestReverseBalances(t *testing.T) {
+	l := newFakeLedger()
+	l.accounts["ACC-1"] = fakeAccount{Balance: 60}
+	l.accounts["MER-1"] = fakeAccount{Balance: 40}
+	db := l.open(t)
+	ctx := context.Background()
+	txn := Transaction{ID: "txn-1", FromAccount: "ACC-1", ToAccount: "MER-1", Amount: 40, Status: "success", CreatedAt: time.Now()}
+
+	tx, _ := db.Begin()
+	if err := reverseBalances(ctx, tx, txn); err != nil {
+		t.Fatal(err)
+	}
+	tx.Commit()
+	if l.balance("ACC-1") != 100 || l.balance("MER-1") != 0 {
+		t.Errorf("balances %v/%v after reversal, want 100/0", l.balance("ACC-1"), l.balance("MER-1"))
+	}
+
+	tx, _ = db.Begin()
+	if err := reverseBalances(ctx, tx, txn); err != errReversalUncovered {
+		t.Errorf("reversing again: %v, want errReversalUncovered", err)
+	}
+	tx.Rollback()
+	if l.balance("ACC-1") != 100 || l.balance("MER-1") != 0 {
+		t.Errorf("balances moved by an uncovered reversal")
+	}
+}
+
+func TestCreateAccountOpeningBalance(t *testing.T) {
+	h := newTestApp(t, func(c *Config) { c.AdminToken = "secret-token" }).newRouter()
+	admin := map[string]string{"X-Admin-Token": "secret-token"}
+	tests := []struct {
+		name    string
+		balance float64
+		headers map[string]string
+		want    int
+	}{
+		// Past the checks, opening fails for want of a database.
+		{"no balance", 0, nil, http.StatusServiceUnavailable},
+		{"balance without admin", 500, nil, http.StatusForbidden},
+		{"balance as admin", 500, admin, http.StatusServiceUnavailable},
+		{"fractional cents", 500.005, admin, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := map[string]interface{}{"id": "ACC-1001", "name": "Checking", "opening_balance": tt.balance}
+			if w := serve(h, http.MethodPost, "/api/accounts", body, tt.headers); w.Code != tt.want {
+				t.Errorf("%d %s, want %d", w.Code, w.Body, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestIsAdmin(t *testing.T) {
+	viewer := &Principal{Subject: "v", Roles: []string{"viewer"}, Source: "oauth"}
+	operator := &Principal{Subject: "o", Roles: []string{"operator"}, Source: "oauth"}
+	admin := &Principal{Subject: "a", Roles: []string{"admin"}, Source: "oauth"}
+	scoped := &Principal{Subject: "s", Scopes: []string{"admin"}, Source: "oauth"}
+	tenant := &Principal{Subject: "k", Scopes: tenantScopes, Source: "api_key", SessionID: "s1"}
+
+	tests := []struct {
+		name       string
+		adminToken string
+		p          *Principal
+		sent       string
+		want       bool
+	}{
+		{"open anonymous", "", nil, "", true},
+		{"open viewer", "", viewer, "", false},
+		{"open operator", "", operator, "", false},
+		{"open tenant key", "", tenant, "", false},
+		{"open admin role", "", admin, "", true},
+		{"open admin scope", "", scoped, "", true},
+		{"token anonymous", "secret-token", nil, "", false},
+		{"token anonymous valid", "secret-token", nil, "secret-token", true},
+		{"token viewer", "secret-token", viewer, "", false},
+		{"token viewer wrong", "secret-token", viewer, "guess", false},
+		{"token admin role", "secret-token", admin, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t, func(c *Config) { c.AdminToken = tt.adminToken })
+			if got := app.isAdmin(tt.p, tt.sent); got != tt.want {
+				t.Errorf("isAdmin = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Tenant keys manage webhooks only when the admin granted it.
+func TestTenantKeyWebhookScope(t *testing.T) {
+	for _, granted := range []bool{false, true} {
+		p := &Principal{Subject: "k", Scopes: tenantKeyScopes(granted), Source: "api_key", SessionID: "s1"}
+		r := gin.New()
+		r.POST("/api/webhooks", func(c *gin.Context) { c.Set(principalContextKey, p) }, requireScope("webhooks:manage"), func(c *gin.Context) {
+			c.Status(http.StatusCreated)
+		})
+		want := http.StatusForbidden
+		if granted {
+			want = http.StatusCreated
+		}
+		if w := serve(r, http.MethodPost, "/api/webhooks", nil, nil); w.Code != want {
+			t.Errorf("webhooks granted %v: %d, want %d", granted, w.Code, want)
+		}
+	}
+}
+
+// A viewer token must not reach admin routes when neither ADMIN_TOKEN nor
+// OIDC is set, while anonymous callers still do.
+func TestAdminRoutesRejectViewerToken(t *testing.T) {
+	app := newTestApp(t, func(c *Config) { c.DemoTokensEnabled = true })
+	r := app.newRouter()
+
+	tokens := map[string]string{}
+	for _, role := range []string{"viewer", "admin"} {
+		w := serve(r, http.MethodPost, "/oauth/demo-token", map[string]string{"subject": role + "-user", "role": role}, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("demo token for %s: %d %s", role, w.Code, w.Body)
+		}
+		var resp struct {
+			AccessToken string `json:"access_token"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		tokens[role] = "Bearer " + resp.AccessToken
+	}
+
+	viewer := map[string]string{"Authorization": tokens["viewer"]}
+	if w := serve(r, http.MethodGet, "/api/admin/chaos", nil, viewer); w.Code != http.StatusUnauthorized {
+		t.Errorf("viewer GET /api/admin/chaos = %d, want 401", w.Code)
+	}
+	if w := serve(r, http.MethodPut, "/api/admin/chaos", map[string]int{"inject_latency_ms": 5}, viewer); w.Code != http.StatusUnauthorized {
+		t.Errorf("viewer PUT /api/admin/chaos = %d, want 401", w.Code)
+	}
+	if got := app.liveConfig().InjectLatencyMs; got != 0 {
+		t.Errorf("viewer changed inject_latency_ms to %d", got)
+	}
+
+	admin := map[string]string{"Authorization": tokens["admin"]}
+	if w := serve(r, http.MethodGet, "/api/admin/chaos", nil, admin); w.Code != http.StatusOK {
+		t.Errorf("admin GET /api/admin/chaos = %d, want 200", w.Code)
+	}
+	if w := serve(r, http.MethodGet, "/api/admin/chaos", nil, nil); w.Code != http.StatusOK {
+		t.Errorf("anonymous GET /api/admin/chaos = %d, want 200", w.Code)
+	}
+}
+
+// Fraud analysts get fraud actions, but nothing else admins can do.
+func TestAuthorizeAdminFraudAnalyst(t *testing.T) {
+	app := newTestApp(t, func(c *Config) { c.AdminToken = "secret-token" })
+	analyst := &Principal{Subject: "a", Roles: []string{"fraud_analyst"}, Source: "oidc"}
+	triage := &Principal{Subject: "svc", Scopes: []string{"fraud:triage"}, Source: "oauth"}
+	viewer := &Principal{Subject: "v", Roles: []string{"viewer"}, Source: "oidc"}
+	tests := []struct {
+		name      string
+		action    string
+		principal *Principal
+		want      bool
+	}{
+		{"analyst fraud", "fraud", analyst, true},
+		{"analyst admin", "admin", analyst, false},
+		{"triage scope fraud", "fraud", triage, true},
+		{"triage scope admin", "admin", triage, false},
+		{"viewer fraud", "fraud", viewer, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := app.authorizeAdmin(context.Background(), tt.action, "GET", "/api/admin/fraud/rules", tt.principal, ""); got != tt.want {
+				t.Errorf("authorizeAdmin = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFraudAnalystRoutes(t *testing.T) {
+	app := newTestApp(t, func(c *Config) { c.DemoTokensEnabled = true })
+	r := app.newRouter()
+	w := serve(r, http.MethodPost, "/oauth/demo-token", map[string]string{"subject": "analyst", "role": "fraud_analyst"}, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("demo token: %d %s", w.Code, w.Body)
+	}
+	var resp struct {
+		AccessToken string `json:"access_token"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	analyst := map[string]string{"Authorization": "Bearer " + resp.AccessToken}
+
+	// No database here, so routes the analyst may use answer 503.
+	for _, path := range []string{"/api/fraud/alerts", "/api/admin/fraud/summaries"} {
+		if w := serve(r, http.MethodGet, path, nil, analyst); w.Code != http.StatusServiceUnavailable {
+			t.Errorf("analyst GET %s = %d %s, want 503", path, w.Code, w.Body)
+		}
+	}
+	if w := serve(r, http.MethodGet, "/api/admin/chaos", nil, analyst); w.Code != http.StatusUnauthorized {
+		t.Errorf("analyst GET /api/admin/chaos = %d, want 401", w.Code)
+	}
+}
+
+func TestCORSPreflightAllowsPatch(t *testing.T) {
+	r := newTestApp(t, nil).newRouter()
+	w := serve(r, http.MethodOptions, "/api/accounts/ACC-1", nil, map[string]string{
+		"Origin":                        "http://dashboard.example",
+		"Access-Control-Request-Method": "PATCH",
+	})
+	if w.Code != http.StatusNoContent || !strings.Contains(w.Header().Get("Access-Control-Allow-Methods"), "PATCH") {
+		t.Errorf("preflight got %d, allowed methods %q", w.Code, w.Header().Get("Access-Control-Allow-Methods"))
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// amountBuckets are the upper bounds of the amount buckets, in major currency
+// units, shared by the histogram and the distribution endpoint.
+var amountBuckets = []float64{10, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// roundAmountMultiple is the multiple an amount must be to count as round.
+// Floods of round amounts are a common testing pattern for stolen cards.
+const roundAmountMultiple = 100
+
+var transactionAmount = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "payflow_transaction_amount",
+		Help:    "Amounts of created transactions in major currency units",
+		Buckets: amountBuckets,
+	},
+	[]string{"status"},
+)
+
+// AmountBucket counts transactions with Min <= amount < Max. Max is nil for
+// the last, open-ended bucket.
+type AmountBucket struct {
+	Min   float64  `json:"min"`
+	Max   *float64 `json:"max"`
+	Count int      `json:"count"`
+	Round int      `json:"round"`
+}
+
+// amountDistributionHandler buckets the session's payments (refunds excluded)
+// by amount, optionally limited to the last window_sec seconds. round counts
+// amounts that are whole multiples of roundAmountMultiple, and round_share is
+// their share of all payments.
+func (app *App) amountDistributionHandler(c *gin.Context) {
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	window, err := strconv.Atoi(c.DefaultQuery("window_sec", "0"))
+	if err != nil || window < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "window_sec must be a non-negative integer"})
+		return
+	}
+
+	rows, err := app.readPool().QueryContext(c.Request.Context(), `
+		SELECT width_bucket(amount, $1::numeric[]) AS bucket,
+		       COUNT(*),
+		       COUNT(*) FILTER (WHERE MOD(amount, $2) = 0)
+		FROM transactions
+		WHERE refund_of IS NULL
+		  AND session_id IS NOT DISTINCT FROM $3
+		  AND ($4 = 0 OR created_at >= NOW() - make_interval(secs => $4))
+		GROUP BY bucket
+	`, pq.Array(amountBuckets), roundAmountMultiple, sessionArg(sessionID(c)), window)
+	if err != nil {
+		app.logCtx(c.Request.Context(), "error", "Failed to compute amount distribution", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	buckets := make([]AmountBucket, len(amountBuckets)+1)
+	for i := range buckets {
+		if i > 0 {
+			buckets[i].Min = amountBuckets[i-1]
+		}
+		if i < len(amountBuckets) {
+			buckets[i].Max = &amountBuckets[i]
+		}
+	}
+	var total, round int
+	for rows.Next() {
+		var i, count, roundCount int
+		if err := rows.Scan(&i, &count, &roundCount); err != nil {
+			continue
+		}
+		buckets[i].Count = count
+		buckets[i].Round = roundCount
+		total += count
+		round += roundCount
+	}
+
+	roundShare := float64(0)
+	if total > 0 {
+		roundShare = float64(round) / float64(total)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"currency":    app.config.Currency,
+		"window_sec":  window,
+		"total":       total,
+		"round_share": roundShare,
+		"buckets":     buckets,
+	})
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	anomalyScore = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "payflow_anomaly_score",
+			Help: "Z-score of the latest window against its EWMA baseline",
+		},
+		[]string{"metric"},
+	)
+	anomalyActive = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "payflow_anomaly_active",
+			Help: "1 when the latest window for a business metric is anomalous",
+		},
+		[]string{"metric"},
+	)
+)
+
+// ewma tracks an exponentially weighted mean and variance.
+type ewma struct {
+	mean     float64
+	variance float64
+	samples  int
+}
+
+// observe returns the z-score of x against the baseline seen so far and then
+// folds x into the baseline.
+func (e *ewma) observe(x, alpha float64) float64 {
+	if e.samples == 0 {
+		e.mean = x
+		e.samples = 1
+		return 0
+	}
+	z := 0.0
+	if std := math.Sqrt(e.variance); std > 0 {
+		z = (x - e.mean) / std
+	}
+	diff := x - e.mean
+	incr := alpha * diff
+	e.mean += incr
+	e.variance = (1 - alpha) * (e.variance + diff*incr)
+	e.samples++
+	return z
+}
+
+// AnomalyDetector aggregates business metrics per window and compares each
+// window to an EWMA baseline of previous windows.
+type AnomalyDetector struct {
+	mu        sync.Mutex
+	count     int
+	failed    int
+	amountSum float64
+	baselines map[string]*ewma
+}
+
+func newAnomalyDetector() *AnomalyDetector {
+	return &AnomalyDetector{baselines: map[string]*ewma{
+		"transaction_volume": {},
+		"failure_rate":       {},
+		"average_amount":     {},
+	}}
+}
+
+// Record adds a processed transaction to the current window.
+func (d *AnomalyDetector) Record(txn Transaction) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.count++
+	if txn.Status == "failed" {
+		d.failed++
+	}
+	d.amountSum += txn.Amount
+}
+
+// flush closes the current window and returns its values.
+func (d *AnomalyDetector) flush() map[string]float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	values := map[string]float64{"transaction_volume": float64(d.count)}
+	if d.count > 0 {
+		values["failure_rate"] = float64(d.failed) / float64(d.count)
+		values["average_amount"] = d.amountSum / float64(d.count)
+	}
+	d.count, d.failed, d.amountSum = 0, 0, 0
+	return values
+}
+
+func (app *App) startAnomalyDetector() {
+	window := time.Duration(app.config.AnomalyWindowSec) * time.Second
+	go func() {
+		for {
+			time.Sleep(window)
+			app.checkAnomalies()
+		}
+	}()
+}
+
+// checkAnomalies closes the current window, scores each metric against its
+// baseline and reports those past the threshold once the baseline is warm.
+func (app *App) checkAnomalies() {
+	for metric, value := range app.anomalies.flush() {
+		baseline := app.anomalies.baselines[metric]
+		expected := baseline.mean
+		warm := baseline.samples >= app.config.AnomalyWarmupWindows
+		z := baseline.observe(value, app.config.AnomalyAlpha)
+
+		anomalyScore.WithLabelValues(metric).Set(z)
+		if !warm || math.Abs(z) < app.config.AnomalyZThreshold {
+			anomalyActive.WithLabelValues(metric).Set(0)
+			continue
+		}
+		anomalyActive.WithLabelValues(metric).Set(1)
+		app.event("warn", EventAnomalyDetected, metric, "Business metric anomaly", map[string]interface{}{
+			"value":      value,
+			"expected":   expected,
+			"z_score":    z,
+			"threshold":  app.config.AnomalyZThreshold,
+			"window_sec": app.config.AnomalyWindowSec,
+		})
+	}
+}
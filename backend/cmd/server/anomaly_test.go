@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAnomalyDetection(t *testing.T) {
+	var logs bytes.Buffer
+	app := newTestApp(t, func(c *Config) {
+		c.AnomalyAlpha = 0.3
+		c.AnomalyZThreshold = 3
+		c.AnomalyWarmupWindows = 4
+	})
+	app.logs = newLogger("info", "json", &logs)
+	window := func(count int) {
+		for i := 0; i < count; i++ {
+			app.anomalies.Record(Transaction{Amount: 20, Status: "success"})
+		}
+		app.checkAnomalies()
+	}
+
+	// A spike while the baseline is still warming up isn't reported.
+	window(10)
+	window(12)
+	window(50)
+	if z := testutil.ToFloat64(anomalyScore.WithLabelValues("transaction_volume")); z < 3 || logs.Len() != 0 {
+		t.Fatalf("spike scored %v, reported %s; want a high score and no report", z, logs.String())
+	}
+	if active := testutil.ToFloat64(anomalyActive.WithLabelValues("transaction_volume")); active != 0 {
+		t.Fatalf("reported during warm-up: active %v, logs %s", active, logs.String())
+	}
+
+	for _, n := range []int{11, 10, 12, 11, 10, 12} {
+		window(n)
+	}
+	if active := testutil.ToFloat64(anomalyActive.WithLabelValues("transaction_volume")); active != 0 {
+		t.Fatalf("normal traffic reported as an anomaly: %s", logs.String())
+	}
+
+	window(200)
+	if active := testutil.ToFloat64(anomalyActive.WithLabelValues("transaction_volume")); active != 1 {
+		t.Errorf("volume spike not active")
+	}
+	if z := testutil.ToFloat64(anomalyScore.WithLabelValues("transaction_volume")); z < 3 {
+		t.Errorf("volume spike scored %v, want at least 3", z)
+	}
+	if !strings.Contains(logs.String(), `"event_type":"`+EventAnomalyDetected+`"`) || !strings.Contains(logs.String(), `"value":200`) {
+		t.Errorf("no anomaly event for the spike in %s", logs.String())
+	}
+
+	logs.Reset()
+	window(11)
+	if active := testutil.ToFloat64(anomalyActive.WithLabelValues("transaction_volume")); active != 0 {
+		t.Errorf("still active once volume is back to normal")
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	apiKeyPrefix   = "pfk_"
+	apiKeyCacheTTL = 5 * time.Second
+)
+
+// defaultAPIKeyScopes are granted when a key is issued without scopes.
+var defaultAPIKeyScopes = []string{"transactions:read", "transactions:write"}
+
+// APIKey is a long-lived credential for a machine client, sent as X-API-Key.
+// Only a SHA-256 of the key is stored; the key itself is shown once, when it
+// is issued. Keys issued with signing enabled also get a signing secret for
+// HMAC-signed requests (see request_signing.go), which the server has to keep.
+// A key with a SessionID is pinned to that demo session (see tenants.go) and
+// is revoked when the session ends.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Owner      string     `json:"owner"`
+	Scopes     []string   `json:"scopes"`
+	Signing    bool       `json:"signing"`
+	SessionID  string     `json:"session_id,omitempty"`
+	CreatedBy  string     `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+type cachedAPIKey struct {
+	principal     *Principal
+	hash          string
+	signingSecret string
+	loadedAt      time.Time
+}
+
+// apiKeyCache avoids a database lookup on every request made with a key. A
+// revoked key stops working here at once and on other replicas within
+// apiKeyCacheTTL.
+type apiKeyCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedAPIKey
+}
+
+func (s *apiKeyCache) get(id string) (cachedAPIKey, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[id]
+	if !ok || time.Since(e.loadedAt) > apiKeyCacheTTL {
+		return cachedAPIKey{}, false
+	}
+	return e, true
+}
+
+func (s *apiKeyCache) put(id string, e cachedAPIKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.entries == nil {
+		s.entries = map[string]cachedAPIKey{}
+	}
+	e.loadedAt = time.Now()
+	s.entries[id] = e
+}
+
+func (s *apiKeyCache) drop(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, id)
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// newAPIKey returns a key of the form pfk_<id>_<secret> and its ID.
+func newAPIKey() (string, string) {
+	b := make([]byte, 38)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	id := hex.EncodeToString(b[:6])
+	return apiKeyPrefix + id + "_" + hex.EncodeToString(b[6:]), id
+}
+
+// apiKeyID extracts the ID from a key, or "" if it isn't shaped like one.
+func apiKeyID(key string) string {
+	parts := strings.Split(strings.TrimPrefix(key, apiKeyPrefix), "_")
+	if !strings.HasPrefix(key, apiKeyPrefix) || len(parts) != 2 || parts[0] == "" {
+		return ""
+	}
+	return parts[0]
+}
+
+// loadAPIKey returns the active key with the given ID; its principal is nil
+// if there is none. Loading a key from the database also stamps last_used_at,
+// so that column is accurate to about apiKeyCacheTTL.
+func (app *App) loadAPIKey(ctx context.Context, id string) (cachedAPIKey, error) {
+	if e, ok := app.apiKeys.get(id); ok {
+		return e, nil
+	}
+	var e cachedAPIKey
+	var owner, scopes, session string
+	err := app.db.QueryRowContext(ctx, `
+		UPDATE api_keys SET last_used_at = NOW()
+		WHERE id = $1 AND revoked_at IS NULL
+		RETURNING owner, scopes, key_hash, signing_secret, COALESCE(session_id, '')
+	`, id).Scan(&owner, &scopes, &e.hash, &e.signingSecret, &session)
+	if err != nil && err != sql.ErrNoRows {
+		return e, err
+	}
+	if err == nil {
+		e.principal = &Principal{Subject: owner, Scopes: strings.Fields(scopes), Source: "apikey", SessionID: session, KeyID: id}
+	}
+	app.apiKeys.put(id, e)
+	return e, nil
+}
+
+// lookupAPIKey resolves a key to its principal, or nil if it is unknown,
+// revoked or wrong.
+func (app *App) lookupAPIKey(ctx context.Context, key string) (*Principal, error) {
+	id := apiKeyID(key)
+	if id == "" {
+		return nil, nil
+	}
+	e, err := app.loadAPIKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if e.principal == nil || subtle.ConstantTimeCompare([]byte(hashAPIKey(key)), []byte(e.hash)) != 1 {
+		return nil, nil
+	}
+	return e.principal, nil
+}
+
+// apiKeyMiddleware authenticates requests carrying X-API-Key and attaches the
+// key owner as the Principal. Invalid keys are rejected; requests without one
+// are left to requireAuthMiddleware.
+func (app *App) apiKeyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("X-API-Key")
+		if key == "" {
+			c.Next()
+			return
+		}
+		if principalFrom(c) != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Send either a bearer token or an API key, not both"})
+			c.Abort()
+			return
+		}
+		if app.db == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+			c.Abort()
+			return
+		}
+		principal, err := app.lookupAPIKey(c.Request.Context(), key)
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to verify API key"})
+			c.Abort()
+			return
+		}
+		if principal == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+			c.Abort()
+			return
+		}
+		c.Set(principalContextKey, principal)
+		c.Next()
+	}
+}
+
+func (app *App) createAPIKeyHandler(c *gin.Context) {
+	var req struct {
+		Owner   string   `json:"owner" binding:"required"`
+		Scopes  []string `json:"scopes"`
+		Signing bool     `json:"signing"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	if len(req.Scopes) == 0 {
+		req.Scopes = defaultAPIKeyScopes
+	}
+
+	key, id := newAPIKey()
+	signingSecret := ""
+	if req.Signing {
+		signingSecret = newSigningSecret()
+	}
+	record := APIKey{
+		ID:        id,
+		Owner:     req.Owner,
+		Scopes:    req.Scopes,
+		Signing:   req.Signing,
+		CreatedBy: adminActor(c),
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+	}
+	if err := app.storeAPIKey(c.Request.Context(), record, key, signingSecret); err != nil {
+		app.logCtx(c.Request.Context(), "error", "Failed to issue API key", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	app.eventCtx(c.Request.Context(), "info", EventAPIKeyIssued, record.ID, "API key issued", map[string]interface{}{
+		"owner":   record.Owner,
+		"scopes":  record.Scopes,
+		"signing": record.Signing,
+		"actor":   record.CreatedBy,
+	})
+	resp := gin.H{"key": key, "api_key": record}
+	if signingSecret != "" {
+		resp["signing_secret"] = signingSecret
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusCreated, resp)
+}
+
+// storeAPIKey records a newly issued key; only its hash is kept.
+func (app *App) storeAPIKey(ctx context.Context, record APIKey, key, signingSecret string) error {
+	_, err := app.db.ExecContext(ctx, `
+		INSERT INTO api_keys (id, owner, scopes, key_hash, signing_secret, session_id, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, record.ID, record.Owner, strings.Join(record.Scopes, " "), hashAPIKey(key), signingSecret, sessionArg(record.SessionID), record.CreatedBy, record.CreatedAt)
+	return err
+}
+
+func (app *App) listAPIKeysHandler(c *gin.Context) {
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	rows, err := app.db.QueryContext(c.Request.Context(), `
+		SELECT id, owner, scopes, signing_secret <> '', COALESCE(session_id, ''), created_by, created_at, last_used_at, revoked_at
+		FROM api_keys ORDER BY created_at DESC
+	`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var k APIKey
+		var scopes string
+		var lastUsed, revoked sql.NullTime
+		if err := rows.Scan(&k.ID, &k.Owner, &scopes, &k.Signing, &k.SessionID, &k.CreatedBy, &k.CreatedAt, &lastUsed, &revoked); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		k.Scopes = strings.Fields(scopes)
+		if lastUsed.Valid {
+			k.LastUsedAt = &lastUsed.Time
+		}
+		if revoked.Valid {
+			k.RevokedAt = &revoked.Time
+		}
+		keys = append(keys, k)
+	}
+	c.JSON(http.StatusOK, gin.H{"api_keys": keys})
+}
+
+func (app *App) revokeAPIKeyHandler(c *gin.Context) {
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	id := c.Param("id")
+	res, err := app.db.ExecContext(c.Request.Context(), `
+		UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL
+	`, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found or already revoked"})
+		return
+	}
+	app.apiKeys.drop(id)
+
+	app.eventCtx(c.Request.Context(), "info", EventAPIKeyRevoked, id, "API key revoked", map[string]interface{}{"actor": adminActor(c)})
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/infrasage/payflow/internal/dbtest"
+)
+
+type fakeKey struct {
+	owner, scopes, hash string
+	revoked             bool
+	uses                int
+}
+
+// fakeAPIKeys is the api_keys table.
+type fakeAPIKeys map[string]*fakeKey
+
+func (f fakeAPIKeys) run(q dbtest.Query) (*dbtest.Rows, error) {
+	switch {
+	case q.HasPrefix("INSERT INTO api_keys"):
+		f[q.String(0)] = &fakeKey{owner: q.String(1), scopes: q.String(2), hash: q.String(3)}
+		return dbtest.Affected(1), nil
+	case q.HasPrefix("UPDATE api_keys SET last_used_at"):
+		rows := dbtest.NewRows("owner", "scopes", "key_hash", "signing_secret", "session_id")
+		if k, ok := f[q.String(0)]; ok && !k.revoked {
+			k.uses++
+			rows.Add(k.owner, k.scopes, k.hash, "", "")
+		}
+		return rows, nil
+	case q.HasPrefix("SELECT id, owner, scopes"):
+		rows := dbtest.NewRows("id", "owner", "scopes", "signing", "session_id", "created_by", "created_at", "last_used_at", "revoked_at")
+		for id, k := range f {
+			rows.Add(id, k.owner, k.scopes, false, "", "admin", time.Now(), nil, nil)
+		}
+		return rows, nil
+	case q.HasPrefix("UPDATE api_keys SET revoked_at"):
+		if k, ok := f[q.String(0)]; ok && !k.revoked {
+			k.revoked = true
+			return dbtest.Affected(1), nil
+		}
+		return dbtest.Affected(0), nil
+	}
+	return nil, dbtest.Unexpected(q)
+}
+
+func TestAPIKeys(t *testing.T) {
+	app := newTestApp(t, func(c *Config) {
+		c.AdminToken = "secret-token"
+		c.OAuthClients = "reporting:s3cret:accounts:read"
+	})
+	keys := fakeAPIKeys{}
+	app.db = dbtest.New(keys.run).Open(t)
+	r := app.newRouter()
+	admin := map[string]string{"X-Admin-Token": "secret-token"}
+
+	w := serve(r, http.MethodPost, "/api/admin/api-keys", map[string]interface{}{"owner": "ci", "scopes": []string{"accounts:read"}}, admin)
+	if w.Code != http.StatusCreated || w.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("issue key: %d %s", w.Code, w.Body)
+	}
+	var issued struct {
+		Key    string `json:"key"`
+		APIKey APIKey `json:"api_key"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &issued)
+	id := issued.APIKey.ID
+	if !strings.HasPrefix(issued.Key, apiKeyPrefix+id+"_") || keys[id] == nil || keys[id].hash != hashAPIKey(issued.Key) {
+		t.Fatalf("issued %+v; stored %+v, want the key's hash stored under its ID", issued, keys[id])
+	}
+	if w := serve(r, http.MethodGet, "/api/admin/api-keys", nil, admin); w.Code != http.StatusOK || strings.Contains(w.Body.String(), keys[id].hash) {
+		t.Errorf("list keys: %d %s", w.Code, w.Body)
+	}
+
+	call := func(key string, headers map[string]string) int {
+		if headers == nil {
+			headers = map[string]string{}
+		}
+		headers["X-API-Key"] = key
+		return serve(r, http.MethodGet, "/api/schemas", nil, headers).Code
+	}
+	if code := call(issued.Key, nil); code != http.StatusOK {
+		t.Errorf("with the key: %d, want 200", code)
+	}
+	if code := call(issued.Key, nil); code != http.StatusOK || keys[id].uses != 1 {
+		t.Errorf("again: %d after %d lookups, want 200 from the cache", code, keys[id].uses)
+	}
+	for name, key := range map[string]string{
+		"wrong secret":  apiKeyPrefix + id + "_0000",
+		"unknown ID":    apiKeyPrefix + "ffffffffffff_0000",
+		"not a key":     "hunter2",
+		"missing parts": apiKeyPrefix + id,
+	} {
+		if code := call(key, nil); code != http.StatusUnauthorized {
+			t.Errorf("%s: %d, want 401", name, code)
+		}
+	}
+	token := requestToken(r, map[string][]string{"grant_type": {"client_credentials"}}, "reporting", "s3cret")
+	var tok struct {
+		AccessToken string `json:"access_token"`
+	}
+	json.Unmarshal(token.Body.Bytes(), &tok)
+	if code := call(issued.Key, map[string]string{"Authorization": "Bearer " + tok.AccessToken}); code != http.StatusBadRequest {
+		t.Errorf("key and bearer token: %d, want 400", code)
+	}
+
+	// The key's scopes apply.
+	w = serve(r, http.MethodPost, "/api/accounts", map[string]interface{}{"name": "Ada"}, map[string]string{"X-API-Key": issued.Key})
+	if w.Code != http.StatusForbidden {
+		t.Errorf("write with a read-only key: %d %s, want 403", w.Code, w.Body)
+	}
+
+	if w := serve(r, http.MethodDelete, "/api/admin/api-keys/"+id, nil, admin); w.Code != http.StatusNoContent {
+		t.Fatalf("revoke: %d %s", w.Code, w.Body)
+	}
+	if code := call(issued.Key, nil); code != http.StatusUnauthorized {
+		t.Errorf("revoked key: %d, want 401 at once", code)
+	}
+	if w := serve(r, http.MethodDelete, "/api/admin/api-keys/"+id, nil, admin); w.Code != http.StatusNotFound {
+		t.Errorf("revoking again: %d, want 404", w.Code)
+	}
+}
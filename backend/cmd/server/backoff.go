@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// backoff produces retry delays that grow by multiplier from initial up to
+// max. Each delay is spread by up to ±jitter of itself, so instances that
+// lost a dependency together don't retry in lockstep.
+type backoff struct {
+	next       time.Duration
+	max        time.Duration
+	multiplier float64
+	jitter     float64
+}
+
+func (app *App) newBackoff() *backoff {
+	cfg := app.config
+	return &backoff{
+		next:       time.Duration(cfg.DepRetryInitialMs) * time.Millisecond,
+		max:        time.Duration(cfg.DepRetryMaxMs) * time.Millisecond,
+		multiplier: cfg.DepRetryMultiplier,
+		jitter:     cfg.DepRetryJitter,
+	}
+}
+
+// delay returns the wait before the next attempt.
+func (b *backoff) delay() time.Duration {
+	d := b.next
+	if b.next = time.Duration(float64(b.next) * b.multiplier); b.next > b.max {
+		b.next = b.max
+	}
+	return time.Duration(float64(d) * (1 + b.jitter*(2*rand.Float64()-1)))
+}
+
+// dependencyPingTimeout bounds one connection attempt. Attempts aren't cut
+// short by the startup deadline, so a dependency always gets at least one
+// even when an earlier one used the deadline up.
+const dependencyPingTimeout = 5 * time.Second
+
+// startupDeadline is when startup stops waiting for its dependencies,
+// STARTUP_DEADLINE_SEC from now.
+func (app *App) startupDeadline() time.Time {
+	return time.Now().Add(time.Duration(app.config.StartupDeadlineSec) * time.Second)
+}
+
+// waitForDependency calls ping until it succeeds, backing off in between,
+// and gives up with the last error once the next attempt would start after
+// deadline. name labels the log lines.
+func (app *App) waitForDependency(deadline time.Time, name string, ping func(context.Context) error) error {
+	b := app.newBackoff()
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), dependencyPingTimeout)
+		err := ping(ctx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		wait := b.delay()
+		if time.Now().Add(wait).After(deadline) {
+			return err
+		}
+		app.log("warn", "Waiting for "+name+"...", map[string]interface{}{
+			"attempt":     attempt,
+			"retry_in_ms": wait.Milliseconds(),
+			"error":       err.Error(),
+		})
+		time.Sleep(wait)
+	}
+}
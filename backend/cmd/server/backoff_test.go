@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBackoffDelays(t *testing.T) {
+	app := newTestApp(t, func(c *Config) {
+		c.DepRetryInitialMs, c.DepRetryMaxMs, c.DepRetryMultiplier, c.DepRetryJitter = 100, 1000, 2, 0
+	})
+	b := app.newBackoff()
+	for i, want := range []int64{100, 200, 400, 800, 1000, 1000} {
+		if got := b.delay().Milliseconds(); got != want {
+			t.Errorf("delay %d = %dms, want %dms", i+1, got, want)
+		}
+	}
+
+	app.config.DepRetryJitter = 0.5
+	b = app.newBackoff()
+	for i := 0; i < 50; i++ {
+		if d := b.delay(); d < 50*time.Millisecond || d > 1500*time.Millisecond {
+			t.Fatalf("jittered delay %v outside ±50%%", d)
+		}
+	}
+}
+
+// Startup waits for Redis until the deadline. An instance that gave up on
+// it keeps the client and reports itself degraded until Redis answers.
+func TestInitRedisWaitsForRedis(t *testing.T) {
+	redisServer := startFakeRedis(t)
+	host, port, _ := net.SplitHostPort(redisServer.addr)
+	newRedisApp := func() *App {
+		app := newTestApp(t, func(c *Config) {
+			c.CacheMode, c.RedisHost, c.RedisPort = "redis", host, port
+			c.DepRetryInitialMs, c.DepRetryMaxMs, c.DepRetryJitter = 20, 50, 0
+		})
+		t.Cleanup(func() {
+			if app.redisClient != nil {
+				app.redisClient.Close()
+			}
+		})
+		return app
+	}
+	redisCheck := func(app *App) (int, string) {
+		w := serve(app.newRouter(), http.MethodGet, "/ready", nil, nil)
+		var resp struct {
+			Checks map[string]DependencyCheck `json:"checks"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp.Checks["redis"].Status
+	}
+
+	redisServer.stop()
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		redisServer.start()
+	}()
+	app := newRedisApp()
+	if err := app.initRedis(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("Redis up within the deadline: %v", err)
+	}
+	if code, status := redisCheck(app); code != http.StatusOK || status != "ok" {
+		t.Errorf("/ready = %d, redis %q; want 200 and ok", code, status)
+	}
+
+	redisServer.stop()
+	app = newRedisApp()
+	start := time.Now()
+	if err := app.initRedis(time.Now().Add(100 * time.Millisecond)); err == nil {
+		t.Fatal("Redis down past the deadline, no error")
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("gave up after %v, deadline was 100ms", waited)
+	}
+	if code, status := redisCheck(app); code != http.StatusOK || status != "degraded" {
+		t.Errorf("/ready without Redis = %d, redis %q; want 200 and degraded", code, status)
+	}
+	// The client pool redials a failing address about once a second.
+	redisServer.start()
+	status := ""
+	for wait := time.Now().Add(3 * time.Second); status != "ok" && time.Now().Before(wait); time.Sleep(50 * time.Millisecond) {
+		_, status = redisCheck(app)
+	}
+	if status != "ok" {
+		t.Errorf("redis %q once it is back, want ok", status)
+	}
+}
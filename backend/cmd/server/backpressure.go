@@ -0,0 +1,93 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const maxRetryAfterSec = 30
+
+var backpressureRejections = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "payflow_backpressure_rejections_total",
+		Help: "Requests rejected with 429 because a downstream resource was saturated, by reason (db_pool, spool)",
+	},
+	[]string{"reason"},
+)
+
+// poolWaitSampler turns the cumulative wait counters of sql.DBStats into the
+// average time a request recently had to wait for a connection.
+type poolWaitSampler struct {
+	mu       sync.Mutex
+	at       time.Time
+	count    int64
+	duration time.Duration
+	avg      time.Duration
+}
+
+func (s *poolWaitSampler) sample(count int64, duration time.Duration) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.at) >= time.Second {
+		if dc := count - s.count; dc > 0 {
+			s.avg = (duration - s.duration) / time.Duration(dc)
+		} else {
+			s.avg = 0
+		}
+		s.at, s.count, s.duration = time.Now(), count, duration
+	}
+	return s.avg
+}
+
+// saturation reports why new writes should be refused, if at all, and how
+// many seconds the client should wait before retrying.
+func (app *App) saturation() (reason string, retryAfter int) {
+	cfg := app.config
+
+	if app.db != nil && cfg.BackpressureDBPoolRatio > 0 {
+		stats := app.db.Stats()
+		avgWait := app.poolWait.sample(stats.WaitCount, stats.WaitDuration)
+		if stats.MaxOpenConnections > 0 &&
+			float64(stats.InUse)/float64(stats.MaxOpenConnections) >= cfg.BackpressureDBPoolRatio {
+			// Callers are queueing for connections; by the time the current
+			// queue has drained a retry should find one free.
+			return "db_pool", clampRetry(avgWait.Seconds())
+		}
+	}
+
+	if app.spool != nil && cfg.BackpressureSpoolMaxDepth > 0 && app.spool.Depth() >= cfg.BackpressureSpoolMaxDepth {
+		// The spool only drains on replay ticks.
+		return "spool", clampRetry(float64(cfg.SpoolReplaySec))
+	}
+	return "", 0
+}
+
+func clampRetry(sec float64) int {
+	return int(math.Min(math.Max(math.Ceil(sec), 1), maxRetryAfterSec))
+}
+
+// backpressureMiddleware sheds load with 429 and Retry-After while the DB
+// pool or the outage spool is saturated, instead of queueing unboundedly.
+func (app *App) backpressureMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reason, retryAfter := app.saturation()
+		if reason == "" {
+			c.Next()
+			return
+		}
+		backpressureRejections.WithLabelValues(reason).Inc()
+		app.debug(c.Request.Context(), "Request rejected by backpressure", map[string]interface{}{
+			"reason":      reason,
+			"retry_after": retryAfter,
+		})
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Service saturated, retry later", "reason": reason})
+		c.Abort()
+	}
+}
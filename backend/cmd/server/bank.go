@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var bankRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "payflow_bank_requests_total",
+	Help: "Bank API requests by operation and result (accepted, rejected, error)",
+}, []string{"operation", "result"})
+
+// BankTransfer is a transfer as the bank API (cmd/mockbank) takes and
+// returns it. Status is accepted or rejected, with Reason for the latter.
+type BankTransfer struct {
+	ID        string  `json:"id,omitempty"`
+	Reference string  `json:"reference"`
+	Type      string  `json:"type"`
+	Amount    float64 `json:"amount"`
+	Currency  string  `json:"currency"`
+	Account   string  `json:"account"`
+	Status    string  `json:"status,omitempty"`
+	Reason    string  `json:"reason,omitempty"`
+}
+
+// BankClient talks to the bank PayFlow moves money out through.
+type BankClient struct {
+	url    string
+	client *http.Client
+}
+
+func (app *App) initBank() {
+	if app.config.BankURL == "" {
+		return
+	}
+	app.bank = &BankClient{
+		url: strings.TrimSuffix(app.config.BankURL, "/"),
+		// Transfers carry an idempotency key, so they are safe to retry.
+		client: app.newOutboundClient("bank", outboundOptions{Timeout: time.Duration(app.config.BankTimeoutMs) * time.Millisecond, Retries: 2}),
+	}
+}
+
+// Healthy checks that the bank API answers.
+func (b *BankClient) Healthy(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.url+"/health", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("bank: %s", resp.Status)
+	}
+	return nil
+}
+
+// Transfer books t under key. The bank answers a repeated key with the
+// transfer it booked the first time, so a request that timed out can be
+// sent again without moving the money twice. A rejection is not an error;
+// it comes back in the transfer's Status.
+func (b *BankClient) Transfer(ctx context.Context, key string, t BankTransfer) (*BankTransfer, error) {
+	body, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.url+"/v1/transfers", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", key)
+	resp, err := b.client.Do(req)
+	if err != nil {
+		bankRequestsTotal.WithLabelValues(t.Type, "error").Inc()
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		bankRequestsTotal.WithLabelValues(t.Type, "error").Inc()
+		return nil, fmt.Errorf("bank: %s", resp.Status)
+	}
+	var booked BankTransfer
+	if err := json.NewDecoder(resp.Body).Decode(&booked); err != nil {
+		bankRequestsTotal.WithLabelValues(t.Type, "error").Inc()
+		return nil, fmt.Errorf("bank: decoding transfer: %w", err)
+	}
+	bankRequestsTotal.WithLabelValues(t.Type, booked.Status).Inc()
+	return &booked, nil
+}
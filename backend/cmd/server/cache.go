@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// cacheDegraded is 1 for each cache-dependent feature that is running on its
+// fallback rather than on shared Redis state: because CACHE_MODE chose so,
+// or because Redis is unreachable.
+var cacheDegraded = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "payflow_cache_degraded",
+		Help: "1 while a cache-dependent feature runs without shared Redis state, by feature",
+	},
+	[]string{"feature"},
+)
+
+var errCacheMiss = errors.New("cache miss")
+
+// errCacheUnavailable is returned by a Redis cache while Redis is known to be
+// down, without waiting out the dial timeout.
+var errCacheUnavailable = errors.New("cache unavailable")
+
+// cacheCheckInterval is how often watchCache pings Redis to bring the cache
+// back, or take it out, when Redis comes up or goes down after startup.
+const cacheCheckInterval = 5 * time.Second
+
+// kvCache is the store behind the read cache and the counterparty cache:
+// Redis, or an in-process map when CACHE_MODE is memory. Get returns
+// errCacheMiss for a missing key. Write applies several changes in order,
+// in a single round trip on Redis.
+type kvCache interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Del(ctx context.Context, key string) error
+	Incr(ctx context.Context, key string) error
+	Write(ctx context.Context, writes []cacheWrite) error
+}
+
+type cacheOp int
+
+const (
+	cacheSet cacheOp = iota
+	cacheDel
+	cacheIncr
+)
+
+// cacheWrite is one change sent with kvCache.Write: a Set of value for ttl,
+// a Del or an Incr of key.
+type cacheWrite struct {
+	op    cacheOp
+	key   string
+	value []byte
+	ttl   time.Duration
+}
+
+// redisCache is a kvCache on Redis. Every call fails with
+// errCacheUnavailable while it is marked down; watchCache keeps the mark
+// current.
+type redisCache struct {
+	rc       *redis.Client
+	up       atomic.Bool
+	features []string // cacheDegraded labels that depend on it
+}
+
+func (c *redisCache) Get(ctx context.Context, key string) ([]byte, error) {
+	if !c.up.Load() {
+		return nil, errCacheUnavailable
+	}
+	raw, err := c.rc.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, errCacheMiss
+	}
+	return raw, err
+}
+
+func (c *redisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if !c.up.Load() {
+		return errCacheUnavailable
+	}
+	return c.rc.Set(ctx, key, value, ttl).Err()
+}
+
+func (c *redisCache) Del(ctx context.Context, key string) error {
+	if !c.up.Load() {
+		return errCacheUnavailable
+	}
+	return c.rc.Del(ctx, key).Err()
+}
+
+func (c *redisCache) Incr(ctx context.Context, key string) error {
+	if !c.up.Load() {
+		return errCacheUnavailable
+	}
+	return c.rc.Incr(ctx, key).Err()
+}
+
+// Write sends writes as one pipeline. Redis runs them in order but not
+// atomically; the first failure is returned.
+func (c *redisCache) Write(ctx context.Context, writes []cacheWrite) error {
+	if !c.up.Load() {
+		return errCacheUnavailable
+	}
+	_, err := c.rc.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for _, w := range writes {
+			switch w.op {
+			case cacheSet:
+				p.Set(ctx, w.key, w.value, w.ttl)
+			case cacheDel:
+				p.Del(ctx, w.key)
+			case cacheIncr:
+				p.Incr(ctx, w.key)
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// memoryCache keeps entries in process, up to max bytes of values. When a
+// Set doesn't fit it drops expired entries first, then arbitrary ones.
+type memoryCache struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	size    int64
+	max     int64
+}
+
+type memoryEntry struct {
+	value   []byte
+	expires time.Time // zero for no expiry
+}
+
+func newMemoryCache(max int64) *memoryCache {
+	return &memoryCache{entries: map[string]memoryEntry{}, max: max}
+}
+
+func (c *memoryCache) Get(_ context.Context, key string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, errCacheMiss
+	}
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		c.remove(key)
+		return nil, errCacheMiss
+	}
+	return e.value, nil
+}
+
+func (c *memoryCache) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.put(key, value, ttl)
+	return nil
+}
+
+func (c *memoryCache) Del(_ context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remove(key)
+	return nil
+}
+
+// Incr treats the value as a decimal counter, like Redis INCR, and keeps its
+// expiry.
+func (c *memoryCache) Incr(_ context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.entries[key]
+	n, _ := strconv.ParseInt(string(e.value), 10, 64)
+	var ttl time.Duration
+	if !e.expires.IsZero() {
+		ttl = time.Until(e.expires)
+	}
+	c.put(key, []byte(strconv.FormatInt(n+1, 10)), ttl)
+	return nil
+}
+
+func (c *memoryCache) Write(ctx context.Context, writes []cacheWrite) error {
+	for _, w := range writes {
+		switch w.op {
+		case cacheSet:
+			c.Set(ctx, w.key, w.value, w.ttl)
+		case cacheDel:
+			c.Del(ctx, w.key)
+		case cacheIncr:
+			c.Incr(ctx, w.key)
+		}
+	}
+	return nil
+}
+
+func (c *memoryCache) put(key string, value []byte, ttl time.Duration) {
+	c.remove(key)
+	need := int64(len(value))
+	if need > c.max {
+		return
+	}
+	if c.size+need > c.max {
+		now := time.Now()
+		for k, e := range c.entries {
+			if !e.expires.IsZero() && now.After(e.expires) {
+				c.remove(k)
+			}
+		}
+		for k := range c.entries {
+			if c.size+need <= c.max {
+				break
+			}
+			c.remove(k)
+		}
+	}
+	e := memoryEntry{value: value}
+	if ttl > 0 {
+		e.expires = time.Now().Add(ttl)
+	}
+	c.entries[key] = e
+	c.size += need
+}
+
+func (c *memoryCache) remove(key string) {
+	if e, ok := c.entries[key]; ok {
+		c.size -= int64(len(e.value))
+		delete(c.entries, key)
+	}
+}
+
+// parseByteSize reads a CACHE_MAX_SIZE value such as 512KB or 100MB.
+func parseByteSize(s string) int64 {
+	units := []struct {
+		suffix string
+		factor int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			n, _ := strconv.ParseInt(strings.TrimSuffix(s, u.suffix), 10, 64)
+			return n * u.factor
+		}
+	}
+	return 0
+}
+
+// initCache picks the store for the read cache and the counterparty cache
+// according to CACHE_MODE. In redis mode both start out bypassed unless Redis
+// answered at startup, since every read would otherwise wait out the dial
+// timeout; watchCache turns them on once it does. Rate limits pick their own
+// backend in rateLimitMiddleware.
+func (app *App) initCache() {
+	var store kvCache
+	var shared *redisCache
+	switch app.config.CacheMode {
+	case "redis":
+		if app.redisClient != nil {
+			shared = &redisCache{rc: app.redisClient}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			shared.up.Store(app.redisClient.Ping(ctx).Err() == nil)
+			store = shared
+		}
+	case "memory":
+		store = newMemoryCache(parseByteSize(app.config.CacheMaxSize))
+	}
+	up := shared != nil && shared.up.Load()
+
+	if app.config.CacheTTL > 0 {
+		app.readCache = store
+		setCacheDegraded("read_cache", !up)
+		if shared != nil {
+			shared.features = append(shared.features, "read_cache")
+		}
+	}
+	if app.enricher != nil {
+		app.enricher.cache = store
+		setCacheDegraded("enrichment", !up)
+		if shared != nil {
+			shared.features = append(shared.features, "enrichment")
+		}
+	}
+	app.sharedCache = shared
+	app.log("info", "Cache initialized", map[string]interface{}{
+		"mode":       app.config.CacheMode,
+		"read_cache": app.readCache != nil,
+		"shared":     up,
+	})
+}
+
+// watchCache checks Redis every cacheCheckInterval and marks the Redis cache
+// up or down to match, so the caches recover when Redis comes up after
+// startup and stop waiting on it when it goes away.
+func (app *App) watchCache() {
+	if app.sharedCache == nil {
+		return
+	}
+	go func() {
+		for {
+			time.Sleep(cacheCheckInterval)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			app.checkCache(ctx)
+			cancel()
+		}
+	}()
+}
+
+// checkCache pings Redis and updates the Redis cache and the degraded gauge
+// when its state changed. Writes while it was down couldn't invalidate cached
+// reads, so on recovery the read cache generation is bumped before the cache
+// is used again.
+func (app *App) checkCache(ctx context.Context) {
+	c := app.sharedCache
+	err := c.rc.Ping(ctx).Err()
+	if err == nil && !c.up.Load() {
+		if err = c.rc.Incr(ctx, readCacheGenKey).Err(); err == nil {
+			c.up.Store(true)
+			for _, f := range c.features {
+				setCacheDegraded(f, false)
+			}
+			app.log("info", "Redis cache recovered", map[string]interface{}{"features": c.features})
+		}
+	}
+	if err != nil && c.up.CompareAndSwap(true, false) {
+		for _, f := range c.features {
+			setCacheDegraded(f, true)
+		}
+		app.log("warn", "Redis cache unavailable, serving uncached", map[string]interface{}{"error": err.Error(), "features": c.features})
+	}
+}
+
+func setCacheDegraded(feature string, degraded bool) {
+	v := 0.0
+	if degraded {
+		v = 1
+	}
+	cacheDegraded.WithLabelValues(feature).Set(v)
+}
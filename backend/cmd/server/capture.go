@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/infrasage/payflow/sdk"
+)
+
+// maxCapturedBody bounds how much of each request and response body a
+// capture keeps.
+const maxCapturedBody = 64 << 10
+
+// redactedHeaders are never copied into a capture. Lower case.
+var redactedHeaders = map[string]bool{
+	"authorization":                      true,
+	"cookie":                             true,
+	"x-api-key":                          true,
+	"x-admin-token":                      true,
+	strings.ToLower(sdk.HeaderSignature): true,
+}
+
+// CaptureStep is one thing that happened while a captured request was
+// handled: a debug note, a log line or a domain event. AtMs is the time since
+// the request started.
+type CaptureStep struct {
+	AtMs      float64     `json:"at_ms"`
+	Kind      string      `json:"kind"`
+	Level     string      `json:"level,omitempty"`
+	EventType string      `json:"event_type,omitempty"`
+	Message   string      `json:"message"`
+	Data      interface{} `json:"data,omitempty"`
+}
+
+// CapturedQuery is one SQL statement a captured request ran.
+type CapturedQuery struct {
+	AtMs       float64 `json:"at_ms"`
+	Query      string  `json:"query"`
+	DurationMs float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// CapturedMessage is a request or response with its headers and body. Body
+// is the JSON as sent when it parses, and a string otherwise.
+type CapturedMessage struct {
+	Method    string            `json:"method,omitempty"`
+	Path      string            `json:"path,omitempty"`
+	Query     string            `json:"query,omitempty"`
+	Status    int               `json:"status,omitempty"`
+	Headers   map[string]string `json:"headers"`
+	Body      interface{}       `json:"body,omitempty"`
+	Truncated bool              `json:"truncated,omitempty"`
+}
+
+// CaptureTimings sums up where a captured request spent its time. Latency
+// is the request's breakdown by dependency, filled in once it has finished.
+type CaptureTimings struct {
+	TotalMs    float64           `json:"total_ms"`
+	DBMs       float64           `json:"db_ms"`
+	DBQueries  int               `json:"db_queries"`
+	CacheCalls int               `json:"cache_calls"`
+	Latency    *LatencyBreakdown `json:"latency,omitempty"`
+}
+
+// CapturedTransaction is everything recorded about one POST
+// /api/transactions request. Fraud is filled in when background analysis
+// finishes, which may be after the response was sent.
+type CapturedTransaction struct {
+	RequestID     string                   `json:"request_id"`
+	TransactionID string                   `json:"transaction_id,omitempty"`
+	StartedAt     time.Time                `json:"started_at"`
+	Done          bool                     `json:"done"`
+	Request       CapturedMessage          `json:"request"`
+	Response      *CapturedMessage         `json:"response,omitempty"`
+	Timings       CaptureTimings           `json:"timings"`
+	Steps         []CaptureStep            `json:"steps"`
+	SQL           []CapturedQuery          `json:"sql"`
+	Cache         []map[string]interface{} `json:"cache"`
+	Fraud         *FraudAssessment         `json:"fraud,omitempty"`
+
+	mu sync.Mutex
+}
+
+func (t *CapturedTransaction) since() float64 {
+	return float64(time.Since(t.StartedAt).Microseconds()) / 1000
+}
+
+func (t *CapturedTransaction) step(s CaptureStep) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s.AtMs = t.since()
+	t.Steps = append(t.Steps, s)
+}
+
+func (t *CapturedTransaction) query(query string, start time.Time, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	q := CapturedQuery{
+		AtMs:       float64(start.Sub(t.StartedAt).Microseconds()) / 1000,
+		Query:      strings.Join(strings.Fields(query), " "),
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		q.Error = err.Error()
+	}
+	t.SQL = append(t.SQL, q)
+	t.Timings.DBMs += q.DurationMs
+	t.Timings.DBQueries++
+}
+
+func (t *CapturedTransaction) cache(data map[string]interface{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	data["at_ms"] = t.since()
+	t.Cache = append(t.Cache, data)
+	t.Timings.CacheCalls++
+}
+
+// setTransaction links the capture to the transaction it created, so the
+// fraud assessment can find it later.
+func (t *CapturedTransaction) setTransaction(id string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.TransactionID = id
+	t.mu.Unlock()
+}
+
+// captureBundle is the set of transactions one capture recorded.
+type captureBundle struct {
+	id           string
+	requested    int
+	startedAt    time.Time
+	actor        string
+	transactions []*CapturedTransaction
+}
+
+// transactionCapture records the next N create-transaction requests in full
+// for one admin-started capture at a time. Bundles are kept in memory per
+// instance until the next capture starts or the current one is discarded.
+type transactionCapture struct {
+	mu     sync.Mutex
+	bundle *captureBundle
+}
+
+type captureKey struct{}
+
+func captureFrom(ctx context.Context) *CapturedTransaction {
+	if ctx == nil {
+		return nil
+	}
+	t, _ := ctx.Value(captureKey{}).(*CapturedTransaction)
+	return t
+}
+
+// claim reserves a place in the bundle for a new request, or returns nil
+// when no capture is running or it is full.
+func (tc *transactionCapture) claim(requestID string) *CapturedTransaction {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	b := tc.bundle
+	if b == nil || len(b.transactions) >= b.requested {
+		return nil
+	}
+	t := &CapturedTransaction{RequestID: requestID, StartedAt: time.Now(), Steps: []CaptureStep{}, SQL: []CapturedQuery{}, Cache: []map[string]interface{}{}}
+	b.transactions = append(b.transactions, t)
+	return t
+}
+
+// attachAssessment adds a finished fraud assessment to the capture of the
+// transaction it is about, if that transaction was captured.
+func (tc *transactionCapture) attachAssessment(a *FraudAssessment) {
+	tc.mu.Lock()
+	b := tc.bundle
+	tc.mu.Unlock()
+	if b == nil {
+		return
+	}
+	for _, t := range b.transactions {
+		t.mu.Lock()
+		if t.TransactionID == a.TransactionID {
+			t.Fraud = a
+		}
+		t.mu.Unlock()
+	}
+}
+
+// captureMiddleware records create-transaction requests into the running
+// capture. It runs before authentication, chaos injection and validation,
+// so requests those reject are captured too.
+func (app *App) captureMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodPost || c.FullPath() != "/api/transactions" {
+			c.Next()
+			return
+		}
+		t := app.capture.claim(traceIDFrom(c.Request.Context()))
+		if t == nil {
+			c.Next()
+			return
+		}
+
+		req := CapturedMessage{
+			Method:  c.Request.Method,
+			Path:    c.Request.URL.Path,
+			Query:   c.Request.URL.RawQuery,
+			Headers: capturedHeaders(c.Request.Header),
+		}
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxBodyBytes))
+		if err == nil {
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+			req.Body, req.Truncated = capturedBody(body)
+		}
+		t.mu.Lock()
+		t.Request = req
+		t.mu.Unlock()
+
+		w := &captureWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), captureKey{}, t))
+		c.Next()
+
+		t.mu.Lock()
+		resp := &CapturedMessage{Status: w.Status(), Headers: capturedHeaders(w.Header())}
+		resp.Body, resp.Truncated = capturedBody(w.body.Bytes())
+		resp.Truncated = resp.Truncated || w.truncated
+		t.Response = resp
+		t.Timings.TotalMs = t.since()
+		t.Done = true
+		t.mu.Unlock()
+	}
+}
+
+// captureWriter keeps a copy of the response body as it is written.
+type captureWriter struct {
+	gin.ResponseWriter
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (w *captureWriter) Write(b []byte) (int, error) {
+	if room := maxCapturedBody - w.body.Len(); room > 0 {
+		if len(b) > room {
+			w.body.Write(b[:room])
+			w.truncated = true
+		} else {
+			w.body.Write(b)
+		}
+	} else if len(b) > 0 {
+		w.truncated = true
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *captureWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func capturedHeaders(h http.Header) map[string]string {
+	out := make(map[string]string, len(h))
+	for name, values := range h {
+		if redactedHeaders[strings.ToLower(name)] {
+			out[name] = "[redacted]"
+			continue
+		}
+		out[name] = strings.Join(values, ", ")
+	}
+	return out
+}
+
+func capturedBody(body []byte) (interface{}, bool) {
+	if len(body) == 0 {
+		return nil, false
+	}
+	truncated := len(body) > maxCapturedBody
+	if truncated {
+		return string(body[:maxCapturedBody]), true
+	}
+	if json.Valid(body) {
+		return json.RawMessage(body), false
+	}
+	return string(body), false
+}
+
+// startCaptureHandler starts capturing the next count (default 10)
+// create-transaction requests, replacing any earlier bundle.
+func (app *App) startCaptureHandler(c *gin.Context) {
+	var req struct {
+		Count int `json:"count" binding:"omitempty,min=1,max=100"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.Count == 0 {
+		req.Count = 10
+	}
+
+	tc := &app.capture
+	tc.mu.Lock()
+	if b := tc.bundle; b != nil && len(b.transactions) < b.requested {
+		tc.mu.Unlock()
+		c.JSON(http.StatusConflict, gin.H{"error": "A capture is already in progress; download or discard it first", "id": b.id})
+		return
+	}
+	b := &captureBundle{
+		id:        uuid.New().String(),
+		requested: req.Count,
+		startedAt: time.Now().UTC(),
+		actor:     adminActor(c),
+	}
+	tc.bundle = b
+	tc.mu.Unlock()
+
+	app.logCtx(c.Request.Context(), "info", "Transaction capture started", map[string]interface{}{
+		"capture_id": b.id,
+		"count":      b.requested,
+		"actor":      b.actor,
+	})
+	c.JSON(http.StatusCreated, gin.H{"id": b.id, "requested": b.requested, "started_at": b.startedAt})
+}
+
+// getCaptureHandler downloads the current bundle as a JSON attachment. It
+// can be fetched while the capture is still filling up.
+func (app *App) getCaptureHandler(c *gin.Context) {
+	tc := &app.capture
+	tc.mu.Lock()
+	b := tc.bundle
+	var txns []*CapturedTransaction
+	if b != nil {
+		txns = append(txns, b.transactions...)
+	}
+	tc.mu.Unlock()
+	if b == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No capture"})
+		return
+	}
+
+	// Each transaction is marshalled under its own lock, since requests and
+	// fraud workers may still be writing to it.
+	entries := make([]json.RawMessage, 0, len(txns))
+	captured := 0
+	for _, t := range txns {
+		t.mu.Lock()
+		raw, err := json.Marshal(t)
+		if t.Done {
+			captured++
+		}
+		t.mu.Unlock()
+		if err != nil {
+			app.logCtx(c.Request.Context(), "error", "Failed to encode captured transaction", map[string]interface{}{"error": err.Error()})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode capture"})
+			return
+		}
+		entries = append(entries, raw)
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="payflow-capture-`+b.id+`.json"`)
+	c.JSON(http.StatusOK, gin.H{
+		"id":           b.id,
+		"requested":    b.requested,
+		"captured":     captured,
+		"complete":     captured == b.requested,
+		"started_at":   b.startedAt,
+		"actor":        b.actor,
+		"transactions": entries,
+	})
+}
+
+func (app *App) discardCaptureHandler(c *gin.Context) {
+	tc := &app.capture
+	tc.mu.Lock()
+	b := tc.bundle
+	tc.bundle = nil
+	tc.mu.Unlock()
+	if b == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No capture"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
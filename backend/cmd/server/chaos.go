@@ -0,0 +1,434 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/infrasage/payflow/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	chaosLatencyMs = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "payflow_chaos_latency_ms",
+		Help: "Latency injected into every request on this instance (INJECT_LATENCY_MS)",
+	})
+	chaosErrorRate = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "payflow_chaos_error_rate",
+		Help: "Fraction of requests failed on purpose on this instance (INJECT_ERROR_RATE)",
+	})
+	chaosActive = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "payflow_chaos_active",
+		Help: "1 while a kind of chaos injection is on for this instance, by type",
+	}, []string{"type"})
+)
+
+// chaosTypes are the kinds of injection payflow_chaos_active reports, and
+// what turns each on.
+var chaosTypes = []struct {
+	name string
+	on   func(*Config) bool
+}{
+	{"latency", func(c *Config) bool { return c.InjectLatencyMs > 0 }},
+	{"error_rate", func(c *Config) bool { return c.InjectErrorRate > 0 }},
+	{"oom", func(c *Config) bool { return c.InjectOOM }},
+	{"cpu_burn", func(c *Config) bool { return c.InjectCPUBurn }},
+	{"panic", func(c *Config) bool { return c.InjectPanic }},
+	{"db_timeout", func(c *Config) bool { return c.InjectDBTimeout }},
+	{"buggy_cache", func(c *Config) bool { return c.FeatureNewCache }},
+	{"replication_lag", func(c *Config) bool { return c.InjectReplicationLagMs > 0 }},
+	{"slow_query", func(c *Config) bool { return c.InjectSlowQueryRate > 0 }},
+	{"goroutine_leak", func(c *Config) bool { return c.InjectGoroutineLeakPerSec > 0 }},
+	{"pool_exhaustion", func(c *Config) bool { return c.InjectPoolExhaustion > 0 }},
+	{"dropped_responses", func(c *Config) bool { return c.InjectDropRate > 0 }},
+}
+
+// anyChaos reports whether cfg turns on any injection.
+func anyChaos(cfg *Config) bool {
+	for _, t := range chaosTypes {
+		if t.on(cfg) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordChaosMetrics publishes the injections cfg turns on. Every type is
+// set, off ones to 0, so dashboards have a series to overlay from the start.
+// Per-request overrides and demo session chaos don't show here.
+func recordChaosMetrics(cfg *Config) {
+	chaosLatencyMs.Set(float64(cfg.InjectLatencyMs))
+	chaosErrorRate.Set(cfg.InjectErrorRate)
+	for _, t := range chaosTypes {
+		on := 0.0
+		if t.on(cfg) {
+			on = 1
+		}
+		chaosActive.WithLabelValues(t.name).Set(on)
+	}
+}
+
+// liveConfig is the configuration in effect outside any request override:
+// the loaded config with the chaos settings changed at runtime, and the
+// settings reloaded on SIGHUP, applied.
+func (app *App) liveConfig() *Config {
+	if cfg := app.chaosConfig.Load(); cfg != nil {
+		return cfg
+	}
+	return app.config
+}
+
+// chaosRunner is a fault that runs in the background instead of on each
+// request. Only one of each runs at a time.
+type chaosRunner struct {
+	name    string
+	message string
+	enabled func(*Config) bool
+	run     func(ctx context.Context)
+}
+
+func (app *App) chaosRunners() []chaosRunner {
+	return []chaosRunner{
+		{"oom", "OOM simulation enabled - memory will grow", func(c *Config) bool { return c.InjectOOM }, func(ctx context.Context) {
+			app.leakMemory(ctx, "Memory allocated")
+		}},
+		{"buggy_cache", "New cache enabled - warming cache (buggy)", func(c *Config) bool { return c.FeatureNewCache }, func(ctx context.Context) {
+			app.leakMemory(ctx, "Cache warmup allocated")
+		}},
+		{"cpu_burn", "CPU burn simulation enabled", func(c *Config) bool { return c.InjectCPUBurn }, burnCPU},
+		{"goroutine_leak", "Goroutine leak enabled", func(c *Config) bool { return c.InjectGoroutineLeakPerSec > 0 }, app.leakGoroutines},
+		{"pool_exhaustion", "Holding primary pool connections", func(c *Config) bool { return c.InjectPoolExhaustion > 0 }, app.exhaustPool},
+	}
+}
+
+// syncChaosRunners starts the background faults cfg enables and stops the
+// ones it doesn't, and updates the chaos metrics. Memory leaked by stopped faults is released once none is
+// left allocating.
+func (app *App) syncChaosRunners(cfg *Config) {
+	recordChaosMetrics(cfg)
+	if app.chaosRunning == nil {
+		app.chaosRunning = map[string]context.CancelFunc{}
+	}
+	for _, r := range app.chaosRunners() {
+		cancel, running := app.chaosRunning[r.name]
+		switch on := r.enabled(cfg); {
+		case on && !running:
+			ctx, cancel := context.WithCancel(context.Background())
+			app.chaosRunning[r.name] = cancel
+			attrs := map[string]interface{}(nil)
+			if r.name == "buggy_cache" {
+				attrs = map[string]interface{}{"cache_max_size": cfg.CacheMaxSize}
+			}
+			app.event("warn", EventChaosFaultStarted, r.name, r.message, attrs)
+			go r.run(ctx)
+		case !on && running:
+			cancel()
+			delete(app.chaosRunning, r.name)
+			app.event("info", EventChaosFaultStopped, r.name, "Chaos fault stopped", nil)
+		}
+	}
+	_, oom := app.chaosRunning["oom"]
+	_, cache := app.chaosRunning["buggy_cache"]
+	if !oom && !cache {
+		app.mu.Lock()
+		leaked := len(app.memoryLeak)
+		app.memoryLeak = nil
+		app.mu.Unlock()
+		if leaked > 0 {
+			debug.FreeOSMemory()
+			app.log("info", "Leaked memory released", map[string]interface{}{"size_mb": leaked * 10})
+		}
+	}
+}
+
+// leakMemory allocates a 10MB chunk every 5 seconds and keeps it until ctx
+// ends.
+func (app *App) leakMemory(ctx context.Context, message string) {
+	for {
+		app.mu.Lock()
+		chunk := make([]byte, 10*1024*1024)
+		for i := range chunk {
+			chunk[i] = byte(i % 256)
+		}
+		app.memoryLeak = append(app.memoryLeak, chunk)
+		chunks := len(app.memoryLeak)
+		app.mu.Unlock()
+		app.log("warn", message, map[string]interface{}{
+			"chunks":  chunks,
+			"size_mb": chunks * 10,
+		})
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// burnCPU spins one core until ctx ends.
+func burnCPU(ctx context.Context) {
+	for ctx.Err() == nil {
+		for i := 0; i < 10000000; i++ {
+			_ = i * i
+		}
+	}
+}
+
+// leakGoroutines starts INJECT_GOROUTINE_LEAK_PER_SEC goroutines a second
+// that block until ctx ends, logging the count every 10 seconds.
+func (app *App) leakGoroutines(ctx context.Context) {
+	leaked := 0
+	for tick := 1; ; tick++ {
+		select {
+		case <-ctx.Done():
+			app.log("info", "Leaked goroutines released", map[string]interface{}{"goroutines": leaked})
+			return
+		case <-time.After(time.Second):
+		}
+		n := app.liveConfig().InjectGoroutineLeakPerSec
+		for i := 0; i < n; i++ {
+			go func() { <-ctx.Done() }()
+		}
+		leaked += n
+		if tick%10 == 0 {
+			app.log("warn", "Goroutines leaked", map[string]interface{}{"leaked": leaked, "goroutines": runtime.NumGoroutine()})
+		}
+	}
+}
+
+// exhaustPool checks out INJECT_POOL_EXHAUSTION connections of the primary
+// pool and keeps them, following the setting as it changes, until ctx ends.
+// Requests then queue for the connections left, or wait for good once the
+// pool's limit is held.
+func (app *App) exhaustPool(ctx context.Context) {
+	var held []*sql.Conn
+	defer func() {
+		for _, conn := range held {
+			conn.Close()
+		}
+	}()
+	for ctx.Err() == nil {
+		want := app.liveConfig().InjectPoolExhaustion
+		for len(held) > want {
+			held[len(held)-1].Close()
+			held = held[:len(held)-1]
+		}
+		for app.db != nil && len(held) < want {
+			// Bounded, so a setting above the pool's limit doesn't block
+			// lowering it again.
+			connCtx, cancel := context.WithTimeout(ctx, time.Second)
+			conn, err := app.db.Conn(connCtx)
+			cancel()
+			if err != nil {
+				break
+			}
+			held = append(held, conn)
+			if len(held) == want {
+				app.log("warn", "Primary pool connections held", map[string]interface{}{"held": len(held)})
+			}
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// requestConfigKey carries the configuration in effect for a request on its
+// context, for code that has no gin context, such as the database driver.
+type requestConfigKey struct{}
+
+func withRequestConfig(ctx context.Context, cfg *Config) context.Context {
+	return context.WithValue(ctx, requestConfigKey{}, cfg)
+}
+
+// configFrom returns the request's configuration on ctx, or the live one.
+func (app *App) configFrom(ctx context.Context) *Config {
+	if cfg, ok := ctx.Value(requestConfigKey{}).(*Config); ok {
+		return cfg
+	}
+	return app.liveConfig()
+}
+
+// slowQueryDelay picks INJECT_SLOW_QUERY_RATE of statements to be delayed by
+// INJECT_SLOW_QUERY_MS.
+func (app *App) slowQueryDelay(ctx context.Context) time.Duration {
+	cfg := app.configFrom(ctx)
+	if cfg.InjectSlowQueryRate <= 0 || rand.Float64() >= cfg.InjectSlowQueryRate {
+		return 0
+	}
+	return time.Duration(cfg.InjectSlowQueryMs) * time.Millisecond
+}
+
+// dropResponse answers c by closing its connection, after the handlers have
+// run with their output discarded, as when a response is lost on the way.
+// The client can't tell whether its request took effect.
+func (app *App) dropResponse(c *gin.Context) {
+	w := c.Writer
+	c.Writer = &discardWriter{ResponseWriter: w}
+	c.Next()
+	app.eventCtx(c.Request.Context(), "warn", EventChaosResponseDropped, "", "Response dropped", map[string]interface{}{
+		"path": c.Request.URL.Path,
+	})
+	if conn, _, err := w.Hijack(); err == nil {
+		conn.Close()
+		return
+	}
+	// Connections that can't be hijacked, such as HTTP/2 streams, get an
+	// empty 502 instead.
+	w.WriteHeader(http.StatusBadGateway)
+	w.WriteHeaderNow()
+}
+
+// discardWriter swallows a handler's response.
+type discardWriter struct {
+	gin.ResponseWriter
+	status int
+}
+
+func (w *discardWriter) WriteHeader(code int)              { w.status = code }
+func (w *discardWriter) WriteHeaderNow()                   {}
+func (w *discardWriter) Write(b []byte) (int, error)       { return len(b), nil }
+func (w *discardWriter) WriteString(s string) (int, error) { return len(s), nil }
+func (w *discardWriter) Written() bool                     { return w.status != 0 }
+func (w *discardWriter) Status() int                       { return w.status }
+
+// chaosField returns the chaos setting named by its lowercased env name.
+func chaosField(key string) (config.Field, bool) {
+	for _, f := range config.Schema {
+		if f.Chaos && strings.ToLower(f.Env) == key {
+			return f, true
+		}
+	}
+	return config.Field{}, false
+}
+
+// setChaos applies changes, keyed like X-Feature-Overrides, on top of the
+// live config and makes the result live. Nothing changes when any of them
+// is invalid, or when allow, if given, refuses the chaosGuardrails
+// violations.
+func (app *App) setChaos(changes map[string]string, allow func([]GuardrailViolation) bool) error {
+	app.chaosMu.Lock()
+	defer app.chaosMu.Unlock()
+	live := app.liveConfig()
+	next, err := withChaos(live, changes)
+	if err != nil {
+		return err
+	}
+	if allow != nil && !allow(chaosGuardrails(live, next)) {
+		return errGuardrailsRefused
+	}
+	app.chaosConfig.Store(next)
+	app.syncChaosRunners(next)
+	return nil
+}
+
+// withChaos returns a copy of base with changes applied.
+func withChaos(base *Config, changes map[string]string) (*Config, error) {
+	next := base.Clone()
+	for key, raw := range changes {
+		field, ok := chaosField(key)
+		if !ok {
+			return nil, fmt.Errorf("%q is not a chaos setting", key)
+		}
+		if err := field.Set(next, raw); err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+		next.SetSource(field.Env, config.SourceRuntime)
+	}
+	return next, nil
+}
+
+// chaosChanges turns decoded JSON or YAML values into setting strings.
+func chaosChanges(values map[string]interface{}) map[string]string {
+	changes := make(map[string]string, len(values))
+	for key, v := range values {
+		if raw, ok := v.(string); ok {
+			changes[strings.ToLower(key)] = raw
+			continue
+		}
+		raw, _ := json.Marshal(v)
+		changes[strings.ToLower(key)] = string(raw)
+	}
+	return changes
+}
+
+// chaosReport is the chaos state /api/admin/chaos returns.
+func (app *App) chaosReport() gin.H {
+	cfg := app.liveConfig()
+	settings := []config.SettingValue{}
+	for _, s := range cfg.Settings() {
+		if _, ok := chaosField(strings.ToLower(s.Env)); ok {
+			settings = append(settings, s)
+		}
+	}
+	app.chaosMu.Lock()
+	running := make([]string, 0, len(app.chaosRunning))
+	for name := range app.chaosRunning {
+		running = append(running, name)
+	}
+	app.chaosMu.Unlock()
+	sort.Strings(running)
+	app.mu.Lock()
+	leaked := len(app.memoryLeak) * 10
+	app.mu.Unlock()
+	return gin.H{"status": chaosStatus(cfg), "settings": settings, "running": running, "leaked_mb": leaked, "scenario": app.scenarioStatus()}
+}
+
+func (app *App) getChaosHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, app.chaosReport())
+}
+
+// updateChaosHandler changes chaos settings on this instance, from a JSON
+// object keyed by lowercased env name, e.g. {"inject_latency_ms": 250}.
+// Settings left out keep their values.
+func (app *App) updateChaosHandler(c *gin.Context) {
+	var body map[string]interface{}
+	if err := c.ShouldBindJSON(&body); err != nil || len(body) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Body must be a JSON object of chaos settings"})
+		return
+	}
+	changes := chaosChanges(body)
+	err := app.setChaos(changes, func(violations []GuardrailViolation) bool {
+		return app.passGuardrails(c, "chaos.update", "", violations)
+	})
+	if errors.Is(err, errGuardrailsRefused) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	app.eventCtx(c.Request.Context(), "warn", EventChaosConfigured, "", "Chaos settings changed", map[string]interface{}{"changes": changes})
+	c.JSON(http.StatusOK, app.chaosReport())
+}
+
+// resetChaosHandler turns every chaos setting on this instance back to its
+// default, which stops all faults, including ones enabled at startup, and
+// any running scenario.
+func (app *App) resetChaosHandler(c *gin.Context) {
+	app.stopScenario()
+	changes := map[string]string{}
+	for _, f := range config.Schema {
+		if f.Chaos {
+			changes[strings.ToLower(f.Env)] = f.Default
+		}
+	}
+	if err := app.setChaos(changes, nil); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	app.eventCtx(c.Request.Context(), "info", EventChaosConfigured, "", "Chaos settings reset", nil)
+	c.JSON(http.StatusOK, app.chaosReport())
+}
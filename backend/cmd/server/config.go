@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/mail"
+
+	"github.com/infrasage/payflow/internal/config"
+)
+
+// Config holds all configuration
+type Config = config.Config
+
+// ConfigError collects every problem found while loading configuration.
+type ConfigError = config.Error
+
+// configChecks validate the settings whose syntax is parsed by the server
+// rather than by the config package.
+var configChecks = []config.Check{
+	func(c *Config) []string {
+		var problems []string
+		if _, err := parseRoleMap(c.OIDCRoleMap); err != nil {
+			problems = append(problems, "OIDC_ROLE_MAP: "+err.Error())
+		}
+		if _, err := parseTrustedProxies(c.TrustedProxies); err != nil {
+			problems = append(problems, "TRUSTED_PROXIES: "+err.Error())
+		}
+		if _, err := parseOAuthClients(c.OAuthClients); err != nil {
+			problems = append(problems, "OAUTH_CLIENTS: "+err.Error())
+		}
+		if _, err := parseRateLimitPolicy(c.RateLimitRoutes, c.RateLimitExempt); err != nil {
+			problems = append(problems, err.Error())
+		}
+		if _, err := parseVaultKeys(c.TokenVaultKey, c.TokenVaultKeys); err != nil && c.TokenizationEnabled {
+			problems = append(problems, "TOKEN_VAULT_KEYS: "+err.Error())
+		}
+		if c.ReceiptEmails {
+			if _, err := loadReceiptTemplate(c.ReceiptTemplateFile); err != nil {
+				problems = append(problems, "RECEIPT_TEMPLATE_FILE: "+err.Error())
+			}
+			if _, err := mail.ParseAddress(c.SMTPFrom); err != nil {
+				problems = append(problems, "SMTP_FROM: "+err.Error())
+			}
+		}
+		return problems
+	},
+}
+
+func loadConfig() (*Config, error) {
+	return config.Load(configChecks...)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/lib/pq"
+)
+
+type requestCostKey struct{}
+
+// requestCost accumulates the resources one request consumed. DB and Redis
+// usage is attributed through the request context, so only calls made with
+// it are counted.
+type requestCost struct {
+	dbNanos        int64
+	dbQueries      int64
+	redisCalls     int64
+	redisNanos     int64
+	fraudNanos     int64
+	serializeNanos int64
+}
+
+func requestCostFrom(ctx context.Context) *requestCost {
+	if ctx == nil {
+		return nil
+	}
+	m, _ := ctx.Value(requestCostKey{}).(*requestCost)
+	return m
+}
+
+func meterDB(ctx context.Context, start time.Time) {
+	if m := requestCostFrom(ctx); m != nil {
+		atomic.AddInt64(&m.dbNanos, int64(time.Since(start)))
+		atomic.AddInt64(&m.dbQueries, 1)
+	}
+}
+
+// queryObserver is told about every statement the metered driver runs.
+type queryObserver func(ctx context.Context, query string, start time.Time, err error)
+
+// queryDelay returns how long the server should sleep before a statement,
+// usually 0.
+type queryDelay func(ctx context.Context) time.Duration
+
+// openMeteredDB opens Postgres through a driver wrapper that charges query
+// time to the request behind the query's context and reports each statement
+// to observe. Statements run for a request are canceled after timeout, if
+// set. Each statement is preceded by a pg_sleep when delay asks for one.
+func openMeteredDB(connStr string, observe queryObserver, timeout time.Duration, delay queryDelay) (*sql.DB, error) {
+	connector, err := pq.NewConnector(connStr)
+	if err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(meteredConnector{connector, observe, timeout, delay}), nil
+}
+
+type meteredConnector struct {
+	driver.Connector
+	observe queryObserver
+	timeout time.Duration
+	delay   queryDelay
+}
+
+func (m meteredConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := m.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return meteredConn{conn, m.observe, m.timeout, m.delay}, nil
+}
+
+// meteredConn forwards every optional driver interface database/sql probes
+// for, since embedding driver.Conn alone would hide them.
+type meteredConn struct {
+	driver.Conn
+	observe queryObserver
+	timeout time.Duration
+	delay   queryDelay
+}
+
+// bound applies the statement timeout to ctx if it carries request costs,
+// as requests and fraud analysis do. Other background jobs and migrations
+// legitimately run long statements and are left to their own contexts.
+func (c meteredConn) bound(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.timeout <= 0 || requestCostFrom(ctx) == nil {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, c.timeout)
+}
+
+func (c meteredConn) done(ctx context.Context, query string, start time.Time, err error) {
+	meterDB(ctx, start)
+	if err == driver.ErrSkip {
+		return
+	}
+	countDBError(err)
+	if c.observe != nil {
+		c.observe(ctx, query, start, err)
+	}
+}
+
+// sleep runs the pg_sleep delay asks for ahead of a statement, on the same
+// connection and under the same timeout.
+func (c meteredConn) sleep(ctx context.Context) error {
+	if c.delay == nil {
+		return nil
+	}
+	d := c.delay(ctx)
+	e, ok := c.Conn.(driver.ExecerContext)
+	if d <= 0 || !ok {
+		return nil
+	}
+	_, err := e.ExecContext(ctx, fmt.Sprintf("SELECT pg_sleep(%.3f)", d.Seconds()), nil)
+	return err
+}
+
+func (c meteredConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	ctx, cancel := c.bound(ctx)
+	defer cancel()
+	start := time.Now()
+	if err := c.sleep(ctx); err != nil {
+		c.done(ctx, query, start, err)
+		return nil, err
+	}
+	res, err := e.ExecContext(ctx, query, args)
+	c.done(ctx, query, start, err)
+	return res, err
+}
+
+func (c meteredConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	ctx, cancel := c.bound(ctx)
+	start := time.Now()
+	if err := c.sleep(ctx); err != nil {
+		c.done(ctx, query, start, err)
+		cancel()
+		return nil, err
+	}
+	rows, err := q.QueryContext(ctx, query, args)
+	c.done(ctx, query, start, err)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// The timeout covers reading the rows too, so it only ends once they
+	// are closed.
+	return boundRows{rows, cancel}, nil
+}
+
+// boundRows releases a statement's timeout when its rows are closed. It
+// forwards the column type interfaces pq implements.
+type boundRows struct {
+	driver.Rows
+	cancel context.CancelFunc
+}
+
+func (r boundRows) Close() error {
+	err := r.Rows.Close()
+	r.cancel()
+	return err
+}
+
+func (r boundRows) ColumnTypeScanType(index int) reflect.Type {
+	if t, ok := r.Rows.(driver.RowsColumnTypeScanType); ok {
+		return t.ColumnTypeScanType(index)
+	}
+	return reflect.TypeOf(new(interface{})).Elem()
+}
+
+func (r boundRows) ColumnTypeDatabaseTypeName(index int) string {
+	if t, ok := r.Rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return t.ColumnTypeDatabaseTypeName(index)
+	}
+	return ""
+}
+
+func (r boundRows) ColumnTypeLength(index int) (int64, bool) {
+	if t, ok := r.Rows.(driver.RowsColumnTypeLength); ok {
+		return t.ColumnTypeLength(index)
+	}
+	return 0, false
+}
+
+func (r boundRows) ColumnTypePrecisionScale(index int) (int64, int64, bool) {
+	if t, ok := r.Rows.(driver.RowsColumnTypePrecisionScale); ok {
+		return t.ColumnTypePrecisionScale(index)
+	}
+	return 0, 0, false
+}
+
+func (c meteredConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c meteredConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c meteredConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c meteredConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c meteredConn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c meteredConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+type redisStartKey struct{}
+
+// redisCostHook counts and times Redis commands issued with a request
+// context.
+type redisCostHook struct{}
+
+func (redisCostHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	if m := requestCostFrom(ctx); m != nil {
+		atomic.AddInt64(&m.redisCalls, 1)
+		return context.WithValue(ctx, redisStartKey{}, time.Now()), nil
+	}
+	return ctx, nil
+}
+
+func (redisCostHook) AfterProcess(ctx context.Context, _ redis.Cmder) error {
+	meterRedis(ctx)
+	return nil
+}
+
+func (redisCostHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	if m := requestCostFrom(ctx); m != nil {
+		atomic.AddInt64(&m.redisCalls, int64(len(cmds)))
+		return context.WithValue(ctx, redisStartKey{}, time.Now()), nil
+	}
+	return ctx, nil
+}
+
+func (redisCostHook) AfterProcessPipeline(ctx context.Context, _ []redis.Cmder) error {
+	meterRedis(ctx)
+	return nil
+}
+
+func meterRedis(ctx context.Context) {
+	if start, ok := ctx.Value(redisStartKey{}).(time.Time); ok {
+		atomic.AddInt64(&requestCostFrom(ctx).redisNanos, int64(time.Since(start)))
+	}
+}
+
+// cpuShare apportions process CPU time among in-flight requests. Go can't
+// measure CPU per goroutine, so each request is charged an equal share of
+// whatever the process burned while it was running.
+type cpuShare struct {
+	mu       sync.Mutex
+	lastCPU  time.Duration
+	perReq   float64
+	inFlight int
+}
+
+func processCPU() time.Duration {
+	var ru syscall.Rusage
+	if syscall.Getrusage(syscall.RUSAGE_SELF, &ru) != nil {
+		return 0
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+func (s *cpuShare) advance() {
+	now := processCPU()
+	if s.inFlight > 0 {
+		s.perReq += float64(now-s.lastCPU) / float64(s.inFlight)
+	}
+	s.lastCPU = now
+}
+
+func (s *cpuShare) begin() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.advance()
+	s.inFlight++
+	return s.perReq
+}
+
+func (s *cpuShare) end(mark float64) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.advance()
+	s.inFlight--
+	return time.Duration(s.perReq - mark)
+}
+
+// CostTotals aggregates resource usage for a route or consumer. Cost is in
+// the abstract units set by the COST_UNITS_PER_* settings.
+type CostTotals struct {
+	Key        string  `json:"key"`
+	Requests   int64   `json:"requests"`
+	DBTimeMs   float64 `json:"db_time_ms"`
+	DBQueries  int64   `json:"db_queries"`
+	RedisCalls int64   `json:"redis_calls"`
+	CPUTimeMs  float64 `json:"cpu_time_ms"`
+	BytesIn    int64   `json:"bytes_in"`
+	BytesOut   int64   `json:"bytes_out"`
+	Cost       float64 `json:"cost"`
+}
+
+func (t *CostTotals) add(o CostTotals) {
+	t.Requests += o.Requests
+	t.DBTimeMs += o.DBTimeMs
+	t.DBQueries += o.DBQueries
+	t.RedisCalls += o.RedisCalls
+	t.CPUTimeMs += o.CPUTimeMs
+	t.BytesIn += o.BytesIn
+	t.BytesOut += o.BytesOut
+	t.Cost += o.Cost
+}
+
+// CostTracker keeps in-memory cost aggregates since start or the last reset.
+type CostTracker struct {
+	cpu cpuShare
+
+	mu        sync.Mutex
+	since     time.Time
+	routes    map[string]*CostTotals
+	consumers map[string]*CostTotals
+}
+
+func newCostTracker() *CostTracker {
+	t := &CostTracker{}
+	t.reset()
+	return t
+}
+
+func (t *CostTracker) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.since = time.Now().UTC()
+	t.routes = map[string]*CostTotals{}
+	t.consumers = map[string]*CostTotals{}
+}
+
+func (t *CostTracker) record(route, consumer string, usage CostTotals) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, bucket := range []struct {
+		m   map[string]*CostTotals
+		key string
+	}{{t.routes, route}, {t.consumers, consumer}} {
+		agg, ok := bucket.m[bucket.key]
+		if !ok {
+			agg = &CostTotals{Key: bucket.key}
+			bucket.m[bucket.key] = agg
+		}
+		agg.add(usage)
+	}
+}
+
+func sortedCosts(m map[string]*CostTotals) []CostTotals {
+	out := make([]CostTotals, 0, len(m))
+	for _, t := range m {
+		out = append(out, *t)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Cost > out[j].Cost })
+	return out
+}
+
+// costMiddleware meters each request and folds it into the aggregates.
+func (app *App) costMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		m := &requestCost{}
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), requestCostKey{}, m))
+		mark := app.costs.cpu.begin()
+
+		c.Next()
+
+		cpu := app.costs.cpu.end(mark)
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		usage := CostTotals{
+			Requests:   1,
+			DBTimeMs:   float64(atomic.LoadInt64(&m.dbNanos)) / float64(time.Millisecond),
+			DBQueries:  atomic.LoadInt64(&m.dbQueries),
+			RedisCalls: atomic.LoadInt64(&m.redisCalls),
+			CPUTimeMs:  float64(cpu) / float64(time.Millisecond),
+		}
+		if c.Request.ContentLength > 0 {
+			usage.BytesIn = c.Request.ContentLength
+		}
+		if n := c.Writer.Size(); n > 0 {
+			usage.BytesOut = int64(n)
+		}
+		cfg := app.config
+		usage.Cost = usage.DBTimeMs*cfg.CostUnitsPerDBMs +
+			usage.CPUTimeMs*cfg.CostUnitsPerCPUMs +
+			float64(usage.RedisCalls)*cfg.CostUnitsPerRedisCall +
+			float64(usage.BytesIn+usage.BytesOut)/1024*cfg.CostUnitsPerKB
+		app.costs.record(c.Request.Method+" "+route, requestActor(c), usage)
+	}
+}
+
+func (app *App) getCostsHandler(c *gin.Context) {
+	t := app.costs
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c.JSON(http.StatusOK, gin.H{
+		"since":     t.since,
+		"routes":    sortedCosts(t.routes),
+		"consumers": sortedCosts(t.consumers),
+	})
+}
+
+func (app *App) resetCostsHandler(c *gin.Context) {
+	app.costs.reset()
+	app.logCtx(c.Request.Context(), "info", "Cost aggregates reset", map[string]interface{}{"actor": adminActor(c)})
+	c.Status(http.StatusNoContent)
+}
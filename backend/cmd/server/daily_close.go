@@ -0,0 +1,445 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/infrasage/payflow/internal/store"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const dayLayout = "2006-01-02"
+
+var (
+	closeExceptionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "payflow_close_exceptions_total",
+		Help: "Changes found in days after they were closed, by kind",
+	}, []string{"kind"})
+	lastClosedDay = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "payflow_last_closed_day_timestamp_seconds",
+		Help: "Start of the latest day closed by the end-of-day job",
+	})
+)
+
+// DailyClose is the frozen record of one UTC day of real traffic. Debits
+// and credits total the two legs of the day's settled transactions, which
+// match unless a row is missing an account. LedgerHead is the hash chain
+// head among the day's transactions and Digest covers every transaction's
+// id, status and amount, so the close can be checked against the chain and
+// against daily_close_entries.
+type DailyClose struct {
+	Day          string    `json:"day"`
+	ClosedAt     time.Time `json:"closed_at"`
+	Transactions int64     `json:"transactions"`
+	Settled      int64     `json:"settled"`
+	Debits       float64   `json:"debits"`
+	Credits      float64   `json:"credits"`
+	Balanced     bool      `json:"balanced"`
+	LedgerHead   string    `json:"ledger_head,omitempty"`
+	Digest       string    `json:"digest"`
+	Exceptions   int64     `json:"exceptions"`
+}
+
+// CloseException is a change made to a closed day: a transaction inserted
+// into it, modified so the day's totals no longer hold, or removed. Detail
+// holds the closed and current values.
+type CloseException struct {
+	ID            int64           `json:"id"`
+	Day           string          `json:"day"`
+	TransactionID string          `json:"transaction_id"`
+	Kind          string          `json:"kind"`
+	Detail        json.RawMessage `json:"detail"`
+	DetectedAt    time.Time       `json:"detected_at"`
+}
+
+// startDailyCloser periodically closes every finished day since the last
+// close, looks for changes made to recently closed days, and settles closed
+// days with the bank.
+func (app *App) startDailyCloser() {
+	interval := app.config.EODCloseIntervalSec
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		for {
+			if app.db != nil {
+				ctx := context.Background()
+				if err := app.closeFinishedDays(ctx); err != nil {
+					app.log("warn", "Failed to close finished days", map[string]interface{}{"error": err.Error()})
+				}
+				if _, err := app.detectCloseExceptions(ctx); err != nil {
+					app.log("warn", "Failed to check closed days", map[string]interface{}{"error": err.Error()})
+				}
+				if err := app.settleClosedDays(ctx); err != nil {
+					app.log("warn", "Failed to settle closed days", map[string]interface{}{"error": err.Error()})
+				}
+			}
+			time.Sleep(time.Duration(interval) * time.Second)
+		}
+	}()
+}
+
+// closeFinishedDays closes each day after the latest close, or from the
+// first real transaction when nothing is closed yet, once
+// EOD_CLOSE_DELAY_MIN has passed since it ended. Days without transactions
+// are closed too, so anything written into them later is caught.
+func (app *App) closeFinishedDays(ctx context.Context) error {
+	var latest, first sql.NullTime
+	err := app.jobPool().QueryRowContext(ctx, `
+		SELECT (SELECT MAX(day)::TIMESTAMP FROM daily_closes),
+			(SELECT DATE_TRUNC('day', MIN(created_at)) FROM transactions WHERE session_id IS NULL)
+	`).Scan(&latest, &first)
+	if err != nil {
+		return err
+	}
+	next := first.Time
+	if latest.Valid {
+		lastClosedDay.Set(float64(latest.Time.Unix()))
+		next = latest.Time.AddDate(0, 0, 1)
+	} else if !first.Valid {
+		return nil
+	}
+	delay := time.Duration(app.config.EODCloseDelayMin) * time.Minute
+	for day := next.UTC(); !day.Add(24*time.Hour + delay).After(time.Now()); day = day.AddDate(0, 0, 1) {
+		if _, _, err := app.closeDay(ctx, day); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeDay closes day and returns its close, and whether this call made it.
+// The day's transactions are copied in a single statement under the ledger
+// lock, so the snapshot is consistent with the hash chain, and the totals
+// are computed from that copy.
+func (app *App) closeDay(ctx context.Context, day time.Time) (*DailyClose, bool, error) {
+	tx, err := app.jobPool().BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, ledgerLockID); err != nil {
+		return nil, false, err
+	}
+	if existing, err := app.getDailyClose(ctx, tx, day); err != sql.ErrNoRows {
+		return existing, false, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO daily_close_entries (day, transaction_id, status, amount, from_account, to_account, hash, chain_seq)
+		SELECT $1::DATE, id, status, amount, from_account, to_account, hash, chain_seq
+		FROM transactions
+		WHERE session_id IS NULL AND created_at >= $1::DATE AND created_at < $1::DATE + 1
+	`, day); err != nil {
+		return nil, false, err
+	}
+	var debits, credits float64
+	var head sql.NullString
+	var digest string
+	if err := tx.QueryRowContext(ctx, `
+		SELECT
+			COALESCE(SUM(amount) FILTER (WHERE status IN `+settledStatuses+` AND from_account <> ''), 0),
+			COALESCE(SUM(amount) FILTER (WHERE status IN `+settledStatuses+` AND to_account <> ''), 0),
+			(SELECT hash FROM daily_close_entries WHERE day = $1::DATE AND hash IS NOT NULL ORDER BY chain_seq DESC LIMIT 1),
+			ENCODE(SHA256(CONVERT_TO(COALESCE(STRING_AGG(transaction_id || '|' || status || '|' || amount, ',' ORDER BY transaction_id), ''), 'UTF8')), 'hex')
+		FROM daily_close_entries
+		WHERE day = $1::DATE
+	`, day).Scan(&debits, &credits, &head, &digest); err != nil {
+		return nil, false, err
+	}
+	balanced := math.Round(debits*100) == math.Round(credits*100)
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO daily_closes (day, transactions, settled, debits, credits, balanced, ledger_head, digest)
+		SELECT $1::DATE, COUNT(*), COUNT(*) FILTER (WHERE status IN `+settledStatuses+`), $2, $3, $4, $5, $6
+		FROM daily_close_entries
+		WHERE day = $1::DATE
+	`, day, debits, credits, balanced, head, digest); err != nil {
+		return nil, false, err
+	}
+	closed, err := app.getDailyClose(ctx, tx, day)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+
+	lastClosedDay.Set(float64(day.Unix()))
+	level, message := "info", "Day closed"
+	if !closed.Balanced {
+		level, message = "error", "Day closed with debits not equal to credits"
+	}
+	app.event(level, EventDayClosed, closed.Day, message, map[string]interface{}{
+		"transactions": closed.Transactions,
+		"debits":       closed.Debits,
+		"credits":      closed.Credits,
+		"balanced":     closed.Balanced,
+	})
+	return closed, true, nil
+}
+
+// dailyCloseQuery selects closes as scanDailyClose reads them.
+const dailyCloseQuery = `
+	SELECT TO_CHAR(c.day, 'YYYY-MM-DD'), c.closed_at, c.transactions, c.settled, c.debits, c.credits, c.balanced,
+		COALESCE(c.ledger_head, ''), c.digest, (SELECT COUNT(*) FROM close_exceptions e WHERE e.day = c.day)
+	FROM daily_closes c`
+
+func scanDailyClose(row interface{ Scan(...interface{}) error }) (*DailyClose, error) {
+	var d DailyClose
+	err := row.Scan(&d.Day, &d.ClosedAt, &d.Transactions, &d.Settled, &d.Debits, &d.Credits, &d.Balanced, &d.LedgerHead, &d.Digest, &d.Exceptions)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (app *App) getDailyClose(ctx context.Context, q queryRower, day time.Time) (*DailyClose, error) {
+	return scanDailyClose(q.QueryRowContext(ctx, dailyCloseQuery+` WHERE c.day = $1::DATE`, day))
+}
+
+// closeExceptionChecks find changes to the closed days from $1 on. Only
+// changes that break the day's totals count as modifications: a refund
+// turns its payment from success into refunded, both settled, and posts as
+// a transaction of its own on the day it is made.
+var closeExceptionChecks = []struct {
+	kind  string
+	query string
+}{
+	{"inserted", `
+		SELECT c.day, t.id, jsonb_build_object('current', jsonb_build_object('status', t.status, 'amount', t.amount))
+		FROM daily_closes c
+		JOIN transactions t ON t.session_id IS NULL AND t.created_at >= c.day AND t.created_at < c.day + 1
+		LEFT JOIN daily_close_entries e ON e.day = c.day AND e.transaction_id = t.id
+		WHERE c.day >= $1::DATE AND e.transaction_id IS NULL`},
+	{"modified", `
+		SELECT e.day, e.transaction_id, jsonb_build_object(
+			'closed', jsonb_build_object('status', e.status, 'amount', e.amount, 'from_account', e.from_account, 'to_account', e.to_account),
+			'current', jsonb_build_object('status', t.status, 'amount', t.amount, 'from_account', t.from_account, 'to_account', t.to_account))
+		FROM daily_close_entries e
+		JOIN transactions t ON t.id = e.transaction_id
+		WHERE e.day >= $1::DATE AND (
+			(t.status IN ` + settledStatuses + `) <> (e.status IN ` + settledStatuses + `)
+			OR t.amount <> e.amount
+			OR t.from_account IS DISTINCT FROM e.from_account
+			OR t.to_account IS DISTINCT FROM e.to_account)`},
+	{"removed", `
+		SELECT e.day, e.transaction_id, jsonb_build_object('closed', jsonb_build_object('status', e.status, 'amount', e.amount))
+		FROM daily_close_entries e
+		LEFT JOIN transactions t ON t.id = e.transaction_id
+		WHERE e.day >= $1::DATE AND t.id IS NULL`},
+}
+
+// detectCloseExceptions records new changes to days closed within
+// EOD_EXCEPTION_LOOKBACK_DAYS and returns how many it found. A transaction
+// is recorded once per kind of change, with the values first seen.
+func (app *App) detectCloseExceptions(ctx context.Context) (int, error) {
+	since := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -app.config.EODExceptionLookbackDays)
+	found := map[string]map[string]int{}
+	total := 0
+	for _, check := range closeExceptionChecks {
+		rows, err := app.jobPool().QueryContext(ctx, `
+			INSERT INTO close_exceptions (day, transaction_id, kind, detail)
+			SELECT day, id, '`+check.kind+`', detail FROM (`+check.query+`) AS found (day, id, detail)
+			ON CONFLICT (day, transaction_id, kind) DO NOTHING
+			RETURNING TO_CHAR(day, 'YYYY-MM-DD')
+		`, since)
+		if err != nil {
+			return total, err
+		}
+		for rows.Next() {
+			var day string
+			if err := rows.Scan(&day); err != nil {
+				rows.Close()
+				return total, err
+			}
+			if found[day] == nil {
+				found[day] = map[string]int{}
+			}
+			found[day][check.kind]++
+			closeExceptionsTotal.WithLabelValues(check.kind).Inc()
+			total++
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return total, err
+		}
+	}
+	for day, kinds := range found {
+		attrs := map[string]interface{}{}
+		for kind, n := range kinds {
+			attrs[kind] = n
+		}
+		app.event("warn", EventCloseException, day, "Closed day changed after its close", attrs)
+	}
+	return total, nil
+}
+
+// closeDayParam parses the :day path parameter as a UTC date.
+func closeDayParam(c *gin.Context) (time.Time, bool) {
+	day, err := time.Parse(dayLayout, c.Param("day"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "day must be a YYYY-MM-DD date"})
+		return time.Time{}, false
+	}
+	return day, true
+}
+
+// listDailyClosesHandler returns closes newest first, optionally between
+// ?since and ?until.
+func (app *App) listDailyClosesHandler(c *gin.Context) {
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	limit, err := pageParam(c, "limit", defaultPageLimit, maxPageLimit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	qb := store.NewQueryBuilder(map[string]string{"day": "c.day"}).OrderBy("day", true)
+	since, hasSince, err := parseTimeParam(c, "since", false)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	until, hasUntil, err := parseTimeParam(c, "until", true)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if hasSince {
+		qb.Where("day", store.OpGte, since)
+	}
+	if hasUntil {
+		qb.Where("day", store.OpLt, until)
+	}
+	limitArg := qb.Arg(limit)
+	where, args, err := qb.WhereClause()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rows, err := app.readPool().QueryContext(c.Request.Context(), dailyCloseQuery+where+qb.OrderClause()+` LIMIT `+limitArg, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	closes := []DailyClose{}
+	for rows.Next() {
+		d, err := scanDailyClose(rows)
+		if err != nil {
+			continue
+		}
+		closes = append(closes, *d)
+	}
+	c.JSON(http.StatusOK, gin.H{"data": closes})
+}
+
+func (app *App) getDailyCloseHandler(c *gin.Context) {
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	day, ok := closeDayParam(c)
+	if !ok {
+		return
+	}
+	closed, err := app.getDailyClose(c.Request.Context(), app.readPool(), day)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Day not closed"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, closed)
+}
+
+// closeDayHandler closes a finished day right away, without waiting for
+// the job. Closing a day that is already closed is a 409 with its close.
+func (app *App) closeDayHandler(c *gin.Context) {
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	day, ok := closeDayParam(c)
+	if !ok {
+		return
+	}
+	if day.Add(24 * time.Hour).After(time.Now()) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Day is not over yet"})
+		return
+	}
+	closed, created, err := app.closeDay(c.Request.Context(), day)
+	if err != nil {
+		app.logCtx(c.Request.Context(), "error", "Failed to close day", map[string]interface{}{"day": c.Param("day"), "error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !created {
+		c.JSON(http.StatusConflict, gin.H{"error": "Day already closed", "close": closed})
+		return
+	}
+	c.JSON(http.StatusCreated, closed)
+}
+
+// listCloseExceptionsHandler returns exceptions newest first, optionally
+// for one ?day or ?kind.
+func (app *App) listCloseExceptionsHandler(c *gin.Context) {
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	limit, err := pageParam(c, "limit", defaultPageLimit, maxPageLimit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	qb := store.NewQueryBuilder(map[string]string{"day": "day", "kind": "kind", "id": "id"}).OrderBy("id", true)
+	if raw := c.Query("day"); raw != "" {
+		day, err := time.Parse(dayLayout, raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "day must be a YYYY-MM-DD date"})
+			return
+		}
+		qb.Where("day", store.OpEq, day)
+	}
+	if kind := c.Query("kind"); kind != "" {
+		qb.Where("kind", store.OpEq, kind)
+	}
+	limitArg := qb.Arg(limit)
+	where, args, err := qb.WhereClause()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rows, err := app.readPool().QueryContext(c.Request.Context(), `
+		SELECT id, TO_CHAR(day, 'YYYY-MM-DD'), transaction_id, kind, detail, detected_at
+		FROM close_exceptions`+where+qb.OrderClause()+`
+		LIMIT `+limitArg, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	exceptions := []CloseException{}
+	for rows.Next() {
+		var e CloseException
+		var detail []byte
+		if err := rows.Scan(&e.ID, &e.Day, &e.TransactionID, &e.Kind, &detail, &e.DetectedAt); err != nil {
+			continue
+		}
+		e.Detail = detail
+		exceptions = append(exceptions, e)
+	}
+	c.JSON(http.StatusOK, gin.H{"data": exceptions})
+}
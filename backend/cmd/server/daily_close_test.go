@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/infrasage/payflow/internal/dbtest"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type closeTxn struct {
+	id, status, from, to, day string
+	amount                    float64
+}
+
+// fakeCloseDB is transactions, daily_close_entries, daily_closes and
+// close_exceptions, with the queries' SQL done in Go.
+type fakeCloseDB struct {
+	txns       map[string]closeTxn
+	entries    map[string][]closeTxn
+	closes     map[string]DailyClose
+	exceptions map[string]bool
+}
+
+func newFakeCloseDB(txns ...closeTxn) *fakeCloseDB {
+	f := &fakeCloseDB{txns: map[string]closeTxn{}, entries: map[string][]closeTxn{}, closes: map[string]DailyClose{}, exceptions: map[string]bool{}}
+	for _, t := range txns {
+		f.txns[t.id] = t
+	}
+	return f
+}
+
+// isSettled is settledStatuses in Go.
+func isSettled(status string) bool {
+	return status == "success" || status == "partially_refunded" || status == "refunded"
+}
+
+func (f *fakeCloseDB) run(q dbtest.Query) (*dbtest.Rows, error) {
+	day := func() string { return q.Args[0].(time.Time).Format(dayLayout) }
+	switch {
+	case q.HasPrefix("SELECT pg_advisory_xact_lock"):
+		return dbtest.None(), nil
+	case q.HasPrefix("SELECT TO_CHAR(c.day"):
+		rows := dbtest.NewRows("day", "closed_at", "transactions", "settled", "debits", "credits", "balanced", "ledger_head", "digest", "exceptions")
+		if d, ok := f.closes[day()]; ok {
+			var exceptions int64
+			for key := range f.exceptions {
+				if strings.HasPrefix(key, d.Day+"|") {
+					exceptions++
+				}
+			}
+			rows.Add(d.Day, d.ClosedAt, d.Transactions, d.Settled, d.Debits, d.Credits, d.Balanced, "", d.Digest, exceptions)
+		}
+		return rows, nil
+	case q.HasPrefix("INSERT INTO daily_close_entries"):
+		for _, t := range f.txns {
+			if t.day == day() {
+				f.entries[t.day] = append(f.entries[t.day], t)
+			}
+		}
+		return dbtest.Affected(int64(len(f.entries[day()]))), nil
+	case q.Contains("FROM daily_close_entries WHERE day = $1::DATE") && q.HasPrefix("SELECT COALESCE(SUM(amount)"):
+		var debits, credits float64
+		for _, e := range f.entries[day()] {
+			if isSettled(e.status) && e.from != "" {
+				debits += e.amount
+			}
+			if isSettled(e.status) && e.to != "" {
+				credits += e.amount
+			}
+		}
+		return dbtest.NewRows("debits", "credits", "head", "digest").Add(debits, credits, nil, "digest-"+day()), nil
+	case q.HasPrefix("INSERT INTO daily_closes"):
+		d := DailyClose{Day: day(), ClosedAt: time.Now(), Debits: q.Args[1].(float64), Credits: q.Args[2].(float64), Balanced: q.Args[3].(bool), Digest: q.String(5)}
+		for _, e := range f.entries[d.Day] {
+			d.Transactions++
+			if isSettled(e.status) {
+				d.Settled++
+			}
+		}
+		f.closes[d.Day] = d
+		return dbtest.Affected(1), nil
+	case q.HasPrefix("INSERT INTO close_exceptions"):
+		rows := dbtest.NewRows("day")
+		found := func(day, id, kind string) {
+			if key := day + "|" + id + "|" + kind; !f.exceptions[key] {
+				f.exceptions[key] = true
+				rows.Add(day)
+			}
+		}
+		for day := range f.closes {
+			closed := map[string]closeTxn{}
+			for _, e := range f.entries[day] {
+				closed[e.id] = e
+			}
+			for _, t := range f.txns {
+				if _, ok := closed[t.id]; t.day == day && !ok && q.Contains("'inserted'") {
+					found(day, t.id, "inserted")
+				}
+			}
+			for _, e := range closed {
+				t, ok := f.txns[e.id]
+				switch {
+				case !ok && q.Contains("'removed'"):
+					found(day, e.id, "removed")
+				case ok && q.Contains("'modified'") && (isSettled(t.status) != isSettled(e.status) || t.amount != e.amount || t.from != e.from || t.to != e.to):
+					found(day, e.id, "modified")
+				}
+			}
+		}
+		return rows, nil
+	}
+	return nil, dbtest.Unexpected(q)
+}
+
+func TestCloseDay(t *testing.T) {
+	var logs bytes.Buffer
+	app := newTestApp(t, func(c *Config) { c.AdminToken = "secret-token" })
+	app.logs = newLogger("info", "json", &logs)
+	fake := newFakeCloseDB(
+		closeTxn{id: "txn-1", status: "success", from: "ACC-1", to: "ACC-2", day: "2026-03-01", amount: 10},
+		closeTxn{id: "txn-2", status: "failed", from: "ACC-1", to: "ACC-2", day: "2026-03-01", amount: 5},
+		closeTxn{id: "txn-3", status: "refunded", from: "ACC-3", to: "ACC-2", day: "2026-03-01", amount: 2.5},
+		closeTxn{id: "txn-4", status: "success", from: "ACC-1", day: "2026-03-02", amount: 7},
+	)
+	app.db = dbtest.New(fake.run).Open(t)
+	r := app.newRouter()
+	admin := map[string]string{"X-Admin-Token": "secret-token"}
+
+	if w := serve(r, http.MethodPost, "/api/admin/ledger/closes/March-1", nil, admin); w.Code != http.StatusBadRequest {
+		t.Errorf("malformed day: %d %s", w.Code, w.Body)
+	}
+	today := time.Now().UTC().Format(dayLayout)
+	if w := serve(r, http.MethodPost, "/api/admin/ledger/closes/"+today, nil, admin); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("closing today: %d %s", w.Code, w.Body)
+	}
+
+	w := serve(r, http.MethodPost, "/api/admin/ledger/closes/2026-03-01", nil, admin)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("close: %d %s", w.Code, w.Body)
+	}
+	var closed DailyClose
+	json.Unmarshal(w.Body.Bytes(), &closed)
+	if closed.Day != "2026-03-01" || closed.Transactions != 3 || closed.Settled != 2 || closed.Debits != 12.5 || closed.Credits != 12.5 || !closed.Balanced {
+		t.Errorf("closed %+v, want 3 transactions, 2 settled and 12.50 each way", closed)
+	}
+	if w := serve(r, http.MethodPost, "/api/admin/ledger/closes/2026-03-01", nil, admin); w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), `"digest":"digest-2026-03-01"`) {
+		t.Errorf("closing again: %d %s, want 409 with the existing close", w.Code, w.Body)
+	}
+	if w := serve(r, http.MethodGet, "/api/admin/ledger/closes/2026-03-01", nil, admin); w.Code != http.StatusOK {
+		t.Errorf("get close: %d %s", w.Code, w.Body)
+	}
+	if w := serve(r, http.MethodGet, "/api/admin/ledger/closes/2026-03-03", nil, admin); w.Code != http.StatusNotFound {
+		t.Errorf("get open day: %d %s", w.Code, w.Body)
+	}
+
+	// A payment missing its credit leg leaves the day unbalanced.
+	logs.Reset()
+	w = serve(r, http.MethodPost, "/api/admin/ledger/closes/2026-03-02", nil, admin)
+	json.Unmarshal(w.Body.Bytes(), &closed)
+	if w.Code != http.StatusCreated || closed.Balanced || closed.Debits != 7 || closed.Credits != 0 {
+		t.Errorf("one-legged day: %d %+v, want an unbalanced close", w.Code, closed)
+	}
+	if !strings.Contains(logs.String(), `"level":"error"`) || !strings.Contains(logs.String(), `"event_type":"`+EventDayClosed+`"`) {
+		t.Errorf("unbalanced close not logged as an error: %s", logs.String())
+	}
+}
+
+func TestDetectCloseExceptions(t *testing.T) {
+	var logs bytes.Buffer
+	app := newTestApp(t, func(c *Config) { c.EODExceptionLookbackDays = 7 })
+	app.logs = newLogger("info", "json", &logs)
+	day := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -1)
+	fake := newFakeCloseDB(
+		closeTxn{id: "txn-1", status: "success", from: "ACC-1", to: "ACC-2", day: day.Format(dayLayout), amount: 10},
+		closeTxn{id: "txn-2", status: "success", from: "ACC-1", to: "ACC-2", day: day.Format(dayLayout), amount: 20},
+		closeTxn{id: "txn-3", status: "success", from: "ACC-1", to: "ACC-2", day: day.Format(dayLayout), amount: 30},
+	)
+	app.db = dbtest.New(fake.run).Open(t)
+	ctx := context.Background()
+	if _, _, err := app.closeDay(ctx, day); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := app.detectCloseExceptions(ctx); n != 0 || err != nil {
+		t.Fatalf("untouched day: %d exceptions, err %v", n, err)
+	}
+
+	// A refund keeps its payment settled, so it isn't a modification.
+	txn := fake.txns["txn-1"]
+	txn.status = "refunded"
+	fake.txns["txn-1"] = txn
+	txn = fake.txns["txn-2"]
+	txn.amount = 2000
+	fake.txns["txn-2"] = txn
+	delete(fake.txns, "txn-3")
+	fake.txns["txn-4"] = closeTxn{id: "txn-4", status: "success", from: "ACC-9", to: "ACC-2", day: day.Format(dayLayout), amount: 99}
+	before := map[string]float64{}
+	for _, kind := range []string{"inserted", "modified", "removed"} {
+		before[kind] = testutil.ToFloat64(closeExceptionsTotal.WithLabelValues(kind))
+	}
+
+	if n, err := app.detectCloseExceptions(ctx); n != 3 || err != nil {
+		t.Fatalf("found %d exceptions, err %v; want 3", n, err)
+	}
+	for _, kind := range []string{"inserted", "modified", "removed"} {
+		if d := testutil.ToFloat64(closeExceptionsTotal.WithLabelValues(kind)) - before[kind]; d != 1 {
+			t.Errorf("%s exceptions counted %v, want 1", kind, d)
+		}
+	}
+	for _, key := range []string{"|txn-4|inserted", "|txn-2|modified", "|txn-3|removed"} {
+		if !fake.exceptions[day.Format(dayLayout)+key] {
+			t.Errorf("no exception %s in %v", key, fake.exceptions)
+		}
+	}
+	if !strings.Contains(logs.String(), `"event_type":"`+EventCloseException+`"`) {
+		t.Errorf("no close exception event in %s", logs.String())
+	}
+	if n, err := app.detectCloseExceptions(ctx); n != 0 || err != nil {
+		t.Errorf("second check found %d, err %v; each change is recorded once", n, err)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/infrasage/payflow/internal/store"
+)
+
+// dashboardFraudAlerts is how many recent fraud alerts the dashboard shows.
+const dashboardFraudAlerts = 10
+
+var errDashboardDatabase = errors.New("database error")
+
+// DashboardSection describes how fresh one part of the dashboard payload is.
+// TTLSec is how long the server caches it (0 when it isn't cached); clients
+// gain nothing by polling more often.
+type DashboardSection struct {
+	TTLSec      int       `json:"ttl_sec"`
+	Cached      bool      `json:"cached"`
+	GeneratedAt time.Time `json:"generated_at"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// dashboardPart is a section's JSON and when it was built, as stored in the
+// read cache.
+type dashboardPart struct {
+	Data        json.RawMessage `json:"data"`
+	GeneratedAt time.Time       `json:"generated_at"`
+}
+
+// dashboardSection builds one section with load, or serves it from the read
+// cache when it was built less than ttl ago. Keys include the cache
+// generation, so a new transaction invalidates them like GET /api/stats.
+// Errors are reported in the section rather than failing the whole payload.
+func (app *App) dashboardSection(c *gin.Context, meta map[string]DashboardSection, name string, ttl int, load func(ctx context.Context) (interface{}, error)) json.RawMessage {
+	ctx := c.Request.Context()
+	rc := app.readCache
+	if c.GetHeader("X-Feature-Overrides") != "" || debugForced(ctx) {
+		rc = nil
+	}
+	if rc == nil {
+		ttl = 0
+	}
+	key := ""
+	if ttl > 0 {
+		if gen, err := cacheGeneration(ctx, rc); err == nil {
+			sum := sha256.Sum256([]byte(sessionID(c) + "|" + app.requestLocale(c) + "|" + c.Request.URL.RawQuery))
+			key = "payflow:dashboard:" + strconv.FormatInt(gen, 10) + ":" + name + ":" + hex.EncodeToString(sum[:16])
+		}
+	}
+	if key != "" {
+		if raw, err := rc.Get(ctx, key); err == nil {
+			var part dashboardPart
+			if json.Unmarshal(raw, &part) == nil {
+				atomic.AddInt64(&app.cacheHits, 1)
+				meta[name] = DashboardSection{TTLSec: ttl, Cached: true, GeneratedAt: part.GeneratedAt}
+				return part.Data
+			}
+		}
+		atomic.AddInt64(&app.cacheMisses, 1)
+	}
+
+	section := DashboardSection{TTLSec: ttl, GeneratedAt: time.Now().UTC()}
+	v, err := load(ctx)
+	if err != nil {
+		section.Error = err.Error()
+		meta[name] = section
+		return json.RawMessage("null")
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		section.Error = "encoding failed"
+		meta[name] = section
+		return json.RawMessage("null")
+	}
+	meta[name] = section
+	if key != "" {
+		if raw, err := json.Marshal(dashboardPart{Data: data, GeneratedAt: section.GeneratedAt}); err == nil {
+			rc.Set(ctx, key, raw, time.Duration(ttl)*time.Second)
+		}
+	}
+	return data
+}
+
+// chaosStatus is the bug injection in effect for the request, including any
+// X-Feature-Overrides.
+func chaosStatus(config *Config) gin.H {
+	return gin.H{
+		"active":             anyChaos(config),
+		"oom":                config.InjectOOM,
+		"latency_ms":         config.InjectLatencyMs,
+		"error_rate":         config.InjectErrorRate,
+		"cpu_burn":           config.InjectCPUBurn,
+		"panic":              config.InjectPanic,
+		"db_timeout":         config.InjectDBTimeout,
+		"feature_new_cache":  config.FeatureNewCache,
+		"replication_lag_ms": config.InjectReplicationLagMs,
+		"slow_query_rate":    config.InjectSlowQueryRate,
+		"slow_query_ms":      config.InjectSlowQueryMs,
+		"goroutine_leak":     config.InjectGoroutineLeakPerSec,
+		"pool_exhaustion":    config.InjectPoolExhaustion,
+		"drop_rate":          config.InjectDropRate,
+	}
+}
+
+// getDashboardHandler returns everything the dashboard polls for in one
+// payload: stats, the latest transactions (?limit, default 50), recent fraud
+// alerts and chaos status. Fraud alerts and chaos status are admin-only, as
+// in GraphQL and GET /api/config, and null for other callers.
+func (app *App) getDashboardHandler(c *gin.Context) {
+	limit, err := pageParam(c, "limit", defaultPageLimit, maxPageLimit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	session := sessionID(c)
+	config := app.cfg(c)
+	admin := app.isAdminRequest(c)
+	meta := map[string]DashboardSection{}
+
+	stats := app.dashboardSection(c, meta, "stats", config.DashboardStatsTTLSec, func(ctx context.Context) (interface{}, error) {
+		stats := app.computeStats(ctx, session)
+		return gin.H{
+			"revenue":      newMoney(stats.RevenueMinor, app.config.Currency, app.requestLocale(c)),
+			"transactions": stats.Transactions,
+			"success_rate": stats.SuccessRate(),
+			"avg_latency":  45, // Mock for now, as in GET /api/stats
+		}, nil
+	})
+
+	transactions := app.dashboardSection(c, meta, "transactions", config.DashboardTransactionsTTLSec, func(ctx context.Context) (interface{}, error) {
+		if app.transactions == nil {
+			return []Transaction{}, nil
+		}
+		filter := store.TransactionFilter{SessionID: session, Limit: limit}
+		app.applyReplicationLag(c, &filter)
+		page, err := app.listTransactions(ctx, filter)
+		if err != nil {
+			return nil, errDashboardDatabase
+		}
+		return page.Data, nil
+	})
+
+	alerts := json.RawMessage("null")
+	chaos := json.RawMessage("null")
+	if admin {
+		alerts = app.dashboardSection(c, meta, "fraud_alerts", config.DashboardFraudTTLSec, func(ctx context.Context) (interface{}, error) {
+			alerts, err := app.listFraudAlerts(ctx, session, dashboardFraudAlerts)
+			if errors.Is(err, errDatabaseUnavailable) {
+				return nil, err
+			}
+			if err != nil {
+				return nil, errDashboardDatabase
+			}
+			return alerts, nil
+		})
+		// Chaos status is read from memory, so there is nothing to cache.
+		chaos = app.dashboardSection(c, meta, "chaos", 0, func(context.Context) (interface{}, error) {
+			return chaosStatus(config), nil
+		})
+	}
+
+	app.debug(c.Request.Context(), "Dashboard assembled", meta)
+	c.JSON(http.StatusOK, gin.H{
+		"stats":        stats,
+		"transactions": transactions,
+		"fraud_alerts": alerts,
+		"chaos":        chaos,
+		"sections":     meta,
+	})
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var datasetName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// Dataset is a named snapshot of the live (non demo session) data and the
+// account balances it produced, used to reset a deployment to a known-good
+// state between demos.
+type Dataset struct {
+	Name         string    `json:"name"`
+	CreatedAt    time.Time `json:"created_at"`
+	Transactions int       `json:"transactions"`
+	AuditEntries int       `json:"audit_entries"`
+	Accounts     int       `json:"accounts"`
+}
+
+func (app *App) listDatasetsHandler(c *gin.Context) {
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	rows, err := app.db.QueryContext(c.Request.Context(), `
+		SELECT name, created_at, jsonb_array_length(transactions), jsonb_array_length(audit), jsonb_array_length(accounts)
+		FROM datasets ORDER BY name
+	`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	datasets := []Dataset{}
+	for rows.Next() {
+		var d Dataset
+		if err := rows.Scan(&d.Name, &d.CreatedAt, &d.Transactions, &d.AuditEntries, &d.Accounts); err != nil {
+			continue
+		}
+		datasets = append(datasets, d)
+	}
+	c.JSON(http.StatusOK, datasets)
+}
+
+// snapshotDatasetHandler stores the current transactions and their audit
+// history under a name. Existing names are only replaced with ?overwrite=true.
+func (app *App) snapshotDatasetHandler(c *gin.Context) {
+	var req struct {
+		Name string `json:"name" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !datasetName.MatchString(req.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name must be lowercase letters, digits, '-' or '_'"})
+		return
+	}
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+
+	conflict := `ON CONFLICT (name) DO NOTHING`
+	if c.Query("overwrite") == "true" {
+		conflict = `ON CONFLICT (name) DO UPDATE SET created_at = EXCLUDED.created_at,
+			transactions = EXCLUDED.transactions, audit = EXCLUDED.audit, accounts = EXCLUDED.accounts`
+	}
+	var d Dataset
+	err := app.db.QueryRowContext(c.Request.Context(), `
+		INSERT INTO datasets (name, transactions, audit, accounts)
+		VALUES ($1,
+			(SELECT COALESCE(jsonb_agg(t ORDER BY t.chain_seq), '[]') FROM transactions t WHERE t.session_id IS NULL),
+			(SELECT COALESCE(jsonb_agg(a ORDER BY a.id), '[]') FROM transaction_audit a
+			 WHERE a.transaction_id IN (SELECT id FROM transactions WHERE session_id IS NULL)),
+			(SELECT COALESCE(jsonb_agg(acc ORDER BY acc.id), '[]') FROM accounts acc WHERE acc.session_id IS NULL))
+		`+conflict+`
+		RETURNING name, created_at, jsonb_array_length(transactions), jsonb_array_length(audit), jsonb_array_length(accounts)
+	`, req.Name).Scan(&d.Name, &d.CreatedAt, &d.Transactions, &d.AuditEntries, &d.Accounts)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusConflict, gin.H{"error": "Dataset already exists; pass ?overwrite=true to replace it"})
+		return
+	}
+	if err != nil {
+		app.logCtx(c.Request.Context(), "error", "Failed to snapshot dataset", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	app.logCtx(c.Request.Context(), "info", "Dataset snapshot taken", map[string]interface{}{
+		"dataset":      d.Name,
+		"transactions": d.Transactions,
+		"actor":        adminActor(c),
+	})
+	c.JSON(http.StatusCreated, d)
+}
+
+// restoreDatasetHandler replaces the live transactions, audit history and
+// accounts with a snapshot. Rows come back byte-for-byte, chain_seq included, so the
+// ledger hash chain verifies after a restore. Demo session data is untouched.
+func (app *App) restoreDatasetHandler(c *gin.Context) {
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	name := c.Param("name")
+	ctx := c.Request.Context()
+
+	tx, err := app.db.BeginTx(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM datasets WHERE name = $1)`, name).Scan(&exists); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dataset not found"})
+		return
+	}
+
+	steps := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`SELECT pg_advisory_xact_lock($1)`, []interface{}{ledgerLockID}},
+		{`DELETE FROM transaction_audit WHERE transaction_id IN (SELECT id FROM transactions WHERE session_id IS NULL)`, nil},
+		{`DELETE FROM transactions WHERE session_id IS NULL`, nil},
+		{`INSERT INTO transactions
+			SELECT * FROM jsonb_populate_recordset(NULL::transactions, (SELECT transactions FROM datasets WHERE name = $1))`, []interface{}{name}},
+		{`INSERT INTO transaction_audit
+			SELECT * FROM jsonb_populate_recordset(NULL::transaction_audit, (SELECT audit FROM datasets WHERE name = $1))`, []interface{}{name}},
+		{`DELETE FROM accounts WHERE session_id IS NULL`, nil},
+		{`INSERT INTO accounts
+			SELECT * FROM jsonb_populate_recordset(NULL::accounts, (SELECT accounts FROM datasets WHERE name = $1))`, []interface{}{name}},
+		{`SELECT setval(pg_get_serial_sequence('transactions', 'chain_seq'), COALESCE((SELECT MAX(chain_seq) FROM transactions), 0) + 1, false)`, nil},
+		{`SELECT setval(pg_get_serial_sequence('transaction_audit', 'id'), COALESCE((SELECT MAX(id) FROM transaction_audit), 0) + 1, false)`, nil},
+	}
+	for _, step := range steps {
+		if _, err := tx.ExecContext(ctx, step.query, step.args...); err != nil {
+			app.logCtx(ctx, "error", "Failed to restore dataset", map[string]interface{}{"dataset": name, "error": err.Error()})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+	var restored int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM transactions WHERE session_id IS NULL`).Scan(&restored); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	app.invalidateReadCache(ctx)
+
+	app.logCtx(ctx, "warn", "Dataset restored", map[string]interface{}{
+		"dataset":      name,
+		"transactions": restored,
+		"actor":        adminActor(c),
+	})
+	c.JSON(http.StatusOK, gin.H{"dataset": name, "transactions": restored})
+}
+
+func (app *App) deleteDatasetHandler(c *gin.Context) {
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	res, err := app.db.ExecContext(c.Request.Context(), `DELETE FROM datasets WHERE name = $1`, c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dataset not found"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Categories database errors are sorted into. Retry decisions and the
+// payflow_db_errors_total label both use them, so keep the set small.
+const (
+	dbErrConstraint    = "constraint_violation"
+	dbErrSerialization = "serialization_failure"
+	dbErrConnection    = "connection"
+	dbErrTimeout       = "timeout"
+	dbErrCanceled      = "canceled"
+	dbErrOther         = "other"
+)
+
+// dbSerializationRetries is how many times a transaction that lost a
+// serialization conflict or deadlock is run again before giving up.
+const dbSerializationRetries = 3
+
+var dbErrorsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "payflow_db_errors_total",
+		Help: "Failed database statements by error category",
+	},
+	[]string{"category"},
+)
+
+// errNoDatabase means the database was never reached at startup.
+var errNoDatabase = errors.New("database not initialized")
+
+// classifyDBError sorts err into one of the dbErr categories, or "" for nil.
+// Postgres errors go by SQLSTATE class; driver and network errors that never
+// got an answer from Postgres count as connection or timeout errors.
+func classifyDBError(err error) string {
+	if err == nil {
+		return ""
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch {
+		case pqErr.Code.Class() == "23":
+			return dbErrConstraint
+		case pqErr.Code.Class() == "40":
+			// 40001 serialization_failure and 40P01 deadlock_detected.
+			return dbErrSerialization
+		case pqErr.Code == "57014", pqErr.Code == "55P03":
+			// query_canceled (statement_timeout) and lock_not_available
+			// (lock_timeout).
+			return dbErrTimeout
+		case pqErr.Code.Class() == "08", pqErr.Code.Class() == "53", pqErr.Code.Class() == "57":
+			// Connection exceptions, too many connections, and the server
+			// shutting down or restarting.
+			return dbErrConnection
+		}
+		return dbErrOther
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return dbErrTimeout
+	case errors.Is(err, context.Canceled):
+		return dbErrCanceled
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return dbErrTimeout
+	case errors.As(err, &netErr),
+		errors.Is(err, errNoDatabase),
+		errors.Is(err, driver.ErrBadConn),
+		errors.Is(err, sql.ErrConnDone),
+		errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF):
+		return dbErrConnection
+	}
+	return dbErrOther
+}
+
+// dbErrorRetryable reports whether the same statement can be expected to
+// succeed later: after a conflict, or once the database is reachable again.
+// Constraint violations and unknown errors would fail the same way forever.
+func dbErrorRetryable(err error) bool {
+	switch classifyDBError(err) {
+	case dbErrSerialization, dbErrConnection, dbErrTimeout:
+		return true
+	}
+	return false
+}
+
+func countDBError(err error) {
+	if err != nil {
+		dbErrorsTotal.WithLabelValues(classifyDBError(err)).Inc()
+	}
+}
+
+// retrySerialization runs fn, a whole database transaction, again when it
+// fails with a serialization failure or deadlock, up to
+// dbSerializationRetries times with a short growing pause.
+func retrySerialization(ctx context.Context, fn func() error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = fn()
+		if classifyDBError(err) != dbErrSerialization || attempt == dbSerializationRetries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Duration(attempt+1) * 10 * time.Millisecond):
+		}
+	}
+}
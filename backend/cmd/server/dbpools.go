@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var dbPoolInUse = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "payflow_db_pool_in_use",
+		Help: "Connections in use per database pool (oltp, read, job)",
+	},
+	[]string{"pool"},
+)
+
+// The database is reached through three pools so one kind of load can't
+// starve another of connections: app.db (DB_POOL_SIZE) carries payment writes
+// and everything else on the request path, the read pool
+// (DB_READ_POOL_SIZE) carries listing and reporting queries, and the job pool
+// (DB_JOB_POOL_SIZE) carries background work such as fraud analysis, subject
+// exports and session reaping. All three share one connection string.
+func (app *App) openPools(connStr string) error {
+	var err error
+	if app.readDB, err = openMeteredDB(connStr, app.logQuery, app.queryTimeout(), app.slowQueryDelay); err != nil {
+		return err
+	}
+	app.readDB.SetMaxOpenConns(app.config.DBReadPoolSize)
+	app.readDB.SetMaxIdleConns(app.config.DBReadPoolSize / 2)
+
+	if app.jobDB, err = openMeteredDB(connStr, app.logQuery, app.queryTimeout(), app.slowQueryDelay); err != nil {
+		return err
+	}
+	app.jobDB.SetMaxOpenConns(app.config.DBJobPoolSize)
+	app.jobDB.SetMaxIdleConns(app.config.DBJobPoolSize / 2)
+	return nil
+}
+
+// queryTimeout is how long a statement run for a request may take, 0 for no
+// limit (DB_QUERY_TIMEOUT_MS).
+func (app *App) queryTimeout() time.Duration {
+	return time.Duration(app.config.DBQueryTimeoutMs) * time.Millisecond
+}
+
+// pingDB checks the database answers within the query timeout, so a hung
+// server fails the check instead of blocking it.
+func (app *App) pingDB(ctx context.Context) error {
+	if t := app.queryTimeout(); t > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t)
+		defer cancel()
+	}
+	return app.db.PingContext(ctx)
+}
+
+// readPool is the pool for read-only listing and reporting queries. It falls
+// back to the OLTP pool if the read pool could not be opened.
+func (app *App) readPool() *sql.DB {
+	if app.readDB != nil {
+		return app.readDB
+	}
+	return app.db
+}
+
+// jobPool is the pool for background jobs, falling back like readPool.
+func (app *App) jobPool() *sql.DB {
+	if app.jobDB != nil {
+		return app.jobDB
+	}
+	return app.db
+}
+
+// observePools updates the per-pool gauges and returns the connections in
+// use across all pools.
+func (app *App) observePools() int {
+	total := 0
+	for name, db := range map[string]*sql.DB{"oltp": app.db, "read": app.readDB, "job": app.jobDB} {
+		if db == nil {
+			continue
+		}
+		inUse := db.Stats().InUse
+		dbPoolInUse.WithLabelValues(name).Set(float64(inUse))
+		total += inUse
+	}
+	return total
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type logTraceKey struct{}
+
+// logTrace identifies a request in logs and records whether debug logging was
+// switched on for it. Forced is set when it was by X-Debug-Log.
+type logTrace struct {
+	ID     string
+	Debug  bool
+	Forced bool
+}
+
+// debugForced reports whether the request behind ctx turned on debug logging
+// with X-Debug-Log. Such requests bypass the read cache, so the handler runs
+// and logs.
+func debugForced(ctx context.Context) bool {
+	t := logTraceFrom(ctx)
+	return t != nil && t.Forced
+}
+
+func logTraceFrom(ctx context.Context) *logTrace {
+	if ctx == nil {
+		return nil
+	}
+	t, _ := ctx.Value(logTraceKey{}).(*logTrace)
+	return t
+}
+
+// debug logs at debug level when the global level is debug or when the
+// request behind ctx was selected for debug sampling. Captured requests
+// record the line either way.
+func (app *App) debug(ctx context.Context, message string, data interface{}) {
+	if t := captureFrom(ctx); t != nil {
+		t.step(CaptureStep{Kind: "debug", Message: message, Data: data})
+	}
+	app.writeDebug(ctx, message, data)
+}
+
+// writeDebug is debug for callers that record captured requests in their
+// own format, such as SQL and Redis.
+func (app *App) writeDebug(ctx context.Context, message string, data interface{}) {
+	if !app.debugEnabled(ctx) {
+		return
+	}
+	traceID := ""
+	if t := logTraceFrom(ctx); t != nil {
+		traceID = t.ID
+	}
+	app.logger().output(StructuredLog{Level: "debug", TraceID: traceID, Message: message, Data: data})
+}
+
+// debugSamplingMiddleware marks a request for debug logging when it falls
+// into the DEBUG_LOG_SAMPLE_RATE sample. Every component that logs through
+// app.debug with the request context then emits debug lines sharing the
+// request ID. debugHeaderMiddleware adds requests that ask for it.
+func (app *App) debugSamplingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		t := logTraceFrom(c.Request.Context())
+		if t == nil {
+			t = &logTrace{ID: uuid.New().String()[:8]}
+			c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), logTraceKey{}, t))
+		}
+		t.Debug = app.config.DebugLogSampleRate > 0 && rand.Float64() < app.config.DebugLogSampleRate
+		if t.Debug {
+			app.startDebug(c, "sampled")
+		}
+		c.Next()
+	}
+}
+
+// debugHeaderMiddleware turns on debug logging for requests that send
+// X-Debug-Log: true. Forced requests skip the read cache, so the header is
+// only honored from admin or operator callers and ignored from anyone else;
+// it runs after authentication to know which is which.
+func (app *App) debugHeaderMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("X-Debug-Log")
+		t := logTraceFrom(c.Request.Context())
+		if t == nil || !(strings.EqualFold(header, "true") || header == "1") || !app.mayForceDebug(c) {
+			c.Next()
+			return
+		}
+		t.Forced = true
+		if !t.Debug {
+			t.Debug = true
+			app.startDebug(c, "header")
+		}
+		c.Next()
+	}
+}
+
+// mayForceDebug reports whether the caller may turn on debug logging with
+// X-Debug-Log: admins, by isAdminRequest, and operators.
+func (app *App) mayForceDebug(c *gin.Context) bool {
+	if p := principalFrom(c); p != nil && p.HasRole("operator") {
+		return true
+	}
+	return app.isAdminRequest(c)
+}
+
+func (app *App) startDebug(c *gin.Context, reason string) {
+	c.Header("X-Debug-Trace-ID", logTraceFrom(c.Request.Context()).ID)
+	app.debug(c.Request.Context(), "Debug logging enabled for request", map[string]interface{}{
+		"method": c.Request.Method,
+		"path":   c.Request.URL.Path,
+		"reason": reason,
+	})
+}
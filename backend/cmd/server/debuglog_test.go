@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// X-Debug-Log skips the read cache, so only admins and operators may send it.
+func TestDebugHeaderNeedsAdminOrOperator(t *testing.T) {
+	app := newTestApp(t, func(c *Config) { c.DemoTokensEnabled = true })
+	r := app.newRouter()
+	r.GET("/api/debug-probe", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"forced": debugForced(c.Request.Context())})
+	})
+
+	token := func(role string) string {
+		w := serve(r, http.MethodPost, "/oauth/demo-token", map[string]string{"subject": role + "-user", "role": role}, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("demo token for %s: %d %s", role, w.Code, w.Body)
+		}
+		var resp struct {
+			AccessToken string `json:"access_token"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return "Bearer " + resp.AccessToken
+	}
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    bool
+	}{
+		{"viewer", map[string]string{"Authorization": token("viewer"), "X-Debug-Log": "true"}, false},
+		{"operator", map[string]string{"Authorization": token("operator"), "X-Debug-Log": "true"}, true},
+		{"admin", map[string]string{"Authorization": token("admin"), "X-Debug-Log": "1"}, true},
+		{"anonymous without admin token", map[string]string{"X-Debug-Log": "true"}, true},
+		{"no header", map[string]string{"Authorization": token("admin")}, false},
+		{"other value", map[string]string{"Authorization": token("admin"), "X-Debug-Log": "yes"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(r, http.MethodGet, "/api/debug-probe", nil, tt.headers)
+			var resp struct {
+				Forced bool `json:"forced"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("%d %s", w.Code, w.Body)
+			}
+			if resp.Forced != tt.want {
+				t.Errorf("forced = %v, want %v", resp.Forced, tt.want)
+			}
+			if got := w.Header().Get("X-Debug-Trace-ID") != ""; got != tt.want {
+				t.Errorf("X-Debug-Trace-ID set = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	app.config.AdminToken = "secret-token"
+	w := serve(r, http.MethodGet, "/api/debug-probe", nil, map[string]string{"X-Debug-Log": "true"})
+	if w.Body.String() != `{"forced":false}` {
+		t.Errorf("anonymous with ADMIN_TOKEN set: %s", w.Body)
+	}
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	sessionContextKey = "payflow.demo_session"
+	sessionCacheTTL   = 5 * time.Second
+	sessionCacheMax   = 1024
+)
+
+// DemoSession is an isolated namespace for one presenter. Transactions created
+// with its X-Demo-Session header are tagged with its ID, list and stats
+// endpoints only see its data, and its chaos settings apply to its requests.
+type DemoSession struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Chaos     string    `json:"chaos,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type cachedSession struct {
+	session  *DemoSession
+	loadedAt time.Time
+}
+
+// sessionCache avoids a database lookup on every request of a busy demo. It
+// only holds sessions that exist and at most sessionCacheMax of them, so
+// callers making up session IDs can't grow it.
+type sessionCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedSession
+}
+
+func (s *sessionCache) get(id string) (*DemoSession, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[id]
+	if ok && time.Since(e.loadedAt) > sessionCacheTTL {
+		delete(s.entries, id)
+		ok = false
+	}
+	if !ok {
+		return nil, false
+	}
+	return e.session, true
+}
+
+func (s *sessionCache) put(id string, session *DemoSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.entries == nil {
+		s.entries = map[string]cachedSession{}
+	}
+	if _, ok := s.entries[id]; !ok && len(s.entries) >= sessionCacheMax {
+		s.evict()
+	}
+	s.entries[id] = cachedSession{session: session, loadedAt: time.Now()}
+}
+
+// evict drops the entries that have outlived sessionCacheTTL or, when none
+// have, the oldest one.
+func (s *sessionCache) evict() {
+	var oldest string
+	for id, e := range s.entries {
+		if time.Since(e.loadedAt) > sessionCacheTTL {
+			delete(s.entries, id)
+		} else if oldest == "" || e.loadedAt.Before(s.entries[oldest].loadedAt) {
+			oldest = id
+		}
+	}
+	if len(s.entries) >= sessionCacheMax {
+		delete(s.entries, oldest)
+	}
+}
+
+func (s *sessionCache) drop(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, id)
+}
+
+// sessionID returns the demo session of the request, or "" for live data.
+func sessionID(c *gin.Context) string {
+	if v, ok := c.Get(sessionContextKey); ok {
+		return v.(*DemoSession).ID
+	}
+	return ""
+}
+
+// sessionArg is a query argument matching session_id with
+// IS NOT DISTINCT FROM, so live data (NULL) and session data never mix.
+func sessionArg(id string) sql.NullString {
+	return sql.NullString{String: id, Valid: id != ""}
+}
+
+func (app *App) lookupSession(ctx context.Context, id string) (*DemoSession, error) {
+	if s, ok := app.sessions.get(id); ok {
+		return s, nil
+	}
+	var s DemoSession
+	err := app.db.QueryRowContext(ctx, `
+		SELECT id, name, chaos, created_at, expires_at FROM demo_sessions
+		WHERE id = $1 AND expires_at > NOW()
+	`, id).Scan(&s.ID, &s.Name, &s.Chaos, &s.CreatedAt, &s.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	app.sessions.put(id, &s)
+	return &s, nil
+}
+
+// demoSessionMiddleware binds requests carrying X-Demo-Session, or made by a
+// principal pinned to a session, to their session and applies the session's
+// chaos settings to them.
+func (app *App) demoSessionMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader("X-Demo-Session")
+		// Browsers can't set headers on a WebSocket handshake.
+		if id == "" && websocket.IsWebSocketUpgrade(c.Request) {
+			id = c.Query("demo_session")
+		}
+		if p := principalFrom(c); p != nil && p.SessionID != "" {
+			if id != "" && id != p.SessionID {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Credentials are limited to another demo session"})
+				c.Abort()
+				return
+			}
+			id = p.SessionID
+		}
+		if id == "" || app.db == nil {
+			c.Next()
+			return
+		}
+		// Session IDs are UUIDs; anything else can't name one and isn't
+		// worth a lookup.
+		if _, err := uuid.Parse(id); err != nil || len(id) != 36 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Demo session ID must be a UUID"})
+			c.Abort()
+			return
+		}
+
+		session, err := app.lookupSession(c.Request.Context(), id)
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to load demo session"})
+			c.Abort()
+			return
+		}
+		if session == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Demo session not found or expired"})
+			c.Abort()
+			return
+		}
+
+		c.Set(sessionContextKey, session)
+		if session.Chaos != "" {
+			if cfg, _, err := parseFeatureOverrides(app.cfg(c), session.Chaos); err == nil {
+				c.Set(configContextKey, cfg)
+			}
+		}
+		c.Next()
+	}
+}
+
+func (app *App) createDemoSessionHandler(c *gin.Context) {
+	var req struct {
+		Name      string   `json:"name" binding:"required"`
+		TTLSec    int      `json:"ttl_sec"`
+		SeedCount int      `json:"seed_count"`
+		Chaos     string   `json:"chaos"`
+		Personas  []string `json:"personas"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	if req.TTLSec <= 0 {
+		req.TTLSec = app.config.DemoSessionTTLSec
+	}
+	if req.SeedCount < 0 || req.SeedCount > 1000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "seed_count must be between 0 and 1000"})
+		return
+	}
+	personas, err := app.selectPersonas(req.Personas)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "personas: " + err.Error()})
+		return
+	}
+	if req.Chaos != "" {
+		if _, _, err := parseFeatureOverrides(app.config, req.Chaos); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "chaos: " + err.Error()})
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+	session, err := app.openDemoSession(ctx, req.Name, req.Chaos, req.TTLSec)
+	if err != nil {
+		app.logCtx(ctx, "error", "Failed to create demo session", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	seeded := app.seedDemoSession(ctx, session.ID, generateSeedTransactions(personas, req.SeedCount))
+
+	app.eventCtx(ctx, "info", EventDemoSessionCreated, session.ID, "Demo session created", map[string]interface{}{
+		"name":       session.Name,
+		"seeded":     seeded,
+		"expires_at": session.ExpiresAt,
+	})
+	c.JSON(http.StatusCreated, gin.H{
+		"session": session,
+		"seeded":  seeded,
+		"header":  "X-Demo-Session",
+	})
+}
+
+// openDemoSession stores a new session that expires after ttlSec.
+func (app *App) openDemoSession(ctx context.Context, name, chaos string, ttlSec int) (DemoSession, error) {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	session := DemoSession{
+		ID:        uuid.New().String(),
+		Name:      name,
+		Chaos:     chaos,
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Duration(ttlSec) * time.Second),
+	}
+	_, err := app.db.ExecContext(ctx, `
+		INSERT INTO demo_sessions (id, name, chaos, created_at, expires_at) VALUES ($1, $2, $3, $4, $5)
+	`, session.ID, session.Name, session.Chaos, session.CreatedAt, session.ExpiresAt)
+	return session, err
+}
+
+// seedDemoSession stores txns in the session and returns how many it stored.
+// Seeding stops at the first failure.
+func (app *App) seedDemoSession(ctx context.Context, id string, txns []Transaction) int {
+	seeded := 0
+	for _, txn := range txns {
+		txn.SessionID = id
+		if err := app.insertTransaction(ctx, &txn); err != nil {
+			app.logCtx(ctx, "warn", "Failed to seed demo session", map[string]interface{}{"session_id": id, "error": err.Error()})
+			break
+		}
+		seeded++
+	}
+	return seeded
+}
+
+func (app *App) listDemoSessionsHandler(c *gin.Context) {
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	rows, err := app.db.QueryContext(c.Request.Context(), `
+		SELECT id, name, chaos, created_at, expires_at FROM demo_sessions
+		WHERE expires_at > NOW() ORDER BY created_at
+	`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	sessions := []DemoSession{}
+	for rows.Next() {
+		var s DemoSession
+		if err := rows.Scan(&s.ID, &s.Name, &s.Chaos, &s.CreatedAt, &s.ExpiresAt); err != nil {
+			continue
+		}
+		sessions = append(sessions, s)
+	}
+	c.JSON(http.StatusOK, sessions)
+}
+
+func (app *App) deleteDemoSessionHandler(c *gin.Context) {
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	n, err := app.purgeSessions(c.Request.Context(), `id = $1`, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Demo session not found"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// purgeSessions deletes the sessions matching where and all of their data.
+func (app *App) purgeSessions(ctx context.Context, where string, args ...interface{}) (int, error) {
+	tx, err := app.jobPool().BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `DELETE FROM demo_sessions WHERE `+where+` RETURNING id`, args...)
+	if err != nil {
+		return 0, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	var keys []string
+	for _, id := range ids {
+		// transaction_audit has no foreign key to transactions, so its rows
+		// go first; alert triage, review labels and receipts cascade.
+		if _, err := tx.ExecContext(ctx, `
+			DELETE FROM transaction_audit WHERE transaction_id IN (SELECT id FROM transactions WHERE session_id = $1)
+		`, id); err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM transactions WHERE session_id = $1`, id); err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM webhooks WHERE session_id = $1`, id); err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM fraud_alert_summaries WHERE session_id = $1`, id); err != nil {
+			return 0, err
+		}
+		// Sub-accounts go in the same statement as their parents.
+		if _, err := tx.ExecContext(ctx, `DELETE FROM accounts WHERE session_id = $1`, id); err != nil {
+			return 0, err
+		}
+		revoked, err := tx.QueryContext(ctx, `
+			UPDATE api_keys SET revoked_at = NOW() WHERE session_id = $1 AND revoked_at IS NULL RETURNING id
+		`, id)
+		if err != nil {
+			return 0, err
+		}
+		for revoked.Next() {
+			var key string
+			if revoked.Scan(&key) == nil {
+				keys = append(keys, key)
+			}
+		}
+		revoked.Close()
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	if len(ids) > 0 {
+		app.invalidateReadCache(ctx)
+	}
+	for _, key := range keys {
+		app.apiKeys.drop(key)
+	}
+	for _, id := range ids {
+		app.sessions.drop(id)
+		app.eventCtx(ctx, "info", EventDemoSessionRemoved, id, "Demo session removed", nil)
+	}
+	return len(ids), nil
+}
+
+func (app *App) startDemoSessionReaper() {
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			if app.db == nil {
+				continue
+			}
+			if _, err := app.purgeSessions(context.Background(), `expires_at <= NOW()`); err != nil {
+				app.log("warn", "Failed to expire demo sessions", map[string]interface{}{"error": err.Error()})
+			}
+		}
+	}()
+}
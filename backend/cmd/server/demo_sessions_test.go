@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/infrasage/payflow/internal/dbtest"
+)
+
+func TestDemoSessionIDMustBeUUID(t *testing.T) {
+	app := newTestApp(t, nil)
+	// Any lookup fails against the empty fake, so only malformed IDs are
+	// answered without one.
+	app.db = newFakeLedger().open(t)
+	r := app.newRouter()
+	tests := []struct {
+		id   string
+		want int
+	}{
+		{"not-a-session", http.StatusBadRequest},
+		{"{6ba7b810-9dad-11d1-80b4-00c04fd430c8}", http.StatusBadRequest},
+		{"6ba7b810-9dad-11d1-80b4-00c04fd430c8", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		if w := serve(r, http.MethodGet, "/api/transactions", nil, map[string]string{"X-Demo-Session": tt.id}); w.Code != tt.want {
+			t.Errorf("X-Demo-Session %q: got %d %s, want %d", tt.id, w.Code, w.Body, tt.want)
+		}
+	}
+	if len(app.sessions.entries) != 0 {
+		t.Errorf("%d sessions cached, want none", len(app.sessions.entries))
+	}
+}
+
+func TestSessionCacheIsBounded(t *testing.T) {
+	var cache sessionCache
+	for i := 0; i < sessionCacheMax+10; i++ {
+		id := fmt.Sprintf("s%d", i)
+		cache.put(id, &DemoSession{ID: id})
+	}
+	if len(cache.entries) != sessionCacheMax {
+		t.Errorf("%d entries, want %d", len(cache.entries), sessionCacheMax)
+	}
+	if _, ok := cache.get(fmt.Sprintf("s%d", sessionCacheMax+9)); !ok {
+		t.Error("latest session evicted")
+	}
+
+	for id, e := range cache.entries {
+		e.loadedAt = time.Now().Add(-2 * sessionCacheTTL)
+		cache.entries[id] = e
+	}
+	cache.put("fresh", &DemoSession{ID: "fresh"})
+	if len(cache.entries) != 1 {
+		t.Errorf("%d entries after a put over stale ones, want 1", len(cache.entries))
+	}
+}
+
+// fakeSessionData is the rows purgeSessions deletes: a table name maps each
+// row's key to the demo session it belongs to, "" for live data, and audit
+// entries map to their transaction. Revoking a key takes it out of
+// api_keys here. A rollback undoes the transaction's deletes. failOn makes
+// the statement starting with it fail.
+type fakeSessionData struct {
+	tables map[string]map[string]string
+	audit  map[string]string
+	saved  *fakeSessionData
+	failOn string
+}
+
+func (d *fakeSessionData) copy() *fakeSessionData {
+	c := &fakeSessionData{tables: map[string]map[string]string{}, audit: map[string]string{}}
+	for name, rows := range d.tables {
+		c.tables[name] = map[string]string{}
+		for k, v := range rows {
+			c.tables[name][k] = v
+		}
+	}
+	for k, v := range d.audit {
+		c.audit[k] = v
+	}
+	return c
+}
+
+func (d *fakeSessionData) deleteSession(table, session string) []string {
+	var keys []string
+	for k, s := range d.tables[table] {
+		if s == session {
+			delete(d.tables[table], k)
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+func (d *fakeSessionData) run(q dbtest.Query) (*dbtest.Rows, error) {
+	if d.failOn != "" && q.HasPrefix(d.failOn) {
+		return nil, errors.New("connection reset")
+	}
+	switch {
+	case q.HasPrefix("DELETE FROM demo_sessions WHERE id = $1 RETURNING id"):
+		rows := dbtest.NewRows("id")
+		for _, k := range d.deleteSession("demo_sessions", q.String(0)) {
+			rows.Add(k)
+		}
+		return rows, nil
+	case q.HasPrefix("DELETE FROM transaction_audit WHERE transaction_id IN (SELECT id FROM transactions WHERE session_id = $1)"):
+		for entry, txn := range d.audit {
+			if d.tables["transactions"][txn] == q.String(0) {
+				delete(d.audit, entry)
+			}
+		}
+		return dbtest.None(), nil
+	case q.HasPrefix("UPDATE api_keys SET revoked_at = NOW() WHERE session_id = $1"):
+		rows := dbtest.NewRows("id")
+		for _, k := range d.deleteSession("api_keys", q.String(0)) {
+			rows.Add(k)
+		}
+		return rows, nil
+	}
+	for _, table := range []string{"transactions", "webhooks", "fraud_alert_summaries", "accounts"} {
+		if q.HasPrefix("DELETE FROM " + table + " WHERE session_id = $1") {
+			d.deleteSession(table, q.String(0))
+			return dbtest.None(), nil
+		}
+	}
+	return nil, dbtest.Unexpected(q)
+}
+
+// Nothing keyed to a deleted session survives it, audit entries of its
+// transactions included, and a purge that fails part way leaves it whole.
+func TestPurgeSessionRemovesItsData(t *testing.T) {
+	const session = "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+	data := &fakeSessionData{
+		tables: map[string]map[string]string{
+			"demo_sessions":         {session: session},
+			"transactions":          {"txn-demo": session, "txn-live": ""},
+			"webhooks":              {"wh-demo": session, "wh-live": ""},
+			"fraud_alert_summaries": {"sum-demo": session},
+			"accounts":              {"ACC-demo": session, "ACC-live": ""},
+			"api_keys":              {"key-demo": session},
+		},
+		audit: map[string]string{"1": "txn-demo", "2": "txn-demo", "3": "txn-live"},
+	}
+	app := newTestApp(t, nil)
+	app.db = dbtest.New(data.run).WithTx(dbtest.Tx{
+		Begin:    func() { data.saved = data.copy() },
+		Commit:   func() { data.saved = nil },
+		Rollback: func() { data.tables, data.audit, data.saved = data.saved.tables, data.saved.audit, nil },
+	}).Open(t)
+	r := app.newRouter()
+
+	data.failOn = "DELETE FROM accounts"
+	if w := serve(r, http.MethodDelete, "/api/admin/demo-sessions/"+session, nil, nil); w.Code != http.StatusInternalServerError {
+		t.Fatalf("failing purge = %d %s, want 500", w.Code, w.Body)
+	}
+	if len(data.audit) != 3 || len(data.tables["transactions"]) != 2 {
+		t.Fatalf("failed purge left audit %v and transactions %v, want both whole", data.audit, data.tables["transactions"])
+	}
+
+	data.failOn = ""
+	if w := serve(r, http.MethodDelete, "/api/admin/demo-sessions/"+session, nil, nil); w.Code != http.StatusNoContent {
+		t.Fatalf("purge = %d %s, want 204", w.Code, w.Body)
+	}
+	for name, rows := range data.tables {
+		for k, s := range rows {
+			if s == session {
+				t.Errorf("%s row %s outlived its session", name, k)
+			}
+		}
+	}
+	if len(data.audit) != 1 || data.audit["3"] != "txn-live" {
+		t.Errorf("audit entries left %v, want only the live transaction's", data.audit)
+	}
+	if len(data.tables["transactions"]) != 1 || len(data.tables["accounts"]) != 1 || len(data.tables["webhooks"]) != 1 {
+		t.Errorf("live data touched: %v", data.tables)
+	}
+}
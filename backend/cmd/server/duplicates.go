@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// AuditEntry is a single row of a transaction's change history.
+type AuditEntry struct {
+	ID            int64           `json:"id"`
+	TransactionID string          `json:"transaction_id"`
+	Action        string          `json:"action"`
+	Actor         string          `json:"actor"`
+	Details       json.RawMessage `json:"details,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
+
+// DuplicateGroup is a set of transactions that look like the same payment.
+type DuplicateGroup struct {
+	FromAccount  string        `json:"from_account"`
+	ToAccount    string        `json:"to_account"`
+	Amount       float64       `json:"amount"`
+	Transactions []Transaction `json:"transactions"`
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func recordAudit(ctx context.Context, db execer, txnID, action, actor string, details interface{}) error {
+	payload, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO transaction_audit (transaction_id, action, actor, details)
+		VALUES ($1, $2, $3, $4)
+	`, txnID, action, actor, payload)
+	return err
+}
+
+// adminActor names the caller behind an admin request for audit purposes:
+// the authenticated principal, or "admin" for callers let in by ADMIN_TOKEN
+// or, without admin auth configured, anonymously. It never comes from
+// anything the client says about itself.
+func adminActor(c *gin.Context) string {
+	if principalFrom(c) != nil {
+		return requestActor(c)
+	}
+	return "admin"
+}
+
+// onBehalfOf is who the caller says it acts for, from X-Admin-Actor, e.g.
+// the person behind a shared admin token. It is unverified, so it is only
+// ever recorded next to adminActor, never instead of it.
+func onBehalfOf(c *gin.Context) string {
+	return c.GetHeader("X-Admin-Actor")
+}
+
+// findDuplicatesHandler groups settled transactions with the same parties
+// and amount whose timestamps fall within window_sec of each other.
+func (app *App) findDuplicatesHandler(c *gin.Context) {
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	window, err := strconv.Atoi(c.DefaultQuery("window_sec", "300"))
+	if err != nil || window <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "window_sec must be a positive integer"})
+		return
+	}
+
+	rows, err := app.readPool().QueryContext(c.Request.Context(), `
+		SELECT a.id, a.from_account, a.to_account, a.amount, a.description, a.status, a.created_at
+		FROM transactions a
+		WHERE a.status = 'success'
+		  AND a.refund_of IS NULL
+		  AND EXISTS (
+			SELECT 1 FROM transactions b
+			WHERE b.id <> a.id
+			  AND b.status = 'success'
+			  AND b.refund_of IS NULL
+			  AND b.from_account = a.from_account
+			  AND b.to_account = a.to_account
+			  AND b.amount = a.amount
+			  AND b.session_id IS NOT DISTINCT FROM a.session_id
+			  AND ABS(EXTRACT(EPOCH FROM (b.created_at - a.created_at))) <= $1
+		  )
+		ORDER BY a.from_account, a.to_account, a.amount, a.created_at
+	`, window)
+	if err != nil {
+		app.logCtx(c.Request.Context(), "error", "Failed to find duplicates", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	var groups []DuplicateGroup
+	var current *DuplicateGroup
+	var last time.Time
+	for rows.Next() {
+		var t Transaction
+		if err := rows.Scan(&t.ID, &t.FromAccount, &t.ToAccount, &t.Amount, &t.Description, &t.Status, &t.CreatedAt); err != nil {
+			continue
+		}
+		sameKey := current != nil && current.FromAccount == t.FromAccount && current.ToAccount == t.ToAccount && current.Amount == t.Amount
+		if !sameKey || t.CreatedAt.Sub(last) > time.Duration(window)*time.Second {
+			groups = append(groups, DuplicateGroup{FromAccount: t.FromAccount, ToAccount: t.ToAccount, Amount: t.Amount})
+			current = &groups[len(groups)-1]
+		}
+		current.Transactions = append(current.Transactions, t)
+		last = t.CreatedAt
+	}
+
+	// A row can match a neighbour that was split into another group by the
+	// sliding window; drop the resulting singletons.
+	filtered := []DuplicateGroup{}
+	for _, g := range groups {
+		if len(g.Transactions) > 1 {
+			filtered = append(filtered, g)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"window_sec": window, "groups": filtered})
+}
+
+// mergeDuplicatesHandler keeps the canonical transaction of a duplicate
+// group and voids the rest. Only settled duplicates from the caller's session
+// can be voided, and voiding one reverses its postings, so the payer gets the
+// money back from the payee.
+func (app *App) mergeDuplicatesHandler(c *gin.Context) {
+	var req struct {
+		CanonicalID  string   `json:"canonical_id" binding:"required"`
+		DuplicateIDs []string `json:"duplicate_ids" binding:"required,min=1"`
+		Reason       string   `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	for _, id := range req.DuplicateIDs {
+		if id == req.CanonicalID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "canonical_id cannot also be a duplicate"})
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+	session := sessionID(c)
+	actor := adminActor(c)
+	tx, err := app.db.BeginTx(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Voiding reverses postings, so take the lock balances are posted
+	// under, before the row locks as refunds do.
+	if session == "" {
+		_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, ledgerLockID)
+	} else {
+		_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1, hashtext($2))`, sessionLockClass, session)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	var canonical Transaction
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, from_account, to_account, amount, status, COALESCE(session_id, '') FROM transactions
+		WHERE id = $1 AND session_id IS NOT DISTINCT FROM $2
+		FOR UPDATE
+	`, req.CanonicalID, sessionArg(session)).Scan(&canonical.ID, &canonical.FromAccount, &canonical.ToAccount, &canonical.Amount, &canonical.Status, &canonical.SessionID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Canonical transaction not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if canonical.Status == "voided" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Canonical transaction is voided"})
+		return
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, from_account, to_account, amount FROM transactions
+		WHERE id = ANY($1)
+		  AND session_id IS NOT DISTINCT FROM $2
+		  AND status = 'success'
+		  AND refund_of IS NULL
+		  AND from_account = $3 AND to_account = $4 AND amount = $5
+		FOR UPDATE
+	`, pq.Array(req.DuplicateIDs), sessionArg(session), canonical.FromAccount, canonical.ToAccount, canonical.Amount)
+	if err != nil {
+		app.logCtx(ctx, "error", "Failed to lock duplicates", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	var duplicates []Transaction
+	for rows.Next() {
+		t := Transaction{SessionID: session}
+		if err := rows.Scan(&t.ID, &t.FromAccount, &t.ToAccount, &t.Amount); err != nil {
+			rows.Close()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		duplicates = append(duplicates, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if len(duplicates) != len(req.DuplicateIDs) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Some duplicates are missing, not settled, or do not match the canonical transaction"})
+		return
+	}
+
+	for _, t := range duplicates {
+		err := reverseBalances(ctx, tx, t)
+		if err == errReversalUncovered {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":          "The payee no longer holds enough to reverse a duplicate",
+				"code":           "INSUFFICIENT_FUNDS",
+				"transaction_id": t.ID,
+			})
+			return
+		}
+		if err != nil {
+			app.logCtx(ctx, "error", "Failed to reverse duplicate", map[string]interface{}{"transaction_id": t.ID, "error": err.Error()})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE transactions SET status = 'voided' WHERE id = ANY($1)`, pq.Array(req.DuplicateIDs)); err != nil {
+		app.logCtx(ctx, "error", "Failed to void duplicates", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := app.resealStatus(ctx, tx, req.DuplicateIDs...); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	details := map[string]interface{}{"canonical_id": canonical.ID, "duplicate_ids": req.DuplicateIDs, "reason": req.Reason}
+	if behalf := onBehalfOf(c); behalf != "" {
+		details["on_behalf_of"] = behalf
+	}
+	if err := recordAudit(ctx, tx, canonical.ID, "marked_canonical", actor, details); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	for _, id := range req.DuplicateIDs {
+		if err := recordAudit(ctx, tx, id, "voided_as_duplicate", actor, details); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	app.invalidateReadCache(ctx)
+	for _, id := range req.DuplicateIDs {
+		app.feed.PublishStatus(canonical.SessionID, id, "voided")
+	}
+
+	app.eventCtx(ctx, "info", EventDuplicatesMerged, canonical.ID, "Duplicate transactions merged", map[string]interface{}{
+		"duplicate_ids": req.DuplicateIDs,
+		"actor":         actor,
+	})
+	c.JSON(http.StatusOK, gin.H{"canonical_id": canonical.ID, "voided": req.DuplicateIDs})
+}
+
+func (app *App) getTransactionAuditHandler(c *gin.Context) {
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	rows, err := app.readPool().QueryContext(c.Request.Context(), `
+		SELECT id, transaction_id, action, actor, COALESCE(details, 'null'::jsonb), created_at
+		FROM transaction_audit
+		WHERE transaction_id = $1
+		ORDER BY id
+	`, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var details []byte
+		if err := rows.Scan(&e.ID, &e.TransactionID, &e.Action, &e.Actor, &details, &e.CreatedAt); err != nil {
+			continue
+		}
+		e.Details = details
+		entries = append(entries, e)
+	}
+	c.JSON(http.StatusOK, entries)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAdminActorIgnoresClaimedActor(t *testing.T) {
+	tests := []struct {
+		name string
+		p    *Principal
+		want string
+	}{
+		{"admin token", nil, "admin"},
+		{"oidc operator", &Principal{Subject: "alice", Roles: []string{"admin"}, Source: "oidc"}, "oidc:alice"},
+		{"api key", &Principal{Subject: "ci", Scopes: []string{"admin"}, Source: "apikey"}, "apikey:ci"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("POST", "/api/admin/duplicates/merge", nil)
+			c.Request.Header.Set("X-Admin-Actor", "mallory")
+			if tt.p != nil {
+				c.Set(principalContextKey, tt.p)
+			}
+			if got := adminActor(c); got != tt.want {
+				t.Errorf("adminActor = %q, want %q", got, tt.want)
+			}
+			if got := onBehalfOf(c); got != "mallory" {
+				t.Errorf("onBehalfOf = %q, want the header", got)
+			}
+		})
+	}
+}
+
+func TestMergeDuplicatesReversesPostings(t *testing.T) {
+	tests := []struct {
+		name       string
+		duplicates []Transaction
+		want       int
+		balances   [2]float64
+		status     string
+	}{
+		{"settled duplicate", []Transaction{{ID: "txn-2", Status: "success"}}, http.StatusOK, [2]float64{70, 30}, "voided"},
+		{"two settled duplicates", []Transaction{{ID: "txn-2", Status: "success"}, {ID: "txn-3", Status: "success"}}, http.StatusOK, [2]float64{80, 20}, "voided"},
+		{"held duplicate", []Transaction{{ID: "txn-2", Status: "held"}}, http.StatusConflict, [2]float64{60, 40}, "held"},
+		{"failed duplicate", []Transaction{{ID: "txn-2", Status: "failed"}}, http.StatusConflict, [2]float64{60, 40}, "failed"},
+		{"refunded duplicate", []Transaction{{ID: "txn-2", Status: "refunded"}}, http.StatusConflict, [2]float64{60, 40}, "refunded"},
+		{"other session", []Transaction{{ID: "txn-2", Status: "success", SessionID: "s1"}}, http.StatusConflict, [2]float64{60, 40}, "success"},
+		{"payee can't cover", []Transaction{{ID: "txn-2", Status: "success"}, {ID: "txn-3", Status: "success"}, {ID: "txn-4", Status: "success"}, {ID: "txn-5", Status: "success"}, {ID: "txn-6", Status: "success"}},
+			http.StatusConflict, [2]float64{60, 40}, "success"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newFakeLedger()
+			l.accounts["ACC-1"] = fakeAccount{Balance: 60}
+			l.accounts["MER-1"] = fakeAccount{Balance: 40}
+			l.txns["txn-1"] = Transaction{ID: "txn-1", FromAccount: "ACC-1", ToAccount: "MER-1", Amount: 10, Status: "success"}
+			var ids []string
+			for _, d := range tt.duplicates {
+				d.FromAccount, d.ToAccount, d.Amount = "ACC-1", "MER-1", 10
+				l.txns[d.ID] = d
+				ids = append(ids, d.ID)
+			}
+			app := newTestApp(t, nil)
+			app.db = l.open(t)
+
+			w := serve(app.newRouter(), http.MethodPost, "/api/admin/duplicates/merge", map[string]interface{}{"canonical_id": "txn-1", "duplicate_ids": ids}, nil)
+			if w.Code != tt.want {
+				t.Fatalf("got %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+			if got := [2]float64{l.balance("ACC-1"), l.balance("MER-1")}; got != tt.balances {
+				t.Errorf("balances %v, want %v", got, tt.balances)
+			}
+			if got := l.status("txn-2"); got != tt.status {
+				t.Errorf("duplicate status %q, want %q", got, tt.status)
+			}
+			if got := l.status("txn-1"); got != "success" {
+				t.Errorf("canonical status %q", got)
+			}
+		})
+	}
+}
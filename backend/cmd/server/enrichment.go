@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/infrasage/payflow/internal/store"
+)
+
+// Counterparty is reference metadata about the other side of a transaction.
+type Counterparty = store.Counterparty
+
+// CounterpartyLookup resolves an account to counterparty metadata. A nil
+// result with a nil error means the account is unknown.
+type CounterpartyLookup interface {
+	Lookup(ctx context.Context, account string) (*Counterparty, error)
+}
+
+// tableLookup reads the local counterparties reference table.
+type tableLookup struct {
+	db *sql.DB
+}
+
+func (l tableLookup) Lookup(ctx context.Context, account string) (*Counterparty, error) {
+	if l.db == nil {
+		return nil, nil
+	}
+	cp := Counterparty{Account: account}
+	err := l.db.QueryRowContext(ctx, `
+		SELECT merchant_name, category, risk_tier FROM counterparties WHERE account = $1
+	`, account).Scan(&cp.Name, &cp.Category, &cp.RiskTier)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// httpLookup asks an external service: GET {base}/{account} returning the
+// Counterparty JSON, or 404 for unknown accounts.
+type httpLookup struct {
+	base   string
+	client *http.Client
+}
+
+func (l httpLookup) Lookup(ctx context.Context, account string) (*Counterparty, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(l.base, "/")+"/"+url.PathEscape(account), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("enrichment lookup: %s", resp.Status)
+	}
+	cp := Counterparty{Account: account}
+	if err := json.NewDecoder(resp.Body).Decode(&cp); err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// Enricher fronts a CounterpartyLookup with the cache. Unknown
+// accounts are cached too, so a missing entry doesn't hit the source on
+// every read.
+type Enricher struct {
+	app    *App
+	lookup CounterpartyLookup
+	cache  kvCache
+	ttl    time.Duration
+}
+
+func (app *App) initEnrichment() {
+	var lookup CounterpartyLookup
+	switch app.config.EnrichmentSource {
+	case "none":
+		return
+	case "http":
+		lookup = httpLookup{base: app.config.EnrichmentURL, client: app.newOutboundClient("enrichment", outboundOptions{Timeout: 2 * time.Second, Retries: 1})}
+	default:
+		lookup = tableLookup{db: app.db}
+	}
+	app.enricher = &Enricher{
+		app:    app,
+		lookup: lookup,
+		ttl:    time.Duration(app.config.EnrichmentCacheTTLSec) * time.Second,
+	}
+}
+
+func counterpartyCacheKey(account string) string {
+	return "payflow:counterparty:" + account
+}
+
+func (e *Enricher) get(ctx context.Context, account string) (*Counterparty, error) {
+	cp, write, err := e.fetch(ctx, account)
+	if write != nil {
+		e.cache.Set(ctx, write.key, write.value, write.ttl)
+	}
+	return cp, err
+}
+
+// fetch returns the counterparty of account from the cache, or from the
+// lookup together with the cache write that stores the result, for the
+// caller to send.
+func (e *Enricher) fetch(ctx context.Context, account string) (*Counterparty, *cacheWrite, error) {
+	key := counterpartyCacheKey(account)
+	rc := e.cache
+	if rc != nil {
+		if raw, err := rc.Get(ctx, key); err == nil {
+			atomic.AddInt64(&e.app.cacheHits, 1)
+			if len(raw) == 0 {
+				return nil, nil, nil
+			}
+			var cp Counterparty
+			if json.Unmarshal(raw, &cp) == nil {
+				cp.Account = account
+				return &cp, nil, nil
+			}
+		}
+		atomic.AddInt64(&e.app.cacheMisses, 1)
+	}
+
+	cp, err := e.lookup.Lookup(ctx, account)
+	if err != nil || rc == nil {
+		return cp, nil, err
+	}
+	var raw []byte
+	if cp != nil {
+		raw, _ = json.Marshal(cp)
+	}
+	return cp, &cacheWrite{op: cacheSet, key: key, value: raw, ttl: e.ttl}, nil
+}
+
+// Annotate attaches counterparty metadata for each transaction's payee.
+// Lookup failures are logged and leave the transaction unannotated rather
+// than failing the read. The payees looked up are cached together once all
+// are resolved.
+func (e *Enricher) Annotate(ctx context.Context, txns []Transaction) {
+	if e == nil {
+		return
+	}
+	resolved := map[string]*Counterparty{}
+	var writes []cacheWrite
+	for i := range txns {
+		account := txns[i].ToAccount
+		cp, seen := resolved[account]
+		if !seen {
+			var write *cacheWrite
+			var err error
+			if cp, write, err = e.fetch(ctx, account); err != nil {
+				e.app.debug(ctx, "Counterparty lookup failed", map[string]interface{}{"account": account, "error": err.Error()})
+			}
+			if write != nil {
+				writes = append(writes, *write)
+			}
+			resolved[account] = cp
+		}
+		txns[i].Counterparty = cp
+	}
+	if len(writes) > 0 {
+		e.cache.Write(ctx, writes)
+	}
+}
+
+func (app *App) listCounterpartiesHandler(c *gin.Context) {
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	rows, err := app.readPool().QueryContext(c.Request.Context(), `
+		SELECT account, merchant_name, category, risk_tier FROM counterparties ORDER BY account
+	`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	counterparties := []Counterparty{}
+	for rows.Next() {
+		var cp Counterparty
+		if err := rows.Scan(&cp.Account, &cp.Name, &cp.Category, &cp.RiskTier); err != nil {
+			continue
+		}
+		counterparties = append(counterparties, cp)
+	}
+	c.JSON(http.StatusOK, counterparties)
+}
+
+// putCounterpartyHandler upserts a reference table entry and drops any
+// cached copy so reads pick it up immediately.
+func (app *App) putCounterpartyHandler(c *gin.Context) {
+	var req struct {
+		Name     string `json:"name" binding:"required"`
+		Category string `json:"category"`
+		RiskTier string `json:"risk_tier"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.RiskTier == "" {
+		req.RiskTier = "standard"
+	}
+	if !containsString([]string{"low", "standard", "high"}, req.RiskTier) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "risk_tier must be low, standard or high"})
+		return
+	}
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+
+	account, err := app.vault.Tokenize(c.Request.Context(), app.db, c.Param("account"))
+	if err != nil {
+		app.logCtx(c.Request.Context(), "error", "Failed to tokenize account", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Vault error"})
+		return
+	}
+	_, err = app.db.ExecContext(c.Request.Context(), `
+		INSERT INTO counterparties (account, merchant_name, category, risk_tier) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (account) DO UPDATE SET merchant_name = EXCLUDED.merchant_name,
+			category = EXCLUDED.category, risk_tier = EXCLUDED.risk_tier
+	`, account, req.Name, req.Category, req.RiskTier)
+	if err != nil {
+		app.logCtx(c.Request.Context(), "error", "Failed to save counterparty", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	// Cached transaction lists carry the old counterparty annotation.
+	app.invalidateReadCache(c.Request.Context(), account)
+	c.JSON(http.StatusOK, Counterparty{Account: account, Name: req.Name, Category: req.Category, RiskTier: req.RiskTier})
+}
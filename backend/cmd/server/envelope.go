@@ -0,0 +1,25 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	apihttp "github.com/infrasage/payflow/internal/http"
+)
+
+func (app *App) apiVersions() map[string]apihttp.Version {
+	return map[string]apihttp.Version{
+		"1": {FieldNaming: "snake_case"},
+		"2": {Envelope: true, FieldNaming: app.config.APIV2FieldNaming},
+	}
+}
+
+// envelopeExempt are /api paths whose responses are documents with shapes
+// of their own, not API resources.
+var envelopeExempt = []string{"/api/openapi.json", "/api/docs", "/api/schemas", "/api/graphql", "/api/privacy/exports/"}
+
+// apiVersionMiddleware reshapes JSON responses under /api for the version
+// the request asks for, API_DEFAULT_VERSION otherwise. It runs outside the
+// recovery middleware, so errors from panics and from every other
+// middleware are reshaped too.
+func (app *App) apiVersionMiddleware() gin.HandlerFunc {
+	return apihttp.VersionMiddleware(app.apiVersions(), app.config.APIDefaultVersion, envelopeExempt)
+}
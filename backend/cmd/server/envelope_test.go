@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newEnvelopeTestRouter(t *testing.T, apply func(*Config)) *gin.Engine {
+	app := newTestApp(t, apply)
+	r := gin.New()
+	r.Use(app.apiVersionMiddleware())
+	r.GET("/api/object", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"from_account": "ACC-1", "fraud_status": gin.H{"review_score": 50}})
+	})
+	r.GET("/api/list", func(c *gin.Context) {
+		c.JSON(http.StatusOK, []gin.H{{"to_account": "A"}, {"to_account": "B"}})
+	})
+	r.GET("/api/page", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"data": []gin.H{{"from_account": "A"}}, "total": 7, "limit": 1, "offset": 0, "next_cursor": "abc"})
+	})
+	r.GET("/api/error", func(c *gin.Context) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Invalid body", "violations": []gin.H{{"field_path": "/amount"}}})
+	})
+	r.GET("/api/text", func(c *gin.Context) { c.String(http.StatusOK, "from_account") })
+	r.GET("/api/openapi.json", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"open_api": "3.0"}) })
+	return r
+}
+
+func decodeJSON(t *testing.T, raw []byte) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		t.Fatalf("%v: %s", err, raw)
+	}
+	return v
+}
+
+func TestResponseVersions(t *testing.T) {
+	tests := []struct {
+		name    string
+		naming  string
+		version string
+		path    string
+		status  int
+		want    string
+	}{
+		{"v1 object", "", "1", "/api/object", 200, `{"from_account": "ACC-1", "fraud_status": {"review_score": 50}}`},
+		{"v1 list", "", "1", "/api/list", 200, `[{"to_account": "A"}, {"to_account": "B"}]`},
+		{"v1 page", "", "1", "/api/page", 200, `{"data": [{"from_account": "A"}], "total": 7, "limit": 1, "offset": 0, "next_cursor": "abc"}`},
+		{"v1 error", "", "1", "/api/error", 422, `{"error": "Invalid body", "violations": [{"field_path": "/amount"}]}`},
+		{"default is v1", "", "", "/api/object", 200, `{"from_account": "ACC-1", "fraud_status": {"review_score": 50}}`},
+
+		{"v2 object", "", "2", "/api/object", 200, `{"data": {"from_account": "ACC-1", "fraud_status": {"review_score": 50}}}`},
+		{"v2 list", "", "2", "/api/list", 200, `{"data": [{"to_account": "A"}, {"to_account": "B"}], "meta": {"count": 2}}`},
+		{"v2 page", "", "2", "/api/page", 200, `{"data": [{"from_account": "A"}], "meta": {"count": 1, "total": 7, "limit": 1, "offset": 0, "next_cursor": "abc"}}`},
+		{"v2 error", "", "2", "/api/error", 422, `{"error": {"status": 422, "message": "Invalid body", "details": {"violations": [{"field_path": "/amount"}]}}}`},
+
+		{"v2 camelCase object", "camelCase", "2", "/api/object", 200, `{"data": {"fromAccount": "ACC-1", "fraudStatus": {"reviewScore": 50}}}`},
+		{"v2 camelCase page", "camelCase", "2", "/api/page", 200, `{"data": [{"fromAccount": "A"}], "meta": {"count": 1, "total": 7, "limit": 1, "offset": 0, "nextCursor": "abc"}}`},
+		{"v2 camelCase error", "camelCase", "2", "/api/error", 422, `{"error": {"status": 422, "message": "Invalid body", "details": {"violations": [{"fieldPath": "/amount"}]}}}`},
+		{"v1 ignores camelCase", "camelCase", "1", "/api/object", 200, `{"from_account": "ACC-1", "fraud_status": {"review_score": 50}}`},
+
+		{"exempt path", "camelCase", "2", "/api/openapi.json", 200, `{"open_api": "3.0"}`},
+		{"unknown version", "", "3", "/api/object", 400, `{"error": "Unsupported API version", "supported": ["1", "2"]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newEnvelopeTestRouter(t, func(c *Config) {
+				if tt.naming != "" {
+					c.APIV2FieldNaming = tt.naming
+				}
+			})
+			headers := map[string]string{}
+			if tt.version != "" {
+				headers["X-API-Version"] = tt.version
+			}
+			w := serve(r, http.MethodGet, tt.path, nil, headers)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d", w.Code, tt.status)
+			}
+			if got, want := decodeJSON(t, w.Body.Bytes()), decodeJSON(t, []byte(tt.want)); !reflect.DeepEqual(got, want) {
+				t.Errorf("body %s\nwant %s", w.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestResponseVersionHeaderAndDefault(t *testing.T) {
+	r := newEnvelopeTestRouter(t, func(c *Config) { c.APIDefaultVersion = "2" })
+	w := serve(r, http.MethodGet, "/api/object", nil, nil)
+	if w.Header().Get("X-API-Version") != "2" {
+		t.Errorf("X-API-Version %q, want 2", w.Header().Get("X-API-Version"))
+	}
+	if _, ok := decodeJSON(t, w.Body.Bytes()).(map[string]interface{})["data"]; !ok {
+		t.Errorf("API_DEFAULT_VERSION=2 body not enveloped: %s", w.Body)
+	}
+	w = serve(r, http.MethodGet, "/api/text", nil, nil)
+	if w.Body.String() != "from_account" {
+		t.Errorf("non-JSON body changed to %q", w.Body)
+	}
+}
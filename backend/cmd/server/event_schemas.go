@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+//go:embed schemas/events
+var eventSchemaFiles embed.FS
+
+// eventSchemaVersion is the schema version outgoing payloads are checked
+// against. A version only ever changes additively; anything that would
+// break a consumer's generated types goes into a new version, and the old
+// one stays published.
+const eventSchemaVersion = "v1"
+
+// eventSchemaBase is the $id prefix of the event schemas. Schemas refer to
+// each other relative to it, like common#/$defs/Transaction.
+const eventSchemaBase = "https://payflow.local/api/schemas/events/"
+
+var eventSchemaViolationsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "payflow_event_schema_violations_total",
+		Help: "Outgoing webhook and event bus payloads that didn't match their schema",
+	},
+	[]string{"schema"},
+)
+
+// EventSchemas holds the published event payload definitions of every
+// version: JSON Schemas, named like "v1/webhook.fraud.alert", and protobuf
+// files, named like "v1/payflow_events.proto".
+type EventSchemas struct {
+	*SchemaRegistry
+	protos map[string][]byte
+}
+
+func loadEventSchemas() (*EventSchemas, error) {
+	versions, err := eventSchemaFiles.ReadDir("schemas/events")
+	if err != nil {
+		return nil, err
+	}
+	reg := &EventSchemas{
+		SchemaRegistry: &SchemaRegistry{
+			raw:      map[string]json.RawMessage{},
+			compiled: map[string]*jsonschema.Schema{},
+		},
+		protos: map[string][]byte{},
+	}
+	compiler := jsonschema.NewCompiler()
+	compiler.Draft = jsonschema.Draft2020
+
+	// All of a version's files are added before any is compiled, so the
+	// references between them resolve.
+	var names []string
+	for _, v := range versions {
+		files, err := eventSchemaFiles.ReadDir("schemas/events/" + v.Name())
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			data, err := eventSchemaFiles.ReadFile("schemas/events/" + v.Name() + "/" + f.Name())
+			if err != nil {
+				return nil, err
+			}
+			if path.Ext(f.Name()) == ".proto" {
+				reg.protos[v.Name()+"/"+f.Name()] = data
+				continue
+			}
+			name := v.Name() + "/" + strings.TrimSuffix(f.Name(), path.Ext(f.Name()))
+			if err := compiler.AddResource(eventSchemaBase+name, bytes.NewReader(data)); err != nil {
+				return nil, fmt.Errorf("event schema %s: %w", name, err)
+			}
+			reg.raw[name] = data
+			names = append(names, name)
+		}
+	}
+	for _, name := range names {
+		schema, err := compiler.Compile(eventSchemaBase + name)
+		if err != nil {
+			return nil, fmt.Errorf("event schema %s: %w", name, err)
+		}
+		reg.compiled[name] = schema
+	}
+	return reg, nil
+}
+
+// Protos returns the names of the protobuf files.
+func (r *EventSchemas) Protos() []string {
+	names := make([]string, 0, len(r.protos))
+	for name := range r.protos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkEventPayload validates an outgoing payload against the current
+// version of the named schema, such as "webhook.fraud.alert", as
+// EVENT_SCHEMA_VALIDATION asks. It reports whether the payload should be
+// sent.
+func (app *App) checkEventPayload(ctx context.Context, schema string, payload []byte) bool {
+	mode := app.config.EventSchemaValidation
+	if mode == "off" || app.eventSchemas == nil {
+		return true
+	}
+	name := eventSchemaVersion + "/" + schema
+	problems, err := app.eventSchemas.Validate(name, payload)
+	if err != nil {
+		// A payload without a schema is a bug here, not in the payload.
+		app.logCtx(ctx, "error", "Event schema validation failed", map[string]interface{}{"schema": name, "error": err.Error()})
+		return true
+	}
+	if len(problems) == 0 {
+		return true
+	}
+	eventSchemaViolationsTotal.WithLabelValues(name).Inc()
+	app.logCtx(ctx, "warn", "Outgoing event does not match its schema", map[string]interface{}{
+		"schema":     name,
+		"violations": problems,
+		"sent":       mode != "enforce",
+	})
+	return mode != "enforce"
+}
+
+// listEventSchemasHandler lists the published event schemas of every
+// version, and which version payloads are currently sent as.
+func (app *App) listEventSchemasHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"current_version": eventSchemaVersion,
+		"schemas":         app.eventSchemas.Names(),
+		"protobuf":        app.eventSchemas.Protos(),
+	})
+}
+
+func (app *App) getEventSchemaHandler(c *gin.Context) {
+	name := c.Param("version") + "/" + c.Param("name")
+	if proto, ok := app.eventSchemas.protos[name]; ok {
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", proto)
+		return
+	}
+	raw, ok := app.eventSchemas.raw[strings.TrimSuffix(name, ".json")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schema not found"})
+		return
+	}
+	c.Data(http.StatusOK, "application/schema+json", raw)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event bus event types. Downstream consumers key off them, so like the
+// domain event names they are never renamed.
+const (
+	StreamTransactionCreated = "transaction.created"
+	StreamFraudAlertRaised   = "fraud.alert.raised"
+)
+
+// StreamEvent is the body of every event bus message. Messages are keyed by
+// transaction ID where the bus has keys.
+type StreamEvent struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	Region    string      `json:"region"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// EventPublisher streams transaction and fraud events to downstream
+// analytics and reconciliation services over the bus chosen by EVENT_BUS.
+// Publishing never blocks or fails the caller; each bus counts what it lost.
+type EventPublisher interface {
+	PublishTransaction(ctx context.Context, txn Transaction)
+	PublishFraudAlert(ctx context.Context, txn Transaction, a FraudAssessment)
+	// Health returns why events are currently not getting through, or nil.
+	Health() error
+	Close() error
+}
+
+// noopPublisher stands in when EVENT_BUS is none.
+type noopPublisher struct{}
+
+func (noopPublisher) PublishTransaction(context.Context, Transaction)                 {}
+func (noopPublisher) PublishFraudAlert(context.Context, Transaction, FraudAssessment) {}
+func (noopPublisher) Health() error                                                   { return nil }
+func (noopPublisher) Close() error                                                    { return nil }
+
+// newEventPublisher sets up the bus, behind the outbox relay when
+// EVENT_OUTBOX is on.
+func newEventPublisher(app *App) EventPublisher {
+	var p EventPublisher = noopPublisher{}
+	switch app.config.EventBus {
+	case "kafka":
+		p = newKafkaPublisher(app)
+	case "nats":
+		p = newNATSPublisher(app)
+	}
+	if bus, ok := p.(relayBus); ok && app.config.EventOutbox {
+		return newEventRelay(app, app.config.EventBus, bus)
+	}
+	return p
+}
+
+func fraudAlertData(txn Transaction, a FraudAssessment) map[string]interface{} {
+	return map[string]interface{}{"transaction": txn, "fraud": a}
+}
+
+// encodeStreamEvent wraps data in a new StreamEvent and marshals it. It
+// reports false when the event can't be sent.
+func (app *App) encodeStreamEvent(ctx context.Context, eventType string, data interface{}) (StreamEvent, []byte, bool) {
+	event := StreamEvent{
+		ID:        uuid.New().String(),
+		Type:      eventType,
+		Region:    app.config.Region,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}
+	value, err := json.Marshal(event)
+	if err != nil {
+		app.logCtx(ctx, "error", "Failed to encode bus event", map[string]interface{}{"event_type": eventType, "error": err.Error()})
+		return event, nil, false
+	}
+	if !app.checkEventPayload(ctx, "stream."+eventType, value) {
+		return event, nil, false
+	}
+	return event, value, true
+}
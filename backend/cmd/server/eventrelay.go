@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	relayPollInterval = time.Second
+	relayBatch        = 100
+	// relaySettleDelay is how old an outbox row must be before it is
+	// relayed. IDs come from a sequence, so a row can commit after one with
+	// a higher ID; waiting keeps the cursor from moving past a row that is
+	// still being inserted.
+	relaySettleDelay = time.Second
+	// relayRetention is how long published events stay in the outbox.
+	relayRetention = time.Hour
+)
+
+var (
+	eventRelayLag = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "payflow_event_relay_lag_events",
+			Help: "Events in the outbox newer than the relay's published cursor",
+		},
+		[]string{"relay"},
+	)
+	eventRelayLagSeconds = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "payflow_event_relay_lag_seconds",
+			Help: "Age of the oldest outbox event the relay hasn't published yet; 0 when it is caught up",
+		},
+		[]string{"relay"},
+	)
+)
+
+// outboxEvent is an encoded bus event waiting in event_outbox.
+type outboxEvent struct {
+	seq       int64
+	eventID   string
+	eventType string
+	key       string
+	payload   []byte
+}
+
+// relayBus is a bus publisher the relay can hand outbox events to. send
+// returns how many of events, in order, the bus took before failing.
+type relayBus interface {
+	EventPublisher
+	send(ctx context.Context, events []outboxEvent) (int, error)
+}
+
+// eventRelay is the EventPublisher used when EVENT_OUTBOX is on. Events
+// are queued in event_outbox and a background loop publishes them in order,
+// checkpointing the ID of the last one the bus took in event_relay_cursors.
+// Every replica runs the loop, but the cursor row is locked for each pass,
+// so one relays at a time. Delivery is at least once: a pass that fails
+// part way leaves its cursor at the last event it knows got through.
+type eventRelay struct {
+	app  *App
+	name string
+	bus  relayBus
+	stop chan struct{}
+	done chan struct{}
+	// ready is set once this relay's cursor row exists.
+	ready bool
+}
+
+func newEventRelay(app *App, name string, bus relayBus) *eventRelay {
+	r := &eventRelay{app: app, name: name, bus: bus, stop: make(chan struct{}), done: make(chan struct{})}
+	go r.run()
+	return r
+}
+
+func (r *eventRelay) PublishTransaction(ctx context.Context, txn Transaction) {
+	r.enqueue(ctx, StreamTransactionCreated, txn.ID, txn, func() { r.bus.PublishTransaction(ctx, txn) })
+}
+
+func (r *eventRelay) PublishFraudAlert(ctx context.Context, txn Transaction, a FraudAssessment) {
+	r.enqueue(ctx, StreamFraudAlertRaised, txn.ID, fraudAlertData(txn, a), func() { r.bus.PublishFraudAlert(ctx, txn, a) })
+}
+
+// enqueue adds an event to the outbox. Without a database it is published
+// directly instead, as it would be with EVENT_OUTBOX off.
+func (r *eventRelay) enqueue(ctx context.Context, eventType, key string, data interface{}, direct func()) {
+	if r.app.db == nil {
+		direct()
+		return
+	}
+	event, value, ok := r.app.encodeStreamEvent(ctx, eventType, data)
+	if !ok {
+		return
+	}
+	_, err := r.app.jobPool().ExecContext(ctx, `
+		INSERT INTO event_outbox (event_id, event_type, key, payload) VALUES ($1, $2, $3, $4)
+	`, event.ID, eventType, key, value)
+	if err != nil {
+		r.app.logCtx(ctx, "warn", "Failed to queue bus event, publishing directly", map[string]interface{}{"event_type": eventType, "error": err.Error()})
+		direct()
+	}
+}
+
+func (r *eventRelay) Health() error { return r.bus.Health() }
+
+// Close stops the relay loop, then the bus. Events still in the outbox are
+// published by another replica or after restart.
+func (r *eventRelay) Close() error {
+	close(r.stop)
+	<-r.done
+	return r.bus.Close()
+}
+
+func (r *eventRelay) run() {
+	defer close(r.done)
+	ticker := time.NewTicker(relayPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+		if r.app.db == nil {
+			continue
+		}
+		// Keep going while full batches come back, so a backlog drains
+		// without waiting for the next tick.
+		for {
+			n, err := r.relay()
+			if err != nil {
+				r.app.log("warn", "Event relay pass failed", map[string]interface{}{"relay": r.name, "error": err.Error()})
+			}
+			if err != nil || n < relayBatch {
+				break
+			}
+		}
+		r.measureLag()
+	}
+}
+
+// relay publishes the next batch of settled outbox events after the cursor
+// and moves the cursor past those the bus took. It returns how many were
+// published; none when another instance holds the cursor.
+func (r *eventRelay) relay() (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	db := r.app.jobPool()
+	if !r.ready {
+		// A new relay starts at the newest event rather than replaying the
+		// outbox another bus has already published.
+		if _, err := db.ExecContext(ctx, `
+			INSERT INTO event_relay_cursors (relay, last_event_id)
+			SELECT $1, COALESCE(MAX(id), 0) FROM event_outbox
+			ON CONFLICT (relay) DO NOTHING
+		`, r.name); err != nil {
+			return 0, err
+		}
+		r.ready = true
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var cursor int64
+	err = tx.QueryRowContext(ctx, `
+		SELECT last_event_id FROM event_relay_cursors WHERE relay = $1 FOR UPDATE SKIP LOCKED
+	`, r.name).Scan(&cursor)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, event_id, event_type, key, payload FROM event_outbox
+		WHERE id > $1 AND created_at <= NOW() - make_interval(secs => $2)
+		ORDER BY id
+		LIMIT $3
+	`, cursor, relaySettleDelay.Seconds(), relayBatch)
+	if err != nil {
+		return 0, err
+	}
+	var events []outboxEvent
+	for rows.Next() {
+		var e outboxEvent
+		if err := rows.Scan(&e.seq, &e.eventID, &e.eventType, &e.key, &e.payload); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		events = append(events, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	sent, sendErr := r.bus.send(ctx, events)
+	if sent > 0 {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE event_relay_cursors SET last_event_id = $2, updated_at = NOW() WHERE relay = $1
+		`, r.name, events[sent-1].seq); err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, `
+			DELETE FROM event_outbox WHERE id <= $1 AND created_at < NOW() - make_interval(secs => $2)
+		`, events[sent-1].seq, relayRetention.Seconds()); err != nil {
+			return 0, err
+		}
+		if err := tx.Commit(); err != nil {
+			return 0, err
+		}
+	}
+	return sent, sendErr
+}
+
+// measureLag sets the lag gauges from the newest outbox event and the
+// published cursor, which every instance can read.
+func (r *eventRelay) measureLag() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var lag int64
+	var seconds float64
+	err := r.app.jobPool().QueryRowContext(ctx, `
+		SELECT COALESCE(MAX(o.id) - c.last_event_id, 0),
+			COALESCE(EXTRACT(EPOCH FROM NOW() - MIN(o.created_at)), 0)
+		FROM event_relay_cursors c
+		LEFT JOIN event_outbox o ON o.id > c.last_event_id
+		WHERE c.relay = $1
+		GROUP BY c.last_event_id
+	`, r.name).Scan(&lag, &seconds)
+	if err == sql.ErrNoRows {
+		return
+	}
+	if err != nil {
+		r.app.log("warn", "Failed to measure event relay lag", map[string]interface{}{"relay": r.name, "error": err.Error()})
+		return
+	}
+	eventRelayLag.WithLabelValues(r.name).Set(float64(lag))
+	eventRelayLagSeconds.WithLabelValues(r.name).Set(seconds)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/infrasage/payflow/internal/dbtest"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeOutbox is event_outbox and event_relay_cursors. Cursor moves made in
+// a database transaction only land when it commits.
+type fakeOutbox struct {
+	events  []outboxEvent
+	cursors map[string]int64
+	pending map[string]int64
+	// locked has another instance hold every cursor row.
+	locked bool
+}
+
+func (o *fakeOutbox) newest() int64 {
+	if len(o.events) == 0 {
+		return 0
+	}
+	return o.events[len(o.events)-1].seq
+}
+
+func (o *fakeOutbox) run(q dbtest.Query) (*dbtest.Rows, error) {
+	switch {
+	case q.HasPrefix("INSERT INTO event_outbox"):
+		o.events = append(o.events, outboxEvent{seq: o.newest() + 1, eventID: q.String(0), eventType: q.String(1), key: q.String(2), payload: q.Args[3].([]byte)})
+		return dbtest.Affected(1), nil
+	case q.HasPrefix("INSERT INTO event_relay_cursors"):
+		if _, ok := o.cursors[q.String(0)]; !ok {
+			o.cursors[q.String(0)] = o.newest()
+		}
+		return dbtest.Affected(1), nil
+	case q.HasPrefix("SELECT last_event_id FROM event_relay_cursors"):
+		rows := dbtest.NewRows("last_event_id")
+		if cursor, ok := o.cursors[q.String(0)]; ok && !o.locked {
+			rows.Add(cursor)
+		}
+		return rows, nil
+	case q.HasPrefix("SELECT id, event_id, event_type, key, payload FROM event_outbox"):
+		rows := dbtest.NewRows("id", "event_id", "event_type", "key", "payload")
+		for _, e := range o.events {
+			if e.seq > q.Args[0].(int64) {
+				rows.Add(e.seq, e.eventID, e.eventType, e.key, e.payload)
+			}
+		}
+		return rows, nil
+	case q.HasPrefix("UPDATE event_relay_cursors"):
+		o.pending[q.String(0)] = q.Args[1].(int64)
+		return dbtest.Affected(1), nil
+	case q.HasPrefix("DELETE FROM event_outbox"):
+		// Nothing is older than relayRetention in these tests.
+		return dbtest.Affected(0), nil
+	case q.Contains("FROM event_relay_cursors c LEFT JOIN event_outbox"):
+		return dbtest.NewRows("lag", "seconds").Add(o.newest()-o.cursors[q.String(0)], 0.0), nil
+	}
+	return nil, dbtest.Unexpected(q)
+}
+
+func (o *fakeOutbox) begin() { o.pending = map[string]int64{} }
+
+func (o *fakeOutbox) commit() {
+	for relay, cursor := range o.pending {
+		o.cursors[relay] = cursor
+	}
+}
+
+// fakeBus records the events it is sent. failAfter makes send fail once it
+// has taken that many in one call; -1 never fails.
+type fakeBus struct {
+	noopPublisher
+	sent      []outboxEvent
+	failAfter int
+}
+
+func (b *fakeBus) send(_ context.Context, events []outboxEvent) (int, error) {
+	for i, e := range events {
+		if i == b.failAfter {
+			return i, errors.New("broker unavailable")
+		}
+		b.sent = append(b.sent, e)
+	}
+	return len(events), nil
+}
+
+func TestEventRelayResumesFromCursor(t *testing.T) {
+	outbox := &fakeOutbox{cursors: map[string]int64{}}
+	app := newTestApp(t, nil)
+	app.db = dbtest.New(outbox.run).WithTx(dbtest.Tx{Begin: outbox.begin, Commit: outbox.commit}).Open(t)
+	ctx := context.Background()
+	bus := &fakeBus{failAfter: -1}
+	relay := &eventRelay{app: app, name: "kafka", bus: bus}
+
+	relay.PublishTransaction(ctx, Transaction{ID: "txn-1", Status: "success"})
+	relay.PublishFraudAlert(ctx, Transaction{ID: "txn-1", Status: "success"}, FraudAssessment{Decision: "review"})
+	if len(bus.sent) != 0 || len(outbox.events) != 2 {
+		t.Fatalf("published %d directly, %d queued; want both queued", len(bus.sent), len(outbox.events))
+	}
+	// A relay starting on an outbox with history starts at its end.
+	if n, err := relay.relay(); n != 0 || err != nil || outbox.cursors["kafka"] != 2 {
+		t.Fatalf("first pass: %d published, err %v, cursor %d; want a new cursor at 2", n, err, outbox.cursors["kafka"])
+	}
+	outbox.cursors["kafka"] = 0
+	if n, err := relay.relay(); n != 2 || err != nil {
+		t.Fatalf("relayed %d, err %v, want 2", n, err)
+	}
+	if bus.sent[0].eventType != StreamTransactionCreated || bus.sent[1].eventType != StreamFraudAlertRaised || bus.sent[1].key != "txn-1" {
+		t.Errorf("sent %+v", bus.sent)
+	}
+	if outbox.cursors["kafka"] != 2 {
+		t.Errorf("cursor %d, want 2", outbox.cursors["kafka"])
+	}
+
+	// A restarted relay picks up after the checkpoint. When the bus fails
+	// part way, the cursor stops at the last event it took.
+	bus = &fakeBus{failAfter: 1}
+	relay = &eventRelay{app: app, name: "kafka", bus: bus}
+	relay.PublishTransaction(ctx, Transaction{ID: "txn-2", Status: "success"})
+	relay.PublishTransaction(ctx, Transaction{ID: "txn-3", Status: "success"})
+	if n, err := relay.relay(); n != 1 || err == nil {
+		t.Fatalf("relayed %d, err %v, want 1 and an error", n, err)
+	}
+	relay.measureLag()
+	if lag := testutil.ToFloat64(eventRelayLag.WithLabelValues("kafka")); lag != 1 {
+		t.Errorf("lag %v, want 1", lag)
+	}
+	bus.failAfter = -1
+	if n, err := relay.relay(); n != 1 || err != nil {
+		t.Fatalf("retry relayed %d, err %v, want 1", n, err)
+	}
+	if len(bus.sent) != 2 || bus.sent[0].key != "txn-2" || bus.sent[1].key != "txn-3" {
+		t.Errorf("sent %+v, want txn-2 then txn-3 once each", bus.sent)
+	}
+	relay.measureLag()
+	if lag := testutil.ToFloat64(eventRelayLag.WithLabelValues("kafka")); lag != 0 {
+		t.Errorf("lag %v once caught up, want 0", lag)
+	}
+
+	outbox.locked = true
+	relay.PublishTransaction(ctx, Transaction{ID: "txn-4", Status: "success"})
+	if n, err := relay.relay(); n != 0 || err != nil || len(bus.sent) != 2 {
+		t.Errorf("relayed %d, err %v while another instance holds the cursor", n, err)
+	}
+}
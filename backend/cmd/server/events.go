@@ -0,0 +1,100 @@
+package main
+
+import "context"
+
+// Domain event types. These names are a stable contract for log-based
+// alerting and the demo analyzer: add new ones freely, but never rename or
+// repurpose an existing one. Messages alongside them are for humans and may
+// change.
+const (
+	EventTransactionCreated     = "transaction.created"
+	EventTransactionDeclined    = "transaction.declined"
+	EventTransactionWriteFailed = "transaction.write_failed"
+	EventTransactionSpooled     = "transaction.spooled"
+	EventTransactionRefunded    = "transaction.refunded"
+	EventTransactionHeld        = "transaction.held"
+	EventTransactionExpired     = "transaction.expired"
+	EventSpoolReplayed          = "spool.replayed"
+	EventDuplicatesMerged       = "duplicates.merged"
+	EventStatementImported      = "statement.imported"
+	EventLedgerTampered         = "ledger.tampered"
+	EventDayClosed              = "ledger.day_closed"
+	EventCloseException         = "ledger.close_exception"
+	EventDaySettled             = "ledger.day_settled"
+	EventSettlementRejected     = "ledger.settlement_rejected"
+	EventAnomalyDetected        = "anomaly.detected"
+	EventIncidentOpened         = "incident.opened"
+	EventIncidentResolved       = "incident.resolved"
+	EventConfigOverridden       = "config.overridden"
+	EventConfigReloaded         = "config.reloaded"
+	EventConfigReloadRejected   = "config.reload_rejected"
+	EventDemoSessionCreated     = "demo_session.created"
+	EventDemoSessionRemoved     = "demo_session.removed"
+	EventTenantProvisioned      = "tenant.provisioned"
+	EventTokenIssued            = "auth.token_issued"
+	EventAPIKeyIssued           = "auth.api_key_issued"
+	EventAPIKeyRevoked          = "auth.api_key_revoked"
+	EventAuthzDecision          = "authz.decision"
+	EventChaosErrorInjected     = "chaos.error_injected"
+	EventChaosPanicInjected     = "chaos.panic_injected"
+	EventChaosFaultStarted      = "chaos.fault_started"
+	EventChaosFaultStopped      = "chaos.fault_stopped"
+	EventChaosConfigured        = "chaos.configured"
+	EventChaosResponseDropped   = "chaos.response_dropped"
+	EventChaosScenarioStarted   = "chaos.scenario_started"
+	EventChaosScenarioStep      = "chaos.scenario_step"
+	EventChaosScenarioFinished  = "chaos.scenario_finished"
+	EventChaosScenarioStopped   = "chaos.scenario_stopped"
+	EventFailoverPromoted       = "failover.promoted"
+	EventFailoverDemoted        = "failover.demoted"
+	EventRegistryRegistered     = "registry.registered"
+	EventRegistryDeregistered   = "registry.deregistered"
+	EventAccountOpened          = "account.opened"
+	EventAccountClosed          = "account.closed"
+	EventPrivacyErased          = "privacy.erased"
+	EventPrivacyExportReady     = "privacy.export_ready"
+	EventTokensDetokenized      = "tokens.detokenized"
+	EventTokensKeyRotated       = "tokens.key_rotated"
+	EventTokensReencrypted      = "tokens.reencrypted"
+	EventFraudRulesReloaded     = "fraud.rules_reloaded"
+	EventFraudShadowStarted     = "fraud.shadow_started"
+	EventFraudRulesPromoted     = "fraud.rules_promoted"
+	EventFraudFlagged           = "fraud.flagged"
+	EventFraudAlertTriaged      = "fraud.alert_triaged"
+	EventFraudReviewed          = "fraud.reviewed"
+	EventFraudPoolScaled        = "fraud.pool_scaled"
+	EventFraudAlertSpike        = "fraud.alert_spike"
+	EventFraudBackfillStarted   = "fraud.backfill_started"
+	EventFraudBackfillFinished  = "fraud.backfill_finished"
+	EventFraudBackfillDeleted   = "fraud.backfill_deleted"
+	EventGuardrailOverridden    = "guardrail.overridden"
+	EventWebhookRegistered      = "webhook.registered"
+	EventWebhookDeleted         = "webhook.deleted"
+	EventWebhookSecretRotated   = "webhook.secret_rotated"
+	EventWebhookDeliveryFailed  = "webhook.delivery_failed"
+	EventRateLimitsChanged      = "rate_limits.changed"
+	EventReceiptBounced         = "receipt.bounced"
+	EventReceiptFailed          = "receipt.failed"
+)
+
+// event logs a machine-readable domain event. entityID identifies the thing
+// the event is about (a transaction ID, session ID, metric name...) and may
+// be empty for service-wide events.
+func (app *App) event(level, eventType, entityID, message string, attributes map[string]interface{}) {
+	app.eventCtx(context.Background(), level, eventType, entityID, message, attributes)
+}
+
+// eventCtx logs an event with the ID of the request behind ctx as trace_id.
+func (app *App) eventCtx(ctx context.Context, level, eventType, entityID, message string, attributes map[string]interface{}) {
+	if t := captureFrom(ctx); t != nil {
+		t.step(CaptureStep{Kind: "event", Level: level, EventType: eventType, Message: message, Data: attributes})
+	}
+	app.write(StructuredLog{
+		Level:      level,
+		TraceID:    traceIDFrom(ctx),
+		Message:    message,
+		EventType:  eventType,
+		EntityID:   entityID,
+		Attributes: attributes,
+	})
+}
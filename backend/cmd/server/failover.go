@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var failoverActive = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "payflow_failover_active",
+		Help: "1 while this instance is the active member of its failover pair",
+	},
+)
+
+type heartbeat struct {
+	Instance string    `json:"instance"`
+	Region   string    `json:"region"`
+	At       time.Time `json:"at"`
+}
+
+// FailoverController coordinates an active-passive pair through Redis. The
+// primary publishes a heartbeat; the standby watches it, promotes itself when
+// it goes stale and demotes again once the primary is back. A standby that
+// cannot reach Redis keeps its current state rather than risk two actives.
+type FailoverController struct {
+	app      *App
+	role     string
+	key      string
+	instance string
+	interval time.Duration
+	stale    time.Duration
+
+	mu          sync.RWMutex
+	active      bool
+	changedAt   time.Time
+	lastPrimary *heartbeat
+}
+
+// FailoverStatus is what /ready reports about the pair.
+type FailoverStatus struct {
+	Role          string     `json:"role"`
+	Active        bool       `json:"active"`
+	Since         time.Time  `json:"since"`
+	PrimarySeenAt *time.Time `json:"primary_seen_at,omitempty"`
+}
+
+func (app *App) startFailover() {
+	if app.config.FailoverRole == "none" {
+		return
+	}
+	instance, _ := os.Hostname()
+	f := &FailoverController{
+		app:       app,
+		role:      app.config.FailoverRole,
+		key:       "payflow:failover:" + app.config.FailoverGroup + ":primary",
+		instance:  instance,
+		interval:  time.Duration(app.config.FailoverHeartbeatSec) * time.Second,
+		stale:     time.Duration(app.config.FailoverStaleSec) * time.Second,
+		active:    app.config.FailoverRole == "primary",
+		changedAt: time.Now().UTC(),
+	}
+	app.failover = f
+	f.setGauge()
+	app.log("info", "Failover controller started", map[string]interface{}{
+		"role":  f.role,
+		"group": app.config.FailoverGroup,
+	})
+
+	go func() {
+		for {
+			f.tick()
+			time.Sleep(f.interval)
+		}
+	}()
+}
+
+func (f *FailoverController) tick() {
+	ctx, cancel := context.WithTimeout(context.Background(), f.interval)
+	defer cancel()
+	rc := f.app.redisClient
+
+	if f.role == "primary" {
+		raw, _ := json.Marshal(heartbeat{Instance: f.instance, Region: f.app.config.Region, At: time.Now().UTC()})
+		if err := rc.Set(ctx, f.key, raw, f.stale).Err(); err != nil {
+			f.app.log("warn", "Failed to publish failover heartbeat", map[string]interface{}{"error": err.Error()})
+		}
+		return
+	}
+
+	raw, err := rc.Get(ctx, f.key).Bytes()
+	if err != nil && err != redis.Nil {
+		f.app.log("warn", "Failover heartbeat unreadable, keeping current state", map[string]interface{}{"error": err.Error()})
+		return
+	}
+
+	var hb heartbeat
+	fresh := err == nil && json.Unmarshal(raw, &hb) == nil && time.Since(hb.At) < f.stale
+	f.mu.Lock()
+	if fresh {
+		f.lastPrimary = &hb
+	}
+	f.mu.Unlock()
+
+	switch {
+	case !fresh && !f.isActive():
+		f.transition(true, EventFailoverPromoted, "Standby promoted: primary heartbeat is stale")
+	case fresh && f.isActive():
+		f.transition(false, EventFailoverDemoted, "Standby demoted: primary heartbeat recovered")
+	}
+}
+
+func (f *FailoverController) isActive() bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.active
+}
+
+func (f *FailoverController) transition(active bool, eventType, message string) {
+	f.mu.Lock()
+	f.active = active
+	f.changedAt = time.Now().UTC()
+	last := f.lastPrimary
+	f.mu.Unlock()
+	f.setGauge()
+
+	attrs := map[string]interface{}{"role": f.role, "stale_after_sec": f.stale.Seconds()}
+	if last != nil {
+		attrs["primary_instance"] = last.Instance
+		attrs["primary_seen_at"] = last.At
+	}
+	f.app.event("warn", eventType, f.instance, message, attrs)
+}
+
+func (f *FailoverController) setGauge() {
+	if f.isActive() {
+		failoverActive.Set(1)
+	} else {
+		failoverActive.Set(0)
+	}
+}
+
+func (f *FailoverController) Status() FailoverStatus {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	s := FailoverStatus{Role: f.role, Active: f.active, Since: f.changedAt}
+	if f.lastPrimary != nil {
+		at := f.lastPrimary.At
+		s.PrimarySeenAt = &at
+	}
+	return s
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/infrasage/payflow/internal/fraud"
+	"gopkg.in/yaml.v3"
+)
+
+// RuleSpec configures one fraud rule.
+type RuleSpec = fraud.RuleSpec
+
+// RuleSet is a compiled set of fraud rules.
+type RuleSet = fraud.RuleSet
+
+// RuleHit is one rule that fired for a transaction.
+type RuleHit = fraud.Hit
+
+// FraudAssessment is the result of running a rule set over a transaction.
+type FraudAssessment = fraud.Assessment
+
+// fraudSource answers the rules' lookups from the database and the
+// counterparty enricher.
+type fraudSource struct {
+	app *App
+}
+
+func (s fraudSource) RecentPayments(ctx context.Context, txn Transaction, window time.Duration) (int, error) {
+	if s.app.db == nil {
+		return 0, fmt.Errorf("database not initialized")
+	}
+	var n int
+	err := s.app.jobPool().QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM transactions
+		WHERE from_account = $1 AND id <> $2 AND created_at >= $3 AND created_at <= $4
+		  AND session_id IS NOT DISTINCT FROM $5
+	`, txn.FromAccount, txn.ID, txn.CreatedAt.Add(-window), txn.CreatedAt, sessionArg(txn.SessionID)).Scan(&n)
+	return n, err
+}
+
+func (s fraudSource) Payee(ctx context.Context, txn Transaction) (*Counterparty, error) {
+	if s.app.enricher == nil {
+		return nil, nil
+	}
+	return s.app.enricher.get(ctx, txn.ToAccount)
+}
+
+// FraudDetector scores transactions against the active rule set, which can
+// be swapped at runtime with Reload.
+type FraudDetector struct {
+	app *App
+
+	mu     sync.RWMutex
+	rules  *RuleSet
+	shadow *ShadowRun
+}
+
+// initFraud loads the configured rules. If they don't load the detector
+// starts with no rules, so nothing is flagged until a reload succeeds.
+func (app *App) initFraud() {
+	app.fraud = &FraudDetector{app: app}
+	app.fraud.rules, _ = fraud.Compile("none", nil)
+	set, err := app.fraud.Reload(context.Background())
+	if err != nil {
+		app.log("error", "Fraud rules failed to load, no rules are active", map[string]interface{}{
+			"source": app.config.FraudRulesSource,
+			"error":  err.Error(),
+		})
+		return
+	}
+	app.log("info", "Fraud rules loaded", map[string]interface{}{
+		"source":  set.Source,
+		"version": set.Version,
+		"rules":   len(set.Enabled()),
+	})
+}
+
+// Rules returns the active rule set.
+func (d *FraudDetector) Rules() *RuleSet {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.rules
+}
+
+// Reload reads the rules from the configured source and activates them. On
+// any error the previous rule set stays active.
+func (d *FraudDetector) Reload(ctx context.Context) (*RuleSet, error) {
+	set, err := d.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	d.activate(set)
+	return set, nil
+}
+
+func (d *FraudDetector) activate(set *RuleSet) {
+	d.mu.Lock()
+	d.rules = set
+	d.mu.Unlock()
+}
+
+// load reads and compiles the rules from the configured source.
+func (d *FraudDetector) load(ctx context.Context) (*RuleSet, error) {
+	cfg := d.app.config
+	var specs []RuleSpec
+	source := cfg.FraudRulesSource
+	switch source {
+	case "file":
+		raw, err := os.ReadFile(cfg.FraudRulesFile)
+		if err != nil {
+			return nil, err
+		}
+		var doc struct {
+			Rules []RuleSpec `yaml:"rules"`
+		}
+		if err := yaml.Unmarshal(raw, &doc); err != nil {
+			return nil, fmt.Errorf("%s: %w", cfg.FraudRulesFile, err)
+		}
+		specs = doc.Rules
+		source = "file:" + cfg.FraudRulesFile
+	case "table":
+		var err error
+		if specs, err = d.loadTableRules(ctx); err != nil {
+			return nil, err
+		}
+	default:
+		specs = fraud.DefaultRuleSpecs
+	}
+
+	return fraud.Compile(source, specs)
+}
+
+func (d *FraudDetector) loadTableRules(ctx context.Context) ([]RuleSpec, error) {
+	if d.app.db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+	rows, err := d.app.db.QueryContext(ctx, `SELECT name, type, enabled, score, params FROM fraud_rules ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	specs := []RuleSpec{}
+	for rows.Next() {
+		var spec RuleSpec
+		var enabled bool
+		var params []byte
+		if err := rows.Scan(&spec.Name, &spec.Type, &enabled, &spec.Score, &params); err != nil {
+			return nil, err
+		}
+		spec.Enabled = &enabled
+		if err := json.Unmarshal(params, &spec.Params); err != nil {
+			return nil, fmt.Errorf("rule %q: params: %w", spec.Name, err)
+		}
+		specs = append(specs, spec)
+	}
+	return specs, rows.Err()
+}
+
+// AnalyzeTransaction runs the active rules over txn. A rule that errors is
+// reported in the assessment and treated as not firing. While a shadow run is
+// in progress the candidate rules are scored too and compared; only the
+// active assessment is returned.
+func (d *FraudDetector) AnalyzeTransaction(ctx context.Context, txn Transaction) *FraudAssessment {
+	d.mu.RLock()
+	set, shadow := d.rules, d.shadow
+	d.mu.RUnlock()
+	a := d.assess(ctx, set, txn)
+	if shadow.running(time.Now()) {
+		shadow.record(a, d.assess(ctx, shadow.candidate, txn))
+	}
+	return a
+}
+
+func (d *FraudDetector) assess(ctx context.Context, set *RuleSet, txn Transaction) *FraudAssessment {
+	defer meterFraud(requestCostFrom(ctx).mark())
+	a := set.Assess(ctx, &fraud.Input{Transaction: txn, Source: fraudSource{d.app}})
+	a.Decision = d.decide(a.Score)
+	return a
+}
+
+func (d *FraudDetector) decide(score float64) string {
+	cfg := d.app.liveConfig()
+	return fraud.Decide(score, cfg.FraudReviewScore, cfg.FraudBlockScore)
+}
+
+func (app *App) getFraudRulesHandler(c *gin.Context) {
+	cfg := app.liveConfig()
+	c.JSON(http.StatusOK, gin.H{
+		"active":       app.fraud.Rules(),
+		"types":        fraud.RuleTypes(),
+		"review_score": cfg.FraudReviewScore,
+		"block_score":  cfg.FraudBlockScore,
+	})
+}
+
+func (app *App) reloadFraudRulesHandler(c *gin.Context) {
+	active := app.fraud.Rules()
+	previous := active.Version
+	set, err := app.fraud.load(c.Request.Context())
+	if err != nil {
+		app.logCtx(c.Request.Context(), "error", "Fraud rules reload failed, keeping the active set", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "active_version": previous})
+		return
+	}
+	if !app.passGuardrails(c, "fraud.rules_reload", set.Version, ruleSetGuardrails(active, set, app.liveConfig().FraudBlockScore)) {
+		return
+	}
+	app.fraud.activate(set)
+	app.eventCtx(c.Request.Context(), "info", EventFraudRulesReloaded, set.Version, "Fraud rules reloaded", map[string]interface{}{
+		"source":           set.Source,
+		"previous_version": previous,
+		"rules":            len(set.Enabled()),
+		"actor":            adminActor(c),
+	})
+	c.JSON(http.StatusOK, set)
+}
+
+// evaluateFraudHandler scores a hypothetical transaction against the active
+// rules, and the shadow candidate if there is one, without storing anything.
+func (app *App) evaluateFraudHandler(c *gin.Context) {
+	var req struct {
+		FromAccount string  `json:"from_account" binding:"required"`
+		ToAccount   string  `json:"to_account" binding:"required"`
+		Amount      float64 `json:"amount" binding:"required,gt=0"`
+		Description string  `json:"description"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx := c.Request.Context()
+	txn := Transaction{
+		Amount:      math.Round(req.Amount*100) / 100,
+		Description: req.Description,
+		CreatedAt:   time.Now().UTC(),
+		SessionID:   sessionID(c),
+	}
+	var err error
+	if txn.FromAccount, err = app.vault.Resolve(ctx, req.FromAccount); err == nil {
+		txn.ToAccount, err = app.vault.Resolve(ctx, req.ToAccount)
+	}
+	if err != nil {
+		app.logCtx(ctx, "error", "Token lookup failed", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Vault error"})
+		return
+	}
+	// Dry runs go straight to assess so they never count towards a shadow
+	// run's comparison.
+	run, _ := app.fraud.shadowRun()
+	resp := gin.H{"active": app.fraud.assess(ctx, app.fraud.Rules(), txn)}
+	if run != nil {
+		resp["candidate"] = app.fraud.assess(ctx, run.candidate, txn)
+	}
+	c.JSON(http.StatusOK, resp)
+}
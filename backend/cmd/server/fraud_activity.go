@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/infrasage/payflow/internal/fraud"
+)
+
+const (
+	// defaultActivitySpan is how far back an activity window reaches when
+	// ?since isn't given.
+	defaultActivitySpan = time.Hour
+	// maxActivitySpan is the longest activity window that can be asked for.
+	maxActivitySpan = 24 * time.Hour
+)
+
+// ActivityWindow is what the velocity rules see of an account's payments
+// from Since up to Until: how many it sent each minute and, for each rule,
+// the payments it fired for.
+type ActivityWindow struct {
+	Account        string             `json:"account"`
+	Since          time.Time          `json:"since"`
+	Until          time.Time          `json:"until"`
+	RuleSetVersion string             `json:"rule_set_version"`
+	Minutes        []ActivityMinute   `json:"minutes"`
+	Rules          []VelocityActivity `json:"rules"`
+}
+
+// ActivityMinute is how many payments an account sent in the minute
+// starting at Minute.
+type ActivityMinute struct {
+	Minute   time.Time `json:"minute"`
+	Payments int       `json:"payments"`
+}
+
+// VelocityActivity is one velocity rule over an activity window. Peak is
+// the most other payments any payment had in the rule's window, and Fired
+// the payments with at least max_count.
+type VelocityActivity struct {
+	fraud.VelocityLimit
+	Peak  int              `json:"peak"`
+	Fired []VelocityWindow `json:"fired"`
+}
+
+// VelocityWindow is a payment and the Count other payments the payer sent
+// from WindowStart up to it.
+type VelocityWindow struct {
+	TransactionID string    `json:"transaction_id"`
+	At            time.Time `json:"at"`
+	WindowStart   time.Time `json:"window_start"`
+	Count         int       `json:"count"`
+}
+
+// activityPayment is a payment as the velocity rules count it. Unscored
+// payments, internal transfers and refunds, count towards others' windows
+// but are never assessed themselves.
+type activityPayment struct {
+	id       string
+	at       time.Time
+	unscored bool
+}
+
+// activityWindowHandler returns the account's activity window: from ?since,
+// by default an hour before ?until, up to ?until, by default now. The
+// windows are worked out again from the stored payments with the active
+// rules, so they match what live analysis saw as long as the rules haven't
+// changed since.
+func (app *App) activityWindowHandler(c *gin.Context) {
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	until, hasUntil, err := parseTimeParam(c, "until", true)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !hasUntil {
+		until = time.Now().UTC()
+	}
+	since, hasSince, err := parseTimeParam(c, "since", false)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !hasSince {
+		since = until.Add(-defaultActivitySpan)
+	}
+	if !since.Before(until) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "since must be before until"})
+		return
+	}
+	if until.Sub(since) > maxActivitySpan {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The window can be at most 24 hours"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	account, err := app.vault.Resolve(ctx, c.Param("id"))
+	if err != nil {
+		app.logCtx(ctx, "error", "Token lookup failed", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Vault error"})
+		return
+	}
+	set := app.fraud.Rules()
+	limits := set.VelocityLimits()
+	// Payments before since still count towards the windows of the first
+	// ones in it.
+	reach := 0
+	for _, l := range limits {
+		reach = max(reach, l.WindowSec)
+	}
+	payments, err := app.accountPayments(ctx, account, sessionID(c), since.Add(-time.Duration(reach)*time.Second), until)
+	if err != nil {
+		app.logCtx(ctx, "error", "Failed to read account activity", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	window := ActivityWindow{
+		Account:        c.Param("id"),
+		Since:          since,
+		Until:          until,
+		RuleSetVersion: set.Version,
+		Minutes:        activityMinutes(payments, since, until),
+		Rules:          make([]VelocityActivity, 0, len(limits)),
+	}
+	for _, l := range limits {
+		window.Rules = append(window.Rules, velocityActivity(payments, since, l))
+	}
+	c.JSON(http.StatusOK, window)
+}
+
+// accountPayments returns the payments account sent from start up to end,
+// oldest first.
+func (app *App) accountPayments(ctx context.Context, account, session string, start, end time.Time) ([]activityPayment, error) {
+	rows, err := app.readPool().QueryContext(ctx, `
+		SELECT id, created_at, internal OR refund_of IS NOT NULL
+		FROM transactions
+		WHERE from_account = $1 AND session_id IS NOT DISTINCT FROM $2 AND created_at >= $3 AND created_at < $4
+		ORDER BY created_at, id
+	`, account, sessionArg(session), start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var payments []activityPayment
+	for rows.Next() {
+		var p activityPayment
+		if err := rows.Scan(&p.id, &p.at, &p.unscored); err != nil {
+			return nil, err
+		}
+		payments = append(payments, p)
+	}
+	return payments, rows.Err()
+}
+
+// activityMinutes counts payments per minute from since up to until,
+// including the minutes without any.
+func activityMinutes(payments []activityPayment, since, until time.Time) []ActivityMinute {
+	first := since.Truncate(time.Minute)
+	minutes := []ActivityMinute{}
+	for m := first; m.Before(until); m = m.Add(time.Minute) {
+		minutes = append(minutes, ActivityMinute{Minute: m})
+	}
+	for _, p := range payments {
+		if p.at.Before(since) || !p.at.Before(until) {
+			continue
+		}
+		minutes[int(p.at.Sub(first)/time.Minute)].Payments++
+	}
+	return minutes
+}
+
+// velocityActivity evaluates l over the scored payments from since on the
+// way the velocity rule does: against the payer's other payments created
+// in the l.WindowSec up to and including the payment's time. payments must
+// be oldest first.
+func velocityActivity(payments []activityPayment, since time.Time, l fraud.VelocityLimit) VelocityActivity {
+	va := VelocityActivity{VelocityLimit: l, Fired: []VelocityWindow{}}
+	span := time.Duration(l.WindowSec) * time.Second
+	for _, p := range payments {
+		if p.unscored || p.at.Before(since) {
+			continue
+		}
+		start := p.at.Add(-span)
+		from := sort.Search(len(payments), func(i int) bool { return !payments[i].at.Before(start) })
+		to := sort.Search(len(payments), func(i int) bool { return payments[i].at.After(p.at) })
+		n := to - from - 1
+		va.Peak = max(va.Peak, n)
+		if n >= l.MaxCount {
+			va.Fired = append(va.Fired, VelocityWindow{TransactionID: p.id, At: p.at, WindowStart: start, Count: n})
+		}
+	}
+	return va
+}
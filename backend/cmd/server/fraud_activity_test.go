@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/infrasage/payflow/internal/fraud"
+)
+
+func TestVelocityActivity(t *testing.T) {
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(sec int) time.Time { return base.Add(time.Duration(sec) * time.Second) }
+	payments := []activityPayment{
+		{id: "before", at: at(-30)},
+		{id: "p1", at: at(0)},
+		{id: "p2", at: at(10)},
+		{id: "refund", at: at(20), unscored: true},
+		{id: "p3", at: at(30)},
+		{id: "p4", at: at(30)},
+		{id: "p5", at: at(200)},
+	}
+	limit := fraud.VelocityLimit{Rule: "payer_velocity", MaxCount: 4, WindowSec: 60}
+
+	va := velocityActivity(payments, base, limit)
+	if va.Peak != 5 {
+		t.Errorf("peak %d, want 5", va.Peak)
+	}
+	// p3 and p4 each see the other, p1, p2, the refund and the payment from
+	// before since. The refund isn't scored.
+	var fired []string
+	for _, w := range va.Fired {
+		fired = append(fired, w.TransactionID)
+		if w.Count != 5 || !w.WindowStart.Equal(at(-30)) {
+			t.Errorf("%s: count %d from %s, want 5 from %s", w.TransactionID, w.Count, w.WindowStart, at(-30))
+		}
+	}
+	if len(fired) != 2 || fired[0] != "p3" || fired[1] != "p4" {
+		t.Errorf("fired for %v, want [p3 p4]", fired)
+	}
+
+	minutes := activityMinutes(payments, base, at(240))
+	want := []int{5, 0, 0, 1}
+	if len(minutes) != len(want) {
+		t.Fatalf("%d minutes, want %d", len(minutes), len(want))
+	}
+	for i, m := range minutes {
+		if m.Payments != want[i] || !m.Minute.Equal(at(60*i)) {
+			t.Errorf("minute %d: %d payments at %s, want %d at %s", i, m.Payments, m.Minute, want[i], at(60*i))
+		}
+	}
+}
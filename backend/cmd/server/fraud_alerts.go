@@ -0,0 +1,227 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	apihttp "github.com/infrasage/payflow/internal/http"
+	"github.com/infrasage/payflow/internal/store"
+)
+
+// fraudAlertSeverities are the decisions that raise a fraud alert, which
+// ?severity filters by.
+var fraudAlertSeverities = []string{"review", "block"}
+
+// fraudAlertStatuses are the triage states of a fraud alert.
+var fraudAlertStatuses = []string{"open", "acknowledged", "resolved"}
+
+// canTriageFraud reports whether p is a fraud analyst, by role or, for
+// machine clients, the fraud:triage scope.
+func canTriageFraud(p *Principal) bool {
+	return p != nil && (p.HasRole("fraud_analyst") || p.HasScope("fraud:triage"))
+}
+
+// fraudTriageMiddleware guards the /api/fraud routes, alert triage and the
+// review queue, which fraud analysts and admins may use. With a policy engine OPA decides, as for
+// other fraud actions.
+func (app *App) fraudTriageMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if app.authorize(c) {
+			c.Next()
+			return
+		}
+		switch p := principalFrom(c); {
+		case app.policy != nil:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Denied by policy"})
+		case p != nil:
+			c.JSON(http.StatusForbidden, gin.H{"error": `Role "fraud_analyst" or scope "fraud:triage" required`})
+		default:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Admin token required"})
+		}
+		c.Abort()
+	}
+}
+
+// listFraudAlertsHandler returns the session's fraud alerts, newest first,
+// optionally by ?severity, ?rule, ?status and when they were flagged
+// (?since and ?until).
+func (app *App) listFraudAlertsHandler(c *gin.Context) {
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	limit, err := pageParam(c, "limit", defaultPageLimit, maxPageLimit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	offset, err := pageParam(c, "offset", 0, maxPageOffset)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	fields, err := apihttp.FieldsParam(c, FraudAlert{})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	qb := newFraudAlertQuery(sessionID(c))
+	if severity := c.Query("severity"); severity != "" {
+		if !containsString(fraudAlertSeverities, severity) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "severity must be review or block"})
+			return
+		}
+		qb.Where("severity", store.OpEq, severity)
+	}
+	if status := c.Query("status"); status != "" {
+		if !containsString(fraudAlertStatuses, status) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "status must be open, acknowledged or resolved"})
+			return
+		}
+		qb.Where("status", store.OpEq, status)
+	}
+	if rule := c.Query("rule"); rule != "" {
+		hit, _ := json.Marshal([]map[string]string{{"rule": rule}})
+		qb.Where("hits", store.OpContains, string(hit))
+	}
+	since, hasSince, err := parseTimeParam(c, "since", false)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	until, hasUntil, err := parseTimeParam(c, "until", true)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if hasSince {
+		qb.Where("flagged_at", store.OpGte, since)
+	}
+	if hasUntil {
+		qb.Where("flagged_at", store.OpLt, until)
+	}
+
+	alerts, err := app.queryFraudAlerts(c.Request.Context(), qb, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": fields.Apply(alerts), "limit": limit, "offset": offset})
+}
+
+// getFraudAlertHandler returns one of the session's fraud alerts by ID.
+func (app *App) getFraudAlertHandler(c *gin.Context) {
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Fraud alert not found"})
+		return
+	}
+	alerts, err := app.queryFraudAlerts(c.Request.Context(), newFraudAlertQuery(sessionID(c)).Where("id", store.OpEq, id), 1, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if len(alerts) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Fraud alert not found"})
+		return
+	}
+	c.JSON(http.StatusOK, alerts[0])
+}
+
+// updateFraudAlertHandler acknowledges or resolves a fraud alert, or opens it
+// again. The change is recorded in the flagged transaction's audit trail
+// with the caller as actor.
+func (app *App) updateFraudAlertHandler(c *gin.Context) {
+	var req struct {
+		Status string `json:"status" binding:"required"`
+		Note   string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !containsString(fraudAlertStatuses, req.Status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be open, acknowledged or resolved"})
+		return
+	}
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Fraud alert not found"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	session := sessionID(c)
+	actor := adminActor(c)
+	tx, err := app.db.BeginTx(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Locking the alert keeps concurrent triage of it in order and away from
+	// the summarizer, which skips locked alerts.
+	var txnID, from string
+	err = tx.QueryRowContext(ctx, `
+		SELECT a.transaction_id, COALESCE(tr.status, 'open')
+		FROM transaction_audit a
+		JOIN transactions t ON t.id = a.transaction_id
+		LEFT JOIN fraud_alert_triage tr ON tr.alert_id = a.id
+		WHERE a.id = $1 AND a.action = 'fraud_flagged' AND t.session_id IS NOT DISTINCT FROM $2
+		FOR UPDATE OF a
+	`, id, sessionArg(session)).Scan(&txnID, &from)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Fraud alert not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if req.Status == "open" {
+		_, err = tx.ExecContext(ctx, `DELETE FROM fraud_alert_triage WHERE alert_id = $1`, id)
+	} else {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO fraud_alert_triage (alert_id, status, actor, note) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (alert_id) DO UPDATE SET status = EXCLUDED.status, actor = EXCLUDED.actor,
+				note = EXCLUDED.note, updated_at = CURRENT_TIMESTAMP
+		`, id, req.Status, actor, req.Note)
+	}
+	if err != nil {
+		app.logCtx(ctx, "error", "Failed to triage fraud alert", map[string]interface{}{"alert_id": id, "error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	details := map[string]interface{}{"alert_id": id, "from": from, "to": req.Status, "note": req.Note}
+	if err := recordAudit(ctx, tx, txnID, "fraud_alert_triaged", actor, details); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	app.eventCtx(ctx, "info", EventFraudAlertTriaged, txnID, "Fraud alert triaged", map[string]interface{}{
+		"alert_id": id,
+		"from":     from,
+		"to":       req.Status,
+		"actor":    actor,
+	})
+	// The dashboard's cached fraud alerts carry the old status.
+	app.invalidateReadCache(ctx)
+	app.getFraudAlertHandler(c)
+}
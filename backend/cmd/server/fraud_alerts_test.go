@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/infrasage/payflow/internal/dbtest"
+)
+
+// alertQueries answers every query with no rows and keeps the statements
+// it was sent.
+var alertQueries *dbtest.DB
+
+func newFraudAlertTestRouter(t *testing.T) http.Handler {
+	app := newTestApp(t, nil)
+	alertQueries = dbtest.New(func(dbtest.Query) (*dbtest.Rows, error) { return dbtest.NewRows("id"), nil })
+	app.db = alertQueries.Open(t)
+	return app.newRouter()
+}
+
+func TestListFraudAlertsFilters(t *testing.T) {
+	r := newFraudAlertTestRouter(t)
+	w := serve(r, http.MethodGet, "/api/fraud/alerts?severity=block&status=open&rule=velocity%22%7D&since=2026-01-01&limit=5&offset=10", nil, nil)
+	if w.Code != http.StatusOK || w.Body.String() != `{"data":[],"limit":5,"offset":10}` {
+		t.Fatalf("got %d %s", w.Code, w.Body)
+	}
+	queries := alertQueries.Queries()
+	if len(queries) != 1 {
+		t.Fatalf("%d queries, want 1", len(queries))
+	}
+	query := queries[0].SQL
+	for _, want := range []string{
+		"WHERE a.action = $1 AND t.session_id IS NOT DISTINCT FROM $2 AND a.details->>'decision' = $3 AND COALESCE(tr.status, 'open') = $4 AND a.details->'hits' @> $5 AND a.created_at >= $6",
+		"ORDER BY a.id DESC LIMIT $7 OFFSET $8",
+	} {
+		if !strings.Contains(query, want) {
+			t.Errorf("query %q\nlacks %q", query, want)
+		}
+	}
+	args := queries[0].Args
+	var hits []map[string]string
+	if err := json.Unmarshal([]byte(fmt.Sprint(args[4])), &hits); err != nil || len(hits) != 1 || hits[0]["rule"] != `velocity"}` {
+		t.Errorf("rule argument %v, want the rule name JSON-encoded", args[4])
+	}
+	if args[0] != "fraud_flagged" || args[2] != "block" || args[3] != "open" {
+		t.Errorf("args %v", args)
+	}
+}
+
+func TestFraudAlertRequests(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"unknown severity", "GET", "/api/fraud/alerts?severity=allow", "", http.StatusBadRequest},
+		{"unknown status", "GET", "/api/fraud/alerts?status=closed", "", http.StatusBadRequest},
+		{"bad date", "GET", "/api/fraud/alerts?since=yesterday", "", http.StatusBadRequest},
+		{"unknown field", "GET", "/api/fraud/alerts?fields=secret", "", http.StatusBadRequest},
+		{"non-numeric id", "GET", "/api/fraud/alerts/abc", "", http.StatusNotFound},
+		{"missing alert", "GET", "/api/fraud/alerts/42", "", http.StatusNotFound},
+		{"unknown triage status", "PATCH", "/api/fraud/alerts/42", `{"status": "closed"}`, http.StatusBadRequest},
+		{"triage without status", "PATCH", "/api/fraud/alerts/42", `{"note": "looked at it"}`, http.StatusBadRequest},
+	}
+	r := newFraudAlertTestRouter(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body interface{}
+			if tt.body != "" {
+				body = json.RawMessage(tt.body)
+			}
+			if w := serve(r, tt.method, tt.path, body, nil); w.Code != tt.status {
+				t.Errorf("got %d %s, want %d", w.Code, w.Body, tt.status)
+			}
+		})
+	}
+}
+
+func TestFraudAlertsNeedDatabase(t *testing.T) {
+	r := newTestApp(t, nil).newRouter()
+	for _, path := range []string{"/api/fraud/alerts", "/api/fraud/alerts/1"} {
+		if w := serve(r, http.MethodGet, path, nil, nil); w.Code != http.StatusServiceUnavailable {
+			t.Errorf("GET %s without a database: %d, want 503", path, w.Code)
+		}
+	}
+}
+
+func TestFraudTriageAccess(t *testing.T) {
+	tests := []struct {
+		name       string
+		adminToken string
+		principal  *Principal
+		header     string
+		want       int
+	}{
+		{"anonymous without admin auth", "", nil, "", http.StatusOK},
+		{"anonymous with admin token set", "secret-token", nil, "", http.StatusUnauthorized},
+		{"admin token", "secret-token", nil, "secret-token", http.StatusOK},
+		{"fraud analyst", "secret-token", &Principal{Subject: "a", Roles: []string{"fraud_analyst"}, Source: "oidc"}, "", http.StatusOK},
+		{"triage scope", "secret-token", &Principal{Subject: "svc", Scopes: []string{"fraud:triage"}, Source: "oauth"}, "", http.StatusOK},
+		{"admin role", "secret-token", &Principal{Subject: "b", Roles: []string{"admin"}, Source: "oidc"}, "", http.StatusOK},
+		{"operator", "", &Principal{Subject: "o", Roles: []string{"operator"}, Source: "oidc"}, "", http.StatusForbidden},
+		{"viewer", "secret-token", &Principal{Subject: "v", Roles: []string{"viewer"}, Source: "oauth"}, "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t, func(c *Config) { c.AdminToken = tt.adminToken })
+			r := gin.New()
+			r.Use(func(c *gin.Context) {
+				if tt.principal != nil {
+					c.Set(principalContextKey, tt.principal)
+				}
+			})
+			r.PATCH("/api/fraud/alerts/:id", app.fraudTriageMiddleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+			if w := serve(r, http.MethodPatch, "/api/fraud/alerts/1", nil, map[string]string{"X-Admin-Token": tt.header}); w.Code != tt.want {
+				t.Errorf("got %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// fraudBackfillBatch is how many past transactions a backfill scores
+	// and records per database transaction.
+	fraudBackfillBatch = 100
+	// fraudBackfillStale is how long a running backfill may go without
+	// recording progress before it counts as interrupted: its instance
+	// stopped, and another backfill may start.
+	fraudBackfillStale = 5 * time.Minute
+)
+
+// FraudBackfill is a run of a rule set over the transactions created from
+// From up to To. Status is running, completed, failed or interrupted.
+// Flagged counts the transactions it raised an alert for, and NewlyFlagged
+// those of them live analysis had allowed or never scored. Rules counts how
+// often each rule fired in those alerts.
+type FraudBackfill struct {
+	ID             string         `json:"id"`
+	From           time.Time      `json:"from"`
+	To             time.Time      `json:"to"`
+	RuleSetVersion string         `json:"rule_set_version"`
+	Status         string         `json:"status"`
+	Scanned        int            `json:"scanned"`
+	Flagged        int            `json:"flagged"`
+	NewlyFlagged   int            `json:"newly_flagged"`
+	Rules          map[string]int `json:"rules"`
+	Error          string         `json:"error,omitempty"`
+	CreatedBy      string         `json:"created_by"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+	FinishedAt     *time.Time     `json:"finished_at,omitempty"`
+}
+
+// backfillAlert is the details of a fraud_backfilled audit entry.
+type backfillAlert struct {
+	*FraudAssessment
+	BackfillID string `json:"backfill_id"`
+}
+
+// startFraudBackfillHandler scores the transactions created from ?from up
+// to ?to with the active rules, or with ?rules=source those
+// FRAUD_RULES_SOURCE holds now, in the background. Only one backfill runs
+// at a time.
+func (app *App) startFraudBackfillHandler(c *gin.Context) {
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database not available"})
+		return
+	}
+	from, hasFrom, err := parseTimeParam(c, "from", false)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	to, hasTo, err := parseTimeParam(c, "to", true)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !hasFrom || !hasTo {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from and to are required"})
+		return
+	}
+	if !from.Before(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return
+	}
+	ctx := c.Request.Context()
+	set := app.fraud.Rules()
+	switch c.Query("rules") {
+	case "", "active":
+	case "source":
+		if set, err = app.fraud.load(ctx); err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "rules must be active or source"})
+		return
+	}
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	run := FraudBackfill{
+		ID:             uuid.New().String(),
+		From:           from,
+		To:             to,
+		RuleSetVersion: set.Version,
+		Status:         "running",
+		Rules:          map[string]int{},
+		CreatedBy:      adminActor(c),
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	res, err := app.db.ExecContext(ctx, `
+		INSERT INTO fraud_backfills (id, from_time, to_time, rule_set_version, created_by, created_at, updated_at)
+		SELECT $1, $2, $3, $4, $5, $6, $6
+		WHERE NOT EXISTS (SELECT 1 FROM fraud_backfills WHERE status = 'running' AND updated_at > $7)
+	`, run.ID, run.From, run.To, run.RuleSetVersion, run.CreatedBy, run.CreatedAt, now.Add(-fraudBackfillStale))
+	if err != nil {
+		app.logCtx(ctx, "error", "Failed to start fraud backfill", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "A fraud backfill is already running"})
+		return
+	}
+
+	app.eventCtx(ctx, "info", EventFraudBackfillStarted, run.ID, "Fraud backfill started", map[string]interface{}{
+		"from":             run.From,
+		"to":               run.To,
+		"rule_set_version": run.RuleSetVersion,
+		"actor":            run.CreatedBy,
+	})
+	go app.runFraudBackfill(context.Background(), run, set)
+	c.JSON(http.StatusAccepted, run)
+}
+
+// runFraudBackfill scores run's transactions oldest first, in batches paced
+// to FRAUD_BACKFILL_RATE, and records an alert for each one the rules don't
+// allow. Nothing about the transactions or their live alerts changes.
+func (app *App) runFraudBackfill(ctx context.Context, run FraudBackfill, set *RuleSet) {
+	afterTime, afterID := run.From, ""
+	for {
+		started := time.Now()
+		txns, err := app.fraudBackfillTransactions(ctx, run, afterTime, afterID)
+		if err == nil {
+			err = app.recordFraudBackfillBatch(ctx, &run, set, txns)
+		}
+		if err != nil {
+			app.finishFraudBackfill(ctx, &run, "failed", err)
+			return
+		}
+		if len(txns) < fraudBackfillBatch {
+			app.finishFraudBackfill(ctx, &run, "completed", nil)
+			return
+		}
+		last := txns[len(txns)-1]
+		afterTime, afterID = last.CreatedAt, last.ID
+		pace := time.Duration(len(txns)) * time.Second / time.Duration(app.liveConfig().FraudBackfillRate)
+		time.Sleep(pace - time.Since(started))
+	}
+}
+
+// fraudBackfillTransactions returns the next batch of run's transactions
+// after the given one. Internal transfers and refunds aren't scored, as
+// they aren't live either.
+func (app *App) fraudBackfillTransactions(ctx context.Context, run FraudBackfill, afterTime time.Time, afterID string) ([]Transaction, error) {
+	rows, err := app.jobPool().QueryContext(ctx, `
+		SELECT id, from_account, to_account, amount, description, status, created_at,
+			COALESCE(session_id, ''), COALESCE(region, ''), COALESCE(fraud_status, '')
+		FROM transactions
+		WHERE created_at >= $1 AND created_at < $2 AND (created_at, id) > ($3, $4)
+			AND NOT internal AND refund_of IS NULL
+		ORDER BY created_at, id
+		LIMIT $5
+	`, run.From, run.To, afterTime, afterID, fraudBackfillBatch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var txns []Transaction
+	for rows.Next() {
+		var t Transaction
+		if err := rows.Scan(&t.ID, &t.FromAccount, &t.ToAccount, &t.Amount, &t.Description, &t.Status, &t.CreatedAt, &t.SessionID, &t.Region, &t.FraudStatus); err != nil {
+			return nil, err
+		}
+		txns = append(txns, t)
+	}
+	return txns, rows.Err()
+}
+
+// recordFraudBackfillBatch scores txns and stores their alerts together with
+// run's progress.
+func (app *App) recordFraudBackfillBatch(ctx context.Context, run *FraudBackfill, set *RuleSet, txns []Transaction) error {
+	tx, err := app.jobPool().BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, txn := range txns {
+		a := app.fraud.assess(ctx, set, txn)
+		run.Scanned++
+		if a.Decision == "allow" {
+			continue
+		}
+		run.Flagged++
+		if txn.FraudStatus == "" || txn.FraudStatus == "pending" || txn.FraudStatus == "allow" {
+			run.NewlyFlagged++
+		}
+		for _, h := range a.Hits {
+			run.Rules[h.Rule]++
+		}
+		if err := recordAudit(ctx, tx, txn.ID, "fraud_backfilled", "fraud-backfill", backfillAlert{a, run.ID}); err != nil {
+			return err
+		}
+	}
+	rules, _ := json.Marshal(run.Rules)
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE fraud_backfills SET scanned = $2, flagged = $3, newly_flagged = $4, rules = $5, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, run.ID, run.Scanned, run.Flagged, run.NewlyFlagged, rules); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (app *App) finishFraudBackfill(ctx context.Context, run *FraudBackfill, status string, runErr error) {
+	run.Status = status
+	level, fields := "info", map[string]interface{}{
+		"status":        status,
+		"scanned":       run.Scanned,
+		"flagged":       run.Flagged,
+		"newly_flagged": run.NewlyFlagged,
+	}
+	if runErr != nil {
+		run.Error = runErr.Error()
+		level, fields["error"] = "error", run.Error
+	}
+	if _, err := app.jobPool().ExecContext(ctx, `
+		UPDATE fraud_backfills SET status = $2, error = $3, updated_at = CURRENT_TIMESTAMP, finished_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, run.ID, run.Status, run.Error); err != nil {
+		app.log("error", "Failed to record the end of a fraud backfill", map[string]interface{}{"backfill_id": run.ID, "error": err.Error()})
+	}
+	app.eventCtx(ctx, level, EventFraudBackfillFinished, run.ID, "Fraud backfill finished", fields)
+}
+
+const fraudBackfillColumns = `id, from_time, to_time, rule_set_version, status, scanned, flagged, newly_flagged, rules, error, created_by, created_at, updated_at, finished_at`
+
+func scanFraudBackfill(row interface{ Scan(...interface{}) error }) (FraudBackfill, error) {
+	var b FraudBackfill
+	var rules []byte
+	var finished sql.NullTime
+	err := row.Scan(&b.ID, &b.From, &b.To, &b.RuleSetVersion, &b.Status, &b.Scanned, &b.Flagged, &b.NewlyFlagged, &rules,
+		&b.Error, &b.CreatedBy, &b.CreatedAt, &b.UpdatedAt, &finished)
+	if err != nil {
+		return b, err
+	}
+	json.Unmarshal(rules, &b.Rules)
+	if finished.Valid {
+		b.FinishedAt = &finished.Time
+	}
+	if b.Status == "running" && time.Since(b.UpdatedAt) > fraudBackfillStale {
+		b.Status = "interrupted"
+	}
+	return b, nil
+}
+
+func (app *App) listFraudBackfillsHandler(c *gin.Context) {
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database not available"})
+		return
+	}
+	limit, err := pageParam(c, "limit", defaultPageLimit, maxPageLimit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rows, err := app.readPool().QueryContext(c.Request.Context(), `
+		SELECT `+fraudBackfillColumns+` FROM fraud_backfills ORDER BY created_at DESC LIMIT $1
+	`, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+	backfills := []FraudBackfill{}
+	for rows.Next() {
+		b, err := scanFraudBackfill(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		backfills = append(backfills, b)
+	}
+	c.JSON(http.StatusOK, gin.H{"data": backfills})
+}
+
+func (app *App) getFraudBackfillHandler(c *gin.Context) {
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database not available"})
+		return
+	}
+	b, err := scanFraudBackfill(app.readPool().QueryRowContext(c.Request.Context(), `
+		SELECT `+fraudBackfillColumns+` FROM fraud_backfills WHERE id = $1
+	`, c.Param("id")))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Backfill not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, b)
+}
+
+// deleteFraudBackfillHandler removes a finished backfill together with its
+// alerts.
+func (app *App) deleteFraudBackfillHandler(c *gin.Context) {
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database not available"})
+		return
+	}
+	ctx := c.Request.Context()
+	tx, err := app.db.BeginTx(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, `
+		DELETE FROM fraud_backfills WHERE id = $1 AND (status <> 'running' OR updated_at <= $2)
+	`, c.Param("id"), time.Now().UTC().Add(-fraudBackfillStale))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No finished backfill with that ID"})
+		return
+	}
+	res, err = tx.ExecContext(ctx, `
+		DELETE FROM transaction_audit WHERE action = 'fraud_backfilled' AND details->>'backfill_id' = $1
+	`, c.Param("id"))
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	alerts, _ := res.RowsAffected()
+	app.eventCtx(ctx, "info", EventFraudBackfillDeleted, c.Param("id"), "Fraud backfill deleted", map[string]interface{}{
+		"alerts": alerts,
+		"actor":  adminActor(c),
+	})
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestStartFraudBackfillRange(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"no range", ""},
+		{"no end", "?from=2024-01-01T00:00:00Z"},
+		{"no start", "?to=2024-02-01T00:00:00Z"},
+		{"bad time", "?from=yesterday&to=2024-02-01T00:00:00Z"},
+		{"inverted", "?from=2024-02-01T00:00:00Z&to=2024-01-01T00:00:00Z"},
+		{"empty", "?from=2024-01-01T00:00:00Z&to=2024-01-01T00:00:00Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t, nil)
+			app.db = newFakeLedger().open(t)
+			app.initFraud()
+			w := serve(app.newRouter(), http.MethodPost, "/api/admin/fraud/backfill"+tt.query, nil, nil)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("status %d %s, want 400", w.Code, w.Body)
+			}
+		})
+	}
+}
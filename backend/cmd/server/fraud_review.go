@@ -0,0 +1,434 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/infrasage/payflow/internal/store"
+	"github.com/lib/pq"
+)
+
+// reviewOutcomes maps a review decision to the label it gives the
+// transaction in fraud_review_labels and the audit action recording it.
+var reviewOutcomes = map[string]struct{ label, action string }{
+	"approve": {"legitimate", "review_approved"},
+	"decline": {"fraud", "review_declined"},
+}
+
+// ReviewItem is a transaction waiting in the review queue, with the
+// assessment that held it. Assessment is null until the fraud pool has
+// recorded it.
+type ReviewItem struct {
+	Transaction Transaction     `json:"transaction"`
+	Assessment  json.RawMessage `json:"assessment"`
+}
+
+// FraudLabel is an analyst's decision on a held transaction, with what the
+// rules saw, for tuning and training against.
+type FraudLabel struct {
+	TransactionID string          `json:"transaction_id"`
+	Label         string          `json:"label"`
+	Amount        float64         `json:"amount"`
+	FromAccount   string          `json:"from_account"`
+	ToAccount     string          `json:"to_account"`
+	CreatedAt     time.Time       `json:"created_at"`
+	Assessment    json.RawMessage `json:"assessment"`
+	Actor         string          `json:"actor"`
+	Note          string          `json:"note"`
+	LabeledAt     time.Time       `json:"labeled_at"`
+}
+
+// holdForReview scores txn before it is posted when FRAUD_REVIEW_HOLD is on,
+// and holds it, unposted, when the score lands in the review band. The
+// assessment is returned for the fraud pool to record, so txn isn't scored
+// twice; nil leaves the scoring to the pool. Accounts are resolved the way
+// they will be stored, since the rules look them up in stored payments.
+func (app *App) holdForReview(ctx context.Context, txn *Transaction) *FraudAssessment {
+	if !app.liveConfig().FraudReviewHold || app.fraud == nil {
+		return nil
+	}
+	scored := *txn
+	var err error
+	if scored.FromAccount, err = app.vault.Resolve(ctx, txn.FromAccount); err == nil {
+		scored.ToAccount, err = app.vault.Resolve(ctx, txn.ToAccount)
+	}
+	if err != nil {
+		app.logCtx(ctx, "warn", "Token lookup failed, fraud analysis left to the pool", map[string]interface{}{"transaction_id": txn.ID, "error": err.Error()})
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, fraudAnalysisTimeout)
+	defer cancel()
+	a := app.fraud.AnalyzeTransaction(ctx, scored)
+	if a.Decision == "review" {
+		txn.Status = "held"
+	}
+	return a
+}
+
+// listReviewQueueHandler returns the session's transactions held for fraud
+// review, newest first.
+func (app *App) listReviewQueueHandler(c *gin.Context) {
+	limit, err := pageParam(c, "limit", defaultPageLimit, maxPageLimit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	offset, err := pageParam(c, "offset", 0, maxPageOffset)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	items := []ReviewItem{}
+	if app.transactions == nil {
+		c.JSON(http.StatusOK, gin.H{"data": items, "total": 0, "limit": limit, "offset": offset})
+		return
+	}
+	ctx := c.Request.Context()
+	page, err := app.listTransactions(ctx, store.TransactionFilter{
+		SessionID: sessionID(c),
+		Statuses:  []string{"held"},
+		Limit:     limit,
+		Offset:    offset,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	assessments, err := app.heldAssessments(ctx, page.Data)
+	if err != nil {
+		app.logCtx(ctx, "error", "Failed to read held assessments", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	for _, txn := range page.Data {
+		items = append(items, ReviewItem{Transaction: txn, Assessment: assessments[txn.ID]})
+	}
+	c.JSON(http.StatusOK, gin.H{"data": items, "total": page.Total, "limit": limit, "offset": offset})
+}
+
+// heldAssessments returns the latest fraud_flagged assessment of each of
+// txns that has one.
+func (app *App) heldAssessments(ctx context.Context, txns []Transaction) (map[string]json.RawMessage, error) {
+	found := map[string]json.RawMessage{}
+	if app.db == nil || len(txns) == 0 {
+		return found, nil
+	}
+	ids := make([]string, len(txns))
+	for i, t := range txns {
+		ids[i] = t.ID
+	}
+	rows, err := app.readPool().QueryContext(ctx, `
+		SELECT DISTINCT ON (transaction_id) transaction_id, details
+		FROM transaction_audit
+		WHERE transaction_id = ANY($1) AND action = 'fraud_flagged'
+		ORDER BY transaction_id, id DESC
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var details []byte
+		if err := rows.Scan(&id, &details); err != nil {
+			return nil, err
+		}
+		found[id] = details
+	}
+	return found, rows.Err()
+}
+
+func (app *App) approveReviewHandler(c *gin.Context) { app.decideReview(c, "approve") }
+
+func (app *App) declineReviewHandler(c *gin.Context) { app.decideReview(c, "decline") }
+
+// decideReview finalizes a held transaction. Approving posts it, or fails it
+// if the payer can no longer cover it; declining leaves the balances alone.
+// Either way the decision is audited with the caller as actor and stored as
+// a label in fraud_review_labels.
+func (app *App) decideReview(c *gin.Context, decision string) {
+	var req struct {
+		Note string `json:"note"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	session := sessionID(c)
+	actor := adminActor(c)
+	tx, err := app.db.BeginTx(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Approving posts balances, so take the lock writeTransaction posts
+	// under, before the row lock as refunds do.
+	if session == "" {
+		_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, ledgerLockID)
+	} else {
+		_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1, hashtext($2))`, sessionLockClass, session)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	var txn Transaction
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, from_account, to_account, amount, description, status, created_at,
+			COALESCE(prev_hash, ''), COALESCE(hash, ''), COALESCE(status_token, ''), COALESCE(session_id, ''),
+			COALESCE(region, ''), COALESCE(fraud_status, '')
+		FROM transactions WHERE id = $1 AND session_id IS NOT DISTINCT FROM $2
+		FOR UPDATE
+	`, c.Param("id"), sessionArg(session)).Scan(&txn.ID, &txn.FromAccount, &txn.ToAccount, &txn.Amount, &txn.Description, &txn.Status, &txn.CreatedAt,
+		&txn.PrevHash, &txn.Hash, &txn.StatusToken, &txn.SessionID, &txn.Region, &txn.FraudStatus)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if txn.Status != "held" {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Transaction is %s, not held for review", txn.Status)})
+		return
+	}
+
+	txn.Status = "declined"
+	if decision == "approve" {
+		txn.Status = "success"
+		if err := postBalances(ctx, tx, &txn); err != nil {
+			app.logCtx(ctx, "error", "Failed to post approved transaction", map[string]interface{}{"transaction_id": txn.ID, "error": err.Error()})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE transactions SET status = $2, internal = $3 WHERE id = $1`, txn.ID, txn.Status, txn.Internal); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := app.resealStatus(ctx, tx, txn.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := app.queueReceipt(ctx, tx, &txn); err != nil {
+		app.logCtx(ctx, "error", "Failed to queue receipt for approved transaction", map[string]interface{}{"transaction_id": txn.ID, "error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	outcome := reviewOutcomes[decision]
+	label := outcome.label
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO fraud_review_labels (transaction_id, label, assessment, actor, note)
+		SELECT $1, $2, (
+			SELECT details FROM transaction_audit
+			WHERE transaction_id = $1 AND action = 'fraud_flagged'
+			ORDER BY id DESC LIMIT 1
+		), $3, $4
+	`, txn.ID, label, actor, req.Note); err != nil {
+		app.logCtx(ctx, "error", "Failed to store fraud review label", map[string]interface{}{"transaction_id": txn.ID, "error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	details := map[string]interface{}{"decision": decision, "label": label, "status": txn.Status, "note": req.Note}
+	if err := recordAudit(ctx, tx, txn.ID, outcome.action, actor, details); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	app.invalidateReadCache(ctx)
+	app.wakeReceipts()
+	app.feed.PublishStatus(session, txn.ID, txn.Status)
+
+	transactionsTotal.WithLabelValues(txn.Status).Inc()
+	app.eventCtx(ctx, "info", EventFraudReviewed, txn.ID, "Held transaction reviewed", map[string]interface{}{
+		"decision": decision,
+		"label":    label,
+		"status":   txn.Status,
+		"actor":    actor,
+	})
+	if txn.Status == "success" {
+		app.webhooks.Dispatch(ctx, WebhookTransactionCreated, txn.SessionID, webhookPayload(txn))
+		app.publisher.PublishTransaction(ctx, txn)
+	}
+	c.JSON(http.StatusOK, gin.H{"transaction": txn, "decision": decision, "label": label})
+}
+
+// listFraudLabelsHandler returns the session's review labels, newest first,
+// optionally by ?label, as training data for the fraud rules.
+func (app *App) listFraudLabelsHandler(c *gin.Context) {
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	limit, err := pageParam(c, "limit", defaultPageLimit, maxPageLimit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	offset, err := pageParam(c, "offset", 0, maxPageOffset)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	label := c.Query("label")
+	if label != "" && label != "legitimate" && label != "fraud" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "label must be legitimate or fraud"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	rows, err := app.readPool().QueryContext(ctx, `
+		SELECT l.transaction_id, l.label, t.amount, t.from_account, t.to_account, t.created_at,
+			l.assessment, l.actor, l.note, l.labeled_at
+		FROM fraud_review_labels l
+		JOIN transactions t ON t.id = l.transaction_id
+		WHERE t.session_id IS NOT DISTINCT FROM $1 AND ($2 = '' OR l.label = $2)
+		ORDER BY l.labeled_at DESC, l.transaction_id
+		LIMIT $3 OFFSET $4
+	`, sessionArg(sessionID(c)), label, limit, offset)
+	if err != nil {
+		app.logCtx(ctx, "error", "Failed to list fraud labels", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+	labels := []FraudLabel{}
+	for rows.Next() {
+		var l FraudLabel
+		var assessment []byte
+		if err := rows.Scan(&l.TransactionID, &l.Label, &l.Amount, &l.FromAccount, &l.ToAccount, &l.CreatedAt, &assessment, &l.Actor, &l.Note, &l.LabeledAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if assessment != nil {
+			l.Assessment = assessment
+		}
+		labels = append(labels, l)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": labels, "limit": limit, "offset": offset})
+}
+
+// holdExpiryBatch is how many held payments one database transaction voids.
+const holdExpiryBatch = 100
+
+// startHoldExpiry voids, every minute, payments held for review for longer
+// than FRAUD_HOLD_EXPIRY_SEC, so the review queue can't grow without bound.
+func (app *App) startHoldExpiry() {
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			if app.db == nil {
+				continue
+			}
+			if _, err := app.expireHeldPayments(context.Background(), time.Now().UTC()); err != nil {
+				app.log("warn", "Failed to expire held payments", map[string]interface{}{"error": err.Error()})
+			}
+		}
+	}()
+}
+
+// expireHeldPayments voids the payments held since before now less
+// FRAUD_HOLD_EXPIRY_SEC and returns how many it voided. Held payments were
+// never posted, so there are no balances to give back. Each is audited as
+// hold_expired and logged as a transaction.expired event. Rows another
+// instance, or an analyst's decision, has locked are left for the next run.
+func (app *App) expireHeldPayments(ctx context.Context, now time.Time) (int, error) {
+	age := app.liveConfig().FraudHoldExpirySec
+	if age <= 0 {
+		return 0, nil
+	}
+	cutoff := now.Add(-time.Duration(age) * time.Second)
+	total := 0
+	for {
+		expired, err := app.expireHeldBatch(ctx, cutoff)
+		total += len(expired)
+		if err != nil {
+			return total, err
+		}
+		if len(expired) > 0 {
+			app.invalidateReadCache(ctx)
+		}
+		for _, txn := range expired {
+			app.feed.PublishStatus(txn.SessionID, txn.ID, "voided")
+			transactionsTotal.WithLabelValues("voided").Inc()
+			app.eventCtx(ctx, "info", EventTransactionExpired, txn.ID, "Held transaction expired unreviewed", map[string]interface{}{
+				"held_since":  txn.CreatedAt,
+				"max_age_sec": age,
+				"session_id":  txn.SessionID,
+			})
+		}
+		if len(expired) < holdExpiryBatch {
+			return total, nil
+		}
+	}
+}
+
+func (app *App) expireHeldBatch(ctx context.Context, cutoff time.Time) ([]Transaction, error) {
+	tx, err := app.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, COALESCE(session_id, ''), created_at FROM transactions
+		WHERE status = 'held' AND created_at < $1
+		ORDER BY created_at
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	`, cutoff, holdExpiryBatch)
+	if err != nil {
+		return nil, err
+	}
+	var expired []Transaction
+	var ids []string
+	for rows.Next() {
+		var t Transaction
+		if err := rows.Scan(&t.ID, &t.SessionID, &t.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		expired = append(expired, t)
+		ids = append(ids, t.ID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE transactions SET status = 'voided' WHERE id = ANY($1)`, pq.Array(ids)); err != nil {
+		return nil, err
+	}
+	if err := app.resealStatus(ctx, tx, ids...); err != nil {
+		return nil, err
+	}
+	details := map[string]interface{}{"reason": "review_timeout", "cutoff": cutoff}
+	for _, id := range ids {
+		if err := recordAudit(ctx, tx, id, "hold_expired", "hold-expiry", details); err != nil {
+			return nil, err
+		}
+	}
+	return expired, tx.Commit()
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/infrasage/payflow/internal/store"
+)
+
+// The built-in rules score a 20000 payment 50: large_amount and round_amount.
+func TestHoldForReview(t *testing.T) {
+	tests := []struct {
+		name       string
+		hold       bool
+		amount     float64
+		blockScore float64
+		want       string
+		scored     bool
+	}{
+		{"hold off", false, 20000, 80, "success", false},
+		{"review band", true, 20000, 80, "held", true},
+		{"below the band", true, 50, 80, "success", true},
+		{"block is not held", true, 20000, 50, "success", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t, func(c *Config) {
+				c.FraudReviewHold = tt.hold
+				c.FraudReviewScore = 40
+				c.FraudBlockScore = tt.blockScore
+			})
+			app.initFraud()
+			txn := Transaction{ID: "txn-1", FromAccount: "ACC-1", ToAccount: "MER-1", Amount: tt.amount, Status: "success", CreatedAt: time.Now().UTC()}
+			a := app.holdForReview(context.Background(), &txn)
+			if txn.Status != tt.want {
+				t.Errorf("status = %q, want %q", txn.Status, tt.want)
+			}
+			if (a != nil) != tt.scored {
+				t.Fatalf("assessment = %+v, want scored %v", a, tt.scored)
+			}
+			if a != nil && a.TransactionID != txn.ID {
+				t.Errorf("assessment is for %q, want %q", a.TransactionID, txn.ID)
+			}
+		})
+	}
+}
+
+// A payment scored before posting isn't scored again: the pool records and
+// alerts on the assessment it was given.
+func TestFraudPoolUsesGivenAssessment(t *testing.T) {
+	var logs bytes.Buffer
+	app := newTestApp(t, nil)
+	app.logs = newLogger("info", "json", &logs)
+	app.webhooks = newWebhookDispatcher(app)
+	app.publisher = noopPublisher{}
+	pool := &FraudPool{app: app}
+	a := &FraudAssessment{TransactionID: "txn-1", Score: 55, Decision: "review", Hits: []RuleHit{{Rule: "large_amount", Score: 55}}}
+
+	// app.fraud is nil, so analyzing the transaction again would panic.
+	pool.analyze(fraudJob{txn: Transaction{ID: "txn-1", Status: "held"}, assessment: a})
+	if !strings.Contains(logs.String(), `"event_type":"`+EventFraudFlagged+`"`) || !strings.Contains(logs.String(), `"score":55`) {
+		t.Errorf("no fraud.flagged event with the given score in %s", logs.String())
+	}
+}
+
+func TestReviewQueueListsHeldTransactions(t *testing.T) {
+	app := newTestApp(t, nil)
+	mem := store.NewMemory()
+	base := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	mem.Add(Transaction{ID: "txn-held", FromAccount: "ACC-1", ToAccount: "MER-1", Amount: 20000, Status: "held", CreatedAt: base})
+	mem.Add(Transaction{ID: "txn-posted", FromAccount: "ACC-1", ToAccount: "MER-1", Amount: 10, Status: "success", CreatedAt: base.Add(time.Minute)})
+	mem.Add(Transaction{ID: "txn-other-session", FromAccount: "ACC-1", ToAccount: "MER-1", Amount: 20000, Status: "held", CreatedAt: base, SessionID: "s1"})
+	app.transactions = mem
+
+	w := serve(app.newRouter(), http.MethodGet, "/api/fraud/review-queue", nil, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Data  []ReviewItem `json:"data"`
+		Total int          `json:"total"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Total != 1 || len(resp.Data) != 1 || resp.Data[0].Transaction.ID != "txn-held" {
+		t.Fatalf("got %s, want only txn-held", w.Body)
+	}
+	if string(resp.Data[0].Assessment) != "null" {
+		t.Errorf("assessment = %s, want null before one is recorded", resp.Data[0].Assessment)
+	}
+}
+
+func TestReviewDecisionRequests(t *testing.T) {
+	app := newTestApp(t, nil)
+	h := app.newRouter()
+	tests := []struct {
+		name string
+		path string
+		body interface{}
+		want int
+	}{
+		{"approve without a body", "/api/fraud/review-queue/txn-1/approve", nil, http.StatusServiceUnavailable},
+		{"decline with a note", "/api/fraud/review-queue/txn-1/decline", map[string]string{"note": "card reported stolen"}, http.StatusServiceUnavailable},
+		{"unknown field", "/api/fraud/review-queue/txn-1/approve", map[string]string{"label": "fraud"}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serve(h, http.MethodPost, tt.path, tt.body, nil); w.Code != tt.want {
+				t.Errorf("got %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+		})
+	}
+}
+
+// Payments held past FRAUD_HOLD_EXPIRY_SEC are voided and audited; fresh
+// holds and settled payments stay as they are.
+func TestExpireHeldPayments(t *testing.T) {
+	now := time.Now().UTC()
+	tests := []struct {
+		name   string
+		maxAge int
+		want   int
+		stale  string
+	}{
+		{"expires stale holds", 3600, 1, "voided"},
+		{"disabled", 0, 0, "held"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newFakeLedger()
+			l.txns["txn-stale"] = Transaction{ID: "txn-stale", Status: "held", CreatedAt: now.Add(-2 * time.Hour)}
+			l.txns["txn-fresh"] = Transaction{ID: "txn-fresh", Status: "held", CreatedAt: now.Add(-time.Minute)}
+			l.txns["txn-old"] = Transaction{ID: "txn-old", Status: "success", CreatedAt: now.Add(-48 * time.Hour)}
+			app := newTestApp(t, func(c *Config) { c.FraudHoldExpirySec = tt.maxAge })
+			app.db = l.open(t)
+			app.feed = newTransactionFeed(1, 1)
+
+			n, err := app.expireHeldPayments(context.Background(), now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != tt.want {
+				t.Errorf("expired %d, want %d", n, tt.want)
+			}
+			for id, want := range map[string]string{"txn-stale": tt.stale, "txn-fresh": "held", "txn-old": "success"} {
+				if got := l.status(id); got != want {
+					t.Errorf("%s status %q, want %q", id, got, want)
+				}
+			}
+			if tt.want > 0 && !containsString(l.audit, "txn-stale hold_expired") {
+				t.Errorf("audit %v, want txn-stale hold_expired", l.audit)
+			}
+		})
+	}
+}
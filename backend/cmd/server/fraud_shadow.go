@@ -0,0 +1,255 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// shadowRecentLimit caps how many per-transaction comparisons a shadow run
+// keeps; the aggregates cover every transaction.
+const shadowRecentLimit = 500
+
+// ShadowComparison is one transaction scored by both rule sets.
+type ShadowComparison struct {
+	TransactionID     string    `json:"transaction_id"`
+	ActiveScore       float64   `json:"active_score"`
+	CandidateScore    float64   `json:"candidate_score"`
+	Delta             float64   `json:"delta"`
+	ActiveDecision    string    `json:"active_decision"`
+	CandidateDecision string    `json:"candidate_decision"`
+	At                time.Time `json:"at"`
+}
+
+// ShadowRun scores transactions with a candidate rule set next to the active
+// one, without acting on the candidate's decisions, until it is promoted,
+// discarded or its period ends.
+type ShadowRun struct {
+	candidate *RuleSet
+	baseline  string
+	started   time.Time
+	until     time.Time
+
+	mu       sync.Mutex
+	compared int
+	flipped  int
+	sumDelta float64
+	sumAbs   float64
+	maxAbs   float64
+	flips    map[string]int
+	recent   []ShadowComparison
+}
+
+// ShadowReport summarizes a shadow run for the comparison endpoint.
+type ShadowReport struct {
+	ActiveVersion    string             `json:"active_version"`
+	BaselineVersion  string             `json:"baseline_version"`
+	CandidateVersion string             `json:"candidate_version"`
+	Candidate        *RuleSet           `json:"candidate"`
+	StartedAt        time.Time          `json:"started_at"`
+	Until            time.Time          `json:"until"`
+	Running          bool               `json:"running"`
+	Compared         int                `json:"compared"`
+	DecisionFlips    int                `json:"decision_flips"`
+	FlipsByDecision  map[string]int     `json:"flips_by_decision"`
+	MeanDelta        float64            `json:"mean_delta"`
+	MeanAbsDelta     float64            `json:"mean_abs_delta"`
+	MaxAbsDelta      float64            `json:"max_abs_delta"`
+	RecentFlips      []ShadowComparison `json:"recent_flips"`
+	Recent           []ShadowComparison `json:"recent"`
+}
+
+func (s *ShadowRun) running(now time.Time) bool {
+	return s != nil && now.Before(s.until)
+}
+
+func (s *ShadowRun) record(active, candidate *FraudAssessment) {
+	cmp := ShadowComparison{
+		TransactionID:     active.TransactionID,
+		ActiveScore:       active.Score,
+		CandidateScore:    candidate.Score,
+		Delta:             candidate.Score - active.Score,
+		ActiveDecision:    active.Decision,
+		CandidateDecision: candidate.Decision,
+		At:                time.Now().UTC(),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.compared++
+	s.sumDelta += cmp.Delta
+	abs := math.Abs(cmp.Delta)
+	s.sumAbs += abs
+	if abs > s.maxAbs {
+		s.maxAbs = abs
+	}
+	if cmp.ActiveDecision != cmp.CandidateDecision {
+		s.flipped++
+		s.flips[cmp.ActiveDecision+"->"+cmp.CandidateDecision]++
+	}
+	if len(s.recent) == shadowRecentLimit {
+		s.recent = s.recent[1:]
+	}
+	s.recent = append(s.recent, cmp)
+}
+
+func (s *ShadowRun) report(active string) *ShadowReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := &ShadowReport{
+		ActiveVersion:    active,
+		BaselineVersion:  s.baseline,
+		CandidateVersion: s.candidate.Version,
+		Candidate:        s.candidate,
+		StartedAt:        s.started,
+		Until:            s.until,
+		Running:          s.running(time.Now()),
+		Compared:         s.compared,
+		DecisionFlips:    s.flipped,
+		FlipsByDecision:  map[string]int{},
+		MaxAbsDelta:      s.maxAbs,
+		RecentFlips:      []ShadowComparison{},
+		Recent:           make([]ShadowComparison, len(s.recent)),
+	}
+	for k, v := range s.flips {
+		r.FlipsByDecision[k] = v
+	}
+	if s.compared > 0 {
+		r.MeanDelta = s.sumDelta / float64(s.compared)
+		r.MeanAbsDelta = s.sumAbs / float64(s.compared)
+	}
+	copy(r.Recent, s.recent)
+	for _, cmp := range s.recent {
+		if cmp.ActiveDecision != cmp.CandidateDecision {
+			r.RecentFlips = append(r.RecentFlips, cmp)
+		}
+	}
+	return r
+}
+
+func (d *FraudDetector) shadowRun() (*ShadowRun, string) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.shadow, d.rules.Version
+}
+
+// startFraudShadowHandler loads the rules from FRAUD_RULES_SOURCE as a
+// candidate and shadows the active set with it for duration_sec (default
+// FRAUD_SHADOW_DURATION_SEC).
+func (app *App) startFraudShadowHandler(c *gin.Context) {
+	var req struct {
+		DurationSec int `json:"duration_sec" binding:"omitempty,min=60,max=2592000"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.DurationSec == 0 {
+		req.DurationSec = app.config.FraudShadowDurationSec
+	}
+
+	candidate, err := app.fraud.load(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	d := app.fraud
+	d.mu.Lock()
+	if d.shadow.running(time.Now()) {
+		d.mu.Unlock()
+		c.JSON(http.StatusConflict, gin.H{"error": "A shadow run is already in progress; promote or discard it first"})
+		return
+	}
+	if candidate.Version == d.rules.Version {
+		d.mu.Unlock()
+		c.JSON(http.StatusConflict, gin.H{"error": "Candidate rules are identical to the active set", "version": candidate.Version})
+		return
+	}
+	now := time.Now().UTC()
+	run := &ShadowRun{
+		candidate: candidate,
+		baseline:  d.rules.Version,
+		started:   now,
+		until:     now.Add(time.Duration(req.DurationSec) * time.Second),
+		flips:     map[string]int{},
+	}
+	d.shadow = run
+	d.mu.Unlock()
+
+	app.eventCtx(c.Request.Context(), "info", EventFraudShadowStarted, candidate.Version, "Fraud rules shadow run started", map[string]interface{}{
+		"active_version": run.baseline,
+		"until":          run.until,
+		"actor":          adminActor(c),
+	})
+	c.JSON(http.StatusCreated, run.report(run.baseline))
+}
+
+func (app *App) getFraudShadowHandler(c *gin.Context) {
+	run, active := app.fraud.shadowRun()
+	if run == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No shadow run"})
+		return
+	}
+	c.JSON(http.StatusOK, run.report(active))
+}
+
+// promoteFraudShadowHandler makes the candidate the active rule set. The
+// run's final report is returned for the record.
+func (app *App) promoteFraudShadowHandler(c *gin.Context) {
+	d := app.fraud
+	run, _ := d.shadowRun()
+	if run == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No shadow run"})
+		return
+	}
+	if !app.passGuardrails(c, "fraud.shadow_promote", run.candidate.Version, ruleSetGuardrails(d.Rules(), run.candidate, app.liveConfig().FraudBlockScore)) {
+		return
+	}
+
+	d.mu.Lock()
+	if d.shadow != run {
+		d.mu.Unlock()
+		c.JSON(http.StatusNotFound, gin.H{"error": "No shadow run"})
+		return
+	}
+	if d.rules.Version != run.baseline {
+		d.mu.Unlock()
+		c.JSON(http.StatusConflict, gin.H{"error": "Active rules changed since the shadow run started; start a new run"})
+		return
+	}
+	previous := d.rules.Version
+	d.rules = run.candidate
+	d.shadow = nil
+	d.mu.Unlock()
+
+	report := run.report(run.candidate.Version)
+	app.eventCtx(c.Request.Context(), "info", EventFraudRulesPromoted, run.candidate.Version, "Shadow fraud rules promoted", map[string]interface{}{
+		"previous_version": previous,
+		"compared":         report.Compared,
+		"decision_flips":   report.DecisionFlips,
+		"actor":            adminActor(c),
+	})
+	c.JSON(http.StatusOK, report)
+}
+
+func (app *App) discardFraudShadowHandler(c *gin.Context) {
+	d := app.fraud
+	d.mu.Lock()
+	run := d.shadow
+	d.shadow = nil
+	d.mu.Unlock()
+	if run == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No shadow run"})
+		return
+	}
+	app.logCtx(c.Request.Context(), "info", "Fraud rules shadow run discarded", map[string]interface{}{
+		"candidate_version": run.candidate.Version,
+		"actor":             adminActor(c),
+	})
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	apihttp "github.com/infrasage/payflow/internal/http"
+	"github.com/infrasage/payflow/internal/store"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// fraudSpikeSettle is how long after a window closes its alerts are counted,
+// so analyses still being recorded make it in.
+const fraudSpikeSettle = 10 * time.Second
+
+// conditionFraudSpike prefixes, with the rule name, the incident condition of
+// a fraud alert spike.
+const conditionFraudSpike = "fraud_spike:"
+
+var fraudAlertSpikesTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "payflow_fraud_alert_spikes_total",
+		Help: "Windows in which a fraud rule's alerts spiked above its baseline, by rule",
+	},
+	[]string{"rule"},
+)
+
+// FraudAlertSpike is a meta-alert: in the window ending at WindowEnd, Rule
+// fired Alerts times, ZScore standard deviations above the mean of the
+// windows before it.
+type FraudAlertSpike struct {
+	Rule       string    `json:"rule"`
+	WindowEnd  time.Time `json:"window_end"`
+	WindowSec  int       `json:"window_sec"`
+	Alerts     int       `json:"alerts"`
+	Baseline   float64   `json:"baseline"`
+	StdDev     float64   `json:"stddev"`
+	ZScore     float64   `json:"z_score"`
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+var fraudSpikeColumns = map[string]string{
+	"rule":       "rule",
+	"window_end": "window_end",
+}
+
+// startFraudSpikeDetector checks, shortly after each FRAUD_SPIKE_WINDOW_SEC
+// window closes, whether any rule's alerts spiked in it. Every instance
+// checks; the first to record a spike announces it.
+func (app *App) startFraudSpikeDetector() {
+	window := time.Duration(app.config.FraudSpikeWindowSec) * time.Second
+	if window <= 0 {
+		return
+	}
+	go func() {
+		for {
+			end := time.Now().UTC().Truncate(window).Add(window)
+			time.Sleep(time.Until(end.Add(fraudSpikeSettle)))
+			if app.db == nil {
+				continue
+			}
+			if _, err := app.detectFraudSpikes(context.Background(), end); err != nil {
+				app.log("warn", "Failed to check for fraud alert spikes", map[string]interface{}{"error": err.Error()})
+			}
+		}
+	}()
+}
+
+// detectFraudSpikes compares each rule's alerts in the window ending at end
+// to FRAUD_SPIKE_BASELINE_WINDOWS windows before it, and records and
+// announces the spikes. It returns the spikes this instance recorded.
+func (app *App) detectFraudSpikes(ctx context.Context, end time.Time) ([]FraudAlertSpike, error) {
+	cfg := app.config
+	window := time.Duration(cfg.FraudSpikeWindowSec) * time.Second
+	start := end.Add(-window * time.Duration(cfg.FraudSpikeBaselineWindows+1))
+	rows, err := app.jobPool().QueryContext(ctx, `
+		SELECT h->>'rule', FLOOR((EXTRACT(EPOCH FROM a.created_at) - $3) / $4)::int, COUNT(*)
+		FROM transaction_audit a
+		CROSS JOIN LATERAL jsonb_array_elements(a.details->'hits') h
+		WHERE a.action = 'fraud_flagged' AND a.created_at >= $1 AND a.created_at < $2
+		GROUP BY 1, 2
+	`, start, end, start.Unix(), cfg.FraudSpikeWindowSec)
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string][]int{}
+	for rows.Next() {
+		var rule string
+		var bucket, n int
+		if err := rows.Scan(&rule, &bucket, &n); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if bucket < 0 || bucket > cfg.FraudSpikeBaselineWindows {
+			continue
+		}
+		if counts[rule] == nil {
+			counts[rule] = make([]int, cfg.FraudSpikeBaselineWindows+1)
+		}
+		counts[rule][bucket] = n
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	spiking := map[string]bool{}
+	var recorded []FraudAlertSpike
+	for _, s := range fraudSpikes(counts, cfg.FraudSpikeZThreshold, cfg.FraudSpikeMinAlerts) {
+		s.WindowEnd, s.WindowSec = end, cfg.FraudSpikeWindowSec
+		spiking[s.Rule] = true
+		res, err := app.jobPool().ExecContext(ctx, `
+			INSERT INTO fraud_alert_spikes (rule, window_end, window_sec, alerts, baseline, stddev, z_score)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (rule, window_end) DO NOTHING
+		`, s.Rule, s.WindowEnd, s.WindowSec, s.Alerts, s.Baseline, s.StdDev, s.ZScore)
+		if err != nil {
+			return recorded, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+		s.DetectedAt = time.Now().UTC()
+		recorded = append(recorded, s)
+		app.announceFraudSpike(ctx, s)
+	}
+	// Spikes this instance opened an incident for resolve once they're over.
+	if app.incidents != nil {
+		for _, inc := range app.incidents.Active() {
+			if rule, ok := strings.CutPrefix(inc.Condition, conditionFraudSpike); ok && !spiking[rule] {
+				app.incidents.set(inc.Condition, false, nil)
+			}
+		}
+	}
+	return recorded, nil
+}
+
+// fraudSpikes finds the rules whose count in the last window of counts is at
+// least minAlerts and threshold standard deviations above the mean of the
+// windows before it. The deviation is taken as at least 1, so a rule that
+// never fired doesn't spike on its first couple of alerts.
+func fraudSpikes(counts map[string][]int, threshold float64, minAlerts int) []FraudAlertSpike {
+	var spikes []FraudAlertSpike
+	for rule, windows := range counts {
+		latest, baseline := windows[len(windows)-1], windows[:len(windows)-1]
+		if latest < minAlerts || len(baseline) == 0 {
+			continue
+		}
+		var sum float64
+		for _, n := range baseline {
+			sum += float64(n)
+		}
+		mean := sum / float64(len(baseline))
+		var variance float64
+		for _, n := range baseline {
+			variance += (float64(n) - mean) * (float64(n) - mean)
+		}
+		std := math.Sqrt(variance / float64(len(baseline)))
+		z := (float64(latest) - mean) / math.Max(std, 1)
+		if z < threshold {
+			continue
+		}
+		spikes = append(spikes, FraudAlertSpike{Rule: rule, Alerts: latest, Baseline: mean, StdDev: std, ZScore: z})
+	}
+	sort.Slice(spikes, func(i, j int) bool { return spikes[i].Rule < spikes[j].Rule })
+	return spikes
+}
+
+// announceFraudSpike logs a spike and notifies on-call: an incident in the
+// INCIDENT_PROVIDER, resolved once a window passes without the spike, and a
+// FRAUD_SPIKE_SLACK_WEBHOOK_URL message.
+func (app *App) announceFraudSpike(ctx context.Context, s FraudAlertSpike) {
+	fraudAlertSpikesTotal.WithLabelValues(s.Rule).Inc()
+	summary := fmt.Sprintf("payflow fraud rule %s raised %d alerts in %ds, %.1f standard deviations above its baseline of %.1f",
+		s.Rule, s.Alerts, s.WindowSec, s.ZScore, s.Baseline)
+	app.eventCtx(ctx, "error", EventFraudAlertSpike, s.Rule, "Fraud alert spike", map[string]interface{}{
+		"alerts":     s.Alerts,
+		"baseline":   s.Baseline,
+		"stddev":     s.StdDev,
+		"z_score":    s.ZScore,
+		"window_end": s.WindowEnd,
+		"window_sec": s.WindowSec,
+	})
+	if app.incidents != nil {
+		app.incidents.set(conditionFraudSpike+s.Rule, true, func() string { return summary })
+	}
+	if url := app.config.FraudSpikeSlackWebhookURL; url != "" {
+		if err := app.postSlack(ctx, url, ":rotating_light: "+summary); err != nil {
+			app.logCtx(ctx, "warn", "Failed to post fraud alert spike to Slack", map[string]interface{}{"rule": s.Rule, "error": err.Error()})
+		}
+	}
+}
+
+// postSlack sends text to a Slack incoming webhook. It isn't retried: Slack
+// doesn't deduplicate messages.
+func (app *App) postSlack(ctx context.Context, url, text string) error {
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.newOutboundClient("slack", outboundOptions{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("slack responded %s", resp.Status)
+	}
+	return nil
+}
+
+// listFraudSpikesHandler returns recorded fraud alert spikes, newest first,
+// optionally for one ?rule and windows ending from ?since and before ?until.
+func (app *App) listFraudSpikesHandler(c *gin.Context) {
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database not available"})
+		return
+	}
+	limit, err := pageParam(c, "limit", defaultPageLimit, maxPageLimit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	fields, err := apihttp.FieldsParam(c, FraudAlertSpike{})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	qb := store.NewQueryBuilder(fraudSpikeColumns).OrderBy("window_end", true)
+	if rule := c.Query("rule"); rule != "" {
+		qb.Where("rule", store.OpEq, rule)
+	}
+	since, hasSince, err := parseTimeParam(c, "since", false)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	until, hasUntil, err := parseTimeParam(c, "until", true)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if hasSince {
+		qb.Where("window_end", store.OpGte, since)
+	}
+	if hasUntil {
+		qb.Where("window_end", store.OpLt, until)
+	}
+	limitArg := qb.Arg(limit)
+	where, args, err := qb.WhereClause()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rows, err := app.readPool().QueryContext(c.Request.Context(), `
+		SELECT rule, window_end, window_sec, alerts, baseline, stddev, z_score, detected_at
+		FROM fraud_alert_spikes`+where+qb.OrderClause()+`, rule
+		LIMIT `+limitArg, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	spikes := []FraudAlertSpike{}
+	for rows.Next() {
+		var s FraudAlertSpike
+		if err := rows.Scan(&s.Rule, &s.WindowEnd, &s.WindowSec, &s.Alerts, &s.Baseline, &s.StdDev, &s.ZScore, &s.DetectedAt); err != nil {
+			continue
+		}
+		spikes = append(spikes, s)
+	}
+	c.JSON(http.StatusOK, gin.H{"data": fields.Apply(spikes)})
+}
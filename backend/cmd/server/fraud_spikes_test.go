@@ -0,0 +1,32 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFraudSpikes(t *testing.T) {
+	tests := []struct {
+		name    string
+		windows []int
+		spike   bool
+		z       float64
+	}{
+		{"steady", []int{4, 6, 5, 5, 4, 6, 5, 5, 6}, false, 0},
+		{"attack wave", []int{4, 6, 5, 5, 4, 6, 5, 5, 40}, true, 35},
+		{"below min alerts", []int{0, 0, 0, 0, 0, 0, 0, 0, 8}, false, 0},
+		{"rule that never fired", []int{0, 0, 0, 0, 0, 0, 0, 0, 12}, true, 12},
+		{"noisy baseline", []int{0, 30, 2, 25, 1, 28, 3, 27, 30}, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spikes := fraudSpikes(map[string][]int{"velocity": tt.windows}, 3, 10)
+			if (len(spikes) == 1) != tt.spike {
+				t.Fatalf("spikes %+v, want spike %v", spikes, tt.spike)
+			}
+			if tt.spike && math.Abs(spikes[0].ZScore-tt.z) > 0.5 {
+				t.Errorf("z-score %.2f, want about %.0f", spikes[0].ZScore, tt.z)
+			}
+		})
+	}
+}
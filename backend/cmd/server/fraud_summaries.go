@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	apihttp "github.com/infrasage/payflow/internal/http"
+	"github.com/infrasage/payflow/internal/store"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// fraudSummaryBatch is how many alerts one summarization transaction moves,
+// so the job never holds locks on the audit table for long.
+const fraudSummaryBatch = 1000
+
+var (
+	fraudAlertRows = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "payflow_fraud_alert_rows",
+		Help: "Fraud alerts stored individually in the audit trail",
+	})
+	fraudAlertsSummarizedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "payflow_fraud_alerts_summarized_total",
+		Help: "Fraud alerts rolled into daily summaries and deleted",
+	})
+)
+
+// FraudAlertSummary stands in for the review-level alerts of one day and
+// demo session once they are past retention. Rules counts how many of them
+// each rule fired for.
+type FraudAlertSummary struct {
+	Day        string           `json:"day"`
+	SessionID  string           `json:"session_id,omitempty"`
+	Decision   string           `json:"decision"`
+	Alerts     int64            `json:"alerts"`
+	TotalScore float64          `json:"total_score"`
+	MaxScore   float64          `json:"max_score"`
+	Rules      map[string]int64 `json:"rules"`
+}
+
+// startFraudSummarizer periodically rolls old review-level fraud alerts
+// into daily summaries. Blocked transactions keep their alerts, which are
+// the ones investigations need.
+func (app *App) startFraudSummarizer() {
+	interval := app.config.FraudAlertSummaryIntervalSec
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		for {
+			if app.db != nil {
+				if _, err := app.summarizeFraudAlerts(context.Background()); err != nil {
+					app.log("warn", "Failed to summarize fraud alerts", map[string]interface{}{"error": err.Error()})
+				}
+			}
+			time.Sleep(time.Duration(interval) * time.Second)
+		}
+	}()
+}
+
+// summarizeFraudAlerts summarizes review-level alerts older than
+// FRAUD_ALERT_RETENTION_DAYS. Past FRAUD_ALERT_SOFT_QUOTA it also takes
+// those from before today, so the table stops growing without dropping alerts
+// outright; today's alerts and block-level ones are always kept. It returns
+// how many alerts were removed from the audit trail.
+func (app *App) summarizeFraudAlerts(ctx context.Context) (int, error) {
+	cfg := app.config
+	now := time.Now().UTC()
+	cutoff := now.AddDate(0, 0, -cfg.FraudAlertRetentionDays)
+	total, err := app.summarizeFraudAlertsBefore(ctx, cutoff)
+	if err != nil {
+		return total, err
+	}
+	rows, err := app.countFraudAlerts(ctx)
+	if err != nil {
+		return total, err
+	}
+	if cfg.FraudAlertSoftQuota > 0 && rows > int64(cfg.FraudAlertSoftQuota) {
+		n, err := app.summarizeFraudAlertsBefore(ctx, now.Truncate(24*time.Hour))
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if rows, err = app.countFraudAlerts(ctx); err != nil {
+			return total, err
+		}
+		fields := map[string]interface{}{"rows": rows, "quota": cfg.FraudAlertSoftQuota, "summarized": n}
+		if rows > int64(cfg.FraudAlertSoftQuota) {
+			app.log("warn", "Fraud alerts over soft quota after summarizing", fields)
+		} else {
+			app.log("info", "Fraud alert soft quota reached, summarized early", fields)
+		}
+	}
+	if total > 0 {
+		app.log("info", "Fraud alerts summarized", map[string]interface{}{"alerts": total, "rows": rows})
+	}
+	return total, nil
+}
+
+func (app *App) countFraudAlerts(ctx context.Context) (int64, error) {
+	var rows int64
+	err := app.jobPool().QueryRowContext(ctx, `SELECT COUNT(*) FROM transaction_audit WHERE action = 'fraud_flagged'`).Scan(&rows)
+	if err == nil {
+		fraudAlertRows.Set(float64(rows))
+	}
+	return rows, err
+}
+
+func (app *App) summarizeFraudAlertsBefore(ctx context.Context, cutoff time.Time) (int, error) {
+	total := 0
+	for {
+		n, err := app.summarizeFraudAlertBatch(ctx, cutoff)
+		total += n
+		if err != nil || n < fraudSummaryBatch {
+			return total, err
+		}
+	}
+}
+
+// fraudSummaryColumns whitelists the fields summaries are filtered by.
+var fraudSummaryColumns = map[string]string{
+	"day":        "day",
+	"session_id": "session_id",
+}
+
+type fraudSummaryKey struct {
+	day      string
+	session  sql.NullString
+	decision string
+}
+
+// summarizeFraudAlertBatch moves up to fraudSummaryBatch alerts into their
+// summaries in one transaction, so an alert is never both counted and kept.
+// Rows locked by another instance doing the same are skipped. Alerts whose
+// transaction is gone, after a demo session was removed, are deleted
+// without being counted.
+func (app *App) summarizeFraudAlertBatch(ctx context.Context, cutoff time.Time) (int, error) {
+	tx, err := app.jobPool().BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT a.id, TO_CHAR(a.created_at, 'YYYY-MM-DD'), t.id IS NOT NULL, t.session_id, a.details
+		FROM transaction_audit a
+		LEFT JOIN transactions t ON t.id = a.transaction_id
+		WHERE a.action = 'fraud_flagged' AND a.details->>'decision' = 'review' AND a.created_at < $1
+		ORDER BY a.id
+		LIMIT $2
+		FOR UPDATE OF a SKIP LOCKED
+	`, cutoff, fraudSummaryBatch)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int64
+	summaries := map[fraudSummaryKey]*FraudAlertSummary{}
+	for rows.Next() {
+		var id int64
+		var key fraudSummaryKey
+		var exists bool
+		var details []byte
+		if err := rows.Scan(&id, &key.day, &exists, &key.session, &details); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+		var a FraudAssessment
+		if !exists || json.Unmarshal(details, &a) != nil {
+			continue
+		}
+		key.decision = a.Decision
+		s, ok := summaries[key]
+		if !ok {
+			s = &FraudAlertSummary{Day: key.day, SessionID: key.session.String, Decision: a.Decision, Rules: map[string]int64{}}
+			summaries[key] = s
+		}
+		s.Alerts++
+		s.TotalScore += a.Score
+		if a.Score > s.MaxScore {
+			s.MaxScore = a.Score
+		}
+		for _, h := range a.Hits {
+			s.Rules[h.Rule]++
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	for key, s := range summaries {
+		rules, _ := json.Marshal(s.Rules)
+		// Rule counts and totals are added to any summary an earlier
+		// batch already wrote for the day.
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO fraud_alert_summaries AS s (day, session_id, decision, alerts, total_score, max_score, rules)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (day, (COALESCE(session_id, '')), decision) DO UPDATE SET
+				alerts = s.alerts + EXCLUDED.alerts,
+				total_score = s.total_score + EXCLUDED.total_score,
+				max_score = GREATEST(s.max_score, EXCLUDED.max_score),
+				rules = COALESCE((
+					SELECT jsonb_object_agg(rule, hits) FROM (
+						SELECT key AS rule, SUM(value::BIGINT) AS hits
+						FROM (SELECT * FROM jsonb_each_text(s.rules) UNION ALL SELECT * FROM jsonb_each_text(EXCLUDED.rules)) r
+						GROUP BY key
+					) merged
+				), '{}')
+		`, key.day, key.session, key.decision, s.Alerts, s.TotalScore, s.MaxScore, rules)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM transaction_audit WHERE id = ANY($1)`, pq.Array(ids)); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	for _, s := range summaries {
+		fraudAlertsSummarizedTotal.Add(float64(s.Alerts))
+	}
+	return len(ids), nil
+}
+
+// listFraudSummariesHandler returns the session's daily alert summaries,
+// newest first, optionally between ?since and ?until.
+func (app *App) listFraudSummariesHandler(c *gin.Context) {
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database not available"})
+		return
+	}
+	limit, err := pageParam(c, "limit", defaultPageLimit, maxPageLimit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	fields, err := apihttp.FieldsParam(c, FraudAlertSummary{})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	qb := store.NewQueryBuilder(fraudSummaryColumns).
+		Where("session_id", store.OpNotDistinct, sessionArg(sessionID(c))).
+		OrderBy("day", true)
+	since, hasSince, err := parseTimeParam(c, "since", false)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	until, hasUntil, err := parseTimeParam(c, "until", true)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if hasSince {
+		qb.Where("day", store.OpGte, since)
+	}
+	if hasUntil {
+		qb.Where("day", store.OpLt, until)
+	}
+	limitArg := qb.Arg(limit)
+	where, args, err := qb.WhereClause()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rows, err := app.readPool().QueryContext(c.Request.Context(), `
+		SELECT TO_CHAR(day, 'YYYY-MM-DD'), COALESCE(session_id, ''), decision, alerts, total_score, max_score, rules
+		FROM fraud_alert_summaries`+where+qb.OrderClause()+`, decision
+		LIMIT `+limitArg, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	summaries := []FraudAlertSummary{}
+	for rows.Next() {
+		var s FraudAlertSummary
+		var rules []byte
+		if err := rows.Scan(&s.Day, &s.SessionID, &s.Decision, &s.Alerts, &s.TotalScore, &s.MaxScore, &rules); err != nil {
+			continue
+		}
+		json.Unmarshal(rules, &s.Rules)
+		summaries = append(summaries, s)
+	}
+	c.JSON(http.StatusOK, gin.H{"data": fields.Apply(summaries)})
+}
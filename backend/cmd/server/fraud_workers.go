@@ -0,0 +1,410 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// fraudAnalysisTimeout bounds one transaction's analysis so a slow rule
+// can't stall a worker indefinitely.
+const fraudAnalysisTimeout = 5 * time.Second
+
+// fraudRecoveryGrace is how long a transaction may be pending analysis
+// before the recovery sweep assumes it was lost. Under load it may only be
+// waiting in the queue; analyzing it twice records it once.
+const fraudRecoveryGrace = time.Minute
+
+var (
+	fraudQueueDepth = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "payflow_fraud_queue_depth",
+			Help: "Transactions waiting for asynchronous fraud analysis",
+		},
+	)
+	fraudAssessmentsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "payflow_fraud_assessments_total",
+			Help: "Completed fraud assessments by decision",
+		},
+		[]string{"decision"},
+	)
+	fraudDroppedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "payflow_fraud_dropped_total",
+			Help: "Transactions not analyzed because the fraud queue was full or shutting down",
+		},
+	)
+	fraudWorkers = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "payflow_fraud_workers",
+			Help: "Goroutines analyzing transactions for fraud, as scaled between FRAUD_WORKERS and FRAUD_WORKERS_MAX",
+		},
+	)
+	fraudResumedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "payflow_fraud_resumed_total",
+			Help: "Transactions requeued for fraud analysis by the recovery sweep",
+		},
+	)
+)
+
+// FraudPool runs fraud analysis off the request path on workers fed by a
+// bounded queue. When the queue is full new work is dropped rather than
+// slowing down payments. With FRAUD_WORKERS_MAX above FRAUD_WORKERS the
+// pool scales between the two, see autoscale.
+type FraudPool struct {
+	app  *App
+	jobs chan fraudJob
+	wg   sync.WaitGroup
+	// quit tells one worker to stop when the pool scales down.
+	quit chan struct{}
+
+	minWorkers, maxWorkers int
+	latencyCap             time.Duration
+
+	mu        sync.RWMutex
+	closed    bool
+	workers   int
+	idleTicks int
+	busy      time.Duration // analysis time since the last autoscale
+	analyzed  int
+}
+
+// fraudJob is a transaction waiting for analysis. assessment is set when the
+// transaction was already scored before it was posted.
+type fraudJob struct {
+	txn        Transaction
+	assessment *FraudAssessment
+}
+
+func (app *App) startFraudWorkers() {
+	cfg := app.config
+	p := newFraudPool(app, cfg.FraudQueueSize, cfg.FraudWorkers, cfg.FraudWorkersMax, time.Duration(cfg.FraudScaleLatencyMs)*time.Millisecond)
+	p.resize(cfg.FraudWorkers)
+	if p.maxWorkers > p.minWorkers {
+		go p.runAutoscaler()
+	}
+	app.fraudPool = p
+}
+
+func newFraudPool(app *App, queueSize, minWorkers, maxWorkers int, latencyCap time.Duration) *FraudPool {
+	if maxWorkers < minWorkers {
+		maxWorkers = minWorkers
+	}
+	return &FraudPool{
+		app:        app,
+		jobs:       make(chan fraudJob, queueSize),
+		quit:       make(chan struct{}, maxWorkers),
+		minWorkers: minWorkers,
+		maxWorkers: maxWorkers,
+		latencyCap: latencyCap,
+	}
+}
+
+// Submit queues txn for analysis and reports whether it was accepted.
+func (p *FraudPool) Submit(txn Transaction) bool {
+	return p.submit(fraudJob{txn: txn})
+}
+
+// SubmitAssessed queues txn with the assessment it was already given, which
+// is recorded and alerted on as if the pool had made it. A nil assessment
+// has txn analyzed as usual.
+func (p *FraudPool) SubmitAssessed(txn Transaction, a *FraudAssessment) bool {
+	return p.submit(fraudJob{txn: txn, assessment: a})
+}
+
+func (p *FraudPool) submit(job fraudJob) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		fraudDroppedTotal.Inc()
+		return false
+	}
+	select {
+	case p.jobs <- job:
+		fraudQueueDepth.Set(float64(len(p.jobs)))
+		return true
+	default:
+		fraudDroppedTotal.Inc()
+		return false
+	}
+}
+
+func (p *FraudPool) work() {
+	defer p.wg.Done()
+	for {
+		select {
+		case <-p.quit:
+			return
+		case job, ok := <-p.jobs:
+			if !ok {
+				return
+			}
+			fraudQueueDepth.Set(float64(len(p.jobs)))
+			start := time.Now()
+			p.analyze(job)
+			p.mu.Lock()
+			p.busy += time.Since(start)
+			p.analyzed++
+			p.mu.Unlock()
+		}
+	}
+}
+
+// fraudScaleInterval is how often the autoscaler looks at the pool, and
+// fraudScaleDownIdle how many looks in a row must find the queue empty
+// before a worker is stopped.
+const (
+	fraudScaleInterval = time.Second
+	fraudScaleDownIdle = 10
+)
+
+func (p *FraudPool) runAutoscaler() {
+	ticker := time.NewTicker(fraudScaleInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if p.Closed() {
+			return
+		}
+		p.autoscale()
+	}
+}
+
+// autoscale adds workers while transactions back up, one for each job
+// queued per worker, or one while any are queued and the average analysis
+// since the last look took longer than FRAUD_SCALE_LATENCY_MS. It stops one
+// worker once the queue has stayed empty for fraudScaleDownIdle looks.
+func (p *FraudPool) autoscale() {
+	queued := len(p.jobs)
+	p.mu.Lock()
+	var latency time.Duration
+	if p.analyzed > 0 {
+		latency = p.busy / time.Duration(p.analyzed)
+	}
+	p.busy, p.analyzed = 0, 0
+	if queued == 0 {
+		p.idleTicks++
+	} else {
+		p.idleTicks = 0
+	}
+	next, reason := fraudPoolTarget(p.workers, p.minWorkers, p.maxWorkers, queued, latency, p.latencyCap, p.idleTicks)
+	if next < p.workers {
+		p.idleTicks = 0
+	}
+	p.mu.Unlock()
+	if next == p.Workers() {
+		return
+	}
+	p.resize(next)
+	p.app.event("info", EventFraudPoolScaled, "", "Fraud worker pool scaled", map[string]interface{}{
+		"workers":    next,
+		"reason":     reason,
+		"queued":     queued,
+		"latency_ms": latency.Milliseconds(),
+	})
+}
+
+// fraudPoolTarget is the worker count the autoscaler moves to, and why.
+func fraudPoolTarget(workers, minWorkers, maxWorkers, queued int, latency, latencyCap time.Duration, idleTicks int) (int, string) {
+	switch {
+	case queued > workers && workers < maxWorkers:
+		next := workers + queued/max(workers, 1)
+		if next > maxWorkers {
+			next = maxWorkers
+		}
+		return next, "queue_depth"
+	case queued > 0 && latency > latencyCap && workers < maxWorkers:
+		return workers + 1, "latency"
+	case idleTicks >= fraudScaleDownIdle && workers > minWorkers:
+		return workers - 1, "idle"
+	}
+	return workers, ""
+}
+
+// resize starts or stops workers until n are running.
+func (p *FraudPool) resize(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	for ; p.workers < n; p.workers++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+	for ; p.workers > n; p.workers-- {
+		p.quit <- struct{}{}
+	}
+	fraudWorkers.Set(float64(p.workers))
+}
+
+// Workers returns how many workers the pool is running.
+func (p *FraudPool) Workers() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.workers
+}
+
+func (p *FraudPool) analyze(job fraudJob) {
+	app := p.app
+	txn, a := job.txn, job.assessment
+	ctx, cancel := context.WithTimeout(context.Background(), fraudAnalysisTimeout)
+	defer cancel()
+	if a == nil {
+		var m *requestCost
+		ctx, m = withLatency(ctx)
+		start := time.Now()
+		a = app.fraud.AnalyzeTransaction(ctx, txn)
+		app.latency.recordBackground("fraud_analysis", m.breakdown(time.Since(start)))
+	}
+	fraudAssessmentsTotal.WithLabelValues(a.Decision).Inc()
+	app.capture.attachAssessment(a)
+	for _, e := range a.Errors {
+		app.log("warn", "Fraud rule failed", map[string]interface{}{"transaction_id": txn.ID, "error": e})
+	}
+	if app.db != nil {
+		recorded, err := app.recordFraudDecision(ctx, txn, a)
+		if err != nil {
+			// The transaction stays pending and the recovery sweep retries.
+			app.log("error", "Failed to record fraud assessment", map[string]interface{}{"transaction_id": txn.ID, "error": err.Error()})
+			return
+		}
+		if !recorded {
+			return
+		}
+	}
+	txn.FraudStatus = a.Decision
+	if a.Decision == "allow" {
+		return
+	}
+
+	app.event("warn", EventFraudFlagged, txn.ID, "Transaction flagged by fraud rules", map[string]interface{}{
+		"score":            a.Score,
+		"decision":         a.Decision,
+		"hits":             a.Hits,
+		"rule_set_version": a.RuleSetVersion,
+	})
+	app.webhooks.Dispatch(ctx, WebhookFraudAlert, txn.SessionID, a)
+	app.publisher.PublishFraudAlert(ctx, txn, *a)
+	if a.Decision == "block" {
+		app.webhooks.Dispatch(ctx, WebhookTransactionBlocked, txn.SessionID, map[string]interface{}{"transaction": webhookPayload(txn), "fraud": a})
+	}
+}
+
+// recordFraudDecision stores the decision on the transaction and, unless it
+// is allow, the fraud_flagged audit entry, in one database transaction. It
+// reports false when the transaction was no longer pending, because another
+// worker or instance got to it first; that one sends the alerts.
+func (app *App) recordFraudDecision(ctx context.Context, txn Transaction, a *FraudAssessment) (bool, error) {
+	tx, err := app.jobPool().BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, `UPDATE transactions SET fraud_status = $2 WHERE id = $1 AND fraud_status = 'pending'`, txn.ID, a.Decision)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	if a.Decision != "allow" {
+		if err := recordAudit(ctx, tx, txn.ID, "fraud_flagged", "fraud-detector", a); err != nil {
+			return false, err
+		}
+	}
+	return true, tx.Commit()
+}
+
+// startFraudRecovery periodically requeues transactions still pending
+// analysis after fraudRecoveryGrace: skipped because the queue was full, or
+// queued on an instance that stopped before getting to them.
+func (app *App) startFraudRecovery() {
+	interval := app.config.FraudRecoveryIntervalSec
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		for {
+			if app.db != nil {
+				if _, err := app.resumeFraudChecks(context.Background()); err != nil {
+					app.log("warn", "Failed to resume fraud checks", map[string]interface{}{"error": err.Error()})
+				}
+			}
+			time.Sleep(time.Duration(interval) * time.Second)
+		}
+	}()
+}
+
+// resumeFraudChecks queues up to a queue's worth of the oldest pending
+// transactions and returns how many were accepted.
+func (app *App) resumeFraudChecks(ctx context.Context) (int, error) {
+	rows, err := app.jobPool().QueryContext(ctx, `
+		SELECT id, from_account, to_account, amount, description, status, created_at,
+			COALESCE(session_id, ''), COALESCE(region, ''), COALESCE(refund_of, ''), internal
+		FROM transactions
+		WHERE fraud_status = 'pending' AND created_at < $1
+		ORDER BY created_at
+		LIMIT $2
+	`, time.Now().UTC().Add(-fraudRecoveryGrace), app.config.FraudQueueSize)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	resumed := 0
+	for rows.Next() {
+		txn := Transaction{FraudStatus: "pending"}
+		if err := rows.Scan(&txn.ID, &txn.FromAccount, &txn.ToAccount, &txn.Amount, &txn.Description, &txn.Status, &txn.CreatedAt, &txn.SessionID, &txn.Region, &txn.RefundOf, &txn.Internal); err != nil {
+			return resumed, err
+		}
+		if !app.fraudPool.Submit(txn) {
+			break
+		}
+		resumed++
+	}
+	if resumed > 0 {
+		fraudResumedTotal.Add(float64(resumed))
+		app.log("info", "Resumed pending fraud checks", map[string]interface{}{"transactions": resumed})
+	}
+	return resumed, rows.Err()
+}
+
+// Load returns how many transactions are queued and how many can be.
+func (p *FraudPool) Load() (queued, capacity int) {
+	return len(p.jobs), cap(p.jobs)
+}
+
+// Closed reports whether Drain has been called.
+func (p *FraudPool) Closed() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.closed
+}
+
+// Drain stops accepting work and waits for queued transactions to be
+// analyzed, or for ctx to end. It returns how many were still queued when it
+// gave up.
+func (p *FraudPool) Drain(ctx context.Context) int {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.jobs)
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return 0
+	case <-ctx.Done():
+		return len(p.jobs)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFraudPoolTarget(t *testing.T) {
+	tests := []struct {
+		name            string
+		workers, queued int
+		latency         time.Duration
+		idleTicks       int
+		want            int
+		reason          string
+	}{
+		{"steady", 2, 1, 10 * time.Millisecond, 0, 2, ""},
+		{"backlog", 2, 5, 0, 0, 4, "queue_depth"},
+		{"backlog capped at max", 2, 50, 0, 0, 8, "queue_depth"},
+		{"slow with work queued", 2, 1, time.Second, 0, 3, "latency"},
+		{"slow but idle", 2, 0, time.Second, 1, 2, ""},
+		{"at max", 8, 50, time.Second, 0, 8, ""},
+		{"idle long enough", 4, 0, 0, fraudScaleDownIdle, 3, "idle"},
+		{"idle at min", 2, 0, 0, fraudScaleDownIdle, 2, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reason := fraudPoolTarget(tt.workers, 2, 8, tt.queued, tt.latency, 250*time.Millisecond, tt.idleTicks)
+			if got != tt.want || reason != tt.reason {
+				t.Errorf("got %d %q, want %d %q", got, reason, tt.want, tt.reason)
+			}
+		})
+	}
+}
+
+func TestFraudPoolAutoscale(t *testing.T) {
+	var logs bytes.Buffer
+	app := newTestApp(t, nil)
+	app.logs = newLogger("info", "json", &logs)
+	p := newFraudPool(app, 10, 1, 4, time.Second)
+	// One worker on the books but none running, so the queue stays put
+	// until the pool scales.
+	p.workers = 1
+	for i := 0; i < 3; i++ {
+		p.SubmitAssessed(Transaction{ID: "txn"}, &FraudAssessment{Decision: "allow"})
+	}
+
+	p.autoscale()
+	if got := p.Workers(); got != 4 {
+		t.Fatalf("%d workers after a backlog of 3, want 4", got)
+	}
+	if !strings.Contains(logs.String(), `"event_type":"`+EventFraudPoolScaled+`"`) || !strings.Contains(logs.String(), `"reason":"queue_depth"`) {
+		t.Errorf("no fraud.pool_scaled event in %s", logs.String())
+	}
+	for deadline := time.Now().Add(time.Second); len(p.jobs) > 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+
+	for i := 0; i < fraudScaleDownIdle; i++ {
+		p.autoscale()
+	}
+	if got := p.Workers(); got != 3 {
+		t.Errorf("%d workers after %d idle looks, want 3", got, fraudScaleDownIdle)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if left := p.Drain(ctx); left != 0 {
+		t.Errorf("Drain left %d queued", left)
+	}
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/infrasage/payflow/internal/store"
+)
+
+// graphqlMaxDepth bounds how deeply queries may nest. The deepest useful
+// path, fraudAlerts.transaction.counterparty.name, needs four levels.
+const graphqlMaxDepth = 6
+
+// graphqlSchema is the dashboard graph. Every field has its own resolver, so
+// a query only pays for what it selects: counting stats, listing
+// transactions and loading the transaction behind a fraud alert are separate
+// queries that run only when asked for.
+const graphqlSchema = `
+schema {
+	query: Query
+}
+
+scalar Time
+
+type Query {
+	# Revenue and counts for the demo session. Requires transactions:read.
+	stats(locale: String): Stats!
+	# A page of transactions, newest first. Requires transactions:read.
+	transactions(limit: Int = 50, offset: Int = 0, status: String): TransactionPage
+	# The most recent fraud alerts. Requires admin access.
+	fraudAlerts(limit: Int = 20): [FraudAlert!]
+	# The public configuration, with bug injection settings for admins.
+	config: Config!
+}
+
+type Money {
+	currency: String!
+	# A string because GraphQL integers are 32-bit.
+	minorUnits: String!
+	amount: String!
+	formatted: String!
+	locale: String!
+}
+
+type Stats {
+	revenue: Money!
+	transactions: Int!
+	# Percent of transactions that settled.
+	successRate: Float!
+	# Mocked, as on GET /api/stats.
+	avgLatency: Int!
+}
+
+type Counterparty {
+	account: String!
+	name: String!
+	category: String
+	riskTier: String
+}
+
+type Transaction {
+	id: ID!
+	fromAccount: String!
+	toAccount: String!
+	amount: Float!
+	description: String!
+	status: String!
+	createdAt: Time!
+	region: String
+	refundOf: ID
+	counterparty: Counterparty
+}
+
+type TransactionPage {
+	total: Int!
+	limit: Int!
+	offset: Int!
+	data: [Transaction!]!
+}
+
+type FraudRuleHit {
+	rule: String!
+	type: String!
+	score: Float!
+	reason: String!
+}
+
+type FraudAlert {
+	transactionId: ID!
+	score: Float!
+	decision: String!
+	ruleSetVersion: String!
+	flaggedAt: Time!
+	hits: [FraudRuleHit!]!
+	# The flagged transaction, or null once it is no longer visible.
+	transaction: Transaction
+}
+
+type BugInjection {
+	oom: Boolean!
+	latencyMs: Int!
+	errorRate: Float!
+	cpuBurn: Boolean!
+	panic: Boolean!
+	dbTimeout: Boolean!
+}
+
+type Config {
+	cacheMode: String!
+	cacheMaxSize: String!
+	cacheTtl: Int!
+	dbPoolSize: Int!
+	rateLimitRps: Int!
+	logLevel: String!
+	featureNewCache: Boolean!
+	# Only visible to admins.
+	bugInjection: BugInjection
+}
+`
+
+var errGraphQLDatabase = errors.New("database error")
+
+func (app *App) initGraphQL() error {
+	schema, err := graphql.ParseSchema(graphqlSchema, &graphqlQuery{app: app}, graphql.MaxDepth(graphqlMaxDepth))
+	if err != nil {
+		return err
+	}
+	app.graphql = schema
+	return nil
+}
+
+type graphqlRequestKey struct{}
+
+// graphqlRequest returns the HTTP request a resolver runs for, so resolvers
+// see the same principal, demo session and config overrides as REST handlers.
+func graphqlRequest(ctx context.Context) *gin.Context {
+	return ctx.Value(graphqlRequestKey{}).(*gin.Context)
+}
+
+// requireGraphQLScope is requireScope for a single field.
+func requireGraphQLScope(c *gin.Context, scope string) error {
+	if p := principalFrom(c); p != nil && !p.HasScope(scope) {
+		return fmt.Errorf("scope %q required", scope)
+	}
+	return nil
+}
+
+// graphqlHandler executes a query sent as POST {"query", "operationName",
+// "variables"} or as GET ?query=. Errors are reported in the response's
+// errors list next to whatever data could be resolved, so one failing field
+// doesn't blank the dashboard.
+func (app *App) graphqlHandler(c *gin.Context) {
+	var req struct {
+		Query         string                 `json:"query"`
+		OperationName string                 `json:"operationName"`
+		Variables     map[string]interface{} `json:"variables"`
+	}
+	if c.Request.Method == http.MethodGet {
+		req.Query, req.OperationName = c.Query("query"), c.Query("operationName")
+		if vars := c.Query("variables"); vars != "" {
+			if err := json.Unmarshal([]byte(vars), &req.Variables); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "variables must be a JSON object"})
+				return
+			}
+		}
+	} else {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBodyBytes)
+		if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid GraphQL request body"})
+			return
+		}
+	}
+	if req.Query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "query is required"})
+		return
+	}
+
+	ctx := context.WithValue(c.Request.Context(), graphqlRequestKey{}, c)
+	resp := app.graphql.Exec(ctx, req.Query, req.OperationName, req.Variables)
+	if len(resp.Errors) > 0 {
+		app.debug(c.Request.Context(), "GraphQL query returned errors", map[string]interface{}{
+			"operation": req.OperationName,
+			"errors":    len(resp.Errors),
+			"first":     resp.Errors[0].Message,
+		})
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+type graphqlQuery struct {
+	app *App
+}
+
+func (q *graphqlQuery) Stats(ctx context.Context, args struct{ Locale *string }) (*graphqlStats, error) {
+	c := graphqlRequest(ctx)
+	if err := requireGraphQLScope(c, "transactions:read"); err != nil {
+		return nil, err
+	}
+	locale := q.app.requestLocale(c)
+	if args.Locale != nil {
+		if _, ok := locales[*args.Locale]; !ok {
+			return nil, fmt.Errorf("unsupported locale %q", *args.Locale)
+		}
+		locale = *args.Locale
+	}
+	stats := q.app.computeStats(ctx, sessionID(c))
+	return &graphqlStats{stats: stats, revenue: newMoney(stats.RevenueMinor, q.app.config.Currency, locale)}, nil
+}
+
+func (q *graphqlQuery) Transactions(ctx context.Context, args struct {
+	Limit  int32
+	Offset int32
+	Status *string
+}) (*graphqlTransactionPage, error) {
+	c := graphqlRequest(ctx)
+	if err := requireGraphQLScope(c, "transactions:read"); err != nil {
+		return nil, err
+	}
+	limit, offset := int(args.Limit), int(args.Offset)
+	if limit < 1 || limit > maxPageLimit {
+		return nil, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+	}
+	if offset < 0 || offset > maxPageOffset {
+		return nil, fmt.Errorf("offset must be between 0 and %d", maxPageOffset)
+	}
+	if q.app.transactions == nil {
+		return &graphqlTransactionPage{page: TransactionPage{Data: []Transaction{}, Limit: limit, Offset: offset}}, nil
+	}
+
+	// The same injected faults as GET /api/transactions, so the dashboard
+	// shows them whichever way it reads.
+	if q.app.cfg(c).InjectDBTimeout && q.app.db != nil {
+		q.app.readPool().ExecContext(ctx, `SELECT pg_sleep(30)`)
+	}
+	filter := store.TransactionFilter{SessionID: sessionID(c), Limit: limit, Offset: offset}
+	q.app.applyReplicationLag(c, &filter)
+	if args.Status != nil {
+		filter.Statuses = []string{*args.Status}
+	}
+	page, err := q.app.listTransactions(ctx, filter)
+	if err != nil {
+		return nil, errGraphQLDatabase
+	}
+	return &graphqlTransactionPage{page: page}, nil
+}
+
+func (q *graphqlQuery) FraudAlerts(ctx context.Context, args struct{ Limit int32 }) (*[]*graphqlFraudAlert, error) {
+	c := graphqlRequest(ctx)
+	if err := requireGraphQLScope(c, "transactions:read"); err != nil {
+		return nil, err
+	}
+	if !q.app.authorizeAdmin(ctx, "fraud", "GRAPHQL", "Query.fraudAlerts", principalFrom(c), c.GetHeader("X-Admin-Token")) {
+		return nil, errors.New("admin or fraud analyst access required")
+	}
+	if args.Limit < 1 || args.Limit > maxFraudAlerts {
+		return nil, fmt.Errorf("limit must be between 1 and %d", maxFraudAlerts)
+	}
+	alerts, err := q.app.listFraudAlerts(ctx, sessionID(c), int(args.Limit))
+	if errors.Is(err, errDatabaseUnavailable) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errGraphQLDatabase
+	}
+	out := make([]*graphqlFraudAlert, 0, len(alerts))
+	for _, a := range alerts {
+		out = append(out, &graphqlFraudAlert{app: q.app, alert: a})
+	}
+	return &out, nil
+}
+
+func (q *graphqlQuery) Config(ctx context.Context) *graphqlConfig {
+	c := graphqlRequest(ctx)
+	return &graphqlConfig{config: q.app.cfg(c), admin: q.app.isAdminRequest(c)}
+}
+
+type graphqlStats struct {
+	stats   StatsSummary
+	revenue Money
+}
+
+func (s *graphqlStats) Revenue() *graphqlMoney { return &graphqlMoney{s.revenue} }
+func (s *graphqlStats) Transactions() int32    { return int32(s.stats.Transactions) }
+func (s *graphqlStats) SuccessRate() float64   { return s.stats.SuccessRate() }
+func (s *graphqlStats) AvgLatency() int32      { return 45 }
+
+type graphqlMoney struct {
+	m Money
+}
+
+func (m *graphqlMoney) Currency() string   { return m.m.Currency }
+func (m *graphqlMoney) MinorUnits() string { return strconv.FormatInt(m.m.MinorUnits, 10) }
+func (m *graphqlMoney) Amount() string     { return m.m.Amount }
+func (m *graphqlMoney) Formatted() string  { return m.m.Formatted }
+func (m *graphqlMoney) Locale() string     { return m.m.Locale }
+
+type graphqlTransactionPage struct {
+	page TransactionPage
+}
+
+func (p *graphqlTransactionPage) Total() int32  { return int32(p.page.Total) }
+func (p *graphqlTransactionPage) Limit() int32  { return int32(p.page.Limit) }
+func (p *graphqlTransactionPage) Offset() int32 { return int32(p.page.Offset) }
+
+func (p *graphqlTransactionPage) Data() []*graphqlTransaction {
+	out := make([]*graphqlTransaction, 0, len(p.page.Data))
+	for _, t := range p.page.Data {
+		out = append(out, &graphqlTransaction{t})
+	}
+	return out
+}
+
+type graphqlTransaction struct {
+	t Transaction
+}
+
+func (t *graphqlTransaction) ID() graphql.ID          { return graphql.ID(t.t.ID) }
+func (t *graphqlTransaction) FromAccount() string     { return t.t.FromAccount }
+func (t *graphqlTransaction) ToAccount() string       { return t.t.ToAccount }
+func (t *graphqlTransaction) Amount() float64         { return t.t.Amount }
+func (t *graphqlTransaction) Description() string     { return t.t.Description }
+func (t *graphqlTransaction) Status() string          { return t.t.Status }
+func (t *graphqlTransaction) CreatedAt() graphql.Time { return graphql.Time{Time: t.t.CreatedAt} }
+func (t *graphqlTransaction) Region() *string         { return optionalString(t.t.Region) }
+
+func (t *graphqlTransaction) RefundOf() *graphql.ID {
+	if t.t.RefundOf == "" {
+		return nil
+	}
+	id := graphql.ID(t.t.RefundOf)
+	return &id
+}
+
+func (t *graphqlTransaction) Counterparty() *graphqlCounterparty {
+	if t.t.Counterparty == nil {
+		return nil
+	}
+	return &graphqlCounterparty{*t.t.Counterparty}
+}
+
+type graphqlCounterparty struct {
+	cp Counterparty
+}
+
+func (c *graphqlCounterparty) Account() string   { return c.cp.Account }
+func (c *graphqlCounterparty) Name() string      { return c.cp.Name }
+func (c *graphqlCounterparty) Category() *string { return optionalString(c.cp.Category) }
+func (c *graphqlCounterparty) RiskTier() *string { return optionalString(c.cp.RiskTier) }
+
+type graphqlFraudAlert struct {
+	app   *App
+	alert FraudAlert
+}
+
+func (a *graphqlFraudAlert) TransactionID() graphql.ID { return graphql.ID(a.alert.TransactionID) }
+func (a *graphqlFraudAlert) Score() float64            { return a.alert.Score }
+func (a *graphqlFraudAlert) Decision() string          { return a.alert.Decision }
+func (a *graphqlFraudAlert) RuleSetVersion() string    { return a.alert.RuleSetVersion }
+func (a *graphqlFraudAlert) FlaggedAt() graphql.Time   { return graphql.Time{Time: a.alert.FlaggedAt} }
+
+func (a *graphqlFraudAlert) Hits() []*graphqlRuleHit {
+	out := make([]*graphqlRuleHit, 0, len(a.alert.Hits))
+	for _, h := range a.alert.Hits {
+		out = append(out, &graphqlRuleHit{h})
+	}
+	return out
+}
+
+// Transaction loads the flagged transaction from the session's own
+// transactions, so an alert never reveals another session's data.
+func (a *graphqlFraudAlert) Transaction(ctx context.Context) (*graphqlTransaction, error) {
+	if a.app.transactions == nil {
+		return nil, errGraphQLDatabase
+	}
+	page, err := a.app.listTransactions(ctx, store.TransactionFilter{
+		ID:        a.alert.TransactionID,
+		SessionID: sessionID(graphqlRequest(ctx)),
+		Limit:     1,
+	})
+	if err != nil {
+		return nil, errGraphQLDatabase
+	}
+	if len(page.Data) == 0 {
+		return nil, nil
+	}
+	return &graphqlTransaction{page.Data[0]}, nil
+}
+
+type graphqlRuleHit struct {
+	h RuleHit
+}
+
+func (h *graphqlRuleHit) Rule() string   { return h.h.Rule }
+func (h *graphqlRuleHit) Type() string   { return h.h.Type }
+func (h *graphqlRuleHit) Score() float64 { return h.h.Score }
+func (h *graphqlRuleHit) Reason() string { return h.h.Reason }
+
+type graphqlConfig struct {
+	config *Config
+	admin  bool
+}
+
+func (c *graphqlConfig) CacheMode() string     { return c.config.CacheMode }
+func (c *graphqlConfig) CacheMaxSize() string  { return c.config.CacheMaxSize }
+func (c *graphqlConfig) CacheTTL() int32       { return int32(c.config.CacheTTL) }
+func (c *graphqlConfig) DBPoolSize() int32     { return int32(c.config.DBPoolSize) }
+func (c *graphqlConfig) RateLimitRPS() int32   { return int32(c.config.RateLimitRPS) }
+func (c *graphqlConfig) LogLevel() string      { return c.config.LogLevel }
+func (c *graphqlConfig) FeatureNewCache() bool { return c.config.FeatureNewCache }
+
+func (c *graphqlConfig) BugInjection() *graphqlBugInjection {
+	if !c.admin {
+		return nil
+	}
+	return &graphqlBugInjection{c.config}
+}
+
+type graphqlBugInjection struct {
+	config *Config
+}
+
+func (b *graphqlBugInjection) OOM() bool          { return b.config.InjectOOM }
+func (b *graphqlBugInjection) LatencyMs() int32   { return int32(b.config.InjectLatencyMs) }
+func (b *graphqlBugInjection) ErrorRate() float64 { return b.config.InjectErrorRate }
+func (b *graphqlBugInjection) CPUBurn() bool      { return b.config.InjectCPUBurn }
+func (b *graphqlBugInjection) Panic() bool        { return b.config.InjectPanic }
+func (b *graphqlBugInjection) DBTimeout() bool    { return b.config.InjectDBTimeout }
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
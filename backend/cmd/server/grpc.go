@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	payflowv1 "github.com/infrasage/payflow/api/payflow/v1"
+	apihttp "github.com/infrasage/payflow/internal/http"
+	"github.com/infrasage/payflow/internal/store"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const maxFraudAlerts = 100
+
+var grpcRequestDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "payflow_grpc_request_duration_seconds",
+		Help:    "gRPC request duration in seconds",
+		Buckets: prometheus.DefBuckets,
+	},
+	[]string{"method", "code"},
+)
+
+// grpcScopes are the scopes each PaymentService method requires, as
+// requireScope enforces them for the matching REST routes.
+var grpcScopes = map[string]string{
+	payflowv1.PaymentService_CreateTransaction_FullMethodName: "transactions:write",
+	payflowv1.PaymentService_ListTransactions_FullMethodName:  "transactions:read",
+	payflowv1.PaymentService_GetStats_FullMethodName:          "transactions:read",
+}
+
+// grpcCall is what the interceptor learned about a call: who made it and
+// which demo session it runs in.
+type grpcCall struct {
+	principal  *Principal
+	adminToken string
+	session    string
+}
+
+type grpcCallKey struct{}
+
+func grpcCallFrom(ctx context.Context) grpcCall {
+	call, _ := ctx.Value(grpcCallKey{}).(grpcCall)
+	return call
+}
+
+// GRPCServer serves PaymentService, the standard health service and server
+// reflection on GRPC_PORT.
+type GRPCServer struct {
+	server *grpc.Server
+	health *health.Server
+}
+
+// startGRPC listens on GRPC_PORT and serves in the background.
+func (app *App) startGRPC() (*GRPCServer, error) {
+	lis, err := net.Listen("tcp", ":"+app.config.GRPCPort)
+	if err != nil {
+		return nil, err
+	}
+	s := &GRPCServer{
+		server: grpc.NewServer(grpc.UnaryInterceptor(app.grpcInterceptor)),
+		health: health.NewServer(),
+	}
+	payflowv1.RegisterPaymentServiceServer(s.server, &paymentService{app: app})
+	healthpb.RegisterHealthServer(s.server, s.health)
+	reflection.Register(s.server)
+	s.health.SetServingStatus(payflowv1.PaymentService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+
+	go func() {
+		if err := s.server.Serve(lis); err != nil {
+			app.log("error", "gRPC server stopped", map[string]interface{}{"error": err.Error()})
+		}
+	}()
+	app.log("info", "gRPC server listening", map[string]interface{}{"port": app.config.GRPCPort})
+	return s, nil
+}
+
+// Stop reports NOT_SERVING to health checks and waits for in-flight calls
+// until ctx ends.
+func (s *GRPCServer) Stop(ctx context.Context) {
+	s.health.Shutdown()
+	done := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.server.Stop()
+	}
+}
+
+// grpcInterceptor gives PaymentService calls the treatment the HTTP
+// middleware gives /api requests: a request ID, authentication through the
+// authorization (bearer token) or x-api-key metadata, OAUTH_REQUIRED, scopes,
+// and the x-demo-session metadata. Health and reflection calls pass through.
+func (app *App) grpcInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !strings.HasPrefix(info.FullMethod, "/"+payflowv1.PaymentService_ServiceDesc.ServiceName+"/") {
+		return handler(ctx, req)
+	}
+	start := time.Now()
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(key string) string {
+		if v := md.Get(key); len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+
+	id := first("x-request-id")
+	if !apihttp.ValidRequestID(id) {
+		id = uuid.New().String()
+	}
+	ctx = context.WithValue(ctx, logTraceKey{}, &logTrace{ID: id})
+	grpc.SetHeader(ctx, metadata.Pairs("x-request-id", id))
+
+	resp, err := app.grpcAuthenticate(ctx, first, info.FullMethod, func(ctx context.Context) (interface{}, error) {
+		return handler(ctx, req)
+	})
+	grpcRequestDuration.WithLabelValues(info.FullMethod, status.Code(err).String()).Observe(time.Since(start).Seconds())
+	return resp, err
+}
+
+func (app *App) grpcAuthenticate(ctx context.Context, md func(string) string, method string, next func(context.Context) (interface{}, error)) (interface{}, error) {
+	call := grpcCall{adminToken: md("x-admin-token")}
+	bearer, _ := strings.CutPrefix(md("authorization"), "Bearer ")
+	apiKey := md("x-api-key")
+	switch {
+	case bearer != "" && apiKey != "":
+		return nil, status.Error(codes.InvalidArgument, "send either a bearer token or an API key, not both")
+	case bearer != "":
+		var err error
+		if app.oidc != nil && app.oidc.Handles(bearer) {
+			call.principal, err = app.oidc.Verify(bearer)
+		} else {
+			call.principal, err = app.validateServiceToken(bearer)
+		}
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, "invalid bearer token")
+		}
+	case apiKey != "":
+		if app.db == nil {
+			return nil, status.Error(codes.Unavailable, "database unavailable")
+		}
+		p, err := app.lookupAPIKey(ctx, apiKey)
+		if err != nil {
+			return nil, status.Error(codes.Unavailable, "failed to verify API key")
+		}
+		if p == nil {
+			return nil, status.Error(codes.Unauthenticated, "invalid API key")
+		}
+		call.principal = p
+	}
+
+	if call.principal == nil && app.config.OAuthRequired {
+		return nil, status.Error(codes.Unauthenticated, "bearer token or API key required")
+	}
+	if scope := grpcScopes[method]; scope != "" && call.principal != nil && !call.principal.HasScope(scope) {
+		return nil, status.Errorf(codes.PermissionDenied, "scope %q required", scope)
+	}
+
+	if id := md("x-demo-session"); id != "" && app.db != nil {
+		session, err := app.lookupSession(ctx, id)
+		if err != nil {
+			return nil, status.Error(codes.Unavailable, "failed to load demo session")
+		}
+		if session == nil {
+			return nil, status.Error(codes.NotFound, "demo session not found or expired")
+		}
+		call.session = session.ID
+	}
+	return next(context.WithValue(ctx, grpcCallKey{}, call))
+}
+
+// paymentService implements payflowv1.PaymentServiceServer on the same
+// service functions as the REST handlers.
+type paymentService struct {
+	payflowv1.UnimplementedPaymentServiceServer
+	app *App
+}
+
+func toProtoTransaction(t Transaction) *payflowv1.Transaction {
+	return &payflowv1.Transaction{
+		Id:          t.ID,
+		FromAccount: t.FromAccount,
+		ToAccount:   t.ToAccount,
+		Amount:      t.Amount,
+		Description: t.Description,
+		Status:      t.Status,
+		CreatedAt:   timestamppb.New(t.CreatedAt),
+		Region:      t.Region,
+		RefundOf:    t.RefundOf,
+		StatusToken: t.StatusToken,
+	}
+}
+
+func (s *paymentService) CreateTransaction(ctx context.Context, req *payflowv1.CreateTransactionRequest) (*payflowv1.CreateTransactionResponse, error) {
+	// The same limits as schemas/create-transaction.json.
+	switch {
+	case req.FromAccount == "" || req.ToAccount == "":
+		return nil, status.Error(codes.InvalidArgument, "from_account and to_account are required")
+	case len(req.FromAccount) > 255 || len(req.ToAccount) > 255:
+		return nil, status.Error(codes.InvalidArgument, "account identifiers are limited to 255 characters")
+	case req.Amount <= 0 || req.Amount > 9999999999999.99:
+		return nil, status.Error(codes.InvalidArgument, "amount must be positive and at most 9999999999999.99")
+	case !wholeCents(req.Amount):
+		return nil, status.Error(codes.InvalidArgument, errAmountPrecision.Error())
+	case len(req.Description) > 1000:
+		return nil, status.Error(codes.InvalidArgument, "description is limited to 1000 characters")
+	}
+
+	txn, spooled, err := s.app.createPayment(ctx, PaymentRequest{
+		FromAccount: req.FromAccount,
+		ToAccount:   req.ToAccount,
+		Amount:      req.Amount,
+		Description: req.Description,
+		SessionID:   grpcCallFrom(ctx).session,
+	})
+	if errors.Is(err, errPaymentRejected) {
+		return nil, status.Error(codes.Internal, "database error")
+	}
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, "database unavailable")
+	}
+	return &payflowv1.CreateTransactionResponse{Transaction: toProtoTransaction(txn), Spooled: spooled}, nil
+}
+
+func (s *paymentService) ListTransactions(ctx context.Context, req *payflowv1.ListTransactionsRequest) (*payflowv1.ListTransactionsResponse, error) {
+	limit, offset := int(req.Limit), int(req.Offset)
+	if limit == 0 {
+		limit = defaultPageLimit
+	}
+	if limit < 1 || limit > maxPageLimit {
+		return nil, status.Errorf(codes.InvalidArgument, "limit must be between 1 and %d", maxPageLimit)
+	}
+	if offset < 0 || offset > maxPageOffset {
+		return nil, status.Errorf(codes.InvalidArgument, "offset must be between 0 and %d", maxPageOffset)
+	}
+	resp := &payflowv1.ListTransactionsResponse{Transactions: []*payflowv1.Transaction{}}
+	if s.app.transactions == nil {
+		return resp, nil
+	}
+
+	filter := store.TransactionFilter{SessionID: grpcCallFrom(ctx).session, Limit: limit, Offset: offset}
+	if req.Status != "" {
+		filter.Statuses = []string{req.Status}
+	}
+	page, err := s.app.listTransactions(ctx, filter)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "database error")
+	}
+	for _, t := range page.Data {
+		resp.Transactions = append(resp.Transactions, toProtoTransaction(t))
+	}
+	resp.Total = int64(page.Total)
+	return resp, nil
+}
+
+func (s *paymentService) GetStats(ctx context.Context, req *payflowv1.GetStatsRequest) (*payflowv1.Stats, error) {
+	locale := s.app.config.StatsLocale
+	if req.Locale != "" {
+		if _, ok := locales[req.Locale]; !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "unsupported locale %q", req.Locale)
+		}
+		locale = req.Locale
+	}
+	stats := s.app.computeStats(ctx, grpcCallFrom(ctx).session)
+	revenue := newMoney(stats.RevenueMinor, s.app.config.Currency, locale)
+	return &payflowv1.Stats{
+		Revenue: &payflowv1.Money{
+			Currency:   revenue.Currency,
+			MinorUnits: revenue.MinorUnits,
+			Amount:     revenue.Amount,
+			Formatted:  revenue.Formatted,
+			Locale:     revenue.Locale,
+		},
+		Transactions: int64(stats.Transactions),
+		SuccessRate:  stats.SuccessRate(),
+	}, nil
+}
+
+// ListFraudAlerts is a fraud action, like the fraud routes under /api/admin,
+// open to admins and fraud analysts, and goes through the policy engine when
+// one is configured.
+func (s *paymentService) ListFraudAlerts(ctx context.Context, req *payflowv1.ListFraudAlertsRequest) (*payflowv1.ListFraudAlertsResponse, error) {
+	call := grpcCallFrom(ctx)
+	if !s.app.authorizeAdmin(ctx, "fraud", "GRPC", payflowv1.PaymentService_ListFraudAlerts_FullMethodName, call.principal, call.adminToken) {
+		return nil, status.Error(codes.PermissionDenied, "admin or fraud analyst access required")
+	}
+
+	limit := int(req.Limit)
+	if limit == 0 {
+		limit = 20
+	}
+	if limit < 1 || limit > maxFraudAlerts {
+		return nil, status.Errorf(codes.InvalidArgument, "limit must be between 1 and %d", maxFraudAlerts)
+	}
+	alerts, err := s.app.listFraudAlerts(ctx, call.session, limit)
+	if errors.Is(err, errDatabaseUnavailable) {
+		return nil, status.Error(codes.Unavailable, "database unavailable")
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, "database error")
+	}
+
+	resp := &payflowv1.ListFraudAlertsResponse{Alerts: []*payflowv1.FraudAlert{}}
+	for _, a := range alerts {
+		alert := &payflowv1.FraudAlert{
+			TransactionId:  a.TransactionID,
+			Score:          a.Score,
+			Decision:       a.Decision,
+			RuleSetVersion: a.RuleSetVersion,
+			FlaggedAt:      timestamppb.New(a.FlaggedAt),
+		}
+		for _, h := range a.Hits {
+			alert.Hits = append(alert.Hits, &payflowv1.FraudRuleHit{Rule: h.Rule, Type: h.Type, Score: h.Score, Reason: h.Reason})
+		}
+		resp.Alerts = append(resp.Alerts, alert)
+	}
+	return resp, nil
+}
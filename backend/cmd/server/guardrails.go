@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/infrasage/payflow/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxChangeFactor is how far a single change may move a rule's score, a
+// numeric threshold, a rate limit or a chaos setting, in either direction,
+// before it needs force=true.
+const maxChangeFactor = 10
+
+// errGuardrailsRefused is returned by setters that were refused by their
+// guardrail decision; the decision has already answered the caller.
+var errGuardrailsRefused = errors.New("change refused by guardrails")
+
+var guardrailTripsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "payflow_guardrail_trips_total",
+		Help: "Runtime changes that tripped a guardrail, by guardrail and whether they were forced through",
+	},
+	[]string{"guardrail", "forced"},
+)
+
+// GuardrailViolation is one reason a runtime change looks too drastic to
+// apply without confirmation.
+type GuardrailViolation struct {
+	Guardrail string `json:"guardrail"`
+	Detail    string `json:"detail"`
+}
+
+// ruleSetGuardrails compares a candidate fraud rule set with the active one.
+// It objects to switching off every rule, to leaving no way to reach
+// FRAUD_BLOCK_SCORE when the active set can, and to moving a rule's score or
+// one of its numeric params by more than maxChangeFactor at once.
+func ruleSetGuardrails(active, candidate *RuleSet, blockScore float64) []GuardrailViolation {
+	var violations []GuardrailViolation
+	if len(active.Enabled()) > 0 && len(candidate.Enabled()) == 0 {
+		violations = append(violations, GuardrailViolation{"all_rules_disabled", "the candidate rule set has no enabled rules"})
+	} else if maxScore(active) >= blockScore && maxScore(candidate) < blockScore {
+		violations = append(violations, GuardrailViolation{"block_unreachable", fmt.Sprintf(
+			"enabled rules add up to %g, below FRAUD_BLOCK_SCORE %g", maxScore(candidate), blockScore)})
+	}
+
+	before := map[string]RuleSpec{}
+	for _, spec := range active.Enabled() {
+		before[spec.Name] = spec
+	}
+	for _, spec := range candidate.Enabled() {
+		old, ok := before[spec.Name]
+		if !ok || old.Type != spec.Type {
+			continue
+		}
+		if exceedsChangeFactor(old.Score, spec.Score) {
+			violations = append(violations, GuardrailViolation{"score_change", fmt.Sprintf(
+				"rule %q score changes from %g to %g", spec.Name, old.Score, spec.Score)})
+		}
+		keys := make([]string, 0, len(spec.Params))
+		for key := range spec.Params {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			from, ok1 := paramNumber(old.Params[key])
+			to, ok2 := paramNumber(spec.Params[key])
+			if ok1 && ok2 && exceedsChangeFactor(from, to) {
+				violations = append(violations, GuardrailViolation{"threshold_change", fmt.Sprintf(
+					"rule %q %s changes from %g to %g", spec.Name, key, from, to)})
+			}
+		}
+	}
+	return violations
+}
+
+// maxScore is the highest total score the set's enabled rules can give.
+func maxScore(set *RuleSet) float64 {
+	total := 0.0
+	for _, spec := range set.Enabled() {
+		total += spec.Score
+	}
+	return total
+}
+
+// exceedsChangeFactor reports whether going from a to b multiplies or
+// divides by maxChangeFactor or more. Changes to or from zero are left to
+// the other guardrails.
+func exceedsChangeFactor(a, b float64) bool {
+	if a <= 0 || b <= 0 {
+		return false
+	}
+	return b/a >= maxChangeFactor || a/b >= maxChangeFactor
+}
+
+// rateLimitGuardrails compares candidate rate limits with the active ones.
+// It objects to switching limiting off and to moving RATE_LIMIT_RPS,
+// RATE_LIMIT_BURST or a route's factor by maxChangeFactor or more at once.
+func rateLimitGuardrails(active, candidate *Config) []GuardrailViolation {
+	var violations []GuardrailViolation
+	if active.RateLimitRPS > 0 && candidate.RateLimitRPS <= 0 {
+		violations = append(violations, GuardrailViolation{"rate_limit_disabled", "RATE_LIMIT_RPS 0 turns rate limiting off"})
+	}
+	for _, s := range []struct {
+		env      string
+		from, to int
+	}{
+		{"RATE_LIMIT_RPS", active.RateLimitRPS, candidate.RateLimitRPS},
+		{"RATE_LIMIT_BURST", active.RateLimitBurst, candidate.RateLimitBurst},
+	} {
+		if exceedsChangeFactor(float64(s.from), float64(s.to)) {
+			violations = append(violations, GuardrailViolation{"rate_limit_change", fmt.Sprintf(
+				"%s changes from %d to %d", s.env, s.from, s.to)})
+		}
+	}
+
+	before, _ := parseRateLimitPolicy(active.RateLimitRoutes, active.RateLimitExempt)
+	after, err := parseRateLimitPolicy(candidate.RateLimitRoutes, candidate.RateLimitExempt)
+	if before == nil || err != nil {
+		return violations
+	}
+	routes := make([]string, 0, len(after.routes))
+	for route := range after.routes {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	for _, route := range routes {
+		if from, ok := before.routes[route]; ok && exceedsChangeFactor(from, after.routes[route]) {
+			violations = append(violations, GuardrailViolation{"route_factor_change", fmt.Sprintf(
+				"route %s factor changes from %g to %g", route, from, after.routes[route])})
+		}
+	}
+	return violations
+}
+
+// chaosGuardrails compares candidate chaos settings with the active ones. It
+// objects to failing or dropping every request and to moving a numeric
+// chaos setting by maxChangeFactor or more at once.
+func chaosGuardrails(active, candidate *Config) []GuardrailViolation {
+	var violations []GuardrailViolation
+	for _, s := range []struct {
+		env      string
+		from, to float64
+	}{
+		{"INJECT_ERROR_RATE", active.InjectErrorRate, candidate.InjectErrorRate},
+		{"INJECT_DROP_RATE", active.InjectDropRate, candidate.InjectDropRate},
+	} {
+		if s.from < 1 && s.to >= 1 {
+			violations = append(violations, GuardrailViolation{"every_request_fails", fmt.Sprintf(
+				"%s %g fails every request", s.env, s.to)})
+		}
+	}
+	for _, f := range config.Schema {
+		if !f.Chaos {
+			continue
+		}
+		from, ok1 := paramNumber(f.Value(active))
+		to, ok2 := paramNumber(f.Value(candidate))
+		if ok1 && ok2 && exceedsChangeFactor(from, to) {
+			violations = append(violations, GuardrailViolation{"chaos_change", fmt.Sprintf(
+				"%s changes from %g to %g", f.Env, from, to)})
+		}
+	}
+	return violations
+}
+
+// refuseGuardrails is the guardrail decision where nobody can confirm a
+// change, such as a reload on SIGHUP: any violation refuses it.
+func refuseGuardrails(violations []GuardrailViolation) bool {
+	for _, v := range violations {
+		guardrailTripsTotal.WithLabelValues(v.Guardrail, "false").Inc()
+	}
+	return len(violations) == 0
+}
+
+// paramNumber reads a numeric rule param, which YAML decodes as int and JSON
+// as float64.
+func paramNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// passGuardrails decides whether a runtime change may go ahead. Without
+// violations it may. Otherwise the caller must have sent force=true, which
+// is recorded as a guardrail.overridden event naming the admin. Refused
+// changes get a 409 listing the violations, and the caller must not apply
+// them.
+func (app *App) passGuardrails(c *gin.Context, change, entityID string, violations []GuardrailViolation) bool {
+	if len(violations) == 0 {
+		return true
+	}
+	forced := c.Query("force") == "true"
+	for _, v := range violations {
+		guardrailTripsTotal.WithLabelValues(v.Guardrail, fmt.Sprint(forced)).Inc()
+	}
+	if !forced {
+		app.logCtx(c.Request.Context(), "warn", "Change refused by guardrails", map[string]interface{}{
+			"change":     change,
+			"violations": violations,
+			"actor":      adminActor(c),
+		})
+		c.JSON(http.StatusConflict, gin.H{
+			"error":      "Change exceeds guardrails; retry with force=true to apply it anyway",
+			"violations": violations,
+		})
+		return false
+	}
+	app.eventCtx(c.Request.Context(), "warn", EventGuardrailOverridden, entityID, "Guardrails overridden with force=true", map[string]interface{}{
+		"change":       change,
+		"violations":   violations,
+		"actor":        adminActor(c),
+		"on_behalf_of": onBehalfOf(c),
+	})
+	return true
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestRateLimitGuardrails(t *testing.T) {
+	active := &Config{RateLimitRPS: 100, RateLimitBurst: 50, RateLimitRoutes: "/api/transactions=2"}
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		want   []string
+	}{
+		{"small raise", func(c *Config) { c.RateLimitRPS = 500 }, nil},
+		{"10x raise", func(c *Config) { c.RateLimitRPS = 1000 }, []string{"rate_limit_change"}},
+		{"10x cut in burst", func(c *Config) { c.RateLimitBurst = 5 }, []string{"rate_limit_change"}},
+		{"limiting off", func(c *Config) { c.RateLimitRPS = 0 }, []string{"rate_limit_disabled"}},
+		{"route factor", func(c *Config) { c.RateLimitRoutes = "/api/transactions=20" }, []string{"route_factor_change"}},
+		{"new route", func(c *Config) { c.RateLimitRoutes = "/api/transactions=2,/api/accounts=50" }, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			candidate := *active
+			tt.change(&candidate)
+			checkViolations(t, rateLimitGuardrails(active, &candidate), tt.want)
+		})
+	}
+}
+
+func TestChaosGuardrails(t *testing.T) {
+	active := &Config{InjectLatencyMs: 100, InjectErrorRate: 0.1}
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		want   []string
+	}{
+		{"small raise", func(c *Config) { c.InjectLatencyMs = 500 }, nil},
+		{"10x raise", func(c *Config) { c.InjectLatencyMs = 1000 }, []string{"chaos_change"}},
+		{"from zero", func(c *Config) { c.InjectReplicationLagMs = 5000 }, nil},
+		{"every request fails", func(c *Config) { c.InjectErrorRate = 1 }, []string{"chaos_change", "every_request_fails"}},
+		{"every request dropped", func(c *Config) { c.InjectDropRate = 1 }, []string{"every_request_fails"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			candidate := *active
+			tt.change(&candidate)
+			checkViolations(t, chaosGuardrails(active, &candidate), tt.want)
+		})
+	}
+}
+
+func checkViolations(t *testing.T, violations []GuardrailViolation, want []string) {
+	t.Helper()
+	got := map[string]bool{}
+	for _, v := range violations {
+		got[v.Guardrail] = true
+	}
+	if len(got) != len(want) {
+		t.Fatalf("violations %+v, want %v", violations, want)
+	}
+	for _, g := range want {
+		if !got[g] {
+			t.Errorf("violations %+v, want %s", violations, g)
+		}
+	}
+}
+
+// A 10x raise is refused without force=true and applied with it, whether it
+// comes through the admin API or a reload.
+func TestGuardrailsNeedForce(t *testing.T) {
+	app := newTestApp(t, nil)
+	r := app.newRouter()
+
+	if w := serve(r, http.MethodPut, "/api/admin/chaos", map[string]interface{}{"inject_latency_ms": 10}, nil); w.Code != http.StatusOK {
+		t.Fatalf("turning latency on = %d %s", w.Code, w.Body)
+	}
+	tests := []struct {
+		path string
+		body map[string]interface{}
+		live func() int
+		want int
+	}{
+		{"/api/admin/rate-limits", map[string]interface{}{"rps": 1000}, func() int { return app.liveConfig().RateLimitRPS }, 1000},
+		{"/api/admin/chaos", map[string]interface{}{"inject_latency_ms": 100}, func() int { return app.liveConfig().InjectLatencyMs }, 100},
+	}
+	for _, tt := range tests {
+		before := tt.live()
+		if w := serve(r, http.MethodPut, tt.path, tt.body, nil); w.Code != http.StatusConflict {
+			t.Errorf("PUT %s %v = %d %s, want 409", tt.path, tt.body, w.Code, w.Body)
+		}
+		if tt.live() != before {
+			t.Errorf("refused PUT %s %v applied: %d", tt.path, tt.body, tt.live())
+		}
+		if w := serve(r, http.MethodPut, tt.path+"?force=true", tt.body, nil); w.Code != http.StatusOK {
+			t.Errorf("forced PUT %s %v = %d %s", tt.path, tt.body, w.Code, w.Body)
+		}
+		if tt.live() != tt.want {
+			t.Errorf("after forced PUT %s: %d, want %d", tt.path, tt.live(), tt.want)
+		}
+	}
+
+	t.Setenv("RATE_LIMIT_RPS", "10000")
+	if _, err := app.reloadConfig(refuseGuardrails); !errors.Is(err, errGuardrailsRefused) {
+		t.Errorf("SIGHUP reload of a 10x raise: %v, want it refused", err)
+	}
+	if w := serve(r, http.MethodPost, "/api/admin/config/reload", nil, nil); w.Code != http.StatusConflict {
+		t.Errorf("reload = %d %s, want 409", w.Code, w.Body)
+	}
+	if rps := app.liveConfig().RateLimitRPS; rps != 1000 {
+		t.Errorf("refused reloads changed RATE_LIMIT_RPS to %d", rps)
+	}
+	if w := serve(r, http.MethodPost, "/api/admin/config/reload?force=true", nil, nil); w.Code != http.StatusOK {
+		t.Errorf("forced reload = %d %s", w.Code, w.Body)
+	}
+	if rps := app.liveConfig().RateLimitRPS; rps != 10000 {
+		t.Errorf("after forced reload RATE_LIMIT_RPS %d, want 10000", rps)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// healthCheckTimeout bounds the dependency round trips /health makes, all
+// together, so it stays well inside the liveness probe's timeout.
+const healthCheckTimeout = 2 * time.Second
+
+// healthHandler reports what the instance is and what it sees: build
+// information, uptime, the round trip to each dependency and the bug
+// injection it runs with, the last for admins only as on the dashboard. It
+// is the liveness probe, so it answers 200 "healthy" whatever its
+// dependencies do; /ready is what acts on them.
+func (app *App) healthHandler(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), healthCheckTimeout)
+	defer cancel()
+	deps := app.readinessChecks(ctx)
+	if app.bank != nil {
+		deps["bank"] = timed(DependencyCheck{Status: "ok"}, "down", func() error { return app.bank.Healthy(ctx) })
+	}
+	// The instance-wide settings; X-Feature-Overrides apply per request.
+	var chaos gin.H
+	if app.isAdminRequest(c) {
+		chaos = chaosStatus(app.liveConfig())
+	}
+	instance, _ := os.Hostname()
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "healthy",
+		"version":  appVersion,
+		"region":   app.config.Region,
+		"instance": instance,
+		"build": gin.H{
+			"git_sha":    gitSHA,
+			"build_time": buildTime,
+			"go":         runtime.Version(),
+		},
+		"started_at":   app.startedAt,
+		"uptime_sec":   int64(time.Since(app.startedAt).Seconds()),
+		"dependencies": deps,
+		"chaos":        chaos,
+	})
+}
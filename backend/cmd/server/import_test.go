@@ -0,0 +1,181 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseOFX(t *testing.T) {
+	sgml := `OFXHEADER:100
+DATA:OFXSGML
+<OFX><BANKMSGSRSV1><STMTTRNRS><STMTRS>
+<BANKACCTFROM><ACCTID>ACC-100</BANKACCTFROM>
+<BANKTRANLIST>
+<STMTTRN><TRNTYPE>DEBIT<DTPOSTED>20240301120000.000[-5:EST]<TRNAMT>-42.50<FITID>F1<NAME>Coffee Shop<MEMO>Latte
+<STMTTRN><TRNTYPE>CREDIT<DTPOSTED>20240302<TRNAMT>1000,00<FITID>F2<PAYEE>Employer
+</BANKTRANLIST>
+</STMTRS></STMTTRNRS></BANKMSGSRSV1></OFX>`
+	xml := `<?xml version="1.0"?><OFX><BANKACCTFROM><ACCTID>ACC-200</ACCTID></BANKACCTFROM><BANKTRANLIST>
+<STMTTRN><DTPOSTED>20240305</DTPOSTED><TRNAMT>15.00</TRNAMT><FITID>X1</FITID><NAME>Refund</NAME></STMTTRN>
+</BANKTRANLIST></OFX>`
+	bad := `<OFX><BANKTRANLIST>
+<STMTTRN><DTPOSTED>20240301<TRNAMT>abc<FITID>B1
+<STMTTRN><DTPOSTED>2024<TRNAMT>5<FITID>B2
+<STMTTRN><DTPOSTED>20240301<TRNAMT>5<FITID>B3
+</BANKTRANLIST></OFX>`
+
+	tests := []struct {
+		name    string
+		data    string
+		account string
+		lines   []StatementLine
+		skipped []string
+		err     string
+	}{
+		{"sgml", sgml, "ACC-100", []StatementLine{
+			{Line: 1, Ref: "F1", Date: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), Amount: -42.5, Counterparty: "Coffee Shop", Memo: "Latte"},
+			{Line: 2, Ref: "F2", Date: time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC), Amount: 1000, Counterparty: "Employer"},
+		}, nil, ""},
+		{"xml", xml, "ACC-200", []StatementLine{
+			{Line: 1, Ref: "X1", Date: time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC), Amount: 15, Counterparty: "Refund"},
+		}, nil, ""},
+		{"bad lines", bad, "", []StatementLine{
+			{Line: 3, Ref: "B3", Date: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), Amount: 5},
+		}, []string{`invalid TRNAMT "abc"`, `invalid DTPOSTED "2024"`}, ""},
+		{"no records", "<OFX><BANKTRANLIST></BANKTRANLIST></OFX>", "", nil, nil, "no STMTTRN records"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stmt, err := parseOFX([]byte(tt.data))
+			checkStatement(t, stmt, err, tt.account, tt.lines, tt.skipped, tt.err)
+		})
+	}
+}
+
+func TestParseMT940(t *testing.T) {
+	statement := `{1:F01BANKDEFFXXXX0000000000}
+:20:STMT-1
+:25:DE89370400440532013000
+:28C:1/1
+:60F:C240301EUR1000,00
+:61:2403010301D42,50NTRFCARD-1//BANK-1
+Card payment
+:86:?20Coffee?21Latte?32Coffee Shop
+:61:240302C1000,00NTRFNONREF
+:86:Salary March
+:61:240303RC10,00NCHGREV-1
+:61:garbage
+:62F:C240303EUR1947,50
+-`
+
+	tests := []struct {
+		name    string
+		data    string
+		account string
+		lines   []StatementLine
+		skipped []string
+		err     string
+	}{
+		{"statement", statement, "DE89370400440532013000", []StatementLine{
+			{Line: 6, Ref: "CARD-1//BANK-1", Date: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), Amount: -42.5, Counterparty: "Coffee Shop", Memo: "Card payment Coffee Latte"},
+			{Line: 9, Date: time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC), Amount: 1000, Memo: "Salary March"},
+			{Line: 11, Ref: "REV-1", Date: time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC), Amount: -10},
+		}, []string{`unrecognised :61: line "garbage"`}, ""},
+		{"no entries", ":20:STMT-1\n:25:ACC\n", "", nil, nil, "no :61: statement lines"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stmt, err := parseMT940([]byte(tt.data))
+			checkStatement(t, stmt, err, tt.account, tt.lines, tt.skipped, tt.err)
+		})
+	}
+}
+
+func checkStatement(t *testing.T, stmt *Statement, err error, account string, lines []StatementLine, skipped []string, wantErr string) {
+	t.Helper()
+	if wantErr != "" {
+		if err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Fatalf("error %v, want %q", err, wantErr)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stmt.Account != account {
+		t.Errorf("account %q, want %q", stmt.Account, account)
+	}
+	if len(stmt.Lines) != len(lines) {
+		t.Fatalf("lines %+v, want %+v", stmt.Lines, lines)
+	}
+	for i, l := range stmt.Lines {
+		if l != lines[i] {
+			t.Errorf("line %d: %+v, want %+v", i, l, lines[i])
+		}
+	}
+	if len(stmt.Skipped) != len(skipped) {
+		t.Fatalf("skipped %+v, want %v", stmt.Skipped, skipped)
+	}
+	for i, s := range stmt.Skipped {
+		if s.Reason != skipped[i] {
+			t.Errorf("skip %d: %q, want %q", i, s.Reason, skipped[i])
+		}
+	}
+}
+
+func TestStatementTransactions(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	stmt := &Statement{
+		Lines: []StatementLine{
+			{Line: 1, Ref: "F1", Date: day, Amount: -42.5, Counterparty: "Coffee Shop"},
+			{Line: 2, Ref: "F2", Date: day, Amount: 1000.004},
+			{Line: 3, Ref: "F3", Date: day, Amount: 0},
+			{Line: 4, Ref: "F1", Date: day, Amount: -42.5, Counterparty: "Coffee Shop"},
+			{Line: 5, Date: day, Amount: 7, Memo: "no reference"},
+			{Line: 6, Date: day, Amount: 7, Memo: "no reference"},
+		},
+		Skipped: []ImportSkip{{Line: 7, Reason: `invalid TRNAMT "abc"`}},
+	}
+
+	txns, skipped := statementTransactions(stmt, "ACC-1", "")
+	want := []struct{ from, to string }{{"ACC-1", "Coffee Shop"}, {"UNKNOWN", "ACC-1"}, {"UNKNOWN", "ACC-1"}}
+	if len(txns) != len(want) {
+		t.Fatalf("%d transactions, want %d", len(txns), len(want))
+	}
+	for i, txn := range txns {
+		if txn.FromAccount != want[i].from || txn.ToAccount != want[i].to {
+			t.Errorf("transaction %d: %s -> %s, want %s -> %s", i, txn.FromAccount, txn.ToAccount, want[i].from, want[i].to)
+		}
+	}
+	if txns[1].Amount != 1000 {
+		t.Errorf("amount %v, want it rounded to 1000", txns[1].Amount)
+	}
+	wantSkips := []ImportSkip{
+		{Line: 7, Reason: `invalid TRNAMT "abc"`},
+		{Line: 3, Reason: "zero amount"},
+		{Line: 4, Reason: "repeated within file"},
+		{Line: 6, Reason: "repeated within file"},
+	}
+	if len(skipped) != len(wantSkips) {
+		t.Fatalf("skipped %+v, want %+v", skipped, wantSkips)
+	}
+	for i, s := range skipped {
+		if s != wantSkips[i] {
+			t.Errorf("skip %d: %+v, want %+v", i, s, wantSkips[i])
+		}
+	}
+
+	again, _ := statementTransactions(stmt, "ACC-1", "")
+	session, _ := statementTransactions(stmt, "ACC-1", "s1")
+	other, _ := statementTransactions(stmt, "ACC-1", "s2")
+	if again[0].ID != txns[0].ID {
+		t.Errorf("re-import got ID %s, want %s", again[0].ID, txns[0].ID)
+	}
+	if session[0].ID == txns[0].ID || session[0].ID == other[0].ID {
+		t.Errorf("sessions share transaction ID %s", session[0].ID)
+	}
+	if session[0].SessionID != "s1" {
+		t.Errorf("session %q, want s1", session[0].SessionID)
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	conditionReadiness = "readiness"
+	conditionPanicRate = "panic_rate"
+	conditionSLOBurn   = "slo_fast_burn"
+
+	// burnMinRequests keeps a handful of errors on an idle service from
+	// counting as a fast burn.
+	burnMinRequests = 20
+)
+
+var (
+	panicsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "payflow_panics_total",
+			Help: "Handler panics recovered by the HTTP server",
+		},
+	)
+
+	incidentsActive = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "payflow_incidents_active",
+			Help: "1 while an incident is open for the condition",
+		},
+		[]string{"condition"},
+	)
+)
+
+// Incident is a critical condition that has been reported to the on-call
+// provider and not yet resolved.
+type Incident struct {
+	Condition string    `json:"condition"`
+	DedupKey  string    `json:"dedup_key"`
+	Summary   string    `json:"summary"`
+	OpenedAt  time.Time `json:"opened_at"`
+}
+
+// minuteBucket holds request outcomes for one wall-clock minute.
+type minuteBucket struct {
+	minute int64
+	total  int
+	errors int
+	panics int
+}
+
+// IncidentNotifier watches for critical conditions and opens and resolves
+// incidents in PagerDuty or Opsgenie. Dedup keys are stable per condition, so
+// the provider folds repeated triggers into one incident. In dry-run mode the
+// events are logged instead of sent.
+type IncidentNotifier struct {
+	app      *App
+	provider string
+	key      string
+	apiURL   string
+	dryRun   bool
+	source   string
+	client   *http.Client
+
+	mu            sync.Mutex
+	buckets       [60]minuteBucket
+	notReadySince time.Time
+	active        map[string]*Incident
+}
+
+func newIncidentNotifier(app *App) *IncidentNotifier {
+	source, _ := os.Hostname()
+	return &IncidentNotifier{
+		app:      app,
+		provider: app.config.IncidentProvider,
+		key:      app.config.IncidentRoutingKey,
+		apiURL:   app.config.IncidentAPIURL,
+		dryRun:   app.config.IncidentDryRun,
+		source:   source,
+		// Both providers dedupe on the incident key, so retries are safe.
+		client: app.newOutboundClient("incident", outboundOptions{Timeout: 10 * time.Second, Retries: 2}),
+		active: map[string]*Incident{},
+	}
+}
+
+func (n *IncidentNotifier) bucket(now time.Time) *minuteBucket {
+	minute := now.Unix() / 60
+	b := &n.buckets[minute%int64(len(n.buckets))]
+	if b.minute != minute {
+		*b = minuteBucket{minute: minute}
+	}
+	return b
+}
+
+// Observe records the outcome of one HTTP request. Nil-safe so callers need
+// not check whether incident notification is enabled.
+func (n *IncidentNotifier) Observe(status int) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	b := n.bucket(time.Now())
+	b.total++
+	if status >= 500 {
+		b.errors++
+	}
+}
+
+func (n *IncidentNotifier) RecordPanic() {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	b := n.bucket(time.Now())
+	b.total++
+	b.errors++
+	b.panics++
+}
+
+// window sums the last minutes buckets, including the current one.
+func (n *IncidentNotifier) window(now time.Time, minutes int) minuteBucket {
+	var sum minuteBucket
+	current := now.Unix() / 60
+	for _, b := range n.buckets {
+		if b.minute > current-int64(minutes) && b.minute <= current {
+			sum.total += b.total
+			sum.errors += b.errors
+			sum.panics += b.panics
+		}
+	}
+	return sum
+}
+
+// burnRate is how many times faster than allowed the error budget is being
+// spent over w.
+func burnRate(w minuteBucket, target float64) float64 {
+	if w.total < burnMinRequests {
+		return 0
+	}
+	return (float64(w.errors) / float64(w.total)) / (1 - target)
+}
+
+func (n *IncidentNotifier) Active() []Incident {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	out := make([]Incident, 0, len(n.active))
+	for _, inc := range n.active {
+		out = append(out, *inc)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].OpenedAt.Before(out[j].OpenedAt) })
+	return out
+}
+
+// evaluate checks every condition once and triggers or resolves incidents.
+func (n *IncidentNotifier) evaluate() {
+	cfg := n.app.config
+	now := time.Now()
+
+	readyErr := n.app.readinessError(context.Background())
+
+	n.mu.Lock()
+	if readyErr == nil {
+		n.notReadySince = time.Time{}
+	} else if n.notReadySince.IsZero() {
+		n.notReadySince = now
+	}
+	notReadyFor := time.Duration(0)
+	if !n.notReadySince.IsZero() {
+		notReadyFor = now.Sub(n.notReadySince)
+	}
+	short, long := n.window(now, 5), n.window(now, 60)
+	n.mu.Unlock()
+
+	readinessLimit := time.Duration(cfg.IncidentReadinessMinutes) * time.Minute
+	n.set(conditionReadiness, readyErr != nil && notReadyFor >= readinessLimit, func() string {
+		return fmt.Sprintf("payflow readiness failing for %s: %v", notReadyFor.Round(time.Second), readyErr)
+	})
+
+	panicRate := float64(short.panics) / 5
+	n.set(conditionPanicRate, panicRate >= cfg.IncidentPanicsPerMin, func() string {
+		return fmt.Sprintf("payflow panic rate %.1f/min over 5m (threshold %.1f/min)", panicRate, cfg.IncidentPanicsPerMin)
+	})
+
+	shortBurn, longBurn := burnRate(short, cfg.IncidentSLOTarget), burnRate(long, cfg.IncidentSLOTarget)
+	n.set(conditionSLOBurn, shortBurn >= cfg.IncidentBurnRate && longBurn >= cfg.IncidentBurnRate, func() string {
+		return fmt.Sprintf("payflow error budget burning at %.1fx (5m) / %.1fx (1h) against a %.3f%% SLO",
+			shortBurn, longBurn, cfg.IncidentSLOTarget*100)
+	})
+}
+
+// set opens the incident for condition when firing and resolves it when not.
+// A failed send leaves the state unchanged so the next evaluation retries.
+func (n *IncidentNotifier) set(condition string, firing bool, summary func() string) {
+	n.mu.Lock()
+	inc, open := n.active[condition]
+	n.mu.Unlock()
+
+	switch {
+	case firing && !open:
+		inc = &Incident{Condition: condition, DedupKey: "payflow-" + condition, Summary: summary(), OpenedAt: time.Now().UTC()}
+		if err := n.send("trigger", inc); err != nil {
+			n.app.log("error", "Failed to open incident", map[string]interface{}{"condition": condition, "error": err.Error()})
+			return
+		}
+		n.mu.Lock()
+		n.active[condition] = inc
+		n.mu.Unlock()
+		incidentsActive.WithLabelValues(condition).Set(1)
+		n.app.event("error", EventIncidentOpened, inc.DedupKey, "Incident opened", map[string]interface{}{"condition": condition, "summary": inc.Summary, "dry_run": n.dryRun})
+	case !firing && open:
+		if err := n.send("resolve", inc); err != nil {
+			n.app.log("error", "Failed to resolve incident", map[string]interface{}{"condition": condition, "error": err.Error()})
+			return
+		}
+		n.mu.Lock()
+		delete(n.active, condition)
+		n.mu.Unlock()
+		incidentsActive.WithLabelValues(condition).Set(0)
+		n.app.event("info", EventIncidentResolved, inc.DedupKey, "Incident resolved", map[string]interface{}{"condition": condition, "dry_run": n.dryRun})
+	}
+}
+
+func (n *IncidentNotifier) send(action string, inc *Incident) error {
+	req, err := n.request(action, inc)
+	if err != nil {
+		return err
+	}
+	if n.dryRun {
+		n.app.log("info", "Incident event (dry run)", map[string]interface{}{
+			"provider":  n.provider,
+			"action":    action,
+			"dedup_key": inc.DedupKey,
+			"url":       req.URL.String(),
+			"summary":   inc.Summary,
+		})
+		return nil
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s responded %s", n.provider, resp.Status)
+	}
+	return nil
+}
+
+// request builds the provider API call: PagerDuty Events API v2 or the
+// Opsgenie Alert API, both keyed by the incident's dedup key.
+func (n *IncidentNotifier) request(action string, inc *Incident) (*http.Request, error) {
+	var endpoint string
+	var body interface{}
+	header := http.Header{"Content-Type": []string{"application/json"}}
+
+	switch n.provider {
+	case "pagerduty":
+		endpoint = "https://events.pagerduty.com/v2/enqueue"
+		if n.apiURL != "" {
+			endpoint = n.apiURL
+		}
+		event := map[string]interface{}{
+			"routing_key":  n.key,
+			"event_action": action,
+			"dedup_key":    inc.DedupKey,
+		}
+		if action == "trigger" {
+			event["payload"] = map[string]interface{}{
+				"summary":   inc.Summary,
+				"source":    n.source,
+				"severity":  "critical",
+				"component": "payflow-backend",
+				"class":     inc.Condition,
+			}
+		}
+		body = event
+	case "opsgenie":
+		base := "https://api.opsgenie.com"
+		if n.apiURL != "" {
+			base = n.apiURL
+		}
+		header.Set("Authorization", "GenieKey "+n.key)
+		if action == "trigger" {
+			endpoint = base + "/v2/alerts"
+			body = map[string]interface{}{
+				"message":  inc.Summary,
+				"alias":    inc.DedupKey,
+				"source":   n.source,
+				"priority": "P1",
+				"tags":     []string{"payflow", inc.Condition},
+			}
+		} else {
+			endpoint = base + "/v2/alerts/" + url.PathEscape(inc.DedupKey) + "/close?identifierType=alias"
+			body = map[string]interface{}{"source": n.source}
+		}
+	default:
+		return nil, fmt.Errorf("unknown incident provider %q", n.provider)
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+	return req, nil
+}
+
+// startIncidentNotifier starts condition checks when INCIDENT_PROVIDER is set.
+func (app *App) startIncidentNotifier() {
+	if app.config.IncidentProvider == "none" {
+		return
+	}
+	app.incidents = newIncidentNotifier(app)
+	app.log("info", "Incident notifier started", map[string]interface{}{
+		"provider": app.config.IncidentProvider,
+		"dry_run":  app.config.IncidentDryRun,
+	})
+
+	interval := time.Duration(app.config.IncidentCheckIntervalSec) * time.Second
+	go func() {
+		for {
+			time.Sleep(interval)
+			app.incidents.evaluate()
+		}
+	}()
+}
+
+// recoveryMiddleware is gin.Recovery plus panic accounting for the incident
+// notifier.
+func (app *App) recoveryMiddleware() gin.HandlerFunc {
+	return gin.CustomRecovery(func(c *gin.Context, err interface{}) {
+		panicsTotal.Inc()
+		app.incidents.RecordPanic()
+		c.AbortWithStatus(http.StatusInternalServerError)
+	})
+}
+
+func (app *App) listIncidentsHandler(c *gin.Context) {
+	if app.incidents == nil {
+		c.JSON(http.StatusOK, gin.H{"provider": "none", "incidents": []Incident{}})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"provider":  app.incidents.provider,
+		"dry_run":   app.incidents.dryRun,
+		"incidents": app.incidents.Active(),
+	})
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/segmentio/kafka-go"
+)
+
+var kafkaMessagesTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "payflow_kafka_messages_total",
+		Help: "Events handed to Kafka by topic and result (published, failed)",
+	},
+	[]string{"topic", "result"},
+)
+
+// kafkaPublisher keys each message by transaction ID, so all of one
+// transaction's events land on one partition in order. Lost events show up
+// in payflow_kafka_messages_total.
+type kafkaPublisher struct {
+	app    *App
+	writer *kafka.Writer
+	// relayWriter is synchronous, so the outbox relay knows what the
+	// brokers acknowledged before moving its cursor.
+	relayWriter *kafka.Writer
+
+	mu      sync.Mutex
+	lastErr error
+}
+
+// newKafkaPublisher publishes to KAFKA_BROKERS. Brokers are only dialed once
+// there is something to send, so an unreachable cluster doesn't hold up
+// startup.
+func newKafkaPublisher(app *App) *kafkaPublisher {
+	var brokers []string
+	for _, b := range strings.Split(app.config.KafkaBrokers, ",") {
+		if b = strings.TrimSpace(b); b != "" {
+			brokers = append(brokers, b)
+		}
+	}
+	p := &kafkaPublisher{app: app}
+	p.writer = &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		BatchTimeout: 50 * time.Millisecond,
+		WriteTimeout: 10 * time.Second,
+		Async:        true,
+		Completion:   p.completed,
+	}
+	p.relayWriter = &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		BatchTimeout: time.Millisecond,
+		WriteTimeout: 10 * time.Second,
+	}
+	app.log("info", "Kafka publishing enabled", map[string]interface{}{
+		"brokers":            brokers,
+		"transactions_topic": app.config.KafkaTopicTransactions,
+		"fraud_alerts_topic": app.config.KafkaTopicFraudAlerts,
+	})
+	return p
+}
+
+func (p *kafkaPublisher) PublishTransaction(ctx context.Context, txn Transaction) {
+	p.publish(ctx, p.app.config.KafkaTopicTransactions, StreamTransactionCreated, txn.ID, txn)
+}
+
+func (p *kafkaPublisher) PublishFraudAlert(ctx context.Context, txn Transaction, a FraudAssessment) {
+	p.publish(ctx, p.app.config.KafkaTopicFraudAlerts, StreamFraudAlertRaised, txn.ID, fraudAlertData(txn, a))
+}
+
+func (p *kafkaPublisher) topic(eventType string) string {
+	if eventType == StreamFraudAlertRaised {
+		return p.app.config.KafkaTopicFraudAlerts
+	}
+	return p.app.config.KafkaTopicTransactions
+}
+
+// send writes events as one batch and waits for the brokers. The batch is
+// all or nothing for the relay: after a failure it is sent again whole,
+// and consumers drop the duplicates by event ID.
+func (p *kafkaPublisher) send(ctx context.Context, events []outboxEvent) (int, error) {
+	msgs := make([]kafka.Message, len(events))
+	for i, e := range events {
+		msgs[i] = kafka.Message{
+			Topic:   p.topic(e.eventType),
+			Key:     []byte(e.key),
+			Value:   e.payload,
+			Headers: []kafka.Header{{Key: "event_type", Value: []byte(e.eventType)}},
+		}
+	}
+	err := p.relayWriter.WriteMessages(ctx, msgs...)
+	p.completed(msgs, err)
+	if err != nil {
+		return 0, err
+	}
+	return len(events), nil
+}
+
+func (p *kafkaPublisher) publish(ctx context.Context, topic, eventType, key string, data interface{}) {
+	_, value, ok := p.app.encodeStreamEvent(ctx, eventType, data)
+	if !ok {
+		return
+	}
+	// The writer is async, so this only queues the message; delivery is
+	// reported to completed.
+	err := p.writer.WriteMessages(ctx, kafka.Message{
+		Topic:   topic,
+		Key:     []byte(key),
+		Value:   value,
+		Headers: []kafka.Header{{Key: "event_type", Value: []byte(eventType)}},
+	})
+	if err != nil {
+		kafkaMessagesTotal.WithLabelValues(topic, "failed").Inc()
+		p.app.logCtx(ctx, "warn", "Failed to queue Kafka event", map[string]interface{}{"topic": topic, "event_type": eventType, "error": err.Error()})
+	}
+}
+
+// completed is called with each batch once the writer has given up retrying
+// or the brokers have acknowledged it.
+func (p *kafkaPublisher) completed(msgs []kafka.Message, err error) {
+	result := "published"
+	if err != nil {
+		result = "failed"
+	}
+	p.mu.Lock()
+	p.lastErr = err
+	p.mu.Unlock()
+	counts := map[string]int{}
+	for _, m := range msgs {
+		counts[m.Topic]++
+	}
+	for topic, n := range counts {
+		kafkaMessagesTotal.WithLabelValues(topic, result).Add(float64(n))
+	}
+	if err != nil {
+		p.app.log("warn", "Kafka publish failed", map[string]interface{}{"messages": len(msgs), "error": err.Error()})
+	}
+}
+
+// Health returns the error of the last batch if it failed. Since brokers are
+// only dialed once there is something to send, a publisher that hasn't sent
+// anything yet is healthy.
+func (p *kafkaPublisher) Health() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastErr
+}
+
+// Close flushes queued messages and waits for their batches to complete.
+func (p *kafkaPublisher) Close() error {
+	p.relayWriter.Close()
+	return p.writer.Close()
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LatencyBreakdown splits a request's wall time between the dependencies it
+// waited on. Fraud and serialization exclude DB and Redis time spent inside
+// them, so the parts never count the same time twice; Other is whatever is
+// left, mostly handler code and middleware.
+type LatencyBreakdown struct {
+	TotalMs         float64 `json:"total_ms"`
+	DBMs            float64 `json:"db_ms"`
+	RedisMs         float64 `json:"redis_ms"`
+	FraudMs         float64 `json:"fraud_ms"`
+	SerializationMs float64 `json:"serialization_ms"`
+	OtherMs         float64 `json:"other_ms"`
+}
+
+func (b *LatencyBreakdown) add(o LatencyBreakdown) {
+	b.TotalMs += o.TotalMs
+	b.DBMs += o.DBMs
+	b.RedisMs += o.RedisMs
+	b.FraudMs += o.FraudMs
+	b.SerializationMs += o.SerializationMs
+	b.OtherMs += o.OtherMs
+}
+
+func (b LatencyBreakdown) scaled(f float64) LatencyBreakdown {
+	return LatencyBreakdown{
+		TotalMs:         b.TotalMs * f,
+		DBMs:            b.DBMs * f,
+		RedisMs:         b.RedisMs * f,
+		FraudMs:         b.FraudMs * f,
+		SerializationMs: b.SerializationMs * f,
+		OtherMs:         b.OtherMs * f,
+	}
+}
+
+func nanosToMs(n int64) float64 {
+	return float64(n) / float64(time.Millisecond)
+}
+
+// breakdown splits total, the request's wall time, by what m recorded.
+// Dependencies called concurrently can add up to more than total; Other is
+// then zero rather than negative.
+func (m *requestCost) breakdown(total time.Duration) LatencyBreakdown {
+	b := LatencyBreakdown{
+		TotalMs:         nanosToMs(int64(total)),
+		DBMs:            nanosToMs(atomic.LoadInt64(&m.dbNanos)),
+		RedisMs:         nanosToMs(atomic.LoadInt64(&m.redisNanos)),
+		FraudMs:         nanosToMs(atomic.LoadInt64(&m.fraudNanos)),
+		SerializationMs: nanosToMs(atomic.LoadInt64(&m.serializeNanos)),
+	}
+	if other := b.TotalMs - b.DBMs - b.RedisMs - b.FraudMs - b.SerializationMs; other > 0 {
+		b.OtherMs = other
+	}
+	return b
+}
+
+// latencyMark is a point in a request to measure exclusive time from: wall
+// time since then, less the DB and Redis time charged meanwhile.
+type latencyMark struct {
+	m     *requestCost
+	start time.Time
+	deps  int64
+}
+
+func (m *requestCost) dependencyNanos() int64 {
+	return atomic.LoadInt64(&m.dbNanos) + atomic.LoadInt64(&m.redisNanos)
+}
+
+// mark is nil-safe, so callers can time work that may run outside a request.
+func (m *requestCost) mark() latencyMark {
+	if m == nil {
+		return latencyMark{}
+	}
+	return latencyMark{m: m, start: time.Now(), deps: m.dependencyNanos()}
+}
+
+func (l latencyMark) exclusive() int64 {
+	d := int64(time.Since(l.start)) - (l.m.dependencyNanos() - l.deps)
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// meterFraud charges fraud rule evaluation since l to its request.
+func meterFraud(l latencyMark) {
+	if l.m != nil {
+		atomic.AddInt64(&l.m.fraudNanos, l.exclusive())
+	}
+}
+
+// latencyWriter times serialization: from the handler setting the status,
+// which c.JSON does right before encoding, to the end of each body write.
+type latencyWriter struct {
+	gin.ResponseWriter
+	m       *requestCost
+	pending latencyMark
+}
+
+func (w *latencyWriter) WriteHeader(code int) {
+	if w.pending.m == nil {
+		w.pending = w.m.mark()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *latencyWriter) Write(b []byte) (int, error) {
+	if w.pending.m == nil {
+		w.pending = w.m.mark()
+	}
+	n, err := w.ResponseWriter.Write(b)
+	atomic.AddInt64(&w.m.serializeNanos, w.pending.exclusive())
+	w.pending = w.m.mark()
+	return n, err
+}
+
+func (w *latencyWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// LatencyTotals aggregates breakdowns for a route. Share is each part's
+// fraction of the summed total.
+type LatencyTotals struct {
+	Key      string             `json:"key"`
+	Requests int64              `json:"requests"`
+	Total    LatencyBreakdown   `json:"total"`
+	Average  LatencyBreakdown   `json:"average"`
+	Share    map[string]float64 `json:"share"`
+}
+
+// LatencyTracker keeps in-memory breakdown aggregates per route, and for
+// background fraud analysis, since start or the last reset.
+type LatencyTracker struct {
+	mu         sync.Mutex
+	since      time.Time
+	routes     map[string]*LatencyTotals
+	background map[string]*LatencyTotals
+}
+
+func newLatencyTracker() *LatencyTracker {
+	t := &LatencyTracker{}
+	t.reset()
+	return t
+}
+
+func (t *LatencyTracker) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.since = time.Now().UTC()
+	t.routes = map[string]*LatencyTotals{}
+	t.background = map[string]*LatencyTotals{}
+}
+
+func (t *LatencyTracker) record(m map[string]*LatencyTotals, key string, b LatencyBreakdown) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	agg, ok := m[key]
+	if !ok {
+		agg = &LatencyTotals{Key: key}
+		m[key] = agg
+	}
+	agg.Requests++
+	agg.Total.add(b)
+}
+
+func (t *LatencyTracker) recordRoute(route string, b LatencyBreakdown) {
+	t.record(t.routes, route, b)
+}
+
+func (t *LatencyTracker) recordBackground(task string, b LatencyBreakdown) {
+	t.record(t.background, task, b)
+}
+
+func sortedLatencies(m map[string]*LatencyTotals) []LatencyTotals {
+	out := make([]LatencyTotals, 0, len(m))
+	for _, t := range m {
+		agg := *t
+		agg.Average = agg.Total.scaled(1 / float64(agg.Requests))
+		agg.Share = map[string]float64{}
+		if agg.Total.TotalMs > 0 {
+			for part, ms := range map[string]float64{
+				"db":            agg.Total.DBMs,
+				"redis":         agg.Total.RedisMs,
+				"fraud":         agg.Total.FraudMs,
+				"serialization": agg.Total.SerializationMs,
+				"other":         agg.Total.OtherMs,
+			} {
+				agg.Share[part] = ms / agg.Total.TotalMs
+			}
+		}
+		out = append(out, agg)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Total.TotalMs > out[j].Total.TotalMs })
+	return out
+}
+
+// latencyMiddleware records where each request spent its time: in its log
+// line, in its transaction capture if it has one, and in the per-route
+// aggregates. It must run after costMiddleware, whose accumulator it reads.
+func (app *App) latencyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		m := requestCostFrom(c.Request.Context())
+		if m == nil {
+			c.Next()
+			return
+		}
+		start := time.Now()
+		c.Writer = &latencyWriter{ResponseWriter: c.Writer, m: m}
+
+		c.Next()
+
+		total := time.Since(start)
+		b := m.breakdown(total)
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		app.latency.recordRoute(c.Request.Method+" "+route, b)
+
+		ctx := c.Request.Context()
+		if t := captureFrom(ctx); t != nil {
+			t.mu.Lock()
+			t.Timings.Latency = &b
+			t.mu.Unlock()
+		}
+		fields := map[string]interface{}{
+			"method":  c.Request.Method,
+			"route":   route,
+			"status":  c.Writer.Status(),
+			"latency": b,
+		}
+		if limit := app.config.LatencyLogThresholdMs; limit > 0 && total >= time.Duration(limit)*time.Millisecond {
+			app.logCtx(ctx, "info", "Slow request", fields)
+		} else {
+			app.debug(ctx, "Request latency", fields)
+		}
+	}
+}
+
+// withLatency gives background work its own accumulator, so dependencies
+// called with the returned context are timed like a request's.
+func withLatency(ctx context.Context) (context.Context, *requestCost) {
+	m := &requestCost{}
+	return context.WithValue(ctx, requestCostKey{}, m), m
+}
+
+func (app *App) getLatencyBreakdownHandler(c *gin.Context) {
+	t := app.latency
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c.JSON(http.StatusOK, gin.H{
+		"since":      t.since,
+		"routes":     sortedLatencies(t.routes),
+		"background": sortedLatencies(t.background),
+	})
+}
+
+func (app *App) resetLatencyBreakdownHandler(c *gin.Context) {
+	app.latency.reset()
+	app.logCtx(c.Request.Context(), "info", "Latency breakdown reset", map[string]interface{}{"actor": adminActor(c)})
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// ledgerLockID is the advisory lock key that serializes appends to the
+// transaction hash chain across replicas.
+const ledgerLockID = 716_2024
+
+// sessionLockClass keys, with a hash of the session ID, the advisory lock
+// that serializes balance postings within one demo session.
+const sessionLockClass = 716_2025
+
+// chainTimeLayout matches the microsecond precision Postgres keeps for
+// TIMESTAMP columns so hashes survive a round trip through the database.
+const chainTimeLayout = "2006-01-02T15:04:05.000000"
+
+// ledgerChainVersion is the hash format rows are sealed with. Version 1 rows
+// hashed the parties and description into the chain with everything else;
+// version 2 rows, sealed before statusHash, have no status seal.
+const ledgerChainVersion = 3
+
+// ledgerHash returns the hash every ledger seal is computed with. When
+// LEDGER_SIGNING_KEY is set it is an HMAC, so records can't be re-chained by
+// someone with only database access.
+func (app *App) ledgerHash() hash.Hash {
+	if app.config.LedgerSigningKey != "" {
+		return hmac.New(sha256.New, []byte(app.config.LedgerSigningKey))
+	}
+	return sha256.New()
+}
+
+// transactionHash returns the chain hash for txn given the previous link. It
+// covers what a data subject erasure leaves alone, the ID, amount and
+// timestamp; contentHash and statusHash seal the rest against the chain hash.
+func (app *App) transactionHash(txn Transaction, prevHash string) string {
+	return app.chainHash(ledgerChainVersion, txn, prevHash)
+}
+
+// chainHash is transactionHash as rows of version 2 and up were sealed. The
+// version is hashed too, so a row can't be passed off as an older version
+// with less sealed.
+func (app *App) chainHash(version int, txn Transaction, prevHash string) string {
+	h := app.ledgerHash()
+	fmt.Fprintf(h, "v%d|%s|%s|%.2f|%s",
+		version,
+		prevHash,
+		txn.ID,
+		txn.Amount,
+		txn.CreatedAt.Format(chainTimeLayout),
+	)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// contentHash seals the parties and description of txn, already chained as
+// txn.Hash. Erasure clears it along with the content.
+func (app *App) contentHash(txn Transaction) string {
+	h := app.ledgerHash()
+	fmt.Fprintf(h, "%s|%s|%s|%s", txn.Hash, txn.FromAccount, txn.ToAccount, txn.Description)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// statusHash seals the status of txn, already chained as txn.Hash. Status
+// changes after sealing, so every change made to a sealed transaction goes
+// through resealStatus.
+func (app *App) statusHash(txn Transaction) string {
+	h := app.ledgerHash()
+	fmt.Fprintf(h, "%s|%s", txn.Hash, txn.Status)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// resealStatus seals the current status of the transactions among ids that
+// are in the chain. Callers run it inside tx right after changing their
+// status.
+func (app *App) resealStatus(ctx context.Context, tx *sql.Tx, ids ...string) error {
+	rows, err := tx.QueryContext(ctx, `SELECT id, hash, status FROM transactions WHERE id = ANY($1) AND hash IS NOT NULL`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	var sealed []Transaction
+	for rows.Next() {
+		var t Transaction
+		if err := rows.Scan(&t.ID, &t.Hash, &t.Status); err != nil {
+			rows.Close()
+			return err
+		}
+		sealed = append(sealed, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, t := range sealed {
+		if _, err := tx.ExecContext(ctx, `UPDATE transactions SET status_hash = $2 WHERE id = $1`, t.ID, app.statusHash(t)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// legacyTransactionHash is the chain hash of version 1 rows, over all of
+// their content at once.
+func (app *App) legacyTransactionHash(txn Transaction, prevHash string) string {
+	h := app.ledgerHash()
+	fmt.Fprintf(h, "%s|%s|%s|%s|%.2f|%s|%s|%s",
+		prevHash,
+		txn.ID,
+		txn.FromAccount,
+		txn.ToAccount,
+		txn.Amount,
+		txn.Description,
+		txn.Status,
+		txn.CreatedAt.Format(chainTimeLayout),
+	)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ledgerSeal holds the hashes stored next to a sealed transaction's chain
+// hash.
+type ledgerSeal struct {
+	ContentHash string
+	StatusHash  string
+}
+
+// sealTransaction links txn to the current chain head inside tx and returns
+// the rest of its seal. The caller must hold the ledger advisory lock for the
+// lifetime of tx.
+func (app *App) sealTransaction(ctx context.Context, tx *sql.Tx, txn *Transaction) (ledgerSeal, error) {
+	var prev sql.NullString
+	err := tx.QueryRowContext(ctx, `SELECT hash FROM transactions WHERE hash IS NOT NULL ORDER BY chain_seq DESC LIMIT 1`).Scan(&prev)
+	if err != nil && err != sql.ErrNoRows {
+		return ledgerSeal{}, fmt.Errorf("failed to read chain head: %w", err)
+	}
+	txn.PrevHash = prev.String
+	txn.Hash = app.transactionHash(*txn, txn.PrevHash)
+	return ledgerSeal{ContentHash: app.contentHash(*txn), StatusHash: app.statusHash(*txn)}, nil
+}
+
+// LedgerBreak describes the first row whose stored hash doesn't match.
+type LedgerBreak struct {
+	TransactionID string `json:"transaction_id"`
+	ChainSeq      int64  `json:"chain_seq"`
+	Reason        string `json:"reason"`
+}
+
+// LedgerReport is the result of walking the hash chain. Erased rows had
+// their parties and description checked only until erasure; Legacy counts
+// rows sealed before version 3, whose status isn't sealed and, for version 1
+// rows that were erased, whose content can only be checked for its place in
+// the chain.
+type LedgerReport struct {
+	Valid    bool         `json:"valid"`
+	Verified int          `json:"verified"`
+	Unsealed int          `json:"unsealed"`
+	Erased   int          `json:"erased"`
+	Legacy   int          `json:"legacy"`
+	Head     string       `json:"head,omitempty"`
+	Break    *LedgerBreak `json:"break,omitempty"`
+}
+
+// ledgerRow is a sealed transaction as verifyLedger reads it.
+type ledgerRow struct {
+	Seq         int64
+	Txn         Transaction
+	Version     int
+	ContentHash sql.NullString
+	StatusHash  sql.NullString
+	Erased      bool
+}
+
+// checkLedgerRow returns why row breaks the chain after prev, or "" when it
+// doesn't, and counts it in report. Versions only go up along the chain.
+func (app *App) checkLedgerRow(report *LedgerReport, row ledgerRow, prev ledgerRow) string {
+	t := row.Txn
+	if t.PrevHash != prev.Txn.Hash {
+		return "prev_hash does not match the preceding record"
+	}
+	if row.Version < prev.Version || row.Version > ledgerChainVersion {
+		return "chain_version does not follow the preceding record"
+	}
+	if row.Erased {
+		report.Erased++
+	}
+	if row.Version < ledgerChainVersion {
+		report.Legacy++
+	}
+	if row.Version < 2 {
+		// A version 1 row's content is all in its chain hash, which
+		// erasure invalidated on purpose.
+		if !row.Erased && app.legacyTransactionHash(t, t.PrevHash) != t.Hash {
+			return "content does not match stored hash"
+		}
+		return ""
+	}
+	if app.chainHash(row.Version, t, t.PrevHash) != t.Hash {
+		return "amount or timestamp does not match stored hash"
+	}
+	if !row.Erased && (!row.ContentHash.Valid || app.contentHash(t) != row.ContentHash.String) {
+		return "content does not match stored hash"
+	}
+	if row.Version >= 3 && (!row.StatusHash.Valid || app.statusHash(t) != row.StatusHash.String) {
+		return "status does not match stored hash"
+	}
+	return ""
+}
+
+func (app *App) verifyLedger(ctx context.Context) (*LedgerReport, error) {
+	report := &LedgerReport{Valid: true}
+
+	if err := app.readPool().QueryRowContext(ctx, `SELECT COUNT(*) FROM transactions WHERE hash IS NULL AND session_id IS NULL`).Scan(&report.Unsealed); err != nil {
+		return nil, err
+	}
+
+	rows, err := app.readPool().QueryContext(ctx, `
+		SELECT chain_seq, id, from_account, to_account, amount, description, status, created_at, prev_hash, hash,
+			chain_version, content_hash, status_hash, erased_at IS NOT NULL
+		FROM transactions
+		WHERE hash IS NOT NULL
+		ORDER BY chain_seq
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var prev ledgerRow
+	for rows.Next() {
+		var row ledgerRow
+		t := &row.Txn
+		if err := rows.Scan(&row.Seq, &t.ID, &t.FromAccount, &t.ToAccount, &t.Amount, &t.Description, &t.Status, &t.CreatedAt, &t.PrevHash, &t.Hash,
+			&row.Version, &row.ContentHash, &row.StatusHash, &row.Erased); err != nil {
+			return nil, err
+		}
+
+		if reason := app.checkLedgerRow(report, row, prev); reason != "" {
+			report.Valid = false
+			report.Break = &LedgerBreak{TransactionID: t.ID, ChainSeq: row.Seq, Reason: reason}
+			break
+		}
+
+		report.Verified++
+		prev = row
+		report.Head = t.Hash
+	}
+	return report, rows.Err()
+}
+
+func (app *App) verifyLedgerHandler(c *gin.Context) {
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	report, err := app.verifyLedger(c.Request.Context())
+	if err != nil {
+		app.logCtx(c.Request.Context(), "error", "Ledger verification failed", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !report.Valid {
+		app.eventCtx(c.Request.Context(), "error", EventLedgerTampered, report.Break.TransactionID, "Ledger tampering detected", map[string]interface{}{
+			"chain_seq": report.Break.ChainSeq,
+			"reason":    report.Break.Reason,
+		})
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+// sealedRows chains txns the way sealTransaction does.
+func sealedRows(app *App, txns ...Transaction) []ledgerRow {
+	var rows []ledgerRow
+	prev := ""
+	for i, t := range txns {
+		t.PrevHash = prev
+		t.Hash = app.transactionHash(t, prev)
+		rows = append(rows, ledgerRow{Seq: int64(i + 1), Txn: t, Version: ledgerChainVersion, ContentHash: sql.NullString{String: app.contentHash(t), Valid: true},
+			StatusHash: sql.NullString{String: app.statusHash(t), Valid: true}})
+		prev = t.Hash
+	}
+	return rows
+}
+
+// checkLedger walks rows like verifyLedger and returns the first break.
+func checkLedger(app *App, rows []ledgerRow) (*LedgerReport, string) {
+	report := &LedgerReport{Valid: true}
+	var prev ledgerRow
+	for _, row := range rows {
+		if reason := app.checkLedgerRow(report, row, prev); reason != "" {
+			return report, reason
+		}
+		prev = row
+	}
+	return report, ""
+}
+
+// erase rewrites row the way eraseAccount does.
+func erase(row *ledgerRow) {
+	row.Txn.FromAccount = "erased-0123456789abcdef"
+	row.Txn.Description = ""
+	row.ContentHash = sql.NullString{}
+	row.Erased = true
+}
+
+func TestLedgerVerifiesErasedRows(t *testing.T) {
+	app := newTestApp(t, func(c *Config) { c.LedgerSigningKey = "ledger-key" })
+	created := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	txns := []Transaction{
+		{ID: "txn-1", FromAccount: "ACC-1", ToAccount: "ACC-2", Amount: 10, Description: "rent", Status: "success", CreatedAt: created},
+		{ID: "txn-2", FromAccount: "ACC-3", ToAccount: "ACC-2", Amount: 25.5, Description: "gift", Status: "success", CreatedAt: created.Add(time.Minute)},
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(rows []ledgerRow)
+		want   string
+	}{
+		{"untouched", func([]ledgerRow) {}, ""},
+		{"erased", func(rows []ledgerRow) { erase(&rows[1]) }, ""},
+		{"edited description", func(rows []ledgerRow) { rows[0].Txn.Description = "refund" }, "content does not match stored hash"},
+		{"content hash removed", func(rows []ledgerRow) { rows[0].ContentHash = sql.NullString{} }, "content does not match stored hash"},
+		{"erased then amount edited", func(rows []ledgerRow) {
+			erase(&rows[1])
+			rows[1].Txn.Amount = 2550
+		}, "amount or timestamp does not match stored hash"},
+		{"erasure faked to hide an amount edit", func(rows []ledgerRow) {
+			rows[1].Txn.Amount = 2550
+			rows[1].Erased = true
+		}, "amount or timestamp does not match stored hash"},
+		{"status edited", func(rows []ledgerRow) { rows[0].Txn.Status = "refunded" }, "status does not match stored hash"},
+		{"status edited on an erased row", func(rows []ledgerRow) {
+			erase(&rows[1])
+			rows[1].Txn.Status = "voided"
+		}, "status does not match stored hash"},
+		{"status seal removed", func(rows []ledgerRow) { rows[1].StatusHash = sql.NullString{} }, "status does not match stored hash"},
+		{"status seal moved from another row", func(rows []ledgerRow) { rows[1].StatusHash = rows[0].StatusHash }, "status does not match stored hash"},
+		{"passed off as version 2", func(rows []ledgerRow) {
+			rows[1].Txn.Status = "voided"
+			rows[1].Version, rows[1].StatusHash = 2, sql.NullString{}
+		}, "chain_version does not follow the preceding record"},
+		{"all passed off as version 2", func(rows []ledgerRow) {
+			for i := range rows {
+				rows[i].Version = 2
+			}
+		}, "amount or timestamp does not match stored hash"},
+		{"erased then backdated", func(rows []ledgerRow) {
+			erase(&rows[0])
+			rows[0].Txn.CreatedAt = created.Add(-24 * time.Hour)
+		}, "amount or timestamp does not match stored hash"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows := sealedRows(app, txns...)
+			tt.tamper(rows)
+			if _, reason := checkLedger(app, rows); reason != tt.want {
+				t.Errorf("break %q, want %q", reason, tt.want)
+			}
+		})
+	}
+}
+
+func TestLedgerVerifiesLegacyRows(t *testing.T) {
+	app := newTestApp(t, nil)
+	txn := Transaction{ID: "txn-1", FromAccount: "ACC-1", ToAccount: "ACC-2", Amount: 10, Status: "success", CreatedAt: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)}
+	txn.Hash = app.legacyTransactionHash(txn, "")
+	row := ledgerRow{Seq: 1, Txn: txn, Version: 1}
+
+	report, reason := checkLedger(app, []ledgerRow{row})
+	if reason != "" || report.Legacy != 1 {
+		t.Errorf("legacy row: break %q, legacy %d", reason, report.Legacy)
+	}
+	for name, edit := range map[string]func(*Transaction){
+		"amount": func(t *Transaction) { t.Amount = 1000 },
+		"status": func(t *Transaction) { t.Status = "voided" },
+	} {
+		edited := row
+		edit(&edited.Txn)
+		if _, reason := checkLedger(app, []ledgerRow{edited}); reason != "content does not match stored hash" {
+			t.Errorf("legacy row with %s edited: break %q", name, reason)
+		}
+	}
+}
+
+// Status changes made through resealStatus keep the row valid.
+func TestLedgerAcceptsResealedStatus(t *testing.T) {
+	app := newTestApp(t, nil)
+	rows := sealedRows(app, Transaction{ID: "txn-1", Amount: 10, Status: "success", CreatedAt: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)})
+	rows[0].Txn.Status = "refunded"
+	rows[0].StatusHash = sql.NullString{String: app.statusHash(rows[0].Txn), Valid: true}
+	if _, reason := checkLedger(app, rows); reason != "" {
+		t.Errorf("resealed status: break %q", reason)
+	}
+
+	// Version 2 rows predate the status seal.
+	rows[0].Version, rows[0].StatusHash = 2, sql.NullString{}
+	rows[0].Txn.Hash = app.chainHash(2, rows[0].Txn, "")
+	rows[0].ContentHash = sql.NullString{String: app.contentHash(rows[0].Txn), Valid: true}
+	if report, reason := checkLedger(app, rows); reason != "" || report.Legacy != 1 {
+		t.Errorf("version 2 row: break %q, legacy %d", reason, report.Legacy)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var logLevels = map[string]int{"debug": 0, "info": 1, "warn": 2, "error": 3}
+
+// LogSink renders log entries that passed the level filter.
+type LogSink interface {
+	Write(entry StructuredLog)
+}
+
+// jsonSink writes one JSON object per line, the format log shippers and the
+// demo analyzer parse.
+type jsonSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *jsonSink) Write(entry StructuredLog) {
+	line, _ := json.Marshal(entry)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.w.Write(append(line, '\n'))
+}
+
+// textSink writes a human-readable line for local development:
+// "<time> <LEVEL> [<trace>] <message> key=value ...".
+type textSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *textSink) Write(entry StructuredLog) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %-5s [%s] %s", entry.Timestamp, strings.ToUpper(entry.Level), entry.TraceID, entry.Message)
+	if entry.EventType != "" {
+		fmt.Fprintf(&b, " event=%s", entry.EventType)
+	}
+	if entry.EntityID != "" {
+		fmt.Fprintf(&b, " entity=%s", entry.EntityID)
+	}
+	keys := make([]string, 0, len(entry.Attributes))
+	for k := range entry.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v, _ := json.Marshal(entry.Attributes[k])
+		fmt.Fprintf(&b, " %s=%s", k, v)
+	}
+	if entry.Data != nil {
+		v, _ := json.Marshal(entry.Data)
+		fmt.Fprintf(&b, " data=%s", v)
+	}
+	b.WriteByte('\n')
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	io.WriteString(s.w, b.String())
+}
+
+// Logger drops entries below its level and hands the rest to its sink.
+type Logger struct {
+	level int32 // atomic; SIGHUP may change it
+	sink  LogSink
+}
+
+// newLogger returns a logger for LOG_LEVEL and LOG_FORMAT writing to w.
+func newLogger(level, format string, w io.Writer) *Logger {
+	l := &Logger{sink: &jsonSink{w: w}}
+	if !l.SetLevel(level) {
+		l.SetLevel("info")
+	}
+	if format == "text" {
+		l.sink = &textSink{w: w}
+	}
+	return l
+}
+
+// defaultLogger serves log calls made before the configuration is loaded.
+var defaultLogger = newLogger("info", "json", os.Stdout)
+
+// Enabled reports whether entries at level are written. Unknown levels are
+// always written so nothing is lost to a typo.
+func (l *Logger) Enabled(level string) bool {
+	n, ok := logLevels[level]
+	return !ok || int32(n) >= atomic.LoadInt32(&l.level)
+}
+
+// SetLevel changes the minimum level, reporting false for an unknown one.
+func (l *Logger) SetLevel(level string) bool {
+	n, ok := logLevels[level]
+	if ok {
+		atomic.StoreInt32(&l.level, int32(n))
+	}
+	return ok
+}
+
+// output writes entry regardless of level.
+func (l *Logger) output(entry StructuredLog) {
+	entry.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	entry.Service = "payflow-api"
+	l.sink.Write(entry)
+}
+
+func (app *App) logger() *Logger {
+	if app.logs != nil {
+		return app.logs
+	}
+	return defaultLogger
+}
+
+// debugEnabled reports whether debug lines for the request behind ctx are
+// written: LOG_LEVEL is debug, or the request was picked for debug logging.
+func (app *App) debugEnabled(ctx context.Context) bool {
+	if app.logger().Enabled("debug") {
+		return true
+	}
+	t := logTraceFrom(ctx)
+	return t != nil && t.Debug
+}
+
+// logQuery logs an SQL statement at debug level. The metered driver calls it
+// after every statement, so check debugEnabled before doing any work.
+func (app *App) logQuery(ctx context.Context, query string, start time.Time, err error) {
+	if t := captureFrom(ctx); t != nil {
+		t.query(query, start, err)
+	}
+	if !app.debugEnabled(ctx) {
+		return
+	}
+	data := map[string]interface{}{
+		"query":       strings.Join(strings.Fields(query), " "),
+		"duration_ms": float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		data["error"] = err.Error()
+	}
+	app.writeDebug(ctx, "SQL statement", data)
+}
+
+// redisDebugHook logs Redis commands at debug level. Only the command and
+// key are logged, never values: those hold cached responses.
+type redisDebugHook struct {
+	app *App
+}
+
+func redisCommandData(cmd redis.Cmder) map[string]interface{} {
+	data := map[string]interface{}{"command": cmd.Name()}
+	if args := cmd.Args(); len(args) > 1 {
+		data["key"] = fmt.Sprint(args[1])
+	}
+	switch err := cmd.Err(); {
+	case err == redis.Nil:
+		data["result"] = "nil"
+	case err != nil:
+		data["error"] = err.Error()
+	}
+	return data
+}
+
+func (h redisDebugHook) BeforeProcess(ctx context.Context, _ redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h redisDebugHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	if t := captureFrom(ctx); t != nil {
+		t.cache(redisCommandData(cmd))
+	}
+	if h.app.debugEnabled(ctx) {
+		h.app.writeDebug(ctx, "Redis command", redisCommandData(cmd))
+	}
+	return nil
+}
+
+func (h redisDebugHook) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h redisDebugHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	if t := captureFrom(ctx); t != nil {
+		for _, cmd := range cmds {
+			t.cache(redisCommandData(cmd))
+		}
+	}
+	if h.app.debugEnabled(ctx) {
+		for _, cmd := range cmds {
+			h.app.writeDebug(ctx, "Redis command", redisCommandData(cmd))
+		}
+	}
+	return nil
+}
@@ -3,16 +3,18 @@ package main
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"maps"
 	"math/rand"
 	"net/http"
 	"os"
 	"os/signal"
 	"runtime"
-	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -20,196 +22,133 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/infrasage/payflow/internal/config"
+	apihttp "github.com/infrasage/payflow/internal/http"
+	"github.com/infrasage/payflow/internal/store"
 	_ "github.com/lib/pq"
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-// Config holds all configuration
-type Config struct {
-	Port           string
-	PostgresHost   string
-	PostgresPort   string
-	PostgresUser   string
-	PostgresPass   string
-	PostgresDB     string
-	RedisHost      string
-	RedisPort      string
-	CacheMaxSize   string
-	CacheTTL       int
-	DBPoolSize     int
-	RateLimitRPS   int
-	LogLevel       string
-	FeatureNewCache bool
-	// Bug injection
-	InjectOOM       bool
-	InjectLatencyMs int
-	InjectErrorRate float64
-	InjectCPUBurn   bool
-	InjectPanic     bool
-	InjectDBTimeout bool
-}
-
-// Metrics
+// Build information, set at build time with
+// -ldflags "-X main.appVersion=... -X main.gitSHA=... -X main.buildTime=...".
 var (
-	transactionsTotal = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Name: "payflow_transactions_total",
-			Help: "Total number of transactions",
-		},
-		[]string{"status"},
-	)
-	transactionDuration = prometheus.NewHistogramVec(
-		prometheus.HistogramOpts{
-			Name:    "payflow_transaction_duration_seconds",
-			Help:    "Transaction duration in seconds",
-			Buckets: prometheus.DefBuckets,
-		},
-		[]string{"endpoint"},
-	)
-	cacheHitRatio = prometheus.NewGauge(
-		prometheus.GaugeOpts{
-			Name: "payflow_cache_hit_ratio",
-			Help: "Cache hit ratio",
-		},
-	)
-	dbConnectionsActive = prometheus.NewGauge(
-		prometheus.GaugeOpts{
-			Name: "payflow_db_connections_active",
-			Help: "Number of active database connections",
-		},
-	)
-	memoryUsedBytes = prometheus.NewGauge(
-		prometheus.GaugeOpts{
-			Name: "payflow_memory_used_bytes",
-			Help: "Memory used in bytes",
-		},
-	)
-	requestsInFlight = prometheus.NewGauge(
-		prometheus.GaugeOpts{
-			Name: "payflow_requests_in_flight",
-			Help: "Number of requests currently in flight",
-		},
-	)
+	appVersion = "1.0.0"
+	gitSHA     = "unknown"
+	buildTime  = ""
 )
 
 // Transaction represents a payment transaction
-type Transaction struct {
-	ID          string    `json:"id"`
-	FromAccount string    `json:"from_account"`
-	ToAccount   string    `json:"to_account"`
-	Amount      float64   `json:"amount"`
-	Description string    `json:"description"`
-	Status      string    `json:"status"`
-	CreatedAt   time.Time `json:"created_at"`
-}
+type Transaction = store.Transaction
 
 // App holds application state
 type App struct {
-	config      *Config
-	db          *sql.DB
-	redisClient *redis.Client
-	memoryLeak  [][]byte
-	mu          sync.Mutex
-	cacheHits   int64
-	cacheMisses int64
+	config       *Config
+	startedAt    time.Time
+	logs         *Logger
+	db           *sql.DB
+	readDB       *sql.DB
+	jobDB        *sql.DB
+	transactions store.TransactionRepository
+	redisClient  *redis.Client
+	readCache    kvCache
+	sharedCache  *redisCache // set in redis mode; watchCache keeps it current
+	spool        *Spool
+	schemas      *SchemaRegistry
+	eventSchemas *EventSchemas
+	anomalies    *AnomalyDetector
+	oauthClients map[string]OAuthClient
+	oauthKey     []byte
+	cursorKey    []byte
+	exportKey    []byte
+	vault        *TokenVault
+	oidc         *OIDCVerifier
+	sessions     sessionCache
+	apiKeys      apiKeyCache
+	nonces       nonceCache
+	incidents    *IncidentNotifier
+	poolWait     poolWaitSampler
+	capture      transactionCapture
+	enricher     *Enricher
+	fraud        *FraudDetector
+	fraudPool    *FraudPool
+	grpc         *GRPCServer
+	policy       *PolicyEngine
+	bank         *BankClient
+	outbound     outboundPool
+	chaosMu      sync.Mutex
+	chaosConfig  atomic.Pointer[Config]
+	chaosRunning map[string]context.CancelFunc
+	// loadedConfig is the config as last read from CONFIG_FILE and the
+	// environment, at startup or on SIGHUP. Guarded by chaosMu.
+	loadedConfig  *Config
+	scenarios     []ChaosScenario
+	scenarioMu    sync.Mutex
+	scenario      *scenarioRun
+	feed          *TransactionFeed
+	webhooks      *WebhookDispatcher
+	receipts      *ReceiptMailer
+	publisher     EventPublisher
+	graphql       *graphql.Schema
+	seedPersonas  []SeedPersona
+	failover      *FailoverController
+	registry      *ServiceRegistry
+	startupReport *StartupReport
+	costs         *CostTracker
+	latency       *LatencyTracker
+	openapi       []byte
+	memoryLeak    [][]byte
+	mu            sync.Mutex
+	cacheHits     int64
+	cacheMisses   int64
 }
 
 // StructuredLog represents a JSON log entry
 type StructuredLog struct {
-	Timestamp string      `json:"timestamp"`
-	Level     string      `json:"level"`
-	Service   string      `json:"service"`
-	TraceID   string      `json:"trace_id"`
-	Message   string      `json:"message"`
-	Data      interface{} `json:"data,omitempty"`
+	Timestamp  string                 `json:"timestamp"`
+	Level      string                 `json:"level"`
+	Service    string                 `json:"service"`
+	TraceID    string                 `json:"trace_id"`
+	Message    string                 `json:"message"`
+	EventType  string                 `json:"event_type,omitempty"`
+	EntityID   string                 `json:"entity_id,omitempty"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Data       interface{}            `json:"data,omitempty"`
 }
 
 func (app *App) log(level, message string, data interface{}) {
-	logEntry := StructuredLog{
-		Timestamp: time.Now().UTC().Format(time.RFC3339),
-		Level:     level,
-		Service:   "payflow-api",
-		TraceID:   uuid.New().String()[:8],
-		Message:   message,
-		Data:      data,
-	}
-	jsonLog, _ := json.Marshal(logEntry)
-	fmt.Println(string(jsonLog))
-}
-
-func loadConfig() *Config {
-	return &Config{
-		Port:            getEnv("PORT", "8080"),
-		PostgresHost:   getEnv("POSTGRES_HOST", "localhost"),
-		PostgresPort:   getEnv("POSTGRES_PORT", "5432"),
-		PostgresUser:   getEnv("POSTGRES_USER", "payflow"),
-		PostgresPass:   getEnv("POSTGRES_PASSWORD", "payflow"),
-		PostgresDB:     getEnv("POSTGRES_DB", "payflow"),
-		RedisHost:      getEnv("REDIS_HOST", "localhost"),
-		RedisPort:      getEnv("REDIS_PORT", "6379"),
-		CacheMaxSize:   getEnv("CACHE_MAX_SIZE", "100MB"),
-		CacheTTL:       getEnvInt("CACHE_TTL", 3600),
-		DBPoolSize:     getEnvInt("DB_POOL_SIZE", 10),
-		RateLimitRPS:   getEnvInt("RATE_LIMIT_RPS", 100),
-		LogLevel:       getEnv("LOG_LEVEL", "info"),
-		FeatureNewCache: getEnvBool("FEATURE_NEW_CACHE", false),
-		InjectOOM:       getEnvBool("INJECT_OOM", false),
-		InjectLatencyMs: getEnvInt("INJECT_LATENCY_MS", 0),
-		InjectErrorRate: getEnvFloat("INJECT_ERROR_RATE", 0),
-		InjectCPUBurn:   getEnvBool("INJECT_CPU_BURN", false),
-		InjectPanic:     getEnvBool("INJECT_PANIC", false),
-		InjectDBTimeout: getEnvBool("INJECT_DB_TIMEOUT", false),
-	}
-}
-
-func getEnv(key, defaultVal string) string {
-	if val := os.Getenv(key); val != "" {
-		return val
-	}
-	return defaultVal
+	app.emit(level, uuid.New().String()[:8], message, data)
 }
 
-func getEnvInt(key string, defaultVal int) int {
-	if val := os.Getenv(key); val != "" {
-		if i, err := strconv.Atoi(val); err == nil {
-			return i
-		}
+// logCtx logs like log, with the ID of the request behind ctx as trace_id.
+func (app *App) logCtx(ctx context.Context, level, message string, data interface{}) {
+	if t := captureFrom(ctx); t != nil {
+		t.step(CaptureStep{Kind: "log", Level: level, Message: message, Data: data})
 	}
-	return defaultVal
+	app.emit(level, traceIDFrom(ctx), message, data)
 }
 
-func getEnvFloat(key string, defaultVal float64) float64 {
-	if val := os.Getenv(key); val != "" {
-		if f, err := strconv.ParseFloat(val, 64); err == nil {
-			return f
-		}
-	}
-	return defaultVal
+func (app *App) emit(level, traceID, message string, data interface{}) {
+	app.write(StructuredLog{Level: level, TraceID: traceID, Message: message, Data: data})
 }
 
-func getEnvBool(key string, defaultVal bool) bool {
-	if val := os.Getenv(key); val != "" {
-		return val == "true" || val == "1"
+// write outputs logEntry if LOG_LEVEL allows its level.
+func (app *App) write(logEntry StructuredLog) {
+	l := app.logger()
+	if l.Enabled(logEntry.Level) {
+		l.output(logEntry)
 	}
-	return defaultVal
 }
 
-func (app *App) initDB() error {
+// connectDB connects to Postgres, waiting for it to come up until deadline,
+// and opens the read and job pools.
+func (app *App) connectDB(deadline time.Time) error {
 	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		app.config.PostgresHost, app.config.PostgresPort, app.config.PostgresUser, app.config.PostgresPass, app.config.PostgresDB)
-	
+
 	var err error
-	for i := 0; i < 30; i++ {
-		app.db, err = sql.Open("postgres", connStr)
-		if err == nil {
-			err = app.db.Ping()
-			if err == nil {
-				break
-			}
-		}
-		app.log("warn", "Waiting for database...", map[string]interface{}{"attempt": i + 1})
-		time.Sleep(2 * time.Second)
+	app.db, err = openMeteredDB(connStr, app.logQuery, app.queryTimeout(), app.slowQueryDelay)
+	if err == nil {
+		err = app.waitForDependency(deadline, "database", app.db.PingContext)
 	}
 	if err != nil {
 		return fmt.Errorf("failed to connect to database: %w", err)
@@ -217,60 +156,81 @@ func (app *App) initDB() error {
 
 	app.db.SetMaxOpenConns(app.config.DBPoolSize)
 	app.db.SetMaxIdleConns(app.config.DBPoolSize / 2)
+	if err := app.openPools(connStr); err != nil {
+		return fmt.Errorf("failed to open database pools: %w", err)
+	}
+	return nil
+}
 
-	// Create tables
-	_, err = app.db.Exec(`
-		CREATE TABLE IF NOT EXISTS transactions (
-			id VARCHAR(36) PRIMARY KEY,
-			from_account VARCHAR(255) NOT NULL,
-			to_account VARCHAR(255) NOT NULL,
-			amount DECIMAL(15,2) NOT NULL,
-			description TEXT,
-			status VARCHAR(50) NOT NULL,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-		)
-	`)
-	if err != nil {
-		return fmt.Errorf("failed to create tables: %w", err)
+// initDB connects and, with MIGRATE_ON_START, brings the schema up to date.
+// Without it the schema is left to `payflow migrate`, and the startup
+// self-check reports pending migrations.
+func (app *App) initDB(deadline time.Time) error {
+	if err := app.connectDB(deadline); err != nil {
+		return err
+	}
+	if app.config.MigrateOnStart {
+		if err := app.migrate(context.Background()); err != nil {
+			return err
+		}
+	}
+	if err := app.initTokenVault(); err != nil {
+		return err
+	}
+	if err := app.initSubjectExports(); err != nil {
+		return err
 	}
 
 	app.log("info", "Database initialized", nil)
 	return nil
 }
 
-func (app *App) initRedis() error {
+// initRedis connects to Redis when CACHE_MODE is redis, waiting for it until
+// deadline. The client is kept when Redis doesn't answer, so the cache
+// starts working once it does.
+func (app *App) initRedis(deadline time.Time) error {
+	if app.config.CacheMode != "redis" {
+		app.log("info", "Redis disabled by CACHE_MODE", map[string]interface{}{"cache_mode": app.config.CacheMode})
+		return nil
+	}
 	app.redisClient = redis.NewClient(&redis.Options{
 		Addr: fmt.Sprintf("%s:%s", app.config.RedisHost, app.config.RedisPort),
 	})
+	app.redisClient.AddHook(redisCostHook{})
+	app.redisClient.AddHook(redisDebugHook{app})
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	for i := 0; i < 30; i++ {
-		_, err := app.redisClient.Ping(ctx).Result()
-		if err == nil {
-			app.log("info", "Redis connected", nil)
-			return nil
-		}
-		app.log("warn", "Waiting for Redis...", map[string]interface{}{"attempt": i + 1})
-		time.Sleep(2 * time.Second)
+	err := app.waitForDependency(deadline, "Redis", func(ctx context.Context) error {
+		return app.redisClient.Ping(ctx).Err()
+	})
+	if err != nil {
+		return fmt.Errorf("redis not available: %w", err)
 	}
-
-	app.log("warn", "Redis not available, continuing without cache", nil)
+	app.log("info", "Redis connected", nil)
 	return nil
 }
 
 func (app *App) bugInjectionMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// Profiles must stay reachable while an injected fault is being
+		// debugged.
+		if strings.HasPrefix(c.Request.URL.Path, pprofPrefix+"/") {
+			c.Next()
+			return
+		}
+		config := app.cfg(c)
+		c.Request = c.Request.WithContext(withRequestConfig(c.Request.Context(), config))
+
 		// Latency injection
-		if app.config.InjectLatencyMs > 0 {
-			time.Sleep(time.Duration(app.config.InjectLatencyMs) * time.Millisecond)
+		if config.InjectLatencyMs > 0 {
+			app.debug(c.Request.Context(), "Injecting latency", map[string]interface{}{"latency_ms": config.InjectLatencyMs})
+			time.Sleep(time.Duration(config.InjectLatencyMs) * time.Millisecond)
 		}
 
 		// Error rate injection
-		if app.config.InjectErrorRate > 0 && rand.Float64() < app.config.InjectErrorRate {
-			app.log("error", "Injected error occurred", map[string]interface{}{
-				"error_rate": app.config.InjectErrorRate,
+		if config.InjectErrorRate > 0 && rand.Float64() < config.InjectErrorRate {
+			app.eventCtx(c.Request.Context(), "error", EventChaosErrorInjected, "", "Injected error occurred", map[string]interface{}{
+				"error_rate": config.InjectErrorRate,
+				"path":       c.Request.URL.Path,
 			})
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Simulated error"})
 			c.Abort()
@@ -278,93 +238,21 @@ func (app *App) bugInjectionMiddleware() gin.HandlerFunc {
 		}
 
 		// Panic injection
-		if app.config.InjectPanic && rand.Float64() < 0.1 {
-			app.log("error", "Panic injection triggered", nil)
-			panic("Injected panic!")
-		}
-
-		c.Next()
-	}
-}
-
-func (app *App) metricsMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		requestsInFlight.Inc()
-		start := time.Now()
-
-		c.Next()
-
-		duration := time.Since(start).Seconds()
-		transactionDuration.WithLabelValues(c.Request.URL.Path).Observe(duration)
-		requestsInFlight.Dec()
-	}
-}
-
-func (app *App) startOOMSimulation() {
-	if !app.config.InjectOOM {
-		return
-	}
-	app.log("warn", "OOM simulation enabled - memory will grow", nil)
-	go func() {
-		for {
-			app.mu.Lock()
-			// Allocate 10MB chunks
-			chunk := make([]byte, 10*1024*1024)
-			for i := range chunk {
-				chunk[i] = byte(i % 256)
-			}
-			app.memoryLeak = append(app.memoryLeak, chunk)
-			app.mu.Unlock()
-			app.log("warn", "Memory allocated", map[string]interface{}{
-				"chunks": len(app.memoryLeak),
-				"size_mb": len(app.memoryLeak) * 10,
+		if config.InjectPanic && rand.Float64() < 0.1 {
+			app.eventCtx(c.Request.Context(), "error", EventChaosPanicInjected, "", "Panic injection triggered", map[string]interface{}{
+				"path": c.Request.URL.Path,
 			})
-			time.Sleep(5 * time.Second)
+			panic("Injected panic!")
 		}
-	}()
-}
 
-func (app *App) startBuggyCacheWarmup() {
-	if !app.config.FeatureNewCache {
-		return
-	}
-
-	app.log("warn", "New cache enabled - warming cache (buggy)", map[string]interface{}{
-		"cache_max_size": app.config.CacheMaxSize,
-	})
-
-	go func() {
-		for {
-			app.mu.Lock()
-			chunk := make([]byte, 10*1024*1024)
-			for i := range chunk {
-				chunk[i] = byte(i % 256)
-			}
-			app.memoryLeak = append(app.memoryLeak, chunk)
-			app.mu.Unlock()
-
-			app.log("warn", "Cache warmup allocated", map[string]interface{}{
-				"chunks":  len(app.memoryLeak),
-				"size_mb": len(app.memoryLeak) * 10,
-			})
-			time.Sleep(5 * time.Second)
+		// Dropped response injection
+		if config.InjectDropRate > 0 && rand.Float64() < config.InjectDropRate {
+			app.dropResponse(c)
+			return
 		}
-	}()
-}
 
-func (app *App) startCPUBurn() {
-	if !app.config.InjectCPUBurn {
-		return
+		c.Next()
 	}
-	app.log("warn", "CPU burn simulation enabled", nil)
-	go func() {
-		for {
-			// Busy loop
-			for i := 0; i < 1000000000; i++ {
-				_ = i * i
-			}
-		}
-	}()
 }
 
 func (app *App) updateMetrics() {
@@ -375,13 +263,12 @@ func (app *App) updateMetrics() {
 			memoryUsedBytes.Set(float64(m.Alloc))
 
 			if app.db != nil {
-				stats := app.db.Stats()
-				dbConnectionsActive.Set(float64(stats.InUse))
+				dbConnectionsActive.Set(float64(app.observePools()))
 			}
 
-			total := app.cacheHits + app.cacheMisses
-			if total > 0 {
-				cacheHitRatio.Set(float64(app.cacheHits) / float64(total))
+			hits, misses := atomic.LoadInt64(&app.cacheHits), atomic.LoadInt64(&app.cacheMisses)
+			if total := hits + misses; total > 0 {
+				cacheHitRatio.Set(float64(hits) / float64(total))
 			}
 
 			time.Sleep(5 * time.Second)
@@ -391,82 +278,101 @@ func (app *App) updateMetrics() {
 
 // Handlers
 
-func (app *App) healthHandler(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"status": "healthy", "version": "1.0.0"})
-}
-
-func (app *App) readinessHandler(c *gin.Context) {
-	if app.db != nil {
-		if err := app.db.Ping(); err != nil {
-			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "not ready", "error": err.Error()})
-			return
-		}
-	}
-	c.JSON(http.StatusOK, gin.H{"status": "ready"})
-}
-
 func (app *App) getStatsHandler(c *gin.Context) {
-	var totalRevenue float64
-	var totalTransactions int
-	var successfulTransactions int
-
-	if app.db != nil {
-		app.db.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM transactions WHERE status = 'success'").Scan(&totalRevenue)
-		app.db.QueryRow("SELECT COUNT(*) FROM transactions").Scan(&totalTransactions)
-		app.db.QueryRow("SELECT COUNT(*) FROM transactions WHERE status = 'success'").Scan(&successfulTransactions)
-	}
-
-	successRate := float64(0)
-	if totalTransactions > 0 {
-		successRate = float64(successfulTransactions) / float64(totalTransactions) * 100
-	}
+	stats := app.computeStats(c.Request.Context(), sessionID(c))
+	revenue := newMoney(stats.RevenueMinor, app.config.Currency, app.requestLocale(c))
+	app.debug(c.Request.Context(), "Stats computed", map[string]interface{}{
+		"revenue":      revenue.Amount,
+		"transactions": stats.Transactions,
+		"successful":   stats.Successful,
+	})
 
 	c.JSON(http.StatusOK, gin.H{
-		"revenue":      totalRevenue,
-		"transactions": totalTransactions,
-		"success_rate": successRate,
+		"revenue":      revenue,
+		"transactions": stats.Transactions,
+		"success_rate": stats.SuccessRate(),
 		"avg_latency":  45, // Mock for now
 	})
 }
 
 func (app *App) getTransactionsHandler(c *gin.Context) {
-	if app.db == nil {
-		c.JSON(http.StatusOK, []Transaction{})
+	limit, err := pageParam(c, "limit", defaultPageLimit, maxPageLimit)
+	if err == nil && limit < 1 {
+		// An empty page has no last row to continue a cursor from.
+		err = fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-
-	// DB timeout injection
-	if app.config.InjectDBTimeout {
-		time.Sleep(30 * time.Second)
+	offset, err := pageParam(c, "offset", 0, maxPageOffset)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
-
-	rows, err := app.db.Query(`
-		SELECT id, from_account, to_account, amount, description, status, created_at 
-		FROM transactions 
-		ORDER BY created_at DESC 
-		LIMIT 50
-	`)
+	fields, err := apihttp.FieldsParam(c, Transaction{})
 	if err != nil {
-		app.log("error", "Failed to fetch transactions", map[string]interface{}{"error": err.Error()})
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	page := TransactionPage{Data: []Transaction{}, Limit: limit, Offset: offset}
+	if app.transactions == nil {
+		c.JSON(http.StatusOK, page)
 		return
 	}
-	defer rows.Close()
 
-	var transactions []Transaction
-	for rows.Next() {
-		var t Transaction
-		if err := rows.Scan(&t.ID, &t.FromAccount, &t.ToAccount, &t.Amount, &t.Description, &t.Status, &t.CreatedAt); err != nil {
-			continue
-		}
-		transactions = append(transactions, t)
+	// DB timeout injection: hold a read connection the way a runaway
+	// reporting query would. Payments use their own pool and keep working.
+	if app.cfg(c).InjectDBTimeout && app.db != nil {
+		app.readPool().ExecContext(c.Request.Context(), `SELECT pg_sleep(30)`)
 	}
 
-	if transactions == nil {
-		transactions = []Transaction{}
+	// One row past the page tells whether another one follows.
+	filter := store.TransactionFilter{SessionID: sessionID(c), Limit: limit + 1, Offset: offset}
+	filters := transactionFilters(c.Request.URL.Query())
+	if token := c.Query("cursor"); token != "" {
+		cur, err := app.decodeCursor(token)
+		if err == nil && cur.SessionID != filter.SessionID {
+			err = errInvalidCursor
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if c.Query("offset") != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "offset can't be combined with cursor"})
+			return
+		}
+		// Filters may be repeated alongside the cursor, but not changed.
+		if len(filters) > 0 && !maps.Equal(filters, cur.Filters) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "filters must match the ones the cursor was issued for"})
+			return
+		}
+		filters, filter.After = cur.Filters, cur.position()
+	}
+	app.applyReplicationLag(c, &filter)
+	if err := app.applyTransactionFilters(c, filters, &filter); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
+	page, err = app.listTransactions(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	page.Limit = limit
+	if len(page.Data) > limit {
+		page.Data = page.Data[:limit]
+		last := store.PositionOf(page.Data[limit-1])
+		page.NextCursor = app.encodeCursor(pageCursor{SessionID: filter.SessionID, Filters: filters, CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+	app.debug(c.Request.Context(), "Transactions fetched", map[string]interface{}{"rows": len(page.Data), "total": page.Total})
 
-	c.JSON(http.StatusOK, transactions)
+	// Data shadows the page's own, so a sparse page keeps the envelope.
+	c.JSON(http.StatusOK, struct {
+		TransactionPage
+		Data interface{} `json:"data"`
+	}{page, fields.Apply(page.Data)})
 }
 
 func (app *App) createTransactionHandler(c *gin.Context) {
@@ -481,128 +387,510 @@ func (app *App) createTransactionHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-
-	// Simulate processing
-	status := "success"
-	if rand.Float64() < 0.05 { // 5% natural failure rate
-		status = "failed"
-		transactionsTotal.WithLabelValues("failed").Inc()
-		app.log("error", "Transaction failed: insufficient funds", map[string]interface{}{
-			"from_account": req.FromAccount,
-			"amount":       req.Amount,
-			"error_code":   "INSUFFICIENT_FUNDS",
-		})
-	} else {
-		transactionsTotal.WithLabelValues("success").Inc()
+	if !wholeCents(req.Amount) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errAmountPrecision.Error()})
+		return
 	}
 
-	txn := Transaction{
-		ID:          uuid.New().String(),
+	app.debug(c.Request.Context(), "Transaction request validated", map[string]interface{}{"amount": req.Amount})
+
+	txn, spooled, err := app.createPayment(c.Request.Context(), PaymentRequest{
 		FromAccount: req.FromAccount,
 		ToAccount:   req.ToAccount,
 		Amount:      req.Amount,
 		Description: req.Description,
-		Status:      status,
-		CreatedAt:   time.Now(),
+		SessionID:   sessionID(c),
+	})
+	if errors.Is(err, errPaymentRejected) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	if txn.Status == "failed" {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":       "Insufficient funds",
+			"code":        "INSUFFICIENT_FUNDS",
+			"transaction": txn,
+		})
+		return
+	}
+	code := http.StatusCreated
+	if spooled || txn.Status == "held" {
+		code = http.StatusAccepted
 	}
+	c.JSON(code, txn)
+}
 
-	if app.db != nil {
-		_, err := app.db.Exec(`
-			INSERT INTO transactions (id, from_account, to_account, amount, description, status, created_at)
-			VALUES ($1, $2, $3, $4, $5, $6, $7)
-		`, txn.ID, txn.FromAccount, txn.ToAccount, txn.Amount, txn.Description, txn.Status, txn.CreatedAt)
+func (app *App) insertTransaction(ctx context.Context, txn *Transaction) error {
+	if txn.Region == "" {
+		txn.Region = app.config.Region
+	}
+	if app.db == nil {
+		return errNoDatabase
+	}
+	// writeTransaction tokenizes and seals txn in place, so each attempt
+	// starts again from the original.
+	var written Transaction
+	err := retrySerialization(ctx, func() error {
+		written = *txn
+		tx, err := app.db.BeginTx(ctx, nil)
 		if err != nil {
-			app.log("error", "Failed to save transaction", map[string]interface{}{"error": err.Error()})
+			return err
 		}
+		defer tx.Rollback()
+		if err := app.writeTransaction(ctx, tx, &written); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		return err
 	}
+	*txn = written
+	app.invalidateReadCache(ctx)
+	app.wakeReceipts()
+	app.feed.PublishCreated(*txn)
+	app.debug(ctx, "Transaction inserted", map[string]interface{}{"transaction_id": txn.ID})
+	return nil
+}
 
-	app.log("info", "Transaction processed", map[string]interface{}{
-		"transaction_id": txn.ID,
-		"amount":         txn.Amount,
-		"status":         txn.Status,
-	})
+// writeTransaction posts and stores txn inside tx, so callers can make other
+// changes atomically with it.
+func (app *App) writeTransaction(ctx context.Context, tx *sql.Tx, txn *Transaction) error {
+	if err := app.tokenizeTransaction(ctx, tx, txn); err != nil {
+		return err
+	}
 
-	c.JSON(http.StatusCreated, txn)
+	// Demo session data is deleted when the session expires, so it stays out
+	// of the hash chain rather than leaving holes in it. Its balances still
+	// move, but only between the session's own accounts, under a lock of the
+	// session's rather than the ledger's.
+	if txn.SessionID == "" {
+		if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, ledgerLockID); err != nil {
+			return err
+		}
+	} else if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1, hashtext($2))`, sessionLockClass, txn.SessionID); err != nil {
+		return err
+	}
+	// Spool replays may retry a transaction that already landed; posting it
+	// again would move the balances twice.
+	var exists bool
+	if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM transactions WHERE id = $1)`, txn.ID).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	if txn.Status == "success" {
+		if err := postBalances(ctx, tx, txn); err != nil {
+			return err
+		}
+	}
+	var seal ledgerSeal
+	if txn.SessionID == "" {
+		var err error
+		if seal, err = app.sealTransaction(ctx, tx, txn); err != nil {
+			return err
+		}
+		app.debug(ctx, "Transaction sealed", map[string]interface{}{
+			"transaction_id": txn.ID,
+			"prev_hash":      txn.PrevHash,
+			"hash":           txn.Hash,
+		})
+	}
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO transactions (id, from_account, to_account, amount, description, status, created_at, prev_hash, hash, status_token, session_id, region, refund_of, internal, fraud_status, chain_version, content_hash, status_hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, ''), NULLIF($10, ''), NULLIF($11, ''), NULLIF($12, ''), NULLIF($13, ''), $14, NULLIF($15, ''), $16, NULLIF($17, ''), NULLIF($18, ''))
+		ON CONFLICT (id) DO NOTHING
+	`, txn.ID, txn.FromAccount, txn.ToAccount, txn.Amount, txn.Description, txn.Status, txn.CreatedAt, txn.PrevHash, txn.Hash, txn.StatusToken, txn.SessionID, txn.Region, txn.RefundOf, txn.Internal, txn.FraudStatus, ledgerChainVersion, seal.ContentHash, seal.StatusHash)
+	if err != nil {
+		return err
+	}
+	return app.queueReceipt(ctx, tx, txn)
 }
 
-func (app *App) getConfigHandler(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
-		"cache_max_size":    app.config.CacheMaxSize,
-		"cache_ttl":         app.config.CacheTTL,
-		"db_pool_size":      app.config.DBPoolSize,
-		"rate_limit_rps":    app.config.RateLimitRPS,
-		"log_level":         app.config.LogLevel,
-		"feature_new_cache": app.config.FeatureNewCache,
-		"bug_injection": gin.H{
-			"oom":        app.config.InjectOOM,
-			"latency_ms": app.config.InjectLatencyMs,
-			"error_rate": app.config.InjectErrorRate,
-			"cpu_burn":   app.config.InjectCPUBurn,
-			"panic":      app.config.InjectPanic,
-			"db_timeout": app.config.InjectDBTimeout,
-		},
+func (app *App) initSpool() error {
+	spool, err := openSpool(app.config.SpoolPath)
+	if err != nil {
+		return err
+	}
+	app.spool = spool
+	spoolDepth.Set(float64(spool.Depth()))
+	app.log("info", "Transaction spool opened", map[string]interface{}{
+		"path":  app.config.SpoolPath,
+		"depth": spool.Depth(),
 	})
+	return nil
 }
 
-func main() {
-	rand.Seed(time.Now().UnixNano())
-
-	// Register metrics
-	prometheus.MustRegister(transactionsTotal)
-	prometheus.MustRegister(transactionDuration)
-	prometheus.MustRegister(cacheHitRatio)
-	prometheus.MustRegister(dbConnectionsActive)
-	prometheus.MustRegister(memoryUsedBytes)
-	prometheus.MustRegister(requestsInFlight)
-
-	config := loadConfig()
-	app := &App{config: config}
+// startSpoolReplay drains the spool into Postgres whenever the database
+// becomes reachable again.
+func (app *App) startSpoolReplay() {
+	if app.spool == nil {
+		return
+	}
+	go func() {
+		interval := time.Duration(app.config.SpoolReplaySec) * time.Second
+		for {
+			time.Sleep(interval)
+			if app.spool.Depth() == 0 || app.db == nil || app.pingDB(context.Background()) != nil {
+				continue
+			}
+			replayed, err := app.spool.Replay(func(txn Transaction) error {
+				if err := app.insertTransaction(context.Background(), &txn); err != nil {
+					if !dbErrorRetryable(err) {
+						// It would fail the same way on every pass and hold
+						// up everything spooled after it.
+						spoolOperationsTotal.WithLabelValues("dead_lettered").Inc()
+						app.log("error", "Spooled transaction rejected by the database, moved to dead letters", map[string]interface{}{
+							"transaction_id": txn.ID,
+							"category":       classifyDBError(err),
+							"error":          err.Error(),
+						})
+						return errSpoolDeadLetter
+					}
+					return err
+				}
+				app.fraudPool.Submit(txn)
+				if txn.Status == "success" {
+					app.webhooks.Dispatch(context.Background(), WebhookTransactionCreated, txn.SessionID, webhookPayload(txn))
+					app.publisher.PublishTransaction(context.Background(), txn)
+				}
+				return nil
+			})
+			if replayed > 0 {
+				spoolOperationsTotal.WithLabelValues("replayed").Add(float64(replayed))
+			}
+			spoolDepth.Set(float64(app.spool.Depth()))
+			fields := map[string]interface{}{"replayed": replayed, "remaining": app.spool.Depth()}
+			if err != nil {
+				fields["error"] = err.Error()
+				fields["category"] = classifyDBError(err)
+				app.log("warn", "Spool replay interrupted", fields)
+				continue
+			}
+			app.event("info", EventSpoolReplayed, "", "Spool replayed", fields)
+		}
+	}()
+}
 
-	app.log("info", "Starting PayFlow API", map[string]interface{}{
-		"version":     "1.0.0",
-		"port":        config.Port,
-		"log_level":   config.LogLevel,
-		"oom_enabled": config.InjectOOM,
-	})
+// getConfigHandler returns the tunables the dashboard shows. Bug injection
+// state is only included for admins, and ?verbose=true (admin only) returns
+// every effective setting with its source instead.
+func (app *App) getConfigHandler(c *gin.Context) {
+	config := app.cfg(c)
+	admin := app.isAdminRequest(c)
 
-	// Initialize connections
-	if err := app.initDB(); err != nil {
-		app.log("error", "Database initialization failed", map[string]interface{}{"error": err.Error()})
+	if c.Query("verbose") == "true" {
+		if !admin {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Verbose configuration requires an admin token"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"settings": config.Settings()})
+		return
 	}
-	if err := app.initRedis(); err != nil {
-		app.log("warn", "Redis initialization failed", map[string]interface{}{"error": err.Error()})
+
+	resp := gin.H{
+		"cache_mode":        config.CacheMode,
+		"cache_max_size":    config.CacheMaxSize,
+		"cache_ttl":         config.CacheTTL,
+		"db_pool_size":      config.DBPoolSize,
+		"rate_limit_rps":    config.RateLimitRPS,
+		"log_level":         config.LogLevel,
+		"feature_new_cache": config.FeatureNewCache,
+	}
+	if admin {
+		resp["bug_injection"] = gin.H{
+			"oom":        config.InjectOOM,
+			"latency_ms": config.InjectLatencyMs,
+			"error_rate": config.InjectErrorRate,
+			"cpu_burn":   config.InjectCPUBurn,
+			"panic":      config.InjectPanic,
+			"db_timeout": config.InjectDBTimeout,
+		}
 	}
+	c.JSON(http.StatusOK, resp)
+}
 
-	// Start bug injections
-	app.startOOMSimulation()
-	app.startBuggyCacheWarmup()
-	app.startCPUBurn()
-	app.updateMetrics()
+func (app *App) getConfigSchemaHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"fields": config.Schema})
+}
 
-	// Setup Gin
-	gin.SetMode(gin.ReleaseMode)
+// newRouter registers the middleware and every HTTP route.
+func (app *App) newRouter() *gin.Engine {
 	r := gin.New()
-	r.Use(gin.Recovery())
+	// The config was validated, so this can't fail.
+	proxies, _ := parseTrustedProxies(app.config.TrustedProxies)
+	r.SetTrustedProxies(proxies)
+	r.Use(app.requestIDMiddleware())
+	r.Use(app.apiVersionMiddleware())
+	r.Use(app.recoveryMiddleware())
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"*"},
 		AllowCredentials: true,
 	}))
 	r.Use(app.metricsMiddleware())
+	r.Use(app.costMiddleware())
+	r.Use(app.latencyMiddleware())
+	r.Use(app.regionMiddleware())
+	r.Use(app.debugSamplingMiddleware())
+	r.Use(app.captureMiddleware())
+	r.Use(app.serviceAuthMiddleware())
+	r.Use(app.apiKeyMiddleware())
+	r.Use(app.signedRequestMiddleware())
+	r.Use(app.debugHeaderMiddleware())
+	r.Use(app.demoSessionMiddleware())
+	r.Use(app.featureOverrideMiddleware())
 	r.Use(app.bugInjectionMiddleware())
 
 	// Routes
 	r.GET("/health", app.healthHandler)
 	r.GET("/ready", app.readinessHandler)
-	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	r.GET("/metrics", gin.WrapH(metricsHandler()))
+	if app.config.EnablePprof {
+		app.mountPprof(r)
+	}
 
-	api := r.Group("/api")
+	r.POST("/oauth/token", app.tokenHandler)
+	if app.config.DemoTokensEnabled {
+		r.POST("/oauth/demo-token", app.validateBody("demo-token"), app.demoTokenHandler)
+	}
+	r.GET("/api/t/:token", app.getTransactionStatusHandler)
+	r.GET("/api/privacy/exports/:id/download", app.downloadSubjectExportHandler)
+	r.GET("/api/openapi.json", app.openAPIHandler)
+	r.GET("/api/docs", app.swaggerUIHandler)
+
+	api := r.Group("/api", app.requireAuthMiddleware(), app.rateLimitMiddleware())
 	{
-		api.GET("/stats", app.getStatsHandler)
-		api.GET("/transactions", app.getTransactionsHandler)
-		api.POST("/transactions", app.createTransactionHandler)
+		api.GET("/stats", requireScope("transactions:read"), app.cacheAside("stats"), app.getStatsHandler)
+		api.GET("/dashboard", requireScope("transactions:read"), app.getDashboardHandler)
+		api.GET("/stats/amount-distribution", requireScope("transactions:read"), app.cacheAside("amount-distribution"), app.amountDistributionHandler)
+		api.GET("/seed/sample", requireScope("transactions:read"), app.seedSampleHandler)
+		api.GET("/transactions", requireScope("transactions:read"), app.cacheAside("transactions"), app.getTransactionsHandler)
+		api.GET("/ws/transactions", requireScope("transactions:read"), app.transactionFeedHandler)
+		api.POST("/transactions", requireScope("transactions:write"), app.backpressureMiddleware(), app.validateBody("create-transaction"), app.createTransactionHandler)
+		api.POST("/transactions/:id/refund", requireScope("transactions:write"), app.validateBody("refund-transaction"), app.refundTransactionHandler)
+		api.POST("/transactions/import", requireScope("transactions:write"), app.importStatementHandler)
+		api.GET("/accounts", requireScope("accounts:read"), app.listAccountsHandler)
+		api.GET("/accounts/:id", requireScope("accounts:read"), app.getAccountHandler)
+		api.GET("/accounts/:id/sub-accounts", requireScope("accounts:read"), app.listSubAccountsHandler)
+		api.GET("/accounts/:id/rollup", requireScope("accounts:read"), app.accountRollupHandler)
+		api.GET("/accounts/:id/receipts", requireScope("accounts:read"), app.listAccountReceiptsHandler)
+		api.POST("/accounts", requireScope("accounts:write"), app.validateBody("create-account"), app.createAccountHandler)
+		api.PATCH("/accounts/:id", requireScope("accounts:write"), app.validateBody("update-account"), app.updateAccountHandler)
+		api.DELETE("/accounts/:id", requireScope("accounts:write"), app.deleteAccountHandler)
+		api.POST("/webhooks", requireScope("webhooks:manage"), app.validateBody("create-webhook"), app.createWebhookHandler)
+		api.GET("/webhooks", requireScope("webhooks:manage"), app.listWebhooksHandler)
+		api.DELETE("/webhooks/:id", requireScope("webhooks:manage"), app.deleteWebhookHandler)
+		api.POST("/webhooks/:id/rotate-secret", requireScope("webhooks:manage"), app.rotateWebhookSecretHandler)
+		api.GET("/webhooks/:id/deliveries", requireScope("webhooks:manage"), app.listWebhookDeliveriesHandler)
 		api.GET("/config", app.getConfigHandler)
+		api.GET("/schemas", app.listSchemasHandler)
+		api.GET("/schemas/:name", app.getSchemaHandler)
+		api.GET("/schemas/events", app.listEventSchemasHandler)
+		api.GET("/schemas/events/:version/:name", app.getEventSchemaHandler)
+		// Scopes and admin access are checked per field.
+		api.GET("/graphql", app.graphqlHandler)
+		api.POST("/graphql", app.graphqlHandler)
+	}
+
+	fraud := api.Group("/fraud", app.fraudTriageMiddleware())
+	{
+		fraud.GET("/alerts", app.listFraudAlertsHandler)
+		fraud.GET("/alerts/:id", app.getFraudAlertHandler)
+		fraud.PATCH("/alerts/:id", app.validateBody("update-fraud-alert"), app.updateFraudAlertHandler)
+		fraud.GET("/review-queue", app.listReviewQueueHandler)
+		fraud.POST("/review-queue/:id/approve", app.validateOptionalBody("review-decision"), app.approveReviewHandler)
+		fraud.POST("/review-queue/:id/decline", app.validateOptionalBody("review-decision"), app.declineReviewHandler)
+		fraud.GET("/labels", app.listFraudLabelsHandler)
+		fraud.GET("/accounts/:id/activity-window", app.activityWindowHandler)
+	}
+
+	admin := api.Group("/admin", app.adminMiddleware())
+	{
+		admin.GET("/config/schema", app.getConfigSchemaHandler)
+		admin.POST("/config/reload", app.reloadConfigHandler)
+		admin.GET("/chaos", app.getChaosHandler)
+		admin.PUT("/chaos", app.validateBody("update-chaos"), app.updateChaosHandler)
+		admin.DELETE("/chaos", app.resetChaosHandler)
+		admin.GET("/rate-limits", app.getRateLimitsHandler)
+		admin.PUT("/rate-limits", app.validateBody("update-rate-limits"), app.updateRateLimitsHandler)
+		admin.DELETE("/rate-limits", app.resetRateLimitsHandler)
+		admin.GET("/chaos/scenarios", app.listScenariosHandler)
+		admin.POST("/chaos/scenarios/run", app.validateBody("run-chaos-scenario"), app.runScenarioHandler)
+		admin.DELETE("/chaos/scenarios/run", app.stopScenarioHandler)
+		admin.GET("/startup-report", app.getStartupReportHandler)
+		admin.GET("/ledger/verify", app.verifyLedgerHandler)
+		admin.GET("/ledger/closes", app.listDailyClosesHandler)
+		admin.GET("/ledger/closes/:day", app.getDailyCloseHandler)
+		admin.POST("/ledger/closes/:day", app.closeDayHandler)
+		admin.GET("/ledger/exceptions", app.listCloseExceptionsHandler)
+		admin.GET("/ledger/settlements", app.listSettlementsHandler)
+		admin.GET("/duplicates", app.findDuplicatesHandler)
+		admin.POST("/duplicates/merge", app.validateBody("merge-duplicates"), app.mergeDuplicatesHandler)
+		admin.GET("/transactions/:id/audit", app.getTransactionAuditHandler)
+		admin.GET("/incidents", app.listIncidentsHandler)
+		admin.GET("/registry", app.listRegistryHandler)
+		admin.GET("/costs", app.getCostsHandler)
+		admin.DELETE("/costs", app.resetCostsHandler)
+		admin.GET("/latency/breakdown", app.getLatencyBreakdownHandler)
+		admin.DELETE("/latency/breakdown", app.resetLatencyBreakdownHandler)
+		admin.GET("/fraud/rules", app.getFraudRulesHandler)
+		admin.POST("/fraud/rules/reload", app.reloadFraudRulesHandler)
+		admin.POST("/fraud/evaluate", app.validateBody("create-transaction"), app.evaluateFraudHandler)
+		admin.POST("/fraud/shadow", app.validateOptionalBody("start-fraud-shadow"), app.startFraudShadowHandler)
+		admin.GET("/fraud/shadow", app.getFraudShadowHandler)
+		admin.POST("/fraud/shadow/promote", app.promoteFraudShadowHandler)
+		admin.DELETE("/fraud/shadow", app.discardFraudShadowHandler)
+		admin.GET("/fraud/summaries", app.listFraudSummariesHandler)
+		admin.GET("/fraud/spikes", app.listFraudSpikesHandler)
+		admin.POST("/fraud/backfill", app.startFraudBackfillHandler)
+		admin.GET("/fraud/backfills", app.listFraudBackfillsHandler)
+		admin.GET("/fraud/backfills/:id", app.getFraudBackfillHandler)
+		admin.DELETE("/fraud/backfills/:id", app.deleteFraudBackfillHandler)
+		admin.POST("/capture", app.validateOptionalBody("start-capture"), app.startCaptureHandler)
+		admin.GET("/capture", app.getCaptureHandler)
+		admin.DELETE("/capture", app.discardCaptureHandler)
+		admin.POST("/privacy/erase", app.validateBody("erase-account"), app.eraseAccountHandler)
+		admin.POST("/tokens/detokenize", requireDetokenize(), app.validateBody("detokenize"), app.detokenizeHandler)
+		admin.GET("/tokens/keys", app.listVaultKeysHandler)
+		admin.POST("/tokens/keys/rotate", app.validateBody("rotate-vault-key"), app.rotateVaultKeyHandler)
+		admin.GET("/privacy/erasures", app.listErasuresHandler)
+		admin.POST("/privacy/exports", app.validateBody("create-subject-export"), app.createSubjectExportHandler)
+		admin.GET("/privacy/exports/:id", app.getSubjectExportHandler)
+		admin.GET("/counterparties", app.listCounterpartiesHandler)
+		admin.PUT("/counterparties/:account", app.validateBody("put-counterparty"), app.putCounterpartyHandler)
+		admin.GET("/datasets", app.listDatasetsHandler)
+		admin.POST("/datasets", app.validateBody("create-dataset"), app.snapshotDatasetHandler)
+		admin.POST("/datasets/:name/restore", app.restoreDatasetHandler)
+		admin.DELETE("/datasets/:name", app.deleteDatasetHandler)
+		admin.POST("/demo-sessions", app.validateBody("create-demo-session"), app.createDemoSessionHandler)
+		admin.GET("/demo-sessions", app.listDemoSessionsHandler)
+		admin.DELETE("/demo-sessions/:id", app.deleteDemoSessionHandler)
+		admin.POST("/tenants", app.validateBody("create-tenant"), app.createTenantHandler)
+		admin.POST("/api-keys", app.validateBody("create-api-key"), app.createAPIKeyHandler)
+		admin.GET("/api-keys", app.listAPIKeysHandler)
+		admin.DELETE("/api-keys/:id", app.revokeAPIKeyHandler)
+	}
+	return r
+}
+
+func main() {
+	rand.Seed(time.Now().UnixNano())
+
+	config, err := loadConfig()
+	app := &App{config: config, startedAt: time.Now().UTC(), anomalies: newAnomalyDetector(), costs: newCostTracker(), latency: newLatencyTracker()}
+	if err != nil {
+		var cfgErr *ConfigError
+		if errors.As(err, &cfgErr) {
+			app.log("error", "Invalid configuration", map[string]interface{}{"problems": cfgErr.Problems})
+		}
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	app.logs = newLogger(config.LogLevel, config.LogFormat, os.Stdout)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(app.runMigrateCommand(os.Args[2:]))
+	}
+	if app.schemas, err = loadSchemas(); err != nil {
+		log.Fatalf("Failed to load request schemas: %v", err)
+	}
+	if app.eventSchemas, err = loadEventSchemas(); err != nil {
+		log.Fatalf("Failed to load event schemas: %v", err)
+	}
+	if err := app.initGraphQL(); err != nil {
+		log.Fatalf("Failed to parse GraphQL schema: %v", err)
+	}
+	if app.seedPersonas, err = loadSeedPersonas(config.SeedPersonasFile); err != nil {
+		log.Fatalf("Failed to load seed personas: %v", err)
+	}
+	if app.scenarios, err = loadChaosScenarios(config.ChaosScenariosFile, config); err != nil {
+		log.Fatalf("Failed to load chaos scenarios: %v", err)
+	}
+	if err := app.initOAuth(); err != nil {
+		log.Fatalf("Failed to initialize OAuth: %v", err)
+	}
+	if err := app.initCursors(); err != nil {
+		log.Fatalf("Failed to initialize cursor signing: %v", err)
+	}
+	if err := app.initReceipts(); err != nil {
+		log.Fatalf("Failed to initialize receipt emails: %v", err)
+	}
+	if config.AdminToken == "" && app.oidc == nil {
+		app.log("warn", "ADMIN_TOKEN and OIDC not set, admin endpoints and feature overrides are unauthenticated", nil)
+	}
+
+	app.log("info", "Starting PayFlow API", map[string]interface{}{
+		"version":     appVersion,
+		"port":        config.Port,
+		"log_level":   config.LogLevel,
+		"oom_enabled": config.InjectOOM,
+	})
+	registerMetrics(config.Region)
+	app.log("info", "Effective configuration", config.Summary())
+
+	// Initialize connections
+	deadline := app.startupDeadline()
+	dbErr := app.initDB(deadline)
+	if dbErr != nil {
+		app.log("error", "Database initialization failed", map[string]interface{}{"error": dbErr.Error()})
+	}
+	if app.db != nil {
+		app.transactions = store.NewPostgres(app.readPool)
+	}
+	redisErr := app.initRedis(deadline)
+	if redisErr != nil {
+		app.log("warn", "Redis initialization failed, continuing without cache", map[string]interface{}{"error": redisErr.Error()})
+	}
+	if config.FailFast && (dbErr != nil || redisErr != nil) {
+		log.Fatalf("Dependencies not available by the startup deadline: %v", errors.Join(dbErr, redisErr))
+	}
+	app.initEnrichment()
+	app.initCache()
+	app.initFraud()
+	app.initPolicy()
+	app.initBank()
+	app.feed = newTransactionFeed(config.WSMaxClients, config.WSSendBuffer)
+	app.webhooks = newWebhookDispatcher(app)
+	app.publisher = newEventPublisher(app)
+	if err := app.initSpool(); err != nil {
+		app.log("error", "Spool initialization failed, writes will fail while the database is down", map[string]interface{}{"error": err.Error()})
+	}
+	if failed := app.runSelfCheck().Failed(); len(failed) > 0 && config.StrictStartup {
+		log.Fatalf("Startup self-check failed: %s", strings.Join(failed, ", "))
+	}
+
+	// Start bug injections
+	app.syncChaosRunners(app.config)
+	app.startConfiguredScenario()
+	app.updateMetrics()
+	app.watchCache()
+	app.startSpoolReplay()
+	app.startAnomalyDetector()
+	app.startDemoSessionReaper()
+	app.startIncidentNotifier()
+	app.startFailover()
+	app.startFraudWorkers()
+	app.startFraudRecovery()
+	app.startHoldExpiry()
+	app.startVaultReencryption()
+	app.startRegistry()
+	app.startWebhooks()
+	app.startReceipts()
+	app.startFraudSummarizer()
+	app.startFraudSpikeDetector()
+	app.startDailyCloser()
+
+	gin.SetMode(gin.ReleaseMode)
+	r := app.newRouter()
+
+	// Generated once every route is registered, so the spec can't miss one.
+	if app.openapi, err = app.openAPISpec(r.Routes()); err != nil {
+		log.Fatalf("Failed to generate OpenAPI spec: %v", err)
 	}
 
 	// Graceful shutdown
@@ -616,7 +904,13 @@ func main() {
 			log.Fatalf("Failed to start server: %v", err)
 		}
 	}()
+	if config.GRPCPort != "" {
+		if app.grpc, err = app.startGRPC(); err != nil {
+			log.Fatalf("Failed to start gRPC server: %v", err)
+		}
+	}
 
+	app.watchReloadSignal()
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
@@ -624,8 +918,33 @@ func main() {
 	app.log("info", "Shutting down server...", nil)
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
+	// Leave the registry first so discovery stops routing here while
+	// in-flight requests finish.
+	if app.registry != nil {
+		app.registry.Close(ctx)
+	}
+	if app.grpc != nil {
+		app.grpc.Stop(ctx)
+	}
+	// Feed connections are hijacked, so Shutdown wouldn't wait for them.
+	app.feed.Close()
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatal("Server forced to shutdown:", err)
 	}
+	// In-flight requests are done, so nothing else can be queued; give the
+	// fraud workers a moment to finish what is.
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelDrain()
+	if left := app.fraudPool.Drain(drainCtx); left > 0 {
+		app.log("warn", "Fraud queue not drained before shutdown", map[string]interface{}{"skipped": left})
+	}
+	app.webhooks.Close()
+	app.receipts.Close()
+	if err := app.publisher.Close(); err != nil {
+		app.log("warn", "Event bus publisher did not flush cleanly", map[string]interface{}{"error": err.Error()})
+	}
+	if app.spool != nil {
+		app.spool.Close()
+	}
 	app.log("info", "Server exited", nil)
 }
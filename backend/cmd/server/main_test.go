@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/infrasage/payflow/internal/config"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+// newTestApp returns an app with the default configuration, after apply
+// changes it, and no database or Redis.
+func newTestApp(t *testing.T, apply func(*Config)) *App {
+	t.Helper()
+	for _, f := range config.Schema {
+		t.Setenv(f.Env, "")
+	}
+	t.Setenv("CONFIG_FILE", "")
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if apply != nil {
+		apply(cfg)
+	}
+	app := &App{config: cfg, anomalies: newAnomalyDetector(), costs: newCostTracker(), latency: newLatencyTracker()}
+	app.logs = newLogger("error", "json", io.Discard)
+	if app.schemas, err = loadSchemas(); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.initOAuth(); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.initCursors(); err != nil {
+		t.Fatal(err)
+	}
+	return app
+}
+
+// serve sends a request with a JSON body, when body isn't nil, to h.
+func serve(h http.Handler, method, path string, body interface{}, headers map[string]string) *httptest.ResponseRecorder {
+	var r io.Reader
+	if body != nil {
+		raw, _ := json.Marshal(body)
+		r = bytes.NewReader(raw)
+	}
+	req := httptest.NewRequest(method, path, r)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
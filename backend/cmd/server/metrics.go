@@ -0,0 +1,183 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics follow the Prometheus/OpenMetrics conventions: base units
+// (seconds, bytes), counters suffixed with _total, and no unit-less ratios
+// disguised as counters.
+var (
+	registry = prometheus.NewRegistry()
+
+	transactionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "payflow_transactions_total",
+			Help: "Total number of transactions",
+		},
+		[]string{"status"},
+	)
+	httpRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "payflow_http_request_duration_seconds",
+			Help:    "HTTP request duration in seconds",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"method", "route", "code"},
+	)
+	cacheHitRatio = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "payflow_cache_hit_ratio",
+			Help: "Cache hit ratio",
+		},
+	)
+	dbConnectionsActive = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "payflow_db_connections_active",
+			Help: "Database connections in use across all pools",
+		},
+	)
+	memoryUsedBytes = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "payflow_memory_used_bytes",
+			Help: "Memory used in bytes",
+		},
+	)
+	requestsInFlight = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "payflow_http_requests_in_flight",
+			Help: "Number of HTTP requests currently in flight",
+		},
+	)
+	spoolDepth = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "payflow_spool_depth",
+			Help: "Number of transactions spooled locally awaiting database write",
+		},
+	)
+	spoolOperationsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "payflow_spool_operations_total",
+			Help: "Spool operations by type (enqueued, replayed, failed)",
+		},
+		[]string{"operation"},
+	)
+)
+
+// newTargetInfo builds the OpenMetrics target_info metric describing this
+// process, so every scrape can be joined against service identity.
+func newTargetInfo() prometheus.Gauge {
+	instance, _ := os.Hostname()
+	g := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "target_info",
+		Help: "Target metadata",
+		ConstLabels: prometheus.Labels{
+			"service_name":        "payflow-api",
+			"service_version":     appVersion,
+			"service_instance_id": instance,
+		},
+	})
+	g.Set(1)
+	return g
+}
+
+// registerMetrics registers every collector with region as a constant label,
+// so dashboards can split and compare regions.
+func registerMetrics(region string) {
+	prometheus.WrapRegistererWith(prometheus.Labels{"region": region}, registry).MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		newTargetInfo(),
+		transactionsTotal,
+		transactionAmount,
+		httpRequestDuration,
+		grpcRequestDuration,
+		cacheHitRatio,
+		dbConnectionsActive,
+		dbPoolInUse,
+		memoryUsedBytes,
+		requestsInFlight,
+		spoolDepth,
+		spoolOperationsTotal,
+		anomalyScore,
+		anomalyActive,
+		importLinesTotal,
+		panicsTotal,
+		incidentsActive,
+		failoverActive,
+		registryRegistered,
+		backpressureRejections,
+		rateLimitedTotal,
+		signatureReplaysTotal,
+		policyDecisionsTotal,
+		guardrailTripsTotal,
+		feedClients,
+		feedDroppedTotal,
+		cacheDegraded,
+		webhookDeliveriesTotal,
+		webhookBatchesTotal,
+		receiptEmailsTotal,
+		dbErrorsTotal,
+		kafkaMessagesTotal,
+		natsMessagesTotal,
+		natsConnected,
+		eventRelayLag,
+		eventRelayLagSeconds,
+		fraudQueueDepth,
+		fraudWorkers,
+		fraudAssessmentsTotal,
+		fraudDroppedTotal,
+		fraudResumedTotal,
+		fraudAlertRows,
+		fraudAlertSpikesTotal,
+		fraudAlertsSummarizedTotal,
+		eventSchemaViolationsTotal,
+		closeExceptionsTotal,
+		bankRequestsTotal,
+		outboundRequestsTotal,
+		outboundRequestDuration,
+		outboundRetriesTotal,
+		outboundCircuitsOpen,
+		lastClosedDay,
+		chaosLatencyMs,
+		chaosErrorRate,
+		chaosActive,
+	)
+}
+
+// metricsHandler serves the registry in OpenMetrics format when the scraper
+// asks for it (with _created samples for counters) and in the classic text
+// format otherwise.
+func metricsHandler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{
+		EnableOpenMetrics:                   true,
+		EnableOpenMetricsTextCreatedSamples: true,
+	})
+}
+
+func (app *App) metricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestsInFlight.Inc()
+		start := time.Now()
+
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequestDuration.
+			WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).
+			Observe(time.Since(start).Seconds())
+		app.incidents.Observe(c.Writer.Status())
+		requestsInFlight.Dec()
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pressly/goose/v3"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockKey is the advisory lock held while migrating, so replicas
+// starting together don't apply the same migration twice.
+const migrationLockKey = 7420315
+
+// migrateCommands are the goose commands `payflow migrate` runs. create and
+// fix would write into the embedded directory, and reset drops everything.
+var migrateCommands = []string{"up", "up-by-one", "up-to", "down", "down-to", "redo", "status", "version"}
+
+func init() {
+	goose.SetBaseFS(migrationFiles)
+	// Only fails for an unknown dialect name.
+	_ = goose.SetDialect("postgres")
+}
+
+// gooseLogger sends goose's progress lines to the structured log.
+type gooseLogger struct{ app *App }
+
+func (l gooseLogger) Printf(format string, v ...interface{}) {
+	l.app.log("info", "Migration: "+strings.TrimSpace(fmt.Sprintf(format, v...)), nil)
+}
+
+func (l gooseLogger) Fatalf(format string, v ...interface{}) {
+	l.app.log("error", "Migration: "+strings.TrimSpace(fmt.Sprintf(format, v...)), nil)
+	os.Exit(1)
+}
+
+// migrate applies pending migrations, as initDB does with MIGRATE_ON_START.
+func (app *App) migrate(ctx context.Context) error {
+	goose.SetLogger(gooseLogger{app})
+	return app.withMigrationLock(ctx, func() error {
+		before, _ := app.schemaVersion(ctx)
+		if err := goose.UpContext(ctx, app.db, "migrations"); err != nil {
+			return fmt.Errorf("failed to apply migrations: %w", err)
+		}
+		after, err := app.schemaVersion(ctx)
+		if err != nil {
+			return err
+		}
+		app.log("info", "Database schema up to date", map[string]interface{}{"from_version": before, "version": after})
+		return nil
+	})
+}
+
+func (app *App) withMigrationLock(ctx context.Context, fn func() error) error {
+	conn, err := app.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey); err != nil {
+		return fmt.Errorf("failed to take migration lock: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockKey)
+	return fn()
+}
+
+// schemaVersion is the database's migration version, 0 if it was never
+// migrated. Unlike goose's own lookup it doesn't create the version table,
+// so it is safe in checks.
+func (app *App) schemaVersion(ctx context.Context) (int64, error) {
+	var exists bool
+	if err := app.db.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, goose.TableName()).Scan(&exists); err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, nil
+	}
+	return goose.GetDBVersionContext(ctx, app.db)
+}
+
+// latestMigration is the version of the newest migration in the binary.
+func latestMigration() (int64, error) {
+	migrations, err := goose.CollectMigrations("migrations", 0, goose.MaxVersion)
+	if err != nil {
+		return 0, err
+	}
+	last, err := migrations.Last()
+	if err != nil {
+		return 0, err
+	}
+	return last.Version, nil
+}
+
+// checkMigrations compares the database's schema version with the
+// binary's. A database behind the binary is missing schema the code relies
+// on; one ahead of it was migrated by a newer release, which is expected
+// during a rollback.
+func (app *App) checkMigrations(ctx context.Context) SelfCheck {
+	check := SelfCheck{Name: "migrations", Critical: true}
+	current, err := app.schemaVersion(ctx)
+	if err != nil {
+		check.Status, check.Detail = "fail", err.Error()
+		return check
+	}
+	latest, err := latestMigration()
+	if err != nil {
+		check.Status, check.Detail = "fail", err.Error()
+		return check
+	}
+	switch {
+	case current < latest:
+		check.Status = "fail"
+		check.Detail = fmt.Sprintf("database is at version %d, %d pending up to %d; run payflow migrate up", current, latest-current, latest)
+	case current > latest:
+		check.Status = "warn"
+		check.Detail = fmt.Sprintf("database is at version %d, newer than this binary's %d", current, latest)
+	default:
+		check.Status, check.Detail = "ok", "at version "+strconv.FormatInt(current, 10)
+	}
+	return check
+}
+
+// runMigrateCommand implements `payflow migrate <command> [version]` and
+// returns the process exit code. It connects like the server does but
+// never migrates on its own.
+func (app *App) runMigrateCommand(args []string) int {
+	if len(args) == 0 || !containsString(migrateCommands, args[0]) {
+		fmt.Fprintf(os.Stderr, "usage: payflow migrate <%s> [version]\n", strings.Join(migrateCommands, "|"))
+		return 2
+	}
+	if err := app.connectDB(app.startupDeadline()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	ctx := context.Background()
+	err := app.withMigrationLock(ctx, func() error {
+		return goose.RunContext(ctx, args[0], app.db, "migrations", args[1:]...)
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,162 @@
+-- The schema as it stood when migrations were introduced. Everything is
+-- IF NOT EXISTS, so databases set up by earlier versions adopt it as
+-- version 1 without changes. There is no Down: rolling the baseline back
+-- would drop every table.
+
+-- +goose Up
+CREATE TABLE IF NOT EXISTS transactions (
+	id VARCHAR(36) PRIMARY KEY,
+	from_account VARCHAR(255) NOT NULL,
+	to_account VARCHAR(255) NOT NULL,
+	amount DECIMAL(15,2) NOT NULL,
+	description TEXT,
+	status VARCHAR(50) NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Ledger hash chain
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS chain_seq BIGSERIAL;
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS prev_hash VARCHAR(64);
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS hash VARCHAR(64);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_transactions_chain_seq ON transactions (chain_seq);
+
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS status_token VARCHAR(32);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_transactions_status_token ON transactions (status_token);
+
+CREATE TABLE IF NOT EXISTS demo_sessions (
+	id VARCHAR(36) PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	chaos TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMP NOT NULL
+);
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS session_id VARCHAR(36);
+CREATE INDEX IF NOT EXISTS idx_transactions_session ON transactions (session_id);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+	id VARCHAR(32) PRIMARY KEY,
+	owner VARCHAR(255) NOT NULL,
+	scopes TEXT NOT NULL DEFAULT '',
+	key_hash CHAR(64) NOT NULL,
+	created_by VARCHAR(255) NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	last_used_at TIMESTAMP,
+	revoked_at TIMESTAMP
+);
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS signing_secret VARCHAR(64) NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS transaction_audit (
+	id BIGSERIAL PRIMARY KEY,
+	transaction_id VARCHAR(36) NOT NULL,
+	action VARCHAR(64) NOT NULL,
+	actor VARCHAR(255) NOT NULL,
+	details JSONB,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_transaction_audit_txn ON transaction_audit (transaction_id, id);
+
+CREATE TABLE IF NOT EXISTS datasets (
+	name VARCHAR(64) PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	transactions JSONB NOT NULL,
+	audit JSONB NOT NULL
+);
+ALTER TABLE datasets ADD COLUMN IF NOT EXISTS accounts JSONB NOT NULL DEFAULT '[]';
+
+CREATE TABLE IF NOT EXISTS counterparties (
+	account VARCHAR(255) PRIMARY KEY,
+	merchant_name VARCHAR(255) NOT NULL,
+	category VARCHAR(64) NOT NULL DEFAULT '',
+	risk_tier VARCHAR(16) NOT NULL DEFAULT 'standard'
+);
+
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS region VARCHAR(32);
+CREATE INDEX IF NOT EXISTS idx_transactions_region ON transactions (region, created_at);
+
+-- Created whether or not TOKENIZATION_ENABLED is set, so turning it on
+-- later needs no migration.
+CREATE TABLE IF NOT EXISTS token_vault (
+	token VARCHAR(32) PRIMARY KEY,
+	fingerprint VARCHAR(64) NOT NULL UNIQUE,
+	ciphertext BYTEA NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS accounts (
+	id VARCHAR(255) PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	balance DECIMAL(15,2) NOT NULL DEFAULT 0 CHECK (balance >= 0),
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS fraud_rules (
+	name VARCHAR(64) PRIMARY KEY,
+	type VARCHAR(64) NOT NULL,
+	enabled BOOLEAN NOT NULL DEFAULT TRUE,
+	score DOUBLE PRECISION NOT NULL,
+	params JSONB NOT NULL DEFAULT '{}',
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS refund_of VARCHAR(36);
+CREATE INDEX IF NOT EXISTS idx_transactions_refund_of ON transactions (refund_of) WHERE refund_of IS NOT NULL;
+
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS erased_at TIMESTAMP;
+CREATE TABLE IF NOT EXISTS privacy_erasures (
+	id VARCHAR(36) PRIMARY KEY,
+	pseudonym VARCHAR(64) NOT NULL,
+	actor VARCHAR(255) NOT NULL,
+	reason TEXT NOT NULL DEFAULT '',
+	report JSONB NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS subject_exports (
+	id VARCHAR(36) PRIMARY KEY,
+	status VARCHAR(16) NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	completed_at TIMESTAMP,
+	expires_at TIMESTAMP NOT NULL,
+	error TEXT NOT NULL DEFAULT '',
+	archive BYTEA
+);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+	id VARCHAR(36) PRIMARY KEY,
+	url TEXT NOT NULL,
+	events TEXT[] NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	secret VARCHAR(64) NOT NULL,
+	session_id VARCHAR(36),
+	created_by VARCHAR(255) NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id VARCHAR(36) PRIMARY KEY,
+	webhook_id VARCHAR(36) NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+	event_id VARCHAR(36) NOT NULL,
+	event_type VARCHAR(64) NOT NULL,
+	payload BYTEA NOT NULL,
+	status VARCHAR(16) NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP,
+	last_status_code INTEGER,
+	last_error TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	delivered_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook ON webhook_deliveries (webhook_id, created_at DESC);
+
+CREATE TABLE IF NOT EXISTS fraud_alert_summaries (
+	day DATE NOT NULL,
+	session_id VARCHAR(36),
+	decision VARCHAR(16) NOT NULL,
+	alerts BIGINT NOT NULL,
+	total_score DOUBLE PRECISION NOT NULL,
+	max_score DOUBLE PRECISION NOT NULL,
+	rules JSONB NOT NULL DEFAULT '{}'
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_fraud_alert_summaries_key ON fraud_alert_summaries (day, (COALESCE(session_id, '')), decision);
+CREATE INDEX IF NOT EXISTS idx_transaction_audit_fraud ON transaction_audit (id) WHERE action = 'fraud_flagged';
@@ -0,0 +1,11 @@
+-- Sub-accounts, such as a merchant's per-store accounts, hang off a parent
+-- one level deep. Transfers inside one family are marked internal.
+
+-- +goose Up
+ALTER TABLE accounts ADD COLUMN parent_id VARCHAR(255) REFERENCES accounts(id) ON UPDATE CASCADE;
+CREATE INDEX idx_accounts_parent ON accounts (parent_id) WHERE parent_id IS NOT NULL;
+ALTER TABLE transactions ADD COLUMN internal BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- +goose Down
+ALTER TABLE transactions DROP COLUMN internal;
+ALTER TABLE accounts DROP COLUMN parent_id;
@@ -0,0 +1,10 @@
+-- The outcome of a transaction's fraud analysis, written in the same
+-- database transaction as its audit entry. Transactions stored before this
+-- migration, and those never queued for analysis, have none.
+
+-- +goose Up
+ALTER TABLE transactions ADD COLUMN fraud_status VARCHAR(16);
+CREATE INDEX idx_transactions_fraud_pending ON transactions (created_at) WHERE fraud_status = 'pending';
+
+-- +goose Down
+ALTER TABLE transactions DROP COLUMN fraud_status;
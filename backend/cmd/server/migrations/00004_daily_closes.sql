@@ -0,0 +1,59 @@
+-- End-of-day closes. A close freezes one UTC day of real traffic: its totals
+-- in daily_closes and every transaction as it stood in daily_close_entries.
+-- Both are insert-only, enforced by trigger. Later changes to a closed day
+-- are recorded in close_exceptions instead.
+
+-- +goose Up
+CREATE TABLE daily_closes (
+	day DATE PRIMARY KEY,
+	closed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	transactions INTEGER NOT NULL,
+	settled INTEGER NOT NULL,
+	debits DECIMAL(18,2) NOT NULL,
+	credits DECIMAL(18,2) NOT NULL,
+	balanced BOOLEAN NOT NULL,
+	ledger_head VARCHAR(64),
+	digest VARCHAR(64) NOT NULL
+);
+
+CREATE TABLE daily_close_entries (
+	day DATE NOT NULL,
+	transaction_id VARCHAR(36) NOT NULL,
+	status VARCHAR(50) NOT NULL,
+	amount DECIMAL(15,2) NOT NULL,
+	from_account VARCHAR(255),
+	to_account VARCHAR(255),
+	hash VARCHAR(64),
+	chain_seq BIGINT,
+	PRIMARY KEY (day, transaction_id)
+);
+
+CREATE TABLE close_exceptions (
+	id BIGSERIAL PRIMARY KEY,
+	day DATE NOT NULL REFERENCES daily_closes(day),
+	transaction_id VARCHAR(36) NOT NULL,
+	kind VARCHAR(16) NOT NULL,
+	detail JSONB NOT NULL DEFAULT '{}',
+	detected_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (day, transaction_id, kind)
+);
+CREATE INDEX idx_close_exceptions_detected ON close_exceptions (detected_at);
+
+-- +goose StatementBegin
+CREATE FUNCTION reject_close_change() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION '% is insert-only', TG_TABLE_NAME;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER daily_closes_immutable BEFORE UPDATE OR DELETE ON daily_closes
+	FOR EACH ROW EXECUTE FUNCTION reject_close_change();
+CREATE TRIGGER daily_close_entries_immutable BEFORE UPDATE OR DELETE ON daily_close_entries
+	FOR EACH ROW EXECUTE FUNCTION reject_close_change();
+
+-- +goose Down
+DROP TABLE close_exceptions;
+DROP TABLE daily_close_entries;
+DROP TABLE daily_closes;
+DROP FUNCTION reject_close_change();
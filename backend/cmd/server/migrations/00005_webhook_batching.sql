@@ -0,0 +1,13 @@
+-- Batched webhook delivery. A webhook with batch_max_size above zero gets
+-- its events POSTed as arrays, and each delivery remembers the batch it was
+-- last sent in.
+
+-- +goose Up
+ALTER TABLE webhooks ADD COLUMN batch_max_size INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE webhooks ADD COLUMN batch_flush_ms INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE webhook_deliveries ADD COLUMN batch_id VARCHAR(36);
+
+-- +goose Down
+ALTER TABLE webhook_deliveries DROP COLUMN batch_id;
+ALTER TABLE webhooks DROP COLUMN batch_flush_ms;
+ALTER TABLE webhooks DROP COLUMN batch_max_size;
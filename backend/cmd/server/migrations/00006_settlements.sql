@@ -0,0 +1,20 @@
+-- Settlement of closed days with the bank. Each closed day with settled
+-- volume gets one row, which stays pending until the bank books or rejects
+-- its transfer.
+
+-- +goose Up
+CREATE TABLE settlements (
+	day DATE PRIMARY KEY REFERENCES daily_closes (day),
+	amount DECIMAL(18,2) NOT NULL,
+	status VARCHAR(20) NOT NULL DEFAULT 'pending',
+	bank_transfer_id VARCHAR(64),
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	settled_at TIMESTAMP
+);
+
+CREATE INDEX idx_settlements_pending ON settlements (day) WHERE status = 'pending';
+
+-- +goose Down
+DROP TABLE settlements;
@@ -0,0 +1,11 @@
+-- Demo tenants: accounts that belong to a demo session, and API keys pinned
+-- to one, so a tenant's credentials only ever reach its own data.
+
+-- +goose Up
+ALTER TABLE accounts ADD COLUMN session_id VARCHAR(36);
+CREATE INDEX idx_accounts_session ON accounts (session_id) WHERE session_id IS NOT NULL;
+ALTER TABLE api_keys ADD COLUMN session_id VARCHAR(36);
+
+-- +goose Down
+ALTER TABLE api_keys DROP COLUMN session_id;
+ALTER TABLE accounts DROP COLUMN session_id;
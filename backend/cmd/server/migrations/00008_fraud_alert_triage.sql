@@ -0,0 +1,15 @@
+-- Triage of fraud alerts, the fraud_flagged audit entries. An alert without a
+-- row is open; removing the alert, when it is summarized or its demo session
+-- goes away, removes its triage with it.
+
+-- +goose Up
+CREATE TABLE fraud_alert_triage (
+	alert_id BIGINT PRIMARY KEY REFERENCES transaction_audit (id) ON DELETE CASCADE,
+	status VARCHAR(16) NOT NULL,
+	actor VARCHAR(255) NOT NULL,
+	note TEXT NOT NULL DEFAULT '',
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE fraud_alert_triage;
@@ -0,0 +1,12 @@
+-- Chain version 2 seals a transaction's parties and description apart from
+-- the chain hash, in content_hash, so rows whose content a data subject
+-- erasure rewrote still have their amount and timestamp verified. Rows
+-- sealed before this migration keep version 1 and its hash format.
+
+-- +goose Up
+ALTER TABLE transactions ADD COLUMN chain_version SMALLINT NOT NULL DEFAULT 1;
+ALTER TABLE transactions ADD COLUMN content_hash VARCHAR(64);
+
+-- +goose Down
+ALTER TABLE transactions DROP COLUMN content_hash;
+ALTER TABLE transactions DROP COLUMN chain_version;
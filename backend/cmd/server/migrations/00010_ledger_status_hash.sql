@@ -0,0 +1,9 @@
+-- Chain version 3 seals a transaction's status against its chain hash in
+-- status_hash, which every status change rewrites. Rows sealed before keep
+-- their version and have no status seal.
+
+-- +goose Up
+ALTER TABLE transactions ADD COLUMN status_hash VARCHAR(64);
+
+-- +goose Down
+ALTER TABLE transactions DROP COLUMN status_hash;
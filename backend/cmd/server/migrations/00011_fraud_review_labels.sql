@@ -0,0 +1,19 @@
+-- Analyst decisions on transactions held for fraud review, kept as labeled
+-- examples for tuning the rules: an approved transaction is labeled
+-- legitimate, a declined one fraud. assessment is the fraud_flagged scoring
+-- that held it, when it had been recorded by then.
+
+-- +goose Up
+CREATE TABLE fraud_review_labels (
+	transaction_id VARCHAR(36) PRIMARY KEY REFERENCES transactions (id) ON DELETE CASCADE,
+	label VARCHAR(16) NOT NULL,
+	assessment JSONB,
+	actor VARCHAR(255) NOT NULL,
+	note TEXT NOT NULL DEFAULT '',
+	labeled_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_transactions_held ON transactions (created_at) WHERE status = 'held';
+
+-- +goose Down
+DROP INDEX idx_transactions_held;
+DROP TABLE fraud_review_labels;
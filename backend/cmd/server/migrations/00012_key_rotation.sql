@@ -0,0 +1,25 @@
+-- Key IDs for rotating secrets. A vault entry records the key that sealed
+-- it, 1 being TOKEN_VAULT_KEY, and token_vault_keys logs which key new
+-- entries are sealed with, the latest row winning. A webhook records the
+-- version of its signing secret, sent with every delivery, and while a
+-- rotation is pending the next secret and when it takes over.
+
+-- +goose Up
+ALTER TABLE token_vault ADD COLUMN key_id VARCHAR(32) NOT NULL DEFAULT '1';
+CREATE INDEX idx_token_vault_key ON token_vault (key_id);
+CREATE TABLE token_vault_keys (
+	key_id VARCHAR(32) NOT NULL,
+	activated_by VARCHAR(255) NOT NULL,
+	activated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+ALTER TABLE webhooks ADD COLUMN secret_version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE webhooks ADD COLUMN next_secret VARCHAR(64);
+ALTER TABLE webhooks ADD COLUMN next_secret_at TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE webhooks DROP COLUMN next_secret_at;
+ALTER TABLE webhooks DROP COLUMN next_secret;
+ALTER TABLE webhooks DROP COLUMN secret_version;
+DROP TABLE token_vault_keys;
+DROP INDEX idx_token_vault_key;
+ALTER TABLE token_vault DROP COLUMN key_id;
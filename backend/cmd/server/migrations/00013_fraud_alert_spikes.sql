@@ -0,0 +1,22 @@
+-- Fraud alert spikes: windows in which a rule raised far more alerts than
+-- its baseline, one row per rule and window. The primary key lets only the
+-- first instance to detect a spike record and announce it. The index serves
+-- the per-window alert counts.
+
+-- +goose Up
+CREATE TABLE fraud_alert_spikes (
+	rule VARCHAR(128) NOT NULL,
+	window_end TIMESTAMP NOT NULL,
+	window_sec INTEGER NOT NULL,
+	alerts INTEGER NOT NULL,
+	baseline DOUBLE PRECISION NOT NULL,
+	stddev DOUBLE PRECISION NOT NULL,
+	z_score DOUBLE PRECISION NOT NULL,
+	detected_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (rule, window_end)
+);
+CREATE INDEX idx_transaction_audit_fraud_time ON transaction_audit (created_at) WHERE action = 'fraud_flagged';
+
+-- +goose Down
+DROP INDEX idx_transaction_audit_fraud_time;
+DROP TABLE fraud_alert_spikes;
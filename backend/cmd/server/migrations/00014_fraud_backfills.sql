@@ -0,0 +1,25 @@
+-- Fraud backfills: runs of a rule set over past transactions. Their alerts
+-- are fraud_backfilled audit entries tagged with the run's ID, apart from
+-- the live fraud_flagged ones. updated_at moves with every batch, so a run
+-- whose instance stopped is told apart from one still going.
+
+-- +goose Up
+CREATE TABLE fraud_backfills (
+	id VARCHAR(36) PRIMARY KEY,
+	from_time TIMESTAMP NOT NULL,
+	to_time TIMESTAMP NOT NULL,
+	rule_set_version VARCHAR(64) NOT NULL,
+	status VARCHAR(16) NOT NULL DEFAULT 'running',
+	scanned INTEGER NOT NULL DEFAULT 0,
+	flagged INTEGER NOT NULL DEFAULT 0,
+	newly_flagged INTEGER NOT NULL DEFAULT 0,
+	rules JSONB NOT NULL DEFAULT '{}',
+	error TEXT NOT NULL DEFAULT '',
+	created_by VARCHAR(255) NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	finished_at TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE fraud_backfills;
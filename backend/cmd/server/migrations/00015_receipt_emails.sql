@@ -0,0 +1,27 @@
+-- Email receipts. An account with an email address is sent a receipt for
+-- every transaction it pays that settles; receipt_emails is the outbox the
+-- mailer sends them from. A hard bounce stamps email_bounced_at, and no
+-- more receipts are queued for the account until its address changes.
+
+-- +goose Up
+ALTER TABLE accounts ADD COLUMN email VARCHAR(255);
+ALTER TABLE accounts ADD COLUMN email_bounced_at TIMESTAMP;
+
+CREATE TABLE receipt_emails (
+	id VARCHAR(36) PRIMARY KEY,
+	transaction_id VARCHAR(36) NOT NULL REFERENCES transactions (id) ON DELETE CASCADE,
+	account_id VARCHAR(255) NOT NULL REFERENCES accounts (id) ON DELETE CASCADE ON UPDATE CASCADE,
+	status VARCHAR(16) NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP,
+	last_error TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	sent_at TIMESTAMP,
+	UNIQUE (transaction_id, account_id)
+);
+CREATE INDEX idx_receipt_emails_due ON receipt_emails (next_attempt_at) WHERE status = 'pending';
+
+-- +goose Down
+DROP TABLE receipt_emails;
+ALTER TABLE accounts DROP COLUMN email_bounced_at;
+ALTER TABLE accounts DROP COLUMN email;
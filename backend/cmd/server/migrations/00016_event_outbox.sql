@@ -0,0 +1,23 @@
+-- Event bus outbox. Bus events are queued in event_outbox and published in
+-- ID order by a relay, which checkpoints the last event it published in
+-- event_relay_cursors, so a restart resumes where the relay left off.
+
+-- +goose Up
+CREATE TABLE event_outbox (
+	id BIGSERIAL PRIMARY KEY,
+	event_id VARCHAR(36) NOT NULL,
+	event_type VARCHAR(64) NOT NULL,
+	key VARCHAR(255) NOT NULL DEFAULT '',
+	payload BYTEA NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE event_relay_cursors (
+	relay VARCHAR(32) PRIMARY KEY,
+	last_event_id BIGINT NOT NULL,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE event_relay_cursors;
+DROP TABLE event_outbox;
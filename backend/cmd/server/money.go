@@ -0,0 +1,126 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// currencySpec describes how a currency is counted and written.
+type currencySpec struct {
+	exponent int // digits after the decimal point in the minor unit
+	symbol   string
+}
+
+var currencies = map[string]currencySpec{
+	"USD": {2, "$"},
+	"EUR": {2, "€"},
+	"GBP": {2, "£"},
+	"CHF": {2, "CHF"},
+	"JPY": {0, "¥"},
+}
+
+// localeSpec holds the number conventions of a locale.
+type localeSpec struct {
+	group        string
+	decimal      string
+	symbolSuffix bool // "1.234,50 €" rather than "€1,234.50"
+}
+
+var locales = map[string]localeSpec{
+	"en-US": {",", ".", false},
+	"en-GB": {",", ".", false},
+	"de-DE": {".", ",", true},
+	"fr-FR": {" ", ",", true},
+	"ja-JP": {",", ".", false},
+}
+
+// Money is a monetary amount as an exact integer count of minor units, with
+// a plain decimal string and a display string for the caller's locale.
+type Money struct {
+	Currency   string `json:"currency"`
+	MinorUnits int64  `json:"minor_units"`
+	Amount     string `json:"amount"`
+	Formatted  string `json:"formatted"`
+	Locale     string `json:"locale"`
+}
+
+// minorUnitScale is the factor between major and minor units of currency.
+func minorUnitScale(currency string) int64 {
+	scale := int64(1)
+	for i := 0; i < currencies[currency].exponent; i++ {
+		scale *= 10
+	}
+	return scale
+}
+
+func newMoney(minor int64, currency, locale string) Money {
+	spec := currencies[currency]
+	loc := locales[locale]
+
+	sign, abs := "", minor
+	if minor < 0 {
+		sign, abs = "-", -minor
+	}
+	scale := minorUnitScale(currency)
+	whole := strconv.FormatInt(abs/scale, 10)
+	frac := ""
+	if spec.exponent > 0 {
+		frac = strconv.FormatInt(abs%scale, 10)
+		frac = strings.Repeat("0", spec.exponent-len(frac)) + frac
+	}
+
+	amount := sign + whole
+	if frac != "" {
+		amount += "." + frac
+	}
+
+	// Group the integer part in threes from the right.
+	var grouped strings.Builder
+	for i, d := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			grouped.WriteString(loc.group)
+		}
+		grouped.WriteRune(d)
+	}
+	number := grouped.String()
+	if frac != "" {
+		number += loc.decimal + frac
+	}
+	symbol := spec.symbol
+	if utf8.RuneCountInString(symbol) > 1 {
+		symbol += " " // codes like CHF need a space, signs like $ don't
+	}
+	formatted := sign + symbol + number
+	if loc.symbolSuffix {
+		formatted = sign + number + " " + spec.symbol
+	}
+
+	return Money{Currency: currency, MinorUnits: minor, Amount: amount, Formatted: formatted, Locale: locale}
+}
+
+// languageLocales maps a bare language tag to the locale used for it.
+var languageLocales = map[string]string{"en": "en-US", "de": "de-DE", "fr": "fr-FR", "ja": "ja-JP"}
+
+// requestLocale picks the display locale: ?locale= if supported, else the
+// first supported language in Accept-Language, else STATS_LOCALE.
+func (app *App) requestLocale(c *gin.Context) string {
+	candidates := []string{c.Query("locale")}
+	for _, part := range strings.Split(c.GetHeader("Accept-Language"), ",") {
+		tag, _, _ := strings.Cut(strings.TrimSpace(part), ";")
+		candidates = append(candidates, tag)
+	}
+	for _, tag := range candidates {
+		lang, region, _ := strings.Cut(tag, "-")
+		lang = strings.ToLower(lang)
+		if _, ok := locales[lang+"-"+strings.ToUpper(region)]; ok {
+			return lang + "-" + strings.ToUpper(region)
+		}
+		if name, ok := languageLocales[lang]; ok {
+			return name
+		}
+	}
+	return app.config.StatsLocale
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/infrasage/payflow/internal/dbtest"
+)
+
+// GET /api/stats reports revenue as exact minor units, however large, and
+// formats it for the caller's locale.
+func TestStatsRevenue(t *testing.T) {
+	tests := []struct {
+		name     string
+		currency string
+		minor    int64
+		query    string
+		headers  map[string]string
+		want     Money
+	}{
+		{"default locale", "USD", 123456789, "", nil,
+			Money{Currency: "USD", MinorUnits: 123456789, Amount: "1234567.89", Formatted: "$1,234,567.89", Locale: "en-US"}},
+		{"beyond float precision", "USD", 900719925474099312, "", nil,
+			Money{Currency: "USD", MinorUnits: 900719925474099312, Amount: "9007199254740993.12", Formatted: "$9,007,199,254,740,993.12", Locale: "en-US"}},
+		{"Accept-Language", "EUR", 123456789, "", map[string]string{"Accept-Language": "de-CH;q=0.9, de;q=0.8"},
+			Money{Currency: "EUR", MinorUnits: 123456789, Amount: "1234567.89", Formatted: "1.234.567,89\u00a0€", Locale: "de-DE"}},
+		{"locale parameter wins", "EUR", -50, "?locale=fr-FR", map[string]string{"Accept-Language": "de-DE"},
+			Money{Currency: "EUR", MinorUnits: -50, Amount: "-0.50", Formatted: "-0,50\u00a0€", Locale: "fr-FR"}},
+		{"no minor unit", "JPY", 1500000, "?locale=ja-JP", nil,
+			Money{Currency: "JPY", MinorUnits: 1500000, Amount: "1500000", Formatted: "¥1,500,000", Locale: "ja-JP"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t, func(c *Config) { c.Currency = tt.currency })
+			app.db = dbtest.New(func(q dbtest.Query) (*dbtest.Rows, error) {
+				switch {
+				case q.HasPrefix("SELECT ROUND(COALESCE(SUM("):
+					if scale := q.Args[1].(int64); scale != minorUnitScale(tt.currency) {
+						t.Errorf("summed at scale %d", scale)
+					}
+					return dbtest.NewRows("revenue").Add(tt.minor), nil
+				case q.HasPrefix("SELECT COUNT(*) FROM transactions WHERE session_id"):
+					return dbtest.NewRows("count").Add(int64(4)), nil
+				case q.HasPrefix("SELECT COUNT(*) FROM transactions WHERE status IN"):
+					return dbtest.NewRows("count").Add(int64(3)), nil
+				}
+				return nil, dbtest.Unexpected(q)
+			}).Open(t)
+			w := serve(app.newRouter(), http.MethodGet, "/api/stats"+tt.query, nil, tt.headers)
+			if w.Code != http.StatusOK {
+				t.Fatalf("GET /api/stats = %d %s", w.Code, w.Body)
+			}
+			var resp struct {
+				Revenue      Money   `json:"revenue"`
+				Transactions int     `json:"transactions"`
+				SuccessRate  float64 `json:"success_rate"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Revenue != tt.want {
+				t.Errorf("revenue %+v, want %+v", resp.Revenue, tt.want)
+			}
+			if resp.Transactions != 4 || resp.SuccessRate != 75 {
+				t.Errorf("transactions %d, success rate %v", resp.Transactions, resp.SuccessRate)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// natsFlushTimeout bounds how long Close waits for buffered messages and
+// outstanding JetStream acks.
+const natsFlushTimeout = 5 * time.Second
+
+var (
+	natsMessagesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "payflow_nats_messages_total",
+			Help: "Events handed to NATS by subject and result (published, failed)",
+		},
+		[]string{"subject", "result"},
+	)
+	natsConnected = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "payflow_nats_connected",
+		Help: "1 while the event bus connection to NATS is up",
+	})
+)
+
+// natsPublisher publishes to core NATS, or to a JetStream stream when
+// NATS_JETSTREAM is set. Core NATS is fire-and-forget: a message counts as
+// published once the client has it, and subscribers that are offline miss
+// it. JetStream messages count once the stream has acknowledged them, and
+// carry the event ID as Nats-Msg-Id so a retried publish isn't stored twice.
+//
+// The client reconnects forever. While it is disconnected, messages are
+// buffered in memory (up to the client's 8MB reconnect buffer) and sent on
+// reconnect; beyond that they fail. Until the first connection succeeds
+// they fail too, since the client can't know yet that the server takes
+// headers.
+type natsPublisher struct {
+	app *App
+	nc  *nats.Conn
+	js  nats.JetStreamContext
+	// ready is closed once nc and js are set, which connection callbacks
+	// can otherwise beat.
+	ready chan struct{}
+}
+
+// newNATSPublisher connects to NATS_URL. An unreachable server doesn't hold
+// up startup: the client keeps trying in the background.
+func newNATSPublisher(app *App) EventPublisher {
+	p := &natsPublisher{app: app, ready: make(chan struct{})}
+	defer close(p.ready)
+	cfg := app.config
+	nc, err := nats.Connect(cfg.NATSURL,
+		nats.Name("payflow-api-"+cfg.Region),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(2*time.Second),
+		nats.ConnectHandler(p.connected),
+		// Also called when the first connection only succeeds on a retry.
+		nats.ReconnectHandler(p.connected),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			natsConnected.Set(0)
+			fields := map[string]interface{}{}
+			if err != nil {
+				fields["error"] = err.Error()
+			}
+			app.log("warn", "NATS connection lost, reconnecting", fields)
+		}),
+	)
+	if err != nil {
+		// Only a malformed URL or option gets here; connection failures are
+		// retried.
+		app.log("error", "NATS publisher not started, events will be dropped", map[string]interface{}{"error": err.Error()})
+		return noopPublisher{}
+	}
+	p.nc = nc
+	if cfg.NATSJetStream {
+		js, err := nc.JetStream(nats.PublishAsyncMaxPending(1024))
+		if err != nil {
+			app.log("error", "NATS JetStream unavailable, events will be dropped", map[string]interface{}{"error": err.Error()})
+			nc.Close()
+			return noopPublisher{}
+		}
+		p.js = js
+	}
+	app.log("info", "NATS publishing enabled", map[string]interface{}{
+		"connected":            nc.IsConnected(),
+		"jetstream":            cfg.NATSJetStream,
+		"transactions_subject": cfg.NATSSubjectTransactions,
+		"fraud_alerts_subject": cfg.NATSSubjectFraudAlerts,
+	})
+	return p
+}
+
+// connected runs on the first connection and every reconnect. With
+// JetStream it makes sure the stream exists, since the server may be new.
+func (p *natsPublisher) connected(nc *nats.Conn) {
+	<-p.ready
+	natsConnected.Set(1)
+	p.app.log("info", "NATS connected", map[string]interface{}{"url": nc.ConnectedUrlRedacted()})
+	if p.js != nil {
+		if err := p.ensureStream(); err != nil {
+			p.app.log("error", "Failed to set up NATS stream", map[string]interface{}{"stream": p.app.config.NATSStream, "error": err.Error()})
+		}
+	}
+}
+
+// ensureStream creates NATS_STREAM over both subjects unless it exists. An
+// existing stream is used as configured, so retention can be tuned on the
+// server.
+func (p *natsPublisher) ensureStream() error {
+	cfg := p.app.config
+	if _, err := p.js.StreamInfo(cfg.NATSStream); err == nil {
+		return nil
+	} else if !errors.Is(err, nats.ErrStreamNotFound) {
+		return err
+	}
+	_, err := p.js.AddStream(&nats.StreamConfig{
+		Name:     cfg.NATSStream,
+		Subjects: []string{cfg.NATSSubjectTransactions, cfg.NATSSubjectFraudAlerts},
+		Storage:  nats.FileStorage,
+	})
+	if err == nil {
+		p.app.log("info", "NATS stream created", map[string]interface{}{"stream": cfg.NATSStream})
+	}
+	return err
+}
+
+func (p *natsPublisher) PublishTransaction(ctx context.Context, txn Transaction) {
+	p.publish(ctx, p.app.config.NATSSubjectTransactions, StreamTransactionCreated, txn)
+}
+
+func (p *natsPublisher) PublishFraudAlert(ctx context.Context, txn Transaction, a FraudAssessment) {
+	p.publish(ctx, p.app.config.NATSSubjectFraudAlerts, StreamFraudAlertRaised, fraudAlertData(txn, a))
+}
+
+func (p *natsPublisher) subject(eventType string) string {
+	if eventType == StreamFraudAlertRaised {
+		return p.app.config.NATSSubjectFraudAlerts
+	}
+	return p.app.config.NATSSubjectTransactions
+}
+
+func natsMessage(subject, eventType string, value []byte) *nats.Msg {
+	msg := &nats.Msg{Subject: subject, Data: value, Header: nats.Header{}}
+	msg.Header.Set("event_type", eventType)
+	return msg
+}
+
+// send publishes events in order and returns how many got through. Nothing
+// is sent while disconnected, so events wait in the outbox rather than in
+// the client's reconnect buffer, which a restart would lose. With JetStream
+// each event waits for the stream's ack.
+func (p *natsPublisher) send(ctx context.Context, events []outboxEvent) (int, error) {
+	if !p.nc.IsConnected() {
+		return 0, fmt.Errorf("not connected to NATS (%s)", p.nc.Status())
+	}
+	for i, e := range events {
+		subject := p.subject(e.eventType)
+		msg := natsMessage(subject, e.eventType, e.payload)
+		var err error
+		if p.js == nil {
+			err = p.nc.PublishMsg(msg)
+		} else {
+			_, err = p.js.PublishMsg(msg, nats.MsgId(e.eventID), nats.Context(ctx))
+		}
+		if err != nil {
+			p.failed(ctx, subject, e.eventType, err)
+			return i, err
+		}
+		natsMessagesTotal.WithLabelValues(subject, "published").Inc()
+	}
+	return len(events), nil
+}
+
+func (p *natsPublisher) publish(ctx context.Context, subject, eventType string, data interface{}) {
+	event, value, ok := p.app.encodeStreamEvent(ctx, eventType, data)
+	if !ok {
+		return
+	}
+	msg := natsMessage(subject, eventType, value)
+
+	if p.js == nil {
+		if err := p.nc.PublishMsg(msg); err != nil {
+			p.failed(ctx, subject, eventType, err)
+			return
+		}
+		natsMessagesTotal.WithLabelValues(subject, "published").Inc()
+		return
+	}
+	ack, err := p.js.PublishMsgAsync(msg, nats.MsgId(event.ID))
+	if err != nil {
+		p.failed(ctx, subject, eventType, err)
+		return
+	}
+	go func() {
+		select {
+		case <-ack.Ok():
+			natsMessagesTotal.WithLabelValues(subject, "published").Inc()
+		case err := <-ack.Err():
+			p.failed(context.Background(), subject, eventType, err)
+		}
+	}()
+}
+
+func (p *natsPublisher) failed(ctx context.Context, subject, eventType string, err error) {
+	natsMessagesTotal.WithLabelValues(subject, "failed").Inc()
+	p.app.logCtx(ctx, "warn", "NATS publish failed", map[string]interface{}{"subject": subject, "event_type": eventType, "error": err.Error()})
+}
+
+// Health fails while the client is not connected, when messages are only
+// being buffered.
+func (p *natsPublisher) Health() error {
+	if !p.nc.IsConnected() {
+		return fmt.Errorf("not connected to NATS (%s)", p.nc.Status())
+	}
+	return nil
+}
+
+// Close waits briefly for outstanding JetStream acks and buffered messages,
+// then disconnects.
+func (p *natsPublisher) Close() error {
+	defer p.nc.Close()
+	if p.js != nil {
+		select {
+		case <-p.js.PublishAsyncComplete():
+		case <-time.After(natsFlushTimeout):
+			return errors.New("timed out waiting for JetStream acks")
+		}
+	}
+	if !p.nc.IsConnected() {
+		return nil
+	}
+	return p.nc.FlushTimeout(natsFlushTimeout)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const principalContextKey = "payflow.principal"
+
+// OAuthClient is a machine client allowed to use the client credentials grant.
+type OAuthClient struct {
+	ID     string
+	Secret string
+	Scopes []string
+}
+
+// Principal is the authenticated caller behind a request. Machine clients
+// carry scopes; human operators from OIDC or demo tokens carry roles, which
+// grant scopes through roleScopes. A principal with a SessionID, such as a
+// tenant's API key, only ever works in that demo session.
+type Principal struct {
+	Subject   string
+	Scopes    []string
+	Roles     []string
+	Source    string
+	SessionID string
+	// KeyID is the ID of the API key the caller authenticated with.
+	KeyID string
+}
+
+func (p *Principal) HasScope(scope string) bool {
+	return containsString(p.Scopes, scope) || rolesGrant(p.Roles, scope)
+}
+
+func (p *Principal) HasRole(role string) bool {
+	return containsString(p.Roles, role)
+}
+
+// ServiceClaims are the JWT claims PayFlow puts in issued access tokens.
+type ServiceClaims struct {
+	Scope string   `json:"scope"`
+	Roles []string `json:"roles,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// parseOAuthClients parses "id:secret:scope scope;id2:secret2:scope".
+func parseOAuthClients(spec string) (map[string]OAuthClient, error) {
+	clients := map[string]OAuthClient{}
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("client entry %q must be id:secret:scopes", parts[0])
+		}
+		clients[parts[0]] = OAuthClient{ID: parts[0], Secret: parts[1], Scopes: strings.Fields(parts[2])}
+	}
+	return clients, nil
+}
+
+func (app *App) initOAuth() error {
+	clients, err := parseOAuthClients(app.config.OAuthClients)
+	if err != nil {
+		return fmt.Errorf("invalid OAUTH_CLIENTS: %w", err)
+	}
+	app.oauthClients = clients
+
+	if app.config.OIDCIssuer != "" {
+		if app.oidc, err = newOIDCVerifier(app.config, app.newOutboundClient("oidc", outboundOptions{Timeout: 5 * time.Second, Retries: 1})); err != nil {
+			return fmt.Errorf("invalid OIDC configuration: %w", err)
+		}
+		app.log("info", "OIDC operator authentication enabled", map[string]interface{}{"issuer": app.config.OIDCIssuer})
+	}
+
+	app.oauthKey = []byte(app.config.OAuthSigningKey)
+	if len(app.oauthKey) == 0 {
+		app.oauthKey = make([]byte, 32)
+		if _, err := rand.Read(app.oauthKey); err != nil {
+			return err
+		}
+		if len(clients) > 0 || app.config.DemoTokensEnabled {
+			app.log("warn", "OAUTH_SIGNING_KEY not set, using an ephemeral key; tokens will not survive restarts or work across replicas", nil)
+		}
+	}
+	return nil
+}
+
+func oauthError(c *gin.Context, status int, code, description string) {
+	c.JSON(status, gin.H{"error": code, "error_description": description})
+}
+
+// tokenHandler implements the OAuth2 client credentials grant (RFC 6749
+// section 4.4). Client credentials may be sent via HTTP Basic or form fields.
+func (app *App) tokenHandler(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	if c.PostForm("grant_type") != "client_credentials" {
+		oauthError(c, http.StatusBadRequest, "unsupported_grant_type", "only client_credentials is supported")
+		return
+	}
+
+	id, secret, ok := c.Request.BasicAuth()
+	if !ok {
+		id, secret = c.PostForm("client_id"), c.PostForm("client_secret")
+	}
+	client, known := app.oauthClients[id]
+	if !known || subtle.ConstantTimeCompare([]byte(secret), []byte(client.Secret)) != 1 {
+		c.Header("WWW-Authenticate", `Basic realm="payflow"`)
+		oauthError(c, http.StatusUnauthorized, "invalid_client", "unknown client or bad secret")
+		return
+	}
+
+	scopes := client.Scopes
+	if requested := strings.Fields(c.PostForm("scope")); len(requested) > 0 {
+		for _, s := range requested {
+			if !containsString(client.Scopes, s) {
+				oauthError(c, http.StatusBadRequest, "invalid_scope", fmt.Sprintf("scope %q not allowed for client", s))
+				return
+			}
+		}
+		scopes = requested
+	}
+
+	ttl := time.Duration(app.config.OAuthTokenTTLSec) * time.Second
+	now := time.Now()
+	claims := ServiceClaims{
+		Scope: strings.Join(scopes, " "),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    app.config.OAuthIssuer,
+			Subject:   client.ID,
+			Audience:  jwt.ClaimStrings{app.config.OAuthIssuer},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			ID:        newStatusToken(),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(app.oauthKey)
+	if err != nil {
+		oauthError(c, http.StatusInternalServerError, "server_error", "failed to sign token")
+		return
+	}
+
+	app.eventCtx(c.Request.Context(), "info", EventTokenIssued, client.ID, "Access token issued", map[string]interface{}{"scope": claims.Scope})
+	c.JSON(http.StatusOK, gin.H{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int(ttl.Seconds()),
+		"scope":        claims.Scope,
+	})
+}
+
+// validateServiceToken verifies signature, issuer, audience and expiry of a
+// token issued by tokenHandler.
+func (app *App) validateServiceToken(raw string) (*Principal, error) {
+	claims := &ServiceClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		return app.oauthKey, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(app.config.OAuthIssuer),
+		jwt.WithAudience(app.config.OAuthIssuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &Principal{Subject: claims.Subject, Scopes: strings.Fields(claims.Scope), Roles: claims.Roles, Source: "oauth"}, nil
+}
+
+func bearerToken(c *gin.Context) string {
+	h := c.GetHeader("Authorization")
+	if len(h) > 7 && strings.EqualFold(h[:7], "bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	return ""
+}
+
+func principalFrom(c *gin.Context) *Principal {
+	if v, ok := c.Get(principalContextKey); ok {
+		return v.(*Principal)
+	}
+	return nil
+}
+
+// requestActor names the authenticated caller behind a request, or
+// "anonymous".
+func requestActor(c *gin.Context) string {
+	if p := principalFrom(c); p != nil {
+		return p.Source + ":" + p.Subject
+	}
+	return "anonymous"
+}
+
+// serviceAuthMiddleware validates bearer tokens when present and attaches the
+// resulting Principal. Tokens from the configured OIDC issuer go to the OIDC
+// verifier; everything else must be a token issued by /oauth/token. Invalid
+// tokens are rejected; missing ones are left to requireAuthMiddleware.
+func (app *App) serviceAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		raw := bearerToken(c)
+		if raw == "" {
+			c.Next()
+			return
+		}
+
+		var principal *Principal
+		var err error
+		if app.oidc != nil && app.oidc.Handles(raw) {
+			principal, err = app.oidc.Verify(raw)
+		} else {
+			principal, err = app.validateServiceToken(raw)
+		}
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer realm="payflow", error="invalid_token"`)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid bearer token"})
+			c.Abort()
+			return
+		}
+		c.Set(principalContextKey, principal)
+		c.Next()
+	}
+}
+
+// requireAuthMiddleware rejects anonymous callers, those with neither a bearer
+// token nor an API key, when OAUTH_REQUIRED is set.
+func (app *App) requireAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if app.config.OAuthRequired && principalFrom(c) == nil {
+			c.Header("WWW-Authenticate", `Bearer realm="payflow"`)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Bearer token or API key required"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// requireScope rejects authenticated callers that lack scope. Anonymous
+// callers are left to requireAuthMiddleware's OAUTH_REQUIRED policy.
+func requireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if p := principalFrom(c); p != nil && !p.HasScope(scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Scope %q required", scope)})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func requestToken(h http.Handler, form url.Values, id, secret string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if id != "" {
+		req.SetBasicAuth(id, secret)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestTokenEndpoint(t *testing.T) {
+	app := newTestApp(t, func(c *Config) {
+		c.OAuthClients = "reporting:s3cret:transactions:read accounts:read"
+		c.OAuthSigningKey = "signing-key"
+	})
+	r := app.newRouter()
+	grant := url.Values{"grant_type": {"client_credentials"}}
+
+	tests := []struct {
+		name       string
+		form       url.Values
+		id, secret string
+		code       int
+		error      string
+		scope      string
+	}{
+		{"basic auth", grant, "reporting", "s3cret", http.StatusOK, "", "transactions:read accounts:read"},
+		{"form credentials and a narrower scope",
+			url.Values{"grant_type": {"client_credentials"}, "client_id": {"reporting"}, "client_secret": {"s3cret"}, "scope": {"accounts:read"}},
+			"", "", http.StatusOK, "", "accounts:read"},
+		{"scope the client lacks", url.Values{"grant_type": {"client_credentials"}, "scope": {"transactions:write"}}, "reporting", "s3cret", http.StatusBadRequest, "invalid_scope", ""},
+		{"bad secret", grant, "reporting", "guess", http.StatusUnauthorized, "invalid_client", ""},
+		{"unknown client", grant, "nobody", "s3cret", http.StatusUnauthorized, "invalid_client", ""},
+		{"password grant", url.Values{"grant_type": {"password"}}, "reporting", "s3cret", http.StatusBadRequest, "unsupported_grant_type", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := requestToken(r, tt.form, tt.id, tt.secret)
+			var resp struct {
+				AccessToken string `json:"access_token"`
+				TokenType   string `json:"token_type"`
+				Scope       string `json:"scope"`
+				Error       string `json:"error"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &resp)
+			if w.Code != tt.code || resp.Error != tt.error || resp.Scope != tt.scope {
+				t.Fatalf("%d %s, want %d with error %q and scope %q", w.Code, w.Body, tt.code, tt.error, tt.scope)
+			}
+			if w.Header().Get("Cache-Control") != "no-store" {
+				t.Errorf("Cache-Control %q", w.Header().Get("Cache-Control"))
+			}
+			if tt.code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without WWW-Authenticate")
+			}
+			if tt.code == http.StatusOK && (resp.AccessToken == "" || resp.TokenType != "Bearer") {
+				t.Errorf("token %+v", resp)
+			}
+		})
+	}
+}
+
+func TestServiceTokens(t *testing.T) {
+	app := newTestApp(t, func(c *Config) {
+		c.OAuthClients = "reporting:s3cret:transactions:read accounts:read"
+		c.OAuthSigningKey = "signing-key"
+		c.OAuthRequired = true
+	})
+	r := app.newRouter()
+	token := func(scope string) string {
+		w := requestToken(r, url.Values{"grant_type": {"client_credentials"}, "scope": {scope}}, "reporting", "s3cret")
+		var resp struct {
+			AccessToken string `json:"access_token"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp.AccessToken
+	}
+	sign := func(key string, expires time.Time) string {
+		claims := ServiceClaims{Scope: "accounts:read", RegisteredClaims: jwt.RegisteredClaims{
+			Issuer: app.config.OAuthIssuer, Subject: "reporting", Audience: jwt.ClaimStrings{app.config.OAuthIssuer}, ExpiresAt: jwt.NewNumericDate(expires),
+		}}
+		raw, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(key))
+		return raw
+	}
+	read := token("accounts:read")
+
+	// There is no database, so a caller let through gets a 503.
+	tests := []struct {
+		name  string
+		token string
+		code  int
+	}{
+		{"token with the scope", read, http.StatusServiceUnavailable},
+		{"token without the scope", token("transactions:read"), http.StatusForbidden},
+		{"no token", "", http.StatusUnauthorized},
+		{"tampered token", read[:len(read)-2] + "xx", http.StatusUnauthorized},
+		{"signed with the key", sign("signing-key", time.Now().Add(time.Hour)), http.StatusServiceUnavailable},
+		{"signed with another key", sign("other-key", time.Now().Add(time.Hour)), http.StatusUnauthorized},
+		{"expired", sign("signing-key", time.Now().Add(-time.Minute)), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := map[string]string{}
+			if tt.token != "" {
+				headers["Authorization"] = "Bearer " + tt.token
+			}
+			if w := serve(r, http.MethodGet, "/api/accounts", nil, headers); w.Code != tt.code {
+				t.Errorf("%d %s, want %d", w.Code, w.Body, tt.code)
+			}
+		})
+	}
+}
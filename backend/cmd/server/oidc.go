@@ -0,0 +1,251 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwksMinRefresh bounds how often an unknown kid can force a JWKS refetch.
+const jwksMinRefresh = 30 * time.Second
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// OIDCVerifier validates tokens issued by an external OpenID Connect
+// provider and maps their group claim onto PayFlow roles.
+type OIDCVerifier struct {
+	issuer      string
+	audience    string
+	jwksURL     string
+	groupsClaim string
+	roleMap     map[string]string
+	ttl         time.Duration
+	client      *http.Client
+
+	mu      sync.RWMutex
+	keys    map[string]interface{}
+	fetched time.Time
+}
+
+// parseRoleMap parses "group=role,group2=role2".
+func parseRoleMap(spec string) (map[string]string, error) {
+	m := map[string]string{}
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		group, role, ok := strings.Cut(pair, "=")
+		if !ok || group == "" || role == "" {
+			return nil, fmt.Errorf("role mapping %q must be group=role", pair)
+		}
+		m[group] = role
+	}
+	return m, nil
+}
+
+func newOIDCVerifier(config *Config, client *http.Client) (*OIDCVerifier, error) {
+	roleMap, err := parseRoleMap(config.OIDCRoleMap)
+	if err != nil {
+		return nil, err
+	}
+	return &OIDCVerifier{
+		issuer:      strings.TrimSuffix(config.OIDCIssuer, "/"),
+		audience:    config.OIDCAudience,
+		jwksURL:     config.OIDCJWKSURL,
+		groupsClaim: config.OIDCGroupsClaim,
+		roleMap:     roleMap,
+		ttl:         time.Duration(config.OIDCJWKSCacheSec) * time.Second,
+		client:      client,
+		keys:        map[string]interface{}{},
+	}, nil
+}
+
+// Handles reports whether a raw (unverified) token claims to come from the
+// configured issuer, so the caller can route it here instead of to the
+// embedded token validator.
+func (v *OIDCVerifier) Handles(raw string) bool {
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(raw, claims); err != nil {
+		return false
+	}
+	iss, _ := claims.GetIssuer()
+	return strings.TrimSuffix(iss, "/") == v.issuer
+}
+
+func (v *OIDCVerifier) Verify(raw string) (*Principal, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return v.key(kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384"}),
+		jwt.WithIssuer(v.issuer),
+		jwt.WithAudience(v.audience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	subject, _ := claims.GetSubject()
+	if email, ok := claims["email"].(string); ok && email != "" {
+		subject = email
+	}
+
+	var roles []string
+	if groups, ok := claims[v.groupsClaim].([]interface{}); ok {
+		for _, g := range groups {
+			name, _ := g.(string)
+			if role, ok := v.roleMap[name]; ok && !containsString(roles, role) {
+				roles = append(roles, role)
+			}
+		}
+	}
+	return &Principal{Subject: subject, Roles: roles, Source: "oidc"}, nil
+}
+
+// key returns the verification key for kid, refetching the JWKS when the
+// cache is stale or the kid is unknown (key rotation at the provider).
+func (v *OIDCVerifier) key(kid string) (interface{}, error) {
+	v.mu.RLock()
+	k, ok := v.keys[kid]
+	fresh := time.Since(v.fetched) < v.ttl
+	recent := time.Since(v.fetched) < jwksMinRefresh
+	v.mu.RUnlock()
+	if ok && fresh {
+		return k, nil
+	}
+	if !ok && recent {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	if err := v.refresh(); err != nil {
+		if ok {
+			return k, nil
+		}
+		return nil, err
+	}
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if k, ok := v.keys[kid]; ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+func (v *OIDCVerifier) discoverJWKSURL() (string, error) {
+	if v.jwksURL != "" {
+		return v.jwksURL, nil
+	}
+	var doc struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := v.getJSON(v.issuer+"/.well-known/openid-configuration", &doc); err != nil {
+		return "", fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+	if doc.JWKSURI == "" {
+		return "", fmt.Errorf("OIDC discovery document has no jwks_uri")
+	}
+	v.jwksURL = doc.JWKSURI
+	return v.jwksURL, nil
+}
+
+func (v *OIDCVerifier) refresh() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	url, err := v.discoverJWKSURL()
+	if err != nil {
+		v.fetched = time.Now()
+		return err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	err = v.getJSON(url, &set)
+	v.fetched = time.Now()
+	if err != nil {
+		return fmt.Errorf("JWKS fetch failed: %w", err)
+	}
+
+	keys := map[string]interface{}{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if pub, err := k.publicKey(); err == nil {
+			keys[k.Kid] = pub
+		}
+	}
+	v.keys = keys
+	return nil
+}
+
+func (v *OIDCVerifier) getJSON(url string, out interface{}) error {
+	resp, err := v.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	b64 := base64.RawURLEncoding
+	switch k.Kty {
+	case "RSA":
+		n, err := b64.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := b64.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b64.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
@@ -0,0 +1,547 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiOperation documents one /api route for the OpenAPI spec. Routes without
+// an entry are still listed, with a summary made from the handler name, so
+// the spec always covers every registered route.
+type apiOperation struct {
+	Summary string
+	// Scope is the scope requireScope checks, if any. Admin routes are
+	// marked from their path.
+	Scope string
+	// Body names the request schema in schemas/, which validateBody
+	// enforces.
+	Body string
+	// OptionalBody marks Body as one the request may leave out.
+	OptionalBody bool
+	Query        []apiParam
+	// Response is a value of the type the handler returns, or a
+	// map[string]interface{} schema for handlers that answer with gin.H.
+	Response interface{}
+	Status   int
+}
+
+type apiParam struct {
+	Name        string
+	Type        string
+	Description string
+}
+
+var pageParams = []apiParam{
+	{"limit", "integer", "Page size"},
+	{"offset", "integer", "Rows to skip"},
+}
+
+// fieldsQuery is the sparse fieldset parameter of list endpoints.
+var fieldsQuery = apiParam{"fields", "string", "Only these comma-separated fields of each object; id is always included"}
+
+var transactionFilterParams = append([]apiParam{
+	{"status", "string", "One status, or a comma-separated list"},
+	{"from_account", "string", "Sender account or token"},
+	{"to_account", "string", "Recipient account or token"},
+	{"since", "string", "Created at or after (RFC 3339 or YYYY-MM-DD)"},
+	{"until", "string", "Created before (RFC 3339 or YYYY-MM-DD, inclusive day)"},
+	{"cursor", "string", "next_cursor of the previous page, instead of offset"},
+	fieldsQuery,
+}, pageParams...)
+
+var fraudAlertParams = append([]apiParam{
+	{"severity", "string", "review or block"},
+	{"rule", "string", "Only alerts this rule contributed to"},
+	{"status", "string", "open, acknowledged or resolved"},
+	{"since", "string", "Flagged at or after (RFC 3339 or YYYY-MM-DD)"},
+	{"until", "string", "Flagged before (RFC 3339 or YYYY-MM-DD, inclusive day)"},
+	fieldsQuery,
+}, pageParams...)
+
+// Inline schemas for handlers that answer with gin.H.
+var (
+	statsSchema = objectSchema(map[string]interface{}{
+		"revenue":      refSchema("Money"),
+		"transactions": map[string]interface{}{"type": "integer"},
+		"success_rate": map[string]interface{}{"type": "number"},
+		"avg_latency":  map[string]interface{}{"type": "integer"},
+	})
+	configSchemaResponse = objectSchema(map[string]interface{}{
+		"cache_mode":        map[string]interface{}{"type": "string"},
+		"cache_max_size":    map[string]interface{}{"type": "string"},
+		"cache_ttl":         map[string]interface{}{"type": "integer"},
+		"db_pool_size":      map[string]interface{}{"type": "integer"},
+		"rate_limit_rps":    map[string]interface{}{"type": "integer"},
+		"log_level":         map[string]interface{}{"type": "string"},
+		"feature_new_cache": map[string]interface{}{"type": "boolean"},
+		"bug_injection":     map[string]interface{}{"type": "object", "description": "Admin callers only"},
+	})
+	chaosReportSchema = objectSchema(map[string]interface{}{
+		"status":    map[string]interface{}{"type": "object", "description": "As in the dashboard's chaos section"},
+		"settings":  map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "object"}},
+		"running":   map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+		"leaked_mb": map[string]interface{}{"type": "integer"},
+		"scenario":  map[string]interface{}{"type": "object", "description": "The running chaos scenario, if any"},
+	})
+	rateLimitReportSchema = objectSchema(map[string]interface{}{
+		"rps":      map[string]interface{}{"type": "integer"},
+		"burst":    map[string]interface{}{"type": "integer"},
+		"routes":   map[string]interface{}{"type": "object", "additionalProperties": map[string]interface{}{"type": "number"}},
+		"exempt":   map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+		"settings": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "object"}},
+	})
+	fraudEvaluationSchema = objectSchema(map[string]interface{}{
+		"active":    refSchema("FraudAssessment"),
+		"candidate": refSchema("FraudAssessment"),
+	})
+)
+
+var apiOperations = map[string]apiOperation{
+	"GET /api/stats":                         {Summary: "Revenue, transaction count and success rate", Scope: "transactions:read", Response: statsSchema},
+	"GET /api/stats/amount-distribution":     {Summary: "Transaction counts by amount bucket", Scope: "transactions:read", Query: []apiParam{{"window_sec", "integer", "Only count the last N seconds"}}},
+	"GET /api/dashboard":                     {Summary: "Stats, latest transactions, fraud alerts and chaos status in one payload", Scope: "transactions:read", Query: []apiParam{{"limit", "integer", "Latest transactions to include"}}},
+	"GET /api/transactions":                  {Summary: "List transactions, newest first", Scope: "transactions:read", Query: transactionFilterParams, Response: TransactionPage{}},
+	"POST /api/transactions":                 {Summary: "Create a payment", Scope: "transactions:write", Body: "create-transaction", Response: Transaction{}, Status: http.StatusCreated},
+	"POST /api/transactions/:id/refund":      {Summary: "Refund a transaction in full or in part", Scope: "transactions:write", Body: "refund-transaction", Response: Transaction{}, Status: http.StatusCreated},
+	"POST /api/transactions/import":          {Summary: "Import an OFX or MT940 bank statement", Scope: "transactions:write"},
+	"GET /api/ws/transactions":               {Summary: "WebSocket feed of new transactions and status changes", Scope: "transactions:read", Status: http.StatusSwitchingProtocols},
+	"GET /api/seed/sample":                   {Summary: "Sample payment requests drawn from the seed personas", Scope: "transactions:read", Query: []apiParam{{"count", "integer", "How many to draw"}}},
+	"GET /api/accounts":                      {Summary: "List accounts", Scope: "accounts:read", Query: []apiParam{fieldsQuery}},
+	"GET /api/accounts/:id":                  {Summary: "Get an account", Scope: "accounts:read", Response: Account{}},
+	"GET /api/accounts/:id/sub-accounts":     {Summary: "Sub-accounts of a parent account", Scope: "accounts:read", Response: []Account{}},
+	"GET /api/accounts/:id/rollup":           {Summary: "An account's balance and settled flows together with its sub-accounts", Scope: "accounts:read", Response: AccountRollup{}},
+	"GET /api/accounts/:id/receipts":         {Summary: "Receipt emails queued for an account, newest first", Scope: "accounts:read", Query: []apiParam{{"limit", "integer", "Page size"}, fieldsQuery}},
+	"POST /api/accounts":                     {Summary: "Open an account", Scope: "accounts:write", Body: "create-account", Response: Account{}, Status: http.StatusCreated},
+	"PATCH /api/accounts/:id":                {Summary: "Update an account", Scope: "accounts:write", Body: "update-account", Response: Account{}},
+	"DELETE /api/accounts/:id":               {Summary: "Close an account", Scope: "accounts:write", Status: http.StatusNoContent},
+	"POST /api/webhooks":                     {Summary: "Register a webhook; the response carries its signing secret", Scope: "webhooks:manage", Body: "create-webhook", Status: http.StatusCreated},
+	"GET /api/webhooks":                      {Summary: "List webhooks", Scope: "webhooks:manage", Query: []apiParam{fieldsQuery}},
+	"DELETE /api/webhooks/:id":               {Summary: "Delete a webhook", Scope: "webhooks:manage", Status: http.StatusNoContent},
+	"POST /api/webhooks/:id/rotate-secret":   {Summary: "Issue a new signing secret, used once WEBHOOK_SECRET_GRACE_SEC has passed", Scope: "webhooks:manage"},
+	"GET /api/webhooks/:id/deliveries":       {Summary: "Delivery attempts of a webhook", Scope: "webhooks:manage", Query: []apiParam{{"status", "string", "pending, delivered or failed"}, {"limit", "integer", "Page size"}, fieldsQuery}},
+	"POST /api/admin/tenants":                {Summary: "Provision a demo tenant: session, sample accounts, seeded transactions and an API key", Body: "create-tenant", Status: http.StatusCreated},
+	"GET /api/admin/chaos":                   {Summary: "Chaos settings in effect on this instance and the background faults running", Response: chaosReportSchema},
+	"PUT /api/admin/chaos":                   {Summary: "Change chaos settings on this instance", Body: "update-chaos", Query: []apiParam{{"force", "boolean", "Skip guardrails"}}, Response: chaosReportSchema},
+	"DELETE /api/admin/chaos":                {Summary: "Turn every chaos setting on this instance off", Response: chaosReportSchema},
+	"GET /api/admin/rate-limits":             {Summary: "Rate limits in effect on this instance: per-route multipliers and exemptions", Response: rateLimitReportSchema},
+	"PUT /api/admin/rate-limits":             {Summary: "Change the rate limits on this instance", Body: "update-rate-limits", Query: []apiParam{{"force", "boolean", "Skip guardrails"}}, Response: rateLimitReportSchema},
+	"DELETE /api/admin/rate-limits":          {Summary: "Put this instance's rate limits back to the configured ones", Response: rateLimitReportSchema},
+	"POST /api/admin/config/reload":          {Summary: "Reread CONFIG_FILE and the environment, as on SIGHUP", Query: []apiParam{{"force", "boolean", "Skip guardrails"}}},
+	"GET /api/admin/chaos/scenarios":         {Summary: "Chaos scenarios from CHAOS_SCENARIOS_FILE and the one running"},
+	"POST /api/admin/chaos/scenarios/run":    {Summary: "Start a chaos scenario by name or inline", Body: "run-chaos-scenario", Response: ScenarioRunStatus{}, Status: http.StatusAccepted},
+	"DELETE /api/admin/chaos/scenarios/run":  {Summary: "Stop the running chaos scenario and revert its changes", Response: chaosReportSchema},
+	"GET /api/config":                        {Summary: "Dashboard tunables; bug injection state for admins", Query: []apiParam{{"verbose", "boolean", "Every effective setting with its source (admin only)"}}, Response: configSchemaResponse},
+	"GET /api/schemas":                       {Summary: "Names of the request body schemas"},
+	"GET /api/schemas/:name":                 {Summary: "A request body JSON Schema"},
+	"GET /api/schemas/events":                {Summary: "Webhook and event bus payload schemas by version"},
+	"GET /api/schemas/events/:version/:name": {Summary: "A payload JSON Schema, or the protobuf file"},
+	"GET /api/graphql":                       {Summary: "GraphQL query (?query=)"},
+	"POST /api/graphql":                      {Summary: "GraphQL query"},
+	"GET /api/openapi.json":                  {Summary: "This OpenAPI document"},
+	"GET /api/docs":                          {Summary: "Swagger UI for this API"},
+	"GET /api/t/:token":                      {Summary: "Transaction status by status token, without authentication"},
+	"GET /api/admin/fraud/rules":             {Summary: "Active fraud rule set, rule types and score thresholds"},
+	"POST /api/admin/fraud/rules/reload":     {Summary: "Reload fraud rules from FRAUD_RULES_SOURCE", Query: []apiParam{{"force", "boolean", "Skip guardrails"}}},
+	"POST /api/admin/fraud/evaluate":         {Summary: "Score a hypothetical transaction without storing it", Body: "create-transaction", Response: fraudEvaluationSchema},
+	"POST /api/admin/fraud/shadow":           {Summary: "Score candidate fraud rules alongside the active set", Body: "start-fraud-shadow", OptionalBody: true, Status: http.StatusCreated},
+	"GET /api/admin/fraud/shadow":            {Summary: "Score deltas and decision flips of the shadow run"},
+	"POST /api/admin/fraud/shadow/promote":   {Summary: "Make the shadowed candidate the active rule set", Query: []apiParam{{"force", "boolean", "Skip guardrails"}}},
+	"DELETE /api/admin/fraud/shadow":         {Summary: "Discard the shadow run", Status: http.StatusNoContent},
+	"GET /api/fraud/alerts":                  {Summary: "Fraud alerts, newest first", Scope: "fraud:triage", Query: fraudAlertParams},
+	"GET /api/fraud/alerts/:id":              {Summary: "One fraud alert", Scope: "fraud:triage", Response: FraudAlert{}},
+	"PATCH /api/fraud/alerts/:id":            {Summary: "Acknowledge, resolve or reopen a fraud alert", Scope: "fraud:triage", Body: "update-fraud-alert", Response: FraudAlert{}},
+
+	// The fraud review queue, of payments held by FRAUD_REVIEW_HOLD.
+	"GET /api/fraud/review-queue":              {Summary: "Transactions held for fraud review, newest first", Scope: "fraud:triage", Query: pageParams, Response: []ReviewItem{}},
+	"POST /api/fraud/review-queue/:id/approve": {Summary: "Approve and post a held transaction, labeling it legitimate", Scope: "fraud:triage", Body: "review-decision"},
+	"POST /api/fraud/review-queue/:id/decline": {Summary: "Decline a held transaction, labeling it fraud", Scope: "fraud:triage", Body: "review-decision"},
+	"GET /api/fraud/labels":                    {Summary: "Review decisions as labeled training data, newest first", Scope: "fraud:triage", Query: append([]apiParam{{"label", "string", "legitimate or fraud"}}, pageParams...), Response: []FraudLabel{}},
+
+	// What the velocity rules see of an account's payments.
+	"GET /api/fraud/accounts/:id/activity-window": {Summary: "An account's payments per minute and the payments each velocity rule fired for", Scope: "fraud:triage", Query: []apiParam{{"since", "string", "Start of the window (RFC 3339), by default an hour before until"}, {"until", "string", "End of the window (RFC 3339), by default now"}}, Response: ActivityWindow{}},
+
+	"GET /api/admin/fraud/spikes":            {Summary: "Windows in which a fraud rule's alerts spiked above its baseline", Query: []apiParam{{"rule", "string", "Rule name"}, {"since", "string", "Earliest window end (RFC 3339)"}, {"until", "string", "Latest window end (RFC 3339)"}, {"limit", "integer", "Page size"}, fieldsQuery}, Response: []FraudAlertSpike{}},
+	"POST /api/admin/fraud/backfill":         {Summary: "Score past transactions with the current fraud rules, recording alerts tagged with the backfill", Query: []apiParam{{"from", "string", "Earliest creation time (RFC 3339)"}, {"to", "string", "Latest creation time (RFC 3339)"}, {"rules", "string", "active (default) or source, the rules FRAUD_RULES_SOURCE holds now"}}, Status: http.StatusAccepted, Response: FraudBackfill{}},
+	"GET /api/admin/fraud/backfills":         {Summary: "Fraud backfills, newest first", Query: []apiParam{{"limit", "integer", "Page size"}}, Response: []FraudBackfill{}},
+	"GET /api/admin/fraud/backfills/:id":     {Summary: "Get a fraud backfill and its progress", Response: FraudBackfill{}},
+	"DELETE /api/admin/fraud/backfills/:id":  {Summary: "Delete a finished fraud backfill and its alerts", Status: http.StatusNoContent},
+	"GET /api/admin/fraud/summaries":         {Summary: "Daily summaries of fraud alerts past retention", Query: []apiParam{{"since", "string", "First day (YYYY-MM-DD)"}, {"until", "string", "Last day (YYYY-MM-DD, inclusive)"}, {"limit", "integer", "Page size"}, fieldsQuery}},
+	"GET /api/admin/transactions/:id/audit":  {Summary: "Audit trail of a transaction"},
+	"GET /api/admin/ledger/closes":           {Summary: "End-of-day closes, newest first", Query: []apiParam{{"since", "string", "First day (YYYY-MM-DD)"}, {"until", "string", "Last day (YYYY-MM-DD, inclusive)"}, {"limit", "integer", "Page size"}}, Response: []DailyClose{}},
+	"GET /api/admin/ledger/closes/:day":      {Summary: "The close of one day", Response: DailyClose{}},
+	"POST /api/admin/ledger/closes/:day":     {Summary: "Close a finished day now", Response: DailyClose{}, Status: http.StatusCreated},
+	"GET /api/admin/ledger/settlements":      {Summary: "Settlements of closed days with the bank, newest first", Query: []apiParam{{"status", "string", "pending, settled or rejected"}, {"limit", "integer", "Page size"}}, Response: []Settlement{}},
+	"GET /api/admin/ledger/exceptions":       {Summary: "Changes made to closed days", Query: []apiParam{{"day", "string", "Closed day (YYYY-MM-DD)"}, {"kind", "string", "inserted, modified or removed"}, {"limit", "integer", "Page size"}}, Response: []CloseException{}},
+	"POST /api/admin/duplicates/merge":       {Summary: "Merge duplicate transactions into a canonical one", Body: "merge-duplicates"},
+	"POST /api/admin/capture":                {Summary: "Record the next POST /api/transactions requests in full for debugging", Body: "start-capture", OptionalBody: true, Status: http.StatusCreated},
+	"POST /api/admin/privacy/erase":          {Summary: "Erase a data subject's personal data", Body: "erase-account"},
+	"POST /api/admin/privacy/exports":        {Summary: "Export everything held about an account", Body: "create-subject-export", Status: http.StatusAccepted},
+	"POST /api/admin/tokens/detokenize":      {Summary: "Reveal the values behind vault tokens", Body: "detokenize"},
+	"GET /api/admin/tokens/keys":             {Summary: "Vault keys and how many entries each seals"},
+	"POST /api/admin/tokens/keys/rotate":     {Summary: "Seal new vault entries with another key and re-encrypt the rest", Body: "rotate-vault-key"},
+	"PUT /api/admin/counterparties/:account": {Summary: "Set the counterparty details of an account", Body: "put-counterparty", Response: Counterparty{}},
+	"POST /api/admin/datasets":               {Summary: "Snapshot the live data as a named dataset", Body: "create-dataset", Status: http.StatusCreated},
+	"POST /api/admin/demo-sessions":          {Summary: "Open a demo session with its own seeded data", Body: "create-demo-session", Status: http.StatusCreated},
+}
+
+// openAPISpec builds the OpenAPI 3.1 document for every /api route in
+// routes. Request bodies are the JSON Schemas validateBody enforces, and
+// response schemas are derived from the Go types handlers return.
+func (app *App) openAPISpec(routes gin.RoutesInfo) ([]byte, error) {
+	components := map[string]interface{}{}
+	// Referenced from the inline schemas above.
+	typeSchema(reflect.TypeOf(Money{}), components)
+	typeSchema(reflect.TypeOf(FraudAssessment{}), components)
+	paths := map[string]map[string]interface{}{}
+	for _, rt := range routes {
+		if !strings.HasPrefix(rt.Path, "/api/") {
+			continue
+		}
+		key := rt.Method + " " + rt.Path
+		op, documented := apiOperations[key]
+		if !documented {
+			op.Summary = handlerSummary(rt.Handler)
+		}
+		path, params := openAPIPath(rt.Path)
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+		paths[path][strings.ToLower(rt.Method)] = app.openAPIOperation(rt, op, params, components)
+	}
+	for _, name := range app.schemas.Names() {
+		var s map[string]interface{}
+		if err := json.Unmarshal(app.schemas.raw[name], &s); err != nil {
+			return nil, err
+		}
+		delete(s, "$schema")
+		delete(s, "$id")
+		components[schemaComponentName(name)] = s
+	}
+	components["Error"] = objectSchema(map[string]interface{}{"error": map[string]interface{}{"type": "string"}})
+
+	spec := map[string]interface{}{
+		"openapi": "3.1.0",
+		"info": map[string]interface{}{
+			"title":       "PayFlow API",
+			"version":     appVersion,
+			"description": "Generated from the routes this server has registered. See the README for behavior in depth.",
+		},
+		"servers": []interface{}{map[string]interface{}{"url": "/"}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": components,
+			"securitySchemes": map[string]interface{}{
+				"oauth2": map[string]interface{}{
+					"type": "oauth2",
+					"flows": map[string]interface{}{
+						"clientCredentials": map[string]interface{}{
+							"tokenUrl": "/oauth/token",
+							"scopes":   openAPIScopes(),
+						},
+					},
+				},
+				"apiKey":     map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+				"adminToken": map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-Admin-Token"},
+			},
+			"parameters": map[string]interface{}{
+				"DemoSession": map[string]interface{}{
+					"name": "X-Demo-Session", "in": "header", "required": false,
+					"description": "Scope the request to a demo session",
+					"schema":      map[string]interface{}{"type": "string"},
+				},
+				"APIVersion": map[string]interface{}{
+					"name": "X-API-Version", "in": "header", "required": false,
+					"description": "Response shape: 1 as documented here, 2 wrapped as {data, meta} and errors as {error: {status, message, details}}",
+					"schema":      map[string]interface{}{"type": "string", "enum": []string{"1", "2"}},
+				},
+			},
+		},
+	}
+	return json.MarshalIndent(spec, "", "  ")
+}
+
+func (app *App) openAPIOperation(rt gin.RouteInfo, op apiOperation, params []interface{}, components map[string]interface{}) map[string]interface{} {
+	out := map[string]interface{}{
+		"summary":     op.Summary,
+		"operationId": handlerName(rt.Handler),
+		"tags":        []string{openAPITag(rt.Path)},
+	}
+	params = append(params,
+		map[string]interface{}{"$ref": "#/components/parameters/DemoSession"},
+		map[string]interface{}{"$ref": "#/components/parameters/APIVersion"})
+	for _, q := range op.Query {
+		params = append(params, map[string]interface{}{
+			"name": q.Name, "in": "query", "required": false,
+			"description": q.Description,
+			"schema":      map[string]interface{}{"type": q.Type},
+		})
+	}
+	out["parameters"] = params
+
+	if op.Body != "" {
+		out["requestBody"] = map[string]interface{}{
+			"required": !op.OptionalBody,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": refSchema(schemaComponentName(op.Body))},
+			},
+		}
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := map[string]interface{}{"description": http.StatusText(status)}
+	if op.Response != nil {
+		success["content"] = map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": responseSchema(op.Response, components)},
+		}
+	}
+	errorResponse := func(code int) map[string]interface{} {
+		return map[string]interface{}{
+			"description": http.StatusText(code),
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": refSchema("Error")},
+			},
+		}
+	}
+	responses := map[string]interface{}{
+		strconv.Itoa(status): success,
+		"401":                errorResponse(http.StatusUnauthorized),
+		"429":                errorResponse(http.StatusTooManyRequests),
+	}
+	if op.Body != "" {
+		responses["400"] = errorResponse(http.StatusBadRequest)
+	}
+
+	admin := strings.HasPrefix(rt.Path, "/api/admin/")
+	switch {
+	case admin:
+		out["security"] = []interface{}{
+			map[string]interface{}{"adminToken": []string{}},
+			map[string]interface{}{"oauth2": []string{"admin"}},
+		}
+		responses["403"] = errorResponse(http.StatusForbidden)
+	case strings.HasPrefix(rt.Path, "/api/fraud/"):
+		// Fraud analysts or admins, see fraudTriageMiddleware.
+		out["security"] = []interface{}{
+			map[string]interface{}{"oauth2": []string{op.Scope}},
+			map[string]interface{}{"adminToken": []string{}},
+			map[string]interface{}{"oauth2": []string{"admin"}},
+		}
+		out["description"] = "Requires the `fraud_analyst` role, the `" + op.Scope + "` scope or admin access."
+		responses["403"] = errorResponse(http.StatusForbidden)
+	case op.Scope != "":
+		out["security"] = []interface{}{
+			map[string]interface{}{"oauth2": []string{op.Scope}},
+			map[string]interface{}{"apiKey": []string{}},
+			map[string]interface{}{},
+		}
+		out["description"] = "Requires the `" + op.Scope + "` scope when the caller is authenticated."
+		responses["403"] = errorResponse(http.StatusForbidden)
+	}
+	out["responses"] = responses
+	return out
+}
+
+var ginParam = regexp.MustCompile(`[:*]([A-Za-z_]+)`)
+
+// openAPIPath turns /api/accounts/:id into /api/accounts/{id} and its path
+// parameters.
+func openAPIPath(path string) (string, []interface{}) {
+	params := []interface{}{}
+	for _, m := range ginParam.FindAllStringSubmatch(path, -1) {
+		params = append(params, map[string]interface{}{
+			"name": m[1], "in": "path", "required": true,
+			"schema": map[string]interface{}{"type": "string"},
+		})
+	}
+	return ginParam.ReplaceAllString(path, "{$1}"), params
+}
+
+// openAPITag groups operations by their first path segment after /api (and
+// /admin).
+func openAPITag(path string) string {
+	parts := strings.Split(strings.TrimPrefix(path, "/api/"), "/")
+	if parts[0] == "admin" && len(parts) > 1 {
+		return "admin/" + parts[1]
+	}
+	return parts[0]
+}
+
+func openAPIScopes() map[string]string {
+	scopes := map[string]string{}
+	for _, granted := range roleScopes {
+		for _, s := range granted {
+			scopes[s] = s
+		}
+	}
+	return scopes
+}
+
+// handlerName is the method name in gin's handler name, such as
+// getStatsHandler for main.(*App).getStatsHandler-fm.
+func handlerName(handler string) string {
+	name := handler[strings.LastIndex(handler, ".")+1:]
+	return strings.TrimSuffix(name, "-fm")
+}
+
+// handlerSummary makes "Get startup report" out of getStartupReportHandler.
+func handlerSummary(handler string) string {
+	name := strings.TrimSuffix(handlerName(handler), "Handler")
+	var words []string
+	start := 0
+	for i, r := range name {
+		if i > 0 && unicode.IsUpper(r) {
+			words = append(words, strings.ToLower(name[start:i]))
+			start = i
+		}
+	}
+	words = append(words, strings.ToLower(name[start:]))
+	s := strings.Join(words, " ")
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// schemaComponentName makes CreateTransaction out of create-transaction.
+func schemaComponentName(file string) string {
+	var b strings.Builder
+	for _, part := range strings.Split(file, "-") {
+		if part != "" {
+			b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+	return b.String()
+}
+
+func refSchema(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+func objectSchema(props map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"type": "object", "properties": props}
+}
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	rawType  = reflect.TypeOf(json.RawMessage{})
+)
+
+// responseSchema is v's schema: v itself when it is already a schema map,
+// and otherwise one derived from its Go type, with structs added to
+// components.
+func responseSchema(v interface{}, components map[string]interface{}) map[string]interface{} {
+	if s, ok := v.(map[string]interface{}); ok {
+		return s
+	}
+	return typeSchema(reflect.TypeOf(v), components)
+}
+
+func typeSchema(t reflect.Type, components map[string]interface{}) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == rawType:
+		return map[string]interface{}{}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem(), components)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem(), components)}
+	case reflect.Struct:
+		if _, done := components[t.Name()]; !done {
+			// Reserve the name first so recursive types terminate.
+			components[t.Name()] = map[string]interface{}{}
+			components[t.Name()] = structSchema(t, components)
+		}
+		return refSchema(t.Name())
+	}
+	return map[string]interface{}{}
+}
+
+// structSchema follows encoding/json: embedded structs are flattened, and
+// fields without omitempty are required.
+func structSchema(t reflect.Type, components map[string]interface{}) map[string]interface{} {
+	props := map[string]interface{}{}
+	var required []string
+	var walk func(reflect.Type)
+	walk = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" || (!f.IsExported() && !f.Anonymous) {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+				walk(f.Type)
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = typeSchema(f.Type, components)
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+	}
+	walk(t)
+	s := objectSchema(props)
+	if len(required) > 0 {
+		sort.Strings(required)
+		s["required"] = required
+	}
+	return s
+}
+
+func (app *App) openAPIHandler(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json; charset=utf-8", app.openapi)
+}
+
+// swaggerUIPage loads Swagger UI from a CDN, so the server doesn't have to
+// ship its assets.
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>PayFlow API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js" crossorigin></script>
+  <script>
+    window.ui = SwaggerUIBundle({ url: 'openapi.json', dom_id: '#swagger-ui', persistAuthorization: true });
+  </script>
+</body>
+</html>
+`
+
+func (app *App) swaggerUIHandler(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(swaggerUIPage))
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	outboundRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "payflow_outbound_requests_total",
+		Help: "Outbound HTTP attempts by destination and result (2xx..5xx, error, circuit_open)",
+	}, []string{"destination", "result"})
+	outboundRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "payflow_outbound_request_duration_seconds",
+		Help:    "Outbound HTTP attempt duration by destination",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"destination"})
+	outboundRetriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "payflow_outbound_retries_total",
+		Help: "Outbound HTTP attempts that were retries, by destination",
+	}, []string{"destination"})
+	outboundCircuitsOpen = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "payflow_outbound_circuits_open",
+		Help: "Hosts whose circuit breaker is open, by destination",
+	}, []string{"destination"})
+)
+
+// errCircuitOpen fails requests to a host whose breaker is open, without
+// sending them.
+var errCircuitOpen = errors.New("circuit breaker open")
+
+// hexTraceID matches request IDs that can serve as a W3C trace ID once their
+// dashes are dropped, as the generated UUIDs can.
+var hexTraceID = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// outboundOptions are a destination's settings. Timeout bounds each attempt.
+// Retries are extra attempts after a transport error or a 502, 503 or 504;
+// set them only where repeating a request is safe. PublicOnly refuses to
+// connect to loopback, link-local, private and unspecified addresses, for
+// destinations that callers choose.
+type outboundOptions struct {
+	Timeout     time.Duration
+	Retries     int
+	NoRedirects bool
+	PublicOnly  bool
+}
+
+// outboundPool is what every outbound client shares: one connection pool,
+// another for PublicOnly destinations, and a circuit breaker per
+// destination and host. The zero value is ready.
+type outboundPool struct {
+	once      sync.Once
+	transport *http.Transport
+	public    *http.Transport
+
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+// newOutboundClient returns the client an integration calls destination
+// through. destination labels metrics and logs; it is the integration's
+// name, not a host.
+func (app *App) newOutboundClient(destination string, opts outboundOptions) *http.Client {
+	app.outbound.once.Do(func() {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.MaxIdleConns = 0
+		t.MaxIdleConnsPerHost = app.config.OutboundMaxIdlePerHost
+		app.outbound.transport = t
+
+		// Going through a proxy would only check the proxy's address.
+		public := t.Clone()
+		public.Proxy = nil
+		public.DialContext = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: publicDialControl}).DialContext
+		app.outbound.public = public
+	})
+	client := &http.Client{Transport: &outboundTransport{app: app, destination: destination, opts: opts}}
+	if opts.NoRedirects {
+		client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	}
+	return client
+}
+
+func (app *App) breakerFor(destination, host string) *circuitBreaker {
+	p := &app.outbound
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := destination + "|" + host
+	b, ok := p.breakers[key]
+	if !ok {
+		if p.breakers == nil {
+			p.breakers = map[string]*circuitBreaker{}
+		}
+		b = &circuitBreaker{
+			threshold: app.config.OutboundBreakerFailures,
+			cooldown:  time.Duration(app.config.OutboundBreakerCooldownSec) * time.Second,
+		}
+		p.breakers[key] = b
+	}
+	return b
+}
+
+// outboundTransport sends one destination's requests through the shared
+// pool, adding request and trace IDs and applying its options.
+type outboundTransport struct {
+	app         *App
+	destination string
+	opts        outboundOptions
+}
+
+func (t *outboundTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	breaker := t.app.breakerFor(t.destination, req.URL.Host)
+	retries := t.opts.Retries
+	if req.Body != nil && req.GetBody == nil {
+		retries = 0
+	}
+	b := &backoff{next: 100 * time.Millisecond, max: 2 * time.Second, multiplier: 2, jitter: 0.2}
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			outboundRetriesTotal.WithLabelValues(t.destination).Inc()
+		}
+		resp, err := t.attempt(req, breaker, attempt)
+		if attempt >= retries || !retryable(resp, err) {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(b.delay()):
+		}
+	}
+}
+
+// attempt sends req once. The attempt's timeout stays in force until the
+// response body is closed.
+func (t *outboundTransport) attempt(req *http.Request, breaker *circuitBreaker, attempt int) (*http.Response, error) {
+	if !breaker.allow() {
+		outboundRequestsTotal.WithLabelValues(t.destination, "circuit_open").Inc()
+		return nil, fmt.Errorf("%s %s: %w", t.destination, req.URL.Host, errCircuitOpen)
+	}
+	ctx, cancel := req.Context(), context.CancelFunc(func() {})
+	if t.opts.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, t.opts.Timeout)
+	}
+	out := req.Clone(ctx)
+	if attempt > 0 && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		out.Body = body
+	}
+	setTraceHeaders(out)
+
+	start := time.Now()
+	transport := t.app.outbound.transport
+	if t.opts.PublicOnly {
+		transport = t.app.outbound.public
+	}
+	resp, err := transport.RoundTrip(out)
+	elapsed := time.Since(start)
+	outboundRequestDuration.WithLabelValues(t.destination).Observe(elapsed.Seconds())
+
+	result := "error"
+	if err == nil {
+		result = fmt.Sprintf("%dxx", resp.StatusCode/100)
+		resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	} else {
+		cancel()
+	}
+	outboundRequestsTotal.WithLabelValues(t.destination, result).Inc()
+	if opened, closed := breaker.record(err != nil || resp.StatusCode >= 500); opened {
+		outboundCircuitsOpen.WithLabelValues(t.destination).Inc()
+		t.app.log("warn", "Outbound circuit opened", map[string]interface{}{"destination": t.destination, "host": req.URL.Host})
+	} else if closed {
+		outboundCircuitsOpen.WithLabelValues(t.destination).Dec()
+		t.app.log("info", "Outbound circuit closed", map[string]interface{}{"destination": t.destination, "host": req.URL.Host})
+	}
+	t.app.debug(req.Context(), "Outbound request", map[string]interface{}{
+		"destination": t.destination,
+		"method":      req.Method,
+		"host":        req.URL.Host,
+		"attempt":     attempt + 1,
+		"result":      result,
+		"duration_ms": elapsed.Milliseconds(),
+	})
+	return resp, err
+}
+
+// retryable reports whether an attempt failed in a way another attempt
+// might not: the connection failed or timed out, or the server said it was
+// temporarily unable to answer. An open circuit is not retried.
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		if errors.Is(err, errCircuitOpen) || errors.Is(err, context.Canceled) {
+			return false
+		}
+		var netErr net.Error
+		return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF)
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// setTraceHeaders passes the request ID behind req's context on as
+// X-Request-ID and, when it is a UUID or trace ID, as a W3C traceparent
+// with a new span, so the callee's logs join this request's.
+func setTraceHeaders(req *http.Request) {
+	t := logTraceFrom(req.Context())
+	if t == nil {
+		return
+	}
+	if req.Header.Get("X-Request-ID") == "" {
+		req.Header.Set("X-Request-ID", t.ID)
+	}
+	if trace := strings.ReplaceAll(t.ID, "-", ""); hexTraceID.MatchString(trace) && req.Header.Get("traceparent") == "" {
+		span := make([]byte, 8)
+		rand.Read(span)
+		req.Header.Set("traceparent", "00-"+trace+"-"+hex.EncodeToString(span)+"-01")
+	}
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// circuitBreaker opens after threshold consecutive failures and fails
+// requests for cooldown. A single trial request then decides whether it
+// closes again or stays open for another cooldown. A threshold of 0 never
+// opens.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openedAt.IsZero() {
+		return true
+	}
+	if b.trial || time.Since(b.openedAt) < b.cooldown {
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+// record counts an attempt's outcome and reports whether it opened or
+// closed the breaker.
+func (b *circuitBreaker) record(failed bool) (opened, closed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wasOpen := !b.openedAt.IsZero()
+	b.trial = false
+	if !failed {
+		b.failures, b.openedAt = 0, time.Time{}
+		return false, wasOpen
+	}
+	b.failures++
+	if b.threshold > 0 && (wasOpen || b.failures >= b.threshold) {
+		b.openedAt = time.Now()
+		return !wasOpen, false
+	}
+	return false, false
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestOutboundRetries(t *testing.T) {
+	var calls atomic.Int32
+	var requestID, traceparent atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID.Store(r.Header.Get("X-Request-ID"))
+		traceparent.Store(r.Header.Get("traceparent"))
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	app := newTestApp(t, nil)
+	retriesBefore := testutil.ToFloat64(outboundRetriesTotal.WithLabelValues("test-retry"))
+
+	ctx := context.WithValue(context.Background(), logTraceKey{}, &logTrace{ID: "4bf92f35-77b3-4da6-a3ce-929d0e0e4736"})
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	resp, err := app.newOutboundClient("test-retry", outboundOptions{Retries: 2}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || calls.Load() != 3 {
+		t.Fatalf("status %d after %d calls, want 200 after 3", resp.StatusCode, calls.Load())
+	}
+	if d := testutil.ToFloat64(outboundRetriesTotal.WithLabelValues("test-retry")) - retriesBefore; d != 2 {
+		t.Errorf("retries counted %v, want 2", d)
+	}
+	if got := testutil.ToFloat64(outboundRequestsTotal.WithLabelValues("test-retry", "5xx")); got != 2 {
+		t.Errorf("5xx attempts counted %v, want 2", got)
+	}
+	if requestID.Load() != "4bf92f35-77b3-4da6-a3ce-929d0e0e4736" || !strings.HasPrefix(traceparent.Load().(string), "00-4bf92f3577b34da6a3ce929d0e0e4736-") {
+		t.Errorf("X-Request-ID %q, traceparent %q", requestID.Load(), traceparent.Load())
+	}
+
+	// Without retries, and for answers another attempt wouldn't change,
+	// the first response comes back.
+	calls.Store(0)
+	resp, err = app.newOutboundClient("test-retry", outboundOptions{}).Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || calls.Load() != 1 {
+		t.Errorf("status %d after %d calls, want 503 after 1", resp.StatusCode, calls.Load())
+	}
+}
+
+func TestOutboundCircuitBreaker(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	app := newTestApp(t, func(c *Config) {
+		c.OutboundBreakerFailures = 2
+		c.OutboundBreakerCooldownSec = 1
+	})
+	client := app.newOutboundClient("test-breaker", outboundOptions{})
+	get := func() (int, error) {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	for i := 0; i < 2; i++ {
+		if code, err := get(); code != http.StatusInternalServerError {
+			t.Fatalf("call %d: %d, %v; want the server's 500", i+1, code, err)
+		}
+	}
+	if _, err := get(); !errors.Is(err, errCircuitOpen) || calls.Load() != 2 {
+		t.Fatalf("third call: %v after %d calls, want the open circuit without calling", err, calls.Load())
+	}
+	if open := testutil.ToFloat64(outboundCircuitsOpen.WithLabelValues("test-breaker")); open != 1 {
+		t.Errorf("circuits open %v, want 1", open)
+	}
+
+	// After the cooldown a failed trial keeps the circuit open; a good one
+	// closes it.
+	app.breakerFor("test-breaker", strings.TrimPrefix(server.URL, "http://")).openedAt = time.Now().Add(-time.Second)
+	if code, _ := get(); code != http.StatusInternalServerError {
+		t.Fatalf("trial answered %d, want 500", code)
+	}
+	if _, err := get(); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("after a failed trial: %v, want the open circuit", err)
+	}
+	failing.Store(false)
+	app.breakerFor("test-breaker", strings.TrimPrefix(server.URL, "http://")).openedAt = time.Now().Add(-time.Second)
+	if code, err := get(); code != http.StatusOK {
+		t.Fatalf("trial answered %d, %v; want 200", code, err)
+	}
+	if open := testutil.ToFloat64(outboundCircuitsOpen.WithLabelValues("test-breaker")); open != 0 {
+		t.Errorf("circuits open %v after recovering, want 0", open)
+	}
+}
+
+func TestOutboundPublicOnly(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer server.Close()
+	app := newTestApp(t, nil)
+
+	if _, err := app.newOutboundClient("test-public", outboundOptions{PublicOnly: true}).Get(server.URL); !errors.Is(err, errPrivateTarget) {
+		t.Errorf("loopback call with PublicOnly: %v, want %v", err, errPrivateTarget)
+	}
+	resp, err := app.newOutboundClient("test-internal", outboundOptions{}).Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
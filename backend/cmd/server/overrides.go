@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/infrasage/payflow/internal/config"
+)
+
+const configContextKey = "payflow.config"
+
+// cfg returns the configuration in effect for this request: the live config
+// unless the request carried feature overrides.
+func (app *App) cfg(c *gin.Context) *Config {
+	if v, ok := c.Get(configContextKey); ok {
+		return v.(*Config)
+	}
+	return app.liveConfig()
+}
+
+// parseFeatureOverrides applies a header of the form
+// "feature_new_cache=true,inject_latency_ms=250" to a copy of base. Keys are
+// the lowercased env var names of overridable settings.
+func parseFeatureOverrides(base *Config, header string) (*Config, map[string]string, error) {
+	override := base.Clone()
+	applied := map[string]string{}
+
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, raw, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, nil, fmt.Errorf("override %q must be key=value", pair)
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		raw = strings.TrimSpace(raw)
+
+		field, ok := overridableField(key)
+		if !ok {
+			return nil, nil, fmt.Errorf("%q cannot be overridden", key)
+		}
+		if err := field.Set(override, raw); err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", key, err)
+		}
+		applied[key] = raw
+		override.SetSource(field.Env, config.SourceOverride)
+	}
+	return override, applied, nil
+}
+
+func overridableField(key string) (config.Field, bool) {
+	for _, f := range config.Schema {
+		if f.Overridable && strings.ToLower(f.Env) == key {
+			return f, true
+		}
+	}
+	return config.Field{}, false
+}
+
+// featureOverrideMiddleware lets an admin flip feature and chaos flags for a
+// single request via X-Feature-Overrides without touching global state.
+func (app *App) featureOverrideMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("X-Feature-Overrides")
+		if header == "" {
+			c.Next()
+			return
+		}
+		if !app.isAdminRequest(c) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Feature overrides require an admin token"})
+			c.Abort()
+			return
+		}
+
+		override, applied, err := parseFeatureOverrides(app.cfg(c), header)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+
+		app.eventCtx(c.Request.Context(), "info", EventConfigOverridden, "", "Feature overrides applied", map[string]interface{}{
+			"method":    c.Request.Method,
+			"path":      c.Request.URL.Path,
+			"overrides": applied,
+		})
+		c.Set(configContextKey, override)
+		c.Header("X-Feature-Overrides-Applied", header)
+		c.Next()
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/infrasage/payflow/internal/store"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+	// Deep OFFSET scans get slower with every page; past this callers should
+	// narrow the date range instead.
+	maxPageOffset = 100_000
+)
+
+// TransactionPage is the GET /api/transactions response envelope. Total
+// counts every transaction matching the filters, not just this page.
+// NextCursor is set while more pages may follow.
+type TransactionPage struct {
+	Data       []Transaction `json:"data"`
+	Total      int           `json:"total"`
+	Limit      int           `json:"limit"`
+	Offset     int           `json:"offset"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+func pageParam(c *gin.Context, name string, def, max int) (int, error) {
+	raw := c.Query(name)
+	if raw == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 || n > max {
+		return 0, fmt.Errorf("%s must be an integer between 0 and %d", name, max)
+	}
+	return n, nil
+}
+
+// parseTimeParam accepts RFC 3339 timestamps or plain dates. A plain date
+// used as an upper bound covers that whole day.
+func parseTimeParam(c *gin.Context, name string, upper bool) (time.Time, bool, error) {
+	return parseTimeValue(name, c.Query(name), upper)
+}
+
+func parseTimeValue(name, raw string, upper bool) (time.Time, bool, error) {
+	if raw == "" {
+		return time.Time{}, false, nil
+	}
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t.UTC(), true, nil
+	}
+	t, err := time.Parse("2006-01-02", raw)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("%s must be an RFC 3339 timestamp or YYYY-MM-DD date", name)
+	}
+	if upper {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, true, nil
+}
+
+// transactionFilterKeys are the query parameters applyTransactionFilters
+// reads.
+var transactionFilterKeys = []string{"status", "from_account", "to_account", "since", "until"}
+
+// transactionFilters returns the filter parameters set in q.
+func transactionFilters(q url.Values) map[string]string {
+	filters := map[string]string{}
+	for _, key := range transactionFilterKeys {
+		if v := q.Get(key); v != "" {
+			filters[key] = v
+		}
+	}
+	return filters
+}
+
+// applyTransactionFilters adds list filters to f: status (one value or a
+// comma-separated list), from_account, to_account and a created_at range
+// given as since (inclusive) and until (exclusive).
+func (app *App) applyTransactionFilters(c *gin.Context, filters map[string]string, f *store.TransactionFilter) error {
+	if raw := filters["status"]; raw != "" {
+		f.Statuses = strings.Split(raw, ",")
+	}
+	for name, field := range map[string]*string{"from_account": &f.FromAccount, "to_account": &f.ToAccount} {
+		if v := filters[name]; v != "" {
+			account, err := app.vault.Resolve(c.Request.Context(), v)
+			if err != nil {
+				return err
+			}
+			*field = account
+		}
+	}
+
+	since, hasSince, err := parseTimeValue("since", filters["since"], false)
+	if err != nil {
+		return err
+	}
+	until, hasUntil, err := parseTimeValue("until", filters["until"], true)
+	if err != nil {
+		return err
+	}
+	if hasSince && hasUntil && !since.Before(until) {
+		return fmt.Errorf("since must be before until")
+	}
+	if hasSince {
+		f.Since = since
+	}
+	if hasUntil {
+		f.Until = until
+	}
+	return nil
+}
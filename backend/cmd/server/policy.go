@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var policyDecisionsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "payflow_policy_decisions_total",
+		Help: "Authorization decisions by action, result (allow, deny) and source (opa, fallback)",
+	},
+	[]string{"action", "result", "source"},
+)
+
+// PolicyInput is the input document sent to the policy engine. Builtin is
+// the decision PayFlow would make on its own, so policies can extend it
+// rather than restate it.
+type PolicyInput struct {
+	Action     string   `json:"action"`
+	Method     string   `json:"method"`
+	Route      string   `json:"route"`
+	Subject    string   `json:"subject,omitempty"`
+	Source     string   `json:"source,omitempty"`
+	Scopes     []string `json:"scopes,omitempty"`
+	Roles      []string `json:"roles,omitempty"`
+	AdminToken bool     `json:"admin_token"`
+	Builtin    bool     `json:"builtin"`
+}
+
+// PolicyEngine asks an Open Policy Agent sidecar for authorization decisions
+// through its data API: POST {url}/v1/data/{path} with {"input": ...}, where
+// path names a boolean rule such as payflow/authz/allow. An undefined result
+// denies.
+type PolicyEngine struct {
+	url    string
+	path   string
+	client *http.Client
+}
+
+func (app *App) initPolicy() {
+	if app.config.PolicyEngine != "opa" {
+		return
+	}
+	app.policy = &PolicyEngine{
+		url:    strings.TrimSuffix(app.config.OPAURL, "/"),
+		path:   strings.Trim(app.config.OPAPolicyPath, "/"),
+		client: app.newOutboundClient("opa", outboundOptions{Timeout: time.Duration(app.config.PolicyTimeoutMs) * time.Millisecond}),
+	}
+}
+
+func (e *PolicyEngine) Decide(ctx context.Context, input PolicyInput) (bool, error) {
+	body, err := json.Marshal(map[string]interface{}{"input": input})
+	if err != nil {
+		return false, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url+"/v1/data/"+e.path, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("opa: %s", resp.Status)
+	}
+	var out struct {
+		Result *bool `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return false, fmt.Errorf("opa: %w", err)
+	}
+	return out.Result != nil && *out.Result, nil
+}
+
+// Healthy reports whether the OPA sidecar answers its health endpoint.
+func (e *PolicyEngine) Healthy(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.url+"/health", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("opa: %s", resp.Status)
+	}
+	return nil
+}
+
+// policyAction names what a route does for policy purposes: fraud rule and
+// shadow-run changes and alert triage are "fraud", everything else under
+// /api/admin "admin".
+func policyAction(route string) string {
+	if strings.HasPrefix(route, "/api/admin/fraud/") || strings.HasPrefix(route, "/api/fraud/") {
+		return "fraud"
+	}
+	return "admin"
+}
+
+// authorize decides whether the request may use an admin route. Without a
+// policy engine that is the built-in check. With one, OPA decides.
+func (app *App) authorize(c *gin.Context) bool {
+	return app.authorizeAdmin(c.Request.Context(), policyAction(c.FullPath()), c.Request.Method, c.FullPath(), principalFrom(c), c.GetHeader("X-Admin-Token"))
+}
+
+// authorizeAdmin is authorize for callers that aren't admin routes, such as
+// the gRPC and GraphQL fraud alert listings, given who is calling. The
+// built-in check lets admins do anything and fraud analysts, by the
+// fraud_analyst role or the fraud:triage scope, do fraud actions.
+func (app *App) authorizeAdmin(ctx context.Context, action, method, route string, p *Principal, adminToken string) bool {
+	builtin := app.isAdmin(p, adminToken) || (action == "fraud" && canTriageFraud(p))
+	if app.policy == nil {
+		return builtin
+	}
+	input := PolicyInput{
+		Action:     action,
+		Method:     method,
+		Route:      route,
+		AdminToken: app.validAdminToken(adminToken),
+		Builtin:    builtin,
+	}
+	if p != nil {
+		input.Subject, input.Source, input.Scopes, input.Roles = p.Subject, p.Source, p.Scopes, p.Roles
+	}
+	return app.decide(ctx, input)
+}
+
+// decide asks the policy engine about input. When it can't be reached
+// POLICY_FALLBACK allows, denies or falls back to the built-in check. Every
+// decision is logged as an authz.decision event.
+func (app *App) decide(ctx context.Context, input PolicyInput) bool {
+	start := time.Now()
+	allow, err := app.policy.Decide(ctx, input)
+	source := "opa"
+	if err != nil {
+		source = "fallback"
+		switch app.config.PolicyFallback {
+		case "allow":
+			allow = true
+		case "builtin":
+			allow = input.Builtin
+		default:
+			allow = false
+		}
+	}
+
+	result, level := "allow", "info"
+	if !allow {
+		result, level = "deny", "warn"
+	}
+	policyDecisionsTotal.WithLabelValues(input.Action, result, source).Inc()
+	attrs := map[string]interface{}{
+		"action":      input.Action,
+		"method":      input.Method,
+		"route":       input.Route,
+		"subject":     input.Subject,
+		"result":      result,
+		"source":      source,
+		"builtin":     input.Builtin,
+		"duration_ms": float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		attrs["error"] = err.Error()
+	}
+	app.eventCtx(ctx, level, EventAuthzDecision, input.Route, "Policy decision", attrs)
+	return allow
+}
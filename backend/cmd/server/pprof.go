@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http/pprof"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const pprofPrefix = "/debug/pprof"
+
+// mountPprof serves net/http/pprof under /debug/pprof for admins, so heap,
+// goroutine and CPU profiles can be taken from a live instance during the
+// memory-growth and CPU-burn scenarios. It is only called when ENABLE_PPROF
+// is set.
+func (app *App) mountPprof(r *gin.Engine) {
+	g := r.Group(pprofPrefix, app.adminMiddleware())
+	g.GET("/*name", func(c *gin.Context) {
+		switch name := strings.TrimPrefix(c.Param("name"), "/"); name {
+		case "cmdline":
+			pprof.Cmdline(c.Writer, c.Request)
+		case "profile":
+			pprof.Profile(c.Writer, c.Request)
+		case "symbol":
+			pprof.Symbol(c.Writer, c.Request)
+		case "trace":
+			pprof.Trace(c.Writer, c.Request)
+		case "":
+			pprof.Index(c.Writer, c.Request)
+		default:
+			pprof.Handler(name).ServeHTTP(c.Writer, c.Request)
+		}
+	})
+	g.POST("/symbol", gin.WrapF(pprof.Symbol))
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// ErasureReport describes a completed data subject erasure. It never
+// contains the erased account identifier itself.
+type ErasureReport struct {
+	ID                 string    `json:"id"`
+	Pseudonym          string    `json:"pseudonym"`
+	Transactions       int64     `json:"transactions"`
+	AuditEntries       int64     `json:"audit_entries"`
+	Accounts           int64     `json:"accounts"`
+	Counterparties     int64     `json:"counterparties"`
+	Datasets           int64     `json:"datasets"`
+	VaultEntries       int64     `json:"vault_entries"`
+	TransactionIDs     []string  `json:"transaction_ids"`
+	Actor              string    `json:"actor"`
+	CompletedAt        time.Time `json:"completed_at"`
+	SpooledNotErasable int       `json:"spooled_not_erasable,omitempty"`
+}
+
+// erasurePseudonym derives the replacement identifier. The salt is random
+// and thrown away, so the pseudonym can't be linked back to the account by
+// hashing candidate IDs, while every erased row still shares one value and
+// per-party aggregates keep adding up.
+func erasurePseudonym(account string) (string, error) {
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(append(salt, account...))
+	return "erased-" + hex.EncodeToString(sum[:8]), nil
+}
+
+// eraseSnapshotTransactions rewrites the account inside stored dataset
+// snapshots, so a later restore can't bring the data back.
+const eraseSnapshotTransactions = `
+	UPDATE datasets d SET
+		transactions = (
+			SELECT COALESCE(jsonb_agg(
+				CASE WHEN t->>'from_account' = $1 OR t->>'to_account' = $1 THEN
+					t || jsonb_build_object(
+						'from_account', CASE WHEN t->>'from_account' = $1 THEN $2::text ELSE t->>'from_account' END,
+						'to_account', CASE WHEN t->>'to_account' = $1 THEN $2::text ELSE t->>'to_account' END,
+						'description', '',
+						'content_hash', NULL,
+						'erased_at', to_jsonb(CURRENT_TIMESTAMP::timestamp))
+				ELSE t END ORDER BY ord), '[]')
+			FROM jsonb_array_elements(d.transactions) WITH ORDINALITY AS e(t, ord)),
+		accounts = (
+			SELECT COALESCE(jsonb_agg(
+				CASE WHEN a->>'id' = $1 THEN a || jsonb_build_object('id', $2::text, 'name', '') ELSE a END
+				ORDER BY ord), '[]')
+			FROM jsonb_array_elements(d.accounts) WITH ORDINALITY AS e(a, ord)),
+		audit = replace(d.audit::text, to_jsonb($1::text)::text, to_jsonb($2::text)::text)::jsonb
+	WHERE d.transactions @> jsonb_build_array(jsonb_build_object('from_account', $1::text))
+	   OR d.transactions @> jsonb_build_array(jsonb_build_object('to_account', $1::text))
+	   OR d.accounts @> jsonb_build_array(jsonb_build_object('id', $1::text))
+`
+
+// eraseAccount anonymizes everything stored about account in one database
+// transaction. Amounts, statuses and timestamps are left alone so totals and
+// stats are unchanged, and stay covered by the ledger hash chain; identifiers
+// are replaced with a pseudonym, free-text descriptions are cleared and so is
+// the content hash that sealed them.
+func (app *App) eraseAccount(ctx context.Context, subject, reason, actor string) (*ErasureReport, error) {
+	pseudonym, err := erasurePseudonym(subject)
+	if err != nil {
+		return nil, err
+	}
+	// With tokenization on, rows hold the subject's token; dropping its vault
+	// entry below also makes any copy of the token elsewhere meaningless.
+	account, err := app.vault.Resolve(ctx, subject)
+	if err != nil {
+		return nil, err
+	}
+	report := &ErasureReport{ID: uuid.New().String(), Pseudonym: pseudonym, Actor: actor, TransactionIDs: []string{}}
+
+	tx, err := app.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, ledgerLockID); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE transactions SET
+			from_account = CASE WHEN from_account = $1 THEN $2 ELSE from_account END,
+			to_account = CASE WHEN to_account = $1 THEN $2 ELSE to_account END,
+			description = '',
+			content_hash = NULL,
+			erased_at = CURRENT_TIMESTAMP
+		WHERE from_account = $1 OR to_account = $1
+		RETURNING id
+	`, account, pseudonym)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		report.TransactionIDs = append(report.TransactionIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	report.Transactions = int64(len(report.TransactionIDs))
+
+	steps := []struct {
+		count *int64
+		query string
+		args  []interface{}
+	}{
+		{&report.AuditEntries, `
+			UPDATE transaction_audit
+			SET details = replace(details::text, to_jsonb($1::text)::text, to_jsonb($2::text)::text)::jsonb
+			WHERE transaction_id = ANY($3) AND details IS NOT NULL`,
+			[]interface{}{account, pseudonym, pq.Array(report.TransactionIDs)}},
+		{&report.Accounts, `UPDATE accounts SET id = $2, name = '', email = NULL, email_bounced_at = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = $1`,
+			[]interface{}{account, pseudonym}},
+		{&report.Counterparties, `DELETE FROM counterparties WHERE account = $1`, []interface{}{account}},
+		{&report.Datasets, eraseSnapshotTransactions, []interface{}{account, pseudonym}},
+	}
+	if isToken(account) {
+		steps = append(steps, struct {
+			count *int64
+			query string
+			args  []interface{}
+		}{&report.VaultEntries, `DELETE FROM token_vault WHERE token = $1`, []interface{}{account}})
+	}
+	for _, step := range steps {
+		res, err := tx.ExecContext(ctx, step.query, step.args...)
+		if err != nil {
+			return nil, err
+		}
+		*step.count, _ = res.RowsAffected()
+	}
+
+	details := map[string]interface{}{"erasure_id": report.ID, "reason": reason}
+	for _, id := range report.TransactionIDs {
+		if err := recordAudit(ctx, tx, id, "erased", actor, details); err != nil {
+			return nil, err
+		}
+	}
+
+	report.CompletedAt = time.Now().UTC()
+	if app.spool != nil {
+		// Spooled writes aren't queryable by account; report how many are
+		// pending so the operator knows to rerun the erasure after replay.
+		report.SpooledNotErasable = app.spool.Depth()
+	}
+	payload, err := json.Marshal(report)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO privacy_erasures (id, pseudonym, actor, reason, report) VALUES ($1, $2, $3, $4, $5)
+	`, report.ID, pseudonym, actor, reason, payload); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	app.invalidateReadCache(ctx, account)
+	return report, nil
+}
+
+// eraseAccountHandler serves POST /api/admin/privacy/erase. Erasure is
+// irreversible; the erased identifier is not logged or returned.
+func (app *App) eraseAccountHandler(c *gin.Context) {
+	var req struct {
+		Account string `json:"account" binding:"required"`
+		Reason  string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+
+	report, err := app.eraseAccount(c.Request.Context(), req.Account, req.Reason, adminActor(c))
+	if err != nil {
+		app.logCtx(c.Request.Context(), "error", "Data subject erasure failed", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	app.eventCtx(c.Request.Context(), "warn", EventPrivacyErased, report.ID, "Data subject erased", map[string]interface{}{
+		"pseudonym":    report.Pseudonym,
+		"transactions": report.Transactions,
+		"actor":        report.Actor,
+	})
+	c.JSON(http.StatusOK, report)
+}
+
+func (app *App) listErasuresHandler(c *gin.Context) {
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	rows, err := app.readPool().QueryContext(c.Request.Context(), `SELECT report FROM privacy_erasures ORDER BY created_at DESC`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	reports := []json.RawMessage{}
+	for rows.Next() {
+		var raw []byte
+		if err := rows.Scan(&raw); err != nil {
+			continue
+		}
+		reports = append(reports, raw)
+	}
+	c.JSON(http.StatusOK, reports)
+}
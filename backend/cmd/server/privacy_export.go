@@ -0,0 +1,335 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const exportBuildTimeout = 2 * time.Minute
+
+// SubjectExport tracks an asynchronously built subject access archive. The
+// account it was built for is deliberately not stored next to it.
+type SubjectExport struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	Error       string     `json:"error,omitempty"`
+	DownloadURL string     `json:"download_url,omitempty"`
+}
+
+// subjectArchive is everything PayFlow holds about one account.
+type subjectArchive struct {
+	Account      *Account      `json:"account"`
+	Counterparty *Counterparty `json:"counterparty"`
+	Transactions []Transaction `json:"transactions"`
+	Audit        []AuditEntry  `json:"audit"`
+}
+
+func (app *App) initSubjectExports() error {
+	app.exportKey = []byte(app.config.ExportSigningKey)
+	if len(app.exportKey) == 0 {
+		app.exportKey = make([]byte, 32)
+		if _, err := rand.Read(app.exportKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (app *App) exportSignature(id string, expires int64) string {
+	mac := hmac.New(sha256.New, app.exportKey)
+	fmt.Fprintf(mac, "%s|%d", id, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (app *App) exportDownloadURL(id string, expiresAt time.Time) string {
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(expiresAt.Unix(), 10))
+	q.Set("sig", app.exportSignature(id, expiresAt.Unix()))
+	return "/api/privacy/exports/" + id + "/download?" + q.Encode()
+}
+
+func (app *App) collectSubjectData(ctx context.Context, subject string) (*subjectArchive, error) {
+	out := &subjectArchive{Transactions: []Transaction{}, Audit: []AuditEntry{}}
+	account, err := app.vault.Resolve(ctx, subject)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := scanAccount(app.jobPool().QueryRowContext(ctx, `SELECT `+accountColumns+` FROM accounts WHERE id = $1`, account))
+	switch {
+	case err == nil:
+		a.ID = subject
+		out.Account = &a
+	case err != sql.ErrNoRows:
+		return nil, err
+	}
+	if out.Counterparty, err = (tableLookup{db: app.db}).Lookup(ctx, account); err != nil {
+		return nil, err
+	}
+	if out.Counterparty != nil {
+		out.Counterparty.Account = subject
+	}
+
+	rows, err := app.jobPool().QueryContext(ctx, `
+		SELECT id, from_account, to_account, amount, COALESCE(description, ''), status, created_at,
+			COALESCE(status_token, ''), COALESCE(session_id, ''), COALESCE(region, '')
+		FROM transactions
+		WHERE from_account = $1 OR to_account = $1
+		ORDER BY created_at
+	`, account)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []string{}
+	for rows.Next() {
+		var t Transaction
+		if err := rows.Scan(&t.ID, &t.FromAccount, &t.ToAccount, &t.Amount, &t.Description, &t.Status, &t.CreatedAt, &t.StatusToken, &t.SessionID, &t.Region); err != nil {
+			return nil, err
+		}
+		// The subject gets their own identifier back; other parties stay
+		// tokenized.
+		if t.FromAccount == account {
+			t.FromAccount = subject
+		}
+		if t.ToAccount == account {
+			t.ToAccount = subject
+		}
+		out.Transactions = append(out.Transactions, t)
+		ids = append(ids, t.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	audit, err := app.jobPool().QueryContext(ctx, `
+		SELECT id, transaction_id, action, actor, COALESCE(details, 'null'::jsonb), created_at
+		FROM transaction_audit
+		WHERE transaction_id = ANY($1)
+		ORDER BY id
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer audit.Close()
+	for audit.Next() {
+		var e AuditEntry
+		var details []byte
+		if err := audit.Scan(&e.ID, &e.TransactionID, &e.Action, &e.Actor, &details, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.Details = details
+		out.Audit = append(out.Audit, e)
+	}
+	return out, audit.Err()
+}
+
+// buildSubjectArchive zips the data as one JSON document plus CSV files for
+// the tabular parts.
+func buildSubjectArchive(data *subjectArchive) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	add := func(name string, write func(w *bytes.Buffer) error) error {
+		var content bytes.Buffer
+		if err := write(&content); err != nil {
+			return err
+		}
+		f, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(content.Bytes())
+		return err
+	}
+	writeCSV := func(header []string, records [][]string) func(w *bytes.Buffer) error {
+		return func(w *bytes.Buffer) error {
+			cw := csv.NewWriter(w)
+			cw.Write(header)
+			cw.WriteAll(records)
+			return cw.Error()
+		}
+	}
+
+	txnRecords := make([][]string, 0, len(data.Transactions))
+	for _, t := range data.Transactions {
+		txnRecords = append(txnRecords, []string{t.ID, t.FromAccount, t.ToAccount,
+			strconv.FormatFloat(t.Amount, 'f', 2, 64), t.Description, t.Status, t.CreatedAt.Format(time.RFC3339)})
+	}
+	auditRecords := make([][]string, 0, len(data.Audit))
+	for _, e := range data.Audit {
+		auditRecords = append(auditRecords, []string{strconv.FormatInt(e.ID, 10), e.TransactionID, e.Action,
+			e.Actor, string(e.Details), e.CreatedAt.Format(time.RFC3339)})
+	}
+
+	steps := []struct {
+		name  string
+		write func(w *bytes.Buffer) error
+	}{
+		{"data.json", func(w *bytes.Buffer) error {
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			return enc.Encode(data)
+		}},
+		{"transactions.csv", writeCSV([]string{"id", "from_account", "to_account", "amount", "description", "status", "created_at"}, txnRecords)},
+		{"audit.csv", writeCSV([]string{"id", "transaction_id", "action", "actor", "details", "created_at"}, auditRecords)},
+	}
+	for _, step := range steps {
+		if err := add(step.name, step.write); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (app *App) runSubjectExport(id, account string) {
+	ctx, cancel := context.WithTimeout(context.Background(), exportBuildTimeout)
+	defer cancel()
+
+	status, errText := "ready", ""
+	var archive []byte
+	data, err := app.collectSubjectData(ctx, account)
+	if err == nil {
+		archive, err = buildSubjectArchive(data)
+	}
+	if err != nil {
+		status, errText = "failed", err.Error()
+		app.log("error", "Subject export failed", map[string]interface{}{"export_id": id, "error": err.Error()})
+	}
+
+	if _, err := app.jobPool().ExecContext(ctx, `
+		UPDATE subject_exports SET status = $2, error = $3, archive = $4, completed_at = CURRENT_TIMESTAMP WHERE id = $1
+	`, id, status, errText, archive); err != nil {
+		app.log("error", "Failed to store subject export", map[string]interface{}{"export_id": id, "error": err.Error()})
+		return
+	}
+	if status == "ready" {
+		app.event("info", EventPrivacyExportReady, id, "Subject export ready", map[string]interface{}{
+			"transactions": len(data.Transactions),
+			"bytes":        len(archive),
+		})
+	}
+}
+
+// createSubjectExportHandler starts building the archive and answers 202
+// straight away; poll the returned status URL for the download link.
+func (app *App) createSubjectExportHandler(c *gin.Context) {
+	var req struct {
+		Account string `json:"account" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	ctx := c.Request.Context()
+
+	// Archives hold personal data, so they don't outlive their links.
+	if _, err := app.db.ExecContext(ctx, `DELETE FROM subject_exports WHERE expires_at < CURRENT_TIMESTAMP`); err != nil {
+		app.logCtx(ctx, "warn", "Failed to purge expired subject exports", map[string]interface{}{"error": err.Error()})
+	}
+
+	export := SubjectExport{
+		ID:        uuid.New().String(),
+		Status:    "pending",
+		CreatedAt: time.Now().UTC(),
+	}
+	export.ExpiresAt = export.CreatedAt.Add(time.Duration(app.config.ExportLinkTTLSec) * time.Second)
+	if _, err := app.db.ExecContext(ctx, `
+		INSERT INTO subject_exports (id, status, created_at, expires_at) VALUES ($1, $2, $3, $4)
+	`, export.ID, export.Status, export.CreatedAt, export.ExpiresAt); err != nil {
+		app.logCtx(ctx, "error", "Failed to create subject export", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	app.logCtx(ctx, "info", "Subject export requested", map[string]interface{}{"export_id": export.ID, "actor": adminActor(c)})
+
+	go app.runSubjectExport(export.ID, req.Account)
+
+	c.Header("Location", "/api/admin/privacy/exports/"+export.ID)
+	c.JSON(http.StatusAccepted, export)
+}
+
+func (app *App) getSubjectExportHandler(c *gin.Context) {
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	var e SubjectExport
+	var completed sql.NullTime
+	err := app.db.QueryRowContext(c.Request.Context(), `
+		SELECT id, status, created_at, completed_at, expires_at, error FROM subject_exports WHERE id = $1
+	`, c.Param("id")).Scan(&e.ID, &e.Status, &e.CreatedAt, &completed, &e.ExpiresAt, &e.Error)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Export not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if completed.Valid {
+		e.CompletedAt = &completed.Time
+	}
+	if e.Status == "ready" && time.Now().Before(e.ExpiresAt) {
+		e.DownloadURL = app.exportDownloadURL(e.ID, e.ExpiresAt)
+	}
+	c.JSON(http.StatusOK, e)
+}
+
+// downloadSubjectExportHandler serves the archive to holders of a signed
+// link. It sits outside the admin group so the link can be handed on.
+func (app *App) downloadSubjectExportHandler(c *gin.Context) {
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	id := c.Param("id")
+	expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires ||
+		subtle.ConstantTimeCompare([]byte(c.Query("sig")), []byte(app.exportSignature(id, expires))) != 1 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid or expired download link"})
+		return
+	}
+	var archive []byte
+	err = app.db.QueryRowContext(c.Request.Context(), `
+		SELECT archive FROM subject_exports WHERE id = $1 AND status = 'ready'
+	`, id).Scan(&archive)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Export not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="payflow-export-%s.zip"`, id))
+	c.Data(http.StatusOK, "application/zip", archive)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// idleBucketTTL is how long a client's full bucket is kept after its last
+// request; a fresh bucket would be full anyway.
+const idleBucketTTL = time.Minute
+
+var rateLimitedTotal = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "payflow_rate_limited_total",
+		Help: "API requests rejected with 429 by the per-client rate limiter",
+	},
+)
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter is an in-memory token bucket per client: each client may burst
+// up to burst requests and then gets rate requests per second. Limits apply
+// per instance.
+type RateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func newRateLimiter(rps, burst int) *RateLimiter {
+	if burst <= 0 {
+		burst = rps
+	}
+	return &RateLimiter{rate: float64(rps), burst: float64(burst), buckets: map[string]*tokenBucket{}, lastSweep: time.Now()}
+}
+
+// rateDecision is a limiter's answer for one request.
+type rateDecision struct {
+	allowed    bool
+	remaining  int
+	retryAfter time.Duration // until the next request would be allowed
+	reset      time.Duration // until the full burst is available again
+}
+
+// decide takes a token for key and reports the outcome.
+func (l *RateLimiter) decide(key string, now time.Time) rateDecision {
+	ok, tokens, wait := l.allow(key, now)
+	full := time.Duration((l.burst - tokens) / l.rate * float64(time.Second))
+	return rateDecision{allowed: ok, remaining: int(tokens), retryAfter: wait, reset: full}
+}
+
+// allow takes a token for key if one is available. It returns the tokens
+// left and, when refused, how long until the next token.
+func (l *RateLimiter) allow(key string, now time.Time) (bool, float64, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, b.tokens, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, b.tokens, 0
+}
+
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < idleBucketTTL {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > idleBucketTTL {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// rateLimitKey identifies the client: the API key, the other authenticated
+// callers, or the client IP for anonymous requests. Keys are limited one by
+// one rather than by owner, which any admin can reuse across keys.
+// X-Forwarded-For only counts from TRUSTED_PROXIES, so clients can't pick a
+// fresh IP per request.
+func rateLimitKey(c *gin.Context) string {
+	if p := principalFrom(c); p != nil {
+		if p.KeyID != "" {
+			return "apikey:" + p.KeyID
+		}
+		return requestActor(c)
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// parseTrustedProxies parses TRUSTED_PROXIES, a comma-separated list of IPs
+// and CIDRs. It is nil, trusting no proxy, when spec is empty.
+func parseTrustedProxies(spec string) ([]string, error) {
+	var proxies []string
+	for _, p := range strings.Split(spec, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(p); err != nil && net.ParseIP(p) == nil {
+			return nil, fmt.Errorf("%q is not an IP address or CIDR", p)
+		}
+		proxies = append(proxies, p)
+	}
+	return proxies, nil
+}
+
+// rateLimiters are the limiters for one RATE_LIMIT_RPS and RATE_LIMIT_BURST,
+// scaled by a route's multiplier. shared is nil without Redis.
+type rateLimiters struct {
+	rps, burst int
+	local      *RateLimiter
+	shared     *RedisRateLimiter
+}
+
+func (app *App) newRateLimiters(rps, burst int) *rateLimiters {
+	l := &rateLimiters{rps: rps, burst: burst, local: newRateLimiter(rps, burst)}
+	if app.redisClient != nil {
+		l.shared = newRedisRateLimiter(app, rps, burst)
+	}
+	return l
+}
+
+// scaleLimit multiplies a limit by a route's factor, keeping at least one
+// request.
+func scaleLimit(n int, factor float64) int {
+	return int(math.Max(1, math.Round(float64(n)*factor)))
+}
+
+// rateLimitMiddleware enforces RATE_LIMIT_RPS per client on the /api group,
+// answering 429 with Retry-After once the client is over its limit. Every
+// response carries X-RateLimit-Limit, X-RateLimit-Remaining and
+// X-RateLimit-Reset (seconds until the client's full burst is available
+// again). With Redis the limit holds across all replicas; while Redis is
+// unreachable, or CACHE_MODE isn't redis, each instance uses its own buckets.
+// Routes in RATE_LIMIT_ROUTES get a budget of their own, scaled by their
+// multiplier, and routes and clients in RATE_LIMIT_EXEMPT aren't limited.
+// The limits are read from the live config, so a reload or a change through
+// the admin API takes effect on the next request; local buckets start full
+// again when they change.
+func (app *App) rateLimitMiddleware() gin.HandlerFunc {
+	var (
+		mu       sync.Mutex
+		rps      int
+		burst    int
+		byFactor map[float64]*rateLimiters
+		policy   *rateLimitPolicy
+	)
+	limitersFor := func(cfg *Config, factor float64) *rateLimiters {
+		mu.Lock()
+		defer mu.Unlock()
+		if byFactor == nil || rps != cfg.RateLimitRPS || burst != cfg.RateLimitBurst {
+			rps, burst, byFactor = cfg.RateLimitRPS, cfg.RateLimitBurst, map[float64]*rateLimiters{}
+		}
+		l, ok := byFactor[factor]
+		if !ok {
+			base := burst
+			if base <= 0 {
+				base = rps
+			}
+			l = app.newRateLimiters(scaleLimit(rps, factor), scaleLimit(base, factor))
+			byFactor[factor] = l
+		}
+		return l
+	}
+	policyFor := func(cfg *Config) *rateLimitPolicy {
+		mu.Lock()
+		defer mu.Unlock()
+		if policy == nil || policy.routesSpec != cfg.RateLimitRoutes || policy.exemptSpec != cfg.RateLimitExempt {
+			// Both settings were validated when they were set.
+			policy, _ = parseRateLimitPolicy(cfg.RateLimitRoutes, cfg.RateLimitExempt)
+		}
+		return policy
+	}
+	setCacheDegraded("rate_limit", app.redisClient == nil)
+	return func(c *gin.Context) {
+		cfg := app.liveConfig()
+		if cfg.RateLimitRPS <= 0 {
+			c.Next()
+			return
+		}
+		key := rateLimitKey(c)
+		p := policyFor(cfg)
+		if p.exempts(c.FullPath(), key) {
+			c.Next()
+			return
+		}
+		factor := 1.0
+		if route, f, ok := p.route(c.Request.Method, c.FullPath()); ok {
+			factor = f
+			key += " " + route
+		}
+		limiters := limitersFor(cfg, factor)
+		var d rateDecision
+		if limiters.shared == nil || !limiters.shared.allow(c.Request.Context(), key, &d) {
+			d = limiters.local.decide(key, time.Now())
+		}
+		c.Header("X-RateLimit-Limit", strconv.Itoa(limiters.rps))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(d.remaining))
+		c.Header("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(d.reset)))
+		if d.allowed {
+			c.Next()
+			return
+		}
+		rateLimitedTotal.Inc()
+		app.debug(c.Request.Context(), "Request rate limited", map[string]interface{}{"client": key})
+		c.Header("Retry-After", strconv.Itoa(int(math.Max(1, float64(ceilSeconds(d.retryAfter))))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded"})
+		c.Abort()
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/infrasage/payflow/internal/config"
+)
+
+// rateLimitPolicy is RATE_LIMIT_ROUTES and RATE_LIMIT_EXEMPT parsed.
+type rateLimitPolicy struct {
+	routesSpec, exemptSpec string
+
+	// routes maps "/path" and "METHOD /path" to a multiplier.
+	routes map[string]float64
+	exempt map[string]bool
+}
+
+var rateLimitMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+
+// parseRateLimitPolicy parses RATE_LIMIT_ROUTES, route=factor pairs, and
+// RATE_LIMIT_EXEMPT, a list of routes and client keys. Routes are paths as
+// registered, starting with /; client keys are as the limiter names them,
+// source:identity.
+func parseRateLimitPolicy(routes, exempt string) (*rateLimitPolicy, error) {
+	p := &rateLimitPolicy{routesSpec: routes, exemptSpec: exempt, routes: map[string]float64{}, exempt: map[string]bool{}}
+	for _, pair := range strings.Split(routes, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		route, raw, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("RATE_LIMIT_ROUTES: %q must be route=factor", pair)
+		}
+		route, err := normalizeRateLimitRoute(route)
+		if err != nil {
+			return nil, fmt.Errorf("RATE_LIMIT_ROUTES: %v", err)
+		}
+		factor, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if err != nil || factor <= 0 {
+			return nil, fmt.Errorf("RATE_LIMIT_ROUTES: factor for %s must be a positive number", route)
+		}
+		p.routes[route] = factor
+	}
+	for _, entry := range strings.Split(exempt, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.HasPrefix(entry, "/") && !strings.Contains(entry, ":") {
+			return nil, fmt.Errorf("RATE_LIMIT_EXEMPT: %q is neither a route nor a client key such as apikey:<key id>", entry)
+		}
+		p.exempt[entry] = true
+	}
+	return p, nil
+}
+
+// normalizeRateLimitRoute checks a route of RATE_LIMIT_ROUTES, a path with
+// an optional method before it, and uppercases the method.
+func normalizeRateLimitRoute(route string) (string, error) {
+	fields := strings.Fields(route)
+	if len(fields) == 0 || len(fields) > 2 || !strings.HasPrefix(fields[len(fields)-1], "/") {
+		return "", fmt.Errorf("%q must be a path such as /api/transactions, optionally after a method", route)
+	}
+	if len(fields) == 1 {
+		return fields[0], nil
+	}
+	method := strings.ToUpper(fields[0])
+	if !containsString(rateLimitMethods, method) {
+		return "", fmt.Errorf("%q: unknown method %s", route, fields[0])
+	}
+	return method + " " + fields[1], nil
+}
+
+// exempts reports whether requests to route from the client key aren't
+// limited.
+func (p *rateLimitPolicy) exempts(route, key string) bool {
+	return p.exempt[route] || p.exempt[key]
+}
+
+// route returns the RATE_LIMIT_ROUTES entry for a request and its factor.
+// An entry with the request's method wins over one for the path alone.
+func (p *rateLimitPolicy) route(method, path string) (string, float64, bool) {
+	if path == "" {
+		return "", 0, false
+	}
+	for _, route := range []string{method + " " + path, path} {
+		if f, ok := p.routes[route]; ok {
+			return route, f, true
+		}
+	}
+	return "", 0, false
+}
+
+// rateLimitSettings are the settings the admin API can change, by the field
+// of the request body that sets them.
+var rateLimitSettings = map[string]string{
+	"rps":    "RATE_LIMIT_RPS",
+	"burst":  "RATE_LIMIT_BURST",
+	"routes": "RATE_LIMIT_ROUTES",
+	"exempt": "RATE_LIMIT_EXEMPT",
+}
+
+func rateLimitField(env string) config.Field {
+	for _, f := range config.Schema {
+		if f.Env == env {
+			return f
+		}
+	}
+	panic("no config field " + env)
+}
+
+// setRateLimits applies changes, keyed by env name, to the live config on
+// this instance. Like chaos settings they last until the next restart, or
+// until a reload changes the same setting in CONFIG_FILE or the environment.
+// When allow is given and refuses the rateLimitGuardrails violations,
+// nothing changes.
+func (app *App) setRateLimits(changes map[string]string, source func(env string) string, allow func([]GuardrailViolation) bool) error {
+	app.chaosMu.Lock()
+	defer app.chaosMu.Unlock()
+	live := app.liveConfig()
+	next := live.Clone()
+	for env, raw := range changes {
+		if err := rateLimitField(env).Set(next, raw); err != nil {
+			return fmt.Errorf("%s: %v", env, err)
+		}
+		next.SetSource(env, source(env))
+	}
+	if problems := next.Problems(configChecks...); len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	if allow != nil && !allow(rateLimitGuardrails(live, next)) {
+		return errGuardrailsRefused
+	}
+	app.chaosConfig.Store(next)
+	return nil
+}
+
+// rateLimitReport is the limiter's configuration as the admin API shows it.
+func (app *App) rateLimitReport() gin.H {
+	cfg := app.liveConfig()
+	p, _ := parseRateLimitPolicy(cfg.RateLimitRoutes, cfg.RateLimitExempt)
+	exempt := make([]string, 0, len(p.exempt))
+	for entry := range p.exempt {
+		exempt = append(exempt, entry)
+	}
+	sort.Strings(exempt)
+	settings := []config.SettingValue{}
+	for _, s := range cfg.Settings() {
+		for _, env := range rateLimitSettings {
+			if s.Env == env {
+				settings = append(settings, s)
+			}
+		}
+	}
+	sort.Slice(settings, func(i, j int) bool { return settings[i].Env < settings[j].Env })
+	return gin.H{
+		"rps":      cfg.RateLimitRPS,
+		"burst":    cfg.RateLimitBurst,
+		"routes":   p.routes,
+		"exempt":   exempt,
+		"settings": settings,
+	}
+}
+
+func (app *App) getRateLimitsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, app.rateLimitReport())
+}
+
+// updateRateLimitsHandler changes the limits on this instance. Fields left
+// out keep their values; routes and exempt replace the whole list.
+func (app *App) updateRateLimitsHandler(c *gin.Context) {
+	var req struct {
+		RPS    *int               `json:"rps"`
+		Burst  *int               `json:"burst"`
+		Routes map[string]float64 `json:"routes"`
+		Exempt []string           `json:"exempt"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	changes := map[string]string{}
+	if req.RPS != nil {
+		changes["RATE_LIMIT_RPS"] = strconv.Itoa(*req.RPS)
+	}
+	if req.Burst != nil {
+		changes["RATE_LIMIT_BURST"] = strconv.Itoa(*req.Burst)
+	}
+	if req.Routes != nil {
+		pairs := make([]string, 0, len(req.Routes))
+		for route, factor := range req.Routes {
+			pairs = append(pairs, route+"="+strconv.FormatFloat(factor, 'g', -1, 64))
+		}
+		sort.Strings(pairs)
+		changes["RATE_LIMIT_ROUTES"] = strings.Join(pairs, ",")
+	}
+	if req.Exempt != nil {
+		changes["RATE_LIMIT_EXEMPT"] = strings.Join(req.Exempt, ",")
+	}
+	err := app.setRateLimits(changes, func(string) string { return config.SourceRuntime }, func(violations []GuardrailViolation) bool {
+		return app.passGuardrails(c, "rate_limits.update", "", violations)
+	})
+	if errors.Is(err, errGuardrailsRefused) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	app.eventCtx(c.Request.Context(), "info", EventRateLimitsChanged, "", "Rate limits changed", map[string]interface{}{
+		"changes": changes,
+		"actor":   adminActor(c),
+	})
+	c.JSON(http.StatusOK, app.rateLimitReport())
+}
+
+// resetRateLimitsHandler puts the limits on this instance back to what
+// CONFIG_FILE and the environment set.
+func (app *App) resetRateLimitsHandler(c *gin.Context) {
+	app.chaosMu.Lock()
+	loaded := app.loadedConfig
+	if loaded == nil {
+		loaded = app.config
+	}
+	app.chaosMu.Unlock()
+	changes := map[string]string{}
+	for _, env := range rateLimitSettings {
+		changes[env] = fmt.Sprint(rateLimitField(env).Value(loaded))
+	}
+	if err := app.setRateLimits(changes, loaded.Source, nil); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	app.eventCtx(c.Request.Context(), "info", EventRateLimitsChanged, "", "Rate limits reset", map[string]interface{}{
+		"actor": adminActor(c),
+	})
+	c.JSON(http.StatusOK, app.rateLimitReport())
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// redisRateLimitTimeout bounds the Redis round trip; a slower answer counts
+// as Redis being unavailable so the limiter never holds up a request.
+const redisRateLimitTimeout = 50 * time.Millisecond
+
+// gcraScript implements the generic cell rate algorithm. The key holds the
+// client's theoretical arrival time (TAT) in microseconds: a request is
+// allowed if it doesn't push the TAT more than the burst ahead of now. Redis
+// supplies the clock so replicas with skewed clocks still agree.
+//
+// ARGV: emission interval (µs per request), burst tolerance (interval * burst).
+// Returns {allowed, remaining, retry_after_us, reset_us}. The TAT is written
+// with %d because Lua would otherwise round it to 14 significant digits.
+var gcraScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local interval = tonumber(ARGV[1])
+local tolerance = tonumber(ARGV[2])
+
+local tat = tonumber(redis.call('GET', KEYS[1]) or now)
+if tat < now then tat = now end
+local next_tat = tat + interval
+local allow_at = next_tat - tolerance
+if allow_at > now then
+	return {0, 0, allow_at - now, tat - now}
+end
+redis.call('SET', KEYS[1], string.format('%d', next_tat), 'PX', string.format('%d', math.ceil((next_tat - now) / 1000)))
+return {1, math.floor((now - allow_at) / interval), 0, next_tat - now}
+`)
+
+// RedisRateLimiter enforces the per-client limit across every replica by
+// keeping each client's GCRA state in Redis.
+type RedisRateLimiter struct {
+	app       *App
+	interval  int64 // microseconds between requests at the sustained rate
+	tolerance int64 // how far ahead of now a client's TAT may run
+	degraded  int32 // 1 while Redis is failing and the local limiter applies
+}
+
+func newRedisRateLimiter(app *App, rps, burst int) *RedisRateLimiter {
+	if burst <= 0 {
+		burst = rps
+	}
+	interval := int64(time.Second/time.Microsecond) / int64(rps)
+	return &RedisRateLimiter{app: app, interval: interval, tolerance: interval * int64(burst)}
+}
+
+// allow fills d with the cluster-wide decision for key. It returns false when
+// Redis couldn't answer, leaving the caller to decide locally.
+func (l *RedisRateLimiter) allow(ctx context.Context, key string, d *rateDecision) bool {
+	ctx, cancel := context.WithTimeout(ctx, redisRateLimitTimeout)
+	defer cancel()
+	res, err := gcraScript.Run(ctx, l.app.redisClient, []string{"payflow:ratelimit:" + key}, l.interval, l.tolerance).Int64Slice()
+	if err != nil || len(res) != 4 {
+		if atomic.CompareAndSwapInt32(&l.degraded, 0, 1) {
+			setCacheDegraded("rate_limit", true)
+			attrs := map[string]interface{}{}
+			if err != nil {
+				attrs["error"] = err.Error()
+			}
+			l.app.log("warn", "Redis rate limiter unavailable, limiting per instance", attrs)
+		}
+		return false
+	}
+	if atomic.CompareAndSwapInt32(&l.degraded, 1, 0) {
+		setCacheDegraded("rate_limit", false)
+		l.app.log("info", "Redis rate limiter recovered", nil)
+	}
+	*d = rateDecision{
+		allowed:    res[0] == 1,
+		remaining:  int(res[1]),
+		retryAfter: time.Duration(res[2]) * time.Microsecond,
+		reset:      time.Duration(res[3]) * time.Microsecond,
+	}
+	return true
+}
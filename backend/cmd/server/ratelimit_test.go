@@ -0,0 +1,259 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Anonymous clients are limited by their own address; X-Forwarded-For only
+// counts when the request comes through a trusted proxy.
+func TestRateLimitIgnoresSpoofedForwardedFor(t *testing.T) {
+	tests := []struct {
+		name    string
+		proxies string
+		want    int
+	}{
+		{"no trusted proxies", "", http.StatusTooManyRequests},
+		{"other proxy trusted", "10.0.0.0/8", http.StatusTooManyRequests},
+		// httptest requests come from 192.0.2.1.
+		{"through a trusted proxy", "192.0.2.0/24", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t, func(c *Config) {
+				c.RateLimitRPS, c.RateLimitBurst = 1, 1
+				c.TrustedProxies = tt.proxies
+			})
+			r := app.newRouter()
+			if w := serve(r, http.MethodGet, "/api/schemas", nil, map[string]string{"X-Forwarded-For": "203.0.113.1"}); w.Code != http.StatusOK {
+				t.Fatalf("first request = %d, want 200", w.Code)
+			}
+			if w := serve(r, http.MethodGet, "/api/schemas", nil, map[string]string{"X-Forwarded-For": "203.0.113.2"}); w.Code != tt.want {
+				t.Errorf("request with another X-Forwarded-For = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	if got, err := parseTrustedProxies(" 10.0.0.1, 192.168.0.0/16 ,"); err != nil || len(got) != 2 {
+		t.Errorf("parseTrustedProxies = %v, %v", got, err)
+	}
+	if got, err := parseTrustedProxies(""); err != nil || got != nil {
+		t.Errorf("empty: %v, %v; want nil", got, err)
+	}
+	if _, err := parseTrustedProxies("proxy.internal"); err == nil {
+		t.Error("host name accepted")
+	}
+}
+
+func TestParseRateLimitPolicy(t *testing.T) {
+	tests := []struct {
+		routes, exempt string
+		err            string
+	}{
+		{"", "", ""},
+		{"/api/transactions=2, post /api/transactions=0.5", "/api/schemas, apikey:3f9a1c2b7d4e, ip:10.0.0.1", ""},
+		{"/api/transactions", "", "must be route=factor"},
+		{"api/transactions=2", "", "must be a path"},
+		{"FETCH /api/transactions=2", "", "unknown method"},
+		{"/api/transactions=0", "", "positive number"},
+		{"/api/transactions=fast", "", "positive number"},
+		{"", "ops", "neither a route nor a client key"},
+	}
+	for _, tt := range tests {
+		_, err := parseRateLimitPolicy(tt.routes, tt.exempt)
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%q %q: %v", tt.routes, tt.exempt, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%q %q: err = %v, want %q", tt.routes, tt.exempt, err, tt.err)
+		}
+	}
+
+	p, _ := parseRateLimitPolicy("/api/transactions=2, post /api/transactions=0.5", "")
+	for _, tt := range []struct {
+		method, path, route string
+		factor              float64
+	}{
+		{"POST", "/api/transactions", "POST /api/transactions", 0.5},
+		{"GET", "/api/transactions", "/api/transactions", 2},
+		{"GET", "/api/accounts", "", 0},
+	} {
+		route, factor, _ := p.route(tt.method, tt.path)
+		if route != tt.route || factor != tt.factor {
+			t.Errorf("%s %s: %q %g, want %q %g", tt.method, tt.path, route, factor, tt.route, tt.factor)
+		}
+	}
+}
+
+// A route with a multiplier has a budget of its own; exempt routes and
+// clients aren't limited.
+func TestRateLimitRoutesAndExemptions(t *testing.T) {
+	tests := []struct {
+		name   string
+		exempt string
+		path   string
+		want   []int
+	}{
+		{"default budget", "", "/api/schemas", []int{200, 429}},
+		{"own budget", "", "/api/schemas/update-chaos", []int{200, 200, 200, 429}},
+		{"exempt route", "/api/openapi.json", "/api/openapi.json", []int{200, 200, 200}},
+		// httptest requests come from 192.0.2.1.
+		{"exempt client", "ip:192.0.2.1", "/api/schemas", []int{200, 200, 200}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t, func(c *Config) {
+				c.RateLimitRPS, c.RateLimitBurst = 1, 1
+				c.RateLimitRoutes = "/api/schemas/:name=3"
+				c.RateLimitExempt = tt.exempt
+			})
+			r := app.newRouter()
+			// The other routes' budgets are untouched by this one.
+			if tt.path != "/api/schemas" {
+				serve(r, http.MethodGet, "/api/schemas", nil, nil)
+			}
+			for i, want := range tt.want {
+				if w := serve(r, http.MethodGet, tt.path, nil, nil); w.Code != want {
+					t.Fatalf("request %d = %d, want %d", i+1, w.Code, want)
+				}
+			}
+		})
+	}
+}
+
+// Two keys of one owner get a budget each, and a key is exempted by its ID.
+func TestRateLimitPerAPIKey(t *testing.T) {
+	app := newTestApp(t, func(c *Config) {
+		c.RateLimitRPS, c.RateLimitBurst = 1, 1
+		c.RateLimitExempt = "apikey:bbbbbbbbbbbb"
+	})
+	db, err := sql.Open("postgres", "host=127.0.0.1 port=1 sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	app.db = db
+	keys := map[string]string{}
+	for _, id := range []string{"aaaaaaaaaaaa", "bbbbbbbbbbbb", "cccccccccccc"} {
+		key := apiKeyPrefix + id + "_secret"
+		keys[id] = key
+		app.apiKeys.put(id, cachedAPIKey{principal: &Principal{Subject: "ops", Source: "apikey", KeyID: id}, hash: hashAPIKey(key)})
+	}
+	r := app.newRouter()
+	get := func(id string) int {
+		return serve(r, http.MethodGet, "/api/schemas", nil, map[string]string{"X-API-Key": keys[id]}).Code
+	}
+
+	if a1, a2 := get("aaaaaaaaaaaa"), get("aaaaaaaaaaaa"); a1 != http.StatusOK || a2 != http.StatusTooManyRequests {
+		t.Fatalf("first key: %d then %d, want 200 then 429", a1, a2)
+	}
+	if c := get("cccccccccccc"); c != http.StatusOK {
+		t.Errorf("second key of the same owner = %d, want its own budget", c)
+	}
+	for i := 0; i < 3; i++ {
+		if b := get("bbbbbbbbbbbb"); b != http.StatusOK {
+			t.Fatalf("exempt key, request %d = %d", i+1, b)
+		}
+	}
+}
+
+func TestRateLimitAdminAPI(t *testing.T) {
+	app := newTestApp(t, nil)
+	r := app.newRouter()
+
+	// Dropping from 100 to 1 rps trips the guardrails.
+	w := serve(r, http.MethodPut, "/api/admin/rate-limits?force=true", map[string]interface{}{
+		"rps": 1, "burst": 1, "routes": map[string]float64{"GET /api/schemas/:name": 2}, "exempt": []string{"/api/admin/rate-limits"},
+	}, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT = %d %s", w.Code, w.Body)
+	}
+	var report struct {
+		RPS      int                `json:"rps"`
+		Routes   map[string]float64 `json:"routes"`
+		Settings []struct {
+			Env    string `json:"env"`
+			Source string `json:"source"`
+		} `json:"settings"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &report)
+	if report.RPS != 1 || report.Routes["GET /api/schemas/:name"] != 2 || len(report.Settings) != 4 {
+		t.Errorf("report %+v", report)
+	}
+	for _, s := range report.Settings {
+		if s.Source != "runtime" {
+			t.Errorf("%s from %s, want runtime", s.Env, s.Source)
+		}
+	}
+	serve(r, http.MethodGet, "/api/schemas", nil, nil)
+	if w := serve(r, http.MethodGet, "/api/schemas", nil, nil); w.Code != http.StatusTooManyRequests {
+		t.Errorf("second request at 1 rps = %d, want 429", w.Code)
+	}
+
+	if w := serve(r, http.MethodPut, "/api/admin/rate-limits", map[string]interface{}{"exempt": []string{"ops"}}, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid exemption = %d, want 400", w.Code)
+	}
+	if w := serve(r, http.MethodPut, "/api/admin/rate-limits", map[string]interface{}{"rps": -1}, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("negative rps = %d, want 400", w.Code)
+	}
+
+	if w := serve(r, http.MethodDelete, "/api/admin/rate-limits", nil, nil); w.Code != http.StatusOK {
+		t.Fatalf("DELETE = %d %s", w.Code, w.Body)
+	}
+	cfg := app.liveConfig()
+	if cfg.RateLimitRPS != 100 || cfg.RateLimitRoutes != "" || cfg.RateLimitExempt != "" || cfg.Source("RATE_LIMIT_RPS") != "default" {
+		t.Errorf("after reset: rps %d routes %q exempt %q from %s", cfg.RateLimitRPS, cfg.RateLimitRoutes, cfg.RateLimitExempt, cfg.Source("RATE_LIMIT_RPS"))
+	}
+	if w := serve(r, http.MethodGet, "/api/schemas", nil, nil); w.Code != http.StatusOK {
+		t.Errorf("request after reset = %d, want 200", w.Code)
+	}
+}
+
+// With Redis, replicas share one budget per client; when Redis goes away
+// each falls back to limiting on its own.
+func TestRateLimitSharedAcrossReplicas(t *testing.T) {
+	redisServer := startFakeRedis(t)
+	replica := func() http.Handler {
+		app := newTestApp(t, func(c *Config) { c.RateLimitRPS, c.RateLimitBurst = 1, 2 })
+		app.redisClient = redis.NewClient(&redis.Options{Addr: redisServer.addr, MaxRetries: -1})
+		t.Cleanup(func() { app.redisClient.Close() })
+		return app.newRouter()
+	}
+	a, b := replica(), replica()
+
+	for i, h := range []http.Handler{a, b} {
+		if w := serve(h, http.MethodGet, "/api/schemas", nil, nil); w.Code != http.StatusOK {
+			t.Fatalf("request %d = %d, want 200", i+1, w.Code)
+		}
+	}
+	w := serve(a, http.MethodGet, "/api/schemas", nil, nil)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("third request across replicas = %d, want 429", w.Code)
+	}
+	if w.Header().Get("Retry-After") != "1" || w.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Errorf("429 headers %v", w.Header())
+	}
+	if got := cacheDegradedValue(t, "rate_limit"); got != 0 {
+		t.Errorf("rate_limit degraded = %v with Redis up", got)
+	}
+
+	redisServer.stop()
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if w := serve(b, http.MethodGet, "/api/schemas", nil, nil); w.Code != want {
+			t.Fatalf("without Redis, request %d = %d, want %d", i+1, w.Code, want)
+		}
+	}
+	if got := cacheDegradedValue(t, "rate_limit"); got != 1 {
+		t.Errorf("rate_limit degraded = %v with Redis down, want 1", got)
+	}
+}
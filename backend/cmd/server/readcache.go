@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readCacheGenKey holds the read cache generation. Every cached response key
+// includes it, so bumping it on a write invalidates all of them at once, on
+// every replica, without having to find the affected keys.
+const readCacheGenKey = "payflow:cache:gen"
+
+// invalidateReadCache drops every cached read, and the cached counterparty
+// of each of accounts, in one round trip. Call it after any write that
+// changes transactions or what is shown alongside them.
+func (app *App) invalidateReadCache(ctx context.Context, accounts ...string) {
+	var writes []cacheWrite
+	store := app.readCache
+	if store != nil {
+		writes = append(writes, cacheWrite{op: cacheIncr, key: readCacheGenKey})
+	}
+	// initCache gives both caches the same store.
+	if app.enricher != nil && app.enricher.cache != nil {
+		store = app.enricher.cache
+		for _, account := range accounts {
+			writes = append(writes, cacheWrite{op: cacheDel, key: counterpartyCacheKey(account)})
+		}
+	}
+	if len(writes) == 0 {
+		return
+	}
+	// While Redis is down nothing can be served from it, and checkCache
+	// bumps the generation before it is used again.
+	if err := store.Write(ctx, writes); err != nil && err != errCacheUnavailable {
+		app.logCtx(ctx, "warn", "Read cache invalidation failed", map[string]interface{}{"error": err.Error()})
+	}
+}
+
+type bodyCapture struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bodyCapture) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyCapture) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// cacheAside serves GET responses from the cache and caches successful ones for
+// CACHE_TTL. Responses are keyed by the route name, demo session, display
+// locale and query string. Requests with per-request feature overrides or
+// forced debug logging bypass the cache so they still reach the handler.
+func (app *App) cacheAside(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rc := app.readCache
+		ttl := app.liveConfig().CacheTTL
+		if rc == nil || ttl <= 0 || c.GetHeader("X-Feature-Overrides") != "" || debugForced(c.Request.Context()) {
+			c.Next()
+			return
+		}
+		ctx := c.Request.Context()
+		gen, err := cacheGeneration(ctx, rc)
+		if err != nil {
+			// Without the generation a hit could be stale; serve uncached.
+			c.Next()
+			return
+		}
+		sum := sha256.Sum256([]byte(sessionID(c) + "|" + app.requestLocale(c) + "|" + c.Request.URL.RawQuery))
+		key := "payflow:cache:" + strconv.FormatInt(gen, 10) + ":" + name + ":" + hex.EncodeToString(sum[:16])
+
+		if raw, err := rc.Get(ctx, key); err == nil {
+			atomic.AddInt64(&app.cacheHits, 1)
+			c.Header("X-Cache", "HIT")
+			c.Data(http.StatusOK, "application/json; charset=utf-8", raw)
+			c.Abort()
+			return
+		}
+		atomic.AddInt64(&app.cacheMisses, 1)
+
+		w := &bodyCapture{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Header("X-Cache", "MISS")
+		c.Next()
+		if w.Status() == http.StatusOK && w.body.Len() > 0 {
+			rc.Set(ctx, key, w.body.Bytes(), time.Duration(ttl)*time.Second)
+		}
+	}
+}
+
+func cacheGeneration(ctx context.Context, rc kvCache) (int64, error) {
+	raw, err := rc.Get(ctx, readCacheGenKey)
+	if err == errCacheMiss {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(string(raw), 10, 64)
+}
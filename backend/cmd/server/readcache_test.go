@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// newReadCacheTestRouter serves GET /api/cached through the read cache,
+// counting the requests that reach the handler. The handler answers with
+// ?status= when one is given. X-Debug-Log is honored, since callers count as
+// admins without ADMIN_TOKEN.
+func newReadCacheTestRouter(app *App) (*gin.Engine, *int) {
+	calls := 0
+	r := gin.New()
+	r.Use(app.debugSamplingMiddleware(), app.debugHeaderMiddleware())
+	r.GET("/api/cached", app.cacheAside("cached"), func(c *gin.Context) {
+		calls++
+		status := http.StatusOK
+		if s := c.Query("status"); s != "" {
+			status, _ = strconv.Atoi(s)
+		}
+		c.JSON(status, gin.H{"calls": calls})
+	})
+	return r, &calls
+}
+
+func newMemoryCacheTestApp(t *testing.T) *App {
+	app := newTestApp(t, func(c *Config) { c.CacheMode = "memory" })
+	app.initCache()
+	return app
+}
+
+func TestReadCacheHitAndMiss(t *testing.T) {
+	app := newMemoryCacheTestApp(t)
+	r, calls := newReadCacheTestRouter(app)
+
+	for i, want := range []string{"MISS", "HIT", "HIT"} {
+		w := serve(r, http.MethodGet, "/api/cached?limit=1", nil, nil)
+		if got := w.Header().Get("X-Cache"); got != want {
+			t.Errorf("request %d: X-Cache %q, want %q", i, got, want)
+		}
+		if w.Body.String() != `{"calls":1}` {
+			t.Errorf("request %d: body %s", i, w.Body)
+		}
+	}
+	// Another query string is another entry.
+	if w := serve(r, http.MethodGet, "/api/cached?limit=2", nil, nil); w.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("other query: X-Cache %q, want MISS", w.Header().Get("X-Cache"))
+	}
+	if *calls != 2 {
+		t.Errorf("handler ran %d times, want 2", *calls)
+	}
+}
+
+func TestReadCacheInvalidation(t *testing.T) {
+	app := newMemoryCacheTestApp(t)
+	r, calls := newReadCacheTestRouter(app)
+
+	serve(r, http.MethodGet, "/api/cached", nil, nil)
+	app.invalidateReadCache(context.Background())
+	w := serve(r, http.MethodGet, "/api/cached", nil, nil)
+	if w.Header().Get("X-Cache") != "MISS" || *calls != 2 {
+		t.Errorf("after invalidation: X-Cache %q, handler ran %d times", w.Header().Get("X-Cache"), *calls)
+	}
+	if w := serve(r, http.MethodGet, "/api/cached", nil, nil); w.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("after refill: X-Cache %q, want HIT", w.Header().Get("X-Cache"))
+	}
+}
+
+func TestReadCacheBypass(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		headers map[string]string
+	}{
+		{"feature overrides", "/api/cached", map[string]string{"X-Feature-Overrides": "feature_new_cache=true"}},
+		{"debug log", "/api/cached", map[string]string{"X-Debug-Log": "1"}},
+		{"error response", "/api/cached?status=500", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newMemoryCacheTestApp(t)
+			r, calls := newReadCacheTestRouter(app)
+			serve(r, http.MethodGet, tt.path, nil, tt.headers)
+			serve(r, http.MethodGet, tt.path, nil, tt.headers)
+			if *calls != 2 {
+				t.Errorf("handler ran %d times, want 2", *calls)
+			}
+		})
+	}
+
+	app := newTestApp(t, func(c *Config) { c.CacheMode = "off" })
+	app.initCache()
+	r, calls := newReadCacheTestRouter(app)
+	serve(r, http.MethodGet, "/api/cached", nil, nil)
+	if w := serve(r, http.MethodGet, "/api/cached", nil, nil); w.Header().Get("X-Cache") != "" || *calls != 2 {
+		t.Errorf("CACHE_MODE=off: X-Cache %q, handler ran %d times", w.Header().Get("X-Cache"), *calls)
+	}
+}
+
+// fakeRedis answers PING, GET, SET, DEL and INCR on a local port, enough for
+// the read cache, and EVAL of gcraScript for the rate limiter. It can be
+// stopped and started again on the same address.
+type fakeRedis struct {
+	t     *testing.T
+	addr  string
+	mu    sync.Mutex
+	ln    net.Listener
+	conns map[net.Conn]bool
+	data  map[string]string
+}
+
+func startFakeRedis(t *testing.T) *fakeRedis {
+	f := &fakeRedis{t: t, addr: "127.0.0.1:0", conns: map[net.Conn]bool{}, data: map[string]string{}}
+	f.start()
+	f.addr = f.ln.Addr().String()
+	t.Cleanup(f.stop)
+	return f
+}
+
+func (f *fakeRedis) start() {
+	ln, err := net.Listen("tcp", f.addr)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	f.ln = ln
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			f.mu.Lock()
+			f.conns[conn] = true
+			f.mu.Unlock()
+			go f.serve(conn)
+		}
+	}()
+}
+
+// stop closes the listener and every open connection, as Redis going away
+// would.
+func (f *fakeRedis) stop() {
+	f.ln.Close()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for conn := range f.conns {
+		conn.Close()
+		delete(f.conns, conn)
+	}
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+	for {
+		args, err := readRESP(rd)
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		var reply string
+		switch strings.ToUpper(args[0]) {
+		case "PING":
+			reply = "+PONG\r\n"
+		case "GET":
+			if v, ok := f.data[args[1]]; ok {
+				reply = fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+			} else {
+				reply = "$-1\r\n"
+			}
+		case "SET":
+			f.data[args[1]] = args[2]
+			reply = "+OK\r\n"
+		case "DEL":
+			delete(f.data, args[1])
+			reply = ":1\r\n"
+		case "INCR":
+			n, _ := strconv.Atoi(f.data[args[1]])
+			f.data[args[1]] = strconv.Itoa(n + 1)
+			reply = fmt.Sprintf(":%d\r\n", n+1)
+		case "EVALSHA":
+			// go-redis sends the script itself after this.
+			reply = "-NOSCRIPT No matching script\r\n"
+		case "EVAL":
+			reply = f.gcra(args[3], args[4], args[5])
+		default:
+			reply = "-ERR unknown command\r\n"
+		}
+		f.mu.Unlock()
+		conn.Write([]byte(reply))
+	}
+}
+
+// gcra does what gcraScript does, with the local clock as Redis TIME.
+func (f *fakeRedis) gcra(key, intervalArg, toleranceArg string) string {
+	now := time.Now().UnixMicro()
+	interval, _ := strconv.ParseInt(intervalArg, 10, 64)
+	tolerance, _ := strconv.ParseInt(toleranceArg, 10, 64)
+	tat := now
+	if v, ok := f.data[key]; ok {
+		tat, _ = strconv.ParseInt(v, 10, 64)
+	}
+	if tat < now {
+		tat = now
+	}
+	next := tat + interval
+	allowAt := next - tolerance
+	reply := func(vals ...int64) string {
+		var b strings.Builder
+		fmt.Fprintf(&b, "*%d\r\n", len(vals))
+		for _, v := range vals {
+			fmt.Fprintf(&b, ":%d\r\n", v)
+		}
+		return b.String()
+	}
+	if allowAt > now {
+		return reply(0, 0, allowAt-now, tat-now)
+	}
+	f.data[key] = strconv.FormatInt(next, 10)
+	return reply(1, (now-allowAt)/interval, 0, next-now)
+}
+
+func readRESP(rd *bufio.Reader) ([]string, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("bad command %q", line)
+	}
+	args := make([]string, n)
+	for i := range args {
+		// Bulk strings are read by length: scripts span lines.
+		header, err := rd.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(header, "$")))
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("bad argument %q", header)
+		}
+		arg := make([]byte, size+2)
+		if _, err := io.ReadFull(rd, arg); err != nil {
+			return nil, err
+		}
+		args[i] = string(arg[:size])
+	}
+	return args, nil
+}
+
+func cacheDegradedValue(t *testing.T, feature string) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := cacheDegraded.WithLabelValues(feature).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetGauge().GetValue()
+}
+
+func TestReadCacheFollowsRedis(t *testing.T) {
+	redisServer := startFakeRedis(t)
+	redisServer.stop()
+
+	app := newTestApp(t, func(c *Config) { c.CacheMode = "redis" })
+	app.redisClient = redis.NewClient(&redis.Options{Addr: redisServer.addr, MaxRetries: -1})
+	t.Cleanup(func() { app.redisClient.Close() })
+	app.initCache()
+	r, calls := newReadCacheTestRouter(app)
+	ctx := context.Background()
+
+	// Down at startup: reads bypass the cache.
+	if w := serve(r, http.MethodGet, "/api/cached", nil, nil); w.Header().Get("X-Cache") != "" {
+		t.Errorf("Redis down at startup: X-Cache %q, want none", w.Header().Get("X-Cache"))
+	}
+	if cacheDegradedValue(t, "read_cache") != 1 {
+		t.Error("read_cache not reported degraded while Redis is down")
+	}
+
+	// Redis comes up: the next check turns the cache on.
+	redisServer.start()
+	app.checkCache(ctx)
+	if cacheDegradedValue(t, "read_cache") != 0 {
+		t.Error("read_cache still reported degraded after Redis came up")
+	}
+	serve(r, http.MethodGet, "/api/cached", nil, nil)
+	if w := serve(r, http.MethodGet, "/api/cached", nil, nil); w.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("after recovery: X-Cache %q, want HIT", w.Header().Get("X-Cache"))
+	}
+
+	// Redis goes away: the cache is bypassed again.
+	redisServer.stop()
+	app.checkCache(ctx)
+	if cacheDegradedValue(t, "read_cache") != 1 {
+		t.Error("read_cache not reported degraded after Redis went down")
+	}
+	before := *calls
+	if w := serve(r, http.MethodGet, "/api/cached", nil, nil); w.Header().Get("X-Cache") != "" || *calls != before+1 {
+		t.Errorf("Redis down: X-Cache %q, handler ran %d more times", w.Header().Get("X-Cache"), *calls-before)
+	}
+
+	// Coming back bumps the generation, so entries from before the outage
+	// aren't served.
+	redisServer.start()
+	app.checkCache(ctx)
+	if w := serve(r, http.MethodGet, "/api/cached", nil, nil); w.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("after second recovery: X-Cache %q, want MISS", w.Header().Get("X-Cache"))
+	}
+}
+
+// roundTrips counts the round trips a Redis client makes: one per command,
+// or one per pipeline.
+type roundTrips struct{ n int }
+
+func (h *roundTrips) BeforeProcess(ctx context.Context, _ redis.Cmder) (context.Context, error) {
+	h.n++
+	return ctx, nil
+}
+
+func (h *roundTrips) AfterProcess(context.Context, redis.Cmder) error { return nil }
+
+func (h *roundTrips) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	h.n++
+	return ctx, nil
+}
+
+func (h *roundTrips) AfterProcessPipeline(context.Context, []redis.Cmder) error { return nil }
+
+type mapLookup map[string]*Counterparty
+
+func (m mapLookup) Lookup(_ context.Context, account string) (*Counterparty, error) {
+	return m[account], nil
+}
+
+// A write's invalidation and the payees a read looked up each reach Redis
+// in one round trip.
+func TestCacheWritesPipelined(t *testing.T) {
+	redisServer := startFakeRedis(t)
+	app := newTestApp(t, func(c *Config) { c.CacheMode = "redis" })
+	app.redisClient = redis.NewClient(&redis.Options{Addr: redisServer.addr, MaxRetries: -1})
+	t.Cleanup(func() { app.redisClient.Close() })
+	trips := &roundTrips{}
+	app.redisClient.AddHook(trips)
+	app.enricher = &Enricher{app: app, lookup: mapLookup{"MER-1": {Name: "Coffee"}, "MER-2": {Name: "Rent"}}, ttl: time.Minute}
+	app.initCache()
+	ctx := context.Background()
+
+	txns := []Transaction{{ToAccount: "MER-1"}, {ToAccount: "MER-2"}, {ToAccount: "MER-1"}, {ToAccount: "MER-3"}}
+	trips.n = 0
+	app.enricher.Annotate(ctx, txns)
+	if trips.n != 4 {
+		t.Errorf("annotating 3 payees took %d round trips, want 3 reads and 1 write", trips.n)
+	}
+	if txns[2].Counterparty == nil || txns[2].Counterparty.Name != "Coffee" || txns[3].Counterparty != nil {
+		t.Errorf("annotated %+v %+v", txns[2].Counterparty, txns[3].Counterparty)
+	}
+	redisServer.mu.Lock()
+	_, cached := redisServer.data[counterpartyCacheKey("MER-3")]
+	redisServer.mu.Unlock()
+	if !cached {
+		t.Error("unknown payee not cached")
+	}
+
+	trips.n = 0
+	app.invalidateReadCache(ctx, "MER-1", "MER-2")
+	if trips.n != 1 {
+		t.Errorf("invalidation took %d round trips, want 1", trips.n)
+	}
+	redisServer.mu.Lock()
+	defer redisServer.mu.Unlock()
+	_, stale := redisServer.data[counterpartyCacheKey("MER-1")]
+	if gen := redisServer.data[readCacheGenKey]; gen != "1" || stale {
+		t.Errorf("after invalidation: generation %q, MER-1 still cached %v", gen, stale)
+	}
+}
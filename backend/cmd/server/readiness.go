@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fraudQueueDegradedRatio is how full the fraud queue may get before
+// readiness reports it degraded. Once it is full, new transactions wait for
+// the pending-check sweep instead of being analyzed right away.
+const fraudQueueDegradedRatio = 0.9
+
+// DependencyCheck is one dependency's entry in the /ready breakdown. Status
+// is ok, degraded, down or disabled. Only critical dependencies being down
+// makes the instance not ready; the others degrade it.
+type DependencyCheck struct {
+	Status    string `json:"status"`
+	Critical  bool   `json:"critical"`
+	LatencyMs int64  `json:"latency_ms,omitempty"`
+	Detail    string `json:"detail,omitempty"`
+}
+
+// readinessError reports whether the instance can serve at all, which is
+// only the case while it reaches its database.
+func (app *App) readinessError(ctx context.Context) error {
+	if app.db != nil {
+		return app.pingDB(ctx)
+	}
+	return nil
+}
+
+// readinessChecks probes every dependency /ready reports on.
+func (app *App) readinessChecks(ctx context.Context) map[string]DependencyCheck {
+	return map[string]DependencyCheck{
+		"database":    app.checkDatabaseReady(ctx),
+		"redis":       app.checkRedisReady(ctx),
+		"event_bus":   app.checkEventBusReady(),
+		"fraud_queue": app.checkFraudQueueReady(),
+	}
+}
+
+// timed runs probe and fills in the check's latency and status from it.
+func timed(check DependencyCheck, failed string, probe func() error) DependencyCheck {
+	start := time.Now()
+	err := probe()
+	check.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		check.Status, check.Detail = failed, err.Error()
+	}
+	return check
+}
+
+func (app *App) checkDatabaseReady(ctx context.Context) DependencyCheck {
+	check := DependencyCheck{Status: "ok", Critical: true}
+	if app.db == nil {
+		// Without a database the handlers answer 503 on their own; the
+		// instance itself is as ready as it will get.
+		check.Status, check.Detail = "disabled", "no database connection"
+		return check
+	}
+	return timed(check, "down", func() error { return app.pingDB(ctx) })
+}
+
+// checkRedisReady only counts when Redis backs the caches. Losing it drops
+// them to local state, so it degrades rather than fails readiness.
+func (app *App) checkRedisReady(ctx context.Context) DependencyCheck {
+	check := DependencyCheck{Status: "ok"}
+	if app.config.CacheMode != "redis" {
+		check.Status, check.Detail = "disabled", "CACHE_MODE is "+app.config.CacheMode
+		return check
+	}
+	if app.redisClient == nil {
+		check.Status, check.Detail = "disabled", "no Redis client"
+		return check
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	return timed(check, "degraded", func() error { return app.redisClient.Ping(ctx).Err() })
+}
+
+// checkEventBusReady reports whether events are getting out. A configured
+// bus whose publisher couldn't be set up at startup is down for good.
+func (app *App) checkEventBusReady() DependencyCheck {
+	check := DependencyCheck{Status: "ok", Detail: app.config.EventBus}
+	if app.config.EventBus == "none" {
+		check.Status, check.Detail = "disabled", "EVENT_BUS is none"
+		return check
+	}
+	switch p := app.publisher.(type) {
+	case nil, noopPublisher:
+		check.Status, check.Detail = "down", "no "+app.config.EventBus+" publisher"
+	default:
+		if err := p.Health(); err != nil {
+			check.Status, check.Detail = "degraded", err.Error()
+		}
+	}
+	return check
+}
+
+// checkFraudQueueReady degrades once the fraud queue is nearly full or the
+// pool is draining for shutdown.
+func (app *App) checkFraudQueueReady() DependencyCheck {
+	check := DependencyCheck{Status: "ok"}
+	if app.fraudPool == nil {
+		check.Status, check.Detail = "disabled", "fraud workers not running"
+		return check
+	}
+	queued, capacity := app.fraudPool.Load()
+	check.Detail = fmt.Sprintf("%d/%d queued", queued, capacity)
+	if app.fraudPool.Closed() {
+		check.Status, check.Detail = "degraded", "draining"
+	} else if float64(queued) >= fraudQueueDegradedRatio*float64(capacity) {
+		check.Status = "degraded"
+	}
+	return check
+}
+
+// readinessHandler answers 503 "not ready" when a critical dependency is
+// down and 503 "standby" on a passive failover member. Otherwise it answers
+// 200, "degraded" when a non-critical dependency isn't ok; ?strict=true
+// turns that into a 503 for probes that would rather take the instance out.
+func (app *App) readinessHandler(c *gin.Context) {
+	checks := app.readinessChecks(c.Request.Context())
+	body := gin.H{"status": "ready", "checks": checks}
+	code := http.StatusOK
+	for _, check := range checks {
+		if check.Critical && check.Status == "down" {
+			body["status"], code = "not ready", http.StatusServiceUnavailable
+			break
+		}
+		if check.Status == "degraded" || check.Status == "down" {
+			body["status"] = "degraded"
+			if c.Query("strict") == "true" {
+				code = http.StatusServiceUnavailable
+			}
+		}
+	}
+	if app.failover != nil {
+		// A passive standby reports not ready so load balancers only route
+		// to the active member of the pair.
+		status := app.failover.Status()
+		body["failover"] = status
+		if !status.Active && body["status"] != "not ready" {
+			body["status"], code = "standby", http.StatusServiceUnavailable
+		}
+	}
+	c.JSON(code, body)
+}
@@ -0,0 +1,528 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"mime"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	apihttp "github.com/infrasage/payflow/internal/http"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	receiptPollInterval = 5 * time.Second
+	receiptClaimBatch   = 20
+	// receiptSendTimeout bounds one SMTP conversation, dial to QUIT.
+	receiptSendTimeout = 30 * time.Second
+	// receiptLease outlasts receiptSendTimeout, so a receipt is only
+	// claimed again if the instance sending it died mid-attempt.
+	receiptLease = 2 * time.Minute
+)
+
+var receiptEmailsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "payflow_receipt_emails_total",
+		Help: "Receipt email attempts by result (sent, retrying, failed, bounced, skipped)",
+	},
+	[]string{"result"},
+)
+
+// defaultReceiptTemplate is used unless RECEIPT_TEMPLATE_FILE names another.
+// A template must define "subject" and "body"; both see a receiptData.
+const defaultReceiptTemplate = `{{define "subject"}}Receipt for your payment of {{.Amount}}{{end}}
+{{define "body"}}Hello {{.AccountName}},
+
+Your payment has settled.
+
+  Amount:       {{.Amount}}
+  Paid to:      {{.Payee}}
+  Description:  {{if .Description}}{{.Description}}{{else}}-{{end}}
+  Settled:      {{.SettledAt.Format "2 Jan 2006 15:04 MST"}}
+  Reference:    {{.TransactionID}}
+
+This receipt was sent to the address on account {{.Account}}.
+{{end}}`
+
+// receiptData is what receipt templates are executed with.
+type receiptData struct {
+	Account       string
+	AccountName   string
+	TransactionID string
+	Payee         string
+	Amount        string
+	Description   string
+	SettledAt     time.Time
+}
+
+// ReceiptEmail is one receipt on its way to an account's email address.
+// Status is pending until it is sent, bounced, skipped because the account
+// no longer has a deliverable address, or failed RECEIPT_MAX_ATTEMPTS times.
+type ReceiptEmail struct {
+	ID            string     `json:"id"`
+	TransactionID string     `json:"transaction_id"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	SentAt        *time.Time `json:"sent_at,omitempty"`
+}
+
+type receiptJob struct {
+	id       string
+	attempts int
+	account  string
+	email    string
+	bounced  bool
+	data     receiptData
+}
+
+// errRecipientRejected marks a permanent (5xx) refusal of the recipient by
+// the SMTP server: the address bounced, and retrying won't help.
+var errRecipientRejected = errors.New("recipient rejected")
+
+// ReceiptMailer sends the receipts queued in receipt_emails from a
+// background loop, claimed the way the webhook dispatcher claims
+// deliveries, so every replica can run it.
+type ReceiptMailer struct {
+	app      *App
+	template *template.Template
+	from     *mail.Address
+	wake     chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// loadReceiptTemplate parses RECEIPT_TEMPLATE_FILE, or the built-in
+// template when path is empty, and checks it defines subject and body.
+func loadReceiptTemplate(path string) (*template.Template, error) {
+	text := defaultReceiptTemplate
+	if path != "" {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		text = string(raw)
+	}
+	tmpl, err := template.New("receipt").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range []string{"subject", "body"} {
+		if tmpl.Lookup(name) == nil {
+			return nil, fmt.Errorf("template does not define %q", name)
+		}
+	}
+	return tmpl, nil
+}
+
+// initReceipts sets up the mailer when RECEIPT_EMAILS is on. Without it no
+// receipts are queued.
+func (app *App) initReceipts() error {
+	if !app.config.ReceiptEmails {
+		return nil
+	}
+	tmpl, err := loadReceiptTemplate(app.config.ReceiptTemplateFile)
+	if err != nil {
+		return fmt.Errorf("RECEIPT_TEMPLATE_FILE: %w", err)
+	}
+	from, err := mail.ParseAddress(app.config.SMTPFrom)
+	if err != nil {
+		return fmt.Errorf("SMTP_FROM: %w", err)
+	}
+	app.receipts = &ReceiptMailer{
+		app:      app,
+		template: tmpl,
+		from:     from,
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	return nil
+}
+
+func (app *App) startReceipts() {
+	if app.receipts != nil {
+		go app.receipts.run()
+	}
+}
+
+// Close stops the send loop once the receipts in flight are sent.
+func (m *ReceiptMailer) Close() {
+	if m == nil {
+		return
+	}
+	close(m.stop)
+	<-m.done
+}
+
+// queueReceipt queues a receipt for the payer of txn inside tx, if txn
+// settled and the payer is an account of its session with an address that
+// hasn't bounced. Queuing with the transaction means a receipt is sent for
+// exactly the transactions that commit.
+func (app *App) queueReceipt(ctx context.Context, tx *sql.Tx, txn *Transaction) error {
+	if app.receipts == nil || txn.Status != "success" {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO receipt_emails (id, transaction_id, account_id, next_attempt_at)
+		SELECT $1, $2, id, NOW() FROM accounts
+		WHERE id = $3 AND session_id IS NOT DISTINCT FROM $4 AND email IS NOT NULL AND email_bounced_at IS NULL
+		ON CONFLICT (transaction_id, account_id) DO NOTHING
+	`, uuid.New().String(), txn.ID, txn.FromAccount, sessionArg(txn.SessionID))
+	if err != nil {
+		return fmt.Errorf("failed to queue receipt: %w", err)
+	}
+	return nil
+}
+
+// wakeReceipts has the mailer look for due receipts now rather than at its
+// next tick. Call it once the transaction that queued them has committed.
+func (app *App) wakeReceipts() {
+	if app.receipts == nil {
+		return
+	}
+	select {
+	case app.receipts.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (m *ReceiptMailer) run() {
+	defer close(m.done)
+	ticker := time.NewTicker(receiptPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		case <-m.wake:
+		}
+		if m.app.db == nil {
+			continue
+		}
+		m.drain()
+	}
+}
+
+// drain sends due receipts, claiming again while full claims come back.
+func (m *ReceiptMailer) drain() {
+	for {
+		jobs, err := m.claim()
+		if err != nil {
+			m.app.log("warn", "Failed to claim receipt emails", map[string]interface{}{"error": err.Error()})
+			return
+		}
+		var wg sync.WaitGroup
+		for _, job := range jobs {
+			wg.Add(1)
+			go func(job receiptJob) {
+				defer wg.Done()
+				m.attempt(job)
+			}(job)
+		}
+		wg.Wait()
+		if len(jobs) < receiptClaimBatch {
+			return
+		}
+	}
+}
+
+// claim leases up to receiptClaimBatch due receipts to this instance,
+// together with the account's current address and the transaction.
+func (m *ReceiptMailer) claim() ([]receiptJob, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rows, err := m.app.jobPool().QueryContext(ctx, `
+		UPDATE receipt_emails r SET next_attempt_at = NOW() + make_interval(secs => $1)
+		FROM accounts a, transactions t
+		WHERE a.id = r.account_id AND t.id = r.transaction_id AND r.id IN (
+			SELECT id FROM receipt_emails
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING r.id, r.attempts, r.account_id, COALESCE(a.email, ''), a.email_bounced_at IS NOT NULL, a.name,
+			t.id, t.to_account, t.amount, COALESCE(t.description, ''), t.created_at
+	`, receiptLease.Seconds(), receiptClaimBatch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var jobs []receiptJob
+	for rows.Next() {
+		var j receiptJob
+		var amount float64
+		if err := rows.Scan(&j.id, &j.attempts, &j.account, &j.email, &j.bounced, &j.data.AccountName,
+			&j.data.TransactionID, &j.data.Payee, &amount, &j.data.Description, &j.data.SettledAt); err != nil {
+			return nil, err
+		}
+		j.data.Account = j.account
+		minor := int64(math.Round(amount * float64(minorUnitScale(m.app.config.Currency))))
+		j.data.Amount = newMoney(minor, m.app.config.Currency, m.app.config.StatsLocale).Formatted
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// attempt sends one receipt and records the outcome. Receipts for accounts
+// whose address was removed, or has bounced, since they were queued are
+// skipped.
+func (m *ReceiptMailer) attempt(job receiptJob) {
+	if job.email == "" || job.bounced {
+		m.record(job, "skipped", nil)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), receiptSendTimeout)
+	defer cancel()
+	if m.app.vault != nil {
+		// Receipts name accounts the way their owner knows them.
+		for _, id := range []*string{&job.data.Account, &job.data.Payee} {
+			if isToken(*id) {
+				if plain, err := m.app.vault.Detokenize(ctx, *id); err == nil && plain != "" {
+					*id = plain
+				}
+			}
+		}
+	}
+	msg, err := m.render(job)
+	if err == nil {
+		err = m.send(ctx, job.email, msg)
+	}
+	switch {
+	case err == nil:
+		m.record(job, "sent", nil)
+	case errors.Is(err, errRecipientRejected):
+		m.record(job, "bounced", err)
+	default:
+		m.record(job, "", err)
+	}
+}
+
+// render builds the receipt message, headers included.
+func (m *ReceiptMailer) render(job receiptJob) ([]byte, error) {
+	var subject, body bytes.Buffer
+	if err := m.template.ExecuteTemplate(&subject, "subject", job.data); err != nil {
+		return nil, err
+	}
+	if err := m.template.ExecuteTemplate(&body, "body", job.data); err != nil {
+		return nil, err
+	}
+	to := mail.Address{Name: job.data.AccountName, Address: job.email}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", m.from.String())
+	fmt.Fprintf(&msg, "To: %s\r\n", to.String())
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", strings.Join(strings.Fields(subject.String()), " ")))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().UTC().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: <%s@payflow>\r\n", job.id)
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: 8bit\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(strings.ReplaceAll(body.String(), "\r\n", "\n"), "\n", "\r\n"))
+	return msg.Bytes(), nil
+}
+
+// send delivers msg to one recipient through SMTP_ADDR, upgrading to TLS
+// when the server offers STARTTLS and authenticating when SMTP_USERNAME is
+// set. A 5xx answer to RCPT TO is errRecipientRejected.
+func (m *ReceiptMailer) send(ctx context.Context, to string, msg []byte) error {
+	cfg := m.app.config
+	host, _, err := net.SplitHostPort(cfg.SMTPAddr)
+	if err != nil {
+		return err
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", cfg.SMTPAddr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if cfg.SMTPUsername != "" {
+		if err := c.Auth(smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(m.from.Address); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		var reply *textproto.Error
+		if errors.As(err, &reply) && reply.Code >= 500 {
+			return fmt.Errorf("%w: %v", errRecipientRejected, err)
+		}
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// record stores the outcome of an attempt. An empty status means it failed
+// and is retried, or marked failed once RECEIPT_MAX_ATTEMPTS is reached. A
+// bounce also stamps the account, so no more receipts are queued for the
+// address.
+func (m *ReceiptMailer) record(job receiptJob, status string, sendErr error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	attempts := job.attempts + 1
+	if status == "" && attempts >= m.app.config.ReceiptMaxAttempts {
+		status = "failed"
+	}
+	lastErr := ""
+	if sendErr != nil {
+		lastErr = sendErr.Error()
+	}
+
+	var err error
+	switch status {
+	case "sent", "skipped":
+		receiptEmailsTotal.WithLabelValues(status).Inc()
+		if status == "skipped" {
+			attempts = job.attempts
+		}
+		_, err = m.app.jobPool().ExecContext(ctx, `
+			UPDATE receipt_emails SET status = $2, attempts = $3, last_error = '', next_attempt_at = NULL,
+				sent_at = CASE WHEN $2 = 'sent' THEN NOW() END
+			WHERE id = $1
+		`, job.id, status, attempts)
+	case "bounced", "failed":
+		receiptEmailsTotal.WithLabelValues(status).Inc()
+		_, err = m.app.jobPool().ExecContext(ctx, `
+			UPDATE receipt_emails SET status = $2, attempts = $3, last_error = $4, next_attempt_at = NULL WHERE id = $1
+		`, job.id, status, attempts, lastErr)
+		event, message := EventReceiptFailed, "Receipt email failed for good"
+		if status == "bounced" {
+			event, message = EventReceiptBounced, "Receipt email bounced; no more receipts go to the address until it changes"
+			if err == nil {
+				_, err = m.app.jobPool().ExecContext(ctx, `
+					UPDATE accounts SET email_bounced_at = NOW() WHERE id = $1 AND email = $2
+				`, job.account, job.email)
+			}
+		}
+		m.app.event("warn", event, job.data.TransactionID, message, map[string]interface{}{
+			"receipt_id": job.id,
+			"account_id": job.account,
+			"attempts":   attempts,
+			"error":      lastErr,
+		})
+	default:
+		receiptEmailsTotal.WithLabelValues("retrying").Inc()
+		_, err = m.app.jobPool().ExecContext(ctx, `
+			UPDATE receipt_emails SET attempts = $2, last_error = $3, next_attempt_at = NOW() + make_interval(secs => $4)
+			WHERE id = $1
+		`, job.id, attempts, lastErr, retryBackoff(m.app.config, attempts).Seconds())
+		m.app.debug(ctx, "Receipt email will be retried", map[string]interface{}{
+			"receipt_id": job.id,
+			"attempts":   attempts,
+			"error":      lastErr,
+		})
+	}
+	if err != nil {
+		// The lease runs out and the receipt is attempted again.
+		m.app.log("error", "Failed to record receipt email", map[string]interface{}{"receipt_id": job.id, "error": err.Error()})
+	}
+}
+
+// listAccountReceiptsHandler returns the receipts most recently queued for
+// an account, newest first.
+func (app *App) listAccountReceiptsHandler(c *gin.Context) {
+	limit, err := pageParam(c, "limit", 50, 500)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	fields, err := apihttp.FieldsParam(c, ReceiptEmail{})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	id, ok := app.resolveParam(c, "id")
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	var exists bool
+	err = app.readPool().QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM accounts WHERE id = $1 AND session_id IS NOT DISTINCT FROM $2)
+	`, id, sessionArg(sessionID(c))).Scan(&exists)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return
+	}
+
+	rows, err := app.readPool().QueryContext(ctx, `
+		SELECT id, transaction_id, status, attempts, next_attempt_at, last_error, created_at, sent_at
+		FROM receipt_emails WHERE account_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`, id, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	receipts := []ReceiptEmail{}
+	for rows.Next() {
+		var r ReceiptEmail
+		var next, sent sql.NullTime
+		if err := rows.Scan(&r.ID, &r.TransactionID, &r.Status, &r.Attempts, &next, &r.LastError, &r.CreatedAt, &sent); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if next.Valid {
+			r.NextAttemptAt = &next.Time
+		}
+		if sent.Valid {
+			r.SentAt = &sent.Time
+		}
+		receipts = append(receipts, r)
+	}
+	c.JSON(http.StatusOK, gin.H{"receipts": fields.Apply(receipts)})
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/infrasage/payflow/internal/dbtest"
+)
+
+// fakeSMTP is an SMTP server that refuses recipients at gone.example with a
+// 550 and at busy.example with a 451, and keeps the messages it accepts.
+type fakeSMTP struct {
+	addr string
+
+	mu       sync.Mutex
+	messages []string
+}
+
+func startFakeSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &fakeSMTP{addr: ln.Addr().String()}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 fake ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.Fields(line + " x")[0])
+		switch {
+		case verb == "EHLO" || verb == "HELO":
+			tp.PrintfLine("250 fake")
+		case verb == "MAIL":
+			tp.PrintfLine("250 OK")
+		case verb == "RCPT" && strings.Contains(line, "@gone.example"):
+			tp.PrintfLine("550 5.1.1 No such user")
+		case verb == "RCPT" && strings.Contains(line, "@busy.example"):
+			tp.PrintfLine("451 4.3.0 Try again later")
+		case verb == "RCPT":
+			tp.PrintfLine("250 OK")
+		case verb == "DATA":
+			tp.PrintfLine("354 Go ahead")
+			body, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, string(body))
+			s.mu.Unlock()
+			tp.PrintfLine("250 Queued")
+		case verb == "QUIT":
+			tp.PrintfLine("221 Bye")
+			return
+		default:
+			tp.PrintfLine("502 Not implemented")
+		}
+	}
+}
+
+func newTestMailer(t *testing.T, smtpAddr string, handle dbtest.Handler) (*App, *dbtest.DB) {
+	t.Helper()
+	app := newTestApp(t, func(c *Config) {
+		c.ReceiptEmails = true
+		c.SMTPAddr = smtpAddr
+		c.ReceiptMaxAttempts = 3
+	})
+	fake := dbtest.New(handle)
+	app.db = fake.Open(t)
+	if err := app.initReceipts(); err != nil {
+		t.Fatal(err)
+	}
+	return app, fake
+}
+
+func TestReceiptSendOutcomes(t *testing.T) {
+	server := startFakeSMTP(t)
+	app, fake := newTestMailer(t, server.addr, func(q dbtest.Query) (*dbtest.Rows, error) {
+		if q.HasPrefix("UPDATE receipt_emails") || q.HasPrefix("UPDATE accounts SET email_bounced_at") {
+			return dbtest.Affected(1), nil
+		}
+		return nil, dbtest.Unexpected(q)
+	})
+	settled := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	job := func(email string, attempts int) receiptJob {
+		return receiptJob{id: "r-" + email, attempts: attempts, account: "ACC-1", email: email, data: receiptData{
+			Account: "ACC-1", AccountName: "Ada Lovelace", TransactionID: "txn-1", Payee: "Coffee Shop",
+			Amount: "$42.50", Description: "Latte", SettledAt: settled,
+		}}
+	}
+
+	tests := []struct {
+		name    string
+		job     receiptJob
+		status  string
+		bounced bool
+	}{
+		{"sent", job("ada@example.com", 0), "sent", false},
+		{"hard bounce", job("ada@gone.example", 0), "bounced", true},
+		{"temporary failure", job("ada@busy.example", 0), "", false},
+		{"temporary failure at the last attempt", job("ada@busy.example", 2), "failed", false},
+		{"address removed since queued", job("", 0), "skipped", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake.Reset()
+			app.receipts.attempt(tt.job)
+			queries := fake.Queries()
+			if len(queries) == 0 {
+				t.Fatal("outcome not recorded")
+			}
+			update := queries[0]
+			switch tt.status {
+			case "":
+				if !update.Contains("next_attempt_at = NOW() + make_interval") {
+					t.Errorf("recorded %q, want a retry", update.SQL)
+				}
+			default:
+				if update.String(1) != tt.status {
+					t.Errorf("status %q, want %q", update.String(1), tt.status)
+				}
+			}
+			var stamped bool
+			for _, q := range queries {
+				stamped = stamped || q.HasPrefix("UPDATE accounts SET email_bounced_at")
+			}
+			if stamped != tt.bounced {
+				t.Errorf("account bounce stamped %v, want %v", stamped, tt.bounced)
+			}
+		})
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.messages) != 1 {
+		t.Fatalf("%d messages accepted, want 1", len(server.messages))
+	}
+	msg := server.messages[0]
+	for _, want := range []string{
+		"To: \"Ada Lovelace\" <ada@example.com>",
+		"Subject: Receipt for your payment of $42.50",
+		"Paid to:      Coffee Shop",
+		"Settled:      1 Mar 2024 12:30 UTC",
+		"Reference:    txn-1",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message lacks %q:\n%s", want, msg)
+		}
+	}
+}
+
+func TestReceiptTemplate(t *testing.T) {
+	dir := t.TempDir()
+	custom := filepath.Join(dir, "receipt.tmpl")
+	os.WriteFile(custom, []byte(`{{define "subject"}}Paid {{.Amount}}{{end}}{{define "body"}}To {{.Payee}}{{end}}`), 0o644)
+	noBody := filepath.Join(dir, "no-body.tmpl")
+	os.WriteFile(noBody, []byte(`{{define "subject"}}Paid{{end}}`), 0o644)
+
+	if _, err := loadReceiptTemplate(""); err != nil {
+		t.Errorf("built-in template: %v", err)
+	}
+	if _, err := loadReceiptTemplate(noBody); err == nil || !strings.Contains(err.Error(), `"body"`) {
+		t.Errorf("template without a body: %v", err)
+	}
+	if _, err := loadReceiptTemplate(filepath.Join(dir, "missing.tmpl")); err == nil {
+		t.Error("missing template file loaded")
+	}
+
+	app := newTestApp(t, func(c *Config) {
+		c.ReceiptEmails, c.SMTPAddr, c.ReceiptTemplateFile = true, "mail:25", custom
+	})
+	if err := app.initReceipts(); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := app.receipts.render(receiptJob{id: "r-1", email: "ada@example.com", data: receiptData{Amount: "€5.00", Payee: "Bakery"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, body, _ := strings.Cut(string(msg), "\r\n\r\n")
+	if !strings.Contains(head, "Subject: =?utf-8?q?Paid_=E2=82=AC5.00?=") || body != "To Bakery" {
+		t.Errorf("rendered %q", msg)
+	}
+	r := textproto.NewReader(bufio.NewReader(strings.NewReader(string(msg))))
+	if h, err := r.ReadMIMEHeader(); err != nil || h.Get("From") != "\"PayFlow\" <receipts@payflow.local>" {
+		t.Errorf("headers %v, err %v", h, err)
+	}
+}
+
+func TestQueueReceipt(t *testing.T) {
+	app, fake := newTestMailer(t, "mail:25", func(q dbtest.Query) (*dbtest.Rows, error) {
+		if q.HasPrefix("INSERT INTO receipt_emails") {
+			return dbtest.Affected(1), nil
+		}
+		return nil, dbtest.Unexpected(q)
+	})
+	ctx := context.Background()
+	queue := func(txn Transaction) []dbtest.Query {
+		t.Helper()
+		fake.Reset()
+		tx, err := app.db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback()
+		if err := app.queueReceipt(ctx, tx, &txn); err != nil {
+			t.Fatal(err)
+		}
+		return fake.Queries()
+	}
+
+	queries := queue(Transaction{ID: "txn-1", FromAccount: "ACC-1", Status: "success", SessionID: "s1"})
+	if len(queries) != 1 || queries[0].String(2) != "ACC-1" || queries[0].String(3) != "s1" {
+		t.Errorf("settled transaction queued %+v, want one receipt for ACC-1 in s1", queries)
+	}
+	if queries := queue(Transaction{ID: "txn-2", FromAccount: "ACC-1", Status: "held"}); len(queries) != 0 {
+		t.Errorf("held transaction queued %+v", queries)
+	}
+
+	app.receipts = nil
+	if queries := queue(Transaction{ID: "txn-3", FromAccount: "ACC-1", Status: "success"}); len(queries) != 0 {
+		t.Errorf("with RECEIPT_EMAILS off queued %+v", queries)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// settledStatuses are the states of a payment that went through, whether or
+// not it has since been refunded, as a SQL list.
+const settledStatuses = "('success', 'partially_refunded', 'refunded')"
+
+// refundTransactionHandler serves POST /api/transactions/:id/refund. The
+// refund is a new transaction moving money back from the payee to the payer
+// and referencing the original through refund_of. Refunds may be partial and
+// repeated until they add up to the original amount; the original's status
+// becomes partially_refunded or refunded accordingly.
+func (app *App) refundTransactionHandler(c *gin.Context) {
+	var req struct {
+		Amount float64 `json:"amount" binding:"omitempty,gt=0"`
+		Reason string  `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	session := sessionID(c)
+	tx, err := app.db.BeginTx(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Take the ledger lock before the row lock, in the same order as
+	// erasure, so the two can't deadlock.
+	if session == "" {
+		if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, ledgerLockID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+
+	var original Transaction
+	var refundOf string
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, from_account, to_account, amount, status, COALESCE(refund_of, '')
+		FROM transactions WHERE id = $1 AND session_id IS NOT DISTINCT FROM $2
+		FOR UPDATE
+	`, c.Param("id"), sessionArg(session)).Scan(&original.ID, &original.FromAccount, &original.ToAccount, &original.Amount, &original.Status, &refundOf)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if refundOf != "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Refunds cannot be refunded"})
+		return
+	}
+	if original.Status != "success" && original.Status != "partially_refunded" {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Transaction is %s and cannot be refunded", original.Status)})
+		return
+	}
+
+	var refunded float64
+	if err := tx.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(amount), 0) FROM transactions WHERE refund_of = $1 AND status = 'success'
+	`, original.ID).Scan(&refunded); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	// Work in cents so repeated partial refunds can't drift past the total.
+	remainingCents := math.Round(original.Amount*100) - math.Round(refunded*100)
+	amountCents := remainingCents
+	if req.Amount > 0 {
+		amountCents = math.Round(req.Amount * 100)
+	}
+	if amountCents <= 0 || amountCents > remainingCents {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":     "Refund exceeds the amount remaining on the transaction",
+			"code":      "REFUND_EXCEEDS_REMAINING",
+			"remaining": remainingCents / 100,
+		})
+		return
+	}
+
+	refund := Transaction{
+		ID:          uuid.New().String(),
+		FromAccount: original.ToAccount,
+		ToAccount:   original.FromAccount,
+		Amount:      amountCents / 100,
+		Description: "Refund of " + original.ID,
+		Status:      "success",
+		CreatedAt:   time.Now().UTC().Truncate(time.Microsecond),
+		StatusToken: newStatusToken(),
+		SessionID:   session,
+		RefundOf:    original.ID,
+	}
+	if req.Reason != "" {
+		refund.Description += ": " + req.Reason
+	}
+	if err := app.writeTransaction(ctx, tx, &refund); err != nil {
+		app.eventCtx(ctx, "error", EventTransactionWriteFailed, refund.ID, "Failed to save refund", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if refund.Status == "failed" {
+		// The payee no longer holds enough to return. The declined refund is
+		// kept for the record, but the original is left as it was.
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		app.invalidateReadCache(ctx)
+		app.feed.PublishCreated(refund)
+		transactionsTotal.WithLabelValues(refund.Status).Inc()
+		app.eventCtx(ctx, "error", EventTransactionDeclined, refund.ID, "Refund failed: insufficient funds", map[string]interface{}{
+			"refund_of":    original.ID,
+			"from_account": refund.FromAccount,
+			"amount":       refund.Amount,
+			"error_code":   "INSUFFICIENT_FUNDS",
+		})
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":       "Insufficient funds",
+			"code":        "INSUFFICIENT_FUNDS",
+			"transaction": refund,
+		})
+		return
+	}
+
+	status := "partially_refunded"
+	if amountCents == remainingCents {
+		status = "refunded"
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE transactions SET status = $2 WHERE id = $1`, original.ID, status); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := app.resealStatus(ctx, tx, original.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	refundedTotal := (math.Round(refunded*100) + amountCents) / 100
+	actor := requestActor(c)
+	details := map[string]interface{}{
+		"refund_id":      refund.ID,
+		"amount":         refund.Amount,
+		"refunded_total": refundedTotal,
+		"reason":         req.Reason,
+	}
+	if err := recordAudit(ctx, tx, original.ID, status, actor, details); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	app.invalidateReadCache(ctx)
+	app.feed.PublishCreated(refund)
+	app.feed.PublishStatus(session, original.ID, status)
+
+	transactionsTotal.WithLabelValues(refund.Status).Inc()
+	app.eventCtx(ctx, "info", EventTransactionRefunded, original.ID, "Transaction refunded", map[string]interface{}{
+		"refund_id":      refund.ID,
+		"amount":         refund.Amount,
+		"refunded_total": refundedTotal,
+		"status":         status,
+		"actor":          actor,
+	})
+	c.JSON(http.StatusCreated, gin.H{
+		"refund":               refund,
+		"original_id":          original.ID,
+		"original_status":      status,
+		"refunded_total":       refundedTotal,
+		"remaining_refundable": (remainingCents - amountCents) / 100,
+	})
+}
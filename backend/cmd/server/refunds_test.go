@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestRefunds(t *testing.T) {
+	type step struct {
+		amount   float64
+		want     int
+		code     string
+		original string
+	}
+	tests := []struct {
+		name     string
+		payee    float64
+		steps    []step
+		balances [2]float64
+	}{
+		{"full refund", 100, []step{{0, http.StatusCreated, "", "refunded"}}, [2]float64{100, 60}},
+		{"partial then the rest", 100, []step{
+			{15.5, http.StatusCreated, "", "partially_refunded"},
+			{0, http.StatusCreated, "", "refunded"},
+		}, [2]float64{100, 60}},
+		{"partial refunds adding up", 100, []step{
+			{10.1, http.StatusCreated, "", "partially_refunded"},
+			{29.9, http.StatusCreated, "", "refunded"},
+		}, [2]float64{100, 60}},
+		{"exceeds the amount", 100, []step{{40.01, http.StatusUnprocessableEntity, "REFUND_EXCEEDS_REMAINING", "success"}}, [2]float64{60, 100}},
+		{"exceeds the remainder", 100, []step{
+			{30, http.StatusCreated, "", "partially_refunded"},
+			{10.01, http.StatusUnprocessableEntity, "REFUND_EXCEEDS_REMAINING", "partially_refunded"},
+		}, [2]float64{90, 70}},
+		{"nothing left", 100, []step{
+			{0, http.StatusCreated, "", "refunded"},
+			{0, http.StatusConflict, "", "refunded"},
+		}, [2]float64{100, 60}},
+		{"payee can't cover", 10, []step{{0, http.StatusUnprocessableEntity, "INSUFFICIENT_FUNDS", "success"}}, [2]float64{60, 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newFakeLedger()
+			l.accounts["ACC-1"] = fakeAccount{Balance: 60}
+			l.accounts["MER-1"] = fakeAccount{Balance: tt.payee}
+			l.txns["txn-1"] = Transaction{ID: "txn-1", FromAccount: "ACC-1", ToAccount: "MER-1", Amount: 40, Status: "success"}
+			app := newTestApp(t, nil)
+			app.db = l.open(t)
+			r := app.newRouter()
+
+			for i, s := range tt.steps {
+				body := map[string]interface{}{}
+				if s.amount > 0 {
+					body["amount"] = s.amount
+				}
+				w := serve(r, http.MethodPost, "/api/transactions/txn-1/refund", body, nil)
+				var resp struct {
+					Code   string `json:"code"`
+					Refund struct {
+						RefundOf string `json:"refund_of"`
+					} `json:"refund"`
+				}
+				json.Unmarshal(w.Body.Bytes(), &resp)
+				if w.Code != s.want || resp.Code != s.code {
+					t.Fatalf("step %d: got %d %s, want %d %q", i, w.Code, w.Body, s.want, s.code)
+				}
+				if w.Code == http.StatusCreated && resp.Refund.RefundOf != "txn-1" {
+					t.Errorf("step %d: refund not linked to the original: %s", i, w.Body)
+				}
+				if got := l.status("txn-1"); got != s.original {
+					t.Errorf("step %d: original status %q, want %q", i, got, s.original)
+				}
+			}
+			if got := [2]float64{l.balance("ACC-1"), l.balance("MER-1")}; got != tt.balances {
+				t.Errorf("balances %v, want %v", got, tt.balances)
+			}
+		})
+	}
+}
+
+func TestRefundOfRefund(t *testing.T) {
+	l := newFakeLedger()
+	l.txns["txn-1"] = Transaction{ID: "txn-1", FromAccount: "ACC-1", ToAccount: "MER-1", Amount: 40, Status: "refunded"}
+	l.txns["txn-2"] = Transaction{ID: "txn-2", FromAccount: "MER-1", ToAccount: "ACC-1", Amount: 40, Status: "success", RefundOf: "txn-1"}
+	app := newTestApp(t, nil)
+	app.db = l.open(t)
+	if w := serve(app.newRouter(), http.MethodPost, "/api/transactions/txn-2/refund", map[string]interface{}{}, nil); w.Code != http.StatusConflict {
+		t.Errorf("got %d %s, want 409", w.Code, w.Body)
+	}
+}
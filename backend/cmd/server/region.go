@@ -0,0 +1,34 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/infrasage/payflow/internal/store"
+)
+
+// regionMiddleware tells clients and load balancers which region served the
+// request.
+func (app *App) regionMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("X-PayFlow-Region", app.config.Region)
+		c.Next()
+	}
+}
+
+// applyReplicationLag simulates asynchronous cross-region replication for
+// reads: with INJECT_REPLICATION_LAG_MS set, transactions written in another
+// region only become visible once they are older than the lag. Rows that
+// predate region tagging count as local.
+func (app *App) applyReplicationLag(c *gin.Context, f *store.TransactionFilter) {
+	cfg := app.cfg(c)
+	if cfg.InjectReplicationLagMs <= 0 {
+		return
+	}
+	f.Region = cfg.Region
+	f.LagCutoff = time.Now().UTC().Add(-time.Duration(cfg.InjectReplicationLagMs) * time.Millisecond)
+	app.debug(c.Request.Context(), "Simulating replication lag", map[string]interface{}{
+		"lag_ms": cfg.InjectReplicationLagMs,
+		"region": cfg.Region,
+	})
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var registryRegistered = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "payflow_registry_registered",
+		Help: "1 while this instance is listed in the service registry",
+	},
+)
+
+// ServiceInstance is one entry in the Redis service registry.
+type ServiceInstance struct {
+	Instance    string    `json:"instance"`
+	Address     string    `json:"address"`
+	Region      string    `json:"region"`
+	Version     string    `json:"version"`
+	StartedAt   time.Time `json:"started_at"`
+	RefreshedAt time.Time `json:"refreshed_at"`
+}
+
+// ServiceRegistry lists this instance under payflow:registry:<service> while
+// it is healthy. Entries carry a TTL that each refresh extends, so an
+// instance that dies without deregistering drops out on its own; one that is
+// alive but not ready removes its entry straight away.
+type ServiceRegistry struct {
+	app      *App
+	prefix   string
+	self     ServiceInstance
+	ttl      time.Duration
+	interval time.Duration
+
+	// op serializes refreshes with Close, so a refresh already under way
+	// can't re-register after shutdown deregistered.
+	op     sync.Mutex
+	closed bool
+
+	mu         sync.Mutex
+	registered bool
+}
+
+func (app *App) startRegistry() {
+	if !app.config.RegistryEnabled || app.redisClient == nil {
+		return
+	}
+	instance, _ := os.Hostname()
+	addr := app.config.RegistryAdvertiseAddr
+	if addr == "" {
+		addr = net.JoinHostPort(instance, app.config.Port)
+	}
+	r := &ServiceRegistry{
+		app:    app,
+		prefix: "payflow:registry:" + app.config.RegistryService + ":",
+		self: ServiceInstance{
+			Instance:  instance,
+			Address:   addr,
+			Region:    app.config.Region,
+			Version:   appVersion,
+			StartedAt: time.Now().UTC(),
+		},
+		ttl:      time.Duration(app.config.RegistryTTLSec) * time.Second,
+		interval: time.Duration(app.config.RegistryRefreshSec) * time.Second,
+	}
+	app.registry = r
+
+	go func() {
+		for {
+			r.tick()
+			time.Sleep(r.interval)
+		}
+	}()
+}
+
+// healthy mirrors /ready: the database answers and, in a failover pair,
+// this is the active member.
+func (r *ServiceRegistry) healthy() bool {
+	if r.app.readinessError(context.Background()) != nil {
+		return false
+	}
+	return r.app.failover == nil || r.app.failover.Status().Active
+}
+
+func (r *ServiceRegistry) tick() {
+	ctx, cancel := context.WithTimeout(context.Background(), r.interval)
+	defer cancel()
+	r.op.Lock()
+	defer r.op.Unlock()
+	if r.closed {
+		return
+	}
+
+	if !r.healthy() {
+		r.deregister(ctx, "instance is not ready")
+		return
+	}
+	entry := r.self
+	entry.RefreshedAt = time.Now().UTC()
+	raw, _ := json.Marshal(entry)
+	if err := r.app.redisClient.Set(ctx, r.prefix+r.self.Instance, raw, r.ttl).Err(); err != nil {
+		r.app.log("warn", "Failed to refresh service registration", map[string]interface{}{"error": err.Error()})
+		return
+	}
+	r.setRegistered(true, "")
+}
+
+// deregister removes this instance's entry when it stops being ready.
+func (r *ServiceRegistry) deregister(ctx context.Context, reason string) {
+	if err := r.app.redisClient.Del(ctx, r.prefix+r.self.Instance).Err(); err != nil {
+		r.app.log("warn", "Failed to remove service registration", map[string]interface{}{"error": err.Error()})
+		return
+	}
+	r.setRegistered(false, reason)
+}
+
+// Close deregisters for good; later refreshes do nothing.
+func (r *ServiceRegistry) Close(ctx context.Context) {
+	r.op.Lock()
+	defer r.op.Unlock()
+	r.closed = true
+	r.deregister(ctx, "shutting down")
+}
+
+func (r *ServiceRegistry) setRegistered(registered bool, reason string) {
+	r.mu.Lock()
+	changed := r.registered != registered
+	r.registered = registered
+	r.mu.Unlock()
+	if registered {
+		registryRegistered.Set(1)
+	} else {
+		registryRegistered.Set(0)
+	}
+	if !changed {
+		return
+	}
+	attrs := map[string]interface{}{"service": r.app.config.RegistryService, "address": r.self.Address}
+	if registered {
+		r.app.event("info", EventRegistryRegistered, r.self.Instance, "Instance registered", attrs)
+		return
+	}
+	attrs["reason"] = reason
+	r.app.event("warn", EventRegistryDeregistered, r.self.Instance, "Instance deregistered", attrs)
+}
+
+// Instances lists the live entries for the service.
+func (r *ServiceRegistry) Instances(ctx context.Context) ([]ServiceInstance, error) {
+	rc := r.app.redisClient
+	var keys []string
+	iter := rc.Scan(ctx, 0, r.prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	instances := []ServiceInstance{}
+	if len(keys) == 0 {
+		return instances, nil
+	}
+	values, err := rc.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			continue // expired between SCAN and MGET
+		}
+		var inst ServiceInstance
+		if json.Unmarshal([]byte(s), &inst) == nil {
+			instances = append(instances, inst)
+		}
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].Instance < instances[j].Instance })
+	return instances, nil
+}
+
+func (app *App) listRegistryHandler(c *gin.Context) {
+	if app.registry == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Service registry is not enabled"})
+		return
+	}
+	instances, err := app.registry.Instances(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Registry unavailable"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"service": app.config.RegistryService, "instances": instances})
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+	"github.com/infrasage/payflow/internal/config"
+)
+
+// ConfigChange is one setting a reload changed.
+type ConfigChange struct {
+	Setting string      `json:"setting"`
+	From    interface{} `json:"from"`
+	To      interface{} `json:"to"`
+	Source  string      `json:"source"`
+}
+
+// watchReloadSignal reloads the configuration every time the process gets
+// SIGHUP, e.g. from `kill -HUP` after CONFIG_FILE was edited.
+func (app *App) watchReloadSignal() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			app.reloadConfig(refuseGuardrails)
+		}
+	}()
+}
+
+// reloadConfig rereads CONFIG_FILE and the environment and makes the
+// reloadable settings that changed since they were last read live, the way
+// a chaos change through /api/admin/chaos is. Settings that did not change
+// keep their live value, so chaos changed at runtime survives a reload that
+// doesn't touch it. Settings that need a restart are only reported, for as
+// long as they differ from what the server started with.
+// A configuration that fails validation is rejected as a whole and the
+// current settings stay, and so is one whose rate limit or chaos
+// guardrail violations allow refuses.
+func (app *App) reloadConfig(allow func([]GuardrailViolation) bool) ([]ConfigChange, error) {
+	loaded, err := loadConfig()
+	if err != nil {
+		return nil, app.rejectReload(err)
+	}
+
+	app.chaosMu.Lock()
+	defer app.chaosMu.Unlock()
+	previous := app.loadedConfig
+	if previous == nil {
+		previous = app.config
+	}
+	live := app.liveConfig()
+	next, _ := withChaos(live, nil)
+	changes := []ConfigChange{}
+	restart := []string{}
+	for _, f := range config.Schema {
+		to := f.Value(loaded)
+		if !f.Reloadable && !f.Chaos {
+			if to != f.Value(app.config) {
+				restart = append(restart, f.Env)
+			}
+			continue
+		}
+		if to == f.Value(previous) {
+			continue
+		}
+		from := f.Value(live)
+		if err := f.Set(next, fmt.Sprint(to)); err != nil {
+			return nil, app.rejectReload(&ConfigError{Problems: []string{fmt.Sprintf("%s: %v", f.Env, err)}})
+		}
+		next.SetSource(f.Env, loaded.Source(f.Env))
+		if from == to {
+			continue
+		}
+		if f.Secret {
+			from, to = config.MaskSecret(fmt.Sprint(from)), config.MaskSecret(fmt.Sprint(to))
+		}
+		changes = append(changes, ConfigChange{Setting: f.Env, From: from, To: to, Source: loaded.Source(f.Env)})
+	}
+	// Runtime chaos changes are kept, so the result is checked again.
+	if problems := next.Problems(configChecks...); len(problems) > 0 {
+		return nil, app.rejectReload(&ConfigError{Problems: problems})
+	}
+	if violations := append(rateLimitGuardrails(live, next), chaosGuardrails(live, next)...); !allow(violations) {
+		app.event("error", EventConfigReloadRejected, "", "Configuration reload refused by guardrails, keeping the current settings", map[string]interface{}{
+			"violations": violations,
+		})
+		return nil, errGuardrailsRefused
+	}
+
+	app.loadedConfig = loaded
+	app.chaosConfig.Store(next)
+	app.syncChaosRunners(next)
+	app.logger().SetLevel(next.LogLevel)
+	app.event("info", EventConfigReloaded, "", "Configuration reloaded", map[string]interface{}{
+		"changes":          changes,
+		"restart_required": restart,
+	})
+	return changes, nil
+}
+
+// rejectReload logs why a reload was refused and returns err.
+func (app *App) rejectReload(err error) error {
+	problems := []string{err.Error()}
+	var cfgErr *ConfigError
+	if errors.As(err, &cfgErr) {
+		problems = cfgErr.Problems
+	}
+	app.event("error", EventConfigReloadRejected, "", "Configuration reload rejected, keeping the current settings", map[string]interface{}{
+		"problems": problems,
+	})
+	return err
+}
+
+// reloadConfigHandler reloads the configuration like SIGHUP does, except
+// that guardrail violations can be overridden with force=true.
+func (app *App) reloadConfigHandler(c *gin.Context) {
+	changes, err := app.reloadConfig(func(violations []GuardrailViolation) bool {
+		return app.passGuardrails(c, "config.reload", "", violations)
+	})
+	if errors.Is(err, errGuardrailsRefused) {
+		return
+	}
+	var cfgErr *ConfigError
+	if errors.As(err, &cfgErr) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Invalid configuration", "problems": cfgErr.Problems})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"changes": changes})
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// Settings that need a restart are reported on every reload until they match
+// the startup config again, not only on the reload that changed them.
+func TestReloadReportsRestartAgainstStartup(t *testing.T) {
+	app := newTestApp(t, nil)
+	var out bytes.Buffer
+	app.logs = newLogger("info", "json", &out)
+
+	reload := func() (changes []ConfigChange, restart []string) {
+		t.Helper()
+		out.Reset()
+		changes, err := app.reloadConfig(refuseGuardrails)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sc := bufio.NewScanner(&out)
+		for sc.Scan() {
+			var entry struct {
+				EventType  string `json:"event_type"`
+				Attributes struct {
+					Restart []string `json:"restart_required"`
+				} `json:"attributes"`
+			}
+			if json.Unmarshal(sc.Bytes(), &entry) == nil && entry.EventType == EventConfigReloaded {
+				return changes, entry.Attributes.Restart
+			}
+		}
+		t.Fatal("no config.reloaded event")
+		return nil, nil
+	}
+
+	t.Setenv("PORT", "9090")
+	t.Setenv("RATE_LIMIT_RPS", "50")
+	changes, restart := reload()
+	if !reflect.DeepEqual(restart, []string{"PORT"}) || len(changes) != 1 || changes[0].Setting != "RATE_LIMIT_RPS" {
+		t.Errorf("first reload: changes %+v, restart_required %v", changes, restart)
+	}
+
+	changes, restart = reload()
+	if !reflect.DeepEqual(restart, []string{"PORT"}) || len(changes) != 0 {
+		t.Errorf("second reload: changes %+v, restart_required %v; want PORT still listed", changes, restart)
+	}
+
+	t.Setenv("PORT", "8080")
+	if _, restart = reload(); len(restart) != 0 {
+		t.Errorf("PORT back to its startup value: restart_required %v", restart)
+	}
+}
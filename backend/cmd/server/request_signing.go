@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/infrasage/payflow/sdk"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxNonceLength bounds X-PayFlow-Nonce; sdk.NewNonce makes 32 characters.
+const maxNonceLength = 64
+
+var signatureReplaysTotal = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "payflow_signature_replays_rejected_total",
+		Help: "Signed requests rejected because their nonce was already used",
+	},
+)
+
+// nonceCache remembers used nonces on this instance, for when Redis can't.
+type nonceCache struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time // expiry by key
+	lastSweep time.Time
+}
+
+// claim records key until ttl from now, reporting false if it is already
+// recorded.
+func (n *nonceCache) claim(key string, ttl time.Duration, now time.Time) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.seen == nil {
+		n.seen = map[string]time.Time{}
+	}
+	if now.Sub(n.lastSweep) > idleBucketTTL {
+		n.lastSweep = now
+		for k, expiry := range n.seen {
+			if now.After(expiry) {
+				delete(n.seen, k)
+			}
+		}
+	}
+	if expiry, ok := n.seen[key]; ok && now.Before(expiry) {
+		return false
+	}
+	n.seen[key] = now.Add(ttl)
+	return true
+}
+
+// claimNonce records that keyID used nonce, reporting false if it already
+// had within ttl. With Redis this holds across replicas; while Redis is
+// unreachable each instance only knows its own nonces.
+func (app *App) claimNonce(ctx context.Context, keyID, nonce string, ttl time.Duration) bool {
+	key := "payflow:signature:nonce:" + keyID + ":" + nonce
+	if app.redisClient != nil {
+		ctx, cancel := context.WithTimeout(ctx, redisRateLimitTimeout)
+		defer cancel()
+		ok, err := app.redisClient.SetNX(ctx, key, 1, ttl).Result()
+		if err == nil {
+			return ok
+		}
+		app.logCtx(ctx, "warn", "Signature nonce check failed, using local nonces", map[string]interface{}{"error": err.Error()})
+	}
+	return app.nonces.claim(key, ttl, time.Now())
+}
+
+func newSigningSecret() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+func signatureError(c *gin.Context, status int, message string) {
+	c.Header("WWW-Authenticate", `PayFlow-HMAC-SHA256 realm="payflow"`)
+	c.JSON(status, gin.H{"error": message})
+	c.Abort()
+}
+
+// signedRequestMiddleware authenticates requests signed with an API key's
+// signing secret, as produced by sdk.SignRequest: the X-PayFlow-Key-Id,
+// X-PayFlow-Timestamp, X-PayFlow-Nonce and X-PayFlow-Signature headers, an
+// HMAC-SHA256 over method, path and query, timestamp, nonce and body hash.
+// Timestamps more than SIGNATURE_MAX_SKEW_SEC from the server clock are
+// rejected, and so is a nonce the key already used while its timestamp is
+// still accepted. Requests without a signature are left to the other
+// authentication methods.
+func (app *App) signedRequestMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		signature := c.GetHeader(sdk.HeaderSignature)
+		if signature == "" {
+			c.Next()
+			return
+		}
+		if principalFrom(c) != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Send only one of a bearer token, an API key or a request signature"})
+			c.Abort()
+			return
+		}
+		ts, err := strconv.ParseInt(c.GetHeader(sdk.HeaderTimestamp), 10, 64)
+		if err != nil {
+			signatureError(c, http.StatusUnauthorized, "Invalid or missing "+sdk.HeaderTimestamp)
+			return
+		}
+		age := time.Since(time.Unix(ts, 0))
+		skew, maxSkew := age, time.Duration(app.config.SignatureMaxSkewSec)*time.Second
+		if skew < 0 {
+			skew = -skew
+		}
+		if skew > maxSkew {
+			signatureError(c, http.StatusUnauthorized, "Request timestamp outside the allowed window")
+			return
+		}
+		nonce := c.GetHeader(sdk.HeaderNonce)
+		if nonce == "" || len(nonce) > maxNonceLength {
+			signatureError(c, http.StatusUnauthorized, "Invalid or missing "+sdk.HeaderNonce)
+			return
+		}
+		if app.db == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+			c.Abort()
+			return
+		}
+
+		key, err := app.loadAPIKey(c.Request.Context(), c.GetHeader(sdk.HeaderKeyID))
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to verify request signature"})
+			c.Abort()
+			return
+		}
+		if key.principal == nil || key.signingSecret == "" {
+			signatureError(c, http.StatusUnauthorized, "Unknown or revoked signing key")
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxBodyBytes))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		keyID := c.GetHeader(sdk.HeaderKeyID)
+		canonical := sdk.CanonicalString(c.Request.Method, c.Request.URL.EscapedPath(), c.Request.URL.RawQuery, ts, nonce, body)
+		if !hmac.Equal([]byte(signature), []byte(sdk.Signature(key.signingSecret, canonical))) {
+			app.debug(c.Request.Context(), "Request signature mismatch", map[string]interface{}{"canonical": canonical})
+			signatureError(c, http.StatusUnauthorized, "Invalid request signature")
+			return
+		}
+		// Remember the nonce until the timestamp is out of the window, after
+		// which the skew check rejects the request anyway.
+		if !app.claimNonce(c.Request.Context(), keyID, nonce, maxSkew-age+time.Second) {
+			signatureReplaysTotal.Inc()
+			app.logCtx(c.Request.Context(), "warn", "Replayed signed request rejected", map[string]interface{}{"key_id": keyID})
+			signatureError(c, http.StatusUnauthorized, "Request nonce already used")
+			return
+		}
+		c.Set(principalContextKey, key.principal)
+		c.Next()
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/infrasage/payflow/sdk"
+)
+
+// newSigningTestRouter serves POST /echo behind signedRequestMiddleware for
+// a signing key that is already cached, so no query is ever run.
+func newSigningTestRouter(t *testing.T, keyID, secret string) *gin.Engine {
+	app := newTestApp(t, nil)
+	db, err := sql.Open("postgres", "host=127.0.0.1 port=1 sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	app.db = db
+	app.apiKeys.put(keyID, cachedAPIKey{principal: &Principal{Subject: "ci", Source: "apikey"}, signingSecret: secret})
+	r := gin.New()
+	r.Use(app.signedRequestMiddleware())
+	r.POST("/echo", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	return r
+}
+
+func signedEcho(t *testing.T, keyID, secret string, now time.Time) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/echo?x=1", bytes.NewReader([]byte(`{"amount": 10}`)))
+	if err := sdk.SignRequest(req, keyID, secret, now); err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestSignedRequestReplayRejected(t *testing.T) {
+	r := newSigningTestRouter(t, "k1", "secret")
+
+	req := signedEcho(t, "k1", "secret", time.Now())
+	replay := req.Clone(req.Context())
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("first request = %d %s, want 204", w.Code, w.Body)
+	}
+
+	replay.Body = io.NopCloser(strings.NewReader(`{"amount": 10}`))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, replay)
+	if w.Code != http.StatusUnauthorized || !bytes.Contains(w.Body.Bytes(), []byte("nonce already used")) {
+		t.Fatalf("replay = %d %s, want 401 nonce already used", w.Code, w.Body)
+	}
+
+	// A fresh signature of the same request has a new nonce.
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, signedEcho(t, "k1", "secret", time.Now()))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("re-signed request = %d %s, want 204", w.Code, w.Body)
+	}
+}
+
+func TestSignedRequestRejections(t *testing.T) {
+	r := newSigningTestRouter(t, "k1", "secret")
+	tests := []struct {
+		name   string
+		mutate func(*http.Request)
+	}{
+		{"missing nonce", func(req *http.Request) { req.Header.Del(sdk.HeaderNonce) }},
+		{"long nonce", func(req *http.Request) {
+			req.Header.Set(sdk.HeaderNonce, string(bytes.Repeat([]byte("a"), maxNonceLength+1)))
+		}},
+		{"nonce not signed", func(req *http.Request) { req.Header.Set(sdk.HeaderNonce, "other") }},
+		{"stale timestamp", func(req *http.Request) {
+			req.Header.Set(sdk.HeaderTimestamp, strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10))
+		}},
+		{"wrong secret", func(req *http.Request) {
+			sdk.SignRequest(req, "k1", "other-secret", time.Now())
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := signedEcho(t, "k1", "secret", time.Now())
+			tt.mutate(req)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("status = %d %s, want 401", w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func TestNonceCacheExpires(t *testing.T) {
+	var n nonceCache
+	now := time.Now()
+	if !n.claim("a", time.Minute, now) {
+		t.Fatal("first claim refused")
+	}
+	if n.claim("a", time.Minute, now.Add(30*time.Second)) {
+		t.Fatal("repeat within ttl allowed")
+	}
+	if !n.claim("b", time.Minute, now) {
+		t.Fatal("other nonce refused")
+	}
+	if !n.claim("a", time.Minute, now.Add(2*time.Minute)) {
+		t.Fatal("claim after ttl refused")
+	}
+}
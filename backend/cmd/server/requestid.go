@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	apihttp "github.com/infrasage/payflow/internal/http"
+)
+
+// requestIDMiddleware gives every request an ID, propagated from the caller
+// when it sent one, and echoes it as X-Request-ID. Everything that logs
+// through logCtx, eventCtx or debug with the request context uses it as the
+// trace_id, so one request's log lines can be collected together.
+func (app *App) requestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := apihttp.IncomingRequestID(c.Request)
+		if id == "" {
+			id = uuid.New().String()
+		}
+		t := &logTrace{ID: id}
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), logTraceKey{}, t))
+		c.Header("X-Request-ID", id)
+		c.Next()
+	}
+}
+
+// requestID returns the ID of the request, or "" outside requestIDMiddleware.
+func requestID(c *gin.Context) string {
+	if t := logTraceFrom(c.Request.Context()); t != nil {
+		return t.ID
+	}
+	return ""
+}
+
+// traceIDFrom is the trace ID for a log line: the request ID behind ctx, or
+// a fresh short ID when there is no request.
+func traceIDFrom(ctx context.Context) string {
+	if t := logTraceFrom(ctx); t != nil {
+		return t.ID
+	}
+	return uuid.New().String()[:8]
+}
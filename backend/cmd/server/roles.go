@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// roleScopes lists the scopes each role grants, so operators with roles pass
+// the same requireScope checks as machine clients with scopes. Roles not
+// listed here (detokenize) grant only their own checks. fraud:triage lets
+// fraud analysts through the fraud routes, see authorizeAdmin.
+var roleScopes = map[string][]string{
+	"viewer":        {"transactions:read", "accounts:read"},
+	"fraud_analyst": {"transactions:read", "accounts:read", "fraud:triage"},
+	"operator":      {"transactions:read", "accounts:read", "transactions:write", "accounts:write", "webhooks:manage"},
+	"admin":         {"transactions:read", "accounts:read", "transactions:write", "accounts:write", "webhooks:manage", "admin"},
+}
+
+// rolesGrant reports whether any of roles grants scope.
+func rolesGrant(roles []string, scope string) bool {
+	for _, role := range roles {
+		if containsString(roleScopes[role], scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// demoTokenHandler issues a role-based access token for any subject, so
+// demos can show viewer, fraud analyst, operator and admin access without an
+// OIDC provider.
+// It is only routed when DEMO_TOKENS_ENABLED is set.
+func (app *App) demoTokenHandler(c *gin.Context) {
+	var req struct {
+		Subject string `json:"subject" binding:"required"`
+		Role    string `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, ok := roleScopes[req.Role]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role must be one of viewer, fraud_analyst, operator, admin"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	ttl := time.Duration(app.config.OAuthTokenTTLSec) * time.Second
+	now := time.Now()
+	claims := ServiceClaims{
+		Roles: []string{req.Role},
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    app.config.OAuthIssuer,
+			Subject:   req.Subject,
+			Audience:  jwt.ClaimStrings{app.config.OAuthIssuer},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			ID:        newStatusToken(),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(app.oauthKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign token"})
+		return
+	}
+
+	app.eventCtx(c.Request.Context(), "info", EventTokenIssued, req.Subject, "Demo access token issued", map[string]interface{}{"role": req.Role})
+	c.JSON(http.StatusOK, gin.H{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int(ttl.Seconds()),
+		"role":         req.Role,
+		"scope":        strings.Join(roleScopes[req.Role], " "),
+	})
+}
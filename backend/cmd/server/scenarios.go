@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// errScenarioRunning refuses to start a scenario while another one runs.
+var errScenarioRunning = errors.New("a chaos scenario is already running")
+
+// ChaosScenario is a timed chaos drill, such as 500ms of latency from two
+// minutes in to seven, then a 20% error rate for five minutes. Running one
+// again gives the same incident at the same times.
+type ChaosScenario struct {
+	Name        string         `json:"name" yaml:"name"`
+	Description string         `json:"description,omitempty" yaml:"description"`
+	Steps       []ScenarioStep `json:"steps" yaml:"steps"`
+}
+
+// ScenarioStep changes chaos settings, keyed like PUT /api/admin/chaos, At an
+// offset from the scenario's start and puts them back to what they were
+// when the scenario started once Duration has passed. Offsets and durations
+// are Go durations such as "90s" or "2m".
+type ScenarioStep struct {
+	At       string                 `json:"at" yaml:"at"`
+	Duration string                 `json:"duration" yaml:"duration"`
+	Set      map[string]interface{} `json:"set" yaml:"set"`
+
+	at, duration time.Duration
+	changes      map[string]string
+}
+
+// validate parses the steps' times and settings. Steps changing the same
+// setting may follow each other but not overlap, so every revert has one
+// value to go back to.
+func (s *ChaosScenario) validate(cfg *Config) error {
+	if s.Name == "" {
+		return errors.New("scenario needs a name")
+	}
+	if len(s.Steps) == 0 {
+		return fmt.Errorf("scenario %s: no steps", s.Name)
+	}
+	for i := range s.Steps {
+		step := &s.Steps[i]
+		var err error
+		if step.at, err = time.ParseDuration(step.At); err != nil || step.at < 0 {
+			return fmt.Errorf("scenario %s: step %d: at must be a duration from the start, such as 2m", s.Name, i+1)
+		}
+		if step.duration, err = time.ParseDuration(step.Duration); err != nil || step.duration <= 0 {
+			return fmt.Errorf("scenario %s: step %d: duration must be a positive duration, such as 5m", s.Name, i+1)
+		}
+		if len(step.Set) == 0 {
+			return fmt.Errorf("scenario %s: step %d: nothing to set", s.Name, i+1)
+		}
+		step.changes = chaosChanges(step.Set)
+		if _, err := withChaos(cfg, step.changes); err != nil {
+			return fmt.Errorf("scenario %s: step %d: %v", s.Name, i+1, err)
+		}
+		for j := 0; j < i; j++ {
+			other := s.Steps[j]
+			if step.at >= other.at+other.duration || other.at >= step.at+step.duration {
+				continue
+			}
+			for key := range step.changes {
+				if _, ok := other.changes[key]; ok {
+					return fmt.Errorf("scenario %s: steps %d and %d both set %s at once", s.Name, j+1, i+1, key)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// length is how long the scenario runs: until its last step ends.
+func (s *ChaosScenario) length() time.Duration {
+	var end time.Duration
+	for _, step := range s.Steps {
+		if step.at+step.duration > end {
+			end = step.at + step.duration
+		}
+	}
+	return end
+}
+
+// loadChaosScenarios reads scenarios from path, a YAML or JSON file with a
+// top-level scenarios list. There are none when path is empty.
+func loadChaosScenarios(path string, cfg *Config) ([]ChaosScenario, error) {
+	if path == "" {
+		return nil, nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		Scenarios []ChaosScenario `yaml:"scenarios"`
+	}
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	seen := map[string]bool{}
+	for i := range doc.Scenarios {
+		s := &doc.Scenarios[i]
+		if err := s.validate(cfg); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if seen[s.Name] {
+			return nil, fmt.Errorf("%s: scenario %s defined twice", path, s.Name)
+		}
+		seen[s.Name] = true
+	}
+	return doc.Scenarios, nil
+}
+
+// ScenarioRunStatus is the progress of a running scenario. Each step is
+// pending, active, done or failed when its changes could not be applied.
+type ScenarioRunStatus struct {
+	Name      string               `json:"name"`
+	StartedBy string               `json:"started_by"`
+	StartedAt time.Time            `json:"started_at"`
+	EndsAt    time.Time            `json:"ends_at"`
+	Steps     []ScenarioStepStatus `json:"steps"`
+}
+
+// ScenarioStepStatus is a step with its state.
+type ScenarioStepStatus struct {
+	ScenarioStep
+	State string `json:"state"`
+}
+
+// scenarioRun is the scenario in progress on this instance.
+type scenarioRun struct {
+	scenario  ChaosScenario
+	startedBy string
+	startedAt time.Time
+	// baseline holds the value of every setting the scenario changes as it
+	// was at the start; reverts go back to it.
+	baseline map[string]string
+	cancel   context.CancelFunc
+	done     chan struct{}
+
+	mu     sync.Mutex
+	states []string
+}
+
+func (r *scenarioRun) setState(step int, state string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.states[step] = state
+}
+
+func (r *scenarioRun) status() *ScenarioRunStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	st := &ScenarioRunStatus{
+		Name:      r.scenario.Name,
+		StartedBy: r.startedBy,
+		StartedAt: r.startedAt,
+		EndsAt:    r.startedAt.Add(r.scenario.length()),
+	}
+	for i, step := range r.scenario.Steps {
+		st.Steps = append(st.Steps, ScenarioStepStatus{ScenarioStep: step, State: r.states[i]})
+	}
+	return st
+}
+
+// startScenario runs s in the background, applying and reverting its steps
+// on schedule. Only one scenario runs at a time.
+func (app *App) startScenario(s ChaosScenario, actor string) (*scenarioRun, error) {
+	app.scenarioMu.Lock()
+	defer app.scenarioMu.Unlock()
+	if app.scenario != nil {
+		return nil, errScenarioRunning
+	}
+	live := app.liveConfig()
+	baseline := map[string]string{}
+	for _, step := range s.Steps {
+		for key := range step.changes {
+			field, _ := chaosField(key)
+			baseline[key] = fmt.Sprint(field.Value(live))
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &scenarioRun{
+		scenario:  s,
+		startedBy: actor,
+		startedAt: time.Now().UTC(),
+		baseline:  baseline,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+		states:    make([]string, len(s.Steps)),
+	}
+	for i := range run.states {
+		run.states[i] = "pending"
+	}
+	app.scenario = run
+	chaosActive.WithLabelValues("scenario").Set(1)
+	app.event("warn", EventChaosScenarioStarted, s.Name, "Chaos scenario started", map[string]interface{}{
+		"steps":   len(s.Steps),
+		"ends_at": run.startedAt.Add(s.length()),
+		"actor":   actor,
+	})
+	go app.runScenario(ctx, run)
+	return run, nil
+}
+
+// stopScenario ends the running scenario early, reverting the steps in
+// effect, and reports whether one was running.
+func (app *App) stopScenario() bool {
+	app.scenarioMu.Lock()
+	run := app.scenario
+	app.scenarioMu.Unlock()
+	if run == nil {
+		return false
+	}
+	run.cancel()
+	<-run.done
+	return true
+}
+
+func (app *App) scenarioStatus() *ScenarioRunStatus {
+	app.scenarioMu.Lock()
+	defer app.scenarioMu.Unlock()
+	if app.scenario == nil {
+		return nil
+	}
+	return app.scenario.status()
+}
+
+// runScenario works through run's timeline until it ends or is stopped.
+func (app *App) runScenario(ctx context.Context, run *scenarioRun) {
+	defer func() {
+		app.scenarioMu.Lock()
+		app.scenario = nil
+		app.scenarioMu.Unlock()
+		chaosActive.WithLabelValues("scenario").Set(0)
+		close(run.done)
+	}()
+
+	type change struct {
+		offset time.Duration
+		step   int
+		revert bool
+	}
+	var timeline []change
+	for i, step := range run.scenario.Steps {
+		timeline = append(timeline, change{step.at, i, false}, change{step.at + step.duration, i, true})
+	}
+	// At the same instant reverts go first, so a step can hand a setting
+	// straight to the next.
+	sort.SliceStable(timeline, func(i, j int) bool {
+		if timeline[i].offset != timeline[j].offset {
+			return timeline[i].offset < timeline[j].offset
+		}
+		return timeline[i].revert && !timeline[j].revert
+	})
+
+	name := run.scenario.Name
+	active := map[int]bool{}
+	for _, ch := range timeline {
+		select {
+		case <-ctx.Done():
+			for step := range active {
+				app.revertScenarioStep(run, step)
+			}
+			app.event("info", EventChaosScenarioStopped, name, "Chaos scenario stopped", map[string]interface{}{"reverted_steps": len(active)})
+			return
+		case <-time.After(time.Until(run.startedAt.Add(ch.offset))):
+		}
+		if ch.revert {
+			// A step that failed to apply has nothing to put back, and
+			// reverting it would undo changes made since the start.
+			if active[ch.step] {
+				app.revertScenarioStep(run, ch.step)
+				delete(active, ch.step)
+			}
+			continue
+		}
+		step := run.scenario.Steps[ch.step]
+		if err := app.setChaos(step.changes, nil); err != nil {
+			app.log("warn", "Failed to apply chaos scenario step", map[string]interface{}{"scenario": name, "step": ch.step + 1, "error": err.Error()})
+			run.setState(ch.step, "failed")
+			continue
+		}
+		active[ch.step] = true
+		run.setState(ch.step, "active")
+		app.event("warn", EventChaosScenarioStep, name, "Chaos scenario step applied", map[string]interface{}{
+			"step":     ch.step + 1,
+			"action":   "applied",
+			"changes":  step.changes,
+			"duration": step.Duration,
+		})
+	}
+	app.event("info", EventChaosScenarioFinished, name, "Chaos scenario finished", nil)
+}
+
+// revertScenarioStep puts the settings step changed back to the baseline.
+func (app *App) revertScenarioStep(run *scenarioRun, step int) {
+	changes := map[string]string{}
+	for key := range run.scenario.Steps[step].changes {
+		changes[key] = run.baseline[key]
+	}
+	if err := app.setChaos(changes, nil); err != nil {
+		app.log("warn", "Failed to revert chaos scenario step", map[string]interface{}{"scenario": run.scenario.Name, "step": step + 1, "error": err.Error()})
+		return
+	}
+	run.setState(step, "done")
+	app.event("info", EventChaosScenarioStep, run.scenario.Name, "Chaos scenario step reverted", map[string]interface{}{
+		"step":    step + 1,
+		"action":  "reverted",
+		"changes": changes,
+	})
+}
+
+func (app *App) listScenariosHandler(c *gin.Context) {
+	scenarios := app.scenarios
+	if scenarios == nil {
+		scenarios = []ChaosScenario{}
+	}
+	c.JSON(http.StatusOK, gin.H{"scenarios": scenarios, "running": app.scenarioStatus()})
+}
+
+// runScenarioHandler starts a scenario from CHAOS_SCENARIOS_FILE by name, or
+// one given inline.
+func (app *App) runScenarioHandler(c *gin.Context) {
+	var req struct {
+		Name     string         `json:"name"`
+		Scenario *ChaosScenario `json:"scenario"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var scenario ChaosScenario
+	switch {
+	case req.Scenario != nil:
+		scenario = *req.Scenario
+		if err := scenario.validate(app.config); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	case req.Name != "":
+		found := false
+		for _, s := range app.scenarios {
+			if s.Name == req.Name {
+				scenario, found = s, true
+				break
+			}
+		}
+		if !found {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Chaos scenario not found"})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Give a scenario name or an inline scenario"})
+		return
+	}
+	run, err := app.startScenario(scenario, adminActor(c))
+	if err == errScenarioRunning {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "running": app.scenarioStatus()})
+		return
+	}
+	c.JSON(http.StatusAccepted, run.status())
+}
+
+// stopScenarioHandler ends the running scenario and reverts what it changed.
+func (app *App) stopScenarioHandler(c *gin.Context) {
+	if !app.stopScenario() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No chaos scenario is running"})
+		return
+	}
+	c.JSON(http.StatusOK, app.chaosReport())
+}
+
+// startConfiguredScenario runs CHAOS_SCENARIO, if set, when the server starts.
+func (app *App) startConfiguredScenario() {
+	name := app.config.ChaosScenario
+	if name == "" {
+		return
+	}
+	for _, s := range app.scenarios {
+		if s.Name == name {
+			app.startScenario(s, "startup")
+			return
+		}
+	}
+	app.log("warn", "CHAOS_SCENARIO not found in CHAOS_SCENARIOS_FILE", map[string]interface{}{"scenario": name})
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// A step that fails to apply is marked failed, and its end leaves the
+// settings of the steps that did apply alone.
+func TestScenarioSkipsRevertOfFailedStep(t *testing.T) {
+	app := newTestApp(t, nil)
+	run, err := app.startScenario(ChaosScenario{
+		Name: "drill",
+		Steps: []ScenarioStep{
+			{At: "0s", Duration: "50ms", duration: 50 * time.Millisecond, changes: map[string]string{"inject_latency_ms": "slow"}},
+			{At: "0s", Duration: "300ms", duration: 300 * time.Millisecond, changes: map[string]string{"inject_latency_ms": "5"}},
+		},
+	}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if got := app.liveConfig().InjectLatencyMs; got != 5 {
+		t.Errorf("after the failed step's end: inject_latency_ms = %d, want 5", got)
+	}
+	if st := run.status(); st.Steps[0].State != "failed" || st.Steps[1].State != "active" {
+		t.Errorf("states %s, %s; want failed, active", st.Steps[0].State, st.Steps[1].State)
+	}
+
+	select {
+	case <-run.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("scenario did not finish")
+	}
+	if got := app.liveConfig().InjectLatencyMs; got != 0 {
+		t.Errorf("after the scenario: inject_latency_ms = %d, want 0", got)
+	}
+	if st := run.status(); st.Steps[0].State != "failed" || st.Steps[1].State != "done" {
+		t.Errorf("states %s, %s; want failed, done", st.Steps[0].State, st.Steps[1].State)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+//go:embed schemas/*.json
+var schemaFiles embed.FS
+
+// maxBodyBytes bounds request bodies read for schema validation.
+const maxBodyBytes = 1 << 20
+
+// SchemaRegistry holds the compiled JSON Schemas for request bodies, keyed by
+// file name without extension (e.g. "create-transaction").
+type SchemaRegistry struct {
+	raw      map[string]json.RawMessage
+	compiled map[string]*jsonschema.Schema
+}
+
+func loadSchemas() (*SchemaRegistry, error) {
+	entries, err := schemaFiles.ReadDir("schemas")
+	if err != nil {
+		return nil, err
+	}
+
+	reg := &SchemaRegistry{
+		raw:      map[string]json.RawMessage{},
+		compiled: map[string]*jsonschema.Schema{},
+	}
+	compiler := jsonschema.NewCompiler()
+	compiler.Draft = jsonschema.Draft2020
+
+	for _, e := range entries {
+		data, err := schemaFiles.ReadFile("schemas/" + e.Name())
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimSuffix(e.Name(), path.Ext(e.Name()))
+		url := "schemas/" + e.Name()
+		if err := compiler.AddResource(url, bytes.NewReader(data)); err != nil {
+			return nil, fmt.Errorf("schema %s: %w", name, err)
+		}
+		schema, err := compiler.Compile(url)
+		if err != nil {
+			return nil, fmt.Errorf("schema %s: %w", name, err)
+		}
+		reg.raw[name] = data
+		reg.compiled[name] = schema
+	}
+	return reg, nil
+}
+
+func (r *SchemaRegistry) Names() []string {
+	names := make([]string, 0, len(r.raw))
+	for name := range r.raw {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Validate checks a JSON document against the named schema and returns a
+// flat list of violations, one per failing keyword.
+func (r *SchemaRegistry) Validate(name string, body []byte) ([]string, error) {
+	schema, ok := r.compiled[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown schema %q", name)
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return []string{"body is not valid JSON: " + err.Error()}, nil
+	}
+
+	err := schema.Validate(doc)
+	if err == nil {
+		return nil, nil
+	}
+	var verr *jsonschema.ValidationError
+	if !errors.As(err, &verr) {
+		return nil, err
+	}
+	var problems []string
+	collectViolations(verr, &problems)
+	return problems, nil
+}
+
+func collectViolations(e *jsonschema.ValidationError, out *[]string) {
+	if len(e.Causes) == 0 {
+		loc := e.InstanceLocation
+		if loc == "" {
+			loc = "/"
+		}
+		*out = append(*out, fmt.Sprintf("%s: %s", loc, e.Message))
+		return
+	}
+	for _, cause := range e.Causes {
+		collectViolations(cause, out)
+	}
+}
+
+// validateBody rejects requests whose JSON body doesn't satisfy the named
+// schema. The body is restored so handlers can still bind it.
+func (app *App) validateBody(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxBodyBytes))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		problems, err := app.schemas.Validate(name, body)
+		if err != nil {
+			app.logCtx(c.Request.Context(), "error", "Schema validation failed", map[string]interface{}{"schema": name, "error": err.Error()})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Schema validation failed"})
+			c.Abort()
+			return
+		}
+		if len(problems) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":      "Request body does not match schema",
+				"schema":     name,
+				"violations": problems,
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// validateOptionalBody is validateBody for routes whose body may be left out;
+// requests without one go straight to the handler.
+func (app *App) validateOptionalBody(name string) gin.HandlerFunc {
+	validate := app.validateBody(name)
+	return func(c *gin.Context) {
+		if c.Request.ContentLength <= 0 {
+			c.Next()
+			return
+		}
+		validate(c)
+	}
+}
+
+func (app *App) listSchemasHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"schemas": app.schemas.Names()})
+}
+
+func (app *App) getSchemaHandler(c *gin.Context) {
+	raw, ok := app.schemas.raw[c.Param("name")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schema not found"})
+		return
+	}
+	c.Data(http.StatusOK, "application/schema+json", raw)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestAPIOperationBodiesExist(t *testing.T) {
+	app := newTestApp(t, nil)
+	for route, op := range apiOperations {
+		if op.Body == "" {
+			continue
+		}
+		if _, ok := app.schemas.compiled[op.Body]; !ok {
+			t.Errorf("%s documents body %q, which is not in schemas/", route, op.Body)
+		}
+	}
+}
+
+func TestAdminBodiesValidated(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{"POST", "/api/admin/demo-sessions", `{"name": "demo", "seed_count": 5000}`},
+		{"POST", "/api/admin/datasets", `{"name": "Not A Name"}`},
+		{"POST", "/api/admin/privacy/erase", `{"reason": "no account"}`},
+		{"POST", "/api/admin/privacy/exports", `{"account": ""}`},
+		{"POST", "/api/admin/fraud/evaluate", `{"from_account": "A", "to_account": "B", "amount": -1}`},
+		{"POST", "/api/admin/fraud/shadow", `{"duration_sec": 1}`},
+		{"POST", "/api/admin/capture", `{"count": 0}`},
+		{"POST", "/api/admin/duplicates/merge", `{"canonical_id": "a", "duplicate_ids": []}`},
+		{"PUT", "/api/admin/counterparties/ACC-1", `{"name": "Shop", "risk_tier": "extreme"}`},
+		{"PUT", "/api/admin/chaos", `{"inject_error_rate": 2}`},
+		{"POST", "/api/admin/chaos/scenarios/run", `{}`},
+		{"POST", "/oauth/demo-token", `{"subject": "ops", "role": "superuser"}`},
+		{"POST", "/oauth/demo-token", `{"subject": "ops", "role": "admin", "scopes": ["admin"]}`},
+	}
+	r := newTestApp(t, func(c *Config) { c.DemoTokensEnabled = true }).newRouter()
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			w := serve(r, tt.method, tt.path, json.RawMessage(tt.body), nil)
+			var resp struct {
+				Error string `json:"error"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &resp)
+			if w.Code != http.StatusBadRequest || resp.Error != "Request body does not match schema" {
+				t.Errorf("got %d %s, want a schema violation", w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func TestOptionalBodyMayBeLeftOut(t *testing.T) {
+	r := newTestApp(t, nil).newRouter()
+	w := serve(r, http.MethodPost, "/api/admin/capture", nil, nil)
+	if w.Code != http.StatusCreated {
+		t.Errorf("POST /api/admin/capture without a body: %d %s", w.Code, w.Body)
+	}
+	w = serve(r, http.MethodPost, "/api/admin/capture", json.RawMessage(`{"count": 3}`), nil)
+	if w.Code == http.StatusBadRequest {
+		t.Errorf("POST /api/admin/capture with a valid body: %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+)
+
+// SeedPersona describes one kind of believable payment, such as a salary, a
+// rent payment or a coffee. Templates may use {merchant}, {month}, {ref} and
+// {store}. Payments go from a customer account to the merchant's account, or
+// the other way round when Direction is "in". Amounts are drawn between
+// MinAmount and MaxAmount and rounded to a multiple of Round (cents when 0).
+type SeedPersona struct {
+	Name      string   `json:"name" yaml:"name"`
+	Weight    int      `json:"weight" yaml:"weight"`
+	Direction string   `json:"direction" yaml:"direction"`
+	Merchants []string `json:"merchants" yaml:"merchants"`
+	Templates []string `json:"templates" yaml:"templates"`
+	MinAmount float64  `json:"min_amount" yaml:"min_amount"`
+	MaxAmount float64  `json:"max_amount" yaml:"max_amount"`
+	Round     float64  `json:"round,omitempty" yaml:"round"`
+}
+
+// defaultSeedPersonas are used when SEED_PERSONAS_FILE is not set.
+var defaultSeedPersonas = []SeedPersona{
+	{Name: "salary", Weight: 2, Direction: "in", MinAmount: 2200, MaxAmount: 6500,
+		Merchants: []string{"Northwind Traders", "Globex Corporation", "Initech", "Umbrella Health"},
+		Templates: []string{"Salary {month} - {merchant}", "{merchant} payroll {month}"}},
+	{Name: "rent", Weight: 2, MinAmount: 900, MaxAmount: 2400, Round: 25,
+		Merchants: []string{"Oakwood Property Management", "Parkside Lettings", "Riverside Apartments"},
+		Templates: []string{"Rent {month} - {merchant}", "{merchant} monthly rent"}},
+	{Name: "coffee", Weight: 10, MinAmount: 2.5, MaxAmount: 14,
+		Merchants: []string{"Blue Bottle Coffee", "Starbucks", "Caffè Nero", "Pret A Manger", "Joe & The Juice"},
+		Templates: []string{"{merchant} #{store}", "{merchant} store {store}"}},
+	{Name: "groceries", Weight: 8, MinAmount: 12, MaxAmount: 185,
+		Merchants: []string{"Whole Foods Market", "Trader Joe's", "Lidl", "Tesco Express", "Safeway"},
+		Templates: []string{"{merchant} #{store}", "{merchant} groceries"}},
+	{Name: "utilities", Weight: 2, MinAmount: 35, MaxAmount: 220,
+		Merchants: []string{"City Power & Light", "Metro Water", "Comcast Xfinity"},
+		Templates: []string{"{merchant} bill {month}", "{merchant} ref {ref}"}},
+	{Name: "subscriptions", Weight: 3, MinAmount: 4.99, MaxAmount: 59.99,
+		Merchants: []string{"Netflix", "Spotify", "Adobe Creative Cloud", "GitHub"},
+		Templates: []string{"{merchant} subscription", "{merchant} monthly plan"}},
+	{Name: "supplier", Weight: 3, MinAmount: 250, MaxAmount: 8000,
+		Merchants: []string{"Acme Supplies", "Contoso Ltd", "Fabrikam Inc"},
+		Templates: []string{"Invoice INV-{ref} {merchant}", "{merchant} supplier settlement"}},
+}
+
+// loadSeedPersonas reads the personas from path, a YAML file with a top-level
+// personas list, or returns the defaults when path is empty.
+func loadSeedPersonas(path string) ([]SeedPersona, error) {
+	if path == "" {
+		return defaultSeedPersonas, nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		Personas []SeedPersona `yaml:"personas"`
+	}
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(doc.Personas) == 0 {
+		return nil, fmt.Errorf("%s: no personas defined", path)
+	}
+	seen := map[string]bool{}
+	for i := range doc.Personas {
+		p := &doc.Personas[i]
+		if p.Weight == 0 {
+			p.Weight = 1
+		}
+		if p.Direction == "" {
+			p.Direction = "out"
+		}
+		switch {
+		case p.Name == "" || seen[p.Name]:
+			return nil, fmt.Errorf("%s: persona %d needs a unique name", path, i+1)
+		case p.Direction != "in" && p.Direction != "out":
+			return nil, fmt.Errorf("%s: persona %s: direction must be in or out", path, p.Name)
+		case len(p.Merchants) == 0 || len(p.Templates) == 0:
+			return nil, fmt.Errorf("%s: persona %s needs merchants and templates", path, p.Name)
+		case p.Weight < 0 || p.MinAmount <= 0 || p.MaxAmount < p.MinAmount || p.Round < 0:
+			return nil, fmt.Errorf("%s: persona %s: need weight >= 0, 0 < min_amount <= max_amount and round >= 0", path, p.Name)
+		}
+		seen[p.Name] = true
+	}
+	return doc.Personas, nil
+}
+
+// selectPersonas narrows the loaded personas to names, or returns all of them
+// when names is empty.
+func (app *App) selectPersonas(names []string) ([]SeedPersona, error) {
+	if len(names) == 0 {
+		return app.seedPersonas, nil
+	}
+	var selected []SeedPersona
+	for _, name := range names {
+		found := false
+		for _, p := range app.seedPersonas {
+			if p.Name == name {
+				selected = append(selected, p)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown persona %q", name)
+		}
+	}
+	return selected, nil
+}
+
+func pickPersona(personas []SeedPersona) SeedPersona {
+	total := 0
+	for _, p := range personas {
+		total += p.Weight
+	}
+	if total <= 0 {
+		return personas[rand.Intn(len(personas))]
+	}
+	n := rand.Intn(total)
+	for _, p := range personas {
+		if n < p.Weight {
+			return p
+		}
+		n -= p.Weight
+	}
+	return personas[len(personas)-1]
+}
+
+// merchantAccount gives each merchant a stable account, so seeded data can
+// be searched and grouped by payee.
+func merchantAccount(merchant string) string {
+	h := fnv.New32a()
+	h.Write([]byte(merchant))
+	return fmt.Sprintf("ACC-%d", 2000+h.Sum32()%100)
+}
+
+// generate draws one payment from the persona, dated at.
+func (p SeedPersona) generate(at time.Time) Transaction {
+	merchant := p.Merchants[rand.Intn(len(p.Merchants))]
+	description := strings.NewReplacer(
+		"{merchant}", merchant,
+		"{month}", at.Format("January 2006"),
+		"{ref}", strconv.Itoa(100000+rand.Intn(900000)),
+		"{store}", strconv.Itoa(100+rand.Intn(900)),
+	).Replace(p.Templates[rand.Intn(len(p.Templates))])
+
+	amount := p.MinAmount + rand.Float64()*(p.MaxAmount-p.MinAmount)
+	if p.Round > 0 {
+		amount = math.Max(p.Round, math.Round(amount/p.Round)*p.Round)
+	}
+
+	customer := fmt.Sprintf("ACC-%d", 1000+rand.Intn(10))
+	txn := Transaction{
+		FromAccount: customer,
+		ToAccount:   merchantAccount(merchant),
+		Amount:      math.Round(amount*100) / 100,
+		Description: description,
+	}
+	if p.Direction == "in" {
+		txn.FromAccount, txn.ToAccount = txn.ToAccount, txn.FromAccount
+	}
+	return txn
+}
+
+// generateSeedTransactions builds n transactions from personas spread over
+// the last day, with roughly the same failure rate as live traffic.
+func generateSeedTransactions(personas []SeedPersona, n int) []Transaction {
+	now := time.Now().UTC()
+	txns := make([]Transaction, 0, n)
+	for i := 0; i < n; i++ {
+		createdAt := now.Add(-time.Duration(rand.Int63n(int64(24 * time.Hour)))).Truncate(time.Microsecond)
+		txn := pickPersona(personas).generate(createdAt)
+		txn.ID = uuid.New().String()
+		txn.Status = "success"
+		if rand.Float64() < 0.05 {
+			txn.Status = "failed"
+		}
+		txn.CreatedAt = createdAt
+		txn.StatusToken = newStatusToken()
+		txns = append(txns, txn)
+	}
+	return txns
+}
+
+// seedSampleHandler returns payment requests drawn from the personas, ready to
+// POST to /api/transactions, so traffic generators such as the dashboard's
+// load button send believable data. ?persona= takes a comma-separated list.
+func (app *App) seedSampleHandler(c *gin.Context) {
+	count, err := strconv.Atoi(c.DefaultQuery("count", "10"))
+	if err != nil || count < 1 || count > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "count must be between 1 and 100"})
+		return
+	}
+	var names []string
+	if persona := c.Query("persona"); persona != "" {
+		names = strings.Split(persona, ",")
+	}
+	personas, err := app.selectPersonas(names)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	type sample struct {
+		Persona     string  `json:"persona"`
+		FromAccount string  `json:"from_account"`
+		ToAccount   string  `json:"to_account"`
+		Amount      float64 `json:"amount"`
+		Description string  `json:"description"`
+	}
+	now := time.Now().UTC()
+	samples := make([]sample, 0, count)
+	for i := 0; i < count; i++ {
+		p := pickPersona(personas)
+		txn := p.generate(now)
+		samples = append(samples, sample{p.Name, txn.FromAccount, txn.ToAccount, txn.Amount, txn.Description})
+	}
+	c.JSON(http.StatusOK, gin.H{"transactions": samples})
+}
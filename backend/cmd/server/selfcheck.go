@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// clockSkewWarn is the clock difference to the database worth a warning.
+// Beyond SIGNATURE_MAX_SKEW_SEC it is critical: token expiry and signed
+// request windows stop meaning what they say.
+const clockSkewWarn = time.Second
+
+// expectedTables are created by the migrations; any missing one means the
+// schema setup failed part way.
+var expectedTables = []string{
+	"accounts", "api_keys", "close_exceptions", "counterparties", "daily_close_entries", "daily_closes", "datasets",
+	"demo_sessions", "fraud_alert_spikes", "fraud_alert_summaries", "fraud_backfills", "fraud_rules", "privacy_erasures", "settlements", "subject_exports",
+	"token_vault", "token_vault_keys", "transaction_audit", "transactions",
+}
+
+// SelfCheck is the outcome of one startup check. Status is ok, warn, fail or
+// skip; only a failed critical check stops a STRICT_STARTUP boot.
+type SelfCheck struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Critical bool   `json:"critical"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+// StartupReport collects the self-checks run once at boot.
+type StartupReport struct {
+	Status      string      `json:"status"`
+	Version     string      `json:"version"`
+	GeneratedAt time.Time   `json:"generated_at"`
+	Checks      []SelfCheck `json:"checks"`
+}
+
+// Failed lists the critical checks that failed.
+func (r *StartupReport) Failed() []string {
+	var names []string
+	for _, c := range r.Checks {
+		if c.Critical && c.Status == "fail" {
+			names = append(names, c.Name)
+		}
+	}
+	return names
+}
+
+// runSelfCheck checks the dependencies and configuration the server just
+// initialized, logs the result as one report and keeps it for
+// /api/admin/startup-report.
+func (app *App) runSelfCheck() *StartupReport {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	report := &StartupReport{Version: appVersion, GeneratedAt: time.Now().UTC()}
+	report.Checks = append(report.Checks, app.checkDatabase(ctx)...)
+	report.Checks = append(report.Checks, app.checkRedis(ctx), app.checkPolicy(ctx), app.checkConfigSanity())
+
+	report.Status = "ok"
+	for _, c := range report.Checks {
+		switch {
+		case c.Status == "fail" && c.Critical:
+			report.Status = "fail"
+		case (c.Status == "fail" || c.Status == "warn") && report.Status == "ok":
+			report.Status = "warn"
+		}
+	}
+	level := map[string]string{"ok": "info", "warn": "warn", "fail": "error"}[report.Status]
+	app.log(level, "Startup self-check", report)
+	app.startupReport = report
+	return report
+}
+
+// checkDatabase covers connectivity, the schema, clock skew against the
+// database and pending migrations. Later checks are skipped when the
+// database is unreachable.
+func (app *App) checkDatabase(ctx context.Context) []SelfCheck {
+	names := []string{"database", "schema", "clock_skew", "migrations"}
+	if app.db == nil {
+		return skipChecks(names, "no database configured")
+	}
+	var version string
+	if err := app.db.QueryRowContext(ctx, `SHOW server_version`).Scan(&version); err != nil {
+		return append([]SelfCheck{{Name: "database", Status: "fail", Critical: true, Detail: err.Error()}},
+			skipChecks(names[1:], "database unreachable")...)
+	}
+	checks := []SelfCheck{{Name: "database", Status: "ok", Critical: true, Detail: "PostgreSQL " + version}}
+
+	schema := SelfCheck{Name: "schema", Status: "ok", Critical: true, Detail: fmt.Sprintf("%d tables present", len(expectedTables))}
+	if missing, err := app.missingTables(ctx); err != nil {
+		schema.Status, schema.Detail = "fail", err.Error()
+	} else if len(missing) > 0 {
+		schema.Status, schema.Detail = "fail", "missing tables: "+strings.Join(missing, ", ")
+	}
+	checks = append(checks, schema)
+
+	skew := SelfCheck{Name: "clock_skew", Critical: true}
+	before := time.Now()
+	var dbNow time.Time
+	if err := app.db.QueryRowContext(ctx, `SELECT NOW()`).Scan(&dbNow); err != nil {
+		skew.Status, skew.Detail = "fail", err.Error()
+	} else {
+		// Compare against the midpoint of the round trip.
+		d := dbNow.Sub(before.Add(time.Since(before) / 2))
+		if d < 0 {
+			d = -d
+		}
+		skew.Status, skew.Detail = "ok", "server clock is "+d.Round(time.Millisecond).String()+" from the database"
+		if d > time.Duration(app.config.SignatureMaxSkewSec)*time.Second {
+			skew.Status = "fail"
+		} else if d > clockSkewWarn {
+			skew.Status = "warn"
+		}
+	}
+	checks = append(checks, skew)
+
+	return append(checks, app.checkMigrations(ctx))
+}
+
+func (app *App) missingTables(ctx context.Context) ([]string, error) {
+	rows, err := app.db.QueryContext(ctx, `
+		SELECT table_name FROM information_schema.tables WHERE table_schema = current_schema()
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	present := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		present[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	var missing []string
+	for _, t := range expectedTables {
+		if !present[t] {
+			missing = append(missing, t)
+		}
+	}
+	return missing, nil
+}
+
+// checkRedis is not critical: without Redis the server runs with local
+// caches and rate limits.
+func (app *App) checkRedis(ctx context.Context) SelfCheck {
+	check := SelfCheck{Name: "redis", Status: "ok", Detail: app.config.RedisHost + ":" + app.config.RedisPort}
+	if app.config.CacheMode != "redis" {
+		check.Status, check.Detail = "skip", "CACHE_MODE is "+app.config.CacheMode
+	} else if app.redisClient == nil {
+		check.Status, check.Detail = "skip", "no Redis client"
+	} else if err := app.redisClient.Ping(ctx).Err(); err != nil {
+		check.Status, check.Detail = "warn", err.Error()
+	}
+	return check
+}
+
+// checkPolicy is not critical: POLICY_FALLBACK decides while OPA is down.
+func (app *App) checkPolicy(ctx context.Context) SelfCheck {
+	check := SelfCheck{Name: "policy", Status: "ok", Detail: app.config.OPAURL}
+	if app.policy == nil {
+		check.Status, check.Detail = "skip", "POLICY_ENGINE is off"
+	} else if err := app.policy.Healthy(ctx); err != nil {
+		check.Status, check.Detail = "warn", fmt.Sprintf("%v; POLICY_FALLBACK=%s applies", err, app.config.PolicyFallback)
+	}
+	return check
+}
+
+// checkConfigSanity flags settings that load fine but are wrong for anything
+// beyond a local demo. loadConfig has already rejected invalid values.
+func (app *App) checkConfigSanity() SelfCheck {
+	cfg := app.config
+	var warnings []string
+	if cfg.AdminToken == "" && app.oidc == nil {
+		warnings = append(warnings, "admin endpoints are unauthenticated (ADMIN_TOKEN and OIDC not set)")
+	}
+	if cfg.OAuthSigningKey == "" && (len(app.oauthClients) > 0 || cfg.DemoTokensEnabled) {
+		warnings = append(warnings, "OAUTH_SIGNING_KEY not set, tokens use an ephemeral key")
+	}
+	if cfg.DemoTokensEnabled {
+		warnings = append(warnings, "DEMO_TOKENS_ENABLED lets anyone mint admin tokens")
+	}
+	var injections []string
+	for name, on := range map[string]bool{
+		"INJECT_OOM":                cfg.InjectOOM,
+		"INJECT_LATENCY_MS":         cfg.InjectLatencyMs > 0,
+		"INJECT_ERROR_RATE":         cfg.InjectErrorRate > 0,
+		"INJECT_CPU_BURN":           cfg.InjectCPUBurn,
+		"INJECT_PANIC":              cfg.InjectPanic,
+		"INJECT_DB_TIMEOUT":         cfg.InjectDBTimeout,
+		"INJECT_REPLICATION_LAG_MS": cfg.InjectReplicationLagMs > 0,
+	} {
+		if on {
+			injections = append(injections, name)
+		}
+	}
+	if len(injections) > 0 {
+		sort.Strings(injections)
+		warnings = append(warnings, "bug injection enabled: "+strings.Join(injections, ", "))
+	}
+
+	if len(warnings) == 0 {
+		return SelfCheck{Name: "config", Status: "ok"}
+	}
+	return SelfCheck{Name: "config", Status: "warn", Detail: strings.Join(warnings, "; ")}
+}
+
+func skipChecks(names []string, reason string) []SelfCheck {
+	checks := make([]SelfCheck, len(names))
+	for i, name := range names {
+		checks[i] = SelfCheck{Name: name, Status: "skip", Detail: reason}
+	}
+	return checks
+}
+
+func (app *App) getStartupReportHandler(c *gin.Context) {
+	if app.startupReport == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Startup self-check has not run"})
+		return
+	}
+	c.JSON(http.StatusOK, app.startupReport)
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/infrasage/payflow/internal/store"
+)
+
+// The functions in this file are the operations the REST handlers and the
+// gRPC service share. They take plain values instead of a gin.Context and
+// leave protocol concerns (status codes, locales, pagination parameters) to
+// their callers.
+
+// errDatabaseUnavailable means a payment could be neither written nor
+// spooled.
+var errDatabaseUnavailable = errors.New("database unavailable")
+
+// errPaymentRejected means the database refused the payment for a reason
+// that retrying, or spooling it for later, wouldn't fix.
+var errPaymentRejected = errors.New("payment rejected by the database")
+
+// errAmountPrecision is the answer for a money amount that has more decimal
+// places than the currency's minor unit.
+var errAmountPrecision = errors.New("amount must have at most 2 decimal places")
+
+// wholeCents reports whether amount has at most two decimal places as the
+// client wrote it: the shortest decimal that parses back to the same float
+// is what a JSON client sent. Amounts are checked rather than rounded, so a
+// payment of 0.004 is refused instead of becoming one of 0.00.
+func wholeCents(amount float64) bool {
+	_, frac, _ := strings.Cut(strconv.FormatFloat(amount, 'f', -1, 64), ".")
+	return len(frac) <= 2
+}
+
+// PaymentRequest is a validated request to move money. Amount is positive
+// and in whole cents.
+type PaymentRequest struct {
+	FromAccount string
+	ToAccount   string
+	Amount      float64
+	Description string
+	SessionID   string
+}
+
+// createPayment stores a payment, spooling it when the database is down, and
+// feeds metrics, anomaly detection and fraud analysis. A payment declined for
+// insufficient funds comes back with status "failed" and no error, one held
+// for fraud review with status "held". spooled reports that the payment was
+// queued rather than written.
+func (app *App) createPayment(ctx context.Context, req PaymentRequest) (txn Transaction, spooled bool, err error) {
+	txn = Transaction{
+		ID:          uuid.New().String(),
+		FromAccount: req.FromAccount,
+		ToAccount:   req.ToAccount,
+		Amount:      req.Amount,
+		Description: req.Description,
+		Status:      "success",
+		CreatedAt:   time.Now().UTC().Truncate(time.Microsecond),
+		StatusToken: newStatusToken(),
+		SessionID:   req.SessionID,
+		// Stored with the transaction, so an analysis lost to a full queue
+		// or a crash is picked up again by the fraud recovery sweep.
+		FraudStatus: "pending",
+	}
+	captureFrom(ctx).setTransaction(txn.ID)
+	assessed := app.holdForReview(ctx, &txn)
+
+	if err := app.insertTransaction(ctx, &txn); err != nil {
+		app.eventCtx(ctx, "error", EventTransactionWriteFailed, txn.ID, "Failed to save transaction", map[string]interface{}{
+			"error":    err.Error(),
+			"category": classifyDBError(err),
+		})
+		if !dbErrorRetryable(err) {
+			return txn, false, errPaymentRejected
+		}
+		if app.spool == nil {
+			return txn, false, errDatabaseUnavailable
+		}
+		if err := app.spool.Enqueue(txn); err != nil {
+			spoolOperationsTotal.WithLabelValues("failed").Inc()
+			app.logCtx(ctx, "error", "Failed to spool transaction", map[string]interface{}{
+				"transaction_id": txn.ID,
+				"error":          err.Error(),
+			})
+			return txn, false, errDatabaseUnavailable
+		}
+		spoolOperationsTotal.WithLabelValues("enqueued").Inc()
+		spoolDepth.Set(float64(app.spool.Depth()))
+		app.eventCtx(ctx, "warn", EventTransactionSpooled, txn.ID, "Transaction spooled for later write", map[string]interface{}{"depth": app.spool.Depth()})
+		spooled = true
+	}
+
+	transactionsTotal.WithLabelValues(txn.Status).Inc()
+	transactionAmount.WithLabelValues(txn.Status).Observe(txn.Amount)
+	app.anomalies.Record(txn)
+	if !spooled {
+		app.fraudPool.SubmitAssessed(txn, assessed)
+	}
+	if txn.Status == "held" {
+		app.eventCtx(ctx, "warn", EventTransactionHeld, txn.ID, "Transaction held for fraud review", map[string]interface{}{
+			"amount":   txn.Amount,
+			"score":    assessed.Score,
+			"hits":     assessed.Hits,
+			"decision": assessed.Decision,
+		})
+		return txn, spooled, nil
+	}
+	if txn.Status == "failed" {
+		app.eventCtx(ctx, "error", EventTransactionDeclined, txn.ID, "Transaction failed: insufficient funds", map[string]interface{}{
+			"from_account": txn.FromAccount,
+			"amount":       txn.Amount,
+			"error_code":   "INSUFFICIENT_FUNDS",
+		})
+		return txn, spooled, nil
+	}
+	app.eventCtx(ctx, "info", EventTransactionCreated, txn.ID, "Transaction processed", map[string]interface{}{
+		"amount": txn.Amount,
+		"status": txn.Status,
+	})
+	if !spooled {
+		app.webhooks.Dispatch(ctx, WebhookTransactionCreated, txn.SessionID, webhookPayload(txn))
+		app.publisher.PublishTransaction(ctx, txn)
+	}
+	return txn, spooled, nil
+}
+
+// listTransactions reads one page of the transactions f selects and returns
+// them counterparty-enriched, with the total count.
+func (app *App) listTransactions(ctx context.Context, f store.TransactionFilter) (TransactionPage, error) {
+	page := TransactionPage{Data: []Transaction{}, Limit: f.Limit, Offset: f.Offset}
+	data, total, err := app.transactions.List(ctx, f)
+	if err != nil {
+		app.logCtx(ctx, "error", "Failed to fetch transactions", map[string]interface{}{"error": err.Error()})
+		return page, err
+	}
+	page.Data, page.Total = data, total
+	app.enricher.Annotate(ctx, page.Data)
+	return page, nil
+}
+
+// StatsSummary holds the figures behind the dashboard statistics.
+type StatsSummary struct {
+	RevenueMinor int64
+	Transactions int
+	Successful   int
+}
+
+// SuccessRate is the share of settled transactions in percent.
+func (s StatsSummary) SuccessRate() float64 {
+	if s.Transactions == 0 {
+		return 0
+	}
+	return float64(s.Successful) / float64(s.Transactions) * 100
+}
+
+// computeStats sums the session's revenue and counts its transactions. It
+// returns zeros without a database.
+func (app *App) computeStats(ctx context.Context, session string) StatsSummary {
+	var stats StatsSummary
+	if app.db == nil {
+		return stats
+	}
+	arg := sessionArg(session)
+	// Refunded payments still settled; their refunds are netted out of
+	// revenue instead. Transfers between sub-accounts of one parent aren't
+	// revenue at all.
+	// Summed as NUMERIC and converted to minor units in SQL, so large
+	// totals never pass through a float.
+	app.readPool().QueryRowContext(ctx, "SELECT ROUND(COALESCE(SUM(CASE WHEN refund_of IS NULL THEN amount ELSE -amount END), 0) * $2)::BIGINT FROM transactions WHERE status IN "+settledStatuses+" AND NOT internal AND session_id IS NOT DISTINCT FROM $1", arg, minorUnitScale(app.config.Currency)).Scan(&stats.RevenueMinor)
+	app.readPool().QueryRowContext(ctx, "SELECT COUNT(*) FROM transactions WHERE session_id IS NOT DISTINCT FROM $1", arg).Scan(&stats.Transactions)
+	app.readPool().QueryRowContext(ctx, "SELECT COUNT(*) FROM transactions WHERE status IN "+settledStatuses+" AND session_id IS NOT DISTINCT FROM $1", arg).Scan(&stats.Successful)
+	return stats
+}
+
+// FraudAlert is a transaction the fraud rules flagged, as recorded in its
+// audit trail. ID is the audit entry's. Status is open until an operator
+// acknowledges or resolves it.
+type FraudAlert struct {
+	ID int64 `json:"id"`
+	FraudAssessment
+	FlaggedAt time.Time  `json:"flagged_at"`
+	Status    string     `json:"status"`
+	TriagedBy string     `json:"triaged_by,omitempty"`
+	TriagedAt *time.Time `json:"triaged_at,omitempty"`
+	Note      string     `json:"note,omitempty"`
+}
+
+// fraudAlertColumns whitelists the fields fraud alerts are filtered and
+// sorted by.
+var fraudAlertColumns = map[string]string{
+	"id":         "a.id",
+	"action":     "a.action",
+	"session_id": "t.session_id",
+	"severity":   "a.details->>'decision'",
+	"hits":       "a.details->'hits'",
+	"status":     "COALESCE(tr.status, 'open')",
+	"flagged_at": "a.created_at",
+}
+
+// newFraudAlertQuery starts a query for the session's fraud alerts, newest
+// first.
+func newFraudAlertQuery(session string) *store.QueryBuilder {
+	return store.NewQueryBuilder(fraudAlertColumns).
+		Where("action", store.OpEq, "fraud_flagged").
+		Where("session_id", store.OpNotDistinct, sessionArg(session)).
+		OrderBy("id", true)
+}
+
+// listFraudAlerts returns the session's most recently flagged transactions.
+func (app *App) listFraudAlerts(ctx context.Context, session string, limit int) ([]FraudAlert, error) {
+	return app.queryFraudAlerts(ctx, newFraudAlertQuery(session), limit, 0)
+}
+
+// queryFraudAlerts returns a page of the fraud alerts qb selects. Alerts
+// whose details can't be read are left out.
+func (app *App) queryFraudAlerts(ctx context.Context, qb *store.QueryBuilder, limit, offset int) ([]FraudAlert, error) {
+	alerts := []FraudAlert{}
+	if app.db == nil {
+		return alerts, errDatabaseUnavailable
+	}
+	limitArg, offsetArg := qb.Arg(limit), qb.Arg(offset)
+	where, args, err := qb.WhereClause()
+	if err != nil {
+		return alerts, err
+	}
+	rows, err := app.readPool().QueryContext(ctx, `
+		SELECT a.id, a.details, a.created_at, COALESCE(tr.status, 'open'), COALESCE(tr.actor, ''), tr.updated_at, COALESCE(tr.note, '')
+		FROM transaction_audit a
+		JOIN transactions t ON t.id = a.transaction_id
+		LEFT JOIN fraud_alert_triage tr ON tr.alert_id = a.id`+where+qb.OrderClause()+`
+		LIMIT `+limitArg+` OFFSET `+offsetArg, args...)
+	if err != nil {
+		app.logCtx(ctx, "error", "Failed to list fraud alerts", map[string]interface{}{"error": err.Error()})
+		return alerts, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var details []byte
+		var alert FraudAlert
+		var triagedAt sql.NullTime
+		if err := rows.Scan(&alert.ID, &details, &alert.FlaggedAt, &alert.Status, &alert.TriagedBy, &triagedAt, &alert.Note); err != nil {
+			continue
+		}
+		if err := json.Unmarshal(details, &alert.FraudAssessment); err != nil {
+			continue
+		}
+		if triagedAt.Valid {
+			alert.TriagedAt = &triagedAt.Time
+		}
+		alerts = append(alerts, alert)
+	}
+	return alerts, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/infrasage/payflow/internal/store"
+)
+
+// Settlement is the transfer of one closed day's settled volume to
+// BANK_SETTLEMENT_ACCOUNT. Status is pending until the bank answers, then
+// settled or rejected.
+type Settlement struct {
+	Day            string     `json:"day"`
+	Amount         float64    `json:"amount"`
+	Status         string     `json:"status"`
+	BankTransferID string     `json:"bank_transfer_id,omitempty"`
+	Attempts       int        `json:"attempts"`
+	LastError      string     `json:"last_error,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	SettledAt      *time.Time `json:"settled_at,omitempty"`
+}
+
+// settleClosedDays queues a settlement for each closed day with settled
+// volume and sends the pending ones to the bank, oldest first. It stops at
+// the first day the bank doesn't answer for, which stays pending for the
+// next run. Replicas may send the same day at once; the idempotency key
+// keeps that to one transfer.
+func (app *App) settleClosedDays(ctx context.Context) error {
+	if app.bank == nil {
+		return nil
+	}
+	if _, err := app.jobPool().ExecContext(ctx, `
+		INSERT INTO settlements (day, amount)
+		SELECT day, credits FROM daily_closes WHERE credits > 0
+		ON CONFLICT (day) DO NOTHING
+	`); err != nil {
+		return err
+	}
+	rows, err := app.jobPool().QueryContext(ctx, `
+		SELECT TO_CHAR(day, 'YYYY-MM-DD'), amount FROM settlements WHERE status = 'pending' ORDER BY day
+	`)
+	if err != nil {
+		return err
+	}
+	var pending []Settlement
+	for rows.Next() {
+		var s Settlement
+		if err := rows.Scan(&s.Day, &s.Amount); err != nil {
+			rows.Close()
+			return err
+		}
+		pending = append(pending, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, s := range pending {
+		if err := app.settleDay(ctx, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// settleDay sends one pending settlement and records the bank's answer. A
+// failed request only counts the attempt.
+func (app *App) settleDay(ctx context.Context, s Settlement) error {
+	booked, err := app.bank.Transfer(ctx, "payflow-settlement-"+s.Day, BankTransfer{
+		Reference: "settlement-" + s.Day,
+		Type:      "settlement",
+		Amount:    s.Amount,
+		Currency:  app.config.Currency,
+		Account:   app.config.BankSettlementAccount,
+	})
+	if err != nil {
+		if _, dbErr := app.jobPool().ExecContext(ctx, `
+			UPDATE settlements SET attempts = attempts + 1, last_error = $2 WHERE day = $1::DATE AND status = 'pending'
+		`, s.Day, err.Error()); dbErr != nil {
+			return dbErr
+		}
+		return err
+	}
+	status, lastError := "settled", sql.NullString{}
+	if booked.Status != "accepted" {
+		status, lastError = "rejected", sql.NullString{String: booked.Reason, Valid: true}
+	}
+	res, err := app.jobPool().ExecContext(ctx, `
+		UPDATE settlements
+		SET status = $2, bank_transfer_id = $3, last_error = $4, attempts = attempts + 1,
+			settled_at = CASE WHEN $2 = 'settled' THEN CURRENT_TIMESTAMP END
+		WHERE day = $1::DATE AND status = 'pending'
+	`, s.Day, status, booked.ID, lastError)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// Another replica recorded the answer first.
+		return nil
+	}
+	attrs := map[string]interface{}{
+		"amount":           s.Amount,
+		"bank_transfer_id": booked.ID,
+	}
+	if status == "rejected" {
+		attrs["reason"] = booked.Reason
+		app.event("error", EventSettlementRejected, s.Day, "Bank rejected the day's settlement", attrs)
+		return nil
+	}
+	app.event("info", EventDaySettled, s.Day, "Day settled with the bank", attrs)
+	return nil
+}
+
+// listSettlementsHandler returns settlements newest first, optionally with
+// one ?status.
+func (app *App) listSettlementsHandler(c *gin.Context) {
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	limit, err := pageParam(c, "limit", defaultPageLimit, maxPageLimit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	qb := store.NewQueryBuilder(map[string]string{"day": "day", "status": "status"}).OrderBy("day", true)
+	if status := c.Query("status"); status != "" {
+		qb.Where("status", store.OpEq, status)
+	}
+	limitArg := qb.Arg(limit)
+	where, args, err := qb.WhereClause()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rows, err := app.readPool().QueryContext(c.Request.Context(), `
+		SELECT TO_CHAR(day, 'YYYY-MM-DD'), amount, status, COALESCE(bank_transfer_id, ''), attempts,
+			COALESCE(last_error, ''), created_at, settled_at
+		FROM settlements`+where+qb.OrderClause()+` LIMIT `+limitArg, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	settlements := []Settlement{}
+	for rows.Next() {
+		var s Settlement
+		var settledAt sql.NullTime
+		if err := rows.Scan(&s.Day, &s.Amount, &s.Status, &s.BankTransferID, &s.Attempts, &s.LastError, &s.CreatedAt, &settledAt); err != nil {
+			continue
+		}
+		if settledAt.Valid {
+			s.SettledAt = &settledAt.Time
+		}
+		settlements = append(settlements, s)
+	}
+	c.JSON(http.StatusOK, gin.H{"data": settlements})
+}
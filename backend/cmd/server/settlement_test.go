@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/infrasage/payflow/internal/dbtest"
+)
+
+// fakeSettlements is the settlements table, keyed by day.
+type fakeSettlements struct {
+	days     []string
+	amounts  map[string]float64
+	status   map[string]string
+	attempts map[string]int
+	errors   map[string]string
+}
+
+func (f *fakeSettlements) run(q dbtest.Query) (*dbtest.Rows, error) {
+	switch {
+	case q.HasPrefix("INSERT INTO settlements"):
+		return dbtest.Affected(0), nil
+	case q.HasPrefix("SELECT TO_CHAR(day, 'YYYY-MM-DD'), amount FROM settlements WHERE status = 'pending'"):
+		rows := dbtest.NewRows("day", "amount")
+		for _, day := range f.days {
+			if f.status[day] == "pending" {
+				rows.Add(day, f.amounts[day])
+			}
+		}
+		return rows, nil
+	case q.HasPrefix("UPDATE settlements SET attempts = attempts + 1"):
+		f.attempts[q.String(0)]++
+		f.errors[q.String(0)] = q.String(1)
+		return dbtest.Affected(1), nil
+	case q.HasPrefix("UPDATE settlements SET status = $2"):
+		day := q.String(0)
+		if f.status[day] != "pending" {
+			return dbtest.Affected(0), nil
+		}
+		f.attempts[day]++
+		f.status[day], f.errors[day] = q.String(1), q.String(3)
+		return dbtest.Affected(1), nil
+	}
+	return nil, dbtest.Unexpected(q)
+}
+
+func TestSettleClosedDays(t *testing.T) {
+	var mu sync.Mutex
+	keys := map[string]int{}
+	answers := map[string]int{
+		"settlement-2026-03-01": http.StatusCreated,
+		"settlement-2026-03-02": http.StatusCreated,
+		"settlement-2026-03-03": http.StatusServiceUnavailable,
+	}
+	bank := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var transfer BankTransfer
+		json.NewDecoder(r.Body).Decode(&transfer)
+		mu.Lock()
+		keys[r.Header.Get("Idempotency-Key")]++
+		code := answers[transfer.Reference]
+		mu.Unlock()
+		if transfer.Account != "SETTLE-1" || transfer.Currency != "EUR" || transfer.Type != "settlement" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		transfer.ID, transfer.Status = "bt-"+transfer.Reference, "accepted"
+		if strings.HasSuffix(transfer.Reference, "-02") {
+			transfer.Status, transfer.Reason = "rejected", "account frozen"
+		}
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(transfer)
+	}))
+	defer bank.Close()
+
+	var logs bytes.Buffer
+	app := newTestApp(t, func(c *Config) {
+		c.BankURL, c.BankSettlementAccount, c.Currency = bank.URL, "SETTLE-1", "EUR"
+	})
+	app.logs = newLogger("info", "json", &logs)
+	app.initBank()
+	settlements := &fakeSettlements{
+		days:     []string{"2026-03-01", "2026-03-02", "2026-03-03", "2026-03-04"},
+		amounts:  map[string]float64{"2026-03-01": 100, "2026-03-02": 200, "2026-03-03": 300, "2026-03-04": 400},
+		status:   map[string]string{"2026-03-01": "pending", "2026-03-02": "pending", "2026-03-03": "pending", "2026-03-04": "pending"},
+		attempts: map[string]int{},
+		errors:   map[string]string{},
+	}
+	app.db = dbtest.New(settlements.run).Open(t)
+
+	// The bank is unavailable for the third day, which stops the run there.
+	if err := app.settleClosedDays(context.Background()); err == nil {
+		t.Fatal("settled with the bank failing, want an error")
+	}
+	want := map[string]string{"2026-03-01": "settled", "2026-03-02": "rejected", "2026-03-03": "pending", "2026-03-04": "pending"}
+	for day, status := range want {
+		if settlements.status[day] != status {
+			t.Errorf("%s is %s, want %s", day, settlements.status[day], status)
+		}
+	}
+	if settlements.errors["2026-03-02"] != "account frozen" || settlements.attempts["2026-03-03"] != 1 || settlements.errors["2026-03-03"] == "" {
+		t.Errorf("errors %v, attempts %v", settlements.errors, settlements.attempts)
+	}
+	if keys["payflow-settlement-2026-03-03"] != 3 || keys["payflow-settlement-2026-03-04"] != 0 {
+		t.Errorf("bank saw keys %v, want the failing day tried 3 times and the next not at all", keys)
+	}
+	for _, event := range []string{EventDaySettled, EventSettlementRejected} {
+		if !strings.Contains(logs.String(), `"event_type":"`+event+`"`) {
+			t.Errorf("no %s event in %s", event, logs.String())
+		}
+	}
+
+	// Once the bank is back the rest settle, each under its own key.
+	mu.Lock()
+	answers["settlement-2026-03-03"], answers["settlement-2026-03-04"] = http.StatusCreated, http.StatusCreated
+	mu.Unlock()
+	if err := app.settleClosedDays(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if settlements.status["2026-03-03"] != "settled" || settlements.status["2026-03-04"] != "settled" || settlements.attempts["2026-03-03"] != 2 {
+		t.Errorf("after recovery: status %v, attempts %v", settlements.status, settlements.attempts)
+	}
+	if keys["payflow-settlement-2026-03-01"] != 1 {
+		t.Errorf("settled day sent again: %v", keys)
+	}
+}
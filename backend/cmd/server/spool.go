@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	spoolBucket      = []byte("transactions")
+	deadLetterBucket = []byte("dead_letters")
+)
+
+// errSpoolDeadLetter, returned by a Replay callback, moves the entry to the
+// dead letter bucket instead of stopping the replay.
+var errSpoolDeadLetter = errors.New("spool entry dead-lettered")
+
+// Spool is a durable FIFO of transactions that could not be written to
+// Postgres. Entries are replayed in order once the database is reachable.
+type Spool struct {
+	db *bolt.DB
+}
+
+func openSpool(path string) (*Spool, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 2 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spool %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(spoolBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(deadLetterBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize spool: %w", err)
+	}
+	return &Spool{db: db}, nil
+}
+
+// Enqueue appends a transaction to the spool and fsyncs it to disk.
+func (s *Spool) Enqueue(txn Transaction) error {
+	payload, err := json.Marshal(txn)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(spoolBucket)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		return b.Put(key, payload)
+	})
+}
+
+// Depth returns the number of spooled transactions.
+func (s *Spool) Depth() int {
+	depth := 0
+	s.db.View(func(tx *bolt.Tx) error {
+		depth = tx.Bucket(spoolBucket).Stats().KeyN
+		return nil
+	})
+	return depth
+}
+
+// Replay hands spooled transactions to fn oldest first, removing each one
+// after fn succeeds. It stops at the first failure so ordering is preserved,
+// except that an entry fn answers with errSpoolDeadLetter, or one that can't
+// be decoded, is set aside in the dead letter bucket and replay moves on.
+// Undecodable entries never reach fn and are counted as dead_lettered here.
+func (s *Spool) Replay(fn func(Transaction) error) (int, error) {
+	replayed := 0
+	for {
+		var key, raw []byte
+		err := s.db.View(func(tx *bolt.Tx) error {
+			k, v := tx.Bucket(spoolBucket).Cursor().First()
+			if k == nil {
+				return nil
+			}
+			key = append([]byte(nil), k...)
+			raw = append([]byte(nil), v...)
+			return nil
+		})
+		if err != nil {
+			return replayed, fmt.Errorf("failed to read spool: %w", err)
+		}
+		if key == nil {
+			return replayed, nil
+		}
+		dead := false
+		var txn Transaction
+		if err := json.Unmarshal(raw, &txn); err != nil {
+			// It can't be decoded on any later pass either.
+			spoolOperationsTotal.WithLabelValues("dead_lettered").Inc()
+			dead = true
+		} else if err := fn(txn); errors.Is(err, errSpoolDeadLetter) {
+			dead = true
+		} else if err != nil {
+			return replayed, err
+		}
+		err = s.db.Update(func(tx *bolt.Tx) error {
+			if dead {
+				if err := tx.Bucket(deadLetterBucket).Put(key, raw); err != nil {
+					return err
+				}
+			}
+			return tx.Bucket(spoolBucket).Delete(key)
+		})
+		if err != nil {
+			return replayed, fmt.Errorf("failed to remove replayed entry: %w", err)
+		}
+		if !dead {
+			replayed++
+		}
+	}
+}
+
+func (s *Spool) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func openTestSpool(t *testing.T) *Spool {
+	t.Helper()
+	s, err := openSpool(filepath.Join(t.TempDir(), "spool.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// putRaw appends raw to the spool as Enqueue would a marshaled transaction.
+func putRaw(t *testing.T, s *Spool, raw string) {
+	t.Helper()
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(spoolBucket)
+		seq, _ := b.NextSequence()
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		return b.Put(key, []byte(raw))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func deadLetters(t *testing.T, s *Spool) []string {
+	t.Helper()
+	var out []string
+	s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(deadLetterBucket).ForEach(func(_, v []byte) error {
+			out = append(out, string(v))
+			return nil
+		})
+	})
+	return out
+}
+
+func TestSpoolReplayOrder(t *testing.T) {
+	s := openTestSpool(t)
+	for _, id := range []string{"a", "b", "c"} {
+		if err := s.Enqueue(Transaction{ID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []string
+	n, err := s.Replay(func(txn Transaction) error {
+		got = append(got, txn.ID)
+		return nil
+	})
+	if err != nil || n != 3 || s.Depth() != 0 {
+		t.Fatalf("Replay = %d, %v; depth %d", n, err, s.Depth())
+	}
+	if len(got) != 3 || got[0] != "a" || got[1] != "b" || got[2] != "c" {
+		t.Errorf("replayed %v, want [a b c]", got)
+	}
+}
+
+func TestSpoolReplayStopsOnError(t *testing.T) {
+	s := openTestSpool(t)
+	s.Enqueue(Transaction{ID: "a"})
+	s.Enqueue(Transaction{ID: "b"})
+	down := errors.New("connection refused")
+	n, err := s.Replay(func(txn Transaction) error { return down })
+	if !errors.Is(err, down) || n != 0 || s.Depth() != 2 {
+		t.Errorf("Replay = %d, %v; depth %d, want the spool kept", n, err, s.Depth())
+	}
+}
+
+func TestSpoolReplayDeadLetters(t *testing.T) {
+	s := openTestSpool(t)
+	s.Enqueue(Transaction{ID: "a"})
+	putRaw(t, s, `{"id": "broken"`)
+	s.Enqueue(Transaction{ID: "rejected"})
+	s.Enqueue(Transaction{ID: "b"})
+
+	var got []string
+	n, err := s.Replay(func(txn Transaction) error {
+		if txn.ID == "rejected" {
+			return errSpoolDeadLetter
+		}
+		got = append(got, txn.ID)
+		return nil
+	})
+	if err != nil || n != 2 || s.Depth() != 0 {
+		t.Fatalf("Replay = %d, %v; depth %d", n, err, s.Depth())
+	}
+	if len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("replayed %v, want [a b]", got)
+	}
+	dead := deadLetters(t, s)
+	if len(dead) != 2 || dead[0] != `{"id": "broken"` {
+		t.Errorf("dead letters %q, want the undecodable and the rejected entries", dead)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PublicTransactionStatus is the sanitized view of a transaction that can be
+// shared with an end customer via its status token.
+type PublicTransactionStatus struct {
+	FromAccount string    `json:"from_account"`
+	ToAccount   string    `json:"to_account"`
+	Amount      float64   `json:"amount"`
+	Status      string    `json:"status"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// newStatusToken returns an unguessable URL-safe token (128 bits).
+func newStatusToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// maskAccount keeps only the last four characters of an account identifier.
+func maskAccount(account string) string {
+	if len(account) <= 4 {
+		return strings.Repeat("*", len(account))
+	}
+	return strings.Repeat("*", len(account)-4) + account[len(account)-4:]
+}
+
+func (app *App) getTransactionStatusHandler(c *gin.Context) {
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+
+	var s PublicTransactionStatus
+	err := app.readPool().QueryRowContext(c.Request.Context(), `
+		SELECT from_account, to_account, amount, status, created_at
+		FROM transactions
+		WHERE status_token = $1
+	`, c.Param("token")).Scan(&s.FromAccount, &s.ToAccount, &s.Amount, &s.Status, &s.CreatedAt)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
+		return
+	}
+	if err != nil {
+		app.logCtx(c.Request.Context(), "error", "Failed to fetch transaction status", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	s.FromAccount = maskAccount(s.FromAccount)
+	s.ToAccount = maskAccount(s.ToAccount)
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, s)
+}
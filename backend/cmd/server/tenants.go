@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultTenantSeedCount is how many transactions a tenant starts with when
+// the request doesn't say.
+const defaultTenantSeedCount = 50
+
+// tenantScopes are what a tenant's API key may do: everything a client of
+// the payments API does, inside its own session. Registering webhooks makes
+// the server call out to a URL of the tenant's choosing, so it is only
+// granted when the admin asks for it; see tenantKeyScopes.
+var tenantScopes = []string{"transactions:read", "transactions:write", "accounts:read", "accounts:write"}
+
+// tenantKeyScopes are the scopes of a new tenant's API key, with
+// webhooks:manage added when the admin granted it.
+func tenantKeyScopes(webhooks bool) []string {
+	scopes := append([]string(nil), tenantScopes...)
+	if webhooks {
+		scopes = append(scopes, "webhooks:manage")
+	}
+	return scopes
+}
+
+// tenantSampleAccounts are opened in every tenant. The seeded customer
+// accounts are spread over them, so the tenant's transactions touch its own
+// accounts.
+var tenantSampleAccounts = []struct {
+	suffix  string
+	name    string
+	balance float64
+}{
+	{"CHK", "Everyday checking", 4200},
+	{"SAV", "Savings", 15000},
+	{"BIZ", "Business current", 32000},
+}
+
+// createTenantHandler provisions a playground for one workshop participant
+// in a single call: a demo session, sample accounts in it, seeded
+// transactions between them and persona merchants, and an API key pinned to
+// the session. The response carries everything needed to start calling the
+// API; the key is not shown again. The tenant expires with its session, which
+// also revokes the key.
+func (app *App) createTenantHandler(c *gin.Context) {
+	var req struct {
+		Name      string   `json:"name" binding:"required"`
+		TTLSec    int      `json:"ttl_sec"`
+		SeedCount *int     `json:"seed_count"`
+		Chaos     string   `json:"chaos"`
+		Personas  []string `json:"personas"`
+		Webhooks  bool     `json:"webhooks"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	if req.TTLSec <= 0 {
+		req.TTLSec = app.config.DemoSessionTTLSec
+	}
+	seedCount := defaultTenantSeedCount
+	if req.SeedCount != nil {
+		seedCount = *req.SeedCount
+	}
+	if seedCount < 0 || seedCount > 1000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "seed_count must be between 0 and 1000"})
+		return
+	}
+	personas, err := app.selectPersonas(req.Personas)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "personas: " + err.Error()})
+		return
+	}
+	if req.Chaos != "" {
+		if _, _, err := parseFeatureOverrides(app.config, req.Chaos); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "chaos: " + err.Error()})
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+	session, err := app.openDemoSession(ctx, req.Name, req.Chaos, req.TTLSec)
+	if err != nil {
+		app.logCtx(ctx, "error", "Failed to create tenant", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	accounts, key, record, err := app.provisionTenant(ctx, session, tenantKeyScopes(req.Webhooks), adminActor(c))
+	if err != nil {
+		app.logCtx(ctx, "error", "Failed to provision tenant", map[string]interface{}{"session_id": session.ID, "error": err.Error()})
+		// Leave nothing half provisioned behind.
+		if _, err := app.purgeSessions(ctx, `id = $1`, session.ID); err != nil {
+			app.logCtx(ctx, "warn", "Failed to remove tenant", map[string]interface{}{"session_id": session.ID, "error": err.Error()})
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	txns := generateSeedTransactions(personas, seedCount)
+	for i := range txns {
+		txns[i].FromAccount = tenantAccount(accounts, txns[i].FromAccount)
+		txns[i].ToAccount = tenantAccount(accounts, txns[i].ToAccount)
+	}
+	seeded := app.seedDemoSession(ctx, session.ID, txns)
+
+	app.eventCtx(ctx, "info", EventTenantProvisioned, session.ID, "Tenant provisioned", map[string]interface{}{
+		"name":       session.Name,
+		"api_key_id": record.ID,
+		"accounts":   len(accounts),
+		"seeded":     seeded,
+		"expires_at": session.ExpiresAt,
+		"actor":      record.CreatedBy,
+	})
+	baseURL := requestBaseURL(c) + "/api"
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusCreated, gin.H{
+		"tenant":   session,
+		"api_key":  record,
+		"key":      key,
+		"accounts": accounts,
+		"seeded":   seeded,
+		"connection": gin.H{
+			"base_url": baseURL,
+			"headers":  gin.H{"X-API-Key": key},
+			"docs":     baseURL + "/docs",
+			"example":  "curl -H 'X-API-Key: " + key + "' " + baseURL + "/transactions",
+		},
+	})
+}
+
+// provisionTenant opens the sample accounts of session and issues its API
+// key with scopes.
+func (app *App) provisionTenant(ctx context.Context, session DemoSession, scopes []string, actor string) ([]Account, string, APIKey, error) {
+	prefix := "TEN-" + strings.ToUpper(session.ID[:8]) + "-"
+	accounts := make([]Account, 0, len(tenantSampleAccounts))
+	for _, sample := range tenantSampleAccounts {
+		id, err := app.vault.Tokenize(ctx, app.db, prefix+sample.suffix)
+		if err != nil {
+			return nil, "", APIKey{}, err
+		}
+		a, err := scanAccount(app.db.QueryRowContext(ctx, `
+			INSERT INTO accounts (id, name, balance, session_id) VALUES ($1, $2, $3, $4)
+			RETURNING `+accountColumns,
+			id, sample.name, sample.balance, session.ID))
+		if err != nil {
+			return nil, "", APIKey{}, err
+		}
+		accounts = append(accounts, a)
+	}
+
+	key, id := newAPIKey()
+	record := APIKey{
+		ID:        id,
+		Owner:     "tenant:" + session.Name,
+		Scopes:    scopes,
+		SessionID: session.ID,
+		CreatedBy: actor,
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+	}
+	if err := app.storeAPIKey(ctx, record, key, ""); err != nil {
+		return nil, "", APIKey{}, err
+	}
+	return accounts, key, record, nil
+}
+
+// tenantAccount maps a seeded customer account (ACC-1000 to ACC-1009) onto
+// one of the tenant's accounts. Merchant accounts stay as they are and act
+// as external counterparties.
+func tenantAccount(accounts []Account, seeded string) string {
+	if !strings.HasPrefix(seeded, "ACC-100") || len(seeded) != len("ACC-1000") {
+		return seeded
+	}
+	return accounts[int(seeded[len(seeded)-1]-'0')%len(accounts)].ID
+}
+
+// requestBaseURL is the scheme and host the client reached this server on,
+// honouring a TLS-terminating proxy's X-Forwarded-Proto.
+func requestBaseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host
+}
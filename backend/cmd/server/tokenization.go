@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+const tokenPrefix = "tok_"
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx.
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// TokenVault swaps account identifiers for random tokens. Each value gets
+// one token, found again through a keyed fingerprint, so tokens still group
+// and filter like the values they replace. The values themselves are kept
+// only AES-GCM encrypted, under one of several keys: each entry records the
+// ID of the key that sealed it, so keys can be rotated without losing any.
+type TokenVault struct {
+	db     *sql.DB
+	keys   map[string]cipher.AEAD
+	macKey []byte
+
+	mu     sync.Mutex
+	active string
+}
+
+func (app *App) initTokenVault() error {
+	if !app.config.TokenizationEnabled {
+		return nil
+	}
+	secrets, err := parseVaultKeys(app.config.TokenVaultKey, app.config.TokenVaultKeys)
+	if err != nil {
+		return err
+	}
+	keys := make(map[string]cipher.AEAD, len(secrets))
+	for id, secret := range secrets {
+		encKey := sha256.Sum256([]byte("enc|" + secret))
+		block, err := aes.NewCipher(encKey[:])
+		if err != nil {
+			return err
+		}
+		if keys[id], err = cipher.NewGCM(block); err != nil {
+			return err
+		}
+	}
+	// Fingerprints must stay stable for tokens to be found again, so they
+	// are always keyed by TOKEN_VAULT_KEY.
+	macKey := sha256.Sum256([]byte("mac|" + app.config.TokenVaultKey))
+	app.vault = &TokenVault{db: app.db, keys: keys, macKey: macKey[:], active: vaultPrimaryKey}
+	if err := app.vault.loadActiveKey(context.Background()); err != nil {
+		app.log("warn", "Failed to load the active vault key", map[string]interface{}{"error": err.Error()})
+	}
+	return nil
+}
+
+func isToken(value string) bool {
+	return strings.HasPrefix(value, tokenPrefix)
+}
+
+func (v *TokenVault) fingerprint(value string) string {
+	mac := hmac.New(sha256.New, v.macKey)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Tokenize returns the token for value, minting one on first sight. Values
+// that already are tokens pass through, so re-tokenizing is harmless.
+func (v *TokenVault) Tokenize(ctx context.Context, db queryRower, value string) (string, error) {
+	if v == nil || value == "" || isToken(value) {
+		return value, nil
+	}
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	keyID, sealed, err := v.seal(value)
+	if err != nil {
+		return "", err
+	}
+
+	// The no-op update makes RETURNING yield the existing token on conflict.
+	var token string
+	err = db.QueryRowContext(ctx, `
+		INSERT INTO token_vault (token, fingerprint, ciphertext, key_id) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (fingerprint) DO UPDATE SET fingerprint = EXCLUDED.fingerprint
+		RETURNING token
+	`, tokenPrefix+base64.RawURLEncoding.EncodeToString(raw), v.fingerprint(value), sealed, keyID).Scan(&token)
+	if err != nil {
+		return "", fmt.Errorf("failed to tokenize: %w", err)
+	}
+	return token, nil
+}
+
+// Resolve maps a caller-supplied identifier to what is stored, without
+// minting: tokens pass through, known values become their token, and
+// unknown values come back unchanged (they can't match anything stored).
+func (v *TokenVault) Resolve(ctx context.Context, value string) (string, error) {
+	if v == nil || value == "" || isToken(value) {
+		return value, nil
+	}
+	var token string
+	err := v.db.QueryRowContext(ctx, `SELECT token FROM token_vault WHERE fingerprint = $1`, v.fingerprint(value)).Scan(&token)
+	if err == sql.ErrNoRows {
+		return value, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Detokenize returns the original value, or "" for unknown tokens.
+func (v *TokenVault) Detokenize(ctx context.Context, token string) (string, error) {
+	var keyID string
+	var sealed []byte
+	err := v.db.QueryRowContext(ctx, `SELECT key_id, ciphertext FROM token_vault WHERE token = $1`, token).Scan(&keyID, &sealed)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	plain, err := v.open(keyID, sealed)
+	if err != nil {
+		return "", fmt.Errorf("vault entry for %s: %w", token, err)
+	}
+	return string(plain), nil
+}
+
+// seal encrypts value under the active key and returns that key's ID.
+func (v *TokenVault) seal(value string) (string, []byte, error) {
+	keyID := v.activeKey()
+	aead := v.keys[keyID]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+	return keyID, aead.Seal(nonce, nonce, []byte(value), nil), nil
+}
+
+// open decrypts what seal returned for keyID.
+func (v *TokenVault) open(keyID string, sealed []byte) ([]byte, error) {
+	aead, ok := v.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("sealed with key %s, which is not in TOKEN_VAULT_KEYS", keyID)
+	}
+	n := aead.NonceSize()
+	if len(sealed) < n {
+		return nil, fmt.Errorf("ciphertext is corrupt")
+	}
+	plain, err := aead.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("does not decrypt with key %s: %w", keyID, err)
+	}
+	return plain, nil
+}
+
+// tokenizeTransaction replaces the account identifiers on txn inside tx.
+// With tokenization on but no vault (the database was down at startup) the
+// write fails rather than storing plaintext.
+func (app *App) tokenizeTransaction(ctx context.Context, tx *sql.Tx, txn *Transaction) error {
+	if app.config.TokenizationEnabled && app.vault == nil {
+		return fmt.Errorf("token vault is not initialized")
+	}
+	var err error
+	if txn.FromAccount, err = app.vault.Tokenize(ctx, tx, txn.FromAccount); err != nil {
+		return err
+	}
+	txn.ToAccount, err = app.vault.Tokenize(ctx, tx, txn.ToAccount)
+	return err
+}
+
+// resolveParam resolves an account identifier taken from the URL, answering
+// the request itself when the vault can't be read.
+func (app *App) resolveParam(c *gin.Context, name string) (string, bool) {
+	id, err := app.vault.Resolve(c.Request.Context(), c.Param(name))
+	if err != nil {
+		app.logCtx(c.Request.Context(), "error", "Token lookup failed", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Vault error"})
+		return "", false
+	}
+	return id, true
+}
+
+// requireDetokenize admits only callers explicitly granted detokenization:
+// OAuth clients with the tokens:detokenize scope or operators with the
+// detokenize role. The admin token alone is not enough.
+func requireDetokenize() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p := principalFrom(c)
+		if p == nil || !(p.HasScope("tokens:detokenize") || p.HasRole("detokenize")) {
+			c.JSON(http.StatusForbidden, gin.H{"error": `Scope "tokens:detokenize" required`})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func (app *App) detokenizeHandler(c *gin.Context) {
+	var req struct {
+		Tokens []string `json:"tokens" binding:"required,min=1,max=100"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if app.vault == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tokenization is not enabled"})
+		return
+	}
+
+	values := make(map[string]string, len(req.Tokens))
+	for _, token := range req.Tokens {
+		value, err := app.vault.Detokenize(c.Request.Context(), token)
+		if err != nil {
+			app.logCtx(c.Request.Context(), "error", "Detokenization failed", map[string]interface{}{"token": token, "error": err.Error()})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Vault error"})
+			return
+		}
+		if value != "" {
+			values[token] = value
+		}
+	}
+
+	p := principalFrom(c)
+	app.eventCtx(c.Request.Context(), "warn", EventTokensDetokenized, p.Subject, "Tokens detokenized", map[string]interface{}{
+		"source":   p.Source,
+		"tokens":   req.Tokens,
+		"resolved": len(values),
+	})
+	c.JSON(http.StatusOK, gin.H{"values": values})
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// vaultPrimaryKey is the ID of TOKEN_VAULT_KEY among the vault's keys, and
+// the key of every entry stored before keys had IDs.
+const vaultPrimaryKey = "1"
+
+// vaultReencryptBatch is how many vault entries one database transaction
+// re-encrypts.
+const vaultReencryptBatch = 500
+
+// parseVaultKeys returns the vault's keys by ID: TOKEN_VAULT_KEY as key 1
+// and the id=key pairs of TOKEN_VAULT_KEYS.
+func parseVaultKeys(primary, spec string) (map[string]string, error) {
+	keys := map[string]string{vaultPrimaryKey: primary}
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		id, key, ok := strings.Cut(pair, "=")
+		id = strings.TrimSpace(id)
+		switch {
+		case !ok || id == "":
+			return nil, fmt.Errorf("entries must be id=key")
+		case len(id) > 32:
+			return nil, fmt.Errorf("key ID %q is longer than 32 characters", id)
+		case id == vaultPrimaryKey:
+			return nil, fmt.Errorf("key ID %s is TOKEN_VAULT_KEY", vaultPrimaryKey)
+		case keys[id] != "":
+			return nil, fmt.Errorf("key ID %s is given twice", id)
+		case len(key) < 16:
+			return nil, fmt.Errorf("key %s must be at least 16 characters", id)
+		}
+		keys[id] = key
+	}
+	return keys, nil
+}
+
+// activeKey is the ID of the key new entries are sealed with.
+func (v *TokenVault) activeKey() string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.active
+}
+
+// loadActiveKey reads which key new entries are sealed with: the one last
+// activated through the rotation endpoint, or key 1. It is shared by every
+// instance through token_vault_keys. A key this instance doesn't have is an
+// error, and the one in use stays active.
+func (v *TokenVault) loadActiveKey(ctx context.Context) error {
+	var id string
+	err := v.db.QueryRowContext(ctx, `SELECT key_id FROM token_vault_keys ORDER BY activated_at DESC LIMIT 1`).Scan(&id)
+	if err == sql.ErrNoRows {
+		id, err = vaultPrimaryKey, nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, ok := v.keys[id]; !ok {
+		return fmt.Errorf("active vault key %s is not in TOKEN_VAULT_KEYS", id)
+	}
+	v.mu.Lock()
+	v.active = id
+	v.mu.Unlock()
+	return nil
+}
+
+// startVaultReencryption re-encrypts, every minute, vault entries sealed
+// with a key other than the active one. It also picks up rotations made
+// through other instances.
+func (app *App) startVaultReencryption() {
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			if app.vault == nil {
+				continue
+			}
+			ctx := context.Background()
+			if err := app.vault.loadActiveKey(ctx); err != nil {
+				app.log("warn", "Failed to load the active vault key", map[string]interface{}{"error": err.Error()})
+				continue
+			}
+			if _, err := app.reencryptVault(ctx); err != nil {
+				app.log("warn", "Failed to re-encrypt vault entries", map[string]interface{}{"error": err.Error()})
+			}
+		}
+	}()
+}
+
+// reencryptVault seals every entry not under the active key again with it,
+// and returns how many it re-encrypted. Tokens and fingerprints don't
+// change. Rows another instance is re-encrypting are left to it.
+func (app *App) reencryptVault(ctx context.Context) (int, error) {
+	active := app.vault.activeKey()
+	total := 0
+	for {
+		n, err := app.reencryptVaultBatch(ctx, active)
+		total += n
+		if err != nil || n < vaultReencryptBatch {
+			if total > 0 {
+				app.eventCtx(ctx, "info", EventTokensReencrypted, active, "Vault entries re-encrypted", map[string]interface{}{"entries": total})
+			}
+			return total, err
+		}
+	}
+}
+
+func (app *App) reencryptVaultBatch(ctx context.Context, active string) (int, error) {
+	tx, err := app.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.QueryContext(ctx, `
+		SELECT token, key_id, ciphertext FROM token_vault
+		WHERE key_id <> $1
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	`, active, vaultReencryptBatch)
+	if err != nil {
+		return 0, err
+	}
+	type entry struct {
+		token, keyID string
+		sealed       []byte
+	}
+	var entries []entry
+	for rows.Next() {
+		var e entry
+		if err := rows.Scan(&e.token, &e.keyID, &e.sealed); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	for _, e := range entries {
+		plain, err := app.vault.open(e.keyID, e.sealed)
+		if err != nil {
+			return 0, fmt.Errorf("vault entry for %s: %w", e.token, err)
+		}
+		keyID, sealed, err := app.vault.seal(string(plain))
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE token_vault SET key_id = $2, ciphertext = $3 WHERE token = $1`, e.token, keyID, sealed); err != nil {
+			return 0, err
+		}
+	}
+	return len(entries), tx.Commit()
+}
+
+// VaultKey is one of the vault's keys and how many entries it seals.
+type VaultKey struct {
+	ID         string `json:"id"`
+	Active     bool   `json:"active"`
+	Configured bool   `json:"configured"`
+	Entries    int64  `json:"entries"`
+}
+
+// vaultKeys lists the configured keys, and any key entries are still sealed
+// with that this instance doesn't have, by ID.
+func (app *App) vaultKeys(ctx context.Context) ([]VaultKey, error) {
+	counts := map[string]int64{}
+	rows, err := app.db.QueryContext(ctx, `SELECT key_id, COUNT(*) FROM token_vault GROUP BY key_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var n int64
+		if err := rows.Scan(&id, &n); err != nil {
+			return nil, err
+		}
+		counts[id] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	ids := map[string]bool{}
+	for id := range app.vault.keys {
+		ids[id] = true
+	}
+	for id := range counts {
+		ids[id] = true
+	}
+	active := app.vault.activeKey()
+	keys := make([]VaultKey, 0, len(ids))
+	for id := range ids {
+		_, configured := app.vault.keys[id]
+		keys = append(keys, VaultKey{ID: id, Active: id == active, Configured: configured, Entries: counts[id]})
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys, nil
+}
+
+func (app *App) listVaultKeysHandler(c *gin.Context) {
+	if app.vault == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tokenization is not enabled"})
+		return
+	}
+	keys, err := app.vaultKeys(c.Request.Context())
+	if err != nil {
+		app.logCtx(c.Request.Context(), "error", "Failed to list vault keys", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+// rotateVaultKeyHandler makes key_id the key new vault entries are sealed
+// with, on every instance within a minute. The re-encryption job then moves
+// the existing entries over. The key must be in TOKEN_VAULT_KEYS on every
+// instance first.
+func (app *App) rotateVaultKeyHandler(c *gin.Context) {
+	var req struct {
+		KeyID string `json:"key_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if app.vault == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tokenization is not enabled"})
+		return
+	}
+	if _, ok := app.vault.keys[req.KeyID]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Key %s is not in TOKEN_VAULT_KEYS", req.KeyID)})
+		return
+	}
+	ctx := c.Request.Context()
+	previous := app.vault.activeKey()
+	if _, err := app.db.ExecContext(ctx, `INSERT INTO token_vault_keys (key_id, activated_by) VALUES ($1, $2)`, req.KeyID, adminActor(c)); err != nil {
+		app.logCtx(ctx, "error", "Failed to rotate the vault key", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	app.vault.mu.Lock()
+	app.vault.active = req.KeyID
+	app.vault.mu.Unlock()
+
+	app.eventCtx(ctx, "warn", EventTokensKeyRotated, req.KeyID, "Vault key rotated", map[string]interface{}{
+		"previous": previous,
+		"actor":    adminActor(c),
+	})
+	keys, err := app.vaultKeys(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
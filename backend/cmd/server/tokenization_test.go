@@ -0,0 +1,75 @@
+package main
+
+import "testing"
+
+func TestParseVaultKeys(t *testing.T) {
+	const primary = "primary-key-0123456789"
+	tests := []struct {
+		name string
+		spec string
+		ids  []string
+		ok   bool
+	}{
+		{"primary only", "", []string{"1"}, true},
+		{"further keys", "2=second-key-0123456789, 2024q3 = third-key-0123456789", []string{"1", "2", "2024q3"}, true},
+		{"no key", "2", nil, false},
+		{"short key", "2=short", nil, false},
+		{"reuses key 1", "1=other-key-0123456789", nil, false},
+		{"duplicate", "2=second-key-0123456789,2=third-key-0123456789", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := parseVaultKeys(primary, tt.spec)
+			if (err == nil) != tt.ok {
+				t.Fatalf("err = %v, want ok %v", err, tt.ok)
+			}
+			if len(keys) != len(tt.ids) {
+				t.Fatalf("keys %v, want %v", keys, tt.ids)
+			}
+			for _, id := range tt.ids {
+				if keys[id] == "" {
+					t.Errorf("key %s missing", id)
+				}
+			}
+		})
+	}
+}
+
+// Entries sealed before a rotation still open, under the key that sealed
+// them, and new ones are sealed with the active key.
+func TestVaultSealsWithActiveKey(t *testing.T) {
+	app := newTestApp(t, func(c *Config) {
+		c.TokenizationEnabled = true
+		c.TokenVaultKey = "primary-key-0123456789"
+		c.TokenVaultKeys = "2=second-key-0123456789"
+	})
+	app.db = newFakeLedger().open(t)
+	if err := app.initTokenVault(); err != nil {
+		t.Fatal(err)
+	}
+	v := app.vault
+
+	oldKey, old, err := v.seal("ACC-1001")
+	if err != nil || oldKey != "1" {
+		t.Fatalf("sealed with key %q, err %v; want key 1", oldKey, err)
+	}
+	v.active = "2"
+	newKey, sealed, err := v.seal("ACC-1001")
+	if err != nil || newKey != "2" {
+		t.Fatalf("sealed with key %q, err %v; want key 2", newKey, err)
+	}
+	for _, e := range []struct {
+		keyID  string
+		sealed []byte
+	}{{oldKey, old}, {newKey, sealed}} {
+		if plain, err := v.open(e.keyID, e.sealed); err != nil || string(plain) != "ACC-1001" {
+			t.Errorf("key %s opened %q, err %v", e.keyID, plain, err)
+		}
+	}
+	if _, err := v.open("2", old); err == nil {
+		t.Error("opened an entry with the wrong key")
+	}
+	if _, err := v.open("3", old); err == nil {
+		t.Error("opened an entry with an unknown key")
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Pings go out often enough to keep an idle feed under the frontend nginx's
+// 30s proxy_read_timeout.
+const (
+	feedWriteWait  = 10 * time.Second
+	feedPongWait   = 25 * time.Second
+	feedPingPeriod = feedPongWait * 9 / 10
+)
+
+var (
+	feedClients = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "payflow_ws_clients",
+		Help: "Connected WebSocket transaction feed clients",
+	})
+	feedDroppedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "payflow_ws_messages_dropped_total",
+		Help: "Feed messages dropped because a client's send buffer was full",
+	})
+)
+
+// The API allows any origin through CORS, and the feed follows suit.
+var feedUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+	CheckOrigin:     func(*http.Request) bool { return true },
+}
+
+// FeedMessage is one message on the transaction feed. Type is
+// transaction.created (with Transaction), transaction.status_changed (with
+// TransactionID and Status) or resync, which means messages were dropped and
+// the client should refetch.
+type FeedMessage struct {
+	Type          string       `json:"type"`
+	Transaction   *Transaction `json:"transaction,omitempty"`
+	TransactionID string       `json:"transaction_id,omitempty"`
+	Status        string       `json:"status,omitempty"`
+}
+
+// TransactionFeed fans new transactions and status changes out to WebSocket
+// clients. Each client has its own send buffer; publishing never blocks. A
+// client whose buffer is full misses messages and gets a resync once it has
+// caught up. Clients only see their own demo session's transactions, and
+// only those written on this instance.
+type TransactionFeed struct {
+	mu      sync.RWMutex
+	clients map[*feedClient]struct{}
+	max     int
+	buffer  int
+}
+
+type feedClient struct {
+	session string
+	send    chan []byte
+	lagged  atomic.Bool
+}
+
+func newTransactionFeed(max, buffer int) *TransactionFeed {
+	return &TransactionFeed{clients: map[*feedClient]struct{}{}, max: max, buffer: buffer}
+}
+
+// subscribe adds a client for session, or reports false at WS_MAX_CLIENTS.
+func (f *TransactionFeed) subscribe(session string) (*feedClient, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.clients) >= f.max {
+		return nil, false
+	}
+	cl := &feedClient{session: session, send: make(chan []byte, f.buffer)}
+	f.clients[cl] = struct{}{}
+	feedClients.Set(float64(len(f.clients)))
+	return cl, true
+}
+
+// unsubscribe removes cl and closes its send channel. It may be called more
+// than once.
+func (f *TransactionFeed) unsubscribe(cl *feedClient) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.clients[cl]; !ok {
+		return
+	}
+	delete(f.clients, cl)
+	close(cl.send)
+	feedClients.Set(float64(len(f.clients)))
+}
+
+// Close disconnects every client, telling it the server is going away.
+func (f *TransactionFeed) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for cl := range f.clients {
+		delete(f.clients, cl)
+		close(cl.send)
+	}
+	feedClients.Set(0)
+}
+
+func (f *TransactionFeed) publish(session string, msg FeedMessage) {
+	if f == nil {
+		return
+	}
+	raw, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for cl := range f.clients {
+		if cl.session != session {
+			continue
+		}
+		select {
+		case cl.send <- raw:
+		default:
+			cl.lagged.Store(true)
+			feedDroppedTotal.Inc()
+		}
+	}
+}
+
+// PublishCreated announces a transaction that was just written.
+func (f *TransactionFeed) PublishCreated(txn Transaction) {
+	session := txn.SessionID
+	txn.SessionID = ""
+	f.publish(session, FeedMessage{Type: "transaction.created", Transaction: &txn})
+}
+
+// PublishStatus announces that a transaction's status changed.
+func (f *TransactionFeed) PublishStatus(session, id, status string) {
+	f.publish(session, FeedMessage{Type: "transaction.status_changed", TransactionID: id, Status: status})
+}
+
+// transactionFeedHandler upgrades to a WebSocket and streams the caller's
+// demo session feed until either side closes. Anything the client sends is
+// ignored.
+func (app *App) transactionFeedHandler(c *gin.Context) {
+	cl, ok := app.feed.subscribe(sessionID(c))
+	if !ok {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Too many feed connections"})
+		return
+	}
+	conn, err := feedUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has already answered with an HTTP error.
+		app.feed.unsubscribe(cl)
+		return
+	}
+	app.debug(c.Request.Context(), "Transaction feed connected", map[string]interface{}{"session_id": cl.session})
+
+	go func() {
+		defer app.feed.unsubscribe(cl)
+		conn.SetReadLimit(512)
+		conn.SetReadDeadline(time.Now().Add(feedPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(feedPongWait))
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	app.writeFeed(conn, cl)
+}
+
+// writeFeed sends cl's messages and keepalive pings until its send channel
+// is closed or a write fails. After falling behind it sends resync once the
+// buffer has drained.
+func (app *App) writeFeed(conn *websocket.Conn, cl *feedClient) {
+	ticker := time.NewTicker(feedPingPeriod)
+	defer func() {
+		ticker.Stop()
+		conn.Close()
+	}()
+	resync, _ := json.Marshal(FeedMessage{Type: "resync"})
+	for {
+		select {
+		case msg, ok := <-cl.send:
+			conn.SetWriteDeadline(time.Now().Add(feedWriteWait))
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""))
+				return
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return
+			}
+			if len(cl.send) == 0 && cl.lagged.CompareAndSwap(true, false) {
+				if err := conn.WriteMessage(websocket.TextMessage, resync); err != nil {
+					return
+				}
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(feedWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/infrasage/payflow/internal/store"
+)
+
+// newListTestApp serves transactions from memory: ten live ones a minute
+// apart, every third one failed, and one belonging to a demo session.
+func newListTestApp(t *testing.T) (*App, http.Handler) {
+	app := newTestApp(t, nil)
+	base := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	mem := store.NewMemory()
+	for i := 0; i < 10; i++ {
+		status := "success"
+		if i%3 == 0 {
+			status = "failed"
+		}
+		mem.Add(Transaction{
+			ID:          fmt.Sprintf("txn-%02d", i),
+			FromAccount: fmt.Sprintf("ACC-100%d", i%2),
+			ToAccount:   "MER-1",
+			Amount:      float64(10 * (i + 1)),
+			Status:      status,
+			CreatedAt:   base.Add(time.Duration(i) * time.Minute),
+		})
+	}
+	mem.Add(Transaction{ID: "txn-session", FromAccount: "ACC-1000", ToAccount: "MER-1", Amount: 1, Status: "success", CreatedAt: base, SessionID: "s1"})
+	app.transactions = mem
+	return app, app.newRouter()
+}
+
+func listTransactions(t *testing.T, h http.Handler, query url.Values) TransactionPage {
+	t.Helper()
+	w := serve(h, http.MethodGet, "/api/transactions?"+query.Encode(), nil, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /api/transactions?%s = %d %s", query.Encode(), w.Code, w.Body)
+	}
+	var page TransactionPage
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	return page
+}
+
+func pageIDs(page TransactionPage) []string {
+	ids := make([]string, len(page.Data))
+	for i, t := range page.Data {
+		ids[i] = t.ID
+	}
+	return ids
+}
+
+func TestListTransactionsFromMemory(t *testing.T) {
+	_, h := newListTestApp(t)
+	tests := []struct {
+		name  string
+		query url.Values
+		total int
+		ids   []string
+	}{
+		{"newest first", url.Values{"limit": {"3"}}, 10, []string{"txn-09", "txn-08", "txn-07"}},
+		{"offset", url.Values{"limit": {"2"}, "offset": {"8"}}, 10, []string{"txn-01", "txn-00"}},
+		{"status", url.Values{"status": {"failed"}}, 4, []string{"txn-09", "txn-06", "txn-03", "txn-00"}},
+		{"status list", url.Values{"status": {"failed,success"}, "limit": {"1"}}, 10, []string{"txn-09"}},
+		{"from account", url.Values{"from_account": {"ACC-1001"}, "limit": {"2"}}, 5, []string{"txn-09", "txn-07"}},
+		{"time range", url.Values{"since": {"2026-01-02T12:02:00Z"}, "until": {"2026-01-02T12:04:00Z"}}, 2, []string{"txn-03", "txn-02"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := listTransactions(t, h, tt.query)
+			if page.Total != tt.total || fmt.Sprint(pageIDs(page)) != fmt.Sprint(tt.ids) {
+				t.Errorf("got total %d %v, want %d %v", page.Total, pageIDs(page), tt.total, tt.ids)
+			}
+		})
+	}
+}
+
+func TestListTransactionsCursor(t *testing.T) {
+	_, h := newListTestApp(t)
+	query := url.Values{"limit": {"3"}, "status": {"success"}}
+	var seen []string
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("cursor never ran out")
+		}
+		page := listTransactions(t, h, query)
+		seen = append(seen, pageIDs(page)...)
+		if page.Total != 6 {
+			t.Errorf("total %d, want 6 on every page", page.Total)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		query = url.Values{"limit": {"3"}, "cursor": {page.NextCursor}}
+	}
+	want := []string{"txn-08", "txn-07", "txn-05", "txn-04", "txn-02", "txn-01"}
+	if fmt.Sprint(seen) != fmt.Sprint(want) {
+		t.Errorf("pages gave %v, want %v", seen, want)
+	}
+
+	w := serve(h, http.MethodGet, "/api/transactions?status=failed&cursor="+url.QueryEscape(listTransactions(t, h, url.Values{"limit": {"1"}, "status": {"success"}}).NextCursor), nil, nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("cursor with changed filters = %d, want 400", w.Code)
+	}
+}
+
+func TestListTransactionsSparseFields(t *testing.T) {
+	_, h := newListTestApp(t)
+	w := serve(h, http.MethodGet, "/api/transactions?limit=1&fields=id,amount", nil, nil)
+	var page struct {
+		Data  []map[string]interface{} `json:"data"`
+		Total int                      `json:"total"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &page)
+	if len(page.Data) != 1 || len(page.Data[0]) != 2 || page.Data[0]["id"] != "txn-09" || page.Total != 10 {
+		t.Errorf("sparse page = %s", w.Body)
+	}
+}
+
+func TestListTransactionsRejectsEmptyPage(t *testing.T) {
+	_, h := newListTestApp(t)
+	for _, limit := range []string{"0", "-1"} {
+		if w := serve(h, http.MethodGet, "/api/transactions?limit="+limit, nil, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("limit=%s = %d %s, want 400", limit, w.Code, w.Body)
+		}
+	}
+}
+
+func TestCreateTransactionAmountPrecision(t *testing.T) {
+	h := newTestApp(t, nil).newRouter()
+	tests := []struct {
+		amount float64
+		want   int
+	}{
+		{0.004, http.StatusBadRequest},
+		{10.005, http.StatusBadRequest},
+		{0, http.StatusBadRequest},
+		// Past validation, the payment fails for want of a database.
+		{0.01, http.StatusServiceUnavailable},
+		{19.99, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		body := map[string]interface{}{"from_account": "ACC-1", "to_account": "MER-1", "amount": tt.amount}
+		if w := serve(h, http.MethodPost, "/api/transactions", body, nil); w.Code != tt.want {
+			t.Errorf("amount %v: %d %s, want %d", tt.amount, w.Code, w.Body, tt.want)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"syscall"
+)
+
+// errPrivateTarget refuses a webhook URL, or a delivery connection, that
+// points into the network PayFlow runs in rather than at a subscriber.
+var errPrivateTarget = errors.New("webhook URLs must not point at loopback, link-local, private or unspecified addresses")
+
+// lookupWebhookHost resolves webhook hosts; tests replace it.
+var lookupWebhookHost = net.DefaultResolver.LookupIPAddr
+
+// privateTarget reports whether ip is loopback, link-local, private (RFC
+// 1918 or IPv6 unique local) or unspecified. IPv4-mapped IPv6 addresses are
+// judged by their IPv4 address.
+func privateTarget(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsPrivate() || ip.IsUnspecified()
+}
+
+// checkWebhookURL checks that raw is an absolute http or https URL whose
+// host resolves only to public addresses. With WEBHOOK_ALLOW_PRIVATE_URLS
+// any host that resolves is accepted.
+func (app *App) checkWebhookURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	addrs, err := lookupWebhookHost(ctx, u.Hostname())
+	if err != nil || len(addrs) == 0 {
+		return fmt.Errorf("url host %s does not resolve", u.Hostname())
+	}
+	if app.config.WebhookAllowPrivateURLs {
+		return nil
+	}
+	for _, a := range addrs {
+		if privateTarget(a.IP) {
+			return errPrivateTarget
+		}
+	}
+	return nil
+}
+
+// publicDialControl refuses connections to private targets. Deliveries dial
+// through it, so a host that resolved to a public address at registration
+// and to an internal one later is still refused.
+func publicDialControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || privateTarget(ip) {
+		return errPrivateTarget
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPrivateTarget(t *testing.T) {
+	tests := []struct {
+		ip      string
+		private bool
+	}{
+		{"127.0.0.1", true},
+		{"127.1.2.3", true},
+		{"::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"169.254.169.254", true},
+		{"fe80::1", true},
+		{"224.0.0.251", true},
+		{"ff02::1", true},
+		{"10.0.0.5", true},
+		{"172.16.0.1", true},
+		{"172.31.255.255", true},
+		{"192.168.1.10", true},
+		{"fd12:3456::1", true},
+		{"0.0.0.0", true},
+		{"::", true},
+		{"93.184.216.34", false},
+		{"172.32.0.1", false},
+		{"2606:4700::1111", false},
+	}
+	for _, tt := range tests {
+		if got := privateTarget(net.ParseIP(tt.ip)); got != tt.private {
+			t.Errorf("privateTarget(%s) = %v, want %v", tt.ip, got, tt.private)
+		}
+	}
+}
+
+func TestCheckWebhookURL(t *testing.T) {
+	hosts := map[string][]string{
+		"merchant.example": {"93.184.216.34"},
+		"internal.example": {"10.0.0.5"},
+		"mixed.example":    {"93.184.216.34", "127.0.0.1"},
+	}
+	lookup := lookupWebhookHost
+	t.Cleanup(func() { lookupWebhookHost = lookup })
+	lookupWebhookHost = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		if ip := net.ParseIP(host); ip != nil {
+			return []net.IPAddr{{IP: ip}}, nil
+		}
+		var addrs []net.IPAddr
+		for _, a := range hosts[host] {
+			addrs = append(addrs, net.IPAddr{IP: net.ParseIP(a)})
+		}
+		return addrs, nil
+	}
+
+	tests := []struct {
+		name         string
+		url          string
+		allowPrivate bool
+		ok           bool
+	}{
+		{"public host", "https://merchant.example/hooks", false, true},
+		{"public address", "http://93.184.216.34:8080/hooks", false, true},
+		{"loopback", "http://127.0.0.1:8080/hooks", false, false},
+		{"ipv6 loopback", "http://[::1]/hooks", false, false},
+		{"cloud metadata", "http://169.254.169.254/latest/meta-data/", false, false},
+		{"private", "http://192.168.1.10/hooks", false, false},
+		{"unspecified", "http://0.0.0.0:8080/hooks", false, false},
+		{"host resolving to a private address", "https://internal.example/hooks", false, false},
+		{"host with one private address", "https://mixed.example/hooks", false, false},
+		{"unresolvable host", "https://nowhere.example/hooks", false, false},
+		{"not http", "ftp://merchant.example/hooks", false, false},
+		{"relative", "/hooks", false, false},
+		{"private allowed", "http://127.0.0.1:8080/hooks", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t, func(c *Config) { c.WebhookAllowPrivateURLs = tt.allowPrivate })
+			if err := app.checkWebhookURL(context.Background(), tt.url); (err == nil) != tt.ok {
+				t.Errorf("checkWebhookURL(%s) = %v, want ok %v", tt.url, err, tt.ok)
+			}
+		})
+	}
+
+	app := newTestApp(t, nil)
+	w := serve(app.newRouter(), http.MethodPost, "/api/webhooks", map[string]string{"url": "http://169.254.169.254/latest/meta-data/"}, nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("registering a metadata URL: %d %s, want 400", w.Code, w.Body)
+	}
+}
+
+// A host can resolve to a public address at registration and an internal
+// one at delivery; the dialer refuses it then.
+func TestWebhookDeliveryRefusesPrivateAddresses(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer receiver.Close()
+
+	app := newTestApp(t, nil)
+	client := app.newOutboundClient("webhooks", outboundOptions{PublicOnly: true})
+	if _, err := client.Post(receiver.URL, "application/json", nil); !errors.Is(err, errPrivateTarget) {
+		t.Errorf("delivery to %s: %v, want %v", receiver.URL, err, errPrivateTarget)
+	}
+	if err := publicDialControl("tcp", "93.184.216.34:443", nil); err != nil {
+		t.Errorf("dialing a public address: %v", err)
+	}
+
+	app = newTestApp(t, nil)
+	resp, err := app.newOutboundClient("webhooks", outboundOptions{}).Post(receiver.URL, "application/json", nil)
+	if err != nil {
+		t.Fatalf("delivery without PublicOnly: %v", err)
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,864 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	apihttp "github.com/infrasage/payflow/internal/http"
+	"github.com/infrasage/payflow/sdk"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	webhookCacheTTL     = 5 * time.Second
+	webhookPollInterval = 2 * time.Second
+	webhookClaimBatch   = 20
+	// webhookLease is how long a claimed delivery is reserved for the
+	// instance sending it. It outlasts WEBHOOK_TIMEOUT_SEC, so a delivery
+	// is only claimed again if that instance died mid-attempt.
+	webhookLease = 2 * time.Minute
+	// defaultWebhookBatchFlushMs is how long a batched webhook's oldest
+	// due event waits for the batch to fill when flush_interval_ms isn't
+	// given.
+	defaultWebhookBatchFlushMs = 5000
+)
+
+// Webhook event types subscribers can ask for. Like the domain event names
+// they are a contract: add new ones, never rename them.
+const (
+	WebhookTransactionCreated = "transaction.created"
+	WebhookTransactionBlocked = "transaction.blocked"
+	WebhookFraudAlert         = "fraud.alert"
+)
+
+var webhookEventTypes = []string{WebhookTransactionCreated, WebhookTransactionBlocked, WebhookFraudAlert}
+
+var webhookDeliveriesTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "payflow_webhook_deliveries_total",
+		Help: "Webhook delivery attempts by event type and result (delivered, retrying, failed)",
+	},
+	[]string{"event", "result"},
+)
+
+var webhookBatchesTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "payflow_webhook_batches_total",
+		Help: "Batched webhook POSTs by result (delivered, partial, failed)",
+	},
+	[]string{"result"},
+)
+
+// Webhook is a subscriber URL registered for some event types. The signing
+// secret is only returned when the webhook is created or it is rotated.
+// SecretKeyID is the version of the secret deliveries are signed with; while
+// a rotation is pending, NextSecretAt is when the next version takes over.
+type Webhook struct {
+	ID           string     `json:"id"`
+	URL          string     `json:"url"`
+	Events       []string   `json:"events"`
+	Description  string     `json:"description,omitempty"`
+	CreatedBy    string     `json:"created_by"`
+	CreatedAt    time.Time  `json:"created_at"`
+	SecretKeyID  int        `json:"secret_key_id"`
+	NextSecretAt *time.Time `json:"next_secret_at,omitempty"`
+
+	Batch *WebhookBatching `json:"batch,omitempty"`
+}
+
+// WebhookBatching has a webhook's events POSTed as arrays of up to MaxSize,
+// sent once that many are due or the oldest has been due for
+// FlushIntervalMs.
+type WebhookBatching struct {
+	MaxSize         int `json:"max_size"`
+	FlushIntervalMs int `json:"flush_interval_ms"`
+}
+
+// WebhookDelivery is one event on its way to one webhook. Status is pending
+// until it is delivered or has failed WEBHOOK_MAX_ATTEMPTS times.
+type WebhookDelivery struct {
+	ID             string     `json:"id"`
+	EventID        string     `json:"event_id"`
+	EventType      string     `json:"event_type"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at,omitempty"`
+	LastStatusCode *int       `json:"last_status_code,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+	BatchID        string     `json:"batch_id,omitempty"`
+}
+
+// WebhookEvent is the JSON body POSTed to subscribers.
+type WebhookEvent struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+type webhookTarget struct {
+	id      string
+	session string
+	events  []string
+}
+
+type webhookJob struct {
+	id        string
+	eventID   string
+	eventType string
+	payload   []byte
+	attempts  int
+	url       string
+	secret    string
+	keyID     int
+}
+
+// webhookBatch is the deliveries of one batched webhook sent in one POST.
+type webhookBatch struct {
+	id      string
+	maxSize int
+	jobs    []webhookJob
+}
+
+// WebhookDispatcher queues events for subscribers in webhook_deliveries and
+// sends them from a background loop. Every replica runs the loop; claiming
+// with SKIP LOCKED and a lease keeps each attempt on one instance, and a
+// restart loses nothing that was queued.
+type WebhookDispatcher struct {
+	app    *App
+	client *http.Client
+	wake   chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+
+	mu       sync.Mutex
+	targets  []webhookTarget
+	loadedAt time.Time
+}
+
+func newWebhookDispatcher(app *App) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		app: app,
+		// Failed deliveries are retried on the dispatcher's own schedule.
+		// A redirect counts as a failed attempt rather than sending the
+		// signed event somewhere the subscriber didn't register.
+		client: app.newOutboundClient("webhooks", outboundOptions{
+			Timeout:     time.Duration(app.config.WebhookTimeoutSec) * time.Second,
+			NoRedirects: true,
+			PublicOnly:  !app.config.WebhookAllowPrivateURLs,
+		}),
+		wake: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+func (app *App) startWebhooks() {
+	go app.webhooks.run()
+}
+
+// Close stops the delivery loop once the batch in flight has been sent.
+// Deliveries still due are picked up by another replica or after restart.
+func (d *WebhookDispatcher) Close() {
+	close(d.stop)
+	<-d.done
+}
+
+// Dispatch queues an event for every webhook of session subscribed to
+// eventType. It never fails the caller: errors are logged and the event is
+// dropped.
+func (d *WebhookDispatcher) Dispatch(ctx context.Context, eventType, session string, data interface{}) {
+	if d == nil || d.app.db == nil {
+		return
+	}
+	targets, err := d.subscribers(ctx, eventType, session)
+	if err != nil {
+		d.app.logCtx(ctx, "warn", "Failed to load webhooks", map[string]interface{}{"error": err.Error()})
+		return
+	}
+	if len(targets) == 0 {
+		return
+	}
+	event := WebhookEvent{ID: uuid.New().String(), Type: eventType, CreatedAt: time.Now().UTC(), Data: data}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		d.app.logCtx(ctx, "error", "Failed to encode webhook event", map[string]interface{}{"event_type": eventType, "error": err.Error()})
+		return
+	}
+	if !d.app.checkEventPayload(ctx, "webhook."+eventType, payload) {
+		return
+	}
+	for _, id := range targets {
+		if _, err := d.app.jobPool().ExecContext(ctx, `
+			INSERT INTO webhook_deliveries (id, webhook_id, event_id, event_type, payload, next_attempt_at)
+			VALUES ($1, $2, $3, $4, $5, NOW())
+		`, uuid.New().String(), id, event.ID, eventType, payload); err != nil {
+			d.app.logCtx(ctx, "error", "Failed to queue webhook delivery", map[string]interface{}{
+				"webhook_id": id,
+				"event_type": eventType,
+				"error":      err.Error(),
+			})
+		}
+	}
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// subscribers returns the IDs of the webhooks that want eventType. The list
+// is cached for webhookCacheTTL, so registrations on other replicas take up
+// to that long to receive events.
+func (d *WebhookDispatcher) subscribers(ctx context.Context, eventType, session string) ([]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.targets == nil || time.Since(d.loadedAt) > webhookCacheTTL {
+		rows, err := d.app.jobPool().QueryContext(ctx, `SELECT id, COALESCE(session_id, ''), events FROM webhooks`)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		targets := []webhookTarget{}
+		for rows.Next() {
+			var t webhookTarget
+			if err := rows.Scan(&t.id, &t.session, pq.Array(&t.events)); err != nil {
+				return nil, err
+			}
+			targets = append(targets, t)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		d.targets, d.loadedAt = targets, time.Now()
+	}
+	var ids []string
+	for _, t := range d.targets {
+		if t.session == session && containsString(t.events, eventType) {
+			ids = append(ids, t.id)
+		}
+	}
+	return ids, nil
+}
+
+func (d *WebhookDispatcher) invalidate() {
+	d.mu.Lock()
+	d.targets = nil
+	d.mu.Unlock()
+}
+
+func (d *WebhookDispatcher) run() {
+	defer close(d.done)
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+		if d.app.db == nil {
+			continue
+		}
+		d.activateSecrets()
+		d.drain()
+		d.drainBatches()
+	}
+}
+
+// activateSecrets switches the webhooks whose rotation grace period is over
+// to their next signing secret. Deliveries claimed from then on are signed
+// with it.
+func (d *WebhookDispatcher) activateSecrets() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rows, err := d.app.jobPool().QueryContext(ctx, `
+		UPDATE webhooks SET secret = next_secret, secret_version = secret_version + 1, next_secret = NULL, next_secret_at = NULL
+		WHERE next_secret_at <= NOW()
+		RETURNING id, secret_version
+	`)
+	if err != nil {
+		d.app.log("warn", "Failed to activate rotated webhook secrets", map[string]interface{}{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var version int
+		if err := rows.Scan(&id, &version); err != nil {
+			return
+		}
+		d.app.log("info", "Webhook signing secret activated", map[string]interface{}{"webhook_id": id, "secret_key_id": version})
+	}
+}
+
+// drain sends the due deliveries of unbatched webhooks. It keeps claiming
+// while full claims come back, so a backlog drains without waiting for the
+// next tick.
+func (d *WebhookDispatcher) drain() {
+	for {
+		jobs, err := d.claim()
+		if err != nil {
+			d.app.log("warn", "Failed to claim webhook deliveries", map[string]interface{}{"error": err.Error()})
+			return
+		}
+		var wg sync.WaitGroup
+		for _, job := range jobs {
+			wg.Add(1)
+			go func(job webhookJob) {
+				defer wg.Done()
+				d.attempt(job)
+			}(job)
+		}
+		wg.Wait()
+		if len(jobs) < webhookClaimBatch {
+			return
+		}
+	}
+}
+
+// drainBatches sends every batch that is ready, one POST per webhook at a
+// time, and goes round again while any of them was full.
+func (d *WebhookDispatcher) drainBatches() {
+	for {
+		batches, err := d.claimBatches()
+		if err != nil {
+			d.app.log("warn", "Failed to claim webhook batches", map[string]interface{}{"error": err.Error()})
+			return
+		}
+		full := false
+		var wg sync.WaitGroup
+		for _, b := range batches {
+			full = full || len(b.jobs) >= b.maxSize
+			wg.Add(1)
+			go func(b webhookBatch) {
+				defer wg.Done()
+				d.attemptBatch(b)
+			}(b)
+		}
+		wg.Wait()
+		if !full {
+			return
+		}
+	}
+}
+
+// claim leases up to webhookClaimBatch due deliveries of unbatched webhooks
+// to this instance by pushing their next attempt past webhookLease.
+func (d *WebhookDispatcher) claim() ([]webhookJob, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return d.lease(ctx, `
+		UPDATE webhook_deliveries d SET next_attempt_at = NOW() + make_interval(secs => $1)
+		FROM webhooks w
+		WHERE w.id = d.webhook_id AND d.id IN (
+			SELECT p.id FROM webhook_deliveries p
+			JOIN webhooks pw ON pw.id = p.webhook_id
+			WHERE p.status = 'pending' AND p.next_attempt_at <= NOW() AND pw.batch_max_size = 0
+			ORDER BY p.next_attempt_at
+			LIMIT $2
+			FOR UPDATE OF p SKIP LOCKED
+		)
+		RETURNING d.id, d.event_id, d.event_type, d.payload, d.attempts, w.url, w.secret, w.secret_version
+	`, webhookLease.Seconds(), webhookClaimBatch)
+}
+
+// claimBatches leases a batch for each batched webhook that has
+// batch_max_size deliveries due, or one due for its flush interval.
+func (d *WebhookDispatcher) claimBatches() ([]webhookBatch, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rows, err := d.app.jobPool().QueryContext(ctx, `
+		SELECT w.id, w.batch_max_size
+		FROM webhooks w
+		CROSS JOIN LATERAL (
+			SELECT COUNT(*) AS due, MIN(next_attempt_at) AS oldest FROM (
+				SELECT next_attempt_at FROM webhook_deliveries
+				WHERE webhook_id = w.id AND status = 'pending' AND next_attempt_at <= NOW()
+				ORDER BY next_attempt_at
+				LIMIT w.batch_max_size
+			) due
+		) p
+		WHERE w.batch_max_size > 0 AND p.due > 0
+			AND (p.due >= w.batch_max_size OR p.oldest <= NOW() - w.batch_flush_ms * INTERVAL '1 millisecond')
+	`)
+	if err != nil {
+		return nil, err
+	}
+	type ready struct {
+		webhookID string
+		maxSize   int
+	}
+	var due []ready
+	for rows.Next() {
+		var r ready
+		if err := rows.Scan(&r.webhookID, &r.maxSize); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		due = append(due, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var batches []webhookBatch
+	for _, r := range due {
+		b := webhookBatch{id: uuid.New().String(), maxSize: r.maxSize}
+		b.jobs, err = d.lease(ctx, `
+			UPDATE webhook_deliveries d SET next_attempt_at = NOW() + make_interval(secs => $1), batch_id = $4
+			FROM webhooks w
+			WHERE w.id = d.webhook_id AND d.id IN (
+				SELECT id FROM webhook_deliveries
+				WHERE webhook_id = $3 AND status = 'pending' AND next_attempt_at <= NOW()
+				ORDER BY next_attempt_at
+				LIMIT $2
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING d.id, d.event_id, d.event_type, d.payload, d.attempts, w.url, w.secret, w.secret_version
+		`, webhookLease.Seconds(), r.maxSize, r.webhookID, b.id)
+		if err != nil {
+			return batches, err
+		}
+		// Another instance may have leased them in the meantime.
+		if len(b.jobs) > 0 {
+			batches = append(batches, b)
+		}
+	}
+	return batches, nil
+}
+
+// lease runs a claiming UPDATE and returns the deliveries it leased.
+func (d *WebhookDispatcher) lease(ctx context.Context, query string, args ...interface{}) ([]webhookJob, error) {
+	rows, err := d.app.jobPool().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var jobs []webhookJob
+	for rows.Next() {
+		var j webhookJob
+		if err := rows.Scan(&j.id, &j.eventID, &j.eventType, &j.payload, &j.attempts, &j.url, &j.secret, &j.keyID); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// attempt POSTs one delivery, signed like sdk.VerifyWebhook expects, and
+// records the outcome. Any 2xx answer counts as delivered.
+func (d *WebhookDispatcher) attempt(job webhookJob) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(d.app.config.WebhookTimeoutSec)*time.Second)
+	defer cancel()
+
+	code := 0
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, job.url, bytes.NewReader(job.payload))
+	if err == nil {
+		ts := time.Now().Unix()
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "PayFlow-Webhooks/"+appVersion)
+		req.Header.Set(sdk.HeaderWebhookEvent, job.eventType)
+		req.Header.Set(sdk.HeaderWebhookDelivery, job.id)
+		req.Header.Set(sdk.HeaderTimestamp, strconv.FormatInt(ts, 10))
+		req.Header.Set(sdk.HeaderWebhookKeyID, strconv.Itoa(job.keyID))
+		req.Header.Set(sdk.HeaderSignature, sdk.Signature(job.secret, sdk.WebhookCanonicalString(job.id, ts, job.payload)))
+		var resp *http.Response
+		if resp, err = d.client.Do(req); err == nil {
+			code = resp.StatusCode
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+			if code < 200 || code >= 300 {
+				err = fmt.Errorf("subscriber responded %s", resp.Status)
+			}
+		}
+	}
+	d.record(job, code, err)
+}
+
+// attemptBatch POSTs b as a JSON array of its events, signed with the batch
+// ID in place of a delivery ID. A 2xx answer delivers every event except
+// those the receiver lists in a sdk.WebhookBatchAck; anything else fails the
+// attempt for all of them. Each delivery then retries on its own schedule.
+func (d *WebhookDispatcher) attemptBatch(b webhookBatch) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(d.app.config.WebhookTimeoutSec)*time.Second)
+	defer cancel()
+
+	payloads := make([][]byte, len(b.jobs))
+	for i, job := range b.jobs {
+		payloads[i] = job.payload
+	}
+	body := append([]byte("["), bytes.Join(payloads, []byte(","))...)
+	body = append(body, ']')
+	code := 0
+	var ack sdk.WebhookBatchAck
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.jobs[0].url, bytes.NewReader(body))
+	if err == nil {
+		ts := time.Now().Unix()
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "PayFlow-Webhooks/"+appVersion)
+		req.Header.Set(sdk.HeaderWebhookEvent, sdk.WebhookEventBatch)
+		req.Header.Set(sdk.HeaderWebhookDelivery, b.id)
+		req.Header.Set(sdk.HeaderWebhookBatchSize, strconv.Itoa(len(b.jobs)))
+		req.Header.Set(sdk.HeaderTimestamp, strconv.FormatInt(ts, 10))
+		req.Header.Set(sdk.HeaderWebhookKeyID, strconv.Itoa(b.jobs[0].keyID))
+		req.Header.Set(sdk.HeaderSignature, sdk.Signature(b.jobs[0].secret, sdk.WebhookCanonicalString(b.id, ts, body)))
+		var resp *http.Response
+		if resp, err = d.client.Do(req); err == nil {
+			code = resp.StatusCode
+			raw, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+			resp.Body.Close()
+			if code < 200 || code >= 300 {
+				err = fmt.Errorf("subscriber responded %s", resp.Status)
+			} else {
+				// An empty or unreadable answer acknowledges the whole batch.
+				json.Unmarshal(raw, &ack)
+			}
+		}
+	}
+
+	failed := 0
+	for _, job := range b.jobs {
+		jobErr := err
+		if jobErr == nil && containsString(ack.Failed, job.eventID) {
+			jobErr = fmt.Errorf("subscriber failed the event in batch %s", b.id)
+		}
+		if jobErr != nil {
+			failed++
+		}
+		d.record(job, code, jobErr)
+	}
+	switch {
+	case failed == 0:
+		webhookBatchesTotal.WithLabelValues("delivered").Inc()
+	case failed < len(b.jobs):
+		webhookBatchesTotal.WithLabelValues("partial").Inc()
+	default:
+		webhookBatchesTotal.WithLabelValues("failed").Inc()
+	}
+}
+
+func (d *WebhookDispatcher) record(job webhookJob, code int, deliveryErr error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	attempts := job.attempts + 1
+	status := sql.NullInt64{Int64: int64(code), Valid: code != 0}
+
+	var err error
+	switch {
+	case deliveryErr == nil:
+		webhookDeliveriesTotal.WithLabelValues(job.eventType, "delivered").Inc()
+		_, err = d.app.jobPool().ExecContext(ctx, `
+			UPDATE webhook_deliveries SET status = 'delivered', attempts = $2, last_status_code = $3, last_error = '',
+				next_attempt_at = NULL, delivered_at = NOW()
+			WHERE id = $1
+		`, job.id, attempts, status)
+	case attempts >= d.app.config.WebhookMaxAttempts:
+		webhookDeliveriesTotal.WithLabelValues(job.eventType, "failed").Inc()
+		_, err = d.app.jobPool().ExecContext(ctx, `
+			UPDATE webhook_deliveries SET status = 'failed', attempts = $2, last_status_code = $3, last_error = $4,
+				next_attempt_at = NULL
+			WHERE id = $1
+		`, job.id, attempts, status, deliveryErr.Error())
+		d.app.event("warn", EventWebhookDeliveryFailed, job.id, "Webhook delivery failed for good", map[string]interface{}{
+			"event_type": job.eventType,
+			"attempts":   attempts,
+			"error":      deliveryErr.Error(),
+		})
+	default:
+		webhookDeliveriesTotal.WithLabelValues(job.eventType, "retrying").Inc()
+		_, err = d.app.jobPool().ExecContext(ctx, `
+			UPDATE webhook_deliveries SET attempts = $2, last_status_code = $3, last_error = $4,
+				next_attempt_at = NOW() + make_interval(secs => $5)
+			WHERE id = $1
+		`, job.id, attempts, status, deliveryErr.Error(), retryBackoff(d.app.config, attempts).Seconds())
+		d.app.debug(ctx, "Webhook delivery will be retried", map[string]interface{}{
+			"delivery_id": job.id,
+			"attempts":    attempts,
+			"error":       deliveryErr.Error(),
+		})
+	}
+	if err != nil {
+		// The lease runs out and the delivery is attempted again.
+		d.app.log("error", "Failed to record webhook delivery", map[string]interface{}{"delivery_id": job.id, "error": err.Error()})
+	}
+}
+
+// retryBackoff is how long to wait after the given number of failed
+// attempts: WEBHOOK_BACKOFF_BASE_SEC, doubling each time up to
+// WEBHOOK_BACKOFF_MAX_SEC. Webhook deliveries and receipt emails share it.
+func retryBackoff(cfg *Config, attempts int) time.Duration {
+	wait := time.Duration(cfg.WebhookBackoffBaseSec) * time.Second
+	max := time.Duration(cfg.WebhookBackoffMaxSec) * time.Second
+	for i := 1; i < attempts && wait < max; i++ {
+		wait *= 2
+	}
+	if wait > max {
+		wait = max
+	}
+	return wait
+}
+
+// webhookPayload is a transaction as subscribers see it.
+func webhookPayload(txn Transaction) Transaction {
+	txn.SessionID = ""
+	return txn
+}
+
+// createWebhookHandler registers a webhook for the caller's demo session (or
+// live data) and returns its signing secret, which is not shown again.
+func (app *App) createWebhookHandler(c *gin.Context) {
+	var req struct {
+		URL         string   `json:"url" binding:"required"`
+		Events      []string `json:"events"`
+		Description string   `json:"description"`
+		Batch       *struct {
+			MaxSize         int  `json:"max_size"`
+			FlushIntervalMs *int `json:"flush_interval_ms"`
+		} `json:"batch"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := app.checkWebhookURL(c.Request.Context(), req.URL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Events) == 0 {
+		req.Events = webhookEventTypes
+	}
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+
+	secret := newSigningSecret()
+	hook := Webhook{
+		ID:          uuid.New().String(),
+		URL:         req.URL,
+		Events:      req.Events,
+		Description: req.Description,
+		CreatedBy:   requestActor(c),
+		CreatedAt:   time.Now().UTC().Truncate(time.Microsecond),
+		SecretKeyID: 1,
+	}
+	var batching WebhookBatching
+	if req.Batch != nil {
+		batching = WebhookBatching{MaxSize: req.Batch.MaxSize, FlushIntervalMs: defaultWebhookBatchFlushMs}
+		if req.Batch.FlushIntervalMs != nil {
+			batching.FlushIntervalMs = *req.Batch.FlushIntervalMs
+		}
+		hook.Batch = &batching
+	}
+	_, err := app.db.ExecContext(c.Request.Context(), `
+		INSERT INTO webhooks (id, url, events, description, secret, session_id, created_by, created_at, batch_max_size, batch_flush_ms)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`, hook.ID, hook.URL, pq.Array(hook.Events), hook.Description, secret, sessionArg(sessionID(c)), hook.CreatedBy, hook.CreatedAt,
+		batching.MaxSize, batching.FlushIntervalMs)
+	if err != nil {
+		app.logCtx(c.Request.Context(), "error", "Failed to register webhook", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	app.webhooks.invalidate()
+
+	app.eventCtx(c.Request.Context(), "info", EventWebhookRegistered, hook.ID, "Webhook registered", map[string]interface{}{
+		"url":    hook.URL,
+		"events": hook.Events,
+		"actor":  hook.CreatedBy,
+	})
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusCreated, gin.H{"webhook": hook, "secret": secret})
+}
+
+func (app *App) listWebhooksHandler(c *gin.Context) {
+	fields, err := apihttp.FieldsParam(c, Webhook{})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	rows, err := app.db.QueryContext(c.Request.Context(), `
+		SELECT id, url, events, description, created_by, created_at, batch_max_size, batch_flush_ms, secret_version, next_secret_at FROM webhooks
+		WHERE session_id IS NOT DISTINCT FROM $1
+		ORDER BY created_at DESC
+	`, sessionArg(sessionID(c)))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	hooks := []Webhook{}
+	for rows.Next() {
+		var h Webhook
+		var batching WebhookBatching
+		var next sql.NullTime
+		if err := rows.Scan(&h.ID, &h.URL, pq.Array(&h.Events), &h.Description, &h.CreatedBy, &h.CreatedAt, &batching.MaxSize, &batching.FlushIntervalMs, &h.SecretKeyID, &next); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if next.Valid {
+			h.NextSecretAt = &next.Time
+		}
+		if batching.MaxSize > 0 {
+			h.Batch = &batching
+		}
+		hooks = append(hooks, h)
+	}
+	c.JSON(http.StatusOK, gin.H{"webhooks": fields.Apply(hooks)})
+}
+
+// deleteWebhookHandler removes a webhook together with its delivery history.
+// Deliveries not yet sent are dropped.
+func (app *App) deleteWebhookHandler(c *gin.Context) {
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	id := c.Param("id")
+	res, err := app.db.ExecContext(c.Request.Context(), `
+		DELETE FROM webhooks WHERE id = $1 AND session_id IS NOT DISTINCT FROM $2
+	`, id, sessionArg(sessionID(c)))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+	app.webhooks.invalidate()
+
+	app.eventCtx(c.Request.Context(), "info", EventWebhookDeleted, id, "Webhook deleted", map[string]interface{}{"actor": requestActor(c)})
+	c.Status(http.StatusNoContent)
+}
+
+// rotateWebhookSecretHandler issues a webhook a new signing secret, returned
+// once like the first. Deliveries stay signed with the current one for
+// WEBHOOK_SECRET_GRACE_SEC, so the subscriber can accept both in the
+// meantime, telling them apart by sdk.HeaderWebhookKeyID. Rotating again
+// before then replaces the pending secret.
+func (app *App) rotateWebhookSecretHandler(c *gin.Context) {
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	id := c.Param("id")
+	secret := newSigningSecret()
+	var keyID int
+	var activeAt time.Time
+	err := app.db.QueryRowContext(c.Request.Context(), `
+		UPDATE webhooks SET next_secret = $3, next_secret_at = NOW() + make_interval(secs => $4)
+		WHERE id = $1 AND session_id IS NOT DISTINCT FROM $2
+		RETURNING secret_version + 1, next_secret_at
+	`, id, sessionArg(sessionID(c)), secret, app.config.WebhookSecretGraceSec).Scan(&keyID, &activeAt)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+	if err != nil {
+		app.logCtx(c.Request.Context(), "error", "Failed to rotate webhook secret", map[string]interface{}{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	app.eventCtx(c.Request.Context(), "info", EventWebhookSecretRotated, id, "Webhook secret rotated", map[string]interface{}{
+		"secret_key_id": keyID,
+		"active_at":     activeAt,
+		"actor":         requestActor(c),
+	})
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{"secret": secret, "secret_key_id": keyID, "active_at": activeAt})
+}
+
+// listWebhookDeliveriesHandler returns a webhook's most recent deliveries,
+// optionally only those with ?status=pending, delivered or failed.
+func (app *App) listWebhookDeliveriesHandler(c *gin.Context) {
+	limit, err := pageParam(c, "limit", 50, 500)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	status := c.Query("status")
+	if status != "" && status != "pending" && status != "delivered" && status != "failed" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be pending, delivered or failed"})
+		return
+	}
+	fields, err := apihttp.FieldsParam(c, WebhookDelivery{})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if app.db == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Database unavailable"})
+		return
+	}
+	ctx := c.Request.Context()
+	var exists bool
+	err = app.readPool().QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM webhooks WHERE id = $1 AND session_id IS NOT DISTINCT FROM $2)
+	`, c.Param("id"), sessionArg(sessionID(c))).Scan(&exists)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+
+	rows, err := app.readPool().QueryContext(ctx, `
+		SELECT id, event_id, event_type, status, attempts, next_attempt_at, last_status_code, last_error, created_at, delivered_at, COALESCE(batch_id, '')
+		FROM webhook_deliveries
+		WHERE webhook_id = $1 AND ($2 = '' OR status = $2)
+		ORDER BY created_at DESC
+		LIMIT $3
+	`, c.Param("id"), status, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var dl WebhookDelivery
+		var next, delivered sql.NullTime
+		var code sql.NullInt64
+		if err := rows.Scan(&dl.ID, &dl.EventID, &dl.EventType, &dl.Status, &dl.Attempts, &next, &code, &dl.LastError, &dl.CreatedAt, &delivered, &dl.BatchID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if next.Valid {
+			dl.NextAttemptAt = &next.Time
+		}
+		if code.Valid {
+			n := int(code.Int64)
+			dl.LastStatusCode = &n
+		}
+		if delivered.Valid {
+			dl.DeliveredAt = &delivered.Time
+		}
+		deliveries = append(deliveries, dl)
+	}
+	c.JSON(http.StatusOK, gin.H{"deliveries": fields.Apply(deliveries)})
+}
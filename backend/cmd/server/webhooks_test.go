@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/infrasage/payflow/internal/dbtest"
+	"github.com/infrasage/payflow/sdk"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCreateBatchedWebhook(t *testing.T) {
+	var inserted []dbtest.Query
+	app := newTestApp(t, func(c *Config) { c.WebhookAllowPrivateURLs = true })
+	app.db = dbtest.New(func(q dbtest.Query) (*dbtest.Rows, error) {
+		if q.HasPrefix("INSERT INTO webhooks") {
+			inserted = append(inserted, q)
+			return dbtest.Affected(1), nil
+		}
+		return nil, dbtest.Unexpected(q)
+	}).Open(t)
+	app.webhooks = newWebhookDispatcher(app)
+	r := app.newRouter()
+	const url = "http://127.0.0.1:9/hooks"
+
+	tests := []struct {
+		name      string
+		batch     interface{}
+		want      int
+		size, ms  int64
+		batchJSON bool
+	}{
+		{"unbatched", nil, http.StatusCreated, 0, 0, false},
+		{"default flush interval", map[string]int{"max_size": 50}, http.StatusCreated, 50, defaultWebhookBatchFlushMs, true},
+		{"flush right away", map[string]int{"max_size": 50, "flush_interval_ms": 0}, http.StatusCreated, 50, 0, true},
+		{"too large", map[string]int{"max_size": 5000}, http.StatusBadRequest, 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inserted = nil
+			body := map[string]interface{}{"url": url}
+			if tt.batch != nil {
+				body["batch"] = tt.batch
+			}
+			w := serve(r, http.MethodPost, "/api/webhooks", body, nil)
+			if w.Code != tt.want {
+				t.Fatalf("POST /api/webhooks = %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+			if tt.want != http.StatusCreated {
+				if len(inserted) != 0 {
+					t.Error("refused webhook stored")
+				}
+				return
+			}
+			if len(inserted) != 1 {
+				t.Fatalf("%d inserts, want 1", len(inserted))
+			}
+			if size, ms := inserted[0].Args[8], inserted[0].Args[9]; size != tt.size || ms != tt.ms {
+				t.Errorf("stored batch_max_size %v, batch_flush_ms %v; want %d, %d", size, ms, tt.size, tt.ms)
+			}
+			var resp struct {
+				Webhook Webhook `json:"webhook"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &resp)
+			if (resp.Webhook.Batch != nil) != tt.batchJSON {
+				t.Errorf("response batch %+v", resp.Webhook.Batch)
+			}
+		})
+	}
+}
+
+// A batch goes out as one signed POST; events the receiver lists as failed
+// are retried and the rest delivered.
+func TestWebhookBatchDelivery(t *testing.T) {
+	const secret = "whsec_test"
+	var (
+		mu      sync.Mutex
+		batchID string
+		answer  = `{"failed": ["evt-2"]}`
+		status  = http.StatusOK
+		gotErr  string
+	)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, _ := io.ReadAll(r.Body)
+		var events []WebhookEvent
+		switch {
+		case sdk.VerifyWebhook(r.Header, body, secret, time.Minute, time.Now()) != nil:
+			gotErr = "signature does not verify"
+		case r.Header.Get(sdk.HeaderWebhookEvent) != sdk.WebhookEventBatch || r.Header.Get(sdk.HeaderWebhookDelivery) != batchID:
+			gotErr = "headers " + r.Header.Get(sdk.HeaderWebhookEvent) + " " + r.Header.Get(sdk.HeaderWebhookDelivery)
+		case json.Unmarshal(body, &events) != nil || len(events) != 3 || r.Header.Get(sdk.HeaderWebhookBatchSize) != "3":
+			gotErr = "body " + string(body)
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, answer)
+	}))
+	defer receiver.Close()
+
+	var outcomes map[string]string
+	app := newTestApp(t, func(c *Config) { c.WebhookAllowPrivateURLs = true })
+	app.db = dbtest.New(func(q dbtest.Query) (*dbtest.Rows, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case q.HasPrefix("SELECT w.id, w.batch_max_size FROM webhooks w"):
+			if outcomes != nil {
+				// Claimed once per pass.
+				return dbtest.NewRows("id", "batch_max_size"), nil
+			}
+			outcomes = map[string]string{}
+			return dbtest.NewRows("id", "batch_max_size").Add("wh-1", int64(10)), nil
+		case q.HasPrefix("UPDATE webhook_deliveries d SET next_attempt_at"):
+			batchID = q.String(3)
+			rows := dbtest.NewRows("id", "event_id", "event_type", "payload", "attempts", "url", "secret", "secret_version")
+			for i := 1; i <= 3; i++ {
+				n := strconv.Itoa(i)
+				payload, _ := json.Marshal(WebhookEvent{ID: "evt-" + n, Type: WebhookTransactionCreated, Data: map[string]string{"id": "txn-" + n}})
+				rows.Add("dlv-"+n, "evt-"+n, WebhookTransactionCreated, payload, int64(0), receiver.URL, secret, int64(1))
+			}
+			return rows, nil
+		case q.HasPrefix("UPDATE webhook_deliveries SET status = 'delivered'"):
+			outcomes[q.String(0)] = "delivered"
+			return dbtest.Affected(1), nil
+		case q.HasPrefix("UPDATE webhook_deliveries SET attempts"):
+			outcomes[q.String(0)] = "retrying"
+			return dbtest.Affected(1), nil
+		}
+		return nil, dbtest.Unexpected(q)
+	}).Open(t)
+	app.webhooks = newWebhookDispatcher(app)
+
+	tests := []struct {
+		name   string
+		status int
+		answer string
+		want   map[string]string
+		result string
+	}{
+		{"partial", http.StatusOK, `{"failed": ["evt-2"]}`, map[string]string{"dlv-1": "delivered", "dlv-2": "retrying", "dlv-3": "delivered"}, "partial"},
+		{"empty answer", http.StatusNoContent, "", map[string]string{"dlv-1": "delivered", "dlv-2": "delivered", "dlv-3": "delivered"}, "delivered"},
+		{"server error", http.StatusInternalServerError, `{"failed": []}`, map[string]string{"dlv-1": "retrying", "dlv-2": "retrying", "dlv-3": "retrying"}, "failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			outcomes, status, answer, gotErr = nil, tt.status, tt.answer, ""
+			mu.Unlock()
+			before := testutil.ToFloat64(webhookBatchesTotal.WithLabelValues(tt.result))
+			app.webhooks.drainBatches()
+
+			mu.Lock()
+			defer mu.Unlock()
+			if gotErr != "" {
+				t.Fatalf("receiver: %s", gotErr)
+			}
+			for id, want := range tt.want {
+				if outcomes[id] != want {
+					t.Errorf("%s %q, want %q", id, outcomes[id], want)
+				}
+			}
+			if n := testutil.ToFloat64(webhookBatchesTotal.WithLabelValues(tt.result)) - before; n != 1 {
+				t.Errorf("%s batches counted %v, want 1", tt.result, n)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+// Package batchjob is what PayFlow's short-lived CLI tools (seed, loadgen,
+// backfill) share: settings from the environment, a client for the API and
+// run metrics pushed to a Prometheus Pushgateway. A job that exits before
+// Prometheus would scrape it still leaves its numbers behind that way.
+//
+// Pushing is configured by:
+//
+//	PUSHGATEWAY_URL           Pushgateway base URL; nothing is pushed when empty
+//	PUSHGATEWAY_JOB           job label, the tool's name by default
+//	PUSHGATEWAY_INTERVAL_SEC  also push every N seconds while running; 0 pushes only at the end
+package batchjob
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// Env returns the environment variable key, or def when it is unset.
+func Env(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// EnvInt returns the environment variable key as an integer, or def when it
+// is unset. A value that isn't an integer ends the program.
+func EnvInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Fatalf("%s must be an integer, got %q", key, v)
+	}
+	return n
+}
+
+// EnvFloat is EnvInt for numbers.
+func EnvFloat(key string, def float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Fatalf("%s must be a number, got %q", key, v)
+	}
+	return f
+}
+
+// Run is one run of a job and its metrics. Every run pushes:
+//
+//	payflow_job_items_total{result}              items processed, by the job's own results
+//	payflow_job_duration_seconds                 how long the run took (so far, while running)
+//	payflow_job_last_run_timestamp_seconds       when the run ended
+//	payflow_job_last_success_timestamp_seconds   when it ended, if it succeeded
+//	payflow_job_succeeded                        1 if it succeeded, 0 if not
+//
+// A push replaces the job's previous metrics on the gateway, so the last
+// success timestamp is only carried over by the run that set it; alert on
+// its age with the last push time the gateway adds.
+type Run struct {
+	// Registry holds the run's metrics; jobs register their own here too.
+	Registry *prometheus.Registry
+	// Items counts what the job processed by result.
+	Items *prometheus.CounterVec
+
+	name        string
+	started     time.Time
+	duration    prometheus.Gauge
+	lastRun     prometheus.Gauge
+	lastSuccess prometheus.Gauge
+	succeeded   prometheus.Gauge
+	pusher      *push.Pusher
+
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Start begins a run of the job called name, reading the PUSHGATEWAY_*
+// settings, and pushes periodically when PUSHGATEWAY_INTERVAL_SEC is set.
+func Start(name string) *Run {
+	r := &Run{
+		Registry: prometheus.NewRegistry(),
+		Items: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "payflow_job_items_total",
+			Help: "Items the job processed, by result",
+		}, []string{"result"}),
+		name:    name,
+		started: time.Now(),
+		duration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "payflow_job_duration_seconds",
+			Help: "How long the run took, or has taken so far",
+		}),
+		lastRun: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "payflow_job_last_run_timestamp_seconds",
+			Help: "When the run ended, as a Unix time",
+		}),
+		lastSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "payflow_job_last_success_timestamp_seconds",
+			Help: "When the run ended, as a Unix time, if it succeeded",
+		}),
+		succeeded: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "payflow_job_succeeded",
+			Help: "1 if the run succeeded, 0 if it failed or is still going",
+		}),
+	}
+	r.Registry.MustRegister(r.Items, r.duration, r.lastRun, r.lastSuccess, r.succeeded)
+	if gateway := os.Getenv("PUSHGATEWAY_URL"); gateway != "" {
+		r.pusher = push.New(gateway, Env("PUSHGATEWAY_JOB", name)).Gatherer(r.Registry)
+	}
+	if every := EnvInt("PUSHGATEWAY_INTERVAL_SEC", 0); every > 0 && r.pusher != nil {
+		r.stop, r.done = make(chan struct{}), make(chan struct{})
+		go r.pushEvery(time.Duration(every) * time.Second)
+	}
+	return r
+}
+
+func (r *Run) pushEvery(interval time.Duration) {
+	defer close(r.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			if err := r.push(); err != nil {
+				log.Printf("%s: push to Pushgateway failed: %v", r.name, err)
+			}
+		}
+	}
+}
+
+func (r *Run) push() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.duration.Set(time.Since(r.started).Seconds())
+	return r.pusher.Push()
+}
+
+// Finish records how the run ended, runErr being nil for success, and
+// pushes the metrics a last time. It returns the push error, if any.
+func (r *Run) Finish(runErr error) error {
+	if r.stop != nil {
+		close(r.stop)
+		<-r.done
+	}
+	now := time.Now()
+	r.lastRun.Set(float64(now.Unix()))
+	if runErr == nil {
+		r.succeeded.Set(1)
+		r.lastSuccess.Set(float64(now.Unix()))
+	}
+	if r.pusher == nil {
+		return nil
+	}
+	return r.push()
+}
+
+// Client calls the PayFlow API at PAYFLOW_URL, authenticating with
+// PAYFLOW_API_KEY and, for admin endpoints, PAYFLOW_ADMIN_TOKEN. With
+// PAYFLOW_DEMO_SESSION set, everything happens in that demo session.
+type Client struct {
+	BaseURL    string
+	APIKey     string
+	AdminToken string
+	Session    string
+	HTTP       *http.Client
+}
+
+// NewClient returns a Client configured from the environment.
+func NewClient() *Client {
+	return &Client{
+		BaseURL:    Env("PAYFLOW_URL", "http://localhost:8080"),
+		APIKey:     os.Getenv("PAYFLOW_API_KEY"),
+		AdminToken: os.Getenv("PAYFLOW_ADMIN_TOKEN"),
+		Session:    os.Getenv("PAYFLOW_DEMO_SESSION"),
+		HTTP:       &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Do sends body, when not nil, as JSON and decodes a 2xx answer into out,
+// when not nil. It returns the status code; answers outside 2xx are an
+// error carrying the API's message.
+func (c *Client) Do(ctx context.Context, method, path string, body, out interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.APIKey != "" {
+		req.Header.Set("X-API-Key", c.APIKey)
+	}
+	if c.AdminToken != "" {
+		req.Header.Set("X-Admin-Token", c.AdminToken)
+	}
+	if c.Session != "" {
+		req.Header.Set("X-Demo-Session", c.Session)
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		json.Unmarshal(raw, &apiErr)
+		if apiErr.Error == "" {
+			apiErr.Error = http.StatusText(resp.StatusCode)
+		}
+		return resp.StatusCode, fmt.Errorf("%s %s: %d %s", method, path, resp.StatusCode, apiErr.Error)
+	}
+	if out != nil && len(raw) > 0 {
+		if err := json.Unmarshal(raw, out); err != nil {
+			return resp.StatusCode, fmt.Errorf("%s %s: %w", method, path, err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// Payment is the body of POST /api/transactions, as /api/seed/sample
+// generates them.
+type Payment struct {
+	Persona     string  `json:"persona,omitempty"`
+	FromAccount string  `json:"from_account"`
+	ToAccount   string  `json:"to_account"`
+	Amount      float64 `json:"amount"`
+	Description string  `json:"description"`
+}
+
+// SamplePayments asks the API for count persona-driven payments, taking
+// them in pages of up to 100. No personas means any.
+func (c *Client) SamplePayments(ctx context.Context, count int, personas string) ([]Payment, error) {
+	var payments []Payment
+	for len(payments) < count {
+		n := count - len(payments)
+		if n > 100 {
+			n = 100
+		}
+		q := url.Values{"count": {strconv.Itoa(n)}}
+		if personas != "" {
+			q.Set("persona", personas)
+		}
+		var page struct {
+			Transactions []Payment `json:"transactions"`
+		}
+		if _, err := c.Do(ctx, http.MethodGet, "/api/seed/sample?"+q.Encode(), nil, &page); err != nil {
+			return nil, err
+		}
+		payments = append(payments, page.Transactions...)
+	}
+	return payments, nil
+}
+
+// Pay posts a payment and returns the status it came back with. A payment
+// declined for insufficient funds is "failed", not an error.
+func (c *Client) Pay(ctx context.Context, p Payment) (string, error) {
+	p.Persona = ""
+	var txn struct {
+		Status string `json:"status"`
+	}
+	code, err := c.Do(ctx, http.MethodPost, "/api/transactions", p, &txn)
+	if code == http.StatusUnprocessableEntity {
+		return "failed", nil
+	}
+	return txn.Status, err
+}
@@ -0,0 +1,124 @@
+package batchjob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeGateway is a Pushgateway that keeps the pushes it gets.
+type fakeGateway struct {
+	mu     sync.Mutex
+	pushes []string
+	paths  []string
+}
+
+func (g *fakeGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.paths = append(g.paths, r.Method+" "+r.URL.Path)
+	g.pushes = append(g.pushes, string(body))
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestRunPushesOnFinish(t *testing.T) {
+	gateway := &fakeGateway{}
+	srv := httptest.NewServer(gateway)
+	defer srv.Close()
+	t.Setenv("PUSHGATEWAY_URL", srv.URL)
+	t.Setenv("PUSHGATEWAY_JOB", "nightly-seed")
+
+	run := Start("seed")
+	run.Items.WithLabelValues("success").Add(3)
+	if err := run.Finish(nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(gateway.paths) != 1 || gateway.paths[0] != "PUT /metrics/job/nightly-seed" {
+		t.Fatalf("pushed to %v, want one PUT for nightly-seed", gateway.paths)
+	}
+	for _, name := range []string{"payflow_job_items_total", "payflow_job_duration_seconds", "payflow_job_last_success_timestamp_seconds", "payflow_job_succeeded"} {
+		if !strings.Contains(gateway.pushes[0], name) {
+			t.Errorf("push lacks %s", name)
+		}
+	}
+
+	failed := Start("seed")
+	failed.Finish(errors.New("boom"))
+	if v := gaugeValue(t, failed, "payflow_job_succeeded"); v != 0 {
+		t.Errorf("failed run succeeded %v, want 0", v)
+	}
+	if v := gaugeValue(t, failed, "payflow_job_last_success_timestamp_seconds"); v != 0 {
+		t.Errorf("failed run stamped a success at %v", v)
+	}
+}
+
+func TestRunWithoutGateway(t *testing.T) {
+	t.Setenv("PUSHGATEWAY_URL", "")
+	if err := Start("seed").Finish(nil); err != nil {
+		t.Errorf("finish without a gateway: %v", err)
+	}
+}
+
+func gaugeValue(t *testing.T, r *Run, name string) float64 {
+	t.Helper()
+	families, err := r.Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() == name {
+			return f.GetMetric()[0].GetGauge().GetValue()
+		}
+	}
+	t.Fatalf("no %s gathered", name)
+	return 0
+}
+
+func TestClientPay(t *testing.T) {
+	var headers http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case strings.Contains(string(body), `"amount":500000`):
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			io.WriteString(w, `{"error":"Insufficient funds","code":"INSUFFICIENT_FUNDS"}`)
+		case strings.Contains(string(body), `"persona"`):
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"error":"unexpected field"}`)
+		default:
+			w.WriteHeader(http.StatusCreated)
+			io.WriteString(w, `{"id":"txn-1","status":"success"}`)
+		}
+	}))
+	defer srv.Close()
+	client := &Client{BaseURL: srv.URL, APIKey: "pk_test", Session: "s1", HTTP: srv.Client()}
+	ctx := context.Background()
+
+	status, err := client.Pay(ctx, Payment{Persona: "commuter", FromAccount: "ACC-1", ToAccount: "ACC-2", Amount: 12.5})
+	if status != "success" || err != nil {
+		t.Errorf("pay: %q, %v", status, err)
+	}
+	if headers.Get("X-API-Key") != "pk_test" || headers.Get("X-Demo-Session") != "s1" || headers.Get("X-Admin-Token") != "" {
+		t.Errorf("sent headers %v", headers)
+	}
+	if status, err := client.Pay(ctx, Payment{FromAccount: "ACC-1", ToAccount: "ACC-2", Amount: 500000}); status != "failed" || err != nil {
+		t.Errorf("declined payment: %q, %v; want failed and no error", status, err)
+	}
+
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		io.WriteString(w, `{"error":"Database unavailable"}`)
+	}))
+	defer down.Close()
+	client.BaseURL = down.URL
+	if _, err := client.Pay(ctx, Payment{}); err == nil || !strings.Contains(err.Error(), "503 Database unavailable") {
+		t.Errorf("unavailable API: %v", err)
+	}
+}
@@ -0,0 +1,869 @@
+// Package config loads PayFlow's settings from the environment and an
+// optional dotenv-style CONFIG_FILE. Schema describes every setting and is
+// the single source of truth for loading, validation, the startup summary
+// and the configuration schema the API serves.
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Config holds all configuration
+type Config struct {
+	Port                         string
+	GRPCPort                     string
+	Region                       string
+	PostgresHost                 string
+	PostgresPort                 string
+	PostgresUser                 string
+	PostgresPass                 string
+	PostgresDB                   string
+	MigrateOnStart               bool
+	RedisHost                    string
+	RedisPort                    string
+	StartupDeadlineSec           int
+	FailFast                     bool
+	DepRetryInitialMs            int
+	DepRetryMaxMs                int
+	DepRetryMultiplier           float64
+	DepRetryJitter               float64
+	CacheMode                    string
+	CacheMaxSize                 string
+	CacheTTL                     int
+	DashboardStatsTTLSec         int
+	DashboardTransactionsTTLSec  int
+	DashboardFraudTTLSec         int
+	DBPoolSize                   int
+	DBReadPoolSize               int
+	DBJobPoolSize                int
+	DBQueryTimeoutMs             int
+	RateLimitRPS                 int
+	RateLimitBurst               int
+	RateLimitRoutes              string
+	RateLimitExempt              string
+	TrustedProxies               string
+	LogLevel                     string
+	LogFormat                    string
+	StrictStartup                bool
+	Currency                     string
+	StatsLocale                  string
+	DebugLogSampleRate           float64
+	FeatureNewCache              bool
+	EnrichmentSource             string
+	EnrichmentURL                string
+	PolicyEngine                 string
+	OPAURL                       string
+	OPAPolicyPath                string
+	PolicyFallback               string
+	PolicyTimeoutMs              int
+	EnrichmentCacheTTLSec        int
+	FailoverRole                 string
+	FailoverGroup                string
+	FailoverHeartbeatSec         int
+	FailoverStaleSec             int
+	RegistryEnabled              bool
+	RegistryService              string
+	RegistryAdvertiseAddr        string
+	RegistryTTLSec               int
+	RegistryRefreshSec           int
+	CostUnitsPerDBMs             float64
+	CostUnitsPerCPUMs            float64
+	CostUnitsPerRedisCall        float64
+	CostUnitsPerKB               float64
+	LatencyLogThresholdMs        int
+	ExportSigningKey             string
+	ExportLinkTTLSec             int
+	TokenizationEnabled          bool
+	TokenVaultKey                string
+	TokenVaultKeys               string
+	FraudRulesSource             string
+	FraudRulesFile               string
+	SeedPersonasFile             string
+	FraudReviewScore             float64
+	FraudBlockScore              float64
+	FraudReviewHold              bool
+	FraudHoldExpirySec           int
+	FraudShadowDurationSec       int
+	FraudBackfillRate            int
+	FraudWorkers                 int
+	FraudWorkersMax              int
+	FraudScaleLatencyMs          int
+	FraudQueueSize               int
+	FraudRecoveryIntervalSec     int
+	FraudAlertRetentionDays      int
+	FraudAlertSoftQuota          int
+	FraudAlertSummaryIntervalSec int
+	FraudSpikeWindowSec          int
+	FraudSpikeBaselineWindows    int
+	FraudSpikeZThreshold         float64
+	FraudSpikeMinAlerts          int
+	FraudSpikeSlackWebhookURL    string
+	WSMaxClients                 int
+	WSSendBuffer                 int
+	WebhookMaxAttempts           int
+	WebhookBackoffBaseSec        int
+	WebhookBackoffMaxSec         int
+	WebhookTimeoutSec            int
+	WebhookSecretGraceSec        int
+	WebhookAllowPrivateURLs      bool
+	ReceiptEmails                bool
+	ReceiptTemplateFile          string
+	ReceiptMaxAttempts           int
+	SMTPAddr                     string
+	SMTPFrom                     string
+	SMTPUsername                 string
+	SMTPPassword                 string
+	EventBus                     string
+	EventOutbox                  bool
+	EventSchemaValidation        string
+	KafkaBrokers                 string
+	KafkaTopicTransactions       string
+	KafkaTopicFraudAlerts        string
+	NATSURL                      string
+	NATSSubjectTransactions      string
+	NATSSubjectFraudAlerts       string
+	NATSJetStream                bool
+	NATSStream                   string
+	SpoolPath                    string
+	SpoolReplaySec               int
+	BackpressureDBPoolRatio      float64
+	BackpressureSpoolMaxDepth    int
+	LedgerSigningKey             string
+	EODCloseIntervalSec          int
+	EODCloseDelayMin             int
+	EODExceptionLookbackDays     int
+	BankURL                      string
+	BankTimeoutMs                int
+	BankSettlementAccount        string
+	OutboundMaxIdlePerHost       int
+	OutboundBreakerFailures      int
+	OutboundBreakerCooldownSec   int
+	InjectSlowQueryRate          float64
+	InjectSlowQueryMs            int
+	InjectGoroutineLeakPerSec    int
+	InjectPoolExhaustion         int
+	InjectDropRate               float64
+	ChaosScenariosFile           string
+	ChaosScenario                string
+	APIDefaultVersion            string
+	APIV2FieldNaming             string
+	AdminToken                   string
+	OAuthClients                 string
+	OAuthSigningKey              string
+	CursorSigningKey             string
+	OAuthIssuer                  string
+	OAuthTokenTTLSec             int
+	OAuthRequired                bool
+	DemoTokensEnabled            bool
+	EnablePprof                  bool
+	SignatureMaxSkewSec          int
+	OIDCIssuer                   string
+	OIDCAudience                 string
+	OIDCJWKSURL                  string
+	OIDCGroupsClaim              string
+	OIDCRoleMap                  string
+	OIDCJWKSCacheSec             int
+	DemoSessionTTLSec            int
+	IncidentProvider             string
+	IncidentRoutingKey           string
+	IncidentAPIURL               string
+	IncidentDryRun               bool
+	IncidentCheckIntervalSec     int
+	IncidentReadinessMinutes     int
+	IncidentPanicsPerMin         float64
+	IncidentSLOTarget            float64
+	IncidentBurnRate             float64
+	AnomalyWindowSec             int
+	AnomalyAlpha                 float64
+	AnomalyZThreshold            float64
+	AnomalyWarmupWindows         int
+	// Bug injection
+	InjectOOM              bool
+	InjectLatencyMs        int
+	InjectErrorRate        float64
+	InjectCPUBurn          bool
+	InjectPanic            bool
+	InjectDBTimeout        bool
+	InjectReplicationLagMs int
+
+	// sources records where each setting came from, keyed by env name.
+	sources map[string]string
+}
+
+// Field describes a single environment-driven setting.
+type Field struct {
+	Env         string   `json:"env"`
+	Type        string   `json:"type"`
+	Default     string   `json:"default"`
+	Description string   `json:"description"`
+	Secret      bool     `json:"secret,omitempty"`
+	Enum        []string `json:"enum,omitempty"`
+	Min         *float64 `json:"min,omitempty"`
+	Max         *float64 `json:"max,omitempty"`
+	Pattern     string   `json:"pattern,omitempty"`
+	Overridable bool     `json:"overridable,omitempty"`
+	// Chaos settings can also be changed at runtime through /api/admin/chaos.
+	Chaos bool `json:"chaos,omitempty"`
+	// Reloadable settings are reread from CONFIG_FILE and the environment
+	// on SIGHUP; chaos settings are too. The rest need a restart.
+	Reloadable bool `json:"reloadable,omitempty"`
+
+	field func(c *Config) interface{}
+}
+
+func bound(v float64) *float64 { return &v }
+
+// Schema lists every setting, in the order they are reported.
+var Schema = []Field{
+	{Env: "PORT", Type: "string", Default: "8080", Description: "HTTP listen port", Pattern: `^[0-9]{1,5}$`,
+		field: func(c *Config) interface{} { return &c.Port }},
+	{Env: "GRPC_PORT", Type: "string", Default: "", Description: "Port of the gRPC API (PaymentService, health, reflection); disabled when empty", Pattern: `^([0-9]{1,5})?$`,
+		field: func(c *Config) interface{} { return &c.GRPCPort }},
+	{Env: "REGION", Type: "string", Default: "local", Description: "Region this instance runs in; stamped on transactions, metrics and responses", Pattern: `^[a-z0-9-]{1,32}$`,
+		field: func(c *Config) interface{} { return &c.Region }},
+	{Env: "POSTGRES_HOST", Type: "string", Default: "localhost", Description: "PostgreSQL host",
+		field: func(c *Config) interface{} { return &c.PostgresHost }},
+	{Env: "POSTGRES_PORT", Type: "string", Default: "5432", Description: "PostgreSQL port", Pattern: `^[0-9]{1,5}$`,
+		field: func(c *Config) interface{} { return &c.PostgresPort }},
+	{Env: "POSTGRES_USER", Type: "string", Default: "payflow", Description: "PostgreSQL user",
+		field: func(c *Config) interface{} { return &c.PostgresUser }},
+	{Env: "POSTGRES_PASSWORD", Type: "string", Default: "payflow", Description: "PostgreSQL password", Secret: true,
+		field: func(c *Config) interface{} { return &c.PostgresPass }},
+	{Env: "POSTGRES_DB", Type: "string", Default: "payflow", Description: "PostgreSQL database name",
+		field: func(c *Config) interface{} { return &c.PostgresDB }},
+	{Env: "MIGRATE_ON_START", Type: "bool", Default: "true", Description: "Apply pending database migrations at startup; turn off to run payflow migrate separately",
+		field: func(c *Config) interface{} { return &c.MigrateOnStart }},
+	{Env: "REDIS_HOST", Type: "string", Default: "localhost", Description: "Redis host",
+		field: func(c *Config) interface{} { return &c.RedisHost }},
+	{Env: "REDIS_PORT", Type: "string", Default: "6379", Description: "Redis port", Pattern: `^[0-9]{1,5}$`,
+		field: func(c *Config) interface{} { return &c.RedisPort }},
+	{Env: "STARTUP_DEADLINE_SEC", Type: "int", Default: "60", Description: "How long startup waits for Postgres and Redis to come up, in seconds, across both", Min: bound(0),
+		field: func(c *Config) interface{} { return &c.StartupDeadlineSec }},
+	{Env: "FAIL_FAST", Type: "bool", Default: "false", Description: "Exit non-zero when Postgres, or Redis with CACHE_MODE=redis, isn't up by STARTUP_DEADLINE_SEC instead of starting degraded",
+		field: func(c *Config) interface{} { return &c.FailFast }},
+	{Env: "DEP_RETRY_INITIAL_MS", Type: "int", Default: "250", Description: "Wait before the second attempt to reach a dependency at startup, in milliseconds", Min: bound(1),
+		field: func(c *Config) interface{} { return &c.DepRetryInitialMs }},
+	{Env: "DEP_RETRY_MAX_MS", Type: "int", Default: "8000", Description: "Longest wait between attempts to reach a dependency at startup, in milliseconds", Min: bound(1),
+		field: func(c *Config) interface{} { return &c.DepRetryMaxMs }},
+	{Env: "DEP_RETRY_MULTIPLIER", Type: "float", Default: "2", Description: "Factor the wait between dependency attempts grows by", Min: bound(1),
+		field: func(c *Config) interface{} { return &c.DepRetryMultiplier }},
+	{Env: "DEP_RETRY_JITTER", Type: "float", Default: "0.2", Description: "Fraction each wait between dependency attempts is randomly lengthened or shortened by", Min: bound(0), Max: bound(1),
+		field: func(c *Config) interface{} { return &c.DepRetryJitter }},
+	{Env: "CACHE_MODE", Type: "string", Default: "redis", Description: "Where caches and shared counters live: redis, memory (per instance, no Redis) or off", Enum: []string{"redis", "memory", "off"},
+		field: func(c *Config) interface{} { return &c.CacheMode }},
+	{Env: "CACHE_MAX_SIZE", Type: "string", Default: "100MB", Description: "Maximum cache size (e.g. 512KB, 100MB, 1GB); bounds the in-process cache when CACHE_MODE is memory", Pattern: `^[0-9]+(B|KB|MB|GB)$`,
+		field: func(c *Config) interface{} { return &c.CacheMaxSize }},
+	{Env: "CACHE_TTL", Type: "int", Default: "3600", Description: "Cache TTL in seconds", Min: bound(0), Reloadable: true,
+		field: func(c *Config) interface{} { return &c.CacheTTL }},
+	{Env: "DASHBOARD_STATS_TTL_SEC", Type: "int", Default: "5", Description: "How long GET /api/dashboard caches its stats section, in seconds (0 disables)", Min: bound(0),
+		field: func(c *Config) interface{} { return &c.DashboardStatsTTLSec }},
+	{Env: "DASHBOARD_TRANSACTIONS_TTL_SEC", Type: "int", Default: "2", Description: "How long GET /api/dashboard caches its latest transactions, in seconds (0 disables)", Min: bound(0),
+		field: func(c *Config) interface{} { return &c.DashboardTransactionsTTLSec }},
+	{Env: "DASHBOARD_FRAUD_TTL_SEC", Type: "int", Default: "15", Description: "How long GET /api/dashboard caches its fraud alerts, in seconds (0 disables)", Min: bound(0),
+		field: func(c *Config) interface{} { return &c.DashboardFraudTTLSec }},
+	{Env: "DB_POOL_SIZE", Type: "int", Default: "10", Description: "Maximum open connections in the OLTP pool used for payments and other request-path queries", Min: bound(1), Max: bound(1000),
+		field: func(c *Config) interface{} { return &c.DBPoolSize }},
+	{Env: "DB_READ_POOL_SIZE", Type: "int", Default: "10", Description: "Maximum open connections in the pool for listing and reporting queries", Min: bound(1), Max: bound(1000),
+		field: func(c *Config) interface{} { return &c.DBReadPoolSize }},
+	{Env: "DB_JOB_POOL_SIZE", Type: "int", Default: "4", Description: "Maximum open connections in the pool for background jobs", Min: bound(1), Max: bound(1000),
+		field: func(c *Config) interface{} { return &c.DBJobPoolSize }},
+	{Env: "DB_QUERY_TIMEOUT_MS", Type: "int", Default: "5000", Description: "How long one statement run for a request, or a readiness ping, may take before it is canceled, in milliseconds (0 disables)", Min: bound(0),
+		field: func(c *Config) interface{} { return &c.DBQueryTimeoutMs }},
+	{Env: "RATE_LIMIT_RPS", Type: "int", Default: "100", Description: "Requests per second allowed per client", Min: bound(0), Reloadable: true,
+		field: func(c *Config) interface{} { return &c.RateLimitRPS }},
+	{Env: "RATE_LIMIT_BURST", Type: "int", Default: "0", Description: "Requests a client may make at once before RATE_LIMIT_RPS applies (0 = same as RATE_LIMIT_RPS)", Min: bound(0), Reloadable: true,
+		field: func(c *Config) interface{} { return &c.RateLimitBurst }},
+	{Env: "RATE_LIMIT_ROUTES", Type: "string", Default: "", Description: "Per-route multipliers of RATE_LIMIT_RPS and RATE_LIMIT_BURST as route=factor, comma-separated; a route is a path as registered, e.g. /api/transactions/:id, optionally after a method, and gets its own budget per client", Reloadable: true,
+		field: func(c *Config) interface{} { return &c.RateLimitRoutes }},
+	{Env: "RATE_LIMIT_EXEMPT", Type: "string", Default: "", Description: "Comma-separated routes and clients the rate limiter lets through: paths as registered, or client keys such as apikey:<key id>, oauth:<client> and ip:<address>", Reloadable: true,
+		field: func(c *Config) interface{} { return &c.RateLimitExempt }},
+	{Env: "TRUSTED_PROXIES", Type: "string", Default: "", Description: "Comma-separated proxy IPs or CIDRs whose X-Forwarded-For names the client, e.g. for rate limits; none when empty",
+		field: func(c *Config) interface{} { return &c.TrustedProxies }},
+	{Env: "CURRENCY", Type: "string", Default: "USD", Description: "Currency transaction amounts are denominated in", Enum: []string{"USD", "EUR", "GBP", "CHF", "JPY"},
+		field: func(c *Config) interface{} { return &c.Currency }},
+	{Env: "STATS_LOCALE", Type: "string", Default: "en-US", Description: "Locale for formatted amounts when the request asks for none", Enum: []string{"en-US", "en-GB", "de-DE", "fr-FR", "ja-JP"},
+		field: func(c *Config) interface{} { return &c.StatsLocale }},
+	{Env: "LOG_LEVEL", Type: "string", Default: "info", Description: "Minimum log level", Enum: []string{"debug", "info", "warn", "error"}, Reloadable: true,
+		field: func(c *Config) interface{} { return &c.LogLevel }},
+	{Env: "LOG_FORMAT", Type: "string", Default: "json", Description: "Log output format: json lines, or text for reading in a terminal", Enum: []string{"json", "text"},
+		field: func(c *Config) interface{} { return &c.LogFormat }},
+	{Env: "STRICT_STARTUP", Type: "bool", Default: "false", Description: "Exit at startup when a critical self-check fails instead of running degraded",
+		field: func(c *Config) interface{} { return &c.StrictStartup }},
+	{Env: "DEBUG_LOG_SAMPLE_RATE", Type: "float", Default: "0", Description: "Fraction of requests logged at debug level regardless of LOG_LEVEL", Min: bound(0), Max: bound(1),
+		field: func(c *Config) interface{} { return &c.DebugLogSampleRate }},
+	{Env: "FEATURE_NEW_CACHE", Type: "bool", Default: "false", Description: "Enable the new cache implementation", Overridable: true, Chaos: true,
+		field: func(c *Config) interface{} { return &c.FeatureNewCache }},
+	{Env: "ENRICHMENT_SOURCE", Type: "string", Default: "table", Description: "Where counterparty metadata comes from: the local counterparties table, an HTTP service, or none", Enum: []string{"none", "table", "http"},
+		field: func(c *Config) interface{} { return &c.EnrichmentSource }},
+	{Env: "ENRICHMENT_URL", Type: "string", Default: "", Description: "Base URL of the counterparty lookup service, queried as GET {url}/{account}",
+		field: func(c *Config) interface{} { return &c.EnrichmentURL }},
+	{Env: "ENRICHMENT_CACHE_TTL_SEC", Type: "int", Default: "300", Description: "How long counterparty lookups are cached in Redis, in seconds", Min: bound(1),
+		field: func(c *Config) interface{} { return &c.EnrichmentCacheTTLSec }},
+	{Env: "FAILOVER_ROLE", Type: "string", Default: "none", Description: "Active-passive role of this instance; a standby takes over when the primary's Redis heartbeat goes stale", Enum: []string{"none", "primary", "standby"},
+		field: func(c *Config) interface{} { return &c.FailoverRole }},
+	{Env: "FAILOVER_GROUP", Type: "string", Default: "payflow", Description: "Name shared by the two instances of a failover pair", Pattern: `^[a-z0-9-]{1,32}$`,
+		field: func(c *Config) interface{} { return &c.FailoverGroup }},
+	{Env: "FAILOVER_HEARTBEAT_SEC", Type: "int", Default: "2", Description: "How often the primary publishes, and the standby checks, the heartbeat", Min: bound(1),
+		field: func(c *Config) interface{} { return &c.FailoverHeartbeatSec }},
+	{Env: "FAILOVER_STALE_SEC", Type: "int", Default: "10", Description: "Heartbeat age after which the standby promotes itself", Min: bound(1),
+		field: func(c *Config) interface{} { return &c.FailoverStaleSec }},
+	{Env: "REGISTRY_ENABLED", Type: "bool", Default: "false", Description: "List this instance in the Redis service registry while it is ready",
+		field: func(c *Config) interface{} { return &c.RegistryEnabled }},
+	{Env: "REGISTRY_SERVICE", Type: "string", Default: "payflow-api", Description: "Service name instances register under", Pattern: `^[a-z0-9-]{1,32}$`,
+		field: func(c *Config) interface{} { return &c.RegistryService }},
+	{Env: "REGISTRY_ADVERTISE_ADDR", Type: "string", Default: "", Description: "host:port other services should use to reach this instance (default hostname and PORT)",
+		field: func(c *Config) interface{} { return &c.RegistryAdvertiseAddr }},
+	{Env: "REGISTRY_TTL_SEC", Type: "int", Default: "15", Description: "Seconds a registration survives without a refresh", Min: bound(2),
+		field: func(c *Config) interface{} { return &c.RegistryTTLSec }},
+	{Env: "REGISTRY_REFRESH_SEC", Type: "int", Default: "5", Description: "How often the registration is refreshed and health rechecked, in seconds", Min: bound(1),
+		field: func(c *Config) interface{} { return &c.RegistryRefreshSec }},
+	{Env: "COST_UNITS_PER_DB_MS", Type: "float", Default: "1", Description: "Showback cost units charged per millisecond of database time", Min: bound(0),
+		field: func(c *Config) interface{} { return &c.CostUnitsPerDBMs }},
+	{Env: "COST_UNITS_PER_CPU_MS", Type: "float", Default: "1", Description: "Showback cost units charged per millisecond of estimated CPU time", Min: bound(0),
+		field: func(c *Config) interface{} { return &c.CostUnitsPerCPUMs }},
+	{Env: "COST_UNITS_PER_REDIS_CALL", Type: "float", Default: "0.1", Description: "Showback cost units charged per Redis command", Min: bound(0),
+		field: func(c *Config) interface{} { return &c.CostUnitsPerRedisCall }},
+	{Env: "COST_UNITS_PER_KB", Type: "float", Default: "0.01", Description: "Showback cost units charged per KiB of request and response body", Min: bound(0),
+		field: func(c *Config) interface{} { return &c.CostUnitsPerKB }},
+	{Env: "LATENCY_LOG_THRESHOLD_MS", Type: "int", Default: "1000", Description: "Requests slower than this log their latency breakdown at info level, in milliseconds (0 disables); others only in debug logs", Min: bound(0),
+		field: func(c *Config) interface{} { return &c.LatencyLogThresholdMs }},
+	{Env: "EXPORT_SIGNING_KEY", Type: "string", Default: "", Description: "HMAC key for subject export download links; an ephemeral key is generated when empty", Secret: true,
+		field: func(c *Config) interface{} { return &c.ExportSigningKey }},
+	{Env: "EXPORT_LINK_TTL_SEC", Type: "int", Default: "3600", Description: "How long a subject export and its download link stay valid, in seconds", Min: bound(60),
+		field: func(c *Config) interface{} { return &c.ExportLinkTTLSec }},
+	{Env: "TOKENIZATION_ENABLED", Type: "bool", Default: "false", Description: "Store account identifiers as vault tokens instead of plaintext",
+		field: func(c *Config) interface{} { return &c.TokenizationEnabled }},
+	{Env: "TOKEN_VAULT_KEY", Type: "string", Default: "", Description: "Key that fingerprints vaulted account identifiers, and encrypts them as key 1", Secret: true,
+		field: func(c *Config) interface{} { return &c.TokenVaultKey }},
+	{Env: "TOKEN_VAULT_KEYS", Type: "string", Default: "", Description: "Further keys vaulted account identifiers can be encrypted with, as id=key pairs; POST /api/admin/tokens/keys/rotate picks the one new entries use", Secret: true,
+		field: func(c *Config) interface{} { return &c.TokenVaultKeys }},
+	{Env: "FRAUD_RULES_SOURCE", Type: "string", Default: "builtin", Description: "Where fraud rules are loaded from: the built-in set, a YAML file, or the fraud_rules table", Enum: []string{"builtin", "file", "table"},
+		field: func(c *Config) interface{} { return &c.FraudRulesSource }},
+	{Env: "FRAUD_RULES_FILE", Type: "string", Default: "", Description: "YAML file of fraud rules, read when FRAUD_RULES_SOURCE is file",
+		field: func(c *Config) interface{} { return &c.FraudRulesFile }},
+	{Env: "SEED_PERSONAS_FILE", Type: "string", Default: "", Description: "YAML file of personas that seeded and sample transactions are drawn from; empty uses the built-in set",
+		field: func(c *Config) interface{} { return &c.SeedPersonasFile }},
+	{Env: "FRAUD_REVIEW_SCORE", Type: "float", Default: "50", Description: "Total rule score at which a transaction is flagged for review", Min: bound(0), Reloadable: true,
+		field: func(c *Config) interface{} { return &c.FraudReviewScore }},
+	{Env: "FRAUD_BLOCK_SCORE", Type: "float", Default: "80", Description: "Total rule score at which a transaction is considered fraudulent", Min: bound(0), Reloadable: true,
+		field: func(c *Config) interface{} { return &c.FraudBlockScore }},
+	{Env: "FRAUD_REVIEW_HOLD", Type: "bool", Default: "false", Description: "Score payments before posting them and hold those scoring from FRAUD_REVIEW_SCORE up to FRAUD_BLOCK_SCORE in the review queue until an analyst approves or declines them", Reloadable: true,
+		field: func(c *Config) interface{} { return &c.FraudReviewHold }},
+	{Env: "FRAUD_HOLD_EXPIRY_SEC", Type: "int", Default: "259200", Description: "How long a payment may wait in the review queue before it is voided unreviewed, in seconds; 0 keeps held payments until an analyst decides", Min: bound(0), Reloadable: true,
+		field: func(c *Config) interface{} { return &c.FraudHoldExpirySec }},
+	{Env: "FRAUD_SHADOW_DURATION_SEC", Type: "int", Default: "86400", Description: "How long candidate fraud rules are scored alongside the active set, in seconds", Min: bound(60),
+		field: func(c *Config) interface{} { return &c.FraudShadowDurationSec }},
+	{Env: "FRAUD_BACKFILL_RATE", Type: "int", Default: "100", Description: "Most past transactions a fraud backfill scores per second", Min: bound(1), Max: bound(10000),
+		field: func(c *Config) interface{} { return &c.FraudBackfillRate }},
+	{Env: "FRAUD_WORKERS", Type: "int", Default: "4", Description: "Goroutines analyzing new transactions for fraud in the background; the fewest the pool scales down to when FRAUD_WORKERS_MAX is higher", Min: bound(1), Max: bound(64),
+		field: func(c *Config) interface{} { return &c.FraudWorkers }},
+	{Env: "FRAUD_WORKERS_MAX", Type: "int", Default: "0", Description: "Most goroutines the fraud pool scales up to while the queue backs up or analysis is slow (0 = FRAUD_WORKERS, no scaling)", Min: bound(0), Max: bound(64),
+		field: func(c *Config) interface{} { return &c.FraudWorkersMax }},
+	{Env: "FRAUD_SCALE_LATENCY_MS", Type: "int", Default: "250", Description: "Average analysis time above which the fraud pool adds a worker while transactions are queued, in milliseconds", Min: bound(1),
+		field: func(c *Config) interface{} { return &c.FraudScaleLatencyMs }},
+	{Env: "FRAUD_QUEUE_SIZE", Type: "int", Default: "1000", Description: "Transactions that can wait for fraud analysis before new ones are skipped", Min: bound(1),
+		field: func(c *Config) interface{} { return &c.FraudQueueSize }},
+	{Env: "FRAUD_RECOVERY_INTERVAL_SEC", Type: "int", Default: "60", Description: "How often transactions whose fraud analysis was skipped or lost are requeued, in seconds; 0 disables recovery", Min: bound(0),
+		field: func(c *Config) interface{} { return &c.FraudRecoveryIntervalSec }},
+	{Env: "FRAUD_ALERT_RETENTION_DAYS", Type: "int", Default: "30", Description: "Days review-level fraud alerts are kept before they are rolled into daily summaries", Min: bound(1),
+		field: func(c *Config) interface{} { return &c.FraudAlertRetentionDays }},
+	{Env: "FRAUD_ALERT_SOFT_QUOTA", Type: "int", Default: "100000", Description: "Fraud alerts kept before review-level ones are summarized after a day instead of after the retention period; 0 disables the quota", Min: bound(0),
+		field: func(c *Config) interface{} { return &c.FraudAlertSoftQuota }},
+	{Env: "FRAUD_ALERT_SUMMARY_INTERVAL_SEC", Type: "int", Default: "3600", Description: "How often old fraud alerts are summarized, in seconds; 0 disables summarization", Min: bound(0),
+		field: func(c *Config) interface{} { return &c.FraudAlertSummaryIntervalSec }},
+	{Env: "FRAUD_SPIKE_WINDOW_SEC", Type: "int", Default: "300", Description: "Window fraud alerts are counted per rule in for spike detection, in seconds; 0 disables spike detection", Min: bound(0), Max: bound(86400),
+		field: func(c *Config) interface{} { return &c.FraudSpikeWindowSec }},
+	{Env: "FRAUD_SPIKE_BASELINE_WINDOWS", Type: "int", Default: "24", Description: "Windows before the latest one that make up a rule's baseline alert rate", Min: bound(3), Max: bound(1000),
+		field: func(c *Config) interface{} { return &c.FraudSpikeBaselineWindows }},
+	{Env: "FRAUD_SPIKE_Z_THRESHOLD", Type: "float", Default: "3", Description: "Standard deviations above its baseline a rule's alerts must reach to count as a spike", Min: bound(0.5),
+		field: func(c *Config) interface{} { return &c.FraudSpikeZThreshold }},
+	{Env: "FRAUD_SPIKE_MIN_ALERTS", Type: "int", Default: "10", Description: "Fewest alerts for a rule in one window that can count as a spike", Min: bound(1),
+		field: func(c *Config) interface{} { return &c.FraudSpikeMinAlerts }},
+	{Env: "FRAUD_SPIKE_SLACK_WEBHOOK_URL", Type: "string", Default: "", Description: "Slack incoming webhook that fraud alert spikes are posted to", Secret: true,
+		field: func(c *Config) interface{} { return &c.FraudSpikeSlackWebhookURL }},
+	{Env: "WS_MAX_CLIENTS", Type: "int", Default: "500", Description: "WebSocket transaction feed connections accepted per instance", Min: bound(1),
+		field: func(c *Config) interface{} { return &c.WSMaxClients }},
+	{Env: "WS_SEND_BUFFER", Type: "int", Default: "64", Description: "Feed messages buffered per WebSocket connection before a slow client is told to resync", Min: bound(1), Max: bound(4096),
+		field: func(c *Config) interface{} { return &c.WSSendBuffer }},
+	{Env: "WEBHOOK_MAX_ATTEMPTS", Type: "int", Default: "8", Description: "Delivery attempts per webhook event before it is marked failed", Min: bound(1), Max: bound(50),
+		field: func(c *Config) interface{} { return &c.WebhookMaxAttempts }},
+	{Env: "WEBHOOK_BACKOFF_BASE_SEC", Type: "int", Default: "5", Description: "Wait before the first webhook retry, in seconds; doubles after each failed attempt", Min: bound(1),
+		field: func(c *Config) interface{} { return &c.WebhookBackoffBaseSec }},
+	{Env: "WEBHOOK_BACKOFF_MAX_SEC", Type: "int", Default: "3600", Description: "Longest wait between webhook retries, in seconds", Min: bound(1),
+		field: func(c *Config) interface{} { return &c.WebhookBackoffMaxSec }},
+	{Env: "WEBHOOK_TIMEOUT_SEC", Type: "int", Default: "10", Description: "How long a subscriber has to answer a webhook delivery, in seconds", Min: bound(1), Max: bound(60),
+		field: func(c *Config) interface{} { return &c.WebhookTimeoutSec }},
+	{Env: "WEBHOOK_SECRET_GRACE_SEC", Type: "int", Default: "86400", Description: "How long after a webhook's signing secret is rotated deliveries start being signed with the new one, in seconds", Min: bound(0),
+		field: func(c *Config) interface{} { return &c.WebhookSecretGraceSec }},
+	{Env: "WEBHOOK_ALLOW_PRIVATE_URLS", Type: "bool", Default: "false", Description: "Accept and deliver to webhook URLs on loopback, link-local and private addresses, for local demos",
+		field: func(c *Config) interface{} { return &c.WebhookAllowPrivateURLs }},
+	{Env: "RECEIPT_EMAILS", Type: "bool", Default: "false", Description: "Email a receipt to the paying account's address when a transaction settles",
+		field: func(c *Config) interface{} { return &c.ReceiptEmails }},
+	{Env: "RECEIPT_TEMPLATE_FILE", Type: "string", Default: "", Description: "text/template file defining the receipt email's subject and body templates; the built-in one is used when empty",
+		field: func(c *Config) interface{} { return &c.ReceiptTemplateFile }},
+	{Env: "RECEIPT_MAX_ATTEMPTS", Type: "int", Default: "5", Description: "Send attempts per receipt before it is marked failed; retries back off like webhook deliveries", Min: bound(1), Max: bound(50),
+		field: func(c *Config) interface{} { return &c.ReceiptMaxAttempts }},
+	{Env: "SMTP_ADDR", Type: "string", Default: "", Description: "SMTP server (host:port) receipts are sent through, e.g. mailpit:1025 in development",
+		field: func(c *Config) interface{} { return &c.SMTPAddr }},
+	{Env: "SMTP_FROM", Type: "string", Default: "PayFlow <receipts@payflow.local>", Description: "From address of receipt emails",
+		field: func(c *Config) interface{} { return &c.SMTPFrom }},
+	{Env: "SMTP_USERNAME", Type: "string", Default: "", Description: "SMTP user for PLAIN authentication; no authentication when empty",
+		field: func(c *Config) interface{} { return &c.SMTPUsername }},
+	{Env: "SMTP_PASSWORD", Type: "string", Default: "", Description: "SMTP password for SMTP_USERNAME", Secret: true,
+		field: func(c *Config) interface{} { return &c.SMTPPassword }},
+	{Env: "EVENT_BUS", Type: "string", Default: "none", Description: "Where transaction and fraud events are published for downstream consumers: kafka, nats or none", Enum: []string{"kafka", "nats", "none"},
+		field: func(c *Config) interface{} { return &c.EventBus }},
+	{Env: "EVENT_OUTBOX", Type: "bool", Default: "true", Description: "Queue bus events in Postgres and publish them from a relay that checkpoints its cursor, so a restart resumes where it left off; false publishes directly",
+		field: func(c *Config) interface{} { return &c.EventOutbox }},
+	{Env: "EVENT_SCHEMA_VALIDATION", Type: "string", Default: "log", Description: "Check outgoing webhook and event bus payloads against their schemas: off, log violations, or enforce by not sending them", Enum: []string{"off", "log", "enforce"},
+		field: func(c *Config) interface{} { return &c.EventSchemaValidation }},
+	{Env: "KAFKA_BROKERS", Type: "string", Default: "", Description: "Comma-separated Kafka brokers (host:port) used when EVENT_BUS is kafka", Pattern: `^([^,\s]+(,[^,\s]+)*)?$`,
+		field: func(c *Config) interface{} { return &c.KafkaBrokers }},
+	{Env: "KAFKA_TOPIC_TRANSACTIONS", Type: "string", Default: "payflow.transactions", Description: "Kafka topic for transaction.created events", Pattern: `^[a-zA-Z0-9._-]{1,249}$`,
+		field: func(c *Config) interface{} { return &c.KafkaTopicTransactions }},
+	{Env: "KAFKA_TOPIC_FRAUD_ALERTS", Type: "string", Default: "payflow.fraud-alerts", Description: "Kafka topic for fraud.alert.raised events", Pattern: `^[a-zA-Z0-9._-]{1,249}$`,
+		field: func(c *Config) interface{} { return &c.KafkaTopicFraudAlerts }},
+	{Env: "NATS_URL", Type: "string", Default: "", Description: "NATS server URL(s), comma-separated, used when EVENT_BUS is nats (e.g. nats://nats:4222)",
+		field: func(c *Config) interface{} { return &c.NATSURL }},
+	{Env: "NATS_SUBJECT_TRANSACTIONS", Type: "string", Default: "payflow.transactions", Description: "NATS subject for transaction.created events", Pattern: `^[a-zA-Z0-9_-]+(\.[a-zA-Z0-9_-]+)*$`,
+		field: func(c *Config) interface{} { return &c.NATSSubjectTransactions }},
+	{Env: "NATS_SUBJECT_FRAUD_ALERTS", Type: "string", Default: "payflow.fraud-alerts", Description: "NATS subject for fraud.alert.raised events", Pattern: `^[a-zA-Z0-9_-]+(\.[a-zA-Z0-9_-]+)*$`,
+		field: func(c *Config) interface{} { return &c.NATSSubjectFraudAlerts }},
+	{Env: "NATS_JETSTREAM", Type: "bool", Default: "false", Description: "Publish through JetStream so events are persisted and acknowledged, instead of fire-and-forget core NATS",
+		field: func(c *Config) interface{} { return &c.NATSJetStream }},
+	{Env: "NATS_STREAM", Type: "string", Default: "PAYFLOW_EVENTS", Description: "JetStream stream events are stored in; created over both subjects if it doesn't exist", Pattern: `^[a-zA-Z0-9_-]{1,64}$`,
+		field: func(c *Config) interface{} { return &c.NATSStream }},
+	{Env: "SPOOL_PATH", Type: "string", Default: "/tmp/payflow-spool.db", Description: "File used to spool transactions while Postgres is unreachable",
+		field: func(c *Config) interface{} { return &c.SpoolPath }},
+	{Env: "SPOOL_REPLAY_INTERVAL_SEC", Type: "int", Default: "5", Description: "How often spooled transactions are replayed, in seconds", Min: bound(1),
+		field: func(c *Config) interface{} { return &c.SpoolReplaySec }},
+	{Env: "BACKPRESSURE_DB_POOL_RATIO", Type: "float", Default: "0.9", Description: "Reject writes with 429 when this fraction of the DB pool is in use (0 disables)", Min: bound(0), Max: bound(1),
+		field: func(c *Config) interface{} { return &c.BackpressureDBPoolRatio }},
+	{Env: "BACKPRESSURE_SPOOL_MAX_DEPTH", Type: "int", Default: "10000", Description: "Reject writes with 429 once this many transactions are spooled (0 disables)", Min: bound(0),
+		field: func(c *Config) interface{} { return &c.BackpressureSpoolMaxDepth }},
+	{Env: "LEDGER_SIGNING_KEY", Type: "string", Default: "", Description: "HMAC key for the transaction hash chain; plain SHA-256 when empty", Secret: true,
+		field: func(c *Config) interface{} { return &c.LedgerSigningKey }},
+	{Env: "EOD_CLOSE_INTERVAL_SEC", Type: "int", Default: "300", Description: "How often finished days are closed and closed days are checked for later changes, in seconds; 0 disables the end-of-day job", Min: bound(0),
+		field: func(c *Config) interface{} { return &c.EODCloseIntervalSec }},
+	{Env: "EOD_CLOSE_DELAY_MIN", Type: "int", Default: "60", Description: "Minutes past midnight UTC before the previous day is closed, so spooled payments can land first", Min: bound(0), Max: bound(1440),
+		field: func(c *Config) interface{} { return &c.EODCloseDelayMin }},
+	{Env: "EOD_EXCEPTION_LOOKBACK_DAYS", Type: "int", Default: "35", Description: "How many days back closed days are checked for changes made after their close", Min: bound(1),
+		field: func(c *Config) interface{} { return &c.EODExceptionLookbackDays }},
+	{Env: "BANK_URL", Type: "string", Default: "", Description: "Base URL of the bank API closed days are settled with, such as cmd/mockbank; settlement is off when empty",
+		field: func(c *Config) interface{} { return &c.BankURL }},
+	{Env: "BANK_TIMEOUT_MS", Type: "int", Default: "3000", Description: "How long to wait for one bank API request", Min: bound(100), Max: bound(60000),
+		field: func(c *Config) interface{} { return &c.BankTimeoutMs }},
+	{Env: "BANK_SETTLEMENT_ACCOUNT", Type: "string", Default: "payflow-settlement", Description: "Account at the bank each closed day's settled volume is transferred to",
+		field: func(c *Config) interface{} { return &c.BankSettlementAccount }},
+	{Env: "OUTBOUND_MAX_IDLE_PER_HOST", Type: "int", Default: "16", Description: "Idle connections kept open to each host outbound integrations call", Min: bound(1), Max: bound(1000),
+		field: func(c *Config) interface{} { return &c.OutboundMaxIdlePerHost }},
+	{Env: "OUTBOUND_BREAKER_FAILURES", Type: "int", Default: "5", Description: "Consecutive failed calls to a host before its circuit breaker opens; 0 disables the breakers", Min: bound(0),
+		field: func(c *Config) interface{} { return &c.OutboundBreakerFailures }},
+	{Env: "OUTBOUND_BREAKER_COOLDOWN_SEC", Type: "int", Default: "30", Description: "How long an open circuit breaker fails calls before letting a trial one through", Min: bound(1), Max: bound(3600),
+		field: func(c *Config) interface{} { return &c.OutboundBreakerCooldownSec }},
+	{Env: "ADMIN_TOKEN", Type: "string", Default: "", Description: "Token required in X-Admin-Token for admin endpoints; admin endpoints are open when empty", Secret: true,
+		field: func(c *Config) interface{} { return &c.AdminToken }},
+	{Env: "OAUTH_CLIENTS", Type: "string", Default: "", Description: "Client credentials clients as id:secret:scope scope;id2:secret2:scope", Secret: true,
+		field: func(c *Config) interface{} { return &c.OAuthClients }},
+	{Env: "OAUTH_SIGNING_KEY", Type: "string", Default: "", Description: "HS256 key for issued access tokens; an ephemeral key is generated when empty", Secret: true,
+		field: func(c *Config) interface{} { return &c.OAuthSigningKey }},
+	{Env: "CURSOR_SIGNING_KEY", Type: "string", Default: "", Description: "HMAC key for list pagination cursors; an ephemeral key is generated when empty", Secret: true,
+		field: func(c *Config) interface{} { return &c.CursorSigningKey }},
+	{Env: "OAUTH_ISSUER", Type: "string", Default: "payflow", Description: "Issuer and audience of issued access tokens",
+		field: func(c *Config) interface{} { return &c.OAuthIssuer }},
+	{Env: "OAUTH_TOKEN_TTL_SEC", Type: "int", Default: "300", Description: "Lifetime of issued access tokens in seconds", Min: bound(30), Max: bound(86400),
+		field: func(c *Config) interface{} { return &c.OAuthTokenTTLSec }},
+	{Env: "OAUTH_REQUIRED", Type: "bool", Default: "false", Description: "Reject /api requests without a valid bearer token or API key",
+		field: func(c *Config) interface{} { return &c.OAuthRequired }},
+	{Env: "DEMO_TOKENS_ENABLED", Type: "bool", Default: "false", Description: "Serve POST /oauth/demo-token, which issues viewer, operator or admin tokens to anyone; demos only",
+		field: func(c *Config) interface{} { return &c.DemoTokensEnabled }},
+	{Env: "ENABLE_PPROF", Type: "bool", Default: "false", Description: "Serve Go runtime profiles under /debug/pprof to admin callers",
+		field: func(c *Config) interface{} { return &c.EnablePprof }},
+	{Env: "POLICY_ENGINE", Type: "string", Default: "off", Description: "Who authorizes admin and fraud actions: the built-in admin check, or an Open Policy Agent sidecar", Enum: []string{"off", "opa"},
+		field: func(c *Config) interface{} { return &c.PolicyEngine }},
+	{Env: "OPA_URL", Type: "string", Default: "http://localhost:8181", Description: "Base URL of the OPA sidecar, read when POLICY_ENGINE is opa",
+		field: func(c *Config) interface{} { return &c.OPAURL }},
+	{Env: "OPA_POLICY_PATH", Type: "string", Default: "payflow/authz/allow", Description: "Boolean rule queried through OPA's data API",
+		field: func(c *Config) interface{} { return &c.OPAPolicyPath }},
+	{Env: "POLICY_FALLBACK", Type: "string", Default: "deny", Description: "Decision when OPA can't be reached: allow, deny, or the built-in admin check", Enum: []string{"allow", "deny", "builtin"},
+		field: func(c *Config) interface{} { return &c.PolicyFallback }},
+	{Env: "POLICY_TIMEOUT_MS", Type: "int", Default: "250", Description: "How long to wait for an OPA decision before falling back", Min: bound(10), Max: bound(10000),
+		field: func(c *Config) interface{} { return &c.PolicyTimeoutMs }},
+	{Env: "SIGNATURE_MAX_SKEW_SEC", Type: "int", Default: "300", Description: "How far a signed request's timestamp may be from the server clock", Min: bound(1), Max: bound(3600),
+		field: func(c *Config) interface{} { return &c.SignatureMaxSkewSec }},
+	{Env: "OIDC_ISSUER", Type: "string", Default: "", Description: "Issuer URL of an external OIDC provider for operator tokens; disabled when empty",
+		field: func(c *Config) interface{} { return &c.OIDCIssuer }},
+	{Env: "OIDC_AUDIENCE", Type: "string", Default: "", Description: "Expected audience (client ID) of OIDC tokens",
+		field: func(c *Config) interface{} { return &c.OIDCAudience }},
+	{Env: "OIDC_JWKS_URL", Type: "string", Default: "", Description: "JWKS URL; discovered from the issuer when empty",
+		field: func(c *Config) interface{} { return &c.OIDCJWKSURL }},
+	{Env: "OIDC_GROUPS_CLAIM", Type: "string", Default: "groups", Description: "Token claim holding the operator's groups",
+		field: func(c *Config) interface{} { return &c.OIDCGroupsClaim }},
+	{Env: "OIDC_ROLE_MAP", Type: "string", Default: "", Description: "Group to role mapping as group=role,group2=role2 (roles: viewer, operator, admin, fraud_analyst, detokenize)",
+		field: func(c *Config) interface{} { return &c.OIDCRoleMap }},
+	{Env: "OIDC_JWKS_CACHE_SEC", Type: "int", Default: "3600", Description: "How long fetched signing keys are cached, in seconds", Min: bound(60),
+		field: func(c *Config) interface{} { return &c.OIDCJWKSCacheSec }},
+	{Env: "DEMO_SESSION_TTL_SEC", Type: "int", Default: "3600", Description: "Default lifetime of a demo session when the create request gives no ttl_sec", Min: bound(60),
+		field: func(c *Config) interface{} { return &c.DemoSessionTTLSec }},
+	{Env: "INCIDENT_PROVIDER", Type: "string", Default: "none", Description: "On-call provider that incidents are opened in", Enum: []string{"none", "pagerduty", "opsgenie"},
+		field: func(c *Config) interface{} { return &c.IncidentProvider }},
+	{Env: "INCIDENT_ROUTING_KEY", Type: "string", Default: "", Description: "PagerDuty integration routing key or Opsgenie API key", Secret: true,
+		field: func(c *Config) interface{} { return &c.IncidentRoutingKey }},
+	{Env: "INCIDENT_API_URL", Type: "string", Default: "", Description: "Override the provider API base URL, e.g. to point at a local mock",
+		field: func(c *Config) interface{} { return &c.IncidentAPIURL }},
+	{Env: "INCIDENT_DRY_RUN", Type: "bool", Default: "false", Description: "Log incident events instead of sending them",
+		field: func(c *Config) interface{} { return &c.IncidentDryRun }},
+	{Env: "INCIDENT_CHECK_INTERVAL_SEC", Type: "int", Default: "15", Description: "How often critical conditions are evaluated, in seconds", Min: bound(1),
+		field: func(c *Config) interface{} { return &c.IncidentCheckIntervalSec }},
+	{Env: "INCIDENT_READINESS_MINUTES", Type: "int", Default: "5", Description: "Minutes readiness must fail before an incident is opened", Min: bound(1),
+		field: func(c *Config) interface{} { return &c.IncidentReadinessMinutes }},
+	{Env: "INCIDENT_PANICS_PER_MIN", Type: "float", Default: "3", Description: "Recovered panics per minute, averaged over 5 minutes, that open an incident", Min: bound(0.2),
+		field: func(c *Config) interface{} { return &c.IncidentPanicsPerMin }},
+	{Env: "INCIDENT_SLO_TARGET", Type: "float", Default: "0.999", Description: "Availability SLO (fraction of non-5xx responses) used for burn rate alerts", Min: bound(0.5), Max: bound(0.99999),
+		field: func(c *Config) interface{} { return &c.IncidentSLOTarget }},
+	{Env: "INCIDENT_BURN_RATE", Type: "float", Default: "14.4", Description: "Error budget burn rate over both 5m and 1h that opens a fast-burn incident", Min: bound(1),
+		field: func(c *Config) interface{} { return &c.IncidentBurnRate }},
+	{Env: "ANOMALY_WINDOW_SEC", Type: "int", Default: "60", Description: "Length of each business-metric window fed to the anomaly detector, in seconds", Min: bound(1),
+		field: func(c *Config) interface{} { return &c.AnomalyWindowSec }},
+	{Env: "ANOMALY_ALPHA", Type: "float", Default: "0.3", Description: "EWMA smoothing factor for anomaly baselines", Min: bound(0.01), Max: bound(1),
+		field: func(c *Config) interface{} { return &c.AnomalyAlpha }},
+	{Env: "ANOMALY_Z_THRESHOLD", Type: "float", Default: "3", Description: "Absolute z-score above which a window is reported as an anomaly", Min: bound(0.5),
+		field: func(c *Config) interface{} { return &c.AnomalyZThreshold }},
+	{Env: "ANOMALY_WARMUP_WINDOWS", Type: "int", Default: "5", Description: "Windows observed before anomalies are reported", Min: bound(1),
+		field: func(c *Config) interface{} { return &c.AnomalyWarmupWindows }},
+	{Env: "INJECT_OOM", Type: "bool", Default: "false", Description: "Chaos: grow memory until the process is killed", Chaos: true,
+		field: func(c *Config) interface{} { return &c.InjectOOM }},
+	{Env: "INJECT_LATENCY_MS", Type: "int", Default: "0", Description: "Chaos: latency added to every request in milliseconds", Min: bound(0), Max: bound(60000), Overridable: true, Chaos: true,
+		field: func(c *Config) interface{} { return &c.InjectLatencyMs }},
+	{Env: "INJECT_ERROR_RATE", Type: "float", Default: "0", Description: "Chaos: fraction of requests that fail with 500", Min: bound(0), Max: bound(1), Overridable: true, Chaos: true,
+		field: func(c *Config) interface{} { return &c.InjectErrorRate }},
+	{Env: "INJECT_CPU_BURN", Type: "bool", Default: "false", Description: "Chaos: spin a busy loop", Chaos: true,
+		field: func(c *Config) interface{} { return &c.InjectCPUBurn }},
+	{Env: "INJECT_PANIC", Type: "bool", Default: "false", Description: "Chaos: panic on a fraction of requests", Overridable: true, Chaos: true,
+		field: func(c *Config) interface{} { return &c.InjectPanic }},
+	{Env: "INJECT_DB_TIMEOUT", Type: "bool", Default: "false", Description: "Chaos: stall transaction list queries", Overridable: true, Chaos: true,
+		field: func(c *Config) interface{} { return &c.InjectDBTimeout }},
+	{Env: "INJECT_REPLICATION_LAG_MS", Type: "int", Default: "0", Description: "Chaos: hide transactions from other regions from reads until they are this old", Min: bound(0), Overridable: true, Chaos: true,
+		field: func(c *Config) interface{} { return &c.InjectReplicationLagMs }},
+	{Env: "INJECT_SLOW_QUERY_RATE", Type: "float", Default: "0", Description: "Chaos: fraction of database statements the server sleeps INJECT_SLOW_QUERY_MS before", Min: bound(0), Max: bound(1), Overridable: true, Chaos: true,
+		field: func(c *Config) interface{} { return &c.InjectSlowQueryRate }},
+	{Env: "INJECT_SLOW_QUERY_MS", Type: "int", Default: "2000", Description: "Chaos: pg_sleep added to slow statements in milliseconds", Min: bound(1), Max: bound(60000), Overridable: true, Chaos: true,
+		field: func(c *Config) interface{} { return &c.InjectSlowQueryMs }},
+	{Env: "INJECT_GOROUTINE_LEAK_PER_SEC", Type: "int", Default: "0", Description: "Chaos: goroutines started per second that never exit", Min: bound(0), Max: bound(10000), Chaos: true,
+		field: func(c *Config) interface{} { return &c.InjectGoroutineLeakPerSec }},
+	{Env: "INJECT_POOL_EXHAUSTION", Type: "int", Default: "0", Description: "Chaos: primary pool connections checked out and never returned", Min: bound(0), Max: bound(1000), Chaos: true,
+		field: func(c *Config) interface{} { return &c.InjectPoolExhaustion }},
+	{Env: "INJECT_DROP_RATE", Type: "float", Default: "0", Description: "Chaos: fraction of requests handled and then answered by closing the connection", Min: bound(0), Max: bound(1), Overridable: true, Chaos: true,
+		field: func(c *Config) interface{} { return &c.InjectDropRate }},
+	{Env: "CHAOS_SCENARIOS_FILE", Type: "string", Default: "", Description: "YAML or JSON file of timed chaos scenarios that can be run by name",
+		field: func(c *Config) interface{} { return &c.ChaosScenariosFile }},
+	{Env: "CHAOS_SCENARIO", Type: "string", Default: "", Description: "Scenario from CHAOS_SCENARIOS_FILE to run at startup",
+		field: func(c *Config) interface{} { return &c.ChaosScenario }},
+	{Env: "API_DEFAULT_VERSION", Type: "string", Default: "1", Description: "Response shape for requests without X-API-Version: 1 bare, 2 data/meta/error envelope", Enum: []string{"1", "2"},
+		field: func(c *Config) interface{} { return &c.APIDefaultVersion }},
+	{Env: "API_V2_FIELD_NAMING", Type: "string", Default: "snake_case", Description: "Field naming of version 2 JSON responses under /api; version 1 always uses snake_case", Enum: []string{"snake_case", "camelCase"},
+		field: func(c *Config) interface{} { return &c.APIV2FieldNaming }},
+}
+
+// Error collects every problem found while loading configuration so they
+// can be reported together instead of one restart at a time.
+type Error struct {
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("invalid configuration (%d problems): %s", len(e.Problems), strings.Join(e.Problems, "; "))
+}
+
+// Setting sources, in increasing order of precedence.
+const (
+	SourceDefault  = "default"
+	SourceFile     = "file"
+	SourceEnv      = "env"
+	SourceRuntime  = "runtime"
+	SourceOverride = "override"
+)
+
+// Check is a validation rule spanning more than one setting, returning a
+// problem for each violation. Load runs the built-in rules and any given.
+type Check func(c *Config) []string
+
+// Load reads every setting in Schema from, in increasing order of
+// precedence, its default, CONFIG_FILE and the environment, and validates
+// the result. The returned Error lists every problem found; the config is
+// returned along with it as far as it could be read.
+func Load(checks ...Check) (*Config, error) {
+	config := &Config{sources: map[string]string{}}
+	var problems []string
+
+	file := map[string]string{}
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		var err error
+		if file, err = readFile(path); err != nil {
+			return config, &Error{Problems: []string{"CONFIG_FILE: " + err.Error()}}
+		}
+	}
+
+	for _, f := range Schema {
+		raw, source := f.Default, SourceDefault
+		if val, ok := file[f.Env]; ok {
+			raw, source = val, SourceFile
+		}
+		if val := os.Getenv(f.Env); val != "" {
+			raw, source = val, SourceEnv
+		}
+		if err := f.Set(config, raw); err != nil {
+			problems = append(problems, fmt.Sprintf("%s=%q: %v", f.Env, raw, err))
+		}
+		config.sources[f.Env] = source
+	}
+
+	problems = append(problems, config.Problems(checks...)...)
+
+	if len(problems) > 0 {
+		return config, &Error{Problems: problems}
+	}
+	return config, nil
+}
+
+// readFile parses a dotenv-style file of KEY=VALUE lines. Blank lines and
+// lines starting with # are ignored; values may be quoted.
+func readFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := map[string]string{}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, val, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", n)
+		}
+		val = strings.TrimSpace(val)
+		if len(val) >= 2 && (val[0] == '"' || val[0] == '\'') && val[len(val)-1] == val[0] {
+			val = val[1 : len(val)-1]
+		}
+		values[strings.TrimSpace(key)] = val
+	}
+	return values, sc.Err()
+}
+
+// Problems checks the rules that span more than one setting, the built-in
+// ones and checks.
+func (c *Config) Problems(checks ...Check) []string {
+	var problems []string
+	if c.OIDCIssuer != "" && c.OIDCAudience == "" {
+		problems = append(problems, "OIDC_AUDIENCE is required when OIDC_ISSUER is set")
+	}
+	if c.IncidentProvider != "none" && !c.IncidentDryRun && c.IncidentRoutingKey == "" {
+		problems = append(problems, "INCIDENT_ROUTING_KEY is required when INCIDENT_PROVIDER is set, unless INCIDENT_DRY_RUN is true")
+	}
+	if c.PolicyEngine == "opa" && c.OPAURL == "" {
+		problems = append(problems, "OPA_URL is required when POLICY_ENGINE is opa")
+	}
+	if c.EnrichmentSource == "http" && c.EnrichmentURL == "" {
+		problems = append(problems, "ENRICHMENT_URL is required when ENRICHMENT_SOURCE is http")
+	}
+	if c.DepRetryMaxMs < c.DepRetryInitialMs {
+		problems = append(problems, "DEP_RETRY_MAX_MS must be at least DEP_RETRY_INITIAL_MS")
+	}
+	if c.FailoverRole != "none" && c.FailoverStaleSec <= c.FailoverHeartbeatSec {
+		problems = append(problems, "FAILOVER_STALE_SEC must be greater than FAILOVER_HEARTBEAT_SEC")
+	}
+	if c.FailoverRole != "none" && c.CacheMode != "redis" {
+		problems = append(problems, "FAILOVER_ROLE requires CACHE_MODE=redis")
+	}
+	if c.RegistryEnabled && c.CacheMode != "redis" {
+		problems = append(problems, "REGISTRY_ENABLED requires CACHE_MODE=redis")
+	}
+	if c.EventBus == "kafka" && c.KafkaBrokers == "" {
+		problems = append(problems, "KAFKA_BROKERS is required when EVENT_BUS is kafka")
+	}
+	if c.EventBus == "nats" && c.NATSURL == "" {
+		problems = append(problems, "NATS_URL is required when EVENT_BUS is nats")
+	}
+	if c.WebhookBackoffMaxSec < c.WebhookBackoffBaseSec {
+		problems = append(problems, "WEBHOOK_BACKOFF_MAX_SEC must be at least WEBHOOK_BACKOFF_BASE_SEC")
+	}
+	if c.ReceiptEmails && c.SMTPAddr == "" {
+		problems = append(problems, "SMTP_ADDR is required when RECEIPT_EMAILS is true")
+	}
+	if c.RegistryEnabled && c.RegistryTTLSec <= c.RegistryRefreshSec {
+		problems = append(problems, "REGISTRY_TTL_SEC must be greater than REGISTRY_REFRESH_SEC")
+	}
+	if c.TokenizationEnabled && len(c.TokenVaultKey) < 16 {
+		problems = append(problems, "TOKEN_VAULT_KEY of at least 16 characters is required when TOKENIZATION_ENABLED is true")
+	}
+	if c.FraudRulesSource == "file" && c.FraudRulesFile == "" {
+		problems = append(problems, "FRAUD_RULES_FILE is required when FRAUD_RULES_SOURCE is file")
+	}
+	if c.FraudBlockScore <= c.FraudReviewScore {
+		problems = append(problems, "FRAUD_BLOCK_SCORE must be greater than FRAUD_REVIEW_SCORE")
+	}
+	if c.FraudWorkersMax > 0 && c.FraudWorkersMax < c.FraudWorkers {
+		problems = append(problems, "FRAUD_WORKERS_MAX must be 0 or at least FRAUD_WORKERS")
+	}
+	for _, check := range checks {
+		problems = append(problems, check(c)...)
+	}
+	return problems
+}
+
+// Set parses raw, validates it against f and stores it in c.
+func (f Field) Set(c *Config, raw string) error {
+	var num float64
+	switch p := f.field(c).(type) {
+	case *string:
+		if f.Pattern != "" && !regexp.MustCompile(f.Pattern).MatchString(raw) {
+			return fmt.Errorf("must match %s", f.Pattern)
+		}
+		if len(f.Enum) > 0 && !containsString(f.Enum, raw) {
+			return fmt.Errorf("must be one of %s", strings.Join(f.Enum, ", "))
+		}
+		*p = raw
+		return nil
+	case *bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("must be a boolean")
+		}
+		*p = b
+		return nil
+	case *int:
+		i, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("must be an integer")
+		}
+		*p = i
+		num = float64(i)
+	case *float64:
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("must be a number")
+		}
+		*p = v
+		num = v
+	default:
+		return fmt.Errorf("unsupported field type %T", p)
+	}
+
+	if f.Min != nil && num < *f.Min {
+		return fmt.Errorf("must be >= %v", *f.Min)
+	}
+	if f.Max != nil && num > *f.Max {
+		return fmt.Errorf("must be <= %v", *f.Max)
+	}
+	return nil
+}
+
+// Value returns f's setting in c.
+func (f Field) Value(c *Config) interface{} {
+	switch p := f.field(c).(type) {
+	case *string:
+		return *p
+	case *bool:
+		return *p
+	case *int:
+		return *p
+	case *float64:
+		return *p
+	}
+	return nil
+}
+
+// Summary returns the effective configuration keyed by env var with secrets
+// masked, suitable for logging at startup.
+func (c *Config) Summary() map[string]interface{} {
+	out := make(map[string]interface{}, len(Schema))
+	for _, f := range Schema {
+		if f.Secret {
+			out[f.Env] = MaskSecret(fmt.Sprint(f.Value(c)))
+			continue
+		}
+		out[f.Env] = f.Value(c)
+	}
+	return out
+}
+
+// SettingValue is one effective setting as reported by the verbose config API.
+type SettingValue struct {
+	Env     string      `json:"env"`
+	Value   interface{} `json:"value"`
+	Default string      `json:"default"`
+	Source  string      `json:"source"`
+	Secret  bool        `json:"secret,omitempty"`
+}
+
+// Settings lists every setting in schema order with its source. Secrets are
+// masked, so the result is safe to return to an operator.
+func (c *Config) Settings() []SettingValue {
+	out := make([]SettingValue, 0, len(Schema))
+	for _, f := range Schema {
+		v := SettingValue{Env: f.Env, Value: f.Value(c), Default: f.Default, Source: c.sources[f.Env], Secret: f.Secret}
+		if v.Source == "" {
+			v.Source = SourceDefault
+		}
+		if f.Secret {
+			v.Value = MaskSecret(fmt.Sprint(v.Value))
+			v.Default = MaskSecret(v.Default)
+		}
+		out = append(out, v)
+	}
+	return out
+}
+
+// MaskSecret hides a secret value, leaving it recognizably unset when empty.
+func MaskSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "********"
+}
+
+// Clone returns a copy of c that can be changed, sources included, without
+// affecting c.
+func (c *Config) Clone() *Config {
+	next := *c
+	next.sources = make(map[string]string, len(c.sources))
+	for k, v := range c.sources {
+		next.sources[k] = v
+	}
+	return &next
+}
+
+// Source reports where the setting named env came from.
+func (c *Config) Source(env string) string {
+	if s := c.sources[env]; s != "" {
+		return s
+	}
+	return SourceDefault
+}
+
+// SetSource records that the setting named env was changed by source.
+func (c *Config) SetSource(env, source string) {
+	if c.sources == nil {
+		c.sources = map[string]string{}
+	}
+	c.sources[env] = source
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// clearEnv leaves every setting at its default.
+func clearEnv(t *testing.T) {
+	t.Helper()
+	for _, f := range Schema {
+		t.Setenv(f.Env, "")
+	}
+	t.Setenv("CONFIG_FILE", "")
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	clearEnv(t)
+	path := filepath.Join(t.TempDir(), "payflow.env")
+	if err := os.WriteFile(path, []byte("# comment\nPORT=9000\nLOG_LEVEL=\"warn\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("LOG_LEVEL", "debug")
+
+	c, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Port != "9000" || c.Source("PORT") != SourceFile {
+		t.Errorf("PORT = %q from %s, want 9000 from the file", c.Port, c.Source("PORT"))
+	}
+	if c.LogLevel != "debug" || c.Source("LOG_LEVEL") != SourceEnv {
+		t.Errorf("LOG_LEVEL = %q from %s, want debug from the environment", c.LogLevel, c.Source("LOG_LEVEL"))
+	}
+	if c.Region == "" || c.Source("REGION") != SourceDefault {
+		t.Errorf("REGION = %q from %s, want the default", c.Region, c.Source("REGION"))
+	}
+}
+
+func TestLoadCollectsProblems(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("PORT", "http")
+	t.Setenv("LOG_LEVEL", "loud")
+	t.Setenv("FRAUD_REVIEW_SCORE", "90")
+	t.Setenv("FRAUD_BLOCK_SCORE", "80")
+	check := func(c *Config) []string { return []string{"from check"} }
+
+	_, err := Load(check)
+	var cfgErr *Error
+	if !errors.As(err, &cfgErr) {
+		t.Fatalf("err = %v, want *Error", err)
+	}
+	got := strings.Join(cfgErr.Problems, "\n")
+	for _, want := range []string{"PORT", "LOG_LEVEL", "FRAUD_BLOCK_SCORE must be greater", "from check"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("problems %q don't mention %q", got, want)
+		}
+	}
+}
+
+func TestCloneIsIndependent(t *testing.T) {
+	clearEnv(t)
+	base, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := base.Clone()
+	for _, f := range Schema {
+		if f.Env != "LOG_LEVEL" {
+			continue
+		}
+		if err := f.Set(next, "error"); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Set(next, "verbose"); err == nil {
+			t.Error("LOG_LEVEL accepted a value outside its enum")
+		}
+	}
+	next.SetSource("LOG_LEVEL", SourceRuntime)
+	if base.LogLevel == "error" || base.Source("LOG_LEVEL") != SourceDefault {
+		t.Errorf("base changed to %q from %s", base.LogLevel, base.Source("LOG_LEVEL"))
+	}
+	if next.LogLevel != "error" || next.Source("LOG_LEVEL") != SourceRuntime {
+		t.Errorf("clone = %q from %s, want error from runtime", next.LogLevel, next.Source("LOG_LEVEL"))
+	}
+}
+
+func TestMaskSecret(t *testing.T) {
+	if got := MaskSecret(""); got != "" {
+		t.Errorf("MaskSecret(\"\") = %q", got)
+	}
+	if got := MaskSecret("hunter2hunter2"); strings.Contains(got, "hunter2") {
+		t.Errorf("MaskSecret leaked the secret: %q", got)
+	}
+}
@@ -0,0 +1,226 @@
+// Package dbtest is a database/sql driver for tests. A DB hands every
+// statement to a Handler, which answers it from whatever state the test
+// keeps, and records it so tests can check the SQL that was sent. A handler
+// should fail statements it doesn't know, so tests notice when the code
+// they cover starts sending something else.
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+// Query is a statement sent to a DB. SQL has its whitespace collapsed to
+// single spaces.
+type Query struct {
+	SQL  string
+	Args []driver.Value
+}
+
+// HasPrefix reports whether the statement starts with prefix, compared with
+// collapsed whitespace.
+func (q Query) HasPrefix(prefix string) bool {
+	return strings.HasPrefix(q.SQL, collapse(prefix))
+}
+
+// Contains reports whether the statement contains s, compared with
+// collapsed whitespace.
+func (q Query) Contains(s string) bool {
+	return strings.Contains(q.SQL, collapse(s))
+}
+
+// String returns argument i as a string, or "" when it is NULL or not one.
+func (q Query) String(i int) string {
+	s, _ := q.Args[i].(string)
+	return s
+}
+
+// IDs returns argument i, a Postgres text array, as a set.
+func (q Query) IDs(i int) map[string]bool {
+	var ids pq.StringArray
+	ids.Scan(q.Args[i])
+	set := map[string]bool{}
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
+
+// Handler answers a statement. For Exec the rows are discarded and their
+// RowsAffected reported.
+type Handler func(q Query) (*Rows, error)
+
+// Unexpected is the error for a statement a handler doesn't answer.
+func Unexpected(q Query) error {
+	return fmt.Errorf("dbtest: unexpected statement %q", q.SQL)
+}
+
+// Tx hooks are called as a database transaction begins, commits and rolls
+// back, for handlers that keep transactional state.
+type Tx struct {
+	Begin    func()
+	Commit   func()
+	Rollback func()
+}
+
+// DB is a fake database. Use Open to get a *sql.DB over it.
+type DB struct {
+	handle Handler
+	tx     Tx
+
+	mu      sync.Mutex
+	queries []Query
+}
+
+// New returns a DB answering statements with handle.
+func New(handle Handler) *DB {
+	return &DB{handle: handle}
+}
+
+// WithTx sets the hooks run around database transactions.
+func (d *DB) WithTx(tx Tx) *DB {
+	d.tx = tx
+	return d
+}
+
+// Open returns a *sql.DB over d, closed when the test ends. It has a single
+// connection, so statements reach the handler one at a time.
+func (d *DB) Open(t testing.TB) *sql.DB {
+	t.Helper()
+	db := sql.OpenDB(d)
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// Queries returns the statements sent so far.
+func (d *DB) Queries() []Query {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]Query(nil), d.queries...)
+}
+
+// Reset forgets the statements sent so far.
+func (d *DB) Reset() {
+	d.mu.Lock()
+	d.queries = nil
+	d.mu.Unlock()
+}
+
+func (d *DB) run(query string, named []driver.NamedValue) (*Rows, error) {
+	q := Query{SQL: collapse(query), Args: make([]driver.Value, len(named))}
+	for i, a := range named {
+		q.Args[i] = a.Value
+	}
+	d.mu.Lock()
+	d.queries = append(d.queries, q)
+	d.mu.Unlock()
+	return d.handle(q)
+}
+
+func collapse(s string) string { return strings.Join(strings.Fields(s), " ") }
+
+// Rows is a result set. Build it with NewRows and Add.
+type Rows struct {
+	cols     []string
+	rows     [][]driver.Value
+	affected int64
+	counted  bool
+}
+
+// NewRows returns an empty result set with the given columns.
+func NewRows(cols ...string) *Rows { return &Rows{cols: cols} }
+
+// None is an empty result set, answering Exec with one row affected.
+func None() *Rows { return NewRows("none") }
+
+// Affected is an empty result set answering Exec with n rows affected.
+func Affected(n int64) *Rows {
+	r := None()
+	r.affected, r.counted = n, true
+	return r
+}
+
+// Add appends a row.
+func (r *Rows) Add(values ...driver.Value) *Rows {
+	r.rows = append(r.rows, values)
+	return r
+}
+
+func (r *Rows) rowsAffected() int64 {
+	if r.counted {
+		return r.affected
+	}
+	return 1
+}
+
+func (r *Rows) Columns() []string { return r.cols }
+func (r *Rows) Close() error      { return nil }
+
+func (r *Rows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func (d *DB) Connect(context.Context) (driver.Conn, error) { return conn{d}, nil }
+func (d *DB) Driver() driver.Driver                        { return nil }
+
+type conn struct{ d *DB }
+
+func (c conn) Prepare(string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (c conn) Close() error                        { return nil }
+
+func (c conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c conn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	if c.d.tx.Begin != nil {
+		c.d.tx.Begin()
+	}
+	return tx{c.d}, nil
+}
+
+func (c conn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	rows, err := c.d.run(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(rows.rowsAffected()), nil
+}
+
+func (c conn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows, err := c.d.run(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+type tx struct{ d *DB }
+
+func (t tx) Commit() error {
+	if t.d.tx.Commit != nil {
+		t.d.tx.Commit()
+	}
+	return nil
+}
+
+func (t tx) Rollback() error {
+	if t.d.tx.Rollback != nil {
+		t.d.tx.Rollback()
+	}
+	return nil
+}
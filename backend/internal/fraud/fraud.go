@@ -0,0 +1,240 @@
+// Package fraud is PayFlow's fraud rules engine: the rule types, the specs
+// rules are configured with, compiled rule sets and the assessment of a
+// transaction against one. Where rules come from, and what happens to a
+// transaction once it is assessed, is up to the server.
+package fraud
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/infrasage/payflow/internal/store"
+)
+
+// Rule is one fraud check. Name, score and whether it is enabled live in the
+// RuleSpec it was built from; a Rule only decides whether it fires.
+type Rule interface {
+	// Evaluate reports whether the rule fires for in and, if so, why.
+	Evaluate(ctx context.Context, in *Input) (bool, string, error)
+}
+
+// RuleFactory builds a Rule of one type from its spec's params.
+type RuleFactory func(params map[string]interface{}) (Rule, error)
+
+var ruleTypes = map[string]RuleFactory{
+	"amount_threshold":  newAmountThresholdRule,
+	"velocity":          newVelocityRule,
+	"counterparty_risk": newCounterpartyRiskRule,
+	"round_amount":      newRoundAmountRule,
+}
+
+// RegisterRuleType makes a rule type available to rule files and the
+// fraud_rules table. Call it from an init function, before rules load.
+func RegisterRuleType(name string, factory RuleFactory) {
+	if _, ok := ruleTypes[name]; ok {
+		panic("fraud rule type registered twice: " + name)
+	}
+	ruleTypes[name] = factory
+}
+
+// RuleTypes returns the names of the registered rule types, sorted.
+func RuleTypes() []string {
+	names := make([]string, 0, len(ruleTypes))
+	for name := range ruleTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RuleSpec configures one rule. Enabled defaults to true when omitted.
+type RuleSpec struct {
+	Name    string                 `json:"name" yaml:"name"`
+	Type    string                 `json:"type" yaml:"type"`
+	Enabled *bool                  `json:"enabled,omitempty" yaml:"enabled"`
+	Score   float64                `json:"score" yaml:"score"`
+	Params  map[string]interface{} `json:"params,omitempty" yaml:"params"`
+}
+
+func (s RuleSpec) enabled() bool {
+	return s.Enabled == nil || *s.Enabled
+}
+
+// DefaultRuleSpecs is the rule set used when FRAUD_RULES_SOURCE is builtin.
+var DefaultRuleSpecs = []RuleSpec{
+	{Name: "large_amount", Type: "amount_threshold", Score: 40, Params: map[string]interface{}{"min_amount": 10000}},
+	{Name: "payer_velocity", Type: "velocity", Score: 30, Params: map[string]interface{}{"max_count": 5, "window_sec": 60}},
+	{Name: "high_risk_payee", Type: "counterparty_risk", Score: 50, Params: map[string]interface{}{"tiers": []interface{}{"high"}}},
+	{Name: "round_amount", Type: "round_amount", Score: 10, Params: map[string]interface{}{"multiple": 1000}},
+}
+
+type compiledRule struct {
+	spec RuleSpec
+	rule Rule
+}
+
+// RuleSet is an immutable, compiled set of rules. Version identifies its
+// content, so two loads of the same rules report the same version.
+type RuleSet struct {
+	Version  string     `json:"version"`
+	Source   string     `json:"source"`
+	LoadedAt time.Time  `json:"loaded_at"`
+	Specs    []RuleSpec `json:"rules"`
+	rules    []compiledRule
+}
+
+// Compile validates specs and builds their rules. Disabled rules are still
+// validated so a typo doesn't hide until someone enables them.
+func Compile(source string, specs []RuleSpec) (*RuleSet, error) {
+	set := &RuleSet{Source: source, LoadedAt: time.Now().UTC(), Specs: specs}
+	seen := map[string]bool{}
+	for _, spec := range specs {
+		if spec.Name == "" {
+			return nil, fmt.Errorf("rule without a name")
+		}
+		if seen[spec.Name] {
+			return nil, fmt.Errorf("rule %q defined twice", spec.Name)
+		}
+		seen[spec.Name] = true
+		factory, ok := ruleTypes[spec.Type]
+		if !ok {
+			return nil, fmt.Errorf("rule %q: unknown type %q", spec.Name, spec.Type)
+		}
+		if spec.Score < 0 {
+			return nil, fmt.Errorf("rule %q: score must not be negative", spec.Name)
+		}
+		rule, err := factory(spec.Params)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", spec.Name, err)
+		}
+		if spec.enabled() {
+			set.rules = append(set.rules, compiledRule{spec: spec, rule: rule})
+		}
+	}
+	canonical, err := json.Marshal(specs)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(canonical)
+	set.Version = hex.EncodeToString(sum[:6])
+	return set, nil
+}
+
+// Enabled returns the specs of the rules that run, in order.
+func (s *RuleSet) Enabled() []RuleSpec {
+	specs := make([]RuleSpec, 0, len(s.rules))
+	for _, r := range s.rules {
+		specs = append(specs, r.spec)
+	}
+	return specs
+}
+
+// VelocityLimit is the threshold of a velocity rule: it fires for a
+// transaction whose payer sent at least MaxCount others in the WindowSec
+// before it.
+type VelocityLimit struct {
+	Rule      string  `json:"rule"`
+	Score     float64 `json:"score"`
+	MaxCount  int     `json:"max_count"`
+	WindowSec int     `json:"window_sec"`
+}
+
+// VelocityLimits returns the thresholds of the velocity rules that run, in
+// order.
+func (s *RuleSet) VelocityLimits() []VelocityLimit {
+	var limits []VelocityLimit
+	for _, r := range s.rules {
+		if v, ok := r.rule.(*velocityRule); ok {
+			limits = append(limits, VelocityLimit{Rule: r.spec.Name, Score: r.spec.Score, MaxCount: v.MaxCount, WindowSec: v.WindowSec})
+		}
+	}
+	return limits
+}
+
+// decodeParams copies a spec's params into a typed struct. Unknown keys are
+// rejected so misspelled thresholds fail the load instead of being ignored.
+func decodeParams(params map[string]interface{}, into interface{}) error {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(into); err != nil {
+		return fmt.Errorf("invalid params: %w", err)
+	}
+	return nil
+}
+
+// Source answers the lookups rules need beyond the transaction itself.
+type Source interface {
+	// RecentPayments counts the payer's other transactions created within
+	// window before txn.
+	RecentPayments(ctx context.Context, txn store.Transaction, window time.Duration) (int, error)
+	// Payee returns counterparty metadata for txn's receiving account, or
+	// nil when enrichment is off or the account is unknown.
+	Payee(ctx context.Context, txn store.Transaction) (*store.Counterparty, error)
+}
+
+// Input is what rules see of a transaction. Account identifiers are as
+// stored, so with tokenization on they are tokens.
+type Input struct {
+	Transaction store.Transaction
+	Source      Source
+}
+
+// Hit is one rule that fired for a transaction.
+type Hit struct {
+	Rule   string  `json:"rule"`
+	Type   string  `json:"type"`
+	Score  float64 `json:"score"`
+	Reason string  `json:"reason"`
+}
+
+// Assessment is the result of running a rule set over a transaction.
+// Decision is allow, review or block, from the total score against
+// FRAUD_REVIEW_SCORE and FRAUD_BLOCK_SCORE.
+type Assessment struct {
+	TransactionID  string   `json:"transaction_id"`
+	Score          float64  `json:"score"`
+	Decision       string   `json:"decision"`
+	Hits           []Hit    `json:"hits"`
+	Errors         []string `json:"errors,omitempty"`
+	RuleSetVersion string   `json:"rule_set_version"`
+}
+
+// Assess runs the set's enabled rules over in and totals their scores. A
+// rule that errors is reported in the assessment and treated as not firing.
+// Decision is left for the caller, see Decide.
+func (s *RuleSet) Assess(ctx context.Context, in *Input) *Assessment {
+	a := &Assessment{TransactionID: in.Transaction.ID, Hits: []Hit{}, RuleSetVersion: s.Version}
+	for _, r := range s.rules {
+		fired, reason, err := r.rule.Evaluate(ctx, in)
+		if err != nil {
+			a.Errors = append(a.Errors, fmt.Sprintf("%s: %v", r.spec.Name, err))
+			continue
+		}
+		if fired {
+			a.Score += r.spec.Score
+			a.Hits = append(a.Hits, Hit{Rule: r.spec.Name, Type: r.spec.Type, Score: r.spec.Score, Reason: reason})
+		}
+	}
+	return a
+}
+
+// Decide turns a total score into allow, review or block.
+func Decide(score, reviewScore, blockScore float64) string {
+	switch {
+	case score >= blockScore:
+		return "block"
+	case score >= reviewScore:
+		return "review"
+	}
+	return "allow"
+}
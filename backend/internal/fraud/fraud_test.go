@@ -0,0 +1,130 @@
+package fraud
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/infrasage/payflow/internal/store"
+)
+
+// fakeSource answers rule lookups with fixed values.
+type fakeSource struct {
+	recent int
+	payee  *store.Counterparty
+	err    error
+}
+
+func (s fakeSource) RecentPayments(context.Context, store.Transaction, time.Duration) (int, error) {
+	return s.recent, s.err
+}
+
+func (s fakeSource) Payee(context.Context, store.Transaction) (*store.Counterparty, error) {
+	return s.payee, s.err
+}
+
+func TestCompileRejects(t *testing.T) {
+	off := false
+	tests := []struct {
+		name  string
+		specs []RuleSpec
+		want  string
+	}{
+		{"no name", []RuleSpec{{Type: "round_amount", Params: map[string]interface{}{"multiple": 10}}}, "without a name"},
+		{"twice", []RuleSpec{
+			{Name: "a", Type: "round_amount", Params: map[string]interface{}{"multiple": 10}},
+			{Name: "a", Type: "round_amount", Params: map[string]interface{}{"multiple": 10}},
+		}, "defined twice"},
+		{"unknown type", []RuleSpec{{Name: "a", Type: "astrology"}}, "unknown type"},
+		{"negative score", []RuleSpec{{Name: "a", Type: "round_amount", Score: -1, Params: map[string]interface{}{"multiple": 10}}}, "negative"},
+		{"misspelled param", []RuleSpec{{Name: "a", Type: "amount_threshold", Params: map[string]interface{}{"min_amout": 10}}}, "invalid params"},
+		{"disabled still checked", []RuleSpec{{Name: "a", Type: "velocity", Enabled: &off, Params: map[string]interface{}{"max_count": 0, "window_sec": 60}}}, "must be positive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compile("test", tt.specs)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want one mentioning %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileVersionAndEnabled(t *testing.T) {
+	off := false
+	specs := append([]RuleSpec{{Name: "off", Type: "round_amount", Enabled: &off, Params: map[string]interface{}{"multiple": 5}}}, DefaultRuleSpecs...)
+	a, err := Compile("a", specs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := Compile("b", specs)
+	if a.Version != b.Version {
+		t.Errorf("same specs gave versions %s and %s", a.Version, b.Version)
+	}
+	if got := len(a.Enabled()); got != len(DefaultRuleSpecs) {
+		t.Errorf("%d enabled rules, want %d", got, len(DefaultRuleSpecs))
+	}
+}
+
+func TestVelocityLimits(t *testing.T) {
+	off := false
+	specs := append([]RuleSpec{{Name: "off", Type: "velocity", Enabled: &off, Params: map[string]interface{}{"max_count": 1, "window_sec": 1}}}, DefaultRuleSpecs...)
+	set, err := Compile("test", specs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits := set.VelocityLimits()
+	want := VelocityLimit{Rule: "payer_velocity", Score: 30, MaxCount: 5, WindowSec: 60}
+	if len(limits) != 1 || limits[0] != want {
+		t.Errorf("limits %+v, want [%+v]", limits, want)
+	}
+}
+
+func TestAssess(t *testing.T) {
+	set, err := Compile("test", DefaultRuleSpecs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		amount float64
+		source fakeSource
+		hits   string
+		score  float64
+		errs   int
+	}{
+		{"nothing", 12.34, fakeSource{}, "", 0, 0},
+		{"large and round", 20000, fakeSource{}, "large_amount round_amount", 50, 0},
+		{"velocity at the limit", 5, fakeSource{recent: 5}, "payer_velocity", 30, 0},
+		{"velocity under the limit", 5, fakeSource{recent: 4}, "", 0, 0},
+		{"high risk payee", 5, fakeSource{payee: &store.Counterparty{RiskTier: "high"}}, "high_risk_payee", 50, 0},
+		{"low risk payee", 5, fakeSource{payee: &store.Counterparty{RiskTier: "low"}}, "", 0, 0},
+		{"lookup errors don't fire", 5, fakeSource{err: errors.New("down")}, "", 0, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := &Input{Transaction: store.Transaction{ID: "t1", Amount: tt.amount}, Source: tt.source}
+			a := set.Assess(context.Background(), in)
+			var hits []string
+			for _, h := range a.Hits {
+				hits = append(hits, h.Rule)
+			}
+			if strings.Join(hits, " ") != tt.hits || a.Score != tt.score || len(a.Errors) != tt.errs {
+				t.Errorf("hits %v score %g errors %v, want %q %g and %d errors", hits, a.Score, a.Errors, tt.hits, tt.score, tt.errs)
+			}
+			if a.TransactionID != "t1" || a.RuleSetVersion != set.Version {
+				t.Errorf("assessment of %s with version %s", a.TransactionID, a.RuleSetVersion)
+			}
+		})
+	}
+}
+
+func TestDecide(t *testing.T) {
+	for score, want := range map[float64]string{0: "allow", 49.9: "allow", 50: "review", 79: "review", 80: "block", 200: "block"} {
+		if got := Decide(score, 50, 80); got != want {
+			t.Errorf("Decide(%g) = %s, want %s", score, got, want)
+		}
+	}
+}
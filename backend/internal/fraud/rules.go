@@ -0,0 +1,108 @@
+package fraud
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+type amountThresholdRule struct {
+	MinAmount float64 `json:"min_amount"`
+}
+
+func newAmountThresholdRule(params map[string]interface{}) (Rule, error) {
+	r := &amountThresholdRule{}
+	if err := decodeParams(params, r); err != nil {
+		return nil, err
+	}
+	if r.MinAmount <= 0 {
+		return nil, fmt.Errorf("min_amount must be positive")
+	}
+	return r, nil
+}
+
+func (r *amountThresholdRule) Evaluate(_ context.Context, in *Input) (bool, string, error) {
+	if in.Transaction.Amount < r.MinAmount {
+		return false, "", nil
+	}
+	return true, fmt.Sprintf("amount %.2f is at least %.2f", in.Transaction.Amount, r.MinAmount), nil
+}
+
+type velocityRule struct {
+	MaxCount  int `json:"max_count"`
+	WindowSec int `json:"window_sec"`
+}
+
+func newVelocityRule(params map[string]interface{}) (Rule, error) {
+	r := &velocityRule{}
+	if err := decodeParams(params, r); err != nil {
+		return nil, err
+	}
+	if r.MaxCount <= 0 || r.WindowSec <= 0 {
+		return nil, fmt.Errorf("max_count and window_sec must be positive")
+	}
+	return r, nil
+}
+
+func (r *velocityRule) Evaluate(ctx context.Context, in *Input) (bool, string, error) {
+	n, err := in.Source.RecentPayments(ctx, in.Transaction, time.Duration(r.WindowSec)*time.Second)
+	if err != nil {
+		return false, "", err
+	}
+	if n < r.MaxCount {
+		return false, "", nil
+	}
+	return true, fmt.Sprintf("payer sent %d other transactions in the last %ds", n, r.WindowSec), nil
+}
+
+type counterpartyRiskRule struct {
+	Tiers []string `json:"tiers"`
+}
+
+func newCounterpartyRiskRule(params map[string]interface{}) (Rule, error) {
+	r := &counterpartyRiskRule{}
+	if err := decodeParams(params, r); err != nil {
+		return nil, err
+	}
+	if len(r.Tiers) == 0 {
+		return nil, fmt.Errorf("tiers must list at least one risk tier")
+	}
+	return r, nil
+}
+
+func (r *counterpartyRiskRule) Evaluate(ctx context.Context, in *Input) (bool, string, error) {
+	cp, err := in.Source.Payee(ctx, in.Transaction)
+	if err != nil || cp == nil {
+		return false, "", err
+	}
+	for _, tier := range r.Tiers {
+		if cp.RiskTier == tier {
+			return true, fmt.Sprintf("payee risk tier is %s", tier), nil
+		}
+	}
+	return false, "", nil
+}
+
+type roundAmountRule struct {
+	Multiple float64 `json:"multiple"`
+}
+
+func newRoundAmountRule(params map[string]interface{}) (Rule, error) {
+	r := &roundAmountRule{}
+	if err := decodeParams(params, r); err != nil {
+		return nil, err
+	}
+	if r.Multiple <= 0 {
+		return nil, fmt.Errorf("multiple must be positive")
+	}
+	return r, nil
+}
+
+func (r *roundAmountRule) Evaluate(_ context.Context, in *Input) (bool, string, error) {
+	cents, step := math.Round(in.Transaction.Amount*100), math.Round(r.Multiple*100)
+	if cents < step || math.Mod(cents, step) != 0 {
+		return false, "", nil
+	}
+	return true, fmt.Sprintf("amount is a multiple of %.2f", r.Multiple), nil
+}
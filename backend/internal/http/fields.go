@@ -0,0 +1,94 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Fieldset is the set of object fields a list caller asked for with
+// ?fields=, JSON:API style. A nil Fieldset keeps every field.
+type Fieldset map[string]bool
+
+// FieldsParam parses ?fields=id,amount,status against the JSON fields of
+// model, so a misspelt name is a 400 instead of objects that are silently
+// empty. id is always kept, so sparse objects can still be told apart.
+func FieldsParam(c *gin.Context, model interface{}) (Fieldset, error) {
+	raw := c.Query("fields")
+	if raw == "" {
+		return nil, nil
+	}
+	known := JSONFieldNames(reflect.TypeOf(model))
+	fields := Fieldset{}
+	if slices.Contains(known, "id") {
+		fields["id"] = true
+	}
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !slices.Contains(known, name) {
+			return nil, fmt.Errorf("unknown field %q in fields; expected any of %s", name, strings.Join(known, ", "))
+		}
+		fields[name] = true
+	}
+	return fields, nil
+}
+
+// JSONFieldNames lists the names t's fields are encoded under, sorted.
+func JSONFieldNames(t reflect.Type) []string {
+	var names []string
+	var walk func(reflect.Type)
+	walk = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" || (!f.IsExported() && !f.Anonymous) {
+				continue
+			}
+			name, _, _ := strings.Cut(tag, ",")
+			if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+				walk(f.Type)
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			names = append(names, name)
+		}
+	}
+	walk(t)
+	sort.Strings(names)
+	return names
+}
+
+// Apply returns items, a slice, with each element cut down to the fields in
+// f. Fields left out by omitempty stay left out. Without a fieldset items is
+// returned as it is.
+func (f Fieldset) Apply(items interface{}) interface{} {
+	if f == nil {
+		return items
+	}
+	v := reflect.ValueOf(items)
+	sparse := make([]map[string]json.RawMessage, 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		var obj map[string]json.RawMessage
+		raw, err := json.Marshal(v.Index(i).Interface())
+		if err != nil || json.Unmarshal(raw, &obj) != nil {
+			continue
+		}
+		for name := range obj {
+			if !f[name] {
+				delete(obj, name)
+			}
+		}
+		sparse = append(sparse, obj)
+	}
+	return sparse
+}
@@ -0,0 +1,99 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type testItem struct {
+	ID     string  `json:"id"`
+	Amount float64 `json:"amount"`
+	Note   string  `json:"note,omitempty"`
+	hidden string
+	Embedded
+}
+
+type Embedded struct {
+	Status string `json:"status"`
+}
+
+func TestFieldsParam(t *testing.T) {
+	tests := []struct {
+		query string
+		want  Fieldset
+		err   string
+	}{
+		{"", nil, ""},
+		{"amount", Fieldset{"id": true, "amount": true}, ""},
+		{" status , ,note", Fieldset{"id": true, "status": true, "note": true}, ""},
+		{"amount,hidden", nil, `unknown field "hidden"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "/?fields="+strings.ReplaceAll(tt.query, " ", "%20"), nil)
+			got, err := FieldsParam(c, testItem{})
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFieldsetApply(t *testing.T) {
+	items := []testItem{{ID: "a", Amount: 1, Note: "n"}, {ID: "b", Amount: 2}}
+	got := Fieldset{"id": true, "note": true}.Apply(items)
+	raw := reflect.ValueOf(got)
+	if raw.Len() != 2 {
+		t.Fatalf("got %v", got)
+	}
+	if s := len(raw.Index(0).Interface().(map[string]json.RawMessage)); s != 2 {
+		t.Errorf("first item has %d fields, want id and note", s)
+	}
+	if s := len(raw.Index(1).Interface().(map[string]json.RawMessage)); s != 1 {
+		t.Errorf("second item has %d fields, want id only", s)
+	}
+	if Fieldset(nil).Apply(items) == nil {
+		t.Error("nil fieldset dropped the items")
+	}
+}
+
+func TestIncomingRequestID(t *testing.T) {
+	trace := "4bf92f3577b34da6a3ce929d0e0e4736"
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    string
+	}{
+		{"none", nil, ""},
+		{"request id", map[string]string{"X-Request-ID": "req-1:a.b"}, "req-1:a.b"},
+		{"too long", map[string]string{"X-Request-ID": strings.Repeat("a", maxRequestIDLen+1)}, ""},
+		{"log injection", map[string]string{"X-Request-ID": "a\nlevel=error"}, ""},
+		{"traceparent", map[string]string{"traceparent": "00-" + trace + "-00f067aa0ba902b7-01"}, trace},
+		{"request id wins", map[string]string{"X-Request-ID": "r", "traceparent": "00-" + trace + "-00f067aa0ba902b7-01"}, "r"},
+		{"zero trace", map[string]string{"traceparent": "00-" + strings.Repeat("0", 32) + "-00f067aa0ba902b7-01"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			if got := IncomingRequestID(r); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
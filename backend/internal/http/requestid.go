@@ -0,0 +1,35 @@
+package http
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// maxRequestIDLen bounds caller-supplied request IDs; longer ones are
+// replaced rather than truncated so they can't collide by prefix.
+const maxRequestIDLen = 128
+
+var (
+	requestIDPattern   = regexp.MustCompile(`^[A-Za-z0-9._:-]+$`)
+	traceparentPattern = regexp.MustCompile(`^[0-9a-f]{2}-([0-9a-f]{32})-[0-9a-f]{16}-[0-9a-f]{2}$`)
+)
+
+// ValidRequestID reports whether a caller-supplied request ID can be used
+// as it is.
+func ValidRequestID(id string) bool {
+	return id != "" && len(id) <= maxRequestIDLen && requestIDPattern.MatchString(id)
+}
+
+// IncomingRequestID takes the caller's X-Request-ID, else the trace ID of a
+// W3C traceparent header, else "". IDs that could garble a log line are
+// ignored.
+func IncomingRequestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-ID"); ValidRequestID(id) {
+		return id
+	}
+	if m := traceparentPattern.FindStringSubmatch(strings.TrimSpace(r.Header.Get("traceparent"))); m != nil && m[1] != strings.Repeat("0", 32) {
+		return m[1]
+	}
+	return ""
+}
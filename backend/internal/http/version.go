@@ -0,0 +1,198 @@
+// Package http holds PayFlow's HTTP plumbing that doesn't depend on the
+// server's state: response versions and their envelope, sparse fieldsets and
+// request IDs. Handlers and the middleware that needs configuration or the
+// database stay in cmd/server.
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// Version is a response shape clients can ask for with X-API-Version, its
+// envelope and its field naming. Version 1 is what handlers write: bare
+// objects and arrays with snake_case fields, and errors as {"error":
+// "message"}. It never changes, so old clients keep working. Version 2 wraps
+// successes as {"data": ..., "meta": ...} and errors as {"error": {"status",
+// "message", "details"}}, with fields named for API_V2_FIELD_NAMING.
+type Version struct {
+	Envelope    bool
+	FieldNaming string
+}
+
+// VersionMiddleware reshapes JSON responses under /api for the version in
+// versions the request asks for, defaultVersion otherwise, renaming their
+// fields as that version names them. Paths starting with one of exempt are
+// left alone. Run it outside the recovery middleware, so errors from panics
+// and from every other middleware are reshaped too.
+func VersionMiddleware(versions map[string]Version, defaultVersion string, exempt []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		if !strings.HasPrefix(path, "/api/") || websocket.IsWebSocketUpgrade(c.Request) {
+			c.Next()
+			return
+		}
+		for _, prefix := range exempt {
+			if strings.HasPrefix(path, prefix) {
+				c.Next()
+				return
+			}
+		}
+		name := c.GetHeader("X-API-Version")
+		if name == "" {
+			name = defaultVersion
+		}
+		version, ok := versions[name]
+		if !ok {
+			supported := make([]string, 0, len(versions))
+			for v := range versions {
+				supported = append(supported, v)
+			}
+			sort.Strings(supported)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported API version", "supported": supported})
+			c.Abort()
+			return
+		}
+		c.Header("X-API-Version", name)
+		camel := version.FieldNaming == "camelCase"
+		if !version.Envelope && !camel {
+			c.Next()
+			return
+		}
+
+		w := &reshapeWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+		if !w.buffering || w.hijacked {
+			return
+		}
+		body := w.body.Bytes()
+		if reshaped, err := reshapeJSON(body, w.Status(), version.Envelope, camel); err == nil {
+			body = reshaped
+		}
+		w.ResponseWriter.Write(body)
+	}
+}
+
+// reshapeWriter holds back JSON bodies so they can be reshaped once the
+// handler is done. Anything else goes straight through.
+type reshapeWriter struct {
+	gin.ResponseWriter
+	body      bytes.Buffer
+	buffering bool
+	decided   bool
+	hijacked  bool
+}
+
+func (w *reshapeWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		w.decided = true
+		w.buffering = strings.HasPrefix(w.Header().Get("Content-Type"), "application/json")
+	}
+	if !w.buffering {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.body.Write(b)
+}
+
+func (w *reshapeWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *reshapeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.hijacked = true
+	return w.ResponseWriter.Hijack()
+}
+
+// reshapeJSON wraps body for the envelope and renames its fields to
+// camelCase, as asked. Numbers are kept as written.
+func reshapeJSON(body []byte, status int, envelope, camel bool) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if envelope {
+		v = envelopeBody(v, status)
+	}
+	if camel {
+		v = camelKeys(v)
+	}
+	return json.Marshal(v)
+}
+
+// envelopeBody wraps a version 1 body. Errors are objects with an "error"
+// message; their other fields become details. A page, an object with
+// "data", keeps its data and moves the rest (total, limit, offset,
+// next_cursor) to meta. A bare array counts as a page of its length.
+func envelopeBody(v interface{}, status int) interface{} {
+	obj, isObject := v.(map[string]interface{})
+	if message, ok := obj["error"].(string); ok && status >= http.StatusBadRequest {
+		apiErr := map[string]interface{}{"status": status, "message": message}
+		if len(obj) > 1 {
+			details := map[string]interface{}{}
+			for k, val := range obj {
+				if k != "error" {
+					details[k] = val
+				}
+			}
+			apiErr["details"] = details
+		}
+		return map[string]interface{}{"error": apiErr}
+	}
+	if list, ok := v.([]interface{}); ok {
+		return map[string]interface{}{"data": list, "meta": map[string]interface{}{"count": json.Number(strconv.Itoa(len(list)))}}
+	}
+	if data, ok := obj["data"]; isObject && ok {
+		meta := map[string]interface{}{}
+		for k, val := range obj {
+			if k != "data" {
+				meta[k] = val
+			}
+		}
+		if list, ok := data.([]interface{}); ok {
+			meta["count"] = json.Number(strconv.Itoa(len(list)))
+		}
+		return map[string]interface{}{"data": data, "meta": meta}
+	}
+	return map[string]interface{}{"data": v}
+}
+
+// camelKeys renames object keys from snake_case to camelCase throughout v.
+func camelKeys(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			out[camelCase(k)] = camelKeys(val)
+		}
+		return out
+	case []interface{}:
+		for i := range t {
+			t[i] = camelKeys(t[i])
+		}
+		return t
+	}
+	return v
+}
+
+func camelCase(s string) string {
+	parts := strings.Split(s, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
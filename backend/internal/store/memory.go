@@ -0,0 +1,61 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// Memory is a TransactionRepository over a slice, for tests and for running
+// handlers without a database.
+type Memory struct {
+	mu   sync.RWMutex
+	txns []Transaction
+}
+
+func NewMemory(txns ...Transaction) *Memory {
+	m := &Memory{}
+	m.Add(txns...)
+	return m
+}
+
+// Add stores txns, replacing any already stored with the same ID.
+func (m *Memory) Add(txns ...Transaction) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range txns {
+		replaced := false
+		for i := range m.txns {
+			if m.txns[i].ID == t.ID {
+				m.txns[i], replaced = t, true
+				break
+			}
+		}
+		if !replaced {
+			m.txns = append(m.txns, t)
+		}
+	}
+	sort.Slice(m.txns, func(i, j int) bool { return PositionOf(m.txns[i]).precedes(PositionOf(m.txns[j])) })
+}
+
+func (m *Memory) List(ctx context.Context, f TransactionFilter) ([]Transaction, int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	txns := []Transaction{}
+	total, skipped := 0, 0
+	for _, t := range m.txns {
+		if !f.visible(t) {
+			continue
+		}
+		total++
+		if f.After != nil && !f.After.precedes(PositionOf(t)) {
+			continue
+		}
+		if skipped < f.Offset {
+			skipped++
+		} else if len(txns) < f.Limit {
+			txns = append(txns, t)
+		}
+	}
+	return txns, total, nil
+}
@@ -0,0 +1,59 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMemoryList(t *testing.T) {
+	base := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	mem := NewMemory(
+		Transaction{ID: "a", Status: "success", CreatedAt: base},
+		Transaction{ID: "b", Status: "failed", CreatedAt: base.Add(time.Minute)},
+		// Same time as b; IDs break the tie, descending.
+		Transaction{ID: "c", Status: "success", CreatedAt: base.Add(time.Minute)},
+		Transaction{ID: "d", Status: "success", CreatedAt: base.Add(2 * time.Minute), Region: "eu"},
+		Transaction{ID: "s", Status: "success", CreatedAt: base, SessionID: "s1"},
+	)
+	tests := []struct {
+		name  string
+		f     TransactionFilter
+		total int
+		ids   string
+	}{
+		{"live only", TransactionFilter{Limit: 10}, 4, "[d c b a]"},
+		{"session only", TransactionFilter{SessionID: "s1", Limit: 10}, 1, "[s]"},
+		{"limit and offset", TransactionFilter{Limit: 2, Offset: 1}, 4, "[c b]"},
+		{"statuses", TransactionFilter{Statuses: []string{"failed"}, Limit: 10}, 1, "[b]"},
+		{"after keeps the total", TransactionFilter{After: &Position{CreatedAt: base.Add(time.Minute), ID: "c"}, Limit: 10}, 4, "[b a]"},
+		{"since and until", TransactionFilter{Since: base.Add(time.Minute), Until: base.Add(2 * time.Minute), Limit: 10}, 2, "[c b]"},
+		{"lagging region hidden", TransactionFilter{Region: "us", LagCutoff: base.Add(time.Minute), Limit: 10}, 3, "[c b a]"},
+		{"own region shown", TransactionFilter{Region: "eu", LagCutoff: base, Limit: 10}, 4, "[d c b a]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			txns, total, err := mem.List(context.Background(), tt.f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids := make([]string, len(txns))
+			for i, txn := range txns {
+				ids[i] = txn.ID
+			}
+			if total != tt.total || fmt.Sprint(ids) != tt.ids {
+				t.Errorf("got %d %v, want %d %s", total, ids, tt.total, tt.ids)
+			}
+		})
+	}
+}
+
+func TestMemoryAddReplaces(t *testing.T) {
+	mem := NewMemory(Transaction{ID: "a", Status: "pending"})
+	mem.Add(Transaction{ID: "a", Status: "success"})
+	txns, total, _ := mem.List(context.Background(), TransactionFilter{Limit: 10})
+	if total != 1 || txns[0].Status != "success" {
+		t.Errorf("got %d %+v", total, txns)
+	}
+}
@@ -0,0 +1,100 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Postgres reads transactions from the pool returned by pool, which is
+// looked up per query so a replica failover takes effect right away.
+type Postgres struct {
+	pool func() *sql.DB
+}
+
+func NewPostgres(pool func() *sql.DB) *Postgres {
+	return &Postgres{pool: pool}
+}
+
+// transactionColumns are the fields a transaction list may filter and sort
+// on.
+var transactionColumns = map[string]string{
+	"id":           "id",
+	"session_id":   "session_id",
+	"status":       "status",
+	"from_account": "from_account",
+	"to_account":   "to_account",
+	"created_at":   "created_at",
+	"region":       "region",
+}
+
+func (p *Postgres) List(ctx context.Context, f TransactionFilter) ([]Transaction, int, error) {
+	qb := NewQueryBuilder(transactionColumns).
+		Where("session_id", OpNotDistinct, sql.NullString{String: f.SessionID, Valid: f.SessionID != ""})
+	if f.ID != "" {
+		qb.Where("id", OpEq, f.ID)
+	}
+	if len(f.Statuses) > 0 {
+		qb.Where("status", OpIn, f.Statuses)
+	}
+	if f.FromAccount != "" {
+		qb.Where("from_account", OpEq, f.FromAccount)
+	}
+	if f.ToAccount != "" {
+		qb.Where("to_account", OpEq, f.ToAccount)
+	}
+	if !f.Since.IsZero() {
+		qb.Where("created_at", OpGte, f.Since)
+	}
+	if !f.Until.IsZero() {
+		qb.Where("created_at", OpLt, f.Until)
+	}
+	if !f.LagCutoff.IsZero() {
+		qb.Or(func(q *QueryBuilder) {
+			q.Where("region", OpNotDistinct, nil).
+				Where("region", OpEq, f.Region).
+				Where("created_at", OpLte, f.LagCutoff)
+		})
+	}
+	where, args, err := qb.WhereClause()
+	if err != nil {
+		return nil, 0, err
+	}
+	var total int
+	if err := p.pool().QueryRowContext(ctx, `SELECT COUNT(*) FROM transactions`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	if f.After != nil {
+		qb.Or(func(q *QueryBuilder) {
+			q.Where("created_at", OpLt, f.After.CreatedAt).
+				And(func(q *QueryBuilder) {
+					q.Where("created_at", OpEq, f.After.CreatedAt).Where("id", OpLt, f.After.ID)
+				})
+		})
+	}
+	qb.OrderBy("created_at", true).ThenBy("id", true)
+	limitArg, offsetArg := qb.Arg(f.Limit), qb.Arg(f.Offset)
+	where, args, err = qb.WhereClause()
+	if err != nil {
+		return nil, 0, err
+	}
+	rows, err := p.pool().QueryContext(ctx, `
+		SELECT id, from_account, to_account, amount, description, status, created_at,
+			COALESCE(prev_hash, ''), COALESCE(hash, ''), COALESCE(status_token, ''), COALESCE(region, ''), COALESCE(refund_of, ''), internal, COALESCE(fraud_status, '')
+		FROM transactions`+where+qb.OrderClause()+`
+		LIMIT `+limitArg+` OFFSET `+offsetArg, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	txns := []Transaction{}
+	for rows.Next() {
+		var t Transaction
+		if err := rows.Scan(&t.ID, &t.FromAccount, &t.ToAccount, &t.Amount, &t.Description, &t.Status, &t.CreatedAt, &t.PrevHash, &t.Hash, &t.StatusToken, &t.Region, &t.RefundOf, &t.Internal, &t.FraudStatus); err != nil {
+			continue
+		}
+		txns = append(txns, t)
+	}
+	return txns, total, rows.Err()
+}
@@ -0,0 +1,68 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/infrasage/payflow/internal/dbtest"
+)
+
+// recorder answers every query with no rows, or a zero count, and keeps
+// the statements it was sent.
+func recorder() *dbtest.DB {
+	return dbtest.New(func(q dbtest.Query) (*dbtest.Rows, error) {
+		if q.Contains("COUNT(*)") {
+			return dbtest.NewRows("count").Add(int64(0)), nil
+		}
+		return dbtest.NewRows("count"), nil
+	})
+}
+
+func TestPostgresListParameterizesFilters(t *testing.T) {
+	rec := recorder()
+	db := rec.Open(t)
+
+	hostile := "x' OR '1'='1"
+	at := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	_, _, err := NewPostgres(func() *sql.DB { return db }).List(context.Background(), TransactionFilter{
+		ID:          hostile,
+		SessionID:   "s1",
+		Statuses:    []string{"success", hostile},
+		FromAccount: hostile,
+		Region:      "eu",
+		LagCutoff:   at,
+		After:       &Position{CreatedAt: at, ID: hostile},
+		Limit:       10,
+		Offset:      5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries := rec.Queries()
+	if len(queries) != 2 {
+		t.Fatalf("%d queries, want a count and a page", len(queries))
+	}
+	count, page := queries[0], queries[1]
+	for _, q := range queries {
+		if strings.Contains(q.SQL, hostile) {
+			t.Errorf("value interpolated into SQL: %s", q.SQL)
+		}
+	}
+	wantCount := `SELECT COUNT(*) FROM transactions WHERE session_id IS NOT DISTINCT FROM $1 AND id = $2 AND status IN ($3, $4) AND from_account = $5 AND (region IS NOT DISTINCT FROM $6 OR region = $7 OR created_at <= $8)`
+	if count.SQL != wantCount {
+		t.Errorf("count query\n got %s\nwant %s", count.SQL, wantCount)
+	}
+	wantTail := `WHERE session_id IS NOT DISTINCT FROM $1 AND id = $2 AND status IN ($3, $4) AND from_account = $5 AND (region IS NOT DISTINCT FROM $6 OR region = $7 OR created_at <= $8) AND (created_at < $9 OR (created_at = $10 AND id < $11)) ORDER BY created_at DESC, id DESC LIMIT $12 OFFSET $13`
+	if !strings.HasSuffix(page.SQL, wantTail) {
+		t.Errorf("page query\n got %s\nwant suffix %s", page.SQL, wantTail)
+	}
+	if len(count.Args) != 8 || len(page.Args) != 13 {
+		t.Fatalf("got %d and %d args, want 8 and 13", len(count.Args), len(page.Args))
+	}
+	if page.Args[10] != hostile || page.Args[11] != int64(10) || page.Args[12] != int64(5) {
+		t.Errorf("page args %v", page.Args[10:])
+	}
+}
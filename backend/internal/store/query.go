@@ -0,0 +1,163 @@
+package store
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// FilterOp is a comparison operator allowed in dynamic filters.
+type FilterOp string
+
+const (
+	OpEq    FilterOp = "="
+	OpNotEq FilterOp = "<>"
+	OpLt    FilterOp = "<"
+	OpLte   FilterOp = "<="
+	OpGt    FilterOp = ">"
+	OpGte   FilterOp = ">="
+	OpILike FilterOp = "ILIKE"
+	OpIn    FilterOp = "IN"
+
+	// OpNotDistinct is a NULL-safe equality, for nullable columns.
+	OpNotDistinct FilterOp = "IS NOT DISTINCT FROM"
+	// OpContains is JSONB containment: the column holds at least the value.
+	OpContains FilterOp = "@>"
+)
+
+var allowedOps = map[FilterOp]bool{
+	OpEq: true, OpNotEq: true, OpLt: true, OpLte: true,
+	OpGt: true, OpGte: true, OpILike: true, OpIn: true,
+	OpNotDistinct: true, OpContains: true,
+}
+
+// QueryBuilder composes WHERE and ORDER BY clauses. Column names only ever
+// come from the whitelist and values only ever travel as $n placeholders,
+// so user input is never interpolated into SQL text.
+type QueryBuilder struct {
+	columns map[string]string
+	where   []string
+	args    []interface{}
+	orderBy []string
+	err     error
+}
+
+// NewQueryBuilder returns a builder that accepts the fields of columns,
+// which maps each to its SQL column.
+func NewQueryBuilder(columns map[string]string) *QueryBuilder {
+	return &QueryBuilder{columns: columns}
+}
+
+func (q *QueryBuilder) column(field string) (string, bool) {
+	col, ok := q.columns[field]
+	if !ok && q.err == nil {
+		q.err = fmt.Errorf("unknown field %q", field)
+	}
+	return col, ok
+}
+
+func (q *QueryBuilder) placeholder(v interface{}) string {
+	q.args = append(q.args, v)
+	return fmt.Sprintf("$%d", len(q.args))
+}
+
+// Where adds "field op value". For OpIn, value must be a non-empty slice.
+func (q *QueryBuilder) Where(field string, op FilterOp, value interface{}) *QueryBuilder {
+	col, ok := q.column(field)
+	if !ok {
+		return q
+	}
+	if !allowedOps[op] {
+		if q.err == nil {
+			q.err = fmt.Errorf("operator %q is not allowed", op)
+		}
+		return q
+	}
+
+	if op != OpIn {
+		q.where = append(q.where, fmt.Sprintf("%s %s %s", col, op, q.placeholder(value)))
+		return q
+	}
+
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Slice || rv.Len() == 0 {
+		if q.err == nil {
+			q.err = fmt.Errorf("IN filter on %q needs a non-empty list", field)
+		}
+		return q
+	}
+	ph := make([]string, rv.Len())
+	for i := range ph {
+		ph[i] = q.placeholder(rv.Index(i).Interface())
+	}
+	q.where = append(q.where, fmt.Sprintf("%s IN (%s)", col, strings.Join(ph, ", ")))
+	return q
+}
+
+// Or adds the conditions added by build, joined with OR, as one condition.
+func (q *QueryBuilder) Or(build func(*QueryBuilder)) *QueryBuilder {
+	return q.group(" OR ", build)
+}
+
+// And adds the conditions added by build, joined with AND, as one
+// condition, for nesting inside Or.
+func (q *QueryBuilder) And(build func(*QueryBuilder)) *QueryBuilder {
+	return q.group(" AND ", build)
+}
+
+func (q *QueryBuilder) group(sep string, build func(*QueryBuilder)) *QueryBuilder {
+	sub := &QueryBuilder{columns: q.columns, args: q.args}
+	build(sub)
+	q.args = sub.args
+	if sub.err != nil && q.err == nil {
+		q.err = sub.err
+	}
+	if len(sub.where) > 0 {
+		q.where = append(q.where, "("+strings.Join(sub.where, sep)+")")
+	}
+	return q
+}
+
+// OrderBy sets the sort column; only whitelisted fields are accepted.
+func (q *QueryBuilder) OrderBy(field string, desc bool) *QueryBuilder {
+	q.orderBy = nil
+	return q.ThenBy(field, desc)
+}
+
+// ThenBy adds a sort column that breaks ties left by the ones before it.
+func (q *QueryBuilder) ThenBy(field string, desc bool) *QueryBuilder {
+	col, ok := q.column(field)
+	if !ok {
+		return q
+	}
+	dir := "ASC"
+	if desc {
+		dir = "DESC"
+	}
+	q.orderBy = append(q.orderBy, col+" "+dir)
+	return q
+}
+
+// Arg appends a bare placeholder (e.g. for LIMIT/OFFSET) and returns it.
+func (q *QueryBuilder) Arg(v interface{}) string {
+	return q.placeholder(v)
+}
+
+// WhereClause returns " WHERE ..." (or "") and the accumulated args.
+func (q *QueryBuilder) WhereClause() (string, []interface{}, error) {
+	if q.err != nil {
+		return "", nil, q.err
+	}
+	if len(q.where) == 0 {
+		return "", q.args, nil
+	}
+	return " WHERE " + strings.Join(q.where, " AND "), q.args, nil
+}
+
+// OrderClause returns " ORDER BY ..." or "".
+func (q *QueryBuilder) OrderClause() string {
+	if len(q.orderBy) == 0 {
+		return ""
+	}
+	return " ORDER BY " + strings.Join(q.orderBy, ", ")
+}
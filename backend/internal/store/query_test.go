@@ -0,0 +1,131 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var testColumns = map[string]string{"day": "c.day", "status": "status", "amount": "t.amount"}
+
+func TestQueryBuilderWhere(t *testing.T) {
+	hostile := "'; DROP TABLE transactions; --"
+	tests := []struct {
+		name  string
+		build func(q *QueryBuilder)
+		where string
+		args  string
+	}{
+		{"none", func(q *QueryBuilder) {}, "", "[]"},
+		{"one", func(q *QueryBuilder) { q.Where("status", OpEq, "success") }, " WHERE status = $1", "[success]"},
+		{"mapped columns", func(q *QueryBuilder) {
+			q.Where("day", OpGte, "2026-01-01").Where("amount", OpLt, 10)
+		}, " WHERE c.day >= $1 AND t.amount < $2", "[2026-01-01 10]"},
+		{"in", func(q *QueryBuilder) {
+			q.Where("status", OpIn, []string{"a", "b", "c"}).Where("day", OpEq, "d")
+		}, " WHERE status IN ($1, $2, $3) AND c.day = $4", "[a b c d]"},
+		{"or", func(q *QueryBuilder) {
+			q.Where("day", OpEq, "d").Or(func(q *QueryBuilder) {
+				q.Where("status", OpEq, "a").Where("amount", OpGt, 5)
+			}).Where("status", OpNotEq, "z")
+		}, " WHERE c.day = $1 AND (status = $2 OR t.amount > $3) AND status <> $4", "[d a 5 z]"},
+		{"and inside or", func(q *QueryBuilder) {
+			q.Or(func(q *QueryBuilder) {
+				q.Where("day", OpLt, "d").And(func(q *QueryBuilder) {
+					q.Where("day", OpEq, "d").Where("status", OpLt, "s")
+				})
+			})
+		}, " WHERE (c.day < $1 OR (c.day = $2 AND status < $3))", "[d d s]"},
+		{"contains", func(q *QueryBuilder) { q.Where("status", OpContains, `[{"rule": "x"}]`) }, " WHERE status @> $1", `[[{"rule": "x"}]]`},
+		{"values never in sql", func(q *QueryBuilder) {
+			q.Where("status", OpILike, hostile).Where("status", OpNotDistinct, nil)
+		}, " WHERE status ILIKE $1 AND status IS NOT DISTINCT FROM $2", "[" + hostile + " <nil>]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewQueryBuilder(testColumns)
+			tt.build(q)
+			where, args, err := q.WhereClause()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if where != tt.where || fmt.Sprint(args) != tt.args {
+				t.Errorf("got %q %v, want %q %s", where, args, tt.where, tt.args)
+			}
+		})
+	}
+}
+
+func TestQueryBuilderRejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(q *QueryBuilder)
+		err   string
+	}{
+		{"unknown field", func(q *QueryBuilder) { q.Where("status; DROP TABLE x", OpEq, 1) }, "unknown field"},
+		{"raw column name", func(q *QueryBuilder) { q.Where("c.day", OpEq, 1) }, "unknown field"},
+		{"operator", func(q *QueryBuilder) { q.Where("status", FilterOp("= 1 OR 1 ="), 1) }, "not allowed"},
+		{"in without list", func(q *QueryBuilder) { q.Where("status", OpIn, "a") }, "non-empty list"},
+		{"in empty list", func(q *QueryBuilder) { q.Where("status", OpIn, []string{}) }, "non-empty list"},
+		{"unknown sort", func(q *QueryBuilder) { q.OrderBy("created_at DESC; --", true) }, "unknown field"},
+		{"unknown tie break", func(q *QueryBuilder) { q.OrderBy("day", false).ThenBy("id", false) }, "unknown field"},
+		{"inside or", func(q *QueryBuilder) {
+			q.Or(func(q *QueryBuilder) { q.Where("status", OpEq, 1).Where("nope", OpEq, 2) })
+		}, "unknown field"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewQueryBuilder(testColumns)
+			tt.build(q)
+			where, args, err := q.WhereClause()
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("err = %v, want %q", err, tt.err)
+			}
+			if where != "" || args != nil {
+				t.Errorf("got %q %v alongside the error", where, args)
+			}
+		})
+	}
+}
+
+func TestQueryBuilderOrder(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(q *QueryBuilder)
+		order string
+	}{
+		{"none", func(q *QueryBuilder) {}, ""},
+		{"asc", func(q *QueryBuilder) { q.OrderBy("day", false) }, " ORDER BY c.day ASC"},
+		{"desc", func(q *QueryBuilder) { q.OrderBy("amount", true) }, " ORDER BY t.amount DESC"},
+		{"tie break", func(q *QueryBuilder) { q.OrderBy("day", true).ThenBy("status", false) }, " ORDER BY c.day DESC, status ASC"},
+		{"order by replaces", func(q *QueryBuilder) { q.OrderBy("day", true).OrderBy("status", true) }, " ORDER BY status DESC"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewQueryBuilder(testColumns)
+			tt.build(q)
+			if got := q.OrderClause(); got != tt.order {
+				t.Errorf("got %q, want %q", got, tt.order)
+			}
+		})
+	}
+}
+
+// Placeholders keep counting across filters and bare args, so LIMIT and
+// OFFSET taken after the WHERE clause bind to their own values.
+func TestQueryBuilderArgNumbering(t *testing.T) {
+	q := NewQueryBuilder(testColumns).Where("status", OpIn, []string{"a", "b"})
+	limit := q.Arg(50)
+	q.Where("day", OpEq, "d")
+	offset := q.Arg(100)
+	where, args, err := q.WhereClause()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limit != "$3" || offset != "$5" || where != " WHERE status IN ($1, $2) AND c.day = $4" {
+		t.Errorf("got limit %s offset %s where %q", limit, offset, where)
+	}
+	if fmt.Sprint(args) != "[a b 50 d 100]" {
+		t.Errorf("args %v", args)
+	}
+}
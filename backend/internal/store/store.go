@@ -0,0 +1,124 @@
+// Package store holds PayFlow's stored records, the repositories that read
+// them and the query builder their SQL is composed with. Handlers depend on
+// the interfaces here rather than on a database, so they can run against
+// the in-memory implementation.
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Transaction represents a payment transaction
+type Transaction struct {
+	ID          string    `json:"id"`
+	FromAccount string    `json:"from_account"`
+	ToAccount   string    `json:"to_account"`
+	Amount      float64   `json:"amount"`
+	Description string    `json:"description"`
+	Status      string    `json:"status"`
+	CreatedAt   time.Time `json:"created_at"`
+	PrevHash    string    `json:"prev_hash,omitempty"`
+	Hash        string    `json:"hash,omitempty"`
+	StatusToken string    `json:"status_token,omitempty"`
+	SessionID   string    `json:"session_id,omitempty"`
+	Region      string    `json:"region,omitempty"`
+	RefundOf    string    `json:"refund_of,omitempty"`
+	Internal    bool      `json:"internal,omitempty"`
+	FraudStatus string    `json:"fraud_status,omitempty"`
+
+	Counterparty *Counterparty `json:"counterparty,omitempty"`
+}
+
+// Counterparty is reference metadata about the other side of a transaction.
+type Counterparty struct {
+	Account  string `json:"account"`
+	Name     string `json:"name"`
+	Category string `json:"category,omitempty"`
+	RiskTier string `json:"risk_tier,omitempty"`
+}
+
+// TransactionFilter selects the transactions a list returns. Zero fields
+// don't filter, except SessionID: an empty one means real traffic, not
+// every session.
+type TransactionFilter struct {
+	ID          string
+	SessionID   string
+	Statuses    []string
+	FromAccount string
+	ToAccount   string
+	// Since is inclusive, Until exclusive.
+	Since time.Time
+	Until time.Time
+
+	// With LagCutoff set, transactions written in a region other than
+	// Region only show once created at or before it, as if they were
+	// replicated late.
+	Region    string
+	LagCutoff time.Time
+
+	// After resumes a listing past the transaction at this position. It
+	// narrows the page but not the total.
+	After *Position
+
+	Limit  int
+	Offset int
+}
+
+// Position is a transaction's place in list order: newest first, and by ID,
+// descending, among transactions created at the same time.
+type Position struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+// PositionOf returns t's place in list order.
+func PositionOf(t Transaction) Position {
+	return Position{CreatedAt: t.CreatedAt, ID: t.ID}
+}
+
+// precedes reports whether p comes before q in list order.
+func (p Position) precedes(q Position) bool {
+	return p.CreatedAt.After(q.CreatedAt) || (p.CreatedAt.Equal(q.CreatedAt) && p.ID > q.ID)
+}
+
+// TransactionRepository reads stored transactions.
+type TransactionRepository interface {
+	// List returns a page of the matching transactions in list order, and
+	// how many match in all.
+	List(ctx context.Context, f TransactionFilter) ([]Transaction, int, error)
+}
+
+// visible reports whether t matches f, for implementations that filter in
+// memory. After is left to the caller, as it doesn't count towards the
+// total.
+func (f TransactionFilter) visible(t Transaction) bool {
+	switch {
+	case f.ID != "" && t.ID != f.ID:
+		return false
+	case t.SessionID != f.SessionID:
+		return false
+	case len(f.Statuses) > 0 && !contains(f.Statuses, t.Status):
+		return false
+	case f.FromAccount != "" && t.FromAccount != f.FromAccount:
+		return false
+	case f.ToAccount != "" && t.ToAccount != f.ToAccount:
+		return false
+	case !f.Since.IsZero() && t.CreatedAt.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !t.CreatedAt.Before(f.Until):
+		return false
+	case !f.LagCutoff.IsZero() && t.Region != "" && t.Region != f.Region && t.CreatedAt.After(f.LagCutoff):
+		return false
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package sdk
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Event is the body of an event bus message. ID is unique per event, and
+// stays the same when the bus delivers the event again.
+type Event struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	Region    string          `json:"region"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// DedupTable is the table Dedup records processed events in.
+const DedupTable = "payflow_processed_events"
+
+// Dedup makes an event bus consumer process each event exactly once, even
+// though Kafka and NATS deliver at least once. It records the IDs of the
+// events processed in DedupTable of the consumer's own PostgreSQL database,
+// in the same transaction as the consumer's writes, so an event's effects
+// and its ID are stored together or not at all.
+//
+// IDs are kept for ttl, which must be longer than the bus can redeliver an
+// event after: the topic's retention or the stream's max age.
+type Dedup struct {
+	db  *sql.DB
+	ttl time.Duration
+	now func() time.Time
+}
+
+// NewDedup returns a Dedup over db that remembers events for ttl.
+func NewDedup(db *sql.DB, ttl time.Duration) *Dedup {
+	return &Dedup{db: db, ttl: ttl, now: time.Now}
+}
+
+// CreateTable creates DedupTable unless it exists.
+func (d *Dedup) CreateTable(ctx context.Context) error {
+	_, err := d.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS `+DedupTable+` (
+			event_id VARCHAR(64) PRIMARY KEY,
+			processed_at TIMESTAMP NOT NULL
+		)
+	`)
+	return err
+}
+
+// Process runs handle for the event with ID eventID unless it was processed
+// before, and reports whether it ran. handle does its writes in tx; if it
+// returns an error nothing is stored, and the event is processed when it is
+// delivered again. A consumer processing the same event concurrently waits
+// for the first one to finish, and skips the event if it was stored.
+func (d *Dedup) Process(ctx context.Context, eventID string, handle func(tx *sql.Tx) error) (bool, error) {
+	if eventID == "" {
+		return false, errors.New("event has no ID")
+	}
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO `+DedupTable+` (event_id, processed_at) VALUES ($1, $2)
+		ON CONFLICT (event_id) DO NOTHING
+	`, eventID, d.now().UTC())
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	if err := handle(tx); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// ProcessEvent decodes a message body and processes it like Process.
+func (d *Dedup) ProcessEvent(ctx context.Context, body []byte, handle func(tx *sql.Tx, e Event) error) (bool, error) {
+	var e Event
+	if err := json.Unmarshal(body, &e); err != nil {
+		return false, err
+	}
+	return d.Process(ctx, e.ID, func(tx *sql.Tx) error { return handle(tx, e) })
+}
+
+// Cleanup forgets the events processed longer than ttl ago, and returns how
+// many it removed.
+func (d *Dedup) Cleanup(ctx context.Context) (int64, error) {
+	res, err := d.db.ExecContext(ctx, `DELETE FROM `+DedupTable+` WHERE processed_at < $1`, d.now().UTC().Add(-d.ttl))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// StartCleanup runs Cleanup every interval until ctx is done. Errors are
+// left for the next run to retry.
+func (d *Dedup) StartCleanup(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				d.Cleanup(ctx)
+			}
+		}
+	}()
+}
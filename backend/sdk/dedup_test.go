@@ -0,0 +1,91 @@
+package sdk
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/infrasage/payflow/internal/dbtest"
+)
+
+// fakeEventStore is a DedupTable. Inserts made in a database transaction
+// are seen once it commits.
+type fakeEventStore struct {
+	events  map[string]time.Time
+	pending map[string]time.Time
+}
+
+func (s *fakeEventStore) run(q dbtest.Query) (*dbtest.Rows, error) {
+	switch {
+	case q.HasPrefix("INSERT INTO " + DedupTable):
+		if _, ok := s.events[q.String(0)]; ok {
+			return dbtest.Affected(0), nil
+		}
+		s.pending[q.String(0)] = q.Args[1].(time.Time)
+		return dbtest.Affected(1), nil
+	case q.HasPrefix("DELETE FROM " + DedupTable):
+		var n int64
+		for id, at := range s.events {
+			if at.Before(q.Args[0].(time.Time)) {
+				delete(s.events, id)
+				n++
+			}
+		}
+		return dbtest.Affected(n), nil
+	}
+	return nil, dbtest.Unexpected(q)
+}
+
+func (s *fakeEventStore) begin() { s.pending = map[string]time.Time{} }
+
+func (s *fakeEventStore) commit() {
+	for id, at := range s.pending {
+		s.events[id] = at
+	}
+}
+
+func TestDedupProcessesOnce(t *testing.T) {
+	store := &fakeEventStore{events: map[string]time.Time{}}
+	db := dbtest.New(store.run).WithTx(dbtest.Tx{Begin: store.begin, Commit: store.commit}).Open(t)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	d := NewDedup(db, time.Hour)
+	d.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	body := []byte(`{"id":"evt-1","type":"transaction.created","data":{"id":"txn-1"}}`)
+	handled := 0
+	handle := func(tx *sql.Tx, e Event) error {
+		if e.Type != "transaction.created" || string(e.Data) != `{"id":"txn-1"}` {
+			t.Errorf("event %+v", e)
+		}
+		handled++
+		return nil
+	}
+
+	failing := func(*sql.Tx, Event) error { return errors.New("downstream unavailable") }
+	if ran, err := d.ProcessEvent(ctx, body, failing); ran || err == nil {
+		t.Fatalf("failed handler: ran %v, err %v", ran, err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := d.ProcessEvent(ctx, body, handle); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if handled != 1 {
+		t.Errorf("handled %d times after a failure and a redelivery, want once", handled)
+	}
+	if _, err := d.ProcessEvent(ctx, []byte(`{"type":"transaction.created"}`), handle); err == nil {
+		t.Error("event without an ID was processed")
+	}
+
+	now = now.Add(30 * time.Minute)
+	if n, err := d.Cleanup(ctx); n != 0 || err != nil {
+		t.Errorf("cleanup within ttl removed %d, err %v", n, err)
+	}
+	now = now.Add(time.Hour)
+	if n, err := d.Cleanup(ctx); n != 1 || err != nil {
+		t.Errorf("cleanup past ttl removed %d, err %v", n, err)
+	}
+}
@@ -0,0 +1,112 @@
+// Package sdk holds client helpers for the PayFlow API.
+//
+// Machine clients with a signing-enabled API key can sign each request
+// instead of sending the key: the signature is an HMAC-SHA256, under the
+// key's signing secret, of the method, path and query, timestamp, a nonce
+// and body hash. The server verifies the same canonical string, so a request
+// can't be altered in transit, and remembers each key's nonces for as long
+// as their timestamp is accepted, so it can't be replayed either.
+package sdk
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers carrying a request signature.
+const (
+	HeaderKeyID     = "X-PayFlow-Key-Id"
+	HeaderTimestamp = "X-PayFlow-Timestamp"
+	HeaderNonce     = "X-PayFlow-Nonce"
+	HeaderSignature = "X-PayFlow-Signature"
+)
+
+// CanonicalString is what gets signed: method, path with its raw query,
+// unix timestamp, nonce and the hex SHA-256 of the body, one per line.
+func CanonicalString(method, path, rawQuery string, timestamp int64, nonce string, body []byte) string {
+	if rawQuery != "" {
+		path += "?" + rawQuery
+	}
+	sum := sha256.Sum256(body)
+	return strings.Join([]string{
+		strings.ToUpper(method),
+		path,
+		strconv.FormatInt(timestamp, 10),
+		nonce,
+		hex.EncodeToString(sum[:]),
+	}, "\n")
+}
+
+// NewNonce returns a random nonce for one signed request.
+func NewNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Signature is the hex HMAC-SHA256 of the canonical string under secret.
+func Signature(secret, canonical string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(canonical))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignRequest adds signature headers to req, with a fresh nonce, reading and
+// restoring its body.
+func SignRequest(req *http.Request, keyID, secret string, now time.Time) error {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	nonce, err := NewNonce()
+	if err != nil {
+		return err
+	}
+	ts := now.Unix()
+	canonical := CanonicalString(req.Method, req.URL.EscapedPath(), req.URL.RawQuery, ts, nonce, body)
+	req.Header.Set(HeaderKeyID, keyID)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(ts, 10))
+	req.Header.Set(HeaderNonce, nonce)
+	req.Header.Set(HeaderSignature, Signature(secret, canonical))
+	return nil
+}
+
+// Signer is an http.RoundTripper that signs every request it sends.
+type Signer struct {
+	KeyID  string
+	Secret string
+	// Base sends the signed request; http.DefaultTransport when nil.
+	Base http.RoundTripper
+}
+
+// NewClient returns an http.Client whose requests are signed with the key.
+func NewClient(keyID, secret string) *http.Client {
+	return &http.Client{Transport: &Signer{KeyID: keyID, Secret: secret}, Timeout: 30 * time.Second}
+}
+
+func (s *Signer) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	if err := SignRequest(req, s.KeyID, s.Secret, time.Now()); err != nil {
+		return nil, err
+	}
+	base := s.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
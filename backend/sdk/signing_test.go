@@ -0,0 +1,37 @@
+package sdk
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignRequestCoversNonce(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	req := httptest.NewRequest("POST", "/api/transactions?dry_run=true", strings.NewReader(`{"amount":1}`))
+	if err := SignRequest(req, "k1", "secret", now); err != nil {
+		t.Fatal(err)
+	}
+	nonce := req.Header.Get(HeaderNonce)
+	if len(nonce) != 32 {
+		t.Fatalf("nonce %q, want 32 hex characters", nonce)
+	}
+	if got := req.Header.Get(HeaderTimestamp); got != strconv.FormatInt(now.Unix(), 10) {
+		t.Errorf("timestamp %q", got)
+	}
+	canonical := CanonicalString("POST", "/api/transactions", "dry_run=true", now.Unix(), nonce, []byte(`{"amount":1}`))
+	if lines := strings.Split(canonical, "\n"); len(lines) != 5 || lines[3] != nonce {
+		t.Fatalf("canonical string %q doesn't carry the nonce", canonical)
+	}
+	if got, want := req.Header.Get(HeaderSignature), Signature("secret", canonical); got != want {
+		t.Errorf("signature %s, want %s", got, want)
+	}
+
+	again := httptest.NewRequest("POST", "/api/transactions?dry_run=true", strings.NewReader(`{"amount":1}`))
+	SignRequest(again, "k1", "secret", now)
+	if again.Header.Get(HeaderNonce) == nonce {
+		t.Error("two signatures share a nonce")
+	}
+}
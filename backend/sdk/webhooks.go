@@ -0,0 +1,78 @@
+package sdk
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers on a webhook delivery, alongside HeaderTimestamp and
+// HeaderSignature.
+const (
+	HeaderWebhookEvent     = "X-PayFlow-Event"
+	HeaderWebhookDelivery  = "X-PayFlow-Delivery"
+	HeaderWebhookBatchSize = "X-PayFlow-Batch-Size"
+	HeaderWebhookKeyID     = "X-PayFlow-Webhook-Key-Id"
+)
+
+// WebhookEventBatch is the X-PayFlow-Event of a batched delivery, whose body
+// is a JSON array of events. HeaderWebhookDelivery then carries the batch
+// ID, which the signature covers like a delivery ID.
+const WebhookEventBatch = "batch"
+
+// WebhookBatchAck is what a receiver may answer a batch with, alongside a
+// 2xx status, to have some of its events sent again. Failed lists their
+// event IDs; every other event in the batch counts as delivered.
+type WebhookBatchAck struct {
+	Failed []string `json:"failed"`
+}
+
+// ErrInvalidWebhook means a delivery's signature didn't verify or its
+// timestamp is outside the allowed skew.
+var ErrInvalidWebhook = errors.New("invalid webhook signature")
+
+// WebhookCanonicalString is what a webhook delivery's signature covers:
+// delivery ID, unix timestamp and the hex SHA-256 of the body, one per line.
+func WebhookCanonicalString(deliveryID string, timestamp int64, body []byte) string {
+	sum := sha256.Sum256(body)
+	return strings.Join([]string{
+		deliveryID,
+		strconv.FormatInt(timestamp, 10),
+		hex.EncodeToString(sum[:]),
+	}, "\n")
+}
+
+// VerifyWebhookKeys checks a delivery like VerifyWebhook, against the secret
+// of secrets named by its HeaderWebhookKeyID. While a webhook's secret is
+// being rotated, keep both versions in secrets: deliveries switch to the new
+// one once the rotation's grace period is over.
+func VerifyWebhookKeys(header http.Header, body []byte, secrets map[string]string, tolerance time.Duration, now time.Time) error {
+	secret, ok := secrets[header.Get(HeaderWebhookKeyID)]
+	if !ok {
+		return ErrInvalidWebhook
+	}
+	return VerifyWebhook(header, body, secret, tolerance, now)
+}
+
+// VerifyWebhook checks a delivery received with header and body against the
+// webhook's secret. Deliveries signed more than tolerance away from now are
+// rejected, so a captured one can't be replayed later.
+func VerifyWebhook(header http.Header, body []byte, secret string, tolerance time.Duration, now time.Time) error {
+	ts, err := strconv.ParseInt(header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		return ErrInvalidWebhook
+	}
+	if skew := now.Sub(time.Unix(ts, 0)); skew > tolerance || skew < -tolerance {
+		return ErrInvalidWebhook
+	}
+	want := Signature(secret, WebhookCanonicalString(header.Get(HeaderWebhookDelivery), ts, body))
+	if !hmac.Equal([]byte(want), []byte(header.Get(HeaderSignature))) {
+		return ErrInvalidWebhook
+	}
+	return nil
+}
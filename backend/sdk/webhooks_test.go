@@ -0,0 +1,38 @@
+package sdk
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerifyWebhookKeys(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"id":"evt-1"}`)
+	secrets := map[string]string{"1": "old-secret", "2": "new-secret"}
+	tests := []struct {
+		name   string
+		keyID  string
+		secret string
+		ok     bool
+	}{
+		{"current key", "1", "old-secret", true},
+		{"rotated key", "2", "new-secret", true},
+		{"signed with another key's secret", "2", "old-secret", false},
+		{"unknown key", "3", "new-secret", false},
+		{"no key ID", "", "old-secret", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			header.Set(HeaderWebhookDelivery, "dlv-1")
+			header.Set(HeaderTimestamp, strconv.FormatInt(now.Unix(), 10))
+			header.Set(HeaderWebhookKeyID, tt.keyID)
+			header.Set(HeaderSignature, Signature(tt.secret, WebhookCanonicalString("dlv-1", now.Unix(), body)))
+			if err := VerifyWebhookKeys(header, body, secrets, time.Minute, now); (err == nil) != tt.ok {
+				t.Errorf("err = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
@@ -1,4 +1,4 @@
-import { useState, useEffect, useCallback } from 'react';
+import { useState, useEffect, useCallback, useRef } from 'react';
 import { 
   DollarSign, 
   CreditCard, 
@@ -19,7 +19,7 @@ import {
   Download,
   BarChart3
 } from 'lucide-react';
-import { LineChart, Line, XAxis, YAxis, CartesianGrid, Tooltip, ResponsiveContainer } from 'recharts';
+import { LineChart, Line, BarChart, Bar, XAxis, YAxis, CartesianGrid, Tooltip, ResponsiveContainer } from 'recharts';
 
 interface Transaction {
   id: string;
@@ -31,13 +31,35 @@ interface Transaction {
   created_at: string;
 }
 
+interface Money {
+  currency: string;
+  minor_units: number;
+  amount: string;
+  formatted: string;
+  locale: string;
+}
+
 interface Stats {
-  revenue: number;
+  revenue: Money;
   transactions: number;
   success_rate: number;
   avg_latency: number;
 }
 
+interface AmountBucket {
+  min: number;
+  max: number | null;
+  count: number;
+  round: number;
+}
+
+interface AmountDistribution {
+  currency: string;
+  total: number;
+  round_share: number;
+  buckets: AmountBucket[];
+}
+
 interface HealthStatus {
   status: string;
   version: string;
@@ -46,65 +68,109 @@ interface HealthStatus {
 }
 
 interface Config {
+  cache_mode: string;
   cache_max_size: string;
   cache_ttl: number;
   db_pool_size: number;
   rate_limit_rps: number;
   log_level: string;
   feature_new_cache: boolean;
-  bug_injection: {
+  // Only returned to admin callers (null from GraphQL otherwise).
+  bug_injection?: {
     oom: boolean;
     latency_ms: number;
     error_rate: number;
     cpu_burn: boolean;
     panic: boolean;
     db_timeout: boolean;
-  };
+  } | null;
+}
+
+interface FraudAlert {
+  id: number;
+  transaction_id: string;
+  score: number;
+  decision: string;
+  rule_set_version: string;
+  flagged_at: string;
+  status: 'open' | 'acknowledged' | 'resolved';
+}
+
+interface ChaosStatus {
+  active: boolean;
+  oom: boolean;
+  latency_ms: number;
+  error_rate: number;
+  cpu_burn: boolean;
+  panic: boolean;
+  db_timeout: boolean;
+  feature_new_cache: boolean;
 }
 
 const API_BASE = '/api';
 
 function App() {
   const [page, setPage] = useState<'dashboard' | 'payment' | 'settings'>('dashboard');
-  const [stats, setStats] = useState<Stats>({ revenue: 0, transactions: 0, success_rate: 0, avg_latency: 0 });
+  const [stats, setStats] = useState<Stats>({ revenue: { currency: 'USD', minor_units: 0, amount: '0.00', formatted: '$0.00', locale: 'en-US' }, transactions: 0, success_rate: 0, avg_latency: 0 });
   const [transactions, setTransactions] = useState<Transaction[]>([]);
+  const [distribution, setDistribution] = useState<AmountDistribution | null>(null);
   const [config, setConfig] = useState<Config | null>(null);
+  // Admin-only; null for other callers.
+  const [fraudAlerts, setFraudAlerts] = useState<FraudAlert[] | null>(null);
+  const [chaos, setChaos] = useState<ChaosStatus | null>(null);
   const [loading, setLoading] = useState(false);
   const [chartData, setChartData] = useState<{ time: string; value: number }[]>([]);
   const [health, setHealth] = useState<HealthStatus | null>(null);
   const [filter, setFilter] = useState<'all' | 'success' | 'failed'>('all');
+  const [live, setLive] = useState(false);
+  const statsRefresh = useRef<ReturnType<typeof setTimeout>>();
 
-  const fetchStats = useCallback(async () => {
+  const fetchDistribution = useCallback(async () => {
     try {
-      const res = await fetch(`${API_BASE}/stats`);
+      const res = await fetch(`${API_BASE}/stats/amount-distribution`);
       if (res.ok) {
         const data = await res.json();
-        setStats(data);
+        setDistribution(data);
       }
     } catch (err) {
-      console.error('Failed to fetch stats:', err);
+      console.error('Failed to fetch amount distribution:', err);
     }
   }, []);
 
-  const fetchTransactions = useCallback(async () => {
+  // One round-trip for stats, recent transactions, fraud alerts and chaos
+  // status. A section that failed is null and keeps its last value.
+  const fetchDashboard = useCallback(async () => {
     try {
-      const res = await fetch(`${API_BASE}/transactions`);
+      const res = await fetch(`${API_BASE}/dashboard`);
       if (res.ok) {
         const data = await res.json();
-        setTransactions(data || []);
+        if (data.stats) setStats(data.stats);
+        if (data.transactions) setTransactions(data.transactions);
+        if (!data.sections.fraud_alerts?.error) setFraudAlerts(data.fraud_alerts);
+        setChaos(data.chaos);
       }
     } catch (err) {
-      console.error('Failed to fetch transactions:', err);
+      console.error('Failed to fetch dashboard:', err);
     }
   }, []);
 
+  const triageFraudAlert = useCallback(async (id: number, status: FraudAlert['status']) => {
+    try {
+      const res = await fetch(`${API_BASE}/fraud/alerts/${id}`, {
+        method: 'PATCH',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ status }),
+      });
+      if (res.ok) fetchDashboard();
+    } catch (err) {
+      console.error('Failed to triage fraud alert:', err);
+    }
+  }, [fetchDashboard]);
+
   const fetchConfig = useCallback(async () => {
     try {
       const res = await fetch(`${API_BASE}/config`);
-      if (res.ok) {
-        const data = await res.json();
-        setConfig(data);
-      }
+      if (res.ok) setConfig(await res.json());
     } catch (err) {
       console.error('Failed to fetch config:', err);
     }
@@ -123,9 +189,9 @@ function App() {
   }, []);
 
   useEffect(() => {
-    fetchStats();
-    fetchTransactions();
+    fetchDashboard();
     fetchConfig();
+    fetchDistribution();
     fetchHealth();
 
     // Generate mock chart data
@@ -137,16 +203,65 @@ function App() {
       });
     }
     setChartData(data.reverse());
+  }, [fetchDashboard, fetchConfig, fetchDistribution, fetchHealth]);
 
-    // Auto refresh
+  // Auto refresh. The live feed keeps transactions current, so poll slowly
+  // while it is connected.
+  useEffect(() => {
     const interval = setInterval(() => {
-      fetchStats();
-      fetchTransactions();
+      fetchDashboard();
+      fetchDistribution();
       fetchHealth();
-    }, 5000);
+    }, live ? 30000 : 5000);
 
     return () => clearInterval(interval);
-  }, [fetchStats, fetchTransactions, fetchConfig, fetchHealth]);
+  }, [live, fetchDashboard, fetchDistribution, fetchHealth]);
+
+  // Live transaction feed, reconnecting a few seconds after it drops. Stats
+  // are refetched at most once a second while transactions stream in.
+  useEffect(() => {
+    let ws: WebSocket | null = null;
+    let retry: ReturnType<typeof setTimeout>;
+    let closed = false;
+
+    const refreshSoon = () => {
+      if (statsRefresh.current) return;
+      statsRefresh.current = setTimeout(() => {
+        statsRefresh.current = undefined;
+        fetchDashboard();
+      }, 1000);
+    };
+
+    const connect = () => {
+      const scheme = window.location.protocol === 'https:' ? 'wss' : 'ws';
+      ws = new WebSocket(`${scheme}://${window.location.host}${API_BASE}/ws/transactions`);
+      ws.onopen = () => setLive(true);
+      ws.onmessage = (event) => {
+        const msg = JSON.parse(event.data);
+        if (msg.type === 'transaction.created') {
+          setTransactions((prev) => [msg.transaction, ...prev.filter((t) => t.id !== msg.transaction.id)].slice(0, 50));
+          refreshSoon();
+        } else if (msg.type === 'transaction.status_changed') {
+          setTransactions((prev) => prev.map((t) => (t.id === msg.transaction_id ? { ...t, status: msg.status } : t)));
+          refreshSoon();
+        } else if (msg.type === 'resync') {
+          fetchDashboard();
+        }
+      };
+      ws.onclose = () => {
+        setLive(false);
+        if (!closed) retry = setTimeout(connect, 3000);
+      };
+    };
+    connect();
+
+    return () => {
+      closed = true;
+      clearTimeout(retry);
+      clearTimeout(statsRefresh.current);
+      ws?.close();
+    };
+  }, [fetchDashboard]);
 
   const formatCurrency = (amount: number) => {
     return new Intl.NumberFormat('en-US', {
@@ -219,17 +334,21 @@ function App() {
             stats={stats} 
             transactions={transactions} 
             chartData={chartData}
+            distribution={distribution}
             formatCurrency={formatCurrency}
             formatTime={formatTime}
-            onRefresh={() => { fetchStats(); fetchTransactions(); fetchHealth(); }}
+            onRefresh={() => { fetchDashboard(); fetchDistribution(); fetchHealth(); }}
             health={health}
+            fraudAlerts={fraudAlerts}
+            onTriage={triageFraudAlert}
+            chaos={chaos}
             filter={filter}
             setFilter={setFilter}
           />
         )}
         {page === 'payment' && (
           <PaymentForm 
-            onSuccess={() => { fetchStats(); fetchTransactions(); }}
+            onSuccess={() => { fetchDashboard(); fetchDistribution(); }}
             loading={loading}
             setLoading={setLoading}
           />
@@ -246,15 +365,19 @@ interface DashboardProps {
   stats: Stats;
   transactions: Transaction[];
   chartData: { time: string; value: number }[];
+  distribution: AmountDistribution | null;
   formatCurrency: (amount: number) => string;
   formatTime: (dateString: string) => string;
   onRefresh: () => void;
   health: HealthStatus | null;
+  fraudAlerts: FraudAlert[] | null;
+  onTriage: (id: number, status: FraudAlert['status']) => void;
+  chaos: ChaosStatus | null;
   filter: 'all' | 'success' | 'failed';
   setFilter: (filter: 'all' | 'success' | 'failed') => void;
 }
 
-function Dashboard({ stats, transactions, chartData, formatCurrency, formatTime, onRefresh, health, filter, setFilter }: DashboardProps) {
+function Dashboard({ stats, transactions, chartData, distribution, formatCurrency, formatTime, onRefresh, health, fraudAlerts, onTriage, chaos, filter, setFilter }: DashboardProps) {
   const filteredTransactions = transactions.filter(txn => {
     if (filter === 'all') return true;
     if (filter === 'success') return txn.status === 'success';
@@ -307,11 +430,30 @@ function Dashboard({ stats, transactions, chartData, formatCurrency, formatTime,
         </div>
       )}
 
+      {/* Chaos Banner */}
+      {chaos?.active && (
+        <div className="rounded-xl p-4 border bg-yellow-500/10 border-yellow-500/30 flex items-center space-x-3">
+          <Zap className="w-5 h-5 text-yellow-400" />
+          <span className="text-yellow-400">Chaos active:</span>
+          <span className="text-gray-300 text-sm">
+            {[
+              chaos.oom && 'OOM',
+              chaos.latency_ms > 0 && `+${chaos.latency_ms}ms latency`,
+              chaos.error_rate > 0 && `${(chaos.error_rate * 100).toFixed(0)}% errors`,
+              chaos.cpu_burn && 'CPU burn',
+              chaos.panic && 'panics',
+              chaos.db_timeout && 'DB timeouts',
+              chaos.feature_new_cache && 'new cache',
+            ].filter(Boolean).join(', ')}
+          </span>
+        </div>
+      )}
+
       {/* Stats Cards */}
       <div className="grid grid-cols-1 md:grid-cols-2 lg:grid-cols-4 gap-4">
         <StatCard
           title="Revenue"
-          value={formatCurrency(stats.revenue)}
+          value={stats.revenue.formatted}
           icon={<DollarSign className="w-5 h-5" />}
           color="from-green-500 to-emerald-600"
         />
@@ -371,21 +513,86 @@ function Dashboard({ stats, transactions, chartData, formatCurrency, formatTime,
         </div>
       </div>
 
+      {/* Fraud Alerts */}
+      {fraudAlerts && fraudAlerts.length > 0 && (
+        <div className="bg-gray-800 rounded-xl p-6 border border-gray-700">
+          <h2 className="text-lg font-semibold mb-4 flex items-center space-x-2">
+            <AlertTriangle className="w-5 h-5 text-red-400" />
+            <span>Fraud Alerts</span>
+          </h2>
+          <div className="space-y-2">
+            {fraudAlerts.map(a => (
+              <div key={a.id} className="flex justify-between items-center text-sm">
+                <span className="font-mono text-gray-300">{a.transaction_id.slice(0, 8)}</span>
+                <span className={a.decision === 'block' ? 'text-red-400' : 'text-yellow-400'}>{a.decision}</span>
+                <span className="text-gray-400">score {a.score}</span>
+                <span className="text-gray-500">{formatTime(a.flagged_at)}</span>
+                {a.status === 'resolved' ? (
+                  <span className="text-green-400">resolved</span>
+                ) : (
+                  <span className="space-x-2">
+                    {a.status === 'open' && (
+                      <button onClick={() => onTriage(a.id, 'acknowledged')} className="text-gray-400 hover:text-white">Acknowledge</button>
+                    )}
+                    <button onClick={() => onTriage(a.id, 'resolved')} className="text-gray-400 hover:text-white">Resolve</button>
+                  </span>
+                )}
+              </div>
+            ))}
+          </div>
+        </div>
+      )}
+
+      {/* Amount Distribution */}
+      {distribution && (
+        <div className="bg-gray-800 rounded-xl p-6 border border-gray-700">
+          <div className="flex justify-between items-center mb-6">
+            <h2 className="text-lg font-semibold">Amount Distribution</h2>
+            <span className={`text-sm ${distribution.round_share > 0.25 ? 'text-yellow-400' : 'text-gray-400'}`}>
+              {(distribution.round_share * 100).toFixed(1)}% round amounts
+            </span>
+          </div>
+          <div className="h-48">
+            <ResponsiveContainer width="100%" height="100%">
+              <BarChart data={distribution.buckets.map(b => ({
+                range: b.max === null ? `${b.min}+` : `${b.min}-${b.max}`,
+                count: b.count,
+              }))}>
+                <CartesianGrid strokeDasharray="3 3" stroke="#374151" />
+                <XAxis dataKey="range" stroke="#9CA3AF" fontSize={12} />
+                <YAxis stroke="#9CA3AF" fontSize={12} allowDecimals={false} />
+                <Tooltip
+                  contentStyle={{
+                    backgroundColor: '#1F2937',
+                    border: '1px solid #374151',
+                    borderRadius: '8px',
+                  }}
+                />
+                <Bar dataKey="count" fill="#06B6D4" />
+              </BarChart>
+            </ResponsiveContainer>
+          </div>
+        </div>
+      )}
+
       {/* Quick Actions */}
       <div className="grid grid-cols-2 md:grid-cols-4 gap-4">
         <QuickActionCard
           icon={<Zap className="w-5 h-5" />}
           label="Generate Load"
-          onClick={() => {
-            for (let i = 0; i < 5; i++) {
+          onClick={async () => {
+            const res = await fetch(`${API_BASE}/seed/sample?count=5`);
+            if (!res.ok) return;
+            const { transactions: samples } = await res.json();
+            for (const t of samples) {
               fetch(`${API_BASE}/transactions`, {
                 method: 'POST',
                 headers: { 'Content-Type': 'application/json' },
                 body: JSON.stringify({
-                  from_account: `ACC-${1000 + Math.floor(Math.random() * 10)}`,
-                  to_account: `ACC-${2000 + Math.floor(Math.random() * 10)}`,
-                  amount: Math.floor(Math.random() * 1000) + 10,
-                  description: 'Load test transaction'
+                  from_account: t.from_account,
+                  to_account: t.to_account,
+                  amount: t.amount,
+                  description: t.description
                 })
               });
             }
@@ -694,6 +901,7 @@ interface SettingsPageProps {
 }
 
 function SettingsPage({ config, onRefresh }: SettingsPageProps) {
+  const bug = config?.bug_injection;
   if (!config) {
     return (
       <div className="text-center py-12">
@@ -721,44 +929,49 @@ function SettingsPage({ config, onRefresh }: SettingsPageProps) {
         <p className="text-sm text-gray-400 mb-4">
           These settings are controlled via environment variables/ConfigMap.
         </p>
-        <div className="space-y-3">
-          <SettingRow
-            label="OOM Simulation"
-            value={config.bug_injection.oom ? 'Enabled' : 'Disabled'}
-            status={config.bug_injection.oom ? 'danger' : 'ok'}
-          />
-          <SettingRow
-            label="Latency Injection"
-            value={config.bug_injection.latency_ms > 0 ? `${config.bug_injection.latency_ms}ms` : 'Disabled'}
-            status={config.bug_injection.latency_ms > 0 ? 'warning' : 'ok'}
-          />
-          <SettingRow
-            label="Error Rate"
-            value={config.bug_injection.error_rate > 0 ? `${(config.bug_injection.error_rate * 100).toFixed(0)}%` : 'Disabled'}
-            status={config.bug_injection.error_rate > 0 ? 'warning' : 'ok'}
-          />
-          <SettingRow
-            label="CPU Burn"
-            value={config.bug_injection.cpu_burn ? 'Enabled' : 'Disabled'}
-            status={config.bug_injection.cpu_burn ? 'danger' : 'ok'}
-          />
-          <SettingRow
-            label="Panic Injection"
-            value={config.bug_injection.panic ? 'Enabled' : 'Disabled'}
-            status={config.bug_injection.panic ? 'danger' : 'ok'}
-          />
-          <SettingRow
-            label="DB Timeout"
-            value={config.bug_injection.db_timeout ? 'Enabled' : 'Disabled'}
-            status={config.bug_injection.db_timeout ? 'danger' : 'ok'}
-          />
-        </div>
+        {bug ? (
+          <div className="space-y-3">
+            <SettingRow
+              label="OOM Simulation"
+              value={bug.oom ? 'Enabled' : 'Disabled'}
+              status={bug.oom ? 'danger' : 'ok'}
+            />
+            <SettingRow
+              label="Latency Injection"
+              value={bug.latency_ms > 0 ? `${bug.latency_ms}ms` : 'Disabled'}
+              status={bug.latency_ms > 0 ? 'warning' : 'ok'}
+            />
+            <SettingRow
+              label="Error Rate"
+              value={bug.error_rate > 0 ? `${(bug.error_rate * 100).toFixed(0)}%` : 'Disabled'}
+              status={bug.error_rate > 0 ? 'warning' : 'ok'}
+            />
+            <SettingRow
+              label="CPU Burn"
+              value={bug.cpu_burn ? 'Enabled' : 'Disabled'}
+              status={bug.cpu_burn ? 'danger' : 'ok'}
+            />
+            <SettingRow
+              label="Panic Injection"
+              value={bug.panic ? 'Enabled' : 'Disabled'}
+              status={bug.panic ? 'danger' : 'ok'}
+            />
+            <SettingRow
+              label="DB Timeout"
+              value={bug.db_timeout ? 'Enabled' : 'Disabled'}
+              status={bug.db_timeout ? 'danger' : 'ok'}
+            />
+          </div>
+        ) : (
+          <p className="text-sm text-gray-500">Bug injection settings are only visible to admins.</p>
+        )}
       </div>
 
       {/* Cache Settings */}
       <div className="bg-gray-800 rounded-xl p-6 border border-gray-700">
         <h3 className="text-lg font-semibold mb-4">Cache Settings</h3>
         <div className="space-y-3">
+          <SettingRow label="Mode" value={config.cache_mode} status={config.cache_mode === 'redis' ? 'ok' : 'warning'} />
           <SettingRow label="Max Size" value={config.cache_max_size} />
           <SettingRow label="TTL" value={`${config.cache_ttl}s`} />
           <SettingRow